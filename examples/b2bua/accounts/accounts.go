@@ -0,0 +1,355 @@
+package accounts
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hash algorithm of the stored passwords.
+type Hash string
+
+const (
+	// Argon2id the memory-hard hash of RFC 9106, in the PHC string format.
+	Argon2id Hash = "argon2id"
+	// Bcrypt .
+	Bcrypt Hash = "bcrypt"
+)
+
+const (
+	// DefaultRealm of the HA1, the realm of the B2BUA challenges.
+	DefaultRealm = "b2bua"
+	// DefaultMinLength of the passwords.
+	DefaultMinLength = 8
+	// DefaultTemporaryExpiry of the temporary passwords.
+	DefaultTemporaryExpiry = 24 * time.Hour
+	// temporaryLength bytes of randomness of a temporary password.
+	temporaryLength = 12
+
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+var (
+	logger log.Logger
+
+	// ErrNotFound the account doesn't exist.
+	ErrNotFound = errors.New("accounts: account not found")
+	// ErrBadPassword the password doesn't match.
+	ErrBadPassword = errors.New("accounts: bad password")
+	// ErrExpired the temporary password has expired.
+	ErrExpired = errors.New("accounts: password expired")
+)
+
+func init() {
+	logger = utils.NewLogrusLogger(log.InfoLevel, "Accounts", nil)
+}
+
+// Account the credentials of a user, the password itself is never stored: its salted hash verifies
+// it on the self-service API, and its HA1 (MD5 of user:realm:password) the SIP digest.
+type Account struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	HA1          string `json:"ha1"`
+	// Expires the temporary password, zero for a permanent one. The user must change it before.
+	Expires time.Time `json:"expires,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// Temporary the password is a temporary one.
+func (a *Account) Temporary() bool {
+	return !a.Expires.IsZero()
+}
+
+// Options .
+type Options struct {
+	// Realm of the HA1, DefaultRealm if empty.
+	Realm string
+	// Hash of the new passwords, Argon2id if empty. Both are verified.
+	Hash Hash
+	// MinLength of the passwords, DefaultMinLength if zero.
+	MinLength int
+	// Path of the JSON file the accounts are loaded from and saved to, in memory only if empty.
+	Path string
+	// Attempts self-service password changes per minute of an address, and of a username,
+	// DefaultAttempts if zero.
+	Attempts int
+}
+
+// Store the accounts, a RequestCredentialCallback of the B2BUA with Credential.
+type Store struct {
+	mutex    sync.RWMutex
+	options  Options
+	accounts map[string]*Account
+	attempts *attemptLimiter
+}
+
+// NewStore the store of options, loaded from its Path if any.
+func NewStore(options Options) (*Store, error) {
+	if len(options.Realm) == 0 {
+		options.Realm = DefaultRealm
+	}
+	switch options.Hash {
+	case "":
+		options.Hash = Argon2id
+	case Argon2id, Bcrypt:
+	default:
+		return nil, fmt.Errorf("accounts: unknown hash %q", options.Hash)
+	}
+	if options.MinLength <= 0 {
+		options.MinLength = DefaultMinLength
+	}
+	if options.Attempts <= 0 {
+		options.Attempts = DefaultAttempts
+	}
+	s := &Store{
+		options:  options,
+		accounts: make(map[string]*Account),
+		attempts: newAttemptLimiter(options.Attempts),
+	}
+	if len(options.Path) > 0 {
+		data, err := ioutil.ReadFile(options.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(data) > 0 {
+			accounts := []*Account{}
+			if err := json.Unmarshal(data, &accounts); err != nil {
+				return nil, fmt.Errorf("accounts: %s: %v", options.Path, err)
+			}
+			for _, account := range accounts {
+				s.accounts[account.Username] = account
+			}
+		}
+	}
+	return s, nil
+}
+
+// Usernames of the accounts, sorted.
+func (s *Store) Usernames() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	names := make([]string, 0, len(s.accounts))
+	for name := range s.accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get a copy of the account of username.
+func (s *Store) Get(username string) (Account, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	account, found := s.accounts[username]
+	if !found {
+		return Account{}, false
+	}
+	return *account, true
+}
+
+// SetPassword create the account of username, or replace its password, e.g. from a provisioning portal.
+func (s *Store) SetPassword(username string, password string) error {
+	return s.setPassword(username, password, time.Time{})
+}
+
+// SetTemporaryPassword generate a temporary password of username, valid for ttl, DefaultTemporaryExpiry
+// if zero, e.g. for a new user or a reset. The user changes it with ChangePassword before it expires.
+func (s *Store) SetTemporaryPassword(username string, ttl time.Duration) (string, time.Time, error) {
+	if ttl <= 0 {
+		ttl = DefaultTemporaryExpiry
+	}
+	random := make([]byte, temporaryLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", time.Time{}, err
+	}
+	password := base64.RawURLEncoding.EncodeToString(random)
+	expires := time.Now().Add(ttl)
+	if err := s.setPassword(username, password, expires); err != nil {
+		return "", time.Time{}, err
+	}
+	return password, expires, nil
+}
+
+// ChangePassword replace the password of username, permanent or temporary, after verifying it.
+func (s *Store) ChangePassword(username string, password string, newPassword string) error {
+	if err := s.Verify(username, password); err != nil {
+		return err
+	}
+	if newPassword == password {
+		return fmt.Errorf("accounts: the new password must differ")
+	}
+	return s.setPassword(username, newPassword, time.Time{})
+}
+
+func (s *Store) setPassword(username string, password string, expires time.Time) error {
+	if len(username) == 0 || strings.ContainsAny(username, ":@ ") {
+		return fmt.Errorf("accounts: invalid username %q", username)
+	}
+	if len(password) < s.options.MinLength {
+		return fmt.Errorf("accounts: the password must have at least %d characters", s.options.MinLength)
+	}
+	hash, err := hashPassword(s.options.Hash, password)
+	if err != nil {
+		return err
+	}
+	account := &Account{
+		Username:     username,
+		PasswordHash: hash,
+		HA1:          md5Hex(username + ":" + s.options.Realm + ":" + password),
+		Expires:      expires,
+		Updated:      time.Now(),
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	previous := s.accounts[username]
+	s.accounts[username] = account
+	if err := s.save(); err != nil {
+		if previous != nil {
+			s.accounts[username] = previous
+		} else {
+			delete(s.accounts, username)
+		}
+		return err
+	}
+	logger.Infof("Password of %s set, temporary: %v", username, account.Temporary())
+	return nil
+}
+
+// Remove the account of username.
+func (s *Store) Remove(username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	account, found := s.accounts[username]
+	if !found {
+		return ErrNotFound
+	}
+	delete(s.accounts, username)
+	if err := s.save(); err != nil {
+		s.accounts[username] = account
+		return err
+	}
+	return nil
+}
+
+// Verify the password of username, ErrExpired once its temporary password has expired.
+func (s *Store) Verify(username string, password string) error {
+	account, found := s.Get(username)
+	if !found {
+		// Same cost as a known user.
+		hashPassword(s.options.Hash, password)
+		return ErrNotFound
+	}
+	if !verifyPassword(account.PasswordHash, password) {
+		return ErrBadPassword
+	}
+	if account.Temporary() && time.Now().After(account.Expires) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Credential the HA1 of username for the digest authentication, a RequestCredentialCallback.
+func (s *Store) Credential(username string) (string, string, error) {
+	account, found := s.Get(username)
+	if !found {
+		return "", "", ErrNotFound
+	}
+	if account.Temporary() && time.Now().After(account.Expires) {
+		return "", "", ErrExpired
+	}
+	return "", account.HA1, nil
+}
+
+// save write the accounts to the Path, atomically, locked.
+func (s *Store) save() error {
+	if len(s.options.Path) == 0 {
+		return nil
+	}
+	accounts := make([]*Account, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Username < accounts[j].Username })
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.options.Path), 0700); err != nil {
+		return err
+	}
+	tmp := s.options.Path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.options.Path)
+}
+
+// hashPassword the salted hash of password.
+func hashPassword(hash Hash, password string) (string, error) {
+	if hash == Bcrypt {
+		encoded, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(encoded), err
+	}
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPassword password matches the encoded hash, argon2id or bcrypt.
+func verifyPassword(encoded string, password string) bool {
+	if !strings.HasPrefix(encoded, "$argon2id$") {
+		return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+	}
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false
+	}
+	var version, memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	computed := argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1
+}
+
+func md5Hex(data string) string {
+	sum := md5.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,155 @@
+package accounts
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// passwordChange the body of a self-service password change.
+type passwordChange struct {
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	NewPassword string `json:"new_password"`
+}
+
+// accountInfo an account without its hashes.
+type accountInfo struct {
+	Username  string     `json:"username"`
+	Temporary bool       `json:"temporary"`
+	Expires   *time.Time `json:"expires,omitempty"`
+	Updated   time.Time  `json:"updated"`
+	Password  string     `json:"password,omitempty"`
+}
+
+func info(account Account) accountInfo {
+	ret := accountInfo{Username: account.Username, Temporary: account.Temporary(), Updated: account.Updated}
+	if account.Temporary() {
+		ret.Expires = &account.Expires
+	}
+	return ret
+}
+
+// tooManyAttempts answer 429 with the seconds before the next attempt.
+func tooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "too many attempts", http.StatusTooManyRequests)
+}
+
+// SelfServiceHandler the password change of the users, POST with the username, the current password,
+// permanent or temporary, and the new one as JSON. The attempts of an address, and those on a username,
+// are limited to Options.Attempts per minute against password guessing, the others are answered 429.
+func (s *Store) SelfServiceHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ok, wait := s.attempts.allow("addr:" + host); !ok {
+			tooManyAttempts(w, wait)
+			return
+		}
+		change := passwordChange{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&change); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ok, wait := s.attempts.allow("user:" + change.Username); !ok {
+			tooManyAttempts(w, wait)
+			return
+		}
+		err = s.ChangePassword(change.Username, change.Password, change.NewPassword)
+		switch err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case ErrNotFound, ErrBadPassword, ErrExpired:
+			// Don't tell which.
+			http.Error(w, "invalid credentials", http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+// AdminHandler the account management of a provisioning portal, by the user query parameter:
+// GET the account, or the list without it; PUT set the password of the JSON body {"password": ...};
+// POST issue a temporary password, valid for the expires query parameter, e.g. 1h, returned once;
+// DELETE remove the account.
+func (s *Store) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := r.URL.Query().Get("user")
+		if len(username) == 0 && r.Method != http.MethodGet {
+			http.Error(w, "missing user", http.StatusBadRequest)
+			return
+		}
+		var body interface{}
+		switch r.Method {
+		case http.MethodGet:
+			if len(username) == 0 {
+				body = s.Usernames()
+				break
+			}
+			account, found := s.Get(username)
+			if !found {
+				http.Error(w, ErrNotFound.Error(), http.StatusNotFound)
+				return
+			}
+			body = info(account)
+		case http.MethodPut:
+			request := struct {
+				Password string `json:"password"`
+			}{}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := s.SetPassword(username, request.Password); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			account, _ := s.Get(username)
+			body = info(account)
+		case http.MethodPost:
+			ttl := time.Duration(0)
+			if value := r.URL.Query().Get("expires"); len(value) > 0 {
+				var err error
+				if ttl, err = time.ParseDuration(value); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			password, _, err := s.SetTemporaryPassword(username, ttl)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			account, _ := s.Get(username)
+			ret := info(account)
+			ret.Password = password
+			body = ret
+		case http.MethodDelete:
+			if err := s.Remove(username); err == ErrNotFound {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(body)
+	})
+}
@@ -0,0 +1,41 @@
+package accounts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSelfServiceAttempts(t *testing.T) {
+	store, err := NewStore(Options{Attempts: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := store.SelfServiceHandler()
+	change := func(addr, username string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/accounts/password",
+			strings.NewReader(`{"username": "`+username+`", "password": "guess", "new_password": "secret123"}`))
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := change("192.0.2.1:1234", "100"); w.Code != http.StatusForbidden {
+			t.Fatalf("attempt %d: %d, want 403", i, w.Code)
+		}
+	}
+	w := change("192.0.2.1:1234", "200")
+	if w.Code != http.StatusTooManyRequests || len(w.Header().Get("Retry-After")) == 0 {
+		t.Errorf("3rd attempt of the address: %d, Retry-After %q, want 429 with a Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	// Another address is limited on the username.
+	if w := change("192.0.2.2:1234", "100"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("3rd attempt on the username: %d, want 429", w.Code)
+	}
+	if w := change("192.0.2.2:1234", "200"); w.Code != http.StatusForbidden {
+		t.Errorf("other address and username: %d, want 403", w.Code)
+	}
+}
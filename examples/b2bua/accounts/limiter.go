@@ -0,0 +1,72 @@
+package accounts
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAttempts password changes per minute of an address, and of a username.
+	DefaultAttempts = 5
+)
+
+// bucket the tokens of a key.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// attemptLimiter token buckets by key, e.g. the address or the username of the self-service requests,
+// refilled at rate tokens per second up to burst.
+type attemptLimiter struct {
+	rate  float64
+	burst float64
+
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+func newAttemptLimiter(perMinute int) *attemptLimiter {
+	return &attemptLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(perMinute),
+		buckets: make(map[string]*bucket),
+		pruned:  time.Now(),
+	}
+}
+
+// allow take a token of key, returns false with the time until the next one if there is none left.
+func (l *attemptLimiter) allow(key string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	l.prune(now)
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drop the buckets refilled since, at most once per refill period.
+func (l *attemptLimiter) prune(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.pruned) < refill {
+		return
+	}
+	l.pruned = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
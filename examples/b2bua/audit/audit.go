@@ -0,0 +1,238 @@
+package audit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Category of an audited action.
+type Category string
+
+const (
+	// Admin an action of the administration API.
+	Admin Category = "admin"
+	// Auth a failed authentication.
+	Auth Category = "auth"
+	// Registration a contact registered or removed.
+	Registration Category = "registration"
+	// Call a call attempt, rejected, or its end.
+	Call Category = "call"
+)
+
+// Format of the audit files.
+type Format string
+
+const (
+	// JSON one object per line.
+	JSON Format = "json"
+	// CSV a header line, then one entry per line.
+	CSV Format = "csv"
+)
+
+const (
+	// DefaultMaxSize an audit file is rotated beyond 100 MB.
+	DefaultMaxSize = 100 << 20
+	// DefaultMaxBackups rotated files kept.
+	DefaultMaxBackups = 30
+	// rotatedLayout the suffix of the rotated files.
+	rotatedLayout = "20060102T150405.000"
+)
+
+var (
+	// ErrClosed the log is closed.
+	ErrClosed = errors.New("audit: log closed")
+
+	header = []string{"time", "category", "action", "actor", "source", "target", "result", "detail"}
+)
+
+// Entry an audited action.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Category Category  `json:"category"`
+	// Action e.g. register, attempt, POST /trace.
+	Action string `json:"action"`
+	// Actor user or account behind the action, Source its address.
+	Actor  string `json:"actor,omitempty"`
+	Source string `json:"source,omitempty"`
+	// Target of the action, e.g. the called party or the AOR.
+	Target string `json:"target,omitempty"`
+	// Result e.g. the status code.
+	Result string `json:"result,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (e *Entry) record() []string {
+	return []string{e.Time.UTC().Format(time.RFC3339Nano), string(e.Category), e.Action, e.Actor, e.Source, e.Target, e.Result, e.Detail}
+}
+
+// Options .
+type Options struct {
+	// Path of the current file, the rotated ones get a timestamp suffix.
+	Path   string
+	Format Format
+	// MaxSize bytes of a file before it is rotated, DefaultMaxSize if zero.
+	MaxSize int64
+	// Interval rotate the file at least this often, e.g. 24h, never if zero.
+	Interval time.Duration
+	// MaxBackups rotated files kept, the oldest are removed, DefaultMaxBackups if zero, all if negative.
+	MaxBackups int
+}
+
+// Log appends the entries to rotating files, each entry is written before Write returns, so that
+// nothing is lost on a crash. Separate from the CDRs, it records who did what for compliance.
+type Log struct {
+	mutex   sync.Mutex
+	options Options
+	file    *os.File
+	csv     *csv.Writer
+	size    int64
+	opened  time.Time
+	closed  bool
+}
+
+// NewLog .
+func NewLog(options Options) (*Log, error) {
+	if len(options.Path) == 0 {
+		return nil, fmt.Errorf("audit: no path")
+	}
+	switch options.Format {
+	case "":
+		options.Format = JSON
+	case JSON, CSV:
+	default:
+		return nil, fmt.Errorf("audit: unknown format %q", options.Format)
+	}
+	if options.MaxSize <= 0 {
+		options.MaxSize = DefaultMaxSize
+	}
+	if options.MaxBackups == 0 {
+		options.MaxBackups = DefaultMaxBackups
+	}
+	l := &Log{options: options}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open the current file, appended to after a restart.
+func (l *Log) open() error {
+	if err := os.MkdirAll(filepath.Dir(l.options.Path), 0750); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.options.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	l.opened = info.ModTime()
+	if l.size == 0 {
+		l.opened = time.Now()
+	}
+	if l.options.Format == CSV {
+		l.csv = csv.NewWriter(writerFunc(l.write))
+		if l.size == 0 {
+			return l.writeCSV(header)
+		}
+	}
+	return nil
+}
+
+// write the bytes to the current file, counting its size.
+func (l *Log) write(p []byte) (int, error) {
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *Log) writeCSV(record []string) error {
+	l.csv.Write(record)
+	l.csv.Flush()
+	return l.csv.Error()
+}
+
+// Write append an entry, stamped now if its time is zero.
+func (l *Log) Write(entry *Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if l.size >= l.options.MaxSize || (l.options.Interval > 0 && time.Since(l.opened) >= l.options.Interval) {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if l.options.Format == CSV {
+		return l.writeCSV(entry.record())
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = l.write(append(line, '\n'))
+	return err
+}
+
+// rotate rename the current file with a timestamp suffix, remove the oldest ones beyond MaxBackups, and
+// open a new one.
+func (l *Log) rotate() error {
+	l.file.Close()
+	stamp := l.options.Path + "." + time.Now().Format(rotatedLayout)
+	rotated := stamp
+	// Never overwrite a file rotated within the same millisecond.
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = fmt.Sprintf("%s.%d", stamp, i)
+	}
+	if err := os.Rename(l.options.Path, rotated); err != nil {
+		// Keep appending to the current file.
+		l.open()
+		return err
+	}
+	if l.options.MaxBackups > 0 {
+		backups, _ := filepath.Glob(l.options.Path + ".*")
+		sort.Strings(backups)
+		for len(backups) > l.options.MaxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return l.open()
+}
+
+// Close .
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	return l.file.Close()
+}
+
+// writerFunc an io.Writer of a function.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
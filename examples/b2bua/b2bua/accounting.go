@@ -0,0 +1,85 @@
+package b2bua
+
+import (
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+)
+
+const (
+	// DefaultAccountingInterim interval of the interim accounting updates.
+	DefaultAccountingInterim = 5 * time.Minute
+)
+
+// Accounting session accounting of the answered calls, e.g. RADIUS Start/Interim/Stop.
+type Accounting interface {
+	// Start called when the call is answered.
+	Start(record *cdr.Record) error
+	// Interim called periodically while the call is answered.
+	Interim(record *cdr.Record) error
+	// Stop called when an answered call is released.
+	Stop(record *cdr.Record) error
+}
+
+// SetAccounting set the accounting of the calls, nil to disable, interim is the interval of the
+// interim updates, DefaultAccountingInterim if 0.
+func (b *B2BUA) SetAccounting(accounting Accounting, interim time.Duration) {
+	if interim <= 0 {
+		interim = DefaultAccountingInterim
+	}
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.accounting = accounting
+	b.accountingInterim = interim
+}
+
+// GetAccounting .
+func (b *B2BUA) GetAccounting() (Accounting, time.Duration) {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.accounting, b.accountingInterim
+}
+
+// startAccounting send the Start of an answered call and schedule the interim updates.
+func (b *B2BUA) startAccounting(call *B2BCall) {
+	accounting, interim := b.GetAccounting()
+	if accounting == nil {
+		return
+	}
+	started := make(chan struct{})
+	call.mutex.Lock()
+	call.accounting = accounting
+	call.accountingStarted = started
+	call.mutex.Unlock()
+	go func() {
+		defer close(started)
+		if err := accounting.Start(call.Record()); err != nil {
+			logger.Errorf("Accounting start of %v failed: %v", call.ToString(), err)
+		}
+	}()
+	var update func()
+	update = func() {
+		if err := accounting.Interim(call.Record()); err != nil {
+			logger.Errorf("Accounting interim of %v failed: %v", call.ToString(), err)
+		}
+		call.schedule(interim, update)
+	}
+	call.schedule(interim, update)
+}
+
+// stopAccounting send the Stop of a released call, if its Start was sent.
+func (b *B2BUA) stopAccounting(call *B2BCall) {
+	call.mutex.Lock()
+	accounting, started := call.accounting, call.accountingStarted
+	call.mutex.Unlock()
+	if accounting == nil {
+		return
+	}
+	go func() {
+		// The Stop must not overtake the Start.
+		<-started
+		if err := accounting.Stop(call.Record()); err != nil {
+			logger.Errorf("Accounting stop of %v failed: %v", call.ToString(), err)
+		}
+	}()
+}
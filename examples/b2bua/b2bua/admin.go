@@ -0,0 +1,284 @@
+package b2bua
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+)
+
+// ActiveCall a call in progress, as listed by the AdminHandler.
+type ActiveCall struct {
+	CallID        string `json:"call_id"`
+	CalleeCallID  string `json:"callee_call_id"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	Caller        string `json:"caller"`
+	Called        string `json:"called"`
+	Source        string `json:"source"`
+	Destination   string `json:"destination"`
+	// State of the call: setup, ringing or answered.
+	State    string     `json:"state"`
+	Setup    time.Time  `json:"setup"`
+	Answered *time.Time `json:"answered,omitempty"`
+	// Duration of the answered call so far, in seconds.
+	Duration int64 `json:"duration"`
+}
+
+// ActiveCalls the calls in progress, the oldest first.
+func (b *B2BUA) ActiveCalls() []*ActiveCall {
+	calls := []*ActiveCall{}
+	for _, call := range b.Calls() {
+		record := call.Record()
+		active := &ActiveCall{
+			CallID:        record.CallID,
+			CalleeCallID:  record.CalleeCallID,
+			CorrelationID: record.CorrelationID,
+			Caller:        record.Caller,
+			Called:        record.Called,
+			Source:        record.Source,
+			Destination:   record.Destination,
+			State:         "setup",
+			Setup:         record.Setup,
+		}
+		switch {
+		case !record.Answered.IsZero():
+			active.State = "answered"
+			active.Answered = &record.Answered
+			active.Duration = int64(time.Since(record.Answered) / time.Second)
+		case !record.Ringing.IsZero():
+			active.State = "ringing"
+		}
+		calls = append(calls, active)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].Setup.Before(calls[j].Setup)
+	})
+	return calls
+}
+
+// HangupCall release the call of which a leg has the Call-ID callID: the answered call is hung up on
+// both legs, the other ones are cancelled on all their branches and rejected to the caller. Returns
+// false if there is no such call.
+func (b *B2BUA) HangupCall(callID string) bool {
+	for _, call := range b.Calls() {
+		if call.src.CallID().Value() != callID && call.dest.CallID().Value() != callID {
+			continue
+		}
+		logger.Infof("Releasing %v", call.ToString())
+		if call.IsAnswered() {
+			b.hangup(call)
+		} else {
+			// Every branch of a forked call is cancelled, the caller answered 487 at once.
+			call.fork.abandon()
+			branches := append(call.fork.others(call), call)
+			for _, branch := range branches {
+				branch.setCause(cdr.Released)
+			}
+			if call.src.IsInProgress() {
+				call.setStatus(487, "Request Terminated")
+				b.reject(call.src, 487, "Request Terminated")
+				b.dialogs.Remove(call.src)
+			}
+			for _, branch := range branches {
+				if branch.dest.IsInProgress() {
+					branch.dest.End()
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Bindings the registered bindings, those of the AORs of user only if not empty.
+func (b *B2BUA) Bindings(user string) []*registry.Binding {
+	bindings := []*registry.Binding{}
+	for _, binding := range registry.NewSnapshot(b.GetRegistry()).Bindings {
+		if len(user) == 0 || uriUser(binding.AOR) == user {
+			bindings = append(bindings, binding)
+		}
+	}
+	sort.Slice(bindings, func(i, j int) bool {
+		return bindings[i].AOR < bindings[j].AOR
+	})
+	return bindings
+}
+
+// AdminHandler the operation of the B2BUA:
+// /calls GET the active calls, DELETE the one of the call_id query parameter;
+// /registrations GET the bindings, those of the user query parameter only if set;
+// /trace the filters of the SIP trace, see Tracer.Handler;
+// /provision POST the provisioning NOTIFYs, see ProvisioningHandler;
+// /history GET the message history set by SetMessageHistory, see MessageHistory.Handler;
+// /erase POST the erasure of the data of a user, see ErasureHandler;
+// /trunks/health the trunks of the breaker set by SetTrunkBreaker, see TrunkBreaker.Handler;
+// /accounts the account management of accounts, e.g. the AdminHandler of an accounts.Store, those added
+// by AddAccount if nil: GET the usernames, PUT the password of the user query parameter from the JSON
+// body {"password": ...}, DELETE it.
+func (b *B2BUA) AdminHandler(accounts http.Handler) http.Handler {
+	if accounts == nil {
+		accounts = b.accountsHandler()
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/calls", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, b.ActiveCalls())
+		case http.MethodDelete:
+			callID := r.URL.Query().Get("call_id")
+			if len(callID) == 0 {
+				http.Error(w, "missing call_id", http.StatusBadRequest)
+				return
+			}
+			if !b.HangupCall(callID) {
+				http.Error(w, "call not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))
+	mux.Handle("/registrations", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, b.Bindings(r.URL.Query().Get("user")))
+	}))
+	mux.Handle("/trace", b.Tracer().Handler())
+	mux.Handle("/provision", b.ProvisioningHandler())
+	mux.Handle("/history", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		history := b.GetMessageHistory()
+		if history == nil {
+			http.Error(w, "message history disabled", http.StatusNotFound)
+			return
+		}
+		history.Handler().ServeHTTP(w, r)
+	}))
+	mux.Handle("/erase", b.ErasureHandler())
+	mux.Handle("/trunks/health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		breaker := b.GetTrunkBreaker()
+		if breaker == nil {
+			http.Error(w, "trunk breaker disabled", http.StatusNotFound)
+			return
+		}
+		breaker.Handler().ServeHTTP(w, r)
+	}))
+	mux.Handle("/accounts", accounts)
+	return mux
+}
+
+// accountsHandler the management of the accounts added by AddAccount.
+func (b *B2BUA) accountsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := r.URL.Query().Get("user")
+		if len(username) == 0 && r.Method != http.MethodGet {
+			http.Error(w, "missing user", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			usernames := []string{}
+			for username := range b.GetAccounts() {
+				usernames = append(usernames, username)
+			}
+			sort.Strings(usernames)
+			writeJSON(w, usernames)
+		case http.MethodPut:
+			request := struct {
+				Password string `json:"password"`
+			}{}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&request); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if len(request.Password) == 0 {
+				http.Error(w, "missing password", http.StatusBadRequest)
+				return
+			}
+			b.AddAccount(username, request.Password)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodDelete:
+			if !b.RemoveAccount(username) {
+				http.Error(w, "account not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// AdminAuth the credentials of the admin API: a bearer Token, or the basic auth of Username and Password,
+// either one being accepted if both are set.
+type AdminAuth struct {
+	Token    string
+	Username string
+	Password string
+}
+
+// IsEmpty no credential is set.
+func (a *AdminAuth) IsEmpty() bool {
+	return len(a.Token) == 0 && (len(a.Username) == 0 || len(a.Password) == 0)
+}
+
+// Handler serve the requests of handler carrying the credentials of a, the others are answered 401. The
+// credentials are compared in constant time; without any, every request is served.
+func (a *AdminAuth) Handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.IsEmpty() && !a.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// authorized whether r carries the credentials of a.
+func (a *AdminAuth) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if len(a.Token) > 0 && len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return subtle.ConstantTimeCompare([]byte(header[7:]), []byte(a.Token)) == 1
+	}
+	username, password, ok := r.BasicAuth()
+	if !ok || len(a.Username) == 0 || len(a.Password) == 0 {
+		return false
+	}
+	// Both compared, not to tell which one is wrong by the time.
+	validUsername := subtle.ConstantTimeCompare([]byte(username), []byte(a.Username))
+	validPassword := subtle.ConstantTimeCompare([]byte(password), []byte(a.Password))
+	return validUsername&validPassword == 1
+}
+
+// CheckListen refuse to serve the admin API on address if it isn't a loopback one and a has no credential.
+func (a *AdminAuth) CheckListen(address string) error {
+	if !a.IsEmpty() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return errors.New("the admin API needs a token or a password off the loopback interface")
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,56 @@
+package b2bua
+
+import (
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// AdoptCalls take over the calls of the store, e.g. replicated by the failed active node of an HA
+// pair. Their signaling is kept alive: a BYE on a leg is relayed to the other one and closes the
+// record and the accounting of the call.
+func (b *B2BUA) AdoptCalls() (int, error) {
+	store, _ := b.GetStateStore()
+	if store == nil {
+		return 0, nil
+	}
+	states, err := store.Load()
+	if err != nil {
+		return 0, err
+	}
+	b.callsLock.Lock()
+	defer b.callsLock.Unlock()
+	for _, state := range states {
+		logger.Infof("Adopting call %s from [%s] to [%s]", state.ID, state.Record.Caller, state.Record.Called)
+		for _, dialog := range state.Legs {
+			b.adopted[dialog.CallID] = state
+		}
+	}
+	return len(states), nil
+}
+
+// handleUnknownBye release the adopted call of the BYE, if any.
+func (b *B2BUA) handleUnknownBye(req sip.Request) {
+	callID, ok := req.CallID()
+	if !ok {
+		return
+	}
+	b.callsLock.Lock()
+	state, found := b.adopted[callID.Value()]
+	if found {
+		for _, dialog := range state.Legs {
+			delete(b.adopted, dialog.CallID)
+		}
+	}
+	b.callsLock.Unlock()
+	if !found {
+		return
+	}
+	logger.Infof("Adopted call %s ended by %s", state.ID, callID.Value())
+	b.releaseState(state, time.Now(), callID.Value())
+	if store, _ := b.GetStateStore(); store != nil {
+		if err := store.Delete(state.ID); err != nil {
+			logger.Errorf("Deleting the state of %s failed: %v", state.ID, err)
+		}
+	}
+}
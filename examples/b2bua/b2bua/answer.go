@@ -0,0 +1,142 @@
+package b2bua
+
+import (
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/events"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+)
+
+// CallTiming answer supervision timestamps of a call, zero if the event didn't happen.
+type CallTiming struct {
+	// Setup the INVITE was received from the caller.
+	Setup time.Time
+	// Ringing the first 180 was received from the callee.
+	Ringing time.Time
+	// Progress the first provisional response but 100 was received from the callee, the end of the
+	// post-dial delay.
+	Progress time.Time
+	// EarlyMedia the first provisional response with SDP was received from the callee,
+	// media may flow but the call is not answered.
+	EarlyMedia time.Time
+	// Answered the 200 OK was received from the callee, the billing start.
+	Answered time.Time
+	// Ended the call was released.
+	Ended time.Time
+}
+
+// EarlyMediaDuration media time before the answer, or before the release of an unanswered call.
+func (t CallTiming) EarlyMediaDuration() time.Duration {
+	if t.EarlyMedia.IsZero() {
+		return 0
+	}
+	end := t.Answered
+	if end.IsZero() {
+		end = t.Ended
+	}
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(t.EarlyMedia)
+}
+
+// PostDialDelay time from the INVITE of the caller to the first progress of the callee, 0 if none.
+func (t CallTiming) PostDialDelay() time.Duration {
+	if t.Progress.IsZero() {
+		return 0
+	}
+	return t.Progress.Sub(t.Setup)
+}
+
+// BillableDuration answered time, up to now if the call is not released.
+func (t CallTiming) BillableDuration() time.Duration {
+	if t.Answered.IsZero() {
+		return 0
+	}
+	end := t.Ended
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(t.Answered)
+}
+
+// AnswerHandler called when the callee answers, with the timing of the call.
+type AnswerHandler func(call *B2BCall, timing CallTiming)
+
+// Timing .
+func (call *B2BCall) Timing() CallTiming {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	return call.timing
+}
+
+// IsAnswered .
+func (call *B2BCall) IsAnswered() bool {
+	return !call.Timing().Answered.IsZero()
+}
+
+// superviseProvisional record the progress, ringing and early media times, returns true on the first
+// progress.
+func (call *B2BCall) superviseProvisional(state session.Status, statusCode int) bool {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	now := time.Now()
+	progress := statusCode > 100 && call.timing.Progress.IsZero()
+	if progress {
+		call.timing.Progress = now
+	}
+	if statusCode == 180 && call.timing.Ringing.IsZero() {
+		call.timing.Ringing = now
+	}
+	if state == session.EarlyMedia && call.timing.EarlyMedia.IsZero() {
+		call.timing.EarlyMedia = now
+	}
+	return progress
+}
+
+// superviseAnswer record the answer time, returns false if the call was already answered.
+func (call *B2BCall) superviseAnswer() (CallTiming, bool) {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	if !call.timing.Answered.IsZero() {
+		return call.timing, false
+	}
+	call.timing.Answered = time.Now()
+	return call.timing, true
+}
+
+func (call *B2BCall) superviseEnd() {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	if call.timing.Ended.IsZero() {
+		call.timing.Ended = time.Now()
+	}
+}
+
+// OnAnswer set the handler called when a call is answered, e.g. to start rating.
+func (b *B2BUA) OnAnswer(handler AnswerHandler) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.answerHandler = handler
+}
+
+func (b *B2BUA) answered(call *B2BCall) {
+	timing, first := call.superviseAnswer()
+	if !first {
+		return
+	}
+	logger.Infof("Call answered after %v, early media %v", timing.Answered.Sub(timing.Setup), timing.EarlyMediaDuration())
+	b.recordTrunkCall(call, outcomeAnswered)
+	b.configLock.RLock()
+	handler := b.answerHandler
+	b.configLock.RUnlock()
+	if handler != nil {
+		handler(call, timing)
+	}
+	b.publishCall(events.CallAnswered, call)
+	b.superviseDuration(call)
+	b.superviseKeepalive(call)
+	b.superviseBilling(call)
+	b.startAccounting(call)
+	b.persistCall(call)
+}
@@ -0,0 +1,146 @@
+package b2bua
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/audit"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// SetAuditLog set the audit log of the admin actions, the authentication failures, the registrations and
+// the call attempts, nil to disable.
+func (b *B2BUA) SetAuditLog(log *audit.Log) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.auditLog = log
+}
+
+// GetAuditLog .
+func (b *B2BUA) GetAuditLog() *audit.Log {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.auditLog
+}
+
+func (b *B2BUA) writeAudit(entry *audit.Entry) {
+	log := b.GetAuditLog()
+	if log == nil {
+		return
+	}
+	if err := log.Write(entry); err != nil {
+		logger.Errorf("Audit write failed: %v", err)
+	}
+}
+
+// auditRequest audit an action of the user of the From of request.
+func (b *B2BUA) auditRequest(category audit.Category, action string, request sip.Request, target string, result string, detail string) {
+	if b.GetAuditLog() == nil {
+		return
+	}
+	actor := ""
+	if from, ok := request.From(); ok && from.Address != nil {
+		actor = from.Address.String()
+	}
+	b.writeAudit(&audit.Entry{
+		Category: category,
+		Action:   action,
+		Actor:    actor,
+		Source:   request.Source(),
+		Target:   target,
+		Result:   result,
+		Detail:   detail,
+	})
+}
+
+// auditAuthFailure the FailureCallback of the authenticator.
+func (b *B2BUA) auditAuthFailure(request sip.Request, username string, reason string) {
+	if len(username) > 0 {
+		reason = username + ": " + reason
+	}
+	b.auditRequest(audit.Auth, string(request.Method()), request, request.Recipient().String(), "rejected", reason)
+}
+
+// auditCall audit an action on the incoming call of sess, e.g. its attempt or its rejection.
+func (b *B2BUA) auditCall(action string, sess *session.Session, result string) {
+	request := sess.Request()
+	if request == nil || b.GetAuditLog() == nil {
+		return
+	}
+	target := request.Recipient().String()
+	if to, ok := request.To(); ok && to.Address != nil {
+		target = to.Address.String()
+	}
+	b.auditRequest(audit.Call, action, request, target, result, sess.CallID().String())
+}
+
+// auditRegistration audit a contact registered, or removed when expires is zero.
+func (b *B2BUA) auditRegistration(aor sip.Uri, request sip.Request, expires uint32) {
+	if b.GetAuditLog() == nil {
+		return
+	}
+	action := "register"
+	if expires == 0 {
+		action = "unregister"
+	}
+	contact := ""
+	if header, ok := request.Contact(); ok {
+		contact = header.Address.String()
+	}
+	b.auditRequest(audit.Registration, action, request, aor.String(), fmt.Sprintf("expires %d", expires), contact)
+}
+
+// auditCallEnd audit the end of a call, with its final status.
+func (b *B2BUA) auditCallEnd(call *B2BCall) {
+	if b.GetAuditLog() == nil {
+		return
+	}
+	record := call.Record()
+	b.writeAudit(&audit.Entry{
+		Time:     record.Ended,
+		Category: audit.Call,
+		Action:   "end",
+		Actor:    record.Caller,
+		Source:   record.Source,
+		Target:   record.Called,
+		Result:   fmt.Sprintf("%d %s", record.StatusCode, record.Disposition),
+		Detail:   record.CallID,
+	})
+}
+
+// AuditHandler audit the requests served by handler, e.g. the admin API, with their status code.
+func (b *B2BUA) AuditHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		b.auditHTTP(r, recorder.status)
+	})
+}
+
+// auditHTTP audit an admin request.
+func (b *B2BUA) auditHTTP(r *http.Request, status int) {
+	if b.GetAuditLog() == nil {
+		return
+	}
+	actor, _, _ := r.BasicAuth()
+	b.writeAudit(&audit.Entry{
+		Category: audit.Admin,
+		Action:   r.Method + " " + r.URL.Path,
+		Actor:    actor,
+		Source:   r.RemoteAddr,
+		Target:   r.URL.RawQuery,
+		Result:   fmt.Sprint(status),
+	})
+}
+
+// statusRecorder the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -1,14 +1,24 @@
 package b2bua
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/audit"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/enum"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/events"
 	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/fcm"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/numbering"
 	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/pushkit"
 	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
 
 	"github.com/cloudwebrtc/go-sip-ua/pkg/account"
 	"github.com/cloudwebrtc/go-sip-ua/pkg/auth"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media/rtp"
 	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
 	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
 	"github.com/cloudwebrtc/go-sip-ua/pkg/ua"
@@ -20,12 +30,66 @@ import (
 )
 
 type B2BCall struct {
-	src *session.Session
-	//TODO: Add support for forked calls
+	src  *session.Session
 	dest *session.Session
+	// emergency the call was routed as an emergency call.
+	emergency bool
+	// location the location conveyed to the B-Leg, if any.
+	location *Location
+	// reservation bandwidth reserved by the call admission control, if any.
+	reservation *Reservation
+	// ringback policy of the call, player the local ringback being played.
+	ringback RingbackPolicy
+	player   *tonePlayer
+	ended    bool
+	// timing answer supervision timestamps.
+	timing CallTiming
+	// maxDuration of the call once answered, 0 for unlimited, timers the warning and hangup timers.
+	maxDuration time.Duration
+	timers      []*time.Timer
+	// billing session of the call, nil if the billing is disabled.
+	billing *billingSession
+	// accounting the Start was sent to, nil if the call wasn't accounted, closed once sent.
+	accounting        Accounting
+	accountingStarted chan struct{}
+	// stateStore the state was saved to, nil if the call isn't persisted.
+	stateStore StateStore
+	// mediaSecurity policy of the call, encryption negotiated by the answer.
+	mediaSecurity MediaSecurityPolicy
+	encryption    media.Encryption
+	// statusCode and reason of the final failure response, if any, cause of the termination.
+	statusCode sip.StatusCode
+	reason     string
+	cause      cdr.Cause
+	mutex      sync.Mutex
+	// fork the branches of the call sharing its caller leg, transport of the B-Leg.
+	fork      *forkState
+	transport string
+	// connected the caller was answered before the B-Leg was created, connect-first, it is re-INVITEd
+	// with the SDP of the callee once it answers.
+	connected bool
+	// onNet the call between registered users bypassed the media processing, see SetOnNetBypass.
+	onNet bool
+	// correlationID links the legs of the call, the prefix of the Call-ID of its B-Legs.
+	correlationID string
+	// trunk name of the trunk of the B-Leg, if any.
+	trunk string
+}
+
+// IsEmergency .
+func (b *B2BCall) IsEmergency() bool {
+	return b.emergency
+}
+
+// HasLocation a location (Geolocation header or PIDF-LO) was conveyed with the call.
+func (b *B2BCall) HasLocation() bool {
+	return b.location != nil
 }
 
 func (b *B2BCall) ToString() string {
+	if len(b.correlationID) > 0 {
+		return "[" + b.correlationID + "] " + b.src.Contact() + " => " + b.dest.Contact()
+	}
 	return b.src.Contact() + " => " + b.dest.Contact()
 }
 
@@ -49,8 +113,95 @@ type B2BUA struct {
 	accounts map[string]string
 	registry registry.Registry
 	domains  []string
-	calls    []*B2BCall
+	calls    map[*session.Session]*B2BCall
 	rfc8599  *registry.RFC8599
+	dialogs  *DialogTracker
+	pacer    *RegisterPacer
+	trunks   []*Trunk
+	cac      *CallAdmission
+	callRate *callRate
+	tracer   *Tracer
+
+	callsLock *sync.RWMutex
+	// adopted calls taken over from another node, by Call-ID of their legs.
+	adopted map[string]*CallState
+
+	locations        map[string]string
+	ringbackPolicies map[string]RingbackPolicy
+	ringbackTone     media.Tone
+	mediaConfig      rtp.Config
+	mediaSecurity    map[string]MediaSecurityPolicy
+	rejectOptions    map[sip.StatusCode]*RejectOptions
+	challengePolicy  stack.ChallengePolicy
+	configLock       *sync.RWMutex
+	draining         bool
+	answerHandler    AnswerHandler
+	durationPolicy   *DurationPolicy
+	maxDurations     map[string]time.Duration
+	billing          Billing
+	billingHeartbeat time.Duration
+	cdrWriter        cdr.Writer
+	eventBus         *events.Bus
+	featureCodes     map[string]FeatureCodeHandler
+	callForwards     map[string]string
+	screening        map[string]*ScreeningRules
+	timeRoutes       map[string]*TimeRoute
+	router           Router
+	messageHistory   *MessageHistory
+	intercomPolicy   *IntercomPolicy
+	livenessPolicy   *LivenessPolicy
+	auditLog         *audit.Log
+	retentionPolicy  *RetentionPolicy
+	webhooks         map[string]*RegistrationWebhook
+	presence         *presence
+	headerPolicies   map[string]*HeaderPolicy
+	keepalivePolicy  *KeepalivePolicy
+	trunkBreaker     *TrunkBreaker
+	// incomingCallHandler intercepts the incoming calls, takenOver the sessions it took over.
+	incomingCallHandler IncomingCallHandler
+	takenOver           map[*session.Session]ua.InviteSessionHandler
+	// transfers the pending transfers by transferor, transferee and B-Legs to the target.
+	transfers map[*session.Session]*transfer
+	// pushes the callers waiting for their callee to be woken up by a push notification.
+	pushes map[*session.Session]*registry.Pusher
+	// earlyMedia the branch of the forked calls relaying its early SDP.
+	earlyMedia EarlyMediaPolicy
+	// forking of the calls to the AORs of several contacts.
+	forking ForkingPolicy
+	// locales by language tag, languages the language of the accounts and tenants.
+	locales   map[string]*Locale
+	languages map[string]string
+	// destinations the rules of the B-Legs, first match.
+	destinations []*DestinationRule
+	onNetBypass  bool
+	// tryingPolicy when the callers are sent 100 Trying, provisionalRefresh the interval of the resent
+	// provisional responses.
+	tryingPolicy       TryingPolicy
+	provisionalRefresh time.Duration
+	// postDialDelayThreshold of the slow progress events, postDialDelays the histograms by trunk.
+	postDialDelayThreshold time.Duration
+	postDialDelays         postDialDelays
+	// setupTimeout of the incoming calls until their first B-Leg is sent.
+	setupTimeout time.Duration
+	// expiries the expiry timers of the bindings.
+	expiries         *utils.TimingWheel
+	registrySnapshot *RegistrySnapshot
+	// registerAuthorizer the AORs the accounts may register besides their own.
+	registerAuthorizer RegisterAuthorizer
+	// callAuthorizer the destinations the accounts may call.
+	callAuthorizer CallAuthorizer
+	// numbering the translation tables of the called numbers.
+	numbering *numbering.Tables
+	// enum the resolver of the E.164 numbers called.
+	enum *enum.Resolver
+
+	authenticator      *auth.ServerAuthorizer
+	credentialProvider auth.RequestCredentialCallback
+	bearerVerifier     auth.VerifyBearerCallback
+	accounting         Accounting
+	accountingInterim  time.Duration
+	stateStore         StateStore
+	stateCheckpoint    time.Duration
 }
 
 var (
@@ -62,47 +213,114 @@ func init() {
 }
 
 //NewB2BUA .
-func NewB2BUA(disableAuth bool) *B2BUA {
+func NewB2BUA(disableAuth bool, options ...StackOption) *B2BUA {
+	config := DefaultB2BUAConfig()
+	config.DisableAuth = disableAuth
+	b, err := NewB2BUAWithConfig(config, options...)
+	if err != nil {
+		logger.Panic(err)
+	}
+	return b
+}
+
+// NewB2BUAWithConfig a B2BUA listening on the addresses of cfg.
+func NewB2BUAWithConfig(cfg *B2BUAConfig, options ...StackOption) (*B2BUA, error) {
+	if len(cfg.EmergencyDestination) > 0 {
+		if _, err := parser.ParseSipUri(cfg.EmergencyDestination); err != nil {
+			return nil, fmt.Errorf("bad emergency destination %s: %v", cfg.EmergencyDestination, err)
+		}
+	}
 	b := &B2BUA{
 		registry: registry.Registry(registry.NewMemoryRegistry()),
 		accounts: make(map[string]string),
 		rfc8599:  registry.NewRFC8599(pushCallback),
+		dialogs:  NewDialogTracker(),
+		calls:    make(map[*session.Session]*B2BCall),
+		adopted:  make(map[string]*CallState),
+		cac:      NewCallAdmission(),
+		callRate: newCallRate(),
+		tracer:   NewTracer(),
+
+		callsLock:        new(sync.RWMutex),
+		rejectOptions:    make(map[sip.StatusCode]*RejectOptions),
+		locations:        make(map[string]string),
+		webhooks:         make(map[string]*RegistrationWebhook),
+		headerPolicies:   make(map[string]*HeaderPolicy),
+		takenOver:        make(map[*session.Session]ua.InviteSessionHandler),
+		pushes:           make(map[*session.Session]*registry.Pusher),
+		transfers:        make(map[*session.Session]*transfer),
+		locales:          make(map[string]*Locale),
+		languages:        make(map[string]string),
+		ringbackPolicies: make(map[string]RingbackPolicy),
+		ringbackTone:     media.RingbackToneITU,
+		mediaConfig:      rtp.DefaultConfig(),
+		mediaSecurity:    make(map[string]MediaSecurityPolicy),
+		durationPolicy:   NewDurationPolicy(),
+		maxDurations:     make(map[string]time.Duration),
+		featureCodes:     make(map[string]FeatureCodeHandler),
+		callForwards:     make(map[string]string),
+		screening:        make(map[string]*ScreeningRules),
+		timeRoutes:       make(map[string]*TimeRoute),
+		setupTimeout:     DefaultSetupTimeout,
+		router:           RegistryRouter{},
+		expiries:         utils.NewTimingWheel(time.Second),
+		configLock:       new(sync.RWMutex),
 	}
+	b.featureCodes["*72"] = b.CallForwardSet()
+	b.featureCodes["*73"] = b.CallForwardCancel()
+
+	policy := NewChallengePolicy()
+	policy.Dialogs = b.dialogs
+	policy.EmergencyDestination = cfg.EmergencyDestination
+	b.challengePolicy = policy
 
 	var authenticator *auth.ServerAuthorizer = nil
 
-	if !disableAuth {
-		authenticator = auth.NewServerAuthorizer(b.requestCredential, "b2bua", false)
+	if !cfg.DisableAuth {
+		authenticator = auth.NewServerAuthorizer(b.requestCredential, cfg.Realm, false)
+		authenticator.OnFailure(b.auditAuthFailure)
 	}
+	b.authenticator = authenticator
 
-	stack := stack.NewSipStack(&stack.SipStackConfig{
-		UserAgent:  "Go B2BUA/1.0.0",
-		Extensions: []string{"replaces", "outbound"},
-		Dns:        "8.8.8.8",
+	config := &stack.SipStackConfig{
+		UserAgent: "Go B2BUA/1.0.0",
+		Features: stack.Features{
+			Replaces: true,
+			Outbound: true,
+		},
+		Dns:     cfg.DNS,
+		Workers: 64,
+		// Registrations over TCP/TLS/WS refresh at least hourly.
+		ConnectionLimits: stack.ConnectionLimits{
+			MaxConnections: 10000,
+			MaxPerSource:   64,
+			IdleTimeout:    2 * time.Hour,
+		},
 		ServerAuthManager: stack.ServerAuthManager{
-			Authenticator:     authenticator,
-			RequiresChallenge: b.requiresChallenge,
+			Authenticator: authenticator,
+			Policy:        stack.ChallengePolicyFunc(b.challenge),
 		},
-	})
-
-	stack.OnConnectionError(b.handleConnectionError)
-
-	if err := stack.Listen("udp", "0.0.0.0:5060"); err != nil {
-		logger.Panic(err)
 	}
-
-	if err := stack.Listen("tcp", "0.0.0.0:5060"); err != nil {
-		logger.Panic(err)
+	for _, option := range options {
+		option(config)
+	}
+	if config.TLSVerify != nil && config.TLSVerify.PeerFunc == nil {
+		config.TLSVerify.PeerFunc = b.trunkTLSPeer
+	}
+	if config.DestinationFunc == nil {
+		config.DestinationFunc = b.destinationRoute
 	}
 
-	tlsOptions := &transport.TLSConfig{Cert: "certs/cert.pem", Key: "certs/key.pem"}
+	stack := stack.NewSipStack(config)
 
-	if err := stack.ListenTLS("tls", "0.0.0.0:5061", tlsOptions); err != nil {
-		logger.Panic(err)
-	}
+	stack.OnConnectionError(b.handleConnectionError)
+	stack.OnMessage(b.observeMessage)
 
-	if err := stack.ListenTLS("wss", "0.0.0.0:5081", tlsOptions); err != nil {
-		logger.Panic(err)
+	b.stack = stack
+	if err := b.listen(cfg); err != nil {
+		stack.Shutdown()
+		b.expiries.Stop()
+		return nil, err
 	}
 
 	ua := ua.NewUserAgent(&ua.UserAgentConfig{
@@ -112,6 +330,12 @@ func NewB2BUA(disableAuth bool) *B2BUA {
 
 	ua.InviteStateHandler = func(sess *session.Session, req *sip.Request, resp *sip.Response, state session.Status) {
 		logger.Infof("InviteStateHandler: state => %v, type => %s", state, sess.Direction())
+		if b.takeOverHandler(sess, req, resp, state) {
+			return
+		}
+		if b.transferHandler(sess, resp, state) {
+			return
+		}
 
 		switch state {
 		// Handle incoming call.
@@ -120,57 +344,315 @@ func NewB2BUA(disableAuth bool) *B2BUA {
 			from, _ := (*req).From()
 			caller := from.Address
 			called := to.Address
+			setup := time.Now()
+			b.callRate.count()
+			b.auditCall("attempt", sess, "")
+			b.stripHeaders(*req, caller)
+
+			if b.IsDraining() {
+				// Let the client retry on the process that took over the sockets.
+				headers := []sip.Header{utils.NewRetryAfterHeader(1)}
+				sess.Reject(503, "Service Unavailable", headers...)
+				return
+			}
+			b.trying(sess, TryingImmediate)
 
-			doInvite := func(instance *registry.ContactInstance) {
-				displayName := ""
-				if from.DisplayName != nil {
-					displayName = from.DisplayName.String()
+			// A sips: call is secured on every hop, RFC 5630.
+			secure := (*req).Recipient().IsEncrypted()
+			if secure && !isSecureTransport((*req).Transport()) {
+				logger.Infof("sips: call from [%v] received over %s", caller, (*req).Transport())
+				b.reject(sess, 416, "Unsupported URI Scheme")
+				return
+			}
+
+			// Set the call up off the stack worker: a slow routing service, billing, DNS lookup or push
+			// mustn't stall the processing of the other calls.
+			pipeline := b.startSetup(sess)
+			go func() {
+				defer pipeline.finish()
+				var route *Route
+				if target, found := b.emergencyTarget(*req); found {
+					// Whoever the caller, authenticated or not, the emergency calls go to their destination only.
+					route = b.emergencyRoute(sess, pipeline, called, target)
+				} else {
+					called = b.translateCalled(*req, called)
+					route = b.dialPlan(sess, pipeline, *req, caller, called)
 				}
+				if route == nil {
+					return
+				}
+				called = route.Called
 
-				// Create a temporary profile. In the future, it will support reading profiles from files or data
-				// For example: use a specific ip or sip account as outbound trunk
-				profile := account.NewProfile(caller, displayName, nil, 0, stack)
+				autoAnswer, allowed := b.intercom(*req, caller)
+				if !allowed {
+					b.rejectSetup(pipeline, sess, 403, "Forbidden")
+					return
+				}
 
-				recipient, err2 := parser.ParseSipUri("sip:" + called.User().String() + "@" + instance.Source + ";transport=" + instance.Transport)
-				if err2 != nil {
-					logger.Error(err2)
+				onNet := b.onNet(*req, caller, route)
+				if onNet {
+					route.MediaSecurity = MediaSecurityBestEffort
 				}
 
-				offer := sess.RemoteSdp()
-				dest, err := ua.Invite(profile, called, recipient, &offer)
-				if err != nil {
-					logger.Errorf("B-Leg session error: %v", err)
+				location, offer := ParseLocation(*req)
+				offer, code := route.MediaSecurity.offer(offer)
+				if code != 0 {
+					logger.Infof("Call from [%v] to [%v] refused by the %s media security policy", caller, called, route.MediaSecurity)
+					b.rejectSetup(pipeline, sess, code, "Not Acceptable Here")
 					return
 				}
-				b.calls = append(b.calls, &B2BCall{src: sess, dest: dest})
-			}
+				emergency := route.Emergency
+				if emergency {
+					location = b.emergencyLocation(*req, location)
+					logger.Infof("Emergency call from [%v] to [%v], location present: %v", caller, called, location != nil)
+				} else {
+					// Location is only passed through on emergency-routed calls.
+					location = nil
+				}
+				if !emergency {
+					if username, reason, allowed := b.callAllowed(*req, called); !allowed {
+						logger.Infof("Account %s may not call [%v]: %s", username, called, reason)
+						b.rejectSetup(pipeline, sess, 403, reason)
+						return
+					}
+				}
 
-			// Try to find online contact records.
-			if contacts, found := b.registry.GetContacts(called); found {
-				sess.Provisional(100, "Trying")
-				for _, instance := range *contacts {
-					doInvite(instance)
+				// Emergency calls are never submitted to the billing.
+				var billing *billingSession
+				authorized := time.Duration(0)
+				if !pipeline.enter(setupAuthorizing) {
+					return
+				}
+				if !emergency {
+					var err error
+					if billing, authorized, err = b.authorize(*req, caller.User().String(), called.User().String()); err != nil {
+						logger.Infof("Billing rejects call from [%v] to [%v]: %v", caller, called, err)
+						code, reason := billingRejection(err)
+						b.rejectSetup(pipeline, sess, code, reason)
+						return
+					}
+				}
+
+				// insecure a B-Leg refused by the transport security policy.
+				insecure := false
+				// The on-net callees play their own ringback.
+				ringback := b.GetRingbackPolicy(called.User().String())
+				if onNet {
+					ringback = RingbackForward
+				}
+				fork := newForkState(b.GetEarlyMediaPolicy())
+				correlationID := b.newCorrelationID()
+				doInvite := func(instance *registry.ContactInstance, reservation *Reservation) *B2BCall {
+					displayName := ""
+					if from.DisplayName != nil {
+						displayName = from.DisplayName.String()
+					}
+
+					// Create a temporary profile. In the future, it will support reading profiles from files or data
+					// For example: use a specific ip or sip account as outbound trunk
+					profile := account.NewProfile(caller, displayName, nil, 0, stack)
+					trunkName := ""
+					if trunk := b.FindTrunk(instance.Source); trunk != nil {
+						profile.Routes = trunk.Routes
+						trunkName = trunk.Name
+					}
+					transport := b.routeLeg(instance.Source, instance.Transport, profile)
+
+					scheme := "sip:"
+					if secure {
+						scheme = "sips:"
+					}
+					recipient, err2 := parser.ParseSipUri(scheme + called.User().String() + "@" + instance.Source + ";transport=" + transport)
+					if err2 != nil {
+						logger.Error(err2)
+					}
+
+					body := offer
+					contentType := ""
+					headers := append(b.copyHeaders(*req, caller), route.Headers...)
+					headers = append(headers, legCallID(correlationID))
+					if autoAnswer != nil {
+						headers = append(headers, autoAnswer.Headers(caller)...)
+					}
+					if location != nil {
+						headers = append(headers, location.Headers()...)
+						if location.HasPIDF() {
+							var err error
+							if body, contentType, err = buildLocationBody(offer, location); err != nil {
+								logger.Errorf("Location body error: %v", err)
+								body, contentType = offer, ""
+							}
+						}
+					}
+
+					dest, err := ua.InviteWithHeaders(context.TODO(), profile, called, recipient, &body, contentType, headers)
+					if err != nil {
+						logger.Errorf("B-Leg session error: %v", err)
+						insecure = insecure || isInsecureTransport(err)
+						return nil
+					}
+					if !pipeline.enter(setupInvited) {
+						// The caller was answered 504 meanwhile.
+						dest.End()
+						return nil
+					}
+					maxDuration := time.Duration(0)
+					if !emergency {
+						maxDuration = b.maxCallDuration(caller.User().String(), (*req).Source(), instance.Source)
+						maxDuration = shorterDuration(maxDuration, authorized)
+					}
+					billing.attach()
+					b.dialogs.Add(dest)
+					call := &B2BCall{
+						src:           sess,
+						dest:          dest,
+						emergency:     emergency,
+						location:      location,
+						reservation:   reservation,
+						ringback:      ringback,
+						mediaSecurity: route.MediaSecurity,
+						timing:        CallTiming{Setup: setup},
+						maxDuration:   maxDuration,
+						billing:       billing,
+						fork:          fork,
+						transport:     transport,
+						onNet:         onNet,
+						trunk:         trunkName,
+						correlationID: correlationID,
+					}
+					b.addCall(call)
+					logger.Infof("Call [%s] from [%v] to [%v] via %s", correlationID, caller, called, instance.Source)
+					if !sess.IsInProgress() {
+						// The caller cancelled meanwhile, e.g. while the callee was pushed.
+						dest.End()
+					}
+					if route.Timeout > 0 {
+						call.schedule(route.Timeout, func() { b.noAnswer(call) })
+					}
+					return call
 				}
-				return
-			}
 
-			// Pushable: try to find pn-params in contact records.
-			// Try to push the UA and wait for it to wake up.
-			pusher, ok := b.rfc8599.TryPush(called, from)
-			if ok {
-				sess.Provisional(100, "Trying")
-				instance, err := pusher.WaitContactOnline()
-				if err != nil {
-					logger.Errorf("Push failed, error: %v", err)
-					sess.Reject(500, fmt.Sprint("Push failed"))
+				if !pipeline.enter(setupInviting) {
+					billing.abandon(setup)
+					return
+				}
+				b.dialogs.Add(sess)
+
+				// Try to find online contact records, unless the route has explicit destinations.
+				contacts, found := route.contacts()
+				if !found {
+					contacts, found = b.registry.GetContacts(called)
+					if found {
+						contacts, found = b.reachableContacts(contacts)
+					}
+				}
+				if found && secure {
+					if contacts, found = secureContacts(contacts); !found {
+						logger.Infof("No contact of [%v] reachable over TLS for a sips: call", called)
+						b.rejectSetup(pipeline, sess, 480, "Temporarily Unavailable")
+						b.dialogs.Remove(sess)
+						billing.abandon(setup)
+						return
+					}
+				}
+				if found {
+					if contacts, found = b.healthyTrunks(contacts); !found {
+						logger.Infof("The trunks of [%v] are degraded", called)
+						b.rejectSetup(pipeline, sess, 503, "Service Unavailable")
+						b.dialogs.Remove(sess)
+						billing.abandon(setup)
+						return
+					}
+				}
+				if found {
+					addrs := []string{(*req).Source()}
+					for _, instance := range *contacts {
+						addrs = append(addrs, instance.Source)
+					}
+					// Emergency calls are admitted over the bandwidth budgets, the on-net calls use none.
+					reservation, code := (*Reservation)(nil), sip.StatusCode(200)
+					if !onNet {
+						reservation, code = b.admit(offer, addrs...)
+					}
+					if code != 200 && !emergency {
+						b.rejectSetup(pipeline, sess, code, admissionReason(code))
+						b.dialogs.Remove(sess)
+						billing.abandon(setup)
+						return
+					}
+
+					b.trying(sess, TryingAfterRouting)
+					invited := false
+					if forking := b.GetForkingPolicy(); forking.Mode == ForkingSequential && len(*contacts) > 1 {
+						invited = fork.sequential(registrationOrder(*contacts), forking.RingTimeout, func(instance *registry.ContactInstance) *B2BCall {
+							if !sess.IsInProgress() {
+								return nil
+							}
+							return doInvite(instance, reservation)
+						})
+					} else {
+						for _, instance := range *contacts {
+							invited = doInvite(instance, reservation) != nil || invited
+						}
+					}
+					if !invited {
+						if reservation != nil {
+							reservation.Release()
+						}
+						billing.abandon(setup)
+						b.failSetup(pipeline, sess, insecure)
+					}
+					return
+				}
+
+				// Pushable: try to find pn-params in contact records.
+				// Try to push the UA and wait for it to wake up.
+				pusher, ok := b.rfc8599.TryPush(called, from)
+				if ok {
+					reservation, code := b.admit(offer, (*req).Source())
+					if code != 200 && !emergency {
+						b.rejectSetup(pipeline, sess, code, admissionReason(code))
+						b.dialogs.Remove(sess)
+						billing.abandon(setup)
+						return
+					}
+
+					if !pipeline.enter(setupPushing) {
+						if reservation != nil {
+							reservation.Release()
+						}
+						billing.abandon(setup)
+						b.dialogs.Remove(sess)
+						return
+					}
+					b.trying(sess, TryingAfterRouting)
+					b.waitPush(sess, pusher)
+					instance, err := pusher.WaitContactOnline()
+					b.endPush(sess, pusher)
+					if err == nil && sess.IsInProgress() && doInvite(instance, reservation) != nil {
+						return
+					}
+					if reservation != nil {
+						reservation.Release()
+					}
+					billing.abandon(setup)
+					switch {
+					case err == nil && sess.IsInProgress():
+						// The B-Leg to the woken up contact couldn't be sent.
+						b.failSetup(pipeline, sess, insecure)
+					case err != nil && err != registry.ErrPushAborted:
+						logger.Errorf("Push failed, error: %v", err)
+						b.reject(sess, 500, "Push failed")
+						b.dialogs.Remove(sess)
+					}
 					return
 				}
-				doInvite(instance)
-				return
-			}
 
-			// Could not found any records
-			sess.Reject(404, fmt.Sprintf("%v Not found", called))
+				// Could not found any records
+				b.rejectSetup(pipeline, sess, 404, fmt.Sprintf("%v Not found", called))
+				b.dialogs.Remove(sess)
+				billing.abandon(setup)
+			}()
 
 		// Handle re-INVITE or UPDATE.
 		case session.ReInviteReceived:
@@ -188,33 +670,98 @@ func NewB2BUA(disableAuth bool) *B2BUA {
 		case session.Provisional:
 			call := b.findCall(sess)
 			if call != nil && call.dest == sess {
-				answer := call.dest.RemoteSdp()
-				call.src.ProvideAnswer(answer)
-				call.src.Provisional((*resp).StatusCode(), (*resp).Reason())
+				if call.superviseProvisional(state, int((*resp).StatusCode())) {
+					b.postDialDelay(call)
+				}
+				b.relayProvisional(call, *resp)
 			}
 
 		// Handle 200OK or ACK
 		case session.Confirmed:
-			//TODO: Add support for forked calls
 			call := b.findCall(sess)
-			if call != nil && call.dest == sess {
+			// A 200 to a re-INVITE of the B-Leg, e.g. a keep-alive, leaves the call as is.
+			if call != nil && call.dest == sess && !call.IsAnswered() {
 				answer := call.dest.RemoteSdp()
-				call.src.ProvideAnswer(answer)
-				call.src.Accept(200)
+				sdp, reinvite, won := call.fork.answer(call, answer)
+				if !won {
+					logger.Infof("Branch %v answered after another one, hung up", sess.CallID())
+					b.dialogs.Remove(sess)
+					b.removeCall(sess)
+					go sess.Bye()
+					return
+				}
+				b.elect(call)
+				for _, branch := range call.fork.others(call) {
+					if branch.dest.IsInProgress() {
+						// The phones of the other branches don't log a missed call.
+						branch.dest.End(utils.NewReasonHeader("SIP", 200, "Call completed elsewhere"))
+					}
+				}
+				call.stopRingback(false)
+				if !b.checkMediaSecurity(call, answer) {
+					return
+				}
+				if call.reservation != nil {
+					// Reserve the bandwidth of the negotiated codecs.
+					if bandwidth, _, err := media.SessionBandwidth(answer); err == nil {
+						call.reservation.Update(bandwidth)
+					}
+				}
+				if call.connected {
+					go b.connectMedia(call, answer)
+				} else {
+					call.src.ProvideAnswer(sdp)
+					call.src.Accept(200)
+					if reinvite {
+						// The early media came from another branch.
+						go b.connectMedia(call, answer)
+					}
+				}
+				b.answered(call)
 			}
 
 		// Handle 4XX+
 		case session.Failure:
+			b.dialogs.Remove(sess)
+			b.recordTrunkFailure(sess, resp)
+			if b.yieldBranch(sess) {
+				return
+			}
+			call := b.findCall(sess)
+			if call != nil && call.dest == sess && call.src.IsInProgress() && resp != nil && *resp != nil {
+				// Relay the B-Leg failure to the caller with the configured details.
+				call.setStatus((*resp).StatusCode(), (*resp).Reason())
+				code, reason := b.trunkResponse(call, (*resp).StatusCode(), (*resp).Reason())
+				b.reject(call.src, code, reason)
+				b.dialogs.Remove(call.src)
+				b.removeCall(sess)
+				return
+			}
 			fallthrough
 		case session.Canceled:
 			fallthrough
 		case session.Terminated:
-			//TODO: Add support for forked calls
+			b.dialogs.Remove(sess)
+			b.abortPush(sess)
+			if b.yieldBranch(sess) {
+				return
+			}
 			call := b.findCall(sess)
 			if call != nil {
+				if state == session.Canceled {
+					call.setStatus(487, "Request Terminated")
+				}
 				if call.src == sess {
+					call.setCause(cdr.CallerHangup)
+					call.fork.abandon()
 					call.dest.End()
+					for _, branch := range call.fork.others(call) {
+						if branch.dest.IsInProgress() {
+							branch.dest.End()
+						}
+					}
 				} else if call.dest == sess {
+					call.setCause(cdr.CalleeHangup)
 					call.src.End()
 				}
 			}
@@ -223,76 +770,140 @@ func NewB2BUA(disableAuth bool) *B2BUA {
 		}
 	}
 
+	ua.UnknownByeHandler = b.handleUnknownBye
+
 	ua.RegisterStateHandler = func(state account.RegisterState) {
 		logger.Infof("RegisterStateHandler: state => %v", state)
 	}
 
 	stack.OnRequest(sip.REGISTER, b.handleRegister)
-	b.stack = stack
+	stack.OnRequest(sip.OPTIONS, b.handleOptions)
+	stack.OnRequest(sip.REFER, b.handleRefer)
 	b.ua = ua
-	return b
+	return b, nil
 }
 
 func (b *B2BUA) Calls() []*B2BCall {
-	return b.calls
+	b.callsLock.RLock()
+	defer b.callsLock.RUnlock()
+	calls := make([]*B2BCall, 0, len(b.calls)/2)
+	for sess, call := range b.calls {
+		if call.src == sess {
+			calls = append(calls, call)
+		}
+	}
+	return calls
 }
 
-func (b *B2BUA) findCall(sess *session.Session) *B2BCall {
-	for _, call := range b.calls {
-		if call.src == sess || call.dest == sess {
-			return call
-		}
+// addCall index the call by both legs.
+func (b *B2BUA) addCall(call *B2BCall) {
+	b.callsLock.Lock()
+	b.calls[call.src] = call
+	b.calls[call.dest] = call
+	b.callsLock.Unlock()
+	call.fork.add(call)
+	if history := b.GetMessageHistory(); history != nil {
+		history.link(legCallIDs(call))
 	}
-	return nil
+	b.tracer.link(legCallIDs(call))
+	b.publishCall(events.CallStarted, call)
+}
+
+func (b *B2BUA) findCall(sess *session.Session) *B2BCall {
+	b.callsLock.RLock()
+	defer b.callsLock.RUnlock()
+	return b.calls[sess]
 }
 
 func (b *B2BUA) removeCall(sess *session.Session) {
-	for idx, call := range b.calls {
-		if call.src == sess || call.dest == sess {
-			b.calls = append(b.calls[:idx], b.calls[idx+1:]...)
-			return
+	b.callsLock.Lock()
+	call, found := b.calls[sess]
+	if found {
+		// The caller leg of a forked call may belong to another branch.
+		if b.calls[call.src] == call {
+			delete(b.calls, call.src)
 		}
+		delete(b.calls, call.dest)
+	}
+	b.callsLock.Unlock()
+	if !found {
+		return
+	}
+	if call.reservation != nil {
+		call.reservation.Release()
+	}
+	call.stopRingback(true)
+	call.stopTimers()
+	call.superviseEnd()
+	call.billing.detach(call.Timing())
+	b.stopAccounting(call)
+	b.forgetCall(call)
+	b.publishCall(events.CallEnded, call)
+	b.auditCallEnd(call)
+	b.writeCDR(call)
+	if history := b.GetMessageHistory(); history != nil {
+		history.end(legCallIDs(call))
 	}
 }
 
 //Shutdown .
 func (b *B2BUA) Shutdown() {
 	b.ua.Shutdown()
+	b.expiries.Stop()
+	if n, err := b.SnapshotRegistry(); err != nil {
+		logger.Errorf("Registry snapshot failed: %v", err)
+	} else if n > 0 {
+		logger.Infof("Registry snapshot of %d bindings", n)
+	}
+	if writer := b.GetCDRWriter(); writer != nil {
+		// Flush the buffered records.
+		writer.Close()
+	}
+	if bus := b.GetEventBus(); bus != nil {
+		bus.Close()
+	}
 }
 
-func (b *B2BUA) requiresChallenge(req sip.Request) bool {
-	switch req.Method() {
-	//case sip.UPDATE:
-	case sip.REGISTER:
-		return true
-	case sip.INVITE:
-		return true
-	//case sip.RREFER:
-	//	return false
-	case sip.CANCEL:
-		return false
-	case sip.OPTIONS:
-		return false
-	case sip.INFO:
-		return false
-	case sip.BYE:
-		{
-			// Allow locally initiated dialogs
-			// Return false if call-id in sessions.
-			return false
-		}
-	}
-	return false
+//ConnectionStats .
+func (b *B2BUA) ConnectionStats() (stack.ConnectionStats, bool) {
+	return b.stack.ConnectionStats()
+}
+
+// ScannerStats .
+func (b *B2BUA) ScannerStats() (stack.ScannerStats, bool) {
+	return b.stack.ScannerStats()
 }
 
 //AddAccount .
 func (b *B2BUA) AddAccount(username string, password string) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
 	b.accounts[username] = password
 }
 
-//GetAccounts .
+// RemoveAccount remove the account added by AddAccount, returns false if there is none.
+func (b *B2BUA) RemoveAccount(username string) bool {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	_, found := b.accounts[username]
+	delete(b.accounts, username)
+	return found
+}
+
+//GetAccounts a copy of the accounts added by AddAccount.
 func (b *B2BUA) GetAccounts() map[string]string {
-	return b.accounts
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	accounts := make(map[string]string, len(b.accounts))
+	for username, password := range b.accounts {
+		accounts[username] = password
+	}
+	return accounts
+}
+
+//SetRegistry replace the registry, before the B2BUA serves any request.
+func (b *B2BUA) SetRegistry(r registry.Registry) {
+	b.registry = r
 }
 
 //GetRegistry .
@@ -306,7 +917,14 @@ func (b *B2BUA) GetRFC8599() *registry.RFC8599 {
 }
 
 func (b *B2BUA) requestCredential(username string) (string, string, error) {
-	if password, found := b.accounts[username]; found {
+	b.configLock.RLock()
+	provider := b.credentialProvider
+	password, found := b.accounts[username]
+	b.configLock.RUnlock()
+	if provider != nil {
+		return provider(username)
+	}
+	if found {
 		logger.Infof("Found user %s", username)
 		return password, "", nil
 	}
@@ -316,37 +934,98 @@ func (b *B2BUA) requestCredential(username string) (string, string, error) {
 func (b *B2BUA) handleRegister(request sip.Request, tx sip.ServerTransaction) {
 	headers := request.GetHeaders("Expires")
 	to, _ := request.To()
-	aor := to.Address.Clone()
+	// The registry clones the AOR when it stores it.
+	aor := to.Address
 	var expires sip.Expires = 0
 	if len(headers) > 0 {
 		expires = *headers[0].(*sip.Expires)
 	}
 
+	if b.IsDraining() {
+		resp := sip.NewResponseFromRequest(request.MessageID(), request, 503, "Service Unavailable", "")
+		resp.AppendHeader(utils.NewRetryAfterHeader(1))
+		tx.Respond(resp)
+		return
+	}
+
+	if username, allowed := b.registerAllowed(request, aor); !allowed {
+		logger.Warnf("Account %s may not register [%v], rejected source %s", username, to, request.Source())
+		b.auditAuthFailure(request, username, "AOR not allowed")
+		tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 403, "Forbidden", ""))
+		return
+	}
+
+	// Paced once authenticated and allowed, so that the rejected requests of a flood don't spend the
+	// tokens of the clients re-registering.
+	if pacer := b.GetRegisterPacer(); pacer != nil && expires != sip.Expires(0) {
+		if !pacer.Allow() {
+			logger.Warnf("Too many REGISTER requests, rejected [%v] source %s", to, request.Source())
+			resp := sip.NewResponseFromRequest(request.MessageID(), request, 503, "Service Unavailable", "")
+			resp.AppendHeader(utils.NewRetryAfterHeader(pacer.RetryAfter))
+			tx.Respond(resp)
+			return
+		}
+		expires = sip.Expires(pacer.Expires(uint32(expires)))
+	}
+
+	// The registration must not outlive the token.
+	expires = sip.Expires(b.bearerExpires(request, uint32(expires)))
+	// The NAT bindings of the UDP clients may close long before.
+	expires = sip.Expires(b.livenessExpires(request, uint32(expires)))
+
+	// Store the binding off the stack worker: a registry backend, e.g. Redis, mustn't stall the processing
+	// of the calls. A UA doesn't send the next REGISTER of a binding before this one is answered, RFC 3261 10.2.
+	b.stack.Offload(request, tx, func() {
+		b.register(request, tx, aor, headers, expires)
+	})
+}
+
+// register store or remove the binding of request to aor, and answer it.
+func (b *B2BUA) register(request sip.Request, tx sip.ServerTransaction, aor sip.Uri, headers []sip.Header, expires sip.Expires) {
+	to, _ := request.To()
 	reason := ""
 	if len(headers) > 0 && expires != sip.Expires(0) {
 		instance := registry.NewContactInstanceForRequest(request)
+		instance.RegExpires = uint32(expires)
+		instance.LastUpdated = uint32(time.Now().Unix())
+		if err := b.registry.AddAor(aor, instance); err != nil {
+			// The bindings are updated atomically or not at all, RFC 3261 10.3.
+			logger.Errorf("Register [%v] failed: %v", to, err)
+			tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 500, "Server Internal Error", ""))
+			return
+		}
 		logger.Infof("Registered [%v] expires [%d] source %s", to, expires, request.Source())
 		reason = "Registered"
-		b.registry.AddAor(aor, instance)
+		b.scheduleExpiry(aor, instance.Source, time.Duration(expires)*time.Second)
 		b.rfc8599.HandleContactInstance(aor, instance)
+		b.publishRegistration(events.Registered, aor, request, uint32(expires))
+		b.auditRegistration(aor, request, uint32(expires))
+		b.updatePresence(aor, "registered")
 	} else {
 		logger.Infof("Logged out [%v] expires [%d] ", to, expires)
 		reason = "UnRegistered"
 		instance := registry.NewContactInstanceForRequest(request)
 		b.registry.RemoveContact(aor, instance)
+		b.cancelExpiry(aor, instance.Source)
 		b.rfc8599.HandleContactInstance(aor, instance)
+		b.publishRegistration(events.Unregistered, aor, request, 0)
+		b.auditRegistration(aor, request, 0)
+		b.updatePresence(aor, "unregistered")
 	}
 
 	resp := sip.NewResponseFromRequest(request.MessageID(), request, 200, reason, "")
-	sip.CopyHeaders("Expires", request, resp)
+	if len(headers) > 0 {
+		resp.AppendHeader(&expires)
+	}
 	utils.BuildContactHeader("Contact", request, resp, &expires)
 	tx.Respond(resp)
-
 }
 
 func (b *B2BUA) handleConnectionError(connError *transport.ConnectionError) {
 	logger.Debugf("Handle Connection Lost: Source: %v, Dest: %v, Network: %v", connError.Source, connError.Dest, connError.Net)
-	b.registry.HandleConnectionError(connError)
+	if b.registry.HandleConnectionError(connError) {
+		b.checkPresence(b.getPresence(), "connection-lost")
+	}
 }
 
 func (b *B2BUA) SetLogLevel(level log.Level) {
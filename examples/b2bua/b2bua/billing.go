@@ -0,0 +1,186 @@
+package b2bua
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// DefaultBillingHeartbeat interval of the heartbeat charging.
+	DefaultBillingHeartbeat = time.Minute
+)
+
+// BillingCall the call submitted to the billing.
+type BillingCall struct {
+	// CallID of the caller leg.
+	CallID string
+	Caller string
+	Called string
+	// Source address of the caller.
+	Source string
+}
+
+// Billing prepaid/quota platform hooks.
+type Billing interface {
+	// Authorize called before routing a new call, returns the allowed maximum duration, 0 for unlimited,
+	// or an error to reject the call, see BillingError.
+	Authorize(call BillingCall) (time.Duration, error)
+	// Heartbeat called periodically while the call is answered, returns the remaining allowed duration,
+	// 0 or an error hangs up the call.
+	Heartbeat(call BillingCall, elapsed time.Duration) (time.Duration, error)
+	// Charge called once when the call is released, timing.Answered is zero for unanswered calls.
+	Charge(call BillingCall, timing CallTiming)
+}
+
+// BillingError rejection of a call by the billing.
+type BillingError struct {
+	StatusCode sip.StatusCode
+	Reason     string
+}
+
+func (e *BillingError) Error() string {
+	return fmt.Sprintf("billing: %d %s", e.StatusCode, e.Reason)
+}
+
+// SetBilling set the billing hooks, nil to disable, heartbeat is the heartbeat charging interval,
+// DefaultBillingHeartbeat if 0.
+func (b *B2BUA) SetBilling(billing Billing, heartbeat time.Duration) {
+	if heartbeat <= 0 {
+		heartbeat = DefaultBillingHeartbeat
+	}
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.billing = billing
+	b.billingHeartbeat = heartbeat
+}
+
+// GetBilling .
+func (b *B2BUA) GetBilling() (Billing, time.Duration) {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.billing, b.billingHeartbeat
+}
+
+// billingSession billing state of a caller leg, shared by its B-Legs.
+type billingSession struct {
+	call    BillingCall
+	billing Billing
+	mutex   sync.Mutex
+	legs    int
+	timing  CallTiming
+	charged bool
+}
+
+// authorize submit a new call to the billing, returns nil if the billing is disabled.
+func (b *B2BUA) authorize(req sip.Request, caller, called string) (*billingSession, time.Duration, error) {
+	billing, _ := b.GetBilling()
+	if billing == nil {
+		return nil, 0, nil
+	}
+	call := BillingCall{
+		Caller: caller,
+		Called: called,
+		Source: req.Source(),
+	}
+	if callID, ok := req.CallID(); ok {
+		call.CallID = callID.Value()
+	}
+	maxDuration, err := billing.Authorize(call)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &billingSession{call: call, billing: billing}, maxDuration, nil
+}
+
+// billingRejection the response to a call rejected by the billing.
+func billingRejection(err error) (sip.StatusCode, string) {
+	if e, ok := err.(*BillingError); ok {
+		return e.StatusCode, e.Reason
+	}
+	return 402, "Payment Required"
+}
+
+// attach a B-Leg to the session.
+func (s *billingSession) attach() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.legs++
+}
+
+// detach a released B-Leg, the call is charged once all the B-Legs are released.
+func (s *billingSession) detach(timing CallTiming) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.legs--
+	if s.timing.Answered.IsZero() {
+		s.timing = timing
+	}
+	if s.legs > 0 || s.charged {
+		s.mutex.Unlock()
+		return
+	}
+	s.charged = true
+	timing = s.timing
+	s.mutex.Unlock()
+	s.billing.Charge(s.call, timing)
+}
+
+// abandon charge a call released before any B-Leg was created.
+func (s *billingSession) abandon(setup time.Time) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.legs > 0 || s.charged {
+		s.mutex.Unlock()
+		return
+	}
+	s.charged = true
+	s.mutex.Unlock()
+	s.billing.Charge(s.call, CallTiming{Setup: setup, Ended: time.Now()})
+}
+
+// superviseBilling schedule the heartbeat charging of an answered call.
+func (b *B2BUA) superviseBilling(call *B2BCall) {
+	if call.billing == nil {
+		return
+	}
+	_, interval := b.GetBilling()
+	var heartbeat func()
+	heartbeat = func() {
+		elapsed := call.Timing().BillableDuration()
+		remaining, err := call.billing.billing.Heartbeat(call.billing.call, elapsed)
+		if err != nil || remaining <= 0 {
+			logger.Infof("Billing ends call %v after %v: %v", call.ToString(), elapsed, err)
+			policy := b.GetDurationPolicy()
+			b.hangup(call, utils.NewReasonHeader(policy.ReasonProtocol, policy.ReasonCause, policy.ReasonText))
+			return
+		}
+		next := interval
+		if remaining < next {
+			// The quota ends before the next heartbeat, the last one hangs up.
+			next = remaining
+		}
+		call.schedule(next, heartbeat)
+	}
+	call.schedule(interval, heartbeat)
+}
+
+// schedule run f after d, unless the call has ended.
+func (call *B2BCall) schedule(d time.Duration, f func()) {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	if call.ended {
+		return
+	}
+	call.timers = append(call.timers, time.AfterFunc(d, f))
+}
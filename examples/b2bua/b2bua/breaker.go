@@ -0,0 +1,399 @@
+package b2bua
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/events"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/ghettovoice/gosip/util"
+)
+
+const (
+	// DefaultBreakerWindow last calls of a trunk its ratios are computed over.
+	DefaultBreakerWindow = 20
+	// DefaultBreakerMinCalls calls in the window before the ratios are checked.
+	DefaultBreakerMinCalls = 10
+	// DefaultBreakerMinASR answer seizure ratio under which a trunk is degraded.
+	DefaultBreakerMinASR = 0.2
+	// DefaultBreakerMaxTimeoutRate ratio of the calls timing out over which a trunk is degraded.
+	DefaultBreakerMaxTimeoutRate = 0.5
+	// DefaultBreakerProbeInterval between the OPTIONS probes of a degraded trunk.
+	DefaultBreakerProbeInterval = 30 * time.Second
+	// DefaultBreakerRecovery probes answered in a row before a degraded trunk is restored.
+	DefaultBreakerRecovery = 3
+	// breakerProbeTimeout of a probe.
+	breakerProbeTimeout = 5 * time.Second
+)
+
+// trunkOutcome the outcome of a B-Leg sent to a trunk.
+type trunkOutcome int
+
+const (
+	outcomeAnswered trunkOutcome = iota
+	outcomeFailed
+	// outcomeTimeout the trunk didn't answer, 408 or no response.
+	outcomeTimeout
+)
+
+// TrunkBreaker a circuit breaker of the trunks: a trunk is degraded when the answer seizure ratio
+// (ASR) of its last calls falls under MinASR, or when too many of them time out. The degraded trunks
+// are skipped by the routing and probed with OPTIONS, they're restored once they answer Recovery
+// probes in a row, with a clean window.
+type TrunkBreaker struct {
+	// Window the last calls of a trunk the ratios are computed over, checked from MinCalls calls.
+	Window   int
+	MinCalls int
+	// MinASR answered over attempted calls under which a trunk is degraded, 0 to disable.
+	MinASR float64
+	// MaxTimeoutRate timed out over attempted calls over which a trunk is degraded, 0 to disable.
+	MaxTimeoutRate float64
+	// ProbeInterval between the probes of a degraded trunk.
+	ProbeInterval time.Duration
+	Recovery      int
+
+	mutex  sync.Mutex
+	trunks map[string]*trunkState
+	stop   chan struct{}
+}
+
+// trunkState the last calls of a trunk.
+type trunkState struct {
+	outcomes []trunkOutcome
+	degraded bool
+	since    time.Time
+	reason   string
+	// probes answered in a row, target the last B-Leg was sent to, probed while degraded.
+	probes    int
+	target    string
+	transport string
+}
+
+// TrunkHealth the state of a trunk in the breaker.
+type TrunkHealth struct {
+	Trunk       string  `json:"trunk"`
+	Calls       int     `json:"calls"`
+	ASR         float64 `json:"asr"`
+	TimeoutRate float64 `json:"timeout_rate"`
+	Degraded    bool    `json:"degraded"`
+	// Since and Reason of the degradation.
+	Since  time.Time `json:"since,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// NewTrunkBreaker .
+func NewTrunkBreaker(window int, minASR float64, maxTimeoutRate float64) *TrunkBreaker {
+	if window <= 0 {
+		window = DefaultBreakerWindow
+	}
+	minCalls := DefaultBreakerMinCalls
+	if minCalls > window {
+		minCalls = window
+	}
+	return &TrunkBreaker{
+		Window:         window,
+		MinCalls:       minCalls,
+		MinASR:         minASR,
+		MaxTimeoutRate: maxTimeoutRate,
+		ProbeInterval:  DefaultBreakerProbeInterval,
+		Recovery:       DefaultBreakerRecovery,
+		trunks:         make(map[string]*trunkState),
+	}
+}
+
+// rates the calls of the window, their ASR and timeout rate.
+func (s *trunkState) rates() (int, float64, float64) {
+	calls := len(s.outcomes)
+	if calls == 0 {
+		return 0, 0, 0
+	}
+	answered, timeouts := 0, 0
+	for _, outcome := range s.outcomes {
+		switch outcome {
+		case outcomeAnswered:
+			answered++
+		case outcomeTimeout:
+			timeouts++
+		}
+	}
+	return calls, float64(answered) / float64(calls), float64(timeouts) / float64(calls)
+}
+
+// state of trunk, locked.
+func (p *TrunkBreaker) state(trunk string) *trunkState {
+	if p.trunks == nil {
+		p.trunks = make(map[string]*trunkState)
+	}
+	state, found := p.trunks[trunk]
+	if !found {
+		state = &trunkState{}
+		p.trunks[trunk] = state
+	}
+	return state
+}
+
+// record the outcome of a B-Leg sent to target of trunk, the reason if the trunk becomes degraded.
+func (p *TrunkBreaker) record(trunk string, target string, transport string, outcome trunkOutcome) (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	state := p.state(trunk)
+	state.target, state.transport = target, transport
+	if state.degraded {
+		return "", false
+	}
+	state.outcomes = append(state.outcomes, outcome)
+	if len(state.outcomes) > p.Window {
+		state.outcomes = state.outcomes[len(state.outcomes)-p.Window:]
+	}
+	calls, asr, timeouts := state.rates()
+	if calls < p.MinCalls {
+		return "", false
+	}
+	switch {
+	case p.MinASR > 0 && asr < p.MinASR:
+		state.reason = fmt.Sprintf("ASR %.2f under %.2f", asr, p.MinASR)
+	case p.MaxTimeoutRate > 0 && timeouts > p.MaxTimeoutRate:
+		state.reason = fmt.Sprintf("timeout rate %.2f over %.2f", timeouts, p.MaxTimeoutRate)
+	default:
+		return "", false
+	}
+	state.degraded, state.since, state.probes = true, time.Now(), 0
+	return state.reason, true
+}
+
+// probed record a probe of a degraded trunk, true when it's restored.
+func (p *TrunkBreaker) probed(trunk string, answered bool) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	state := p.state(trunk)
+	if !state.degraded {
+		return false
+	}
+	if !answered {
+		state.probes = 0
+		return false
+	}
+	state.probes++
+	if state.probes < p.Recovery {
+		return false
+	}
+	p.trunks[trunk] = &trunkState{target: state.target, transport: state.transport}
+	return true
+}
+
+// Degraded the trunk is skipped by the routing.
+func (p *TrunkBreaker) Degraded(trunk string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	state, found := p.trunks[trunk]
+	return found && state.degraded
+}
+
+// Restore a degraded trunk manually.
+func (p *TrunkBreaker) Restore(trunk string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.trunks, trunk)
+}
+
+// Health of the trunks which had calls, by name.
+func (p *TrunkBreaker) Health() []TrunkHealth {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	health := []TrunkHealth{}
+	for trunk, state := range p.trunks {
+		calls, asr, timeouts := state.rates()
+		health = append(health, TrunkHealth{
+			Trunk:       trunk,
+			Calls:       calls,
+			ASR:         asr,
+			TimeoutRate: timeouts,
+			Degraded:    state.degraded,
+			Since:       state.since,
+			Reason:      state.reason,
+		})
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].Trunk < health[j].Trunk })
+	return health
+}
+
+// Handler GET the health of the trunks as JSON, POST with the restore query parameter restores a trunk.
+func (p *TrunkBreaker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			p.Restore(r.URL.Query().Get("restore"))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.Health())
+	})
+}
+
+// SetTrunkBreaker enable the circuit breaker of the trunks, nil to disable.
+func (b *B2BUA) SetTrunkBreaker(breaker *TrunkBreaker) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if b.trunkBreaker != nil {
+		close(b.trunkBreaker.stop)
+	}
+	b.trunkBreaker = breaker
+	if breaker != nil {
+		breaker.stop = make(chan struct{})
+		go b.probeTrunks(breaker)
+	}
+}
+
+// GetTrunkBreaker .
+func (b *B2BUA) GetTrunkBreaker() *TrunkBreaker {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.trunkBreaker
+}
+
+// recordTrunkCall record the outcome of the B-Leg of call, if sent to a trunk.
+func (b *B2BUA) recordTrunkCall(call *B2BCall, outcome trunkOutcome) {
+	breaker := b.GetTrunkBreaker()
+	if breaker == nil || len(call.trunk) == 0 {
+		return
+	}
+	target := ""
+	if req := call.dest.Request(); req != nil {
+		target = req.Destination()
+	}
+	if reason, degraded := breaker.record(call.trunk, target, call.transport, outcome); degraded {
+		logger.Warnf("Trunk %s degraded: %s", call.trunk, reason)
+		b.publish(&events.Event{Type: events.TrunkDegraded, Trunk: call.trunk, Reason: reason})
+	}
+}
+
+// recordTrunkFailure record the failure of sess, the B-Leg of a call, with resp, nil if it timed out.
+func (b *B2BUA) recordTrunkFailure(sess *session.Session, resp *sip.Response) {
+	call := b.findCall(sess)
+	if call == nil || call.dest != sess {
+		return
+	}
+	outcome := outcomeTimeout
+	if resp != nil && *resp != nil && (*resp).StatusCode() != 408 {
+		if (*resp).StatusCode() == 487 {
+			// Cancelled by the caller.
+			return
+		}
+		outcome = outcomeFailed
+	}
+	b.recordTrunkCall(call, outcome)
+}
+
+// healthyTrunks the contacts which aren't on a degraded trunk, false if none.
+func (b *B2BUA) healthyTrunks(contacts *map[string]*registry.ContactInstance) (*map[string]*registry.ContactInstance, bool) {
+	breaker := b.GetTrunkBreaker()
+	if breaker == nil {
+		return contacts, len(*contacts) > 0
+	}
+	healthy := make(map[string]*registry.ContactInstance)
+	for source, instance := range *contacts {
+		if trunk := b.FindTrunk(instance.Source); trunk != nil && breaker.Degraded(trunk.Name) {
+			logger.Debugf("Skipping %v on degraded trunk %s", instance.Source, trunk.Name)
+			continue
+		}
+		healthy[source] = instance
+	}
+	return &healthy, len(healthy) > 0
+}
+
+// probeTrunks probe the degraded trunks every interval, until the breaker is replaced.
+func (b *B2BUA) probeTrunks(breaker *TrunkBreaker) {
+	interval := breaker.ProbeInterval
+	if interval <= 0 {
+		interval = DefaultBreakerProbeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-breaker.stop:
+			return
+		case <-ticker.C:
+		}
+		for _, trunk := range b.GetTrunks() {
+			if !breaker.Degraded(trunk.Name) {
+				continue
+			}
+			go b.probeTrunk(breaker, trunk)
+		}
+	}
+}
+
+// probeTrunk send an OPTIONS to the outbound proxy of a degraded trunk, else to the last target of its
+// calls, any response means it is back.
+func (b *B2BUA) probeTrunk(breaker *TrunkBreaker, trunk *Trunk) {
+	breaker.mutex.Lock()
+	state := breaker.state(trunk.Name)
+	target, transport := state.target, state.transport
+	breaker.mutex.Unlock()
+	if len(trunk.Routes) > 0 {
+		target = trunk.Routes[0].Host()
+		if port := trunk.Routes[0].Port(); port != nil {
+			target = fmt.Sprintf("%s:%d", target, *port)
+		}
+		transport = "udp"
+		if tp, ok := trunk.Routes[0].UriParams().Get("transport"); ok && tp != nil {
+			transport = tp.String()
+		}
+	}
+	if len(target) == 0 {
+		return
+	}
+	request, err := probeRequest(target, transport)
+	if err != nil {
+		logger.Errorf("Probe of trunk %s failed: %v", trunk.Name, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), breakerProbeTimeout)
+	defer cancel()
+	_, err = b.ua.RequestWithContext(ctx, request, nil, true, 1)
+	if reqErr, ok := err.(*sip.RequestError); ok && reqErr.Response != nil {
+		// Rejected, e.g. 405, the trunk is up.
+		err = nil
+	}
+	if breaker.probed(trunk.Name, err == nil) {
+		logger.Infof("Trunk %s restored", trunk.Name)
+		b.publish(&events.Event{Type: events.TrunkRestored, Trunk: trunk.Name})
+	}
+}
+
+// probeRequest an OPTIONS to target, host:port, over transport.
+func probeRequest(target string, transport string) (sip.Request, error) {
+	uri, err := parser.ParseSipUri("sip:" + target)
+	if err != nil {
+		return nil, err
+	}
+	builder := sip.NewRequestBuilder()
+	builder.SetMethod(sip.OPTIONS)
+	builder.SetRecipient(uri.Clone())
+	builder.SetFrom(&sip.Address{
+		Uri:    uri.Clone(),
+		Params: sip.NewParams().Add("tag", sip.String{Str: util.RandString(8)}),
+	})
+	builder.SetTo(&sip.Address{Uri: uri.Clone()})
+	callID := sip.CallID(util.RandString(32))
+	builder.SetCallID(&callID)
+	request, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	request.SetDestination(target)
+	request.SetTransport(transport)
+	return request, nil
+}
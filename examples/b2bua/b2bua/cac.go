@@ -0,0 +1,159 @@
+package b2bua
+
+import (
+	"sync"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// TrunkUsage calls and bandwidth in kbit/s reserved on a trunk.
+type TrunkUsage struct {
+	Calls     int
+	Bandwidth int
+}
+
+// CallAdmission call admission control, tracks the bandwidth reserved by the calls on each trunk.
+type CallAdmission struct {
+	mutex sync.Mutex
+	usage map[string]*TrunkUsage
+}
+
+// NewCallAdmission .
+func NewCallAdmission() *CallAdmission {
+	return &CallAdmission{
+		usage: make(map[string]*TrunkUsage),
+	}
+}
+
+// Reservation bandwidth reserved by a call on its trunks.
+type Reservation struct {
+	cac       *CallAdmission
+	trunks    []*Trunk
+	bandwidth int
+	released  bool
+}
+
+// Admit reserve bandwidth on the trunks of a call, lowest is the bandwidth of the cheapest
+// offered codecs. Returns 488 if the call can never fit in the budget of a trunk,
+// 503 if the budget is currently exhausted.
+func (c *CallAdmission) Admit(trunks []*Trunk, bandwidth int, lowest int) (*Reservation, sip.StatusCode) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, trunk := range trunks {
+		if trunk.Bandwidth <= 0 {
+			continue
+		}
+		if lowest > trunk.Bandwidth {
+			logger.Warnf("CAC: %d kbit/s call never fits in trunk %s, budget %d kbit/s", lowest, trunk.Name, trunk.Bandwidth)
+			return nil, 488
+		}
+		used := 0
+		if usage, found := c.usage[trunk.Name]; found {
+			used = usage.Bandwidth
+		}
+		if used+bandwidth > trunk.Bandwidth {
+			logger.Warnf("CAC: trunk %s exhausted, %d + %d > %d kbit/s", trunk.Name, used, bandwidth, trunk.Bandwidth)
+			return nil, 503
+		}
+	}
+	r := &Reservation{
+		cac:       c,
+		trunks:    trunks,
+		bandwidth: bandwidth,
+	}
+	for _, trunk := range trunks {
+		usage, found := c.usage[trunk.Name]
+		if !found {
+			usage = &TrunkUsage{}
+			c.usage[trunk.Name] = usage
+		}
+		usage.Calls++
+		usage.Bandwidth += bandwidth
+	}
+	return r, 200
+}
+
+// Usage .
+func (c *CallAdmission) Usage(trunk string) TrunkUsage {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if usage, found := c.usage[trunk]; found {
+		return *usage
+	}
+	return TrunkUsage{}
+}
+
+// Update adjust the reservation to the negotiated bandwidth, e.g. once the answer is received.
+func (r *Reservation) Update(bandwidth int) {
+	r.cac.mutex.Lock()
+	defer r.cac.mutex.Unlock()
+	if r.released {
+		return
+	}
+	for _, trunk := range r.trunks {
+		if usage, found := r.cac.usage[trunk.Name]; found {
+			usage.Bandwidth += bandwidth - r.bandwidth
+		}
+	}
+	r.bandwidth = bandwidth
+}
+
+// Release the bandwidth, once the call has ended.
+func (r *Reservation) Release() {
+	r.cac.mutex.Lock()
+	defer r.cac.mutex.Unlock()
+	if r.released {
+		return
+	}
+	r.released = true
+	for _, trunk := range r.trunks {
+		if usage, found := r.cac.usage[trunk.Name]; found {
+			usage.Calls--
+			usage.Bandwidth -= r.bandwidth
+			if usage.Calls <= 0 {
+				delete(r.cac.usage, trunk.Name)
+			}
+		}
+	}
+}
+
+func admissionReason(code sip.StatusCode) string {
+	if code == 488 {
+		return "Not Acceptable Here"
+	}
+	return "Service Unavailable"
+}
+
+// GetTrunkUsage .
+func (b *B2BUA) GetTrunkUsage(name string) TrunkUsage {
+	return b.cac.Usage(name)
+}
+
+// admit reserve the bandwidth of the offer on the trunks of the given peer addresses,
+// calls outside of any trunk are always admitted.
+func (b *B2BUA) admit(offer string, addrs ...string) (*Reservation, sip.StatusCode) {
+	trunks := []*Trunk{}
+	for _, addr := range addrs {
+		trunk := b.FindTrunk(addr)
+		if trunk == nil {
+			continue
+		}
+		duplicate := false
+		for _, t := range trunks {
+			duplicate = duplicate || t == trunk
+		}
+		if !duplicate {
+			trunks = append(trunks, trunk)
+		}
+	}
+	if len(trunks) == 0 {
+		return nil, 200
+	}
+	bandwidth, lowest, err := media.SessionBandwidth(offer)
+	if err != nil {
+		// Late offer or no SDP, the codecs are unknown until the answer.
+		bandwidth, lowest = media.CodecBandwidth("", media.DefaultPtime), 0
+	}
+	return b.cac.Admit(trunks, bandwidth, lowest)
+}
@@ -0,0 +1,71 @@
+package b2bua
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gosip/transport"
+)
+
+// B2BUAConfig the listeners and the settings of a B2BUA, to embed it in other services. A transport
+// without a listen address isn't served.
+type B2BUAConfig struct {
+	// Listen addresses by transport, host:port.
+	UDP string
+	TCP string
+	TLS string
+	WS  string
+	WSS string
+	// TLSCert and TLSKey paths of the certificate of the TLS and WSS listeners, PEM encoded.
+	TLSCert string
+	TLSKey  string
+	// DNS server of the SRV lookups, unless a resolver is set with WithResolver.
+	DNS string
+	// Realm of the digest challenges.
+	Realm string
+	// DisableAuth accept the requests without challenging them.
+	DisableAuth bool
+	// EmergencyDestination SIP URI the emergency calls are routed to unchallenged, e.g. the gateway of the
+	// PSAP, see ChallengePolicy.
+	EmergencyDestination string
+}
+
+// DefaultB2BUAConfig UDP and TCP on 5060, TLS on 5061 and WSS on 5081 on all the interfaces, with the
+// certificate of certs/.
+func DefaultB2BUAConfig() *B2BUAConfig {
+	return &B2BUAConfig{
+		UDP:     "0.0.0.0:5060",
+		TCP:     "0.0.0.0:5060",
+		TLS:     "0.0.0.0:5061",
+		WSS:     "0.0.0.0:5081",
+		TLSCert: "certs/cert.pem",
+		TLSKey:  "certs/key.pem",
+		DNS:     "8.8.8.8",
+		Realm:   "b2bua",
+	}
+}
+
+// listen start the listeners of the config.
+func (b *B2BUA) listen(config *B2BUAConfig) error {
+	for _, listener := range []struct{ network, address string }{
+		{"udp", config.UDP}, {"tcp", config.TCP}, {"ws", config.WS},
+	} {
+		if len(listener.address) == 0 {
+			continue
+		}
+		if err := b.stack.Listen(listener.network, listener.address); err != nil {
+			return fmt.Errorf("%s listen on %s: %v", listener.network, listener.address, err)
+		}
+	}
+	tlsOptions := &transport.TLSConfig{Cert: config.TLSCert, Key: config.TLSKey}
+	for _, listener := range []struct{ network, address string }{
+		{"tls", config.TLS}, {"wss", config.WSS},
+	} {
+		if len(listener.address) == 0 {
+			continue
+		}
+		if err := b.stack.ListenTLS(listener.network, listener.address, tlsOptions); err != nil {
+			return fmt.Errorf("%s listen on %s: %v", listener.network, listener.address, err)
+		}
+	}
+	return nil
+}
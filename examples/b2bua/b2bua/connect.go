@@ -0,0 +1,145 @@
+package b2bua
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/account"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// glareDelay before a re-INVITE is sent again after a 491, RFC 3261 section 14.1.
+const glareDelay = 2100 * time.Millisecond
+
+// BridgeCall bridge the incoming call of sess, taken over by the OnIncomingCall handler, to target: a
+// number of the domain of the called party, or a SIP URI. The call may have been answered already,
+// connect-first, e.g. to play an announcement or collect digits: the B-Leg is offered the SDP of the
+// caller, and the caller is re-INVITEd with the SDP of the callee once it answers.
+func (b *B2BUA) BridgeCall(sess *session.Session, target string) error {
+	req := sess.Request()
+	if req == nil || sess.IsEnded() {
+		return fmt.Errorf("bridge: the call has ended")
+	}
+	from, _ := req.From()
+	to, _ := req.To()
+	caller := from.Address
+	route := &Route{Called: to.Address}
+	decision := &RouteDecision{Action: RouteTo, Target: target}
+	if code, reason := decision.apply(route); code != 0 {
+		return fmt.Errorf("bridge to %s: %d %s", target, code, reason)
+	}
+	displayName := ""
+	if from.DisplayName != nil {
+		displayName = from.DisplayName.String()
+	}
+	contacts, err := b.routeContacts(route)
+	if err != nil {
+		return fmt.Errorf("bridge to %s: %v", target, err)
+	}
+	calls, err := b.bridgeLegs(sess, caller, displayName, route, contacts, b.newCorrelationID())
+	if err != nil {
+		return fmt.Errorf("bridge to %s: %v", target, err)
+	}
+	for _, call := range calls {
+		b.addCall(call)
+	}
+	// The B2BUA handles the session from now.
+	b.callsLock.Lock()
+	delete(b.takenOver, sess)
+	b.callsLock.Unlock()
+	logger.Infof("Call from [%v] bridged to [%v], connected first: %v", caller, route.Called, sess.IsEstablished())
+	return nil
+}
+
+// routeContacts the explicit destinations of route, else the reachable contacts of route.Called, the
+// degraded trunks excepted.
+func (b *B2BUA) routeContacts(route *Route) (*map[string]*registry.ContactInstance, error) {
+	if route.Called.User() == nil {
+		return nil, fmt.Errorf("%v: no user", route.Called)
+	}
+	contacts, found := route.contacts()
+	if !found {
+		if contacts, found = b.registry.GetContacts(route.Called); found {
+			contacts, found = b.reachableContacts(contacts)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%v not found", route.Called)
+	}
+	if contacts, found = b.healthyTrunks(contacts); !found {
+		return nil, fmt.Errorf("the trunks of %v are degraded", route.Called)
+	}
+	return contacts, nil
+}
+
+// bridgeLegs INVITE the contacts of route.Called from caller, offering the SDP of sess, the B-Legs are
+// returned to be added by the caller.
+func (b *B2BUA) bridgeLegs(sess *session.Session, caller sip.Uri, displayName string, route *Route, contacts *map[string]*registry.ContactInstance, correlationID string) ([]*B2BCall, error) {
+	route.MediaSecurity = b.GetMediaSecurityPolicy(route.Called.User().String())
+	connected := sess.IsEstablished()
+	offer := sess.RemoteSdp()
+	calls := []*B2BCall{}
+	fork := newForkState(b.GetEarlyMediaPolicy())
+	for _, instance := range *contacts {
+		profile := account.NewProfile(caller, displayName, nil, 0, b.stack)
+		trunkName := ""
+		if trunk := b.FindTrunk(instance.Source); trunk != nil {
+			profile.Routes = trunk.Routes
+			trunkName = trunk.Name
+		}
+		transport := b.routeLeg(instance.Source, instance.Transport, profile)
+		recipient, err := parser.ParseSipUri("sip:" + route.Called.User().String() + "@" + instance.Source + ";transport=" + transport)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		body := offer
+		headers := append([]sip.Header{legCallID(correlationID)}, route.Headers...)
+		dest, err := b.ua.InviteWithHeaders(context.TODO(), profile, route.Called, recipient, &body, "", headers)
+		if err != nil {
+			logger.Errorf("B-Leg session error: %v", err)
+			continue
+		}
+		b.dialogs.Add(dest)
+		calls = append(calls, &B2BCall{
+			src:           sess,
+			dest:          dest,
+			ringback:      RingbackForward,
+			mediaSecurity: route.MediaSecurity,
+			timing:        CallTiming{Setup: time.Now()},
+			connected:     connected,
+			fork:          fork,
+			transport:     transport,
+			trunk:         trunkName,
+			correlationID: correlationID,
+		})
+	}
+	if len(calls) == 0 {
+		return nil, fmt.Errorf("no B-Leg")
+	}
+	return calls, nil
+}
+
+// connectMedia re-INVITE the caller, answered first or with the early SDP of another branch, with the SDP
+// of the callee, once more after a glare, the call is hung up if the caller refuses it.
+func (b *B2BUA) connectMedia(call *B2BCall, answer string) {
+	b.offerLeg(call, call.src, answer)
+}
+
+// offerLeg re-INVITE the leg sess of call with sdp, once more after a glare, the call is hung up if the leg
+// refuses it.
+func (b *B2BUA) offerLeg(call *B2BCall, sess *session.Session, sdp string) {
+	_, err := sess.Offer(sdp)
+	if reqErr, ok := err.(*sip.RequestError); ok && reqErr.Code == 491 {
+		time.Sleep(glareDelay)
+		_, err = sess.Offer(sdp)
+	}
+	if err != nil {
+		logger.Errorf("Call %v: re-INVITE of %v failed: %v", call.ToString(), sess.CallID(), err)
+		b.hangup(call)
+	}
+}
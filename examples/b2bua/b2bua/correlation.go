@@ -0,0 +1,27 @@
+package b2bua
+
+import (
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/util"
+)
+
+// newCorrelationID the ID linking the legs of a call in the logs, the CDRs and the events, prefixed with
+// the instance ID behind a load balancer so that the aggregated logs tell which instance handled it.
+func (b *B2BUA) newCorrelationID() string {
+	id := util.RandString(16)
+	if instance := b.stack.InstanceID(); len(instance) > 0 {
+		return instance + "-" + id
+	}
+	return id
+}
+
+// legCallID the Call-ID of a B-Leg of the call of correlationID, its prefix, unique to each branch.
+func legCallID(correlationID string) *sip.CallID {
+	callID := sip.CallID(correlationID + "-" + util.RandString(8))
+	return &callID
+}
+
+// CorrelationID the ID linking both legs of the call.
+func (call *B2BCall) CorrelationID() string {
+	return call.correlationID
+}
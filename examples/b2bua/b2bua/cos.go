@@ -0,0 +1,150 @@
+package b2bua
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// ClassOfService the destinations an account may call, each class including the lower ones.
+type ClassOfService string
+
+const (
+	// ClassInternal the registered users and the internal numbers only.
+	ClassInternal ClassOfService = "internal"
+	// ClassNational the national numbers too.
+	ClassNational ClassOfService = "national"
+	// ClassInternational the international numbers too.
+	ClassInternational ClassOfService = "international"
+	// ClassPremium any number, the premium rate ones included.
+	ClassPremium ClassOfService = "premium"
+)
+
+// rank of the class, -1 if unknown.
+func (c ClassOfService) rank() int {
+	switch c {
+	case ClassInternal:
+		return 0
+	case ClassNational:
+		return 1
+	case ClassInternational:
+		return 2
+	case ClassPremium:
+		return 3
+	}
+	return -1
+}
+
+// CallAuthorizer decides whether the account username, authenticated, may call called, the number after
+// the dial plan; the denied calls are answered 403 with reason, Forbidden if empty.
+type CallAuthorizer func(username string, called sip.Uri) (bool, string)
+
+// SetCallAuthorizer authorize the calls of the authenticated accounts by authorizer, nil to allow them all.
+// The emergency calls and the calls not challenged, e.g. from the trunks of the trusted networks, are not
+// submitted to it.
+func (b *B2BUA) SetCallAuthorizer(authorizer CallAuthorizer) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.callAuthorizer = authorizer
+}
+
+// GetCallAuthorizer .
+func (b *B2BUA) GetCallAuthorizer() CallAuthorizer {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.callAuthorizer
+}
+
+// callAllowed whether the account authenticated by request may call called, returns its username and the
+// reason of the denial.
+func (b *B2BUA) callAllowed(request sip.Request, called sip.Uri) (string, string, bool) {
+	authorizer := b.GetCallAuthorizer()
+	if authorizer == nil {
+		return "", "", true
+	}
+	username, authenticated := b.authenticatedUser(request)
+	if !authenticated {
+		return "", "", true
+	}
+	allowed, reason := authorizer(username, called)
+	if len(reason) == 0 {
+		reason = "Forbidden"
+	}
+	return username, reason, allowed
+}
+
+// ClassOfServicePolicy a CallAuthorizer by the class of service of the accounts and the class of the called
+// numbers, found by their longest matching prefix, the numbers matching none being internal.
+type ClassOfServicePolicy struct {
+	// Classes of the accounts, Default of the others.
+	Classes map[string]ClassOfService `json:"classes"`
+	Default ClassOfService            `json:"default"`
+	// National, International and Premium the prefixes of the numbers of each class, e.g. "0", "00" and "+",
+	// "0899".
+	National      []string `json:"national"`
+	International []string `json:"international"`
+	Premium       []string `json:"premium"`
+	// Reason of the 403 of the denied calls, Forbidden if empty.
+	Reason string `json:"reason"`
+}
+
+// NewClassOfServicePolicy .
+func NewClassOfServicePolicy() *ClassOfServicePolicy {
+	return &ClassOfServicePolicy{Classes: make(map[string]ClassOfService), Default: ClassNational}
+}
+
+// LoadClassOfServicePolicy the ClassOfServicePolicy of the JSON object of path.
+func LoadClassOfServicePolicy(path string) (*ClassOfServicePolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := NewClassOfServicePolicy()
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("bad class of service policy %s: %v", path, err)
+	}
+	if policy.Default.rank() < 0 {
+		return nil, fmt.Errorf("bad class of service policy %s: unknown default class %q", path, policy.Default)
+	}
+	for account, class := range policy.Classes {
+		if class.rank() < 0 {
+			return nil, fmt.Errorf("bad class of service policy %s: unknown class %q of %s", path, class, account)
+		}
+	}
+	return policy, nil
+}
+
+// Class of the number.
+func (p *ClassOfServicePolicy) Class(number string) ClassOfService {
+	class, length := ClassInternal, 0
+	for _, prefixes := range []struct {
+		class    ClassOfService
+		prefixes []string
+	}{{ClassNational, p.National}, {ClassInternational, p.International}, {ClassPremium, p.Premium}} {
+		for _, prefix := range prefixes.prefixes {
+			if len(prefix) > length && strings.HasPrefix(number, prefix) {
+				class, length = prefixes.class, len(prefix)
+			}
+		}
+	}
+	return class
+}
+
+// Authorize the calls of username to the numbers of its class of service, a CallAuthorizer.
+func (p *ClassOfServicePolicy) Authorize(username string, called sip.Uri) (bool, string) {
+	if called.User() == nil {
+		return true, ""
+	}
+	class, found := p.Classes[username]
+	if !found {
+		class = p.Default
+	}
+	number := p.Class(called.User().String())
+	if number.rank() <= class.rank() {
+		return true, ""
+	}
+	return false, p.Reason
+}
@@ -0,0 +1,62 @@
+package b2bua
+
+import (
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/auth"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// SetDigestVerifier delegate the digest authentication, e.g. to a RADIUS server, nil to authenticate
+// with the local accounts.
+func (b *B2BUA) SetDigestVerifier(verifier auth.VerifyDigestCallback) {
+	if b.authenticator != nil {
+		b.authenticator.SetDigestVerifier(verifier)
+	}
+}
+
+// SetCredentialProvider look up the credentials in an external backend, e.g. LDAP, instead of the
+// local accounts, nil to use the local accounts.
+func (b *B2BUA) SetCredentialProvider(provider auth.RequestCredentialCallback) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.credentialProvider = provider
+}
+
+// SetBearerVerifier accept the bearer tokens of an identity provider (RFC 8898) as an alternative
+// to digest, authzServer is advertised in the challenges, nil to disable.
+func (b *B2BUA) SetBearerVerifier(verifier auth.VerifyBearerCallback, authzServer string) {
+	if b.authenticator != nil {
+		b.authenticator.SetBearerVerifier(verifier, authzServer)
+	}
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.bearerVerifier = verifier
+}
+
+// bearerExpires cap the registration expiry of a request authenticated by a bearer token
+// to the expiry of the token.
+func (b *B2BUA) bearerExpires(request sip.Request, expires uint32) uint32 {
+	b.configLock.RLock()
+	verifier := b.bearerVerifier
+	b.configLock.RUnlock()
+	if verifier == nil || expires == 0 {
+		return expires
+	}
+	token, ok := auth.BearerToken(request)
+	if !ok {
+		return expires
+	}
+	_, expiry, err := verifier(token, request)
+	if err != nil || expiry.IsZero() {
+		return expires
+	}
+	remaining := uint32(1)
+	if d := time.Until(expiry); d > time.Second {
+		remaining = uint32(d / time.Second)
+	}
+	if remaining < expires {
+		return remaining
+	}
+	return expires
+}
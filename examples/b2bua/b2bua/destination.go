@@ -0,0 +1,158 @@
+package b2bua
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/account"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// DestinationPolicy how the B-Legs and the trunk calls reach a destination, e.g. from a multi-homed host
+// facing several networks.
+type DestinationPolicy struct {
+	// Transport of the B-Legs, udp, tcp, tls, ws or wss, the one of the contact if empty.
+	Transport string
+	// Family the IP family a domain is dialed at, its first address if empty.
+	Family stack.IPFamily
+	// Source the local address the B-Legs are sent from, advertised in their Via and Contact, the one of
+	// the stack if nil.
+	Source net.IP
+	// TelURI send the Request-URI, To and From of telephone numbers as tel: URIs, e.g. to a trunk.
+	TelURI bool
+}
+
+// DestinationRule the policy of the destinations in a network, or of a domain and its subdomains.
+type DestinationRule struct {
+	// Match the CIDR, IP address or domain of the rule.
+	Match   string
+	Network *net.IPNet
+	Domain  string
+	Policy  DestinationPolicy
+}
+
+// NewDestinationRule the rule of a CIDR (10.0.0.0/8), an IP address or a domain (carrier.com).
+func NewDestinationRule(match string, policy DestinationPolicy) (*DestinationRule, error) {
+	rule := &DestinationRule{Match: match, Policy: policy}
+	switch strings.ToLower(policy.Transport) {
+	case "", "udp", "tcp", "tls", "ws", "wss":
+	default:
+		return nil, fmt.Errorf("destination %s: unknown transport %s", match, policy.Transport)
+	}
+	switch policy.Family {
+	case stack.AnyFamily, stack.IPv4, stack.IPv6:
+	default:
+		return nil, fmt.Errorf("destination %s: unknown IP family %s", match, policy.Family)
+	}
+	if strings.Contains(match, "/") {
+		_, network, err := net.ParseCIDR(match)
+		if err != nil {
+			return nil, err
+		}
+		rule.Network = network
+		return rule, nil
+	}
+	if ip := net.ParseIP(match); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		rule.Network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return rule, nil
+	}
+	rule.Domain = strings.ToLower(strings.TrimPrefix(match, "*."))
+	return rule, nil
+}
+
+// Matches host, an address or a domain, with or without port, is a destination of the rule.
+func (r *DestinationRule) Matches(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return r.Network != nil && r.Network.Contains(ip)
+	}
+	host = strings.ToLower(host)
+	return len(r.Domain) > 0 && (host == r.Domain || strings.HasSuffix(host, "."+r.Domain))
+}
+
+// AddDestinationRule add the rule of match, a CIDR, an IP address or a domain, or replace it.
+func (b *B2BUA) AddDestinationRule(match string, policy DestinationPolicy) error {
+	rule, err := NewDestinationRule(match, policy)
+	if err != nil {
+		return err
+	}
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	for idx, r := range b.destinations {
+		if r.Match == match {
+			b.destinations[idx] = rule
+			return nil
+		}
+	}
+	b.destinations = append(b.destinations, rule)
+	return nil
+}
+
+// RemoveDestinationRule .
+func (b *B2BUA) RemoveDestinationRule(match string) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	for idx, r := range b.destinations {
+		if r.Match == match {
+			b.destinations = append(b.destinations[:idx], b.destinations[idx+1:]...)
+			return
+		}
+	}
+}
+
+// GetDestinationRules .
+func (b *B2BUA) GetDestinationRules() []*DestinationRule {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return append([]*DestinationRule{}, b.destinations...)
+}
+
+// DestinationPolicy the policy of the first rule matching host, false if none.
+func (b *B2BUA) DestinationPolicy(host string) (DestinationPolicy, bool) {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	for _, rule := range b.destinations {
+		if rule.Matches(host) {
+			return rule.Policy, true
+		}
+	}
+	return DestinationPolicy{}, false
+}
+
+// destinationRoute the route of the stack to host.
+func (b *B2BUA) destinationRoute(host string) (stack.DestinationRoute, bool) {
+	policy, found := b.DestinationPolicy(host)
+	if !found || (policy.Family == stack.AnyFamily && policy.Source == nil && !policy.TelURI) {
+		return stack.DestinationRoute{}, false
+	}
+	return stack.DestinationRoute{Family: policy.Family, Source: policy.Source, TelURI: policy.TelURI}, true
+}
+
+// routeLeg the transport of the B-Leg to host over transport, and its Contact in profile, per the
+// destination policy of host.
+func (b *B2BUA) routeLeg(host string, transport string, profile *account.Profile) string {
+	policy, found := b.DestinationPolicy(host)
+	if !found {
+		return transport
+	}
+	if len(policy.Transport) > 0 {
+		transport = strings.ToLower(policy.Transport)
+	}
+	if policy.Source != nil && profile.ContactURI != nil {
+		contact := profile.ContactURI.Clone()
+		contact.SetHost(policy.Source.String())
+		if params := contact.UriParams(); params != nil && params.Has("transport") {
+			contact.SetUriParams(params.Clone().Add("transport", sip.String{Str: transport}))
+		}
+		profile.ContactURI = contact
+	}
+	return transport
+}
@@ -0,0 +1,131 @@
+package b2bua
+
+import (
+	"sync"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// DialogMatcher check if an in-dialog request belongs to a known dialog.
+type DialogMatcher interface {
+	MatchDialog(req sip.Request) bool
+}
+
+// DialogTracker keeps track of the dialogs (A-Leg and B-Leg sessions) established through the B2BUA.
+type DialogTracker struct {
+	mutex   *sync.RWMutex
+	dialogs map[sip.CallID][]*session.Session
+}
+
+// NewDialogTracker .
+func NewDialogTracker() *DialogTracker {
+	return &DialogTracker{
+		mutex:   new(sync.RWMutex),
+		dialogs: make(map[sip.CallID][]*session.Session),
+	}
+}
+
+// Add .
+func (t *DialogTracker) Add(sess *session.Session) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	callID := *sess.CallID()
+	for _, s := range t.dialogs[callID] {
+		if s == sess {
+			return
+		}
+	}
+	t.dialogs[callID] = append(t.dialogs[callID], sess)
+}
+
+// Remove .
+func (t *DialogTracker) Remove(sess *session.Session) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	callID := *sess.CallID()
+	sessions := t.dialogs[callID]
+	for idx, s := range sessions {
+		if s == sess {
+			sessions = append(sessions[:idx], sessions[idx+1:]...)
+			break
+		}
+	}
+	if len(sessions) == 0 {
+		delete(t.dialogs, callID)
+		return
+	}
+	t.dialogs[callID] = sessions
+}
+
+// Length number of tracked sessions.
+func (t *DialogTracker) Length() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	count := 0
+	for _, sessions := range t.dialogs {
+		count += len(sessions)
+	}
+	return count
+}
+
+// MatchDialog match Call-ID, From tag and To tag of req against the tracked sessions.
+func (t *DialogTracker) MatchDialog(req sip.Request) bool {
+	callID, ok := req.CallID()
+	if !ok {
+		return false
+	}
+	fromTag := getTag(req.From())
+	toTag := getTag(req.To())
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	for _, sess := range t.dialogs[*callID] {
+		localTag := addressTag(sess.LocalURI())
+		remoteTag := addressTag(sess.RemoteURI())
+		if (fromTag == remoteTag && toTag == localTag) || (fromTag == localTag && toTag == remoteTag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dialogs .
+func (b *B2BUA) Dialogs() *DialogTracker {
+	return b.dialogs
+}
+
+// isInDialog a request with a To tag is sent within a dialog, https://tools.ietf.org/html/rfc3261#section-12.2
+func isInDialog(req sip.Request) bool {
+	return len(getTag(req.To())) > 0
+}
+
+func addressTag(address sip.Address) string {
+	if address.Params != nil {
+		if tag, ok := address.Params.Get("tag"); ok && tag != nil {
+			return tag.String()
+		}
+	}
+	return ""
+}
+
+func getTag(header interface{}, ok bool) string {
+	if !ok {
+		return ""
+	}
+	switch hdr := header.(type) {
+	case *sip.FromHeader:
+		if hdr != nil && hdr.Params != nil {
+			if tag, ok := hdr.Params.Get("tag"); ok && tag != nil {
+				return tag.String()
+			}
+		}
+	case *sip.ToHeader:
+		if hdr != nil && hdr.Params != nil {
+			if tag, ok := hdr.Params.Get("tag"); ok && tag != nil {
+				return tag.String()
+			}
+		}
+	}
+	return ""
+}
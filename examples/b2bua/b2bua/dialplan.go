@@ -0,0 +1,96 @@
+package b2bua
+
+import (
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// dialPlan apply the OnIncomingCall handler, the feature codes, ENUM, the Router, the time routing, the
+// screening and the call forwarding to the called party, returns the route of the call, or nil if the call
+// has been handled, the caller being answered unless setup timed out.
+func (b *B2BUA) dialPlan(sess *session.Session, setup *callSetup, req sip.Request, caller sip.Uri, called sip.Uri) *Route {
+	route := &Route{Called: called}
+	if !b.intercept(sess, setup, req, caller, route) {
+		return nil
+	}
+	called = route.Called
+	if called.User() == nil {
+		return route
+	}
+	route.MediaSecurity = b.GetMediaSecurityPolicy(called.User().String())
+	if len(route.Targets) > 0 {
+		// Bridged to an explicit destination by the application.
+		return route
+	}
+	if b.handleFeatureCode(sess, setup, req, caller, called.User().String()) {
+		return nil
+	}
+	if code, reason := b.routeNumber(req, caller, route); code != 0 {
+		b.rejectSetup(setup, sess, code, reason)
+		return nil
+	}
+	return route
+}
+
+// routeNumber apply ENUM, the Router, the time routing, the screening and the call forwarding to
+// route.Called, returns the status code rejecting the call, 0 to route it.
+func (b *B2BUA) routeNumber(req sip.Request, caller sip.Uri, route *Route) (sip.StatusCode, string) {
+	called := route.Called
+	if target, found := b.enumTarget(called); found {
+		logger.Infof("Call to [%v] routed to [%s] by ENUM", called, target)
+		decision := &RouteDecision{Action: RouteTo, Target: target}
+		if code, reason := decision.apply(route); code != 0 {
+			return code, reason
+		}
+		if len(route.Targets) > 0 {
+			return 0, ""
+		}
+		called = route.Called
+	}
+	if router := b.GetRouter(); router != nil {
+		decision, err := router.Route(newRouteRequest(req, caller, called))
+		if err != nil {
+			logger.Errorf("External routing of [%v] failed: %v", called, err)
+			return 503, "Service Unavailable"
+		}
+		if code, reason := decision.apply(route); code != 0 {
+			logger.Infof("Call from [%v] to [%v] rejected by the external router", caller, called)
+			return code, reason
+		}
+		if len(route.Targets) > 0 {
+			// Routed to an explicit destination, the internal rules don't apply.
+			logger.Infof("Call to [%v] routed to [%s] by the external router", called, decision.Target)
+			return 0, ""
+		}
+		called = route.Called
+	}
+	if target, diverted := b.timeTarget(called.User().String()); diverted {
+		logger.Infof("Call to [%v] routed to [%s] outside the open hours", called, target)
+		called = divert(called, target)
+	}
+	action, voicemail := b.screen(req, caller, called.User().String())
+	switch action {
+	case ScreenReject:
+		logger.Infof("Call from [%v] to [%v] rejected by screening", caller, called)
+		if IsAnonymous(req) {
+			return 433, "Anonymity Disallowed"
+		}
+		return 603, "Decline"
+	case ScreenVoicemail:
+		logger.Infof("Call from [%v] to [%v] sent to voicemail [%s] by screening", caller, called, voicemail)
+		called = divert(called, voicemail)
+	}
+	if target, found := b.GetCallForward(called.User().String()); found && action == ScreenAllow {
+		logger.Infof("Call to [%v] forwarded to [%s]", called, target)
+		called = divert(called, target)
+	}
+	route.Called = called
+	return 0, ""
+}
+
+// divert the called party to user.
+func divert(called sip.Uri, user string) sip.Uri {
+	called = called.Clone()
+	called.SetUser(sip.String{Str: user})
+	return called
+}
@@ -0,0 +1,156 @@
+package b2bua
+
+import (
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
+)
+
+// StackOption customize the SIP stack config of the B2BUA.
+type StackOption func(config *stack.SipStackConfig)
+
+// WithReusePort listen with SO_REUSEPORT, so that a new process can bind the same ports.
+func WithReusePort() StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.ReusePort = true
+	}
+}
+
+// WithHandoff take over the listening sockets of the B2BUA serving the handoff socket at path,
+// and serve it for the next process.
+func WithHandoff(path string) StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.HandoffPath = path
+	}
+}
+
+// WithInstanceID name the B2BUA in the Via and the Contact it sends, to run it behind a SIP load
+// balancer with other instances.
+func WithInstanceID(id string) StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.InstanceID = id
+	}
+}
+
+// WithHost advertise host in the Via and the Contact instead of the address of the interface,
+// e.g. the pod IP of the downward API.
+func WithHost(host string) StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.Host = host
+	}
+}
+
+// WithWebSocketPath upgrade only the WS/WSS requests of path, e.g. the path of an ingress.
+func WithWebSocketPath(path string) StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.WebSocketPath = path
+	}
+}
+
+// WithTransportSecurity send the requests to the destinations of policy over TLS only.
+func WithTransportSecurity(policy *stack.TransportSecurityPolicy) StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.TransportSecurity = policy
+	}
+}
+
+// WithSignalingTOS mark the signaling packets with the TOS byte, e.g. 0x60 for CS3.
+func WithSignalingTOS(tos int) StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.SignalingTOS = tos
+	}
+}
+
+// WithTelURI accept the tel: URIs, routed in their SIP form at the host of the B2BUA with user=phone.
+func WithTelURI() StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.AcceptTelURI = true
+	}
+}
+
+// WithUserAgent send userAgent in the User-Agent of the requests and server in the Server header of the
+// responses, userAgent if empty.
+func WithUserAgent(userAgent string, server string) StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.UserAgent = userAgent
+		config.Server = server
+	}
+}
+
+// WithHiddenUserAgent send neither User-Agent nor Server header.
+func WithHiddenUserAgent() StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.HideUserAgent = true
+	}
+}
+
+// WithHeaderOrder send these headers first, in this order, e.g. stack.DefaultHeaderOrder.
+func WithHeaderOrder(names ...string) StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.HeaderOrder = names
+	}
+}
+
+// WithScannerFilter drop or tarpit the requests of the SIP scanners matching filter.
+func WithScannerFilter(filter *stack.ScannerFilter) StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.ScannerFilter = filter
+	}
+}
+
+// WithTLSVerify verify the certificates of the TLS peers dialed against their SIP domain, RFC 5922, the
+// TLS of the trunks overriding it.
+func WithTLSVerify(policy *stack.TLSVerifyPolicy) StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.TLSVerify = policy
+	}
+}
+
+// WithResolver resolve the targets with the resolver of config, over TLS or HTTPS, checking DNSSEC.
+func WithResolver(config *stack.ResolverConfig) StackOption {
+	return func(stackConfig *stack.SipStackConfig) {
+		stackConfig.Resolver = config
+	}
+}
+
+// WithDialogRuntime process the messages of each dialog on a goroutine of its own, with a mailbox of
+// mailboxSize messages, instead of the 64 workers shared by the dialogs.
+func WithDialogRuntime(mailboxSize int) StackOption {
+	return func(config *stack.SipStackConfig) {
+		config.DialogMailboxSize = mailboxSize
+	}
+}
+
+// Drain stop accepting new calls and registrations, the returned channel is closed
+// once all the calls have ended.
+func (b *B2BUA) Drain() <-chan struct{} {
+	b.configLock.Lock()
+	b.draining = true
+	b.configLock.Unlock()
+
+	logger.Infof("Draining %d calls", len(b.Calls()))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if len(b.Calls()) == 0 {
+				return
+			}
+		}
+	}()
+	return done
+}
+
+// IsDraining .
+func (b *B2BUA) IsDraining() bool {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.draining
+}
+
+// OnHandoff handler called once the listening sockets have been handed off to a new process.
+func (b *B2BUA) OnHandoff(handler func()) {
+	b.stack.OnHandoff(handler)
+}
@@ -0,0 +1,151 @@
+package b2bua
+
+import (
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+var (
+	// WarningToneBeep 1400 Hz beeps, played before the hangup of a call reaching its maximum duration.
+	WarningToneBeep = media.Tone{Frequencies: []float64{1400}, On: 200 * time.Millisecond, Off: 800 * time.Millisecond}
+)
+
+// DurationPolicy enforcement of the maximum call duration, e.g. for prepaid calls.
+type DurationPolicy struct {
+	// Warning time before the hangup at which the warning tone is played to the caller, 0 for no warning.
+	Warning time.Duration
+	// WarningTone and WarningLength of the warning announcement.
+	WarningTone   media.Tone
+	WarningLength time.Duration
+	// ReasonProtocol, ReasonCause and ReasonText of the Reason header of the BYE sent on both legs.
+	ReasonProtocol string
+	ReasonCause    int
+	ReasonText     string
+}
+
+// NewDurationPolicy .
+func NewDurationPolicy() *DurationPolicy {
+	return &DurationPolicy{
+		WarningTone:    WarningToneBeep,
+		WarningLength:  3 * time.Second,
+		ReasonProtocol: "SIP",
+		ReasonCause:    200,
+		ReasonText:     "Call duration limit reached",
+	}
+}
+
+// SetDurationPolicy .
+func (b *B2BUA) SetDurationPolicy(policy *DurationPolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.durationPolicy = policy
+}
+
+// GetDurationPolicy .
+func (b *B2BUA) GetDurationPolicy() *DurationPolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.durationPolicy
+}
+
+// SetMaxCallDuration set the maximum duration of the calls of an account, 0 for unlimited.
+func (b *B2BUA) SetMaxCallDuration(username string, duration time.Duration) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if duration <= 0 {
+		delete(b.maxDurations, username)
+		return
+	}
+	b.maxDurations[username] = duration
+}
+
+// GetMaxCallDuration .
+func (b *B2BUA) GetMaxCallDuration(username string) time.Duration {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.maxDurations[username]
+}
+
+// shorterDuration the shortest of the limits, 0 for unlimited.
+func shorterDuration(a, b time.Duration) time.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// maxCallDuration the limit of the call of username between the given peer addresses.
+func (b *B2BUA) maxCallDuration(username string, addrs ...string) time.Duration {
+	limit := b.GetMaxCallDuration(username)
+	for _, addr := range addrs {
+		if trunk := b.FindTrunk(addr); trunk != nil {
+			limit = shorterDuration(limit, trunk.MaxDuration)
+		}
+	}
+	return limit
+}
+
+// superviseDuration schedule the warning and the hangup of an answered call.
+func (b *B2BUA) superviseDuration(call *B2BCall) {
+	maxDuration := call.maxDuration
+	if maxDuration <= 0 {
+		return
+	}
+	policy := b.GetDurationPolicy()
+	if policy.Warning > 0 && policy.Warning < maxDuration {
+		call.schedule(maxDuration-policy.Warning, func() {
+			b.playWarning(call, policy)
+		})
+	}
+	call.schedule(maxDuration, func() {
+		logger.Infof("Call %v reached its maximum duration %v", call.ToString(), maxDuration)
+		b.hangup(call, utils.NewReasonHeader(policy.ReasonProtocol, policy.ReasonCause, policy.ReasonText))
+	})
+}
+
+// playWarning play the warning tone to the caller, the caller media is redirected to the B2BUA
+// with a re-INVITE, then back to the callee.
+func (b *B2BUA) playWarning(call *B2BCall, policy *DurationPolicy) {
+	offer := call.src.RemoteSdp()
+	tone := policy.WarningTone
+	if locale := b.locale(call.src); locale != nil && locale.WarningTone != nil {
+		tone = *locale.WarningTone
+	}
+	player, sdp, err := b.playTone(offer, tone)
+	if err != nil {
+		logger.Warnf("Warning tone failed: %v", err)
+		return
+	}
+	call.src.ProvideOffer(sdp)
+	call.src.ReInvite()
+	time.Sleep(policy.WarningLength)
+	player.Stop()
+	call.src.ProvideOffer(call.dest.RemoteSdp())
+	call.src.ReInvite()
+	// Keep the caller's description for the next offers.
+	call.src.ProvideOffer(offer)
+}
+
+// hangup terminate both legs of an established call.
+func (b *B2BUA) hangup(call *B2BCall, headers ...sip.Header) {
+	call.setCause(cdr.Released)
+	call.src.Bye(headers...)
+	call.dest.Bye(headers...)
+	b.dialogs.Remove(call.src)
+	b.dialogs.Remove(call.dest)
+	b.removeCall(call.src)
+}
+
+// stopTimers .
+func (call *B2BCall) stopTimers() {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	for _, timer := range call.timers {
+		timer.Stop()
+	}
+	call.timers = nil
+}
@@ -0,0 +1,53 @@
+package b2bua
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/enum"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// SetENUM look the E.164 numbers called up by resolver before the Router, e.g. before they are sent to a
+// trunk, nil to disable.
+func (b *B2BUA) SetENUM(resolver *enum.Resolver) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.enum = resolver
+}
+
+// GetENUM .
+func (b *B2BUA) GetENUM() *enum.Resolver {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.enum
+}
+
+// enumTarget the SIP URI of the E.164 number called by ENUM, or its number if the URI is of the domain
+// called; the registered users aren't looked up.
+func (b *B2BUA) enumTarget(called sip.Uri) (string, bool) {
+	resolver := b.GetENUM()
+	if resolver == nil || called.User() == nil || !enum.IsE164(called.User().String()) {
+		return "", false
+	}
+	if _, found := b.registry.GetContacts(called); found {
+		return "", false
+	}
+	uri, err := resolver.Lookup(context.TODO(), called.User().String())
+	if err != nil {
+		if err != enum.ErrNotFound {
+			logger.Warnf("ENUM lookup of %v failed: %v", called.User(), err)
+		}
+		return "", false
+	}
+	target, err := parser.ParseSipUri(uri)
+	if err != nil {
+		logger.Warnf("ENUM URI %s of %v: %v", uri, called.User(), err)
+		return "", false
+	}
+	if strings.EqualFold(target.Host(), called.Host()) && target.User() != nil {
+		return target.User().String(), true
+	}
+	return uri, true
+}
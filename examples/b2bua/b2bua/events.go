@@ -0,0 +1,75 @@
+package b2bua
+
+import (
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/events"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// SetEventBus set the bus of the call and registration events, nil to disable.
+func (b *B2BUA) SetEventBus(bus *events.Bus) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.eventBus = bus
+}
+
+// GetEventBus .
+func (b *B2BUA) GetEventBus() *events.Bus {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.eventBus
+}
+
+func (b *B2BUA) publish(event *events.Event) {
+	if bus := b.GetEventBus(); bus != nil {
+		bus.Publish(event)
+	}
+}
+
+// publishCall publish a call event built from the call detail record.
+func (b *B2BUA) publishCall(eventType events.Type, call *B2BCall) {
+	if b.GetEventBus() == nil {
+		return
+	}
+	record := call.Record()
+	event := &events.Event{
+		Type:          eventType,
+		CallID:        record.CallID,
+		CorrelationID: record.CorrelationID,
+		Caller:        record.Caller,
+		Called:        record.Called,
+		Source:        record.Source,
+		Destination:   record.Destination,
+	}
+	if req := call.src.Request(); req != nil {
+		if to, ok := req.To(); ok {
+			event.Tenant = to.Address.Host()
+		}
+	}
+	if eventType == events.CallEnded {
+		event.Time = record.Ended
+		event.Duration = record.Duration
+		event.StatusCode = record.StatusCode
+		event.Reason = record.Reason
+	}
+	b.publish(event)
+}
+
+// publishRegistration publish a registration event of the contact of request.
+func (b *B2BUA) publishRegistration(eventType events.Type, aor sip.Uri, request sip.Request, expires uint32) {
+	if b.GetEventBus() == nil {
+		return
+	}
+	event := &events.Event{
+		Type:    eventType,
+		Tenant:  aor.Host(),
+		AOR:     aor.String(),
+		Source:  request.Source(),
+		Expires: expires,
+	}
+	if contacts := request.GetHeaders("Contact"); len(contacts) > 0 {
+		if contact, ok := contacts[0].(*sip.ContactHeader); ok {
+			event.Contact = contact.Address.String()
+		}
+	}
+	b.publish(event)
+}
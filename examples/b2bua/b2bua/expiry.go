@@ -0,0 +1,67 @@
+package b2bua
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/events"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// bindingKey the key of the expiry timer of the binding of aor from source.
+func bindingKey(aor sip.Uri, source string) string {
+	user := ""
+	if aor.User() != nil {
+		user = aor.User().String()
+	}
+	return "binding:" + user + "@" + strings.ToLower(aor.Host()) + "/" + source
+}
+
+// scheduleExpiry remove the binding of aor from source in after unless refreshed, its timer replaced by
+// each refresh.
+func (b *B2BUA) scheduleExpiry(aor sip.Uri, source string, after time.Duration) {
+	aor = aor.Clone()
+	b.expiries.Schedule(bindingKey(aor, source), after, func() {
+		b.expireBinding(aor, source)
+	})
+}
+
+// cancelExpiry .
+func (b *B2BUA) cancelExpiry(aor sip.Uri, source string) {
+	b.expiries.Cancel(bindingKey(aor, source))
+}
+
+// expireBinding remove the binding of aor from source if it wasn't refreshed meanwhile, e.g. through
+// another instance sharing the registry.
+func (b *B2BUA) expireBinding(aor sip.Uri, source string) {
+	contacts, found := b.registry.GetContacts(aor)
+	if !found || contacts == nil {
+		return
+	}
+	instance, found := (*contacts)[source]
+	if !found {
+		return
+	}
+	if remaining := int64(instance.LastUpdated) + int64(instance.RegExpires) - time.Now().Unix(); remaining > 0 {
+		b.scheduleExpiry(aor, source, time.Duration(remaining)*time.Second)
+		return
+	}
+	logger.Infof("Expired [%v] source %s", aor, source)
+	b.registry.RemoveContact(aor, instance)
+	event := &events.Event{
+		Type:   events.Expired,
+		Tenant: aor.Host(),
+		AOR:    aor.String(),
+		Source: source,
+	}
+	if instance.Contact != nil {
+		event.Contact = instance.Contact.Address.String()
+	}
+	b.publish(event)
+	b.updatePresence(aor, "expired")
+}
+
+// ExpiryTimers the bindings waiting for their expiry.
+func (b *B2BUA) ExpiryTimers() int {
+	return b.expiries.Len()
+}
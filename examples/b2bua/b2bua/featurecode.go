@@ -0,0 +1,169 @@
+package b2bua
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+var (
+	// ConfirmationTone 350+440 Hz stutter, played when a feature code succeeds.
+	ConfirmationTone = media.Tone{Frequencies: []float64{350, 440}, On: 100 * time.Millisecond, Off: 100 * time.Millisecond}
+)
+
+const (
+	confirmationLength = 2 * time.Second
+)
+
+// FeatureCall a call to a feature code, e.g. *72200 to forward the calls to 200.
+type FeatureCall struct {
+	// Code the matched feature code, e.g. *72.
+	Code string
+	// Argument the digits dialed after the code, without the trailing #.
+	Argument string
+	Caller   sip.Uri
+	Request  sip.Request
+	Session  *session.Session
+	b2bua    *B2BUA
+}
+
+// Confirm answer the call with the confirmation tone, then hang up.
+func (call *FeatureCall) Confirm() {
+	player, answer, err := call.b2bua.playTone(call.Session.RemoteSdp(), ConfirmationTone)
+	if err != nil {
+		// The feature is applied, only the confirmation can't be played.
+		logger.Warnf("Confirmation tone of %s failed: %v", call.Code, err)
+		call.Reject(488, "Not Acceptable Here")
+		return
+	}
+	call.Session.ProvideAnswer(answer)
+	call.Session.Accept(200)
+	time.AfterFunc(confirmationLength, func() {
+		player.Stop()
+		call.Session.Bye()
+	})
+}
+
+// Reject .
+func (call *FeatureCall) Reject(statusCode sip.StatusCode, reason string) {
+	call.b2bua.reject(call.Session, statusCode, reason)
+}
+
+// FeatureCodeHandler handles the calls to a feature code, it must Confirm or Reject the call.
+type FeatureCodeHandler interface {
+	HandleFeatureCode(call *FeatureCall)
+}
+
+// FeatureCodeHandlerFunc allows the use of ordinary functions as FeatureCodeHandler.
+type FeatureCodeHandlerFunc func(call *FeatureCall)
+
+// HandleFeatureCode calls f(call).
+func (f FeatureCodeHandlerFunc) HandleFeatureCode(call *FeatureCall) {
+	f(call)
+}
+
+// SetFeatureCode set the handler of the called numbers starting with code, nil to remove it.
+// The feature codes are interpreted before the routing.
+func (b *B2BUA) SetFeatureCode(code string, handler FeatureCodeHandler) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if handler == nil {
+		delete(b.featureCodes, code)
+		return
+	}
+	b.featureCodes[code] = handler
+}
+
+// GetFeatureCodes .
+func (b *B2BUA) GetFeatureCodes() map[string]FeatureCodeHandler {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	codes := make(map[string]FeatureCodeHandler, len(b.featureCodes))
+	for code, handler := range b.featureCodes {
+		codes[code] = handler
+	}
+	return codes
+}
+
+// matchFeatureCode the handler of the longest feature code prefix of called.
+func (b *B2BUA) matchFeatureCode(called string) (string, FeatureCodeHandler) {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	var handler FeatureCodeHandler
+	matched := ""
+	for code, h := range b.featureCodes {
+		if strings.HasPrefix(called, code) && len(code) > len(matched) {
+			handler, matched = h, code
+		}
+	}
+	return matched, handler
+}
+
+// handleFeatureCode run the feature code dialed by the request, unless setup timed out, returns false if
+// called is not a feature code.
+func (b *B2BUA) handleFeatureCode(sess *session.Session, setup *callSetup, req sip.Request, caller sip.Uri, called string) bool {
+	code, handler := b.matchFeatureCode(called)
+	if handler == nil {
+		return false
+	}
+	if !setup.conclude() {
+		return true
+	}
+	call := &FeatureCall{
+		Code:     code,
+		Argument: strings.TrimSuffix(strings.TrimPrefix(called, code), "#"),
+		Caller:   caller,
+		Request:  req,
+		Session:  sess,
+		b2bua:    b,
+	}
+	logger.Infof("Feature code %s [%s] dialed by [%v]", call.Code, call.Argument, caller)
+	handler.HandleFeatureCode(call)
+	return true
+}
+
+// SetCallForward forward the calls to user to target, "" to cancel.
+func (b *B2BUA) SetCallForward(user string, target string) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if len(target) == 0 {
+		delete(b.callForwards, user)
+		return
+	}
+	b.callForwards[user] = target
+}
+
+// GetCallForward .
+func (b *B2BUA) GetCallForward(user string) (string, bool) {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	target, found := b.callForwards[user]
+	return target, found
+}
+
+// CallForwardSet feature code handler forwarding the calls of the caller to the dialed argument, e.g. *72.
+func (b *B2BUA) CallForwardSet() FeatureCodeHandler {
+	return FeatureCodeHandlerFunc(func(call *FeatureCall) {
+		if len(call.Argument) == 0 || call.Caller.User() == nil {
+			call.Reject(484, "Address Incomplete")
+			return
+		}
+		b.SetCallForward(call.Caller.User().String(), call.Argument)
+		call.Confirm()
+	})
+}
+
+// CallForwardCancel feature code handler canceling the call forwarding of the caller, e.g. *73.
+func (b *B2BUA) CallForwardCancel() FeatureCodeHandler {
+	return FeatureCodeHandlerFunc(func(call *FeatureCall) {
+		if call.Caller.User() == nil {
+			call.Reject(403, "Forbidden")
+			return
+		}
+		b.SetCallForward(call.Caller.User().String(), "")
+		call.Confirm()
+	})
+}
@@ -0,0 +1,383 @@
+package b2bua
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+)
+
+// EarlyMediaMode which branch of a forked call relays its early SDP to the caller.
+type EarlyMediaMode string
+
+const (
+	// EarlyMediaFirst the first branch sending an early SDP, the default.
+	EarlyMediaFirst EarlyMediaMode = "first"
+	// EarlyMediaPreferred the first branch over EarlyMediaPolicy.Transport sending an early SDP, the first
+	// branch as EarlyMediaFirst when none of the branches uses it.
+	EarlyMediaPreferred EarlyMediaMode = "preferred"
+	// EarlyMediaNone no early SDP, the provisional responses are relayed without their SDP.
+	EarlyMediaNone EarlyMediaMode = "none"
+)
+
+// EarlyMediaPolicy the early media of the forked calls: a single branch relays its early SDP, the
+// conflicting SDP of the other branches is suppressed, with their 183s, so the caller doesn't switch
+// media between the branches ringing in parallel.
+type EarlyMediaPolicy struct {
+	Mode EarlyMediaMode
+	// Transport preferred by EarlyMediaPreferred, e.g. tls.
+	Transport string
+}
+
+// SetEarlyMediaPolicy set the early media policy of the calls forked from now.
+func (b *B2BUA) SetEarlyMediaPolicy(policy EarlyMediaPolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.earlyMedia = policy
+}
+
+// GetEarlyMediaPolicy .
+func (b *B2BUA) GetEarlyMediaPolicy() EarlyMediaPolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.earlyMedia
+}
+
+// DefaultRingTimeout of each contact of a sequential fork.
+const DefaultRingTimeout = 20 * time.Second
+
+// ForkingMode how the calls to an AOR of several contacts are forked.
+type ForkingMode string
+
+const (
+	// ForkingParallel all the contacts ring at once, the default.
+	ForkingParallel ForkingMode = "parallel"
+	// ForkingSequential the contacts ring one at a time, in the order they registered: each for the ring
+	// timeout, the last one until the route timeout; the next one is tried on a timeout or a failure.
+	ForkingSequential ForkingMode = "sequential"
+)
+
+// ForkingPolicy the forking of the calls to an AOR of several contacts.
+type ForkingPolicy struct {
+	Mode ForkingMode
+	// RingTimeout of each contact of a sequential fork, DefaultRingTimeout if 0.
+	RingTimeout time.Duration
+}
+
+// SetForkingPolicy set the forking of the calls from now.
+func (b *B2BUA) SetForkingPolicy(policy ForkingPolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.forking = policy
+}
+
+// GetForkingPolicy .
+func (b *B2BUA) GetForkingPolicy() ForkingPolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.forking
+}
+
+// registrationOrder the contacts, the first registered first.
+func registrationOrder(contacts map[string]*registry.ContactInstance) []*registry.ContactInstance {
+	keys := make([]string, 0, len(contacts))
+	for key := range contacts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := contacts[keys[i]].Registered, contacts[keys[j]].Registered
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return keys[i] < keys[j]
+	})
+	ordered := make([]*registry.ContactInstance, 0, len(keys))
+	for _, key := range keys {
+		ordered = append(ordered, contacts[key])
+	}
+	return ordered
+}
+
+// forkState the branches of a forked call, the B2BCalls sharing the caller leg. The first branch
+// answering wins, the others are cancelled, or hung up when they answered too. The caller sees a single
+// dialog: when the early SDP relayed to it came from another branch than the winner, it is answered
+// with that SDP, then re-INVITEd with the final answer, rather than left with mismatched media.
+type forkState struct {
+	mutex    sync.Mutex
+	policy   EarlyMediaPolicy
+	branches []*B2BCall
+	// early the SDP relayed to the caller in a provisional response, by earlyBranch.
+	early       string
+	earlyBranch *session.Session
+	winner      *B2BCall
+	// sequence the targets of a sequential fork not invited yet, by invite, each ringing for ringTimeout
+	// but the last one.
+	sequence    []*registry.ContactInstance
+	invite      func(instance *registry.ContactInstance) *B2BCall
+	ringTimeout time.Duration
+}
+
+func newForkState(policy EarlyMediaPolicy) *forkState {
+	return &forkState{policy: policy, branches: []*B2BCall{}}
+}
+
+// add a branch.
+func (f *forkState) add(call *B2BCall) {
+	if f == nil {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.branches = append(f.branches, call)
+}
+
+// provisional the SDP of a provisional response of branch relayed to the caller, "" for none, and whether
+// the response is relayed at all: not once a branch answered, nor an early SDP conflicting with the one
+// of the early media branch.
+func (f *forkState) provisional(branch *B2BCall, sdp string) (string, bool) {
+	if f == nil {
+		return sdp, true
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.winner != nil {
+		return "", false
+	}
+	if len(sdp) == 0 || f.policy.Mode == EarlyMediaNone {
+		return "", true
+	}
+	if f.earlyBranch != nil {
+		if f.earlyBranch == branch.dest {
+			// The caller keeps the media it was given.
+			return f.early, true
+		}
+		logger.Debugf("Early SDP of %v suppressed, the early media is relayed from %v", branch.dest.CallID(), f.earlyBranch.CallID())
+		return "", false
+	}
+	if f.policy.Mode == EarlyMediaPreferred && !f.preferred(branch) && f.anyPreferred() {
+		// Wait for the early SDP of a branch over the preferred transport.
+		return "", false
+	}
+	f.early = sdp
+	f.earlyBranch = branch.dest
+	return sdp, true
+}
+
+// sequential invite the targets one at a time, from the first, returns false if none could be.
+func (f *forkState) sequential(targets []*registry.ContactInstance, ringTimeout time.Duration, invite func(instance *registry.ContactInstance) *B2BCall) bool {
+	if ringTimeout <= 0 {
+		ringTimeout = DefaultRingTimeout
+	}
+	f.mutex.Lock()
+	f.sequence, f.invite, f.ringTimeout = targets, invite, ringTimeout
+	f.mutex.Unlock()
+	return f.advance()
+}
+
+// advance invite the next target of a sequential fork, skipping those that can't be, false if none is
+// left or a branch answered.
+func (f *forkState) advance() bool {
+	if f == nil {
+		return false
+	}
+	for {
+		f.mutex.Lock()
+		if f.winner != nil || len(f.sequence) == 0 {
+			f.mutex.Unlock()
+			return false
+		}
+		instance := f.sequence[0]
+		f.sequence = f.sequence[1:]
+		last := len(f.sequence) == 0
+		invite, ringTimeout := f.invite, f.ringTimeout
+		f.mutex.Unlock()
+
+		call := invite(instance)
+		if call == nil {
+			continue
+		}
+		if !last {
+			call.schedule(ringTimeout, func() {
+				if call.IsAnswered() || !call.dest.IsInProgress() {
+					return
+				}
+				logger.Infof("Branch %v not answered in %v, trying the next contact", call.dest.CallID(), ringTimeout)
+				call.dest.End()
+			})
+		}
+		return true
+	}
+}
+
+// preferred branch uses the transport preferred by the policy.
+func (f *forkState) preferred(branch *B2BCall) bool {
+	return strings.EqualFold(branch.transport, f.policy.Transport)
+}
+
+// anyPreferred a branch uses the transport preferred by the policy.
+func (f *forkState) anyPreferred() bool {
+	for _, branch := range f.branches {
+		if f.preferred(branch) {
+			return true
+		}
+	}
+	return false
+}
+
+// answer elect call, answering with sdp, unless another branch answered first. Returns the SDP of the
+// 200 OK to the caller, and whether the caller must be re-INVITEd with sdp then.
+func (f *forkState) answer(call *B2BCall, sdp string) (string, bool, bool) {
+	if f == nil {
+		return sdp, false, true
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.winner != nil {
+		return "", false, false
+	}
+	f.winner = call
+	if len(f.early) > 0 && f.earlyBranch != call.dest && f.early != sdp {
+		return f.early, true, true
+	}
+	return sdp, false, true
+}
+
+// others the branches but call.
+func (f *forkState) others(call *B2BCall) []*B2BCall {
+	if f == nil {
+		return nil
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	others := []*B2BCall{}
+	for _, branch := range f.branches {
+		if branch != call {
+			others = append(others, branch)
+		}
+	}
+	return others
+}
+
+// yield remove the ended branch call, true when the caller leg is left to the others: another branch
+// answered, or none did and some are still ringing, or are still to be tried by a sequential fork.
+func (f *forkState) yield(call *B2BCall) bool {
+	if f == nil {
+		return false
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.winner == call {
+		return false
+	}
+	for i, branch := range f.branches {
+		if branch == call {
+			f.branches = append(f.branches[:i:i], f.branches[i+1:]...)
+			break
+		}
+	}
+	return f.winner != nil || len(f.branches) > 0 || len(f.sequence) > 0
+}
+
+// idle no branch answered nor is ringing, the next target of a sequential fork is due.
+func (f *forkState) idle() bool {
+	if f == nil {
+		return false
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.winner == nil && len(f.branches) == 0 && len(f.sequence) > 0
+}
+
+// abandon drop the targets of a sequential fork not invited yet, the call ends with its branches.
+func (f *forkState) abandon() {
+	if f == nil {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.sequence = nil
+}
+
+// elect index the winner of a forked call by the caller leg, the in-dialog requests of the caller
+// belong to its call.
+func (b *B2BUA) elect(call *B2BCall) {
+	b.callsLock.Lock()
+	defer b.callsLock.Unlock()
+	if _, found := b.calls[call.src]; found {
+		b.calls[call.src] = call
+	}
+}
+
+// yieldBranch end the branch of sess, a callee leg, when the caller leg is left to the other branches of
+// its call, false if the caller leg ends with it.
+func (b *B2BUA) yieldBranch(sess *session.Session) bool {
+	call := b.findCall(sess)
+	if call == nil || call.dest != sess || !call.fork.yield(call) {
+		return false
+	}
+	// The other branches keep the bandwidth reserved for the call.
+	call.reservation = nil
+	b.dialogs.Remove(sess)
+	b.removeCall(sess)
+	if call.fork.idle() {
+		go b.nextContact(call)
+	}
+	return true
+}
+
+// nextContact invite the next contact of the sequential fork of call, whose branch ended; the caller is
+// answered 480 if none could be.
+func (b *B2BUA) nextContact(call *B2BCall) {
+	if call.fork.advance() || !call.src.IsInProgress() {
+		return
+	}
+	logger.Infof("No contact of %v left to try", call.ToString())
+	b.reject(call.src, 480, "Temporarily Unavailable")
+	b.dialogs.Remove(call.src)
+}
+
+// waitPush record the pusher the caller of sess waits for.
+func (b *B2BUA) waitPush(sess *session.Session, pusher *registry.Pusher) {
+	b.callsLock.Lock()
+	defer b.callsLock.Unlock()
+	b.pushes[sess] = pusher
+}
+
+// endPush forget the pusher of sess, once its wait is over.
+func (b *B2BUA) endPush(sess *session.Session, pusher *registry.Pusher) {
+	b.callsLock.Lock()
+	if b.pushes[sess] == pusher {
+		delete(b.pushes, sess)
+	}
+	b.callsLock.Unlock()
+	b.rfc8599.Abort(pusher)
+}
+
+// abortPush abort the push wait of the caller of sess, e.g. on its CANCEL.
+func (b *B2BUA) abortPush(sess *session.Session) {
+	b.callsLock.Lock()
+	pusher, found := b.pushes[sess]
+	delete(b.pushes, sess)
+	b.callsLock.Unlock()
+	if found {
+		logger.Infof("Push wait of %v aborted", sess.CallID())
+		b.rfc8599.Abort(pusher)
+	}
+}
+
+// PendingBranches the B-Legs still ringing and the callers waiting for a push, none once the outstanding
+// calls were answered or cancelled, e.g. to verify that a CANCEL left no orphan branch.
+func (b *B2BUA) PendingBranches() int {
+	b.callsLock.RLock()
+	defer b.callsLock.RUnlock()
+	pending := len(b.pushes)
+	for sess, call := range b.calls {
+		if call.dest == sess && sess.IsInProgress() {
+			pending++
+		}
+	}
+	return pending
+}
@@ -0,0 +1,343 @@
+package b2bua
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// branchInvite an INVITE of callID, of a leg of a forked call.
+func branchInvite(t *testing.T, callID string) sip.Request {
+	return parseRequest(t, "INVITE sip:bob@example.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK"+callID+"\r\n"+
+		"From: <sip:alice@example.com>;tag=from-"+callID+"\r\n"+
+		"To: <sip:bob@example.com>\r\n"+
+		"Call-ID: "+callID+"\r\n"+
+		"CSeq: 1 INVITE\r\n"+
+		"Contact: <sip:alice@192.0.2.1:5060>\r\n"+
+		"Content-Length: 0\r\n\r\n")
+}
+
+func newBranches(fork *forkState, transports ...string) []*B2BCall {
+	src := &session.Session{}
+	branches := []*B2BCall{}
+	for _, transport := range transports {
+		call := &B2BCall{src: src, dest: &session.Session{}, fork: fork, transport: transport}
+		fork.add(call)
+		branches = append(branches, call)
+	}
+	return branches
+}
+
+func TestForkAnswerFromEarlyBranch(t *testing.T) {
+	fork := newForkState(EarlyMediaPolicy{})
+	branches := newBranches(fork, "udp", "udp")
+	fork.provisional(branches[0], "sdp-a")
+	sdp, reinvite, won := fork.answer(branches[0], "sdp-a")
+	if !won || reinvite || sdp != "sdp-a" {
+		t.Errorf("answer = %q, %v, %v, want sdp-a, false, true", sdp, reinvite, won)
+	}
+}
+
+func TestForkAnswerFromOtherBranch(t *testing.T) {
+	fork := newForkState(EarlyMediaPolicy{})
+	branches := newBranches(fork, "udp", "udp")
+	fork.provisional(branches[0], "sdp-a")
+	// An SDP-less 180 of the other branch keeps the early media.
+	fork.provisional(branches[1], "")
+	sdp, reinvite, won := fork.answer(branches[1], "sdp-b")
+	if !won || !reinvite || sdp != "sdp-a" {
+		t.Errorf("answer = %q, %v, %v, want sdp-a, true, true", sdp, reinvite, won)
+	}
+	if _, relay := fork.provisional(branches[0], "sdp-a2"); relay {
+		t.Error("provisional relayed after the answer")
+	}
+	others := fork.others(branches[1])
+	if len(others) != 1 || others[0] != branches[0] {
+		t.Errorf("others = %v, want the early branch", others)
+	}
+}
+
+func TestForkAnswerWithoutEarlyMedia(t *testing.T) {
+	fork := newForkState(EarlyMediaPolicy{})
+	branches := newBranches(fork, "udp", "udp")
+	sdp, reinvite, won := fork.answer(branches[1], "sdp-b")
+	if !won || reinvite || sdp != "sdp-b" {
+		t.Errorf("answer = %q, %v, %v, want sdp-b, false, true", sdp, reinvite, won)
+	}
+}
+
+func TestForkAnswerRace(t *testing.T) {
+	fork := newForkState(EarlyMediaPolicy{})
+	branches := newBranches(fork, "udp", "udp", "udp", "udp", "udp", "udp", "udp", "udp")
+	fork.provisional(branches[0], "sdp-0")
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	winners := []*B2BCall{}
+	for _, branch := range branches {
+		wg.Add(1)
+		go func(branch *B2BCall) {
+			defer wg.Done()
+			if _, _, won := fork.answer(branch, "sdp"); won {
+				mutex.Lock()
+				winners = append(winners, branch)
+				mutex.Unlock()
+			}
+		}(branch)
+	}
+	wg.Wait()
+	if len(winners) != 1 {
+		t.Fatalf("%d branches won, want 1", len(winners))
+	}
+	for _, branch := range branches {
+		yielded := fork.yield(branch)
+		if branch == winners[0] && yielded {
+			t.Error("the winner yielded the caller leg")
+		}
+		if branch != winners[0] && !yielded {
+			t.Error("a losing branch ended the caller leg")
+		}
+	}
+}
+
+func TestEarlyMediaFirst(t *testing.T) {
+	fork := newForkState(EarlyMediaPolicy{Mode: EarlyMediaFirst})
+	branches := newBranches(fork, "udp", "udp")
+	if sdp, relay := fork.provisional(branches[1], "sdp-b"); !relay || sdp != "sdp-b" {
+		t.Errorf("first early SDP = %q, %v, want sdp-b, true", sdp, relay)
+	}
+	if _, relay := fork.provisional(branches[0], "sdp-a"); relay {
+		t.Error("conflicting early SDP relayed")
+	}
+	if sdp, relay := fork.provisional(branches[0], ""); !relay || sdp != "" {
+		t.Errorf("180 = %q, %v, want no SDP, true", sdp, relay)
+	}
+	if sdp, relay := fork.provisional(branches[1], "sdp-b2"); !relay || sdp != "sdp-b" {
+		t.Errorf("early branch SDP = %q, %v, want sdp-b, true", sdp, relay)
+	}
+}
+
+func TestEarlyMediaPreferred(t *testing.T) {
+	fork := newForkState(EarlyMediaPolicy{Mode: EarlyMediaPreferred, Transport: "tls"})
+	branches := newBranches(fork, "udp", "TLS")
+	if _, relay := fork.provisional(branches[0], "sdp-udp"); relay {
+		t.Error("early SDP of the udp branch relayed, a tls branch is ringing")
+	}
+	if sdp, relay := fork.provisional(branches[1], "sdp-tls"); !relay || sdp != "sdp-tls" {
+		t.Errorf("tls early SDP = %q, %v, want sdp-tls, true", sdp, relay)
+	}
+
+	fork = newForkState(EarlyMediaPolicy{Mode: EarlyMediaPreferred, Transport: "tls"})
+	branches = newBranches(fork, "udp", "tcp")
+	if sdp, relay := fork.provisional(branches[1], "sdp-tcp"); !relay || sdp != "sdp-tcp" {
+		t.Errorf("early SDP without tls branch = %q, %v, want sdp-tcp, true", sdp, relay)
+	}
+}
+
+func TestEarlyMediaNone(t *testing.T) {
+	fork := newForkState(EarlyMediaPolicy{Mode: EarlyMediaNone})
+	branches := newBranches(fork, "udp", "udp")
+	if sdp, relay := fork.provisional(branches[0], "sdp-a"); !relay || sdp != "" {
+		t.Errorf("183 = %q, %v, want no SDP, true", sdp, relay)
+	}
+	if sdp, reinvite, _ := fork.answer(branches[1], "sdp-b"); reinvite || sdp != "sdp-b" {
+		t.Errorf("answer = %q, %v, want sdp-b, false", sdp, reinvite)
+	}
+}
+
+func TestForkYieldWhileRinging(t *testing.T) {
+	fork := newForkState(EarlyMediaPolicy{})
+	branches := newBranches(fork, "udp", "udp")
+	if !fork.yield(branches[0]) {
+		t.Error("the first failed branch ended the caller leg, the other one is ringing")
+	}
+	if fork.yield(branches[1]) {
+		t.Error("the last failed branch left the caller leg")
+	}
+}
+
+func TestForkNil(t *testing.T) {
+	var fork *forkState
+	call := &B2BCall{}
+	fork.add(call)
+	if _, relay := fork.provisional(call, "sdp"); !relay {
+		t.Error("provisional not relayed without fork")
+	}
+	if sdp, reinvite, won := fork.answer(call, "sdp"); !won || reinvite || sdp != "sdp" {
+		t.Errorf("answer = %q, %v, %v, want sdp, false, true", sdp, reinvite, won)
+	}
+	if fork.yield(call) {
+		t.Error("yield without fork")
+	}
+}
+
+func TestCancelLeavesNoBranch(t *testing.T) {
+	b := newTestB2BUA(t)
+	defer b.Shutdown()
+	req := branchInvite(t, "a-leg")
+	contacts, _ := req.Contact()
+	srcTx := &referTx{responses: make(chan sip.Response, 2)}
+	src := session.NewInviteSession(nil, "UAS", contacts, req, "a-leg", srcTx, session.Incoming, nil)
+	src.SetState(session.InviteReceived)
+	// A contact of the callee being woken up by a push, and two ringing.
+	pusher := registry.NewPusher()
+	b.waitPush(src, pusher)
+	fork := newForkState(EarlyMediaPolicy{})
+	txs := map[*session.Session]*cancelTx{}
+	for _, callID := range []string{"b-leg-1", "b-leg-2"} {
+		tx := &cancelTx{canceled: make(chan struct{})}
+		dest := session.NewInviteSession(nil, "UAC", contacts, branchInvite(t, callID), sip.CallID(callID), tx, session.Outgoing, nil)
+		dest.SetState(session.Provisional)
+		b.addCall(&B2BCall{src: src, dest: dest, fork: fork})
+		txs[dest] = tx
+	}
+	if pending := b.PendingBranches(); pending != 3 {
+		t.Fatalf("%d pending branches, want 3", pending)
+	}
+
+	// The CANCEL of the caller, as the user agent handles it.
+	ok := sip.Response(sip.NewResponseFromRequest("", req, 200, "OK", ""))
+	src.SetState(session.Canceled)
+	b.ua.InviteStateHandler(src, &req, &ok, session.Canceled)
+	if _, err := pusher.WaitContactOnline(); err != registry.ErrPushAborted {
+		t.Errorf("wait = %v, want %v", err, registry.ErrPushAborted)
+	}
+	for dest, tx := range txs {
+		select {
+		case <-tx.canceled:
+		default:
+			t.Fatalf("branch %v not cancelled", dest.CallID())
+		}
+		// The cancelled branch answers 487.
+		inviteReq := dest.Request()
+		terminated := sip.Response(sip.NewResponseFromRequest("", inviteReq, 487, "Request Terminated", ""))
+		dest.SetState(session.Failure)
+		b.ua.InviteStateHandler(dest, &inviteReq, &terminated, session.Failure)
+	}
+	if pending := b.PendingBranches(); pending != 0 {
+		t.Errorf("%d pending branches after the CANCEL, want 0", pending)
+	}
+}
+
+// cancelTx a client transaction recording its CANCEL.
+type cancelTx struct {
+	sip.ClientTransaction
+	canceled chan struct{}
+}
+
+func (tx *cancelTx) Cancel() error {
+	close(tx.canceled)
+	return nil
+}
+
+func TestForkSequential(t *testing.T) {
+	fork := newForkState(EarlyMediaPolicy{})
+	targets := []*registry.ContactInstance{{Source: "skipped"}, {Source: "first"}, {Source: "last"}}
+	invited := []string{}
+	txs := map[string]*cancelTx{}
+	calls := map[string]*B2BCall{}
+	invite := func(instance *registry.ContactInstance) *B2BCall {
+		if instance.Source == "skipped" {
+			return nil
+		}
+		invited = append(invited, instance.Source)
+		tx := &cancelTx{canceled: make(chan struct{})}
+		req := newInvite(t, "sip:bob@example.com", "sip:bob@example.com")
+		callID, _ := req.CallID()
+		dest := session.NewInviteSession(nil, "UAC", nil, req, *callID, tx, session.Outgoing, nil)
+		dest.SetState(session.Provisional)
+		call := &B2BCall{src: &session.Session{}, dest: dest, fork: fork}
+		fork.add(call)
+		txs[instance.Source], calls[instance.Source] = tx, call
+		return call
+	}
+	if !fork.sequential(targets, 20*time.Millisecond, invite) {
+		t.Fatal("no target invited")
+	}
+	if len(invited) != 1 || invited[0] != "first" {
+		t.Fatalf("invited %v, want the first target which can be", invited)
+	}
+	select {
+	case <-txs["first"].canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the first branch wasn't cancelled after the ring timeout")
+	}
+
+	// The cancelled branch fails, the next target rings until answered.
+	calls["first"].dest.SetState(session.Failure)
+	if !fork.advance() {
+		t.Fatal("the last target not invited")
+	}
+	if len(invited) != 2 || invited[1] != "last" {
+		t.Fatalf("invited %v, want the last target next", invited)
+	}
+	select {
+	case <-txs["last"].canceled:
+		t.Error("the last branch cancelled by a ring timeout")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if fork.advance() {
+		t.Error("advanced past the last target")
+	}
+}
+
+func TestForkSequentialAnswered(t *testing.T) {
+	fork := newForkState(EarlyMediaPolicy{})
+	targets := []*registry.ContactInstance{{Source: "first"}, {Source: "second"}}
+	invited := 0
+	fork.sequential(targets, time.Hour, func(instance *registry.ContactInstance) *B2BCall {
+		invited++
+		call := &B2BCall{src: &session.Session{}, dest: &session.Session{}, fork: fork}
+		fork.add(call)
+		if _, _, won := fork.answer(call, "sdp"); !won {
+			t.Error("the only branch lost")
+		}
+		return call
+	})
+	if fork.advance() || invited != 1 {
+		t.Errorf("%d targets invited, want none after the answer", invited)
+	}
+}
+
+func TestHangupForkedCall(t *testing.T) {
+	b := newTestB2BUA(t)
+	defer b.Shutdown()
+	req := branchInvite(t, "a-leg")
+	contacts, _ := req.Contact()
+	srcTx := &referTx{responses: make(chan sip.Response, 2)}
+	src := session.NewInviteSession(nil, "UAS", contacts, req, "a-leg", srcTx, session.Incoming, nil)
+	src.SetState(session.InviteReceived)
+
+	fork := newForkState(EarlyMediaPolicy{})
+	txs := []*cancelTx{}
+	for _, callID := range []string{"b-leg-1", "b-leg-2"} {
+		tx := &cancelTx{canceled: make(chan struct{})}
+		dest := session.NewInviteSession(nil, "UAC", contacts, branchInvite(t, callID), sip.CallID(callID), tx, session.Outgoing, nil)
+		dest.SetState(session.Provisional)
+		b.addCall(&B2BCall{src: src, dest: dest, fork: fork})
+		txs = append(txs, tx)
+	}
+
+	if !b.HangupCall("a-leg") {
+		t.Fatal("forked call not found")
+	}
+	for i, tx := range txs {
+		select {
+		case <-tx.canceled:
+		default:
+			t.Errorf("branch %d not cancelled", i)
+		}
+	}
+	select {
+	case res := <-srcTx.responses:
+		if res.StatusCode() != 487 {
+			t.Errorf("caller answered %d, want 487", res.StatusCode())
+		}
+	default:
+		t.Error("caller not answered")
+	}
+}
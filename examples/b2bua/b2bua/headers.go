@@ -0,0 +1,136 @@
+package b2bua
+
+import (
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+var (
+	// DefaultStripHeaders the private and the identity headers only a trusted peer may assert.
+	DefaultStripHeaders = []string{"P-*", "X-*", "Remote-Party-ID"}
+
+	// dialogHeaders are built by the B-leg itself, never copied.
+	dialogHeaders = []string{"Via", "From", "To", "Call-ID", "CSeq", "Contact", "Max-Forwards", "Route",
+		"Record-Route", "Content-Type", "Content-Length", "Content-Encoding", "Authorization",
+		"Proxy-Authorization", "Allow", "Supported", "Require", "User-Agent"}
+)
+
+// HeaderPolicy the inbound SIP headers of the calls stripped and copied onto the B-leg. The names are
+// matched case-insensitively, a trailing * matches a prefix, e.g. X-*.
+type HeaderPolicy struct {
+	// Strip headers removed on ingress from the untrusted sources: neither a trusted network of the
+	// ChallengePolicy nor a trunk.
+	Strip []string
+	// Copy inbound headers copied onto the B-leg, after stripping, none if empty.
+	Copy []string
+}
+
+// NewHeaderPolicy strip the DefaultStripHeaders, copy none.
+func NewHeaderPolicy() *HeaderPolicy {
+	return &HeaderPolicy{
+		Strip: DefaultStripHeaders,
+		Copy:  []string{},
+	}
+}
+
+// Stripped name is stripped from the untrusted sources.
+func (p *HeaderPolicy) Stripped(name string) bool {
+	return matchHeader(p.Strip, name)
+}
+
+// Copied name is copied onto the B-leg.
+func (p *HeaderPolicy) Copied(name string) bool {
+	return !matchHeader(dialogHeaders, name) && matchHeader(p.Copy, name)
+}
+
+func matchHeader(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(name, pattern[:len(pattern)-1]) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// SetHeaderPolicy set the header policy of the calls of account, the caller, "" for the server-wide
+// one, nil to remove it. Without any, the inbound headers are neither stripped nor copied.
+func (b *B2BUA) SetHeaderPolicy(account string, policy *HeaderPolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if policy == nil {
+		delete(b.headerPolicies, account)
+		return
+	}
+	b.headerPolicies[account] = policy
+}
+
+// GetHeaderPolicy the header policy of account, or the server-wide one.
+func (b *B2BUA) GetHeaderPolicy(account string) *HeaderPolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	if policy, found := b.headerPolicies[account]; found {
+		return policy
+	}
+	return b.headerPolicies[""]
+}
+
+// isTrustedSource the request comes from a trusted network or a trunk.
+func (b *B2BUA) isTrustedSource(req sip.Request) bool {
+	if policy, ok := b.GetChallengePolicy().(*ChallengePolicy); ok {
+		if policy.IsTrusted(stack.RequestSource{Addr: req.Source()}) {
+			return true
+		}
+	}
+	return b.FindTrunk(req.Source()) != nil
+}
+
+// headerPolicy the header policy of the account of caller.
+func (b *B2BUA) headerPolicy(caller sip.Uri) *HeaderPolicy {
+	account := ""
+	if caller != nil && caller.User() != nil {
+		account = caller.User().String()
+	}
+	return b.GetHeaderPolicy(account)
+}
+
+// stripHeaders remove the stripped headers of the policy of caller from req, unless it comes from a
+// trusted source.
+func (b *B2BUA) stripHeaders(req sip.Request, caller sip.Uri) {
+	policy := b.headerPolicy(caller)
+	if policy == nil || len(policy.Strip) == 0 || b.isTrustedSource(req) {
+		return
+	}
+	stripped := map[string]bool{}
+	for _, header := range req.Headers() {
+		if policy.Stripped(header.Name()) {
+			stripped[header.Name()] = true
+		}
+	}
+	for name := range stripped {
+		logger.Debugf("Header %s stripped from %s", name, req.Source())
+		req.RemoveHeader(name)
+	}
+}
+
+// copyHeaders the headers of req copied onto the B-leg by the policy of caller.
+func (b *B2BUA) copyHeaders(req sip.Request, caller sip.Uri) []sip.Header {
+	headers := []sip.Header{}
+	policy := b.headerPolicy(caller)
+	if policy == nil || len(policy.Copy) == 0 {
+		return headers
+	}
+	for _, header := range req.Headers() {
+		if policy.Copied(header.Name()) {
+			headers = append(headers, header.Clone())
+		}
+	}
+	return headers
+}
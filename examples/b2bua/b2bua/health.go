@@ -0,0 +1,56 @@
+package b2bua
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+)
+
+// Listen serve SIP on another address, e.g. "ws", "0.0.0.0:8080" for the plain WS of an ingress
+// terminating TLS.
+func (b *B2BUA) Listen(network string, address string) error {
+	return b.stack.Listen(network, address)
+}
+
+// Ready returns why the B2BUA can't take new calls: draining, or registry backend unreachable.
+func (b *B2BUA) Ready() error {
+	if b.IsDraining() {
+		return fmt.Errorf("draining")
+	}
+	if pinger, ok := b.GetRegistry().(registry.Pinger); ok {
+		if err := pinger.Ping(); err != nil {
+			return fmt.Errorf("registry: %v", err)
+		}
+	}
+	return nil
+}
+
+// HealthHandler the probes of an orchestrator such as Kubernetes: /healthz the liveness, /readyz
+// the readiness, 503 while not Ready, and /drain the preStop hook, which drains the calls and
+// answers once they have ended, or after timeout.
+func (b *B2BUA) HealthHandler(timeout time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := b.Ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		b.auditHTTP(r, http.StatusOK)
+		select {
+		case <-b.Drain():
+			fmt.Fprintln(w, "drained")
+		case <-time.After(timeout):
+			fmt.Fprintf(w, "%d calls left\n", len(b.Calls()))
+		case <-r.Context().Done():
+		}
+	})
+	return mux
+}
@@ -0,0 +1,346 @@
+package b2bua
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// DefaultHistoryMessages messages kept per call.
+	DefaultHistoryMessages = 100
+	// DefaultHistoryCalls completed calls kept.
+	DefaultHistoryCalls = 100
+	// historyIdle an INVITE dialog which never became a call, e.g. rejected by the dial plan, is
+	// completed once idle for this long.
+	historyIdle = time.Minute
+	// historyLinger the messages received after the end of a call, e.g. the 200 OK of the BYE, are
+	// kept until it is completed.
+	historyLinger = 5 * time.Second
+)
+
+// HistoryMessage a SIP message of a call.
+type HistoryMessage struct {
+	Time time.Time `json:"time"`
+	// Inbound received by the B2BUA, else sent.
+	Inbound     bool   `json:"inbound"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Message     string `json:"message"`
+}
+
+// CallHistory the SIP messages of both legs of a call, the oldest are dropped beyond the limit.
+type CallHistory struct {
+	// ID the Call-ID of the A-Leg, CallIDs of all the legs.
+	ID       string           `json:"id"`
+	CallIDs  []string         `json:"call_ids"`
+	Caller   string           `json:"caller"`
+	Called   string           `json:"called"`
+	Started  time.Time        `json:"started"`
+	Ended    *time.Time       `json:"ended,omitempty"`
+	Dropped  int              `json:"dropped"`
+	Messages []HistoryMessage `json:"messages,omitempty"`
+
+	call    bool
+	updated time.Time
+}
+
+// summary the history without its messages.
+func (h *CallHistory) summary() *CallHistory {
+	ret := *h
+	ret.CallIDs = append([]string{}, h.CallIDs...)
+	ret.Messages = nil
+	return &ret
+}
+
+func (h *CallHistory) copy() *CallHistory {
+	ret := h.summary()
+	ret.Messages = append([]HistoryMessage{}, h.Messages...)
+	return ret
+}
+
+// MessageHistory keeps the SIP messages of the active calls and of the last completed calls,
+// for the support engineers to see the signaling of a problem call without packet captures.
+type MessageHistory struct {
+	mutex     sync.Mutex
+	perCall   int
+	limit     int
+	active    map[string]*CallHistory
+	completed []*CallHistory
+	pruned    time.Time
+	redaction *utils.Redaction
+}
+
+// NewMessageHistory keep perCall messages of each call and the last completed calls,
+// DefaultHistoryMessages and DefaultHistoryCalls if 0.
+func NewMessageHistory(perCall int, completed int) *MessageHistory {
+	if perCall <= 0 {
+		perCall = DefaultHistoryMessages
+	}
+	if completed <= 0 {
+		completed = DefaultHistoryCalls
+	}
+	return &MessageHistory{
+		perCall: perCall,
+		limit:   completed,
+		active:  make(map[string]*CallHistory),
+		pruned:  time.Now(),
+	}
+}
+
+// SetRedaction mask the sensitive data of the messages recorded from now on, nil to disable.
+func (m *MessageHistory) SetRedaction(redaction *utils.Redaction) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.redaction = redaction
+}
+
+// Record add msg to the history of its call, an INVITE out of dialog starts a new history.
+func (m *MessageHistory) Record(msg sip.Message, inbound bool) {
+	callID, ok := msg.CallID()
+	if !ok {
+		return
+	}
+	now := time.Now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	history, found := m.active[callID.Value()]
+	if !found {
+		req, ok := msg.(sip.Request)
+		if !ok || req.Method() != sip.INVITE {
+			return
+		}
+		if to, ok := req.To(); !ok || to.Params == nil || to.Params.Has("tag") {
+			return
+		}
+		m.prune(now)
+		history = &CallHistory{ID: callID.Value(), CallIDs: []string{callID.Value()}, Started: now}
+		if from, ok := req.From(); ok {
+			history.Caller = from.Address.String()
+		}
+		if to, ok := req.To(); ok {
+			history.Called = to.Address.String()
+		}
+		m.active[history.ID] = history
+	}
+	history.updated = now
+	if len(history.Messages) >= m.perCall {
+		history.Messages = history.Messages[1:]
+		history.Dropped++
+	}
+	history.Messages = append(history.Messages, HistoryMessage{
+		Time:        now,
+		Inbound:     inbound,
+		Source:      msg.Source(),
+		Destination: msg.Destination(),
+		Message:     m.redaction.Redact(msg.String()),
+	})
+}
+
+// link merge the history of the B-Leg into the one of the A-Leg.
+func (m *MessageHistory) link(aLeg string, bLeg string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	history, found := m.active[aLeg]
+	if !found {
+		return
+	}
+	history.call = true
+	if other, found := m.active[bLeg]; found && other != history {
+		merged := make([]HistoryMessage, 0, len(history.Messages)+len(other.Messages))
+		i, j := 0, 0
+		for i < len(history.Messages) || j < len(other.Messages) {
+			if j == len(other.Messages) || (i < len(history.Messages) && !other.Messages[j].Time.Before(history.Messages[i].Time)) {
+				merged = append(merged, history.Messages[i])
+				i++
+			} else {
+				merged = append(merged, other.Messages[j])
+				j++
+			}
+		}
+		if drop := len(merged) - m.perCall; drop > 0 {
+			merged = merged[drop:]
+			history.Dropped += drop
+		}
+		history.Messages = merged
+		history.Dropped += other.Dropped
+	}
+	history.CallIDs = append(history.CallIDs, bLeg)
+	m.active[bLeg] = history
+}
+
+// end complete the history of the call with the leg callIDs after historyLinger.
+func (m *MessageHistory) end(callIDs ...string) {
+	time.AfterFunc(historyLinger, func() { m.endNow(callIDs...) })
+}
+
+func (m *MessageHistory) endNow(callIDs ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, callID := range callIDs {
+		if history, found := m.active[callID]; found {
+			m.complete(history, time.Now())
+		}
+	}
+}
+
+// complete move history to the completed ones, locked.
+func (m *MessageHistory) complete(history *CallHistory, now time.Time) {
+	for _, callID := range history.CallIDs {
+		if m.active[callID] == history {
+			delete(m.active, callID)
+		}
+	}
+	history.Ended = &now
+	m.completed = append(m.completed, history)
+	if len(m.completed) > m.limit {
+		m.completed = m.completed[len(m.completed)-m.limit:]
+	}
+}
+
+// prune complete the idle INVITE dialogs which never became a call, at most every historyIdle, locked.
+func (m *MessageHistory) prune(now time.Time) {
+	if now.Sub(m.pruned) < historyIdle {
+		return
+	}
+	m.pruned = now
+	for _, history := range m.active {
+		if !history.call && now.Sub(history.updated) > historyIdle {
+			m.complete(history, now)
+		}
+	}
+}
+
+// Get the history of the call with a leg callID, active or completed.
+func (m *MessageHistory) Get(callID string) (*CallHistory, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if history, found := m.active[callID]; found {
+		return history.copy(), true
+	}
+	for i := len(m.completed) - 1; i >= 0; i-- {
+		for _, id := range m.completed[i].CallIDs {
+			if id == callID {
+				return m.completed[i].copy(), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// List the active and the completed calls, without their messages, most recent first.
+func (m *MessageHistory) List() []*CallHistory {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	list := make([]*CallHistory, 0, len(m.active)+len(m.completed))
+	for callID, history := range m.active {
+		if callID == history.ID {
+			list = append(list, history.summary())
+		}
+	}
+	for i := len(m.completed) - 1; i >= 0; i-- {
+		list = append(list, m.completed[i].summary())
+	}
+	return list
+}
+
+// Purge forget the completed calls ended before, returns their number.
+func (m *MessageHistory) Purge(before time.Time) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	// A new slice, the forgotten histories must not linger in the array.
+	kept := make([]*CallHistory, 0, len(m.completed))
+	for _, history := range m.completed {
+		if !history.Ended.Before(before) {
+			kept = append(kept, history)
+		}
+	}
+	purged := len(m.completed) - len(kept)
+	m.completed = kept
+	return purged
+}
+
+// Erase forget the active and the completed calls of user, the caller or the called, returns their number.
+// The messages of an active call aren't recorded anymore.
+func (m *MessageHistory) Erase(user string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	erased := 0
+	for callID, history := range m.active {
+		if callID == history.ID && (uriUser(history.Caller) == user || uriUser(history.Called) == user) {
+			for _, id := range history.CallIDs {
+				delete(m.active, id)
+			}
+			erased++
+		}
+	}
+	kept := make([]*CallHistory, 0, len(m.completed))
+	for _, history := range m.completed {
+		if uriUser(history.Caller) != user && uriUser(history.Called) != user {
+			kept = append(kept, history)
+		}
+	}
+	erased += len(m.completed) - len(kept)
+	m.completed = kept
+	return erased
+}
+
+// Handler serve the histories as JSON, the list of calls, or the messages of the call with the
+// call_id query parameter.
+func (m *MessageHistory) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body interface{}
+		if callID := r.URL.Query().Get("call_id"); len(callID) > 0 {
+			history, found := m.Get(callID)
+			if !found {
+				http.Error(w, "call not found", http.StatusNotFound)
+				return
+			}
+			body = history
+		} else {
+			body = m.List()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(body)
+	})
+}
+
+// SetMessageHistory keep the SIP messages of the calls in history, nil to disable.
+func (b *B2BUA) SetMessageHistory(history *MessageHistory) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.messageHistory = history
+}
+
+// GetMessageHistory .
+func (b *B2BUA) GetMessageHistory() *MessageHistory {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.messageHistory
+}
+
+// observeMessage the stack message handler, feeds the message history and the SIP trace.
+func (b *B2BUA) observeMessage(msg sip.Message, inbound bool) {
+	b.tracer.Trace(msg, inbound)
+	if history := b.GetMessageHistory(); history != nil {
+		history.Record(msg, inbound)
+	}
+}
+
+// legCallIDs the Call-IDs of the A-Leg and the B-Leg of call.
+func legCallIDs(call *B2BCall) (string, string) {
+	aLeg, bLeg := "", ""
+	if callID := call.src.CallID(); callID != nil {
+		aLeg = callID.Value()
+	}
+	if callID := call.dest.CallID(); callID != nil {
+		bLeg = callID.Value()
+	}
+	return aLeg, bLeg
+}
@@ -0,0 +1,167 @@
+package b2bua
+
+import (
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/ua"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// DecisionAction what the B2BUA does with an intercepted call.
+type DecisionAction string
+
+const (
+	// DecisionBridge accept the call and bridge it, to Decision.Target if any, through the dial plan.
+	DecisionBridge DecisionAction = "bridge"
+	// DecisionReject reject the call with Decision.StatusCode.
+	DecisionReject DecisionAction = "reject"
+	// DecisionRedirect redirect the caller to Decision.Target with a 3xx.
+	DecisionRedirect DecisionAction = "redirect"
+	// DecisionTakeOver the application handles the session itself, e.g. answers it locally.
+	DecisionTakeOver DecisionAction = "takeover"
+)
+
+// IncomingCall an incoming call submitted to the OnIncomingCall handler, before the dial plan and
+// any registry lookup.
+type IncomingCall struct {
+	Session *session.Session
+	Request sip.Request
+	Caller  sip.Uri
+	Called  sip.Uri
+}
+
+// Header the value of the first header name of the INVITE, "" if absent.
+func (c *IncomingCall) Header(name string) string {
+	if headers := c.Request.GetHeaders(name); len(headers) > 0 {
+		return headers[0].Value()
+	}
+	return ""
+}
+
+// Decision the outcome of the OnIncomingCall handler.
+type Decision struct {
+	Action DecisionAction
+	// Target of DecisionBridge, a number routed by the dial plan or a SIP URI the call is sent to, the
+	// called party if empty. The SIP URI of DecisionRedirect.
+	Target string
+	// StatusCode and Reason of DecisionReject, or the 3xx of DecisionRedirect, 302 by default.
+	StatusCode sip.StatusCode
+	Reason     string
+	// Headers added to the B-Leg INVITE of DecisionBridge.
+	Headers map[string]string
+	// Handler of DecisionTakeOver, given the next states of the session, e.g. its ACK or its BYE.
+	Handler ua.InviteSessionHandler
+}
+
+// Bridge the call to its called party.
+func Bridge() Decision {
+	return Decision{Action: DecisionBridge}
+}
+
+// BridgeTo the call to target, a number or a SIP URI.
+func BridgeTo(target string) Decision {
+	return Decision{Action: DecisionBridge, Target: target}
+}
+
+// Reject the call.
+func Reject(statusCode sip.StatusCode, reason string) Decision {
+	return Decision{Action: DecisionReject, StatusCode: statusCode, Reason: reason}
+}
+
+// Redirect the caller to target, a SIP URI.
+func Redirect(target string) Decision {
+	return Decision{Action: DecisionRedirect, Target: target}
+}
+
+// TakeOver the session, handler is given its next states, nil to ignore them.
+func TakeOver(handler ua.InviteSessionHandler) Decision {
+	return Decision{Action: DecisionTakeOver, Handler: handler}
+}
+
+// IncomingCallHandler decides what to do with an incoming call.
+type IncomingCallHandler func(call *IncomingCall) Decision
+
+// OnIncomingCall set the handler of the incoming calls, fired before the dial plan and any registry
+// lookup, nil to handle them as usual.
+func (b *B2BUA) OnIncomingCall(handler IncomingCallHandler) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.incomingCallHandler = handler
+}
+
+// intercept submit the call to the OnIncomingCall handler and apply its decision to route, false if the
+// call has been handled.
+func (b *B2BUA) intercept(sess *session.Session, setup *callSetup, req sip.Request, caller sip.Uri, route *Route) bool {
+	b.configLock.RLock()
+	handler := b.incomingCallHandler
+	b.configLock.RUnlock()
+	if handler == nil {
+		return true
+	}
+	decision := handler(&IncomingCall{Session: sess, Request: req, Caller: caller, Called: route.Called})
+	switch decision.Action {
+	case DecisionReject:
+		code := decision.StatusCode
+		if code < 400 || code > 699 {
+			code = 403
+		}
+		logger.Infof("Call from [%v] to [%v] rejected by the application", caller, route.Called)
+		b.rejectSetup(setup, sess, code, decision.Reason)
+		return false
+	case DecisionRedirect:
+		if !strings.HasPrefix(decision.Target, "sip:") && !strings.HasPrefix(decision.Target, "sips:") {
+			logger.Errorf("Invalid redirect target %s", decision.Target)
+			b.rejectSetup(setup, sess, 500, "Invalid Redirect")
+			return false
+		}
+		logger.Infof("Call from [%v] to [%v] redirected to [%s] by the application", caller, route.Called, decision.Target)
+		if setup.conclude() {
+			sess.Redirect(decision.Target, decision.StatusCode)
+		}
+		return false
+	case DecisionTakeOver:
+		if !setup.conclude() {
+			// Answered 504 meanwhile.
+			return false
+		}
+		logger.Infof("Call from [%v] to [%v] taken over by the application", caller, route.Called)
+		b.callsLock.Lock()
+		b.takenOver[sess] = decision.Handler
+		b.callsLock.Unlock()
+		// The in-dialog requests of the session are let through.
+		b.dialogs.Add(sess)
+		return false
+	}
+	action := RouteContinue
+	if len(decision.Target) > 0 {
+		action = RouteTo
+	}
+	routeDecision := &RouteDecision{Action: action, Target: decision.Target, Headers: decision.Headers}
+	if code, reason := routeDecision.apply(route); code != 0 {
+		b.rejectSetup(setup, sess, code, reason)
+		return false
+	}
+	return true
+}
+
+// takeOverHandler pass a state of a taken over session to its handler, false if the session isn't one.
+func (b *B2BUA) takeOverHandler(sess *session.Session, req *sip.Request, resp *sip.Response, state session.Status) bool {
+	b.callsLock.Lock()
+	handler, found := b.takenOver[sess]
+	ended := state == session.Failure || state == session.Canceled || state == session.Terminated
+	if found && ended {
+		delete(b.takenOver, sess)
+	}
+	b.callsLock.Unlock()
+	if !found {
+		return false
+	}
+	if ended {
+		b.dialogs.Remove(sess)
+	}
+	if handler != nil {
+		handler(sess, req, resp, state)
+	}
+	return true
+}
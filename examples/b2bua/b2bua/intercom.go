@@ -0,0 +1,72 @@
+package b2bua
+
+import (
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/ua"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// IntercomPolicy the callers allowed to have their calls answered automatically by the callees,
+// e.g. the door phones or the paging systems. The auto-answer requests of the other callers are
+// removed, and their calls rejected with 403 when they require it.
+type IntercomPolicy struct {
+	// Callers a number ending with * matches a prefix, * matches everyone.
+	Callers []string
+}
+
+// NewIntercomPolicy .
+func NewIntercomPolicy(callers ...string) *IntercomPolicy {
+	return &IntercomPolicy{Callers: callers}
+}
+
+// Allowed caller may request the auto-answer.
+func (p *IntercomPolicy) Allowed(caller string) bool {
+	for _, number := range p.Callers {
+		if strings.HasSuffix(number, "*") {
+			if strings.HasPrefix(caller, strings.TrimSuffix(number, "*")) {
+				return true
+			}
+		} else if caller == number {
+			return true
+		}
+	}
+	return false
+}
+
+// SetIntercomPolicy set who may request the auto-answer of the calls, nil to allow no one.
+func (b *B2BUA) SetIntercomPolicy(policy *IntercomPolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.intercomPolicy = policy
+}
+
+// GetIntercomPolicy .
+func (b *B2BUA) GetIntercomPolicy() *IntercomPolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.intercomPolicy
+}
+
+// intercom the auto-answer to request on the B-Leg of the INVITE req from caller, nil if none,
+// false if the call must be rejected.
+func (b *B2BUA) intercom(req sip.Request, caller sip.Uri) (*ua.AutoAnswer, bool) {
+	autoAnswer := ua.ParseAutoAnswer(req)
+	if autoAnswer == nil {
+		return nil, true
+	}
+	user := ""
+	if caller.User() != nil {
+		user = caller.User().String()
+	}
+	if policy := b.GetIntercomPolicy(); policy != nil && policy.Allowed(user) {
+		logger.Infof("Intercom call from [%v], %v", caller, autoAnswer)
+		return autoAnswer, true
+	}
+	if autoAnswer.Required {
+		logger.Infof("Intercom call from [%v] rejected, not allowed to require the auto-answer", caller)
+		return nil, false
+	}
+	logger.Debugf("Auto-answer request of [%v] removed", caller)
+	return nil, true
+}
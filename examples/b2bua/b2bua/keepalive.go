@@ -0,0 +1,145 @@
+package b2bua
+
+import (
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// KeepaliveMethod the in-dialog request of the session keep-alive.
+type KeepaliveMethod string
+
+const (
+	// KeepaliveOptions an in-dialog OPTIONS, any response but 408 and 481 means the peer is alive.
+	KeepaliveOptions KeepaliveMethod = "options"
+	// KeepaliveReInvite a re-INVITE with the unchanged SDP, for the peers which don't answer OPTIONS.
+	KeepaliveReInvite KeepaliveMethod = "reinvite"
+	// DefaultKeepaliveInterval between the keep-alives of a leg.
+	DefaultKeepaliveInterval = 60 * time.Second
+	// DefaultKeepaliveFailures unanswered keep-alives in a row before the call is torn down.
+	DefaultKeepaliveFailures = 2
+)
+
+// KeepalivePolicy detects the dead dialogs of the answered calls, an alternative to the session timers of
+// RFC 4028 with the peers which don't support them: both legs are sent a keep-alive every Interval, the
+// call is torn down once a peer answers 481 or 408, or doesn't answer Failures times in a row.
+type KeepalivePolicy struct {
+	Method   KeepaliveMethod
+	Interval time.Duration
+	Failures int
+	// ReasonProtocol, ReasonCause and ReasonText of the Reason header of the BYE sent on the teardown.
+	ReasonProtocol string
+	ReasonCause    int
+	ReasonText     string
+}
+
+// NewKeepalivePolicy .
+func NewKeepalivePolicy(method KeepaliveMethod, interval time.Duration, failures int) *KeepalivePolicy {
+	if len(method) == 0 {
+		method = KeepaliveOptions
+	}
+	if interval <= 0 {
+		interval = DefaultKeepaliveInterval
+	}
+	if failures <= 0 {
+		failures = DefaultKeepaliveFailures
+	}
+	return &KeepalivePolicy{
+		Method:         method,
+		Interval:       interval,
+		Failures:       failures,
+		ReasonProtocol: "SIP",
+		ReasonCause:    408,
+		ReasonText:     "Peer unreachable",
+	}
+}
+
+// SetKeepalivePolicy enable the keep-alives of the calls answered from now, nil to disable.
+func (b *B2BUA) SetKeepalivePolicy(policy *KeepalivePolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.keepalivePolicy = policy
+}
+
+// GetKeepalivePolicy .
+func (b *B2BUA) GetKeepalivePolicy() *KeepalivePolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.keepalivePolicy
+}
+
+// superviseKeepalive schedule the keep-alives of both legs of an answered call.
+func (b *B2BUA) superviseKeepalive(call *B2BCall) {
+	policy := b.GetKeepalivePolicy()
+	if policy == nil {
+		return
+	}
+	for _, leg := range []*session.Session{call.src, call.dest} {
+		leg := leg
+		failures := 0
+		var keepalive func()
+		keepalive = func() {
+			alive, dead := b.keepalive(policy, leg)
+			if b.findCall(leg) != call {
+				// Ended meanwhile.
+				return
+			}
+			switch {
+			case dead:
+				failures = policy.Failures
+			case alive:
+				failures = 0
+			default:
+				failures++
+			}
+			if failures >= policy.Failures {
+				logger.Infof("Call %v torn down, the peer of %v vanished", call.ToString(), leg.CallID())
+				b.teardown(call, leg, utils.NewReasonHeader(policy.ReasonProtocol, policy.ReasonCause, policy.ReasonText))
+				return
+			}
+			call.schedule(policy.Interval, keepalive)
+		}
+		call.schedule(policy.Interval, keepalive)
+	}
+}
+
+// keepalive send a keep-alive to the peer of leg: alive when it answered, dead when it answered that the
+// dialog is gone, neither when it didn't answer.
+func (b *B2BUA) keepalive(policy *KeepalivePolicy, leg *session.Session) (bool, bool) {
+	var err error
+	if policy.Method == KeepaliveReInvite {
+		_, err = leg.Refresh()
+	} else {
+		_, err = leg.Options()
+	}
+	if err == nil {
+		return true, false
+	}
+	reqErr, ok := err.(*sip.RequestError)
+	if !ok || reqErr.Response == nil {
+		logger.Debugf("Keep-alive of %v unanswered: %v", leg.CallID(), err)
+		return false, false
+	}
+	switch reqErr.Response.StatusCode() {
+	case 408, 481:
+		return false, true
+	}
+	// Rejected, e.g. 405 or 491, the peer is still there.
+	return true, false
+}
+
+// teardown end a call whose peer on dead vanished: the other leg is sent a BYE first, the dead one
+// won't answer its own.
+func (b *B2BUA) teardown(call *B2BCall, dead *session.Session, headers ...sip.Header) {
+	other := call.dest
+	if dead == call.dest {
+		other = call.src
+	}
+	other.Bye(headers...)
+	b.dialogs.Remove(call.src)
+	b.dialogs.Remove(call.dest)
+	b.removeCall(call.src)
+	go dead.Bye(headers...)
+}
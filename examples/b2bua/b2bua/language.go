@@ -0,0 +1,202 @@
+package b2bua
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// Locale the reason phrases and the announcements of a language.
+type Locale struct {
+	// Reasons localized reason phrases of the responses sent to the caller, by status code.
+	Reasons map[sip.StatusCode]string
+	// RingbackTone and WarningTone played to the caller, the default ones if nil.
+	RingbackTone *media.Tone
+	WarningTone  *media.Tone
+}
+
+// NewLocale .
+func NewLocale(reasons map[sip.StatusCode]string) *Locale {
+	if reasons == nil {
+		reasons = map[sip.StatusCode]string{}
+	}
+	return &Locale{Reasons: reasons}
+}
+
+// Reason the localized reason phrase of statusCode, reason if there is none.
+func (l *Locale) Reason(statusCode sip.StatusCode, reason string) string {
+	if localized, found := l.Reasons[statusCode]; found {
+		return localized
+	}
+	return reason
+}
+
+// LoadLocales read the reason phrases of the languages from a JSON file, e.g.
+// {"fr": {"486": "Occupé", "480": "Indisponible"}}.
+func LoadLocales(path string) (map[string]*Locale, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	phrases := map[string]map[string]string{}
+	if err := json.Unmarshal(data, &phrases); err != nil {
+		return nil, err
+	}
+	locales := map[string]*Locale{}
+	for language, reasons := range phrases {
+		locale := NewLocale(nil)
+		for code, reason := range reasons {
+			statusCode, err := strconv.Atoi(code)
+			if err != nil {
+				return nil, err
+			}
+			locale.Reasons[sip.StatusCode(statusCode)] = reason
+		}
+		locales[language] = locale
+	}
+	return locales, nil
+}
+
+// SetLocale set the locale of a language tag, e.g. fr or de-CH, nil to remove it.
+func (b *B2BUA) SetLocale(language string, locale *Locale) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	language = strings.ToLower(language)
+	if locale == nil {
+		delete(b.locales, language)
+		return
+	}
+	b.locales[language] = locale
+}
+
+// GetLocale .
+func (b *B2BUA) GetLocale(language string) *Locale {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.locales[strings.ToLower(language)]
+}
+
+// SetLanguage set the language of the callers of account, a user or the domain of a tenant, "" for the
+// server-wide one, used when the Accept-Language of the caller matches no locale. "" to remove it.
+func (b *B2BUA) SetLanguage(account string, language string) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	account = strings.ToLower(account)
+	if len(language) == 0 {
+		delete(b.languages, account)
+		return
+	}
+	b.languages[account] = strings.ToLower(language)
+}
+
+// GetLanguage .
+func (b *B2BUA) GetLanguage(account string) string {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.languages[strings.ToLower(account)]
+}
+
+// acceptLanguages the language ranges of the Accept-Language headers of req, by decreasing preference,
+// without the refused ones, q=0.
+func acceptLanguages(req sip.Request) []string {
+	type languageRange struct {
+		tag     string
+		quality float64
+	}
+	ranges := []languageRange{}
+	for _, header := range req.GetHeaders("Accept-Language") {
+		for _, value := range strings.Split(header.Value(), ",") {
+			params := strings.Split(value, ";")
+			tag := strings.ToLower(strings.TrimSpace(params[0]))
+			quality := 1.0
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						quality = q
+					}
+				}
+			}
+			if len(tag) > 0 && tag != "*" && quality > 0 {
+				ranges = append(ranges, languageRange{tag, quality})
+			}
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+	tags := []string{}
+	for _, r := range ranges {
+		tags = append(tags, r.tag)
+	}
+	return tags
+}
+
+// primaryTag the language of tag, e.g. de of de-ch.
+func primaryTag(tag string) string {
+	if i := strings.Index(tag, "-"); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+// matchLocale the locale of tag, else of a language tag sharing its primary one, e.g. en for en-gb, locked.
+func (b *B2BUA) matchLocale(tag string) *Locale {
+	if locale, found := b.locales[tag]; found {
+		return locale
+	}
+	languages := []string{}
+	for language := range b.locales {
+		if primaryTag(language) == primaryTag(tag) {
+			languages = append(languages, language)
+		}
+	}
+	if len(languages) == 0 {
+		return nil
+	}
+	sort.Strings(languages)
+	return b.locales[languages[0]]
+}
+
+// requestLocale the locale of the caller of req, nil if none: the one of the first language of its
+// Accept-Language with a locale, else of the language of its account, of its tenant, or the server-wide one.
+func (b *B2BUA) requestLocale(req sip.Request) *Locale {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	if len(b.locales) == 0 {
+		return nil
+	}
+	for _, tag := range acceptLanguages(req) {
+		if locale := b.matchLocale(tag); locale != nil {
+			return locale
+		}
+	}
+	accounts := []string{""}
+	if from, ok := req.From(); ok && from.Address != nil {
+		accounts = []string{strings.ToLower(from.Address.Host()), ""}
+		if from.Address.User() != nil {
+			accounts = append([]string{strings.ToLower(from.Address.User().String())}, accounts...)
+		}
+	}
+	for _, account := range accounts {
+		if language, found := b.languages[account]; found {
+			if locale := b.matchLocale(language); locale != nil {
+				return locale
+			}
+		}
+	}
+	return nil
+}
+
+// locale the locale of the caller of sess, an inbound leg, nil if none.
+func (b *B2BUA) locale(sess *session.Session) *Locale {
+	req := sess.Request()
+	if req == nil {
+		return nil
+	}
+	return b.requestLocale(req)
+}
@@ -0,0 +1,211 @@
+package b2bua
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// DefaultLivenessExpires max expires granted to the UDP clients behind a NAT, in s.
+	DefaultLivenessExpires = 120
+	// DefaultLivenessInterval between the OPTIONS pings.
+	DefaultLivenessInterval = 30 * time.Second
+	// DefaultLivenessFailures unanswered pings before a contact is unreachable.
+	DefaultLivenessFailures = 2
+	// livenessTimeout of a ping.
+	livenessTimeout = 5 * time.Second
+)
+
+// LivenessPolicy keeps the NAT bindings of the UDP clients open and detects the ones which are gone
+// before their registration expires: their expires is shortened and their contacts are pinged with
+// OPTIONS. The contacts which don't answer are unreachable, and skipped by the forking, until they
+// answer a ping or register again.
+type LivenessPolicy struct {
+	// MaxExpires granted to the UDP clients behind a NAT, 0 to keep the requested one.
+	MaxExpires uint32
+	// Interval between the pings.
+	Interval time.Duration
+	// Failures unanswered pings in a row before a contact is unreachable.
+	Failures int
+
+	mutex sync.Mutex
+	// failures by source of the pinged contacts.
+	failures map[string]int
+	stop     chan struct{}
+}
+
+// NewLivenessPolicy .
+func NewLivenessPolicy(maxExpires uint32, interval time.Duration, failures int) *LivenessPolicy {
+	if interval <= 0 {
+		interval = DefaultLivenessInterval
+	}
+	if failures <= 0 {
+		failures = DefaultLivenessFailures
+	}
+	return &LivenessPolicy{
+		MaxExpires: maxExpires,
+		Interval:   interval,
+		Failures:   failures,
+		failures:   make(map[string]int),
+	}
+}
+
+// Reachable the contact registered from source answers the pings.
+func (p *LivenessPolicy) Reachable(source string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.failures[source] < p.Failures
+}
+
+// reset the failures of source, after an answer or a registration.
+func (p *LivenessPolicy) reset(source string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.failures, source)
+}
+
+// fail count an unanswered ping of source, true when it becomes unreachable.
+func (p *LivenessPolicy) fail(source string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.failures[source]++
+	return p.failures[source] == p.Failures
+}
+
+// prune forget the sources which are no longer registered.
+func (p *LivenessPolicy) prune(sources map[string]bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for source := range p.failures {
+		if !sources[source] {
+			delete(p.failures, source)
+		}
+	}
+}
+
+// SetLivenessPolicy enable the pings of the UDP clients behind a NAT, nil to disable.
+func (b *B2BUA) SetLivenessPolicy(policy *LivenessPolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if b.livenessPolicy != nil {
+		close(b.livenessPolicy.stop)
+	}
+	b.livenessPolicy = policy
+	if policy != nil {
+		policy.stop = make(chan struct{})
+		go b.pingContacts(policy)
+	}
+}
+
+// GetLivenessPolicy .
+func (b *B2BUA) GetLivenessPolicy() *LivenessPolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.livenessPolicy
+}
+
+// behindNAT the UDP client registered from source is behind a NAT, its contact isn't the address
+// the request came from.
+func behindNAT(transport string, source string, contact *sip.ContactHeader) bool {
+	if !strings.EqualFold(transport, "udp") || contact == nil {
+		return false
+	}
+	host, port, err := net.SplitHostPort(source)
+	if err != nil {
+		return false
+	}
+	contactPort := "5060"
+	if contact.Address.Port() != nil {
+		contactPort = strconv.Itoa(int(*contact.Address.Port()))
+	}
+	return host != contact.Address.Host() || port != contactPort
+}
+
+// livenessExpires the expires granted to a REGISTER request, shortened for the UDP clients behind a NAT.
+func (b *B2BUA) livenessExpires(request sip.Request, expires uint32) uint32 {
+	policy := b.GetLivenessPolicy()
+	if policy == nil || expires == 0 {
+		return expires
+	}
+	policy.reset(request.Source())
+	contact, ok := request.Contact()
+	if !ok || !behindNAT(request.Transport(), request.Source(), contact) {
+		return expires
+	}
+	if policy.MaxExpires > 0 && expires > policy.MaxExpires {
+		return policy.MaxExpires
+	}
+	return expires
+}
+
+// reachableContacts the contacts which answer the pings, false if none.
+func (b *B2BUA) reachableContacts(contacts *map[string]*registry.ContactInstance) (*map[string]*registry.ContactInstance, bool) {
+	policy := b.GetLivenessPolicy()
+	if policy == nil {
+		return contacts, len(*contacts) > 0
+	}
+	reachable := make(map[string]*registry.ContactInstance)
+	for source, instance := range *contacts {
+		if policy.Reachable(instance.Source) {
+			reachable[source] = instance
+		} else {
+			logger.Debugf("Skipping unreachable contact %v", instance.Contact.Address)
+		}
+	}
+	return &reachable, len(reachable) > 0
+}
+
+// pingContacts ping the UDP clients behind a NAT every interval, until the policy is replaced.
+func (b *B2BUA) pingContacts(policy *LivenessPolicy) {
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-policy.stop:
+			return
+		case <-ticker.C:
+		}
+		sources := make(map[string]bool)
+		for aor, contacts := range b.registry.GetAllContacts() {
+			for _, instance := range contacts {
+				if !behindNAT(instance.Transport, instance.Source, instance.Contact) {
+					continue
+				}
+				sources[instance.Source] = true
+				go b.ping(policy, aor, instance)
+			}
+		}
+		policy.prune(sources)
+	}
+}
+
+// ping send an OPTIONS to a registered contact of aor, any response means it is alive.
+func (b *B2BUA) ping(policy *LivenessPolicy, aor sip.Uri, instance *registry.ContactInstance) {
+	request, err := contactRequest(sip.OPTIONS, aor, instance)
+	if err != nil {
+		logger.Errorf("Ping of %v failed: %v", instance.Contact.Address, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), livenessTimeout)
+	defer cancel()
+	_, err = b.ua.RequestWithContext(ctx, request, nil, true, 1)
+	if err == nil {
+		policy.reset(instance.Source)
+		return
+	}
+	if reqErr, ok := err.(*sip.RequestError); ok && reqErr.Response != nil {
+		// Rejected, e.g. 405 or 481, the client is still there.
+		policy.reset(instance.Source)
+		return
+	}
+	if policy.fail(instance.Source) {
+		logger.Warnf("Contact %v of [%v] is unreachable: %v", instance.Contact.Address, aor, err)
+	}
+}
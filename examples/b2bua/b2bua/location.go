@@ -0,0 +1,201 @@
+package b2bua
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/util"
+)
+
+const (
+	// ContentTypePIDF PIDF-LO, https://tools.ietf.org/html/rfc4119
+	ContentTypePIDF = "application/pidf+xml"
+	// ContentTypeSDP .
+	ContentTypeSDP = "application/sdp"
+)
+
+// Location location conveyed with a call, https://tools.ietf.org/html/rfc6442
+type Location struct {
+	// Geolocation values of the Geolocation headers, e.g. <cid:alice@example.com>
+	Geolocation []string
+	// Routing value of the Geolocation-Routing header.
+	Routing string
+	// ContentID Content-ID of the PIDF-LO body part, without <>.
+	ContentID string
+	// PIDF PIDF-LO document.
+	PIDF string
+}
+
+// HasPIDF .
+func (l *Location) HasPIDF() bool {
+	return len(l.PIDF) > 0
+}
+
+// Headers Geolocation and Geolocation-Routing headers of the location.
+func (l *Location) Headers() []sip.Header {
+	headers := []sip.Header{}
+	for _, value := range l.Geolocation {
+		headers = append(headers, &sip.GenericHeader{HeaderName: "Geolocation", Contents: value})
+	}
+	if len(l.Routing) > 0 {
+		headers = append(headers, &sip.GenericHeader{HeaderName: "Geolocation-Routing", Contents: l.Routing})
+	}
+	return headers
+}
+
+// ParseLocation extract the Geolocation headers and the PIDF-LO body part of req.
+// Returns the location (nil if the request does not convey any) and the SDP offer.
+func ParseLocation(req sip.Request) (*Location, string) {
+	location := &Location{}
+	for _, hdr := range req.GetHeaders("Geolocation") {
+		location.Geolocation = append(location.Geolocation, hdr.Value())
+	}
+	if hdrs := req.GetHeaders("Geolocation-Routing"); len(hdrs) > 0 {
+		location.Routing = hdrs[0].Value()
+	}
+
+	sdp := req.Body()
+	if contentType, ok := req.ContentType(); ok {
+		mediaType, params, err := mime.ParseMediaType(contentType.Value())
+		if err == nil && strings.HasPrefix(mediaType, "multipart/") {
+			sdp = ""
+			reader := multipart.NewReader(strings.NewReader(req.Body()), params["boundary"])
+			for {
+				part, err := reader.NextPart()
+				if err != nil {
+					break
+				}
+				data, err := ioutil.ReadAll(part)
+				if err != nil {
+					break
+				}
+				partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+				switch partType {
+				case ContentTypeSDP:
+					sdp = string(data)
+				case ContentTypePIDF:
+					location.PIDF = string(data)
+					location.ContentID = strings.Trim(part.Header.Get("Content-ID"), "<>")
+				}
+			}
+		} else if mediaType == ContentTypePIDF {
+			sdp = ""
+			location.PIDF = req.Body()
+		}
+	}
+
+	if len(location.Geolocation) == 0 && !location.HasPIDF() {
+		return nil, sdp
+	}
+	return location, sdp
+}
+
+// NewStaticLocation build a location by value from a PIDF-LO document.
+func NewStaticLocation(host string, pidf string) *Location {
+	contentID := util.RandString(8) + "@" + host
+	return &Location{
+		Geolocation: []string{"<cid:" + contentID + ">"},
+		Routing:     "yes",
+		ContentID:   contentID,
+		PIDF:        pidf,
+	}
+}
+
+// buildLocationBody build a multipart/mixed body with the SDP offer and the PIDF-LO of location.
+func buildLocationBody(sdp string, location *Location) (string, string, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	sdpHeader := textproto.MIMEHeader{}
+	sdpHeader.Set("Content-Type", ContentTypeSDP)
+	part, err := writer.CreatePart(sdpHeader)
+	if err != nil {
+		return "", "", err
+	}
+	part.Write([]byte(sdp))
+
+	pidfHeader := textproto.MIMEHeader{}
+	pidfHeader.Set("Content-Type", ContentTypePIDF)
+	if len(location.ContentID) > 0 {
+		pidfHeader.Set("Content-ID", "<"+location.ContentID+">")
+	}
+	part, err = writer.CreatePart(pidfHeader)
+	if err != nil {
+		return "", "", err
+	}
+	part.Write([]byte(location.PIDF))
+
+	if err := writer.Close(); err != nil {
+		return "", "", err
+	}
+	return body.String(), fmt.Sprintf("multipart/mixed;boundary=%s", writer.Boundary()), nil
+}
+
+// SetStaticLocation set the PIDF-LO injected in the emergency calls of username
+// when the caller does not provide its location, empty to remove.
+func (b *B2BUA) SetStaticLocation(username string, pidf string) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if len(pidf) == 0 {
+		delete(b.locations, username)
+		return
+	}
+	b.locations[username] = pidf
+}
+
+// GetStaticLocation .
+func (b *B2BUA) GetStaticLocation(username string) (string, bool) {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	pidf, found := b.locations[username]
+	return pidf, found
+}
+
+// emergencyTarget the emergency destination of req by the challenge policy, false if it isn't an emergency
+// call or the policy has no emergency destination.
+func (b *B2BUA) emergencyTarget(req sip.Request) (string, bool) {
+	if policy, ok := b.GetChallengePolicy().(interface {
+		EmergencyTarget(req sip.Request) (string, bool)
+	}); ok {
+		return policy.EmergencyTarget(req)
+	}
+	return "", false
+}
+
+// emergencyRoute the route of the emergency call to called, to the emergency destination target.
+func (b *B2BUA) emergencyRoute(sess *session.Session, setup *callSetup, called sip.Uri, target string) *Route {
+	route := &Route{Called: called, Emergency: true}
+	if called.User() != nil {
+		route.MediaSecurity = b.GetMediaSecurityPolicy(called.User().String())
+	}
+	decision := &RouteDecision{Action: RouteTo, Target: target}
+	if code, reason := decision.apply(route); code != 0 {
+		b.rejectSetup(setup, sess, code, reason)
+		return nil
+	}
+	logger.Infof("Emergency call to [%v] routed to [%s]", called, target)
+	return route
+}
+
+// emergencyLocation location passed through to the B-Leg of an emergency call,
+// the configured static location of the caller is used when the request does not convey any.
+func (b *B2BUA) emergencyLocation(req sip.Request, location *Location) *Location {
+	if location != nil {
+		return location
+	}
+	from, ok := req.From()
+	if !ok || from.Address.User() == nil {
+		return nil
+	}
+	if pidf, found := b.GetStaticLocation(from.Address.User().String()); found {
+		return NewStaticLocation(b.stack.GetNetworkInfo("udp").Host, pidf)
+	}
+	return nil
+}
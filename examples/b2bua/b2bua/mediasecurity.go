@@ -0,0 +1,127 @@
+package b2bua
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// MediaSecurityPolicy the encryption of the media the B2BUA accepts on a route.
+type MediaSecurityPolicy int
+
+const (
+	// MediaSecurityBestEffort SRTP when both parties negotiate it, RTP otherwise.
+	MediaSecurityBestEffort MediaSecurityPolicy = iota
+	// MediaSecurityRequire the calls are rejected unless SRTP is negotiated.
+	MediaSecurityRequire
+	// MediaSecurityForbid the media stays in the clear, e.g. for lawful recording or a media server,
+	// the best effort SRTP offers are renegotiated as RTP.
+	MediaSecurityForbid
+)
+
+func (p MediaSecurityPolicy) String() string {
+	switch p {
+	case MediaSecurityBestEffort:
+		return "best-effort"
+	case MediaSecurityRequire:
+		return "require"
+	case MediaSecurityForbid:
+		return "forbid"
+	}
+	return fmt.Sprintf("MediaSecurityPolicy(%d)", int(p))
+}
+
+// ParseMediaSecurityPolicy best-effort, require or forbid.
+func ParseMediaSecurityPolicy(value string) (MediaSecurityPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "best-effort", "":
+		return MediaSecurityBestEffort, nil
+	case "require":
+		return MediaSecurityRequire, nil
+	case "forbid":
+		return MediaSecurityForbid, nil
+	}
+	return MediaSecurityBestEffort, fmt.Errorf("unknown media security policy %q", value)
+}
+
+// SetMediaSecurityPolicy set the media security policy of the called numbers starting with prefix,
+// "" for the default policy.
+func (b *B2BUA) SetMediaSecurityPolicy(prefix string, policy MediaSecurityPolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.mediaSecurity[prefix] = policy
+}
+
+// GetMediaSecurityPolicy the policy of the longest matching prefix of called.
+func (b *B2BUA) GetMediaSecurityPolicy(called string) MediaSecurityPolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	policy, matched := MediaSecurityBestEffort, -1
+	for prefix, p := range b.mediaSecurity {
+		if strings.HasPrefix(called, prefix) && len(prefix) > matched {
+			policy, matched = p, len(prefix)
+		}
+	}
+	return policy
+}
+
+// offer the offer of the caller as sent to the B-Legs under the policy, 0 if it meets the policy,
+// the status code rejecting the call otherwise.
+func (p MediaSecurityPolicy) offer(offer string) (string, sip.StatusCode) {
+	if len(offer) == 0 || p == MediaSecurityBestEffort {
+		// Late offer, the answer of the caller is checked with the one of the callee. The best effort
+		// offers are relayed as is, without parsing them.
+		return offer, 0
+	}
+	encryption, err := media.MediaEncryption(offer)
+	if err != nil {
+		return offer, 0
+	}
+	switch p {
+	case MediaSecurityRequire:
+		if encryption == media.EncryptionNone {
+			return offer, 488
+		}
+	case MediaSecurityForbid:
+		if media.RequiresEncryption(offer) {
+			return offer, 488
+		}
+		if encryption != media.EncryptionNone {
+			return media.StripEncryption(offer), 0
+		}
+	}
+	return offer, 0
+}
+
+// allows the encryption negotiated by an answer meets the policy.
+func (p MediaSecurityPolicy) allows(encryption media.Encryption) bool {
+	switch p {
+	case MediaSecurityRequire:
+		return encryption != media.EncryptionNone
+	case MediaSecurityForbid:
+		return encryption == media.EncryptionNone
+	}
+	return true
+}
+
+// checkMediaSecurity record the encryption negotiated by the answer of the callee, false if it doesn't meet
+// the policy of the call, which is then released with 488.
+func (b *B2BUA) checkMediaSecurity(call *B2BCall, answer string) bool {
+	encryption, err := media.MediaEncryption(answer)
+	if err != nil {
+		encryption = media.EncryptionNone
+	}
+	call.mutex.Lock()
+	call.encryption = encryption
+	call.mutex.Unlock()
+	if call.mediaSecurity.allows(encryption) {
+		return true
+	}
+	logger.Infof("Call %v negotiated %s media, refused by the %s media security policy", call.ToString(), encryption, call.mediaSecurity)
+	call.setStatus(488, "Not Acceptable Here")
+	b.reject(call.src, 488, "Not Acceptable Here")
+	call.dest.End()
+	return false
+}
@@ -0,0 +1,49 @@
+package b2bua
+
+import (
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/numbering"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// SetNumbering translate the numbers dialed by the callers before the routing by the tables of their
+// tenant, the domain called, or of the trunk they come from, nil to route them as dialed.
+func (b *B2BUA) SetNumbering(tables *numbering.Tables) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.numbering = tables
+}
+
+// GetNumbering .
+func (b *B2BUA) GetNumbering() *numbering.Tables {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.numbering
+}
+
+// translateCalled translate the number of the Request-URI of req and of called, its To, returns the
+// translated called party. The To header is left as is, being part of the dialog of the caller.
+func (b *B2BUA) translateCalled(req sip.Request, called sip.Uri) sip.Uri {
+	tables := b.GetNumbering()
+	if tables == nil || called.User() == nil {
+		return called
+	}
+	trunkName := ""
+	if trunk := b.FindTrunk(req.Source()); trunk != nil {
+		trunkName = trunk.Name
+	}
+	table := tables.For(called.Host(), trunkName)
+	if table == nil {
+		return called
+	}
+	if recipient := req.Recipient(); recipient != nil && recipient.User() != nil {
+		if number := table.Translate(recipient.User().String()); number != recipient.User().String() {
+			req.SetRecipient(divert(recipient, number))
+		}
+	}
+	number := table.Translate(called.User().String())
+	if number == called.User().String() {
+		return called
+	}
+	logger.Infof("Called number %s translated to %s", called.User(), number)
+	return divert(called, number)
+}
@@ -0,0 +1,44 @@
+package b2bua
+
+import (
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// SetOnNetBypass skip the processing of the on-net calls, between users registered on the B2BUA: the
+// ringback of the callee is relayed and never played locally, the offer isn't filtered by the media
+// security policy, and the calls aren't admitted against the bandwidth of the trunks. Their CDRs are
+// flagged on-net.
+func (b *B2BUA) SetOnNetBypass(enabled bool) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.onNetBypass = enabled
+}
+
+// GetOnNetBypass .
+func (b *B2BUA) GetOnNetBypass() bool {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.onNetBypass
+}
+
+// onNet the call of caller in req, routed by route, is bypassed on-net: the caller sends it from one of
+// its registered contacts, and the callee is called at its registered contacts.
+func (b *B2BUA) onNet(req sip.Request, caller sip.Uri, route *Route) bool {
+	if !b.GetOnNetBypass() || len(route.Targets) > 0 {
+		return false
+	}
+	if _, found := b.registry.GetContacts(route.Called); !found {
+		return false
+	}
+	contacts, found := b.registry.GetContacts(caller)
+	source := peerIP(req.Source())
+	if !found || source == nil {
+		return false
+	}
+	for _, instance := range *contacts {
+		if ip := peerIP(instance.Source); ip != nil && ip.Equal(source) {
+			return true
+		}
+	}
+	return false
+}
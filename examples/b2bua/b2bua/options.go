@@ -0,0 +1,25 @@
+package b2bua
+
+import (
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// handleOptions answer the capability queries: an OPTIONS to the B2BUA itself is answered with its
+// capabilities, an OPTIONS to a user with 200 if the user is registered, 480 otherwise.
+func (b *B2BUA) handleOptions(request sip.Request, tx sip.ServerTransaction) {
+	if user := request.Recipient().User(); user == nil || len(user.String()) == 0 {
+		b.stack.RespondOptions(request, tx)
+		return
+	}
+
+	// The registry may be a backend, e.g. Redis, looked up off the stack worker.
+	b.stack.Offload(request, tx, func() {
+		to, _ := request.To()
+		if _, found := b.registry.GetContacts(to.Address); !found {
+			logger.Debugf("OPTIONS to offline user [%v]", to.Address)
+			tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 480, "Temporarily Unavailable", ""))
+			return
+		}
+		b.stack.RespondOptions(request, tx)
+	})
+}
@@ -0,0 +1,95 @@
+package b2bua
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRegisterRetryAfter .
+	DefaultRegisterRetryAfter = 30 // s
+)
+
+// RegisterPacer spreads registration load, e.g. when thousands of clients
+// re-register at once after a restart of the B2BUA. Only the REGISTERs authenticated
+// and allowed to register their AOR are paced.
+type RegisterPacer struct {
+	// MinExpires and MaxExpires band of the expires granted to the clients,
+	// the granted value is picked randomly in [MinExpires, min(requested, MaxExpires)]
+	// so that refreshes do not stay synchronized.
+	MinExpires uint32
+	MaxExpires uint32
+	// Rate REGISTER requests accepted per second, 0 for unlimited.
+	Rate float64
+	// Burst REGISTER requests accepted at once above Rate.
+	Burst int
+	// RetryAfter seconds in the 503 responses of rejected requests.
+	RetryAfter uint32
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRegisterPacer .
+func NewRegisterPacer(minExpires, maxExpires uint32, rate float64, burst int) *RegisterPacer {
+	return &RegisterPacer{
+		MinExpires: minExpires,
+		MaxExpires: maxExpires,
+		Rate:       rate,
+		Burst:      burst,
+		RetryAfter: DefaultRegisterRetryAfter,
+		tokens:     float64(burst),
+		last:       time.Now(),
+	}
+}
+
+// Allow token bucket, returns false when the request exceeds the configured rate.
+func (p *RegisterPacer) Allow() bool {
+	if p.Rate <= 0 {
+		return true
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := time.Now()
+	p.tokens += now.Sub(p.last).Seconds() * p.Rate
+	if capacity := float64(p.Burst) + p.Rate; p.tokens > capacity {
+		p.tokens = capacity
+	}
+	p.last = now
+	if p.tokens < 1 {
+		return false
+	}
+	p.tokens--
+	return true
+}
+
+// Expires the expires granted for the requested value.
+func (p *RegisterPacer) Expires(requested uint32) uint32 {
+	if requested == 0 || p.MaxExpires == 0 {
+		return requested
+	}
+	upper := requested
+	if upper > p.MaxExpires {
+		upper = p.MaxExpires
+	}
+	if upper <= p.MinExpires {
+		return upper
+	}
+	return p.MinExpires + uint32(rand.Int63n(int64(upper-p.MinExpires)+1))
+}
+
+// SetRegisterPacer enable registration pacing, nil to disable.
+func (b *B2BUA) SetRegisterPacer(pacer *RegisterPacer) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.pacer = pacer
+}
+
+// GetRegisterPacer .
+func (b *B2BUA) GetRegisterPacer() *RegisterPacer {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.pacer
+}
@@ -0,0 +1,149 @@
+package b2bua
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/events"
+)
+
+// localTrunk the trunk of the post-dial delays of the calls to the registered users.
+const localTrunk = "local"
+
+// postDialDelayBuckets the upper bounds of the buckets of the post-dial delay histograms.
+var postDialDelayBuckets = []time.Duration{
+	500 * time.Millisecond, time.Second, 2 * time.Second, 3 * time.Second, 5 * time.Second,
+	8 * time.Second, 13 * time.Second, 20 * time.Second, 30 * time.Second,
+}
+
+// HistogramBucket the observations lower or equal to LE seconds, cumulative as the Prometheus ones.
+type HistogramBucket struct {
+	LE    float64 `json:"le"`
+	Count uint64  `json:"count"`
+}
+
+// Histogram of delays, Sum in seconds.
+type Histogram struct {
+	Buckets []HistogramBucket `json:"buckets"`
+	Count   uint64            `json:"count"`
+	Sum     float64           `json:"sum"`
+}
+
+// delayHistogram counts the delays per bucket of postDialDelayBuckets, the last one is +Inf.
+type delayHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    time.Duration
+}
+
+func (h *delayHistogram) observe(delay time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(postDialDelayBuckets)+1)
+	}
+	idx := len(postDialDelayBuckets)
+	for i, bound := range postDialDelayBuckets {
+		if delay <= bound {
+			idx = i
+			break
+		}
+	}
+	h.counts[idx]++
+	h.count++
+	h.sum += delay
+}
+
+func (h *delayHistogram) snapshot() Histogram {
+	histogram := Histogram{Count: h.count, Sum: h.sum.Seconds()}
+	cumulative := uint64(0)
+	for i, bound := range postDialDelayBuckets {
+		cumulative += h.counts[i]
+		histogram.Buckets = append(histogram.Buckets, HistogramBucket{LE: bound.Seconds(), Count: cumulative})
+	}
+	return histogram
+}
+
+// postDialDelays the post-dial delay histograms by trunk.
+type postDialDelays struct {
+	mutex      sync.Mutex
+	histograms map[string]*delayHistogram
+}
+
+func (p *postDialDelays) observe(trunk string, delay time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.histograms == nil {
+		p.histograms = make(map[string]*delayHistogram)
+	}
+	histogram, found := p.histograms[trunk]
+	if !found {
+		histogram = &delayHistogram{}
+		p.histograms[trunk] = histogram
+	}
+	histogram.observe(delay)
+}
+
+func (p *postDialDelays) snapshot() map[string]Histogram {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	histograms := make(map[string]Histogram, len(p.histograms))
+	for trunk, histogram := range p.histograms {
+		histograms[trunk] = histogram.snapshot()
+	}
+	return histograms
+}
+
+// SetPostDialDelayThreshold the post-dial delay, from the INVITE of the caller to the first progress of
+// the callee, over which a call publishes a call.slow_progress event, unless its trunk has its own, 0 to
+// disable.
+func (b *B2BUA) SetPostDialDelayThreshold(threshold time.Duration) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.postDialDelayThreshold = threshold
+}
+
+// GetPostDialDelayThreshold .
+func (b *B2BUA) GetPostDialDelayThreshold() time.Duration {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.postDialDelayThreshold
+}
+
+// PostDialDelays the post-dial delay histograms by trunk, "local" for the calls to the registered users.
+func (b *B2BUA) PostDialDelays() map[string]Histogram {
+	return b.postDialDelays.snapshot()
+}
+
+// postDialDelay measure the post-dial delay of call on its first progress, and publish a slow progress
+// event over the threshold.
+func (b *B2BUA) postDialDelay(call *B2BCall) {
+	delay := call.Timing().PostDialDelay()
+	trunkName := call.trunk
+	if len(trunkName) == 0 {
+		trunkName = localTrunk
+	}
+	b.postDialDelays.observe(trunkName, delay)
+
+	threshold := b.GetPostDialDelayThreshold()
+	if trunk := b.trunkNamed(call.trunk); len(call.trunk) > 0 && trunk != nil && trunk.PostDialDelayThreshold > 0 {
+		threshold = trunk.PostDialDelayThreshold
+	}
+	if threshold <= 0 || delay <= threshold {
+		return
+	}
+	logger.Infof("Call %v progressed after %v on %s, over %v", call.ToString(), delay, trunkName, threshold)
+	if b.GetEventBus() == nil {
+		return
+	}
+	record := call.Record()
+	b.publish(&events.Event{
+		Type:          events.CallSlowProgress,
+		CallID:        record.CallID,
+		CorrelationID: record.CorrelationID,
+		Caller:        record.Caller,
+		Called:        record.Called,
+		Source:        record.Source,
+		Destination:   record.Destination,
+		Trunk:         call.trunk,
+		PostDialDelay: delay,
+	})
+}
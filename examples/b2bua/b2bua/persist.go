@@ -0,0 +1,277 @@
+package b2bua
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/events"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// DefaultStateCheckpoint interval of the updates of the persisted calls.
+	DefaultStateCheckpoint = time.Minute
+	// recoveryTimeout of the BYE of a recovered leg.
+	recoveryTimeout = 10 * time.Second
+)
+
+// CallState the persisted state of an answered call.
+type CallState struct {
+	// ID the Call-ID of the caller leg.
+	ID string `json:"id"`
+	// Record the call detail record at the last checkpoint.
+	Record *cdr.Record `json:"record"`
+	// Legs the dialogs of the caller and the callee legs.
+	Legs []session.Dialog `json:"legs"`
+	// Media the media anchors of the legs, host:port.
+	Media []string `json:"media,omitempty"`
+	// Tenant the domain of the called party.
+	Tenant string `json:"tenant,omitempty"`
+	// Updated time of the last checkpoint, the call was up until then at least.
+	Updated time.Time `json:"updated"`
+}
+
+// StateStore persists the state of the answered calls, to recover them after a restart.
+type StateStore interface {
+	Save(state *CallState) error
+	Delete(id string) error
+	// Load the calls left by the previous process.
+	Load() ([]*CallState, error)
+}
+
+// FileStateStore a StateStore keeping a JSON file per call in a directory.
+type FileStateStore struct {
+	dir string
+}
+
+// NewFileStateStore .
+func NewFileStateStore(dir string) (*FileStateStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileStateStore{dir: dir}, nil
+}
+
+func (s *FileStateStore) path(id string) string {
+	// The Call-ID may hold any character.
+	return filepath.Join(s.dir, strings.NewReplacer("/", "_", "\\", "_").Replace(id)+".json")
+}
+
+// Save write the file atomically, a crash must not leave a truncated state.
+func (s *FileStateStore) Save(state *CallState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(s.dir, ".call-")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), s.path(state.ID))
+}
+
+// Delete .
+func (s *FileStateStore) Delete(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Load .
+func (s *FileStateStore) Load() ([]*CallState, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	states := []*CallState{}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		state := &CallState{}
+		if err := json.Unmarshal(data, state); err != nil || state.Record == nil {
+			logger.Errorf("Invalid call state %s: %v", path, err)
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// SetStateStore persist the answered calls in store, nil to disable, checkpoint is the interval of
+// the updates, DefaultStateCheckpoint if 0.
+func (b *B2BUA) SetStateStore(store StateStore, checkpoint time.Duration) {
+	if checkpoint <= 0 {
+		checkpoint = DefaultStateCheckpoint
+	}
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.stateStore = store
+	b.stateCheckpoint = checkpoint
+}
+
+// GetStateStore .
+func (b *B2BUA) GetStateStore() (StateStore, time.Duration) {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.stateStore, b.stateCheckpoint
+}
+
+// callState the state of an answered call.
+func (call *B2BCall) callState() *CallState {
+	state := &CallState{
+		Record:  call.Record(),
+		Legs:    []session.Dialog{call.src.Dialog(), call.dest.Dialog()},
+		Updated: time.Now(),
+	}
+	state.ID = state.Record.CallID
+	for _, desc := range []string{call.src.RemoteSdp(), call.dest.RemoteSdp()} {
+		if addresses, err := media.MediaAddresses(desc); err == nil {
+			state.Media = append(state.Media, addresses...)
+		}
+	}
+	if req := call.src.Request(); req != nil {
+		if to, ok := req.To(); ok {
+			state.Tenant = to.Address.Host()
+		}
+	}
+	return state
+}
+
+// persistCall save the state of an answered call and schedule the checkpoints.
+func (b *B2BUA) persistCall(call *B2BCall) {
+	store, checkpoint := b.GetStateStore()
+	if store == nil {
+		return
+	}
+	call.mutex.Lock()
+	call.stateStore = store
+	call.mutex.Unlock()
+	var save func()
+	save = func() {
+		if err := store.Save(call.callState()); err != nil {
+			logger.Errorf("Saving the state of %v failed: %v", call.ToString(), err)
+		}
+		call.schedule(checkpoint, save)
+	}
+	save()
+}
+
+// forgetCall delete the state of a released call.
+func (b *B2BUA) forgetCall(call *B2BCall) {
+	call.mutex.Lock()
+	store := call.stateStore
+	call.mutex.Unlock()
+	if store == nil {
+		return
+	}
+	if callID := call.src.CallID(); callID != nil {
+		if err := store.Delete(callID.Value()); err != nil {
+			logger.Errorf("Deleting the state of %v failed: %v", call.ToString(), err)
+		}
+	}
+}
+
+// RecoverCalls release the calls left by the previous process: their records and accounting
+// are closed at the last checkpoint and both legs get a BYE. Call it once at startup, after
+// the listeners are up.
+func (b *B2BUA) RecoverCalls() (int, error) {
+	store, _ := b.GetStateStore()
+	if store == nil {
+		return 0, nil
+	}
+	states, err := store.Load()
+	if err != nil {
+		return 0, err
+	}
+	for _, state := range states {
+		b.recoverCall(state)
+		if err := store.Delete(state.ID); err != nil {
+			logger.Errorf("Deleting the state of %s failed: %v", state.ID, err)
+		}
+	}
+	return len(states), nil
+}
+
+func (b *B2BUA) recoverCall(state *CallState) {
+	logger.Infof("Recovering call %s from [%s] to [%s], media %v", state.ID, state.Record.Caller, state.Record.Called, state.Media)
+	b.releaseState(state, state.Updated, "")
+}
+
+// releaseState close the record and the accounting of a call at ended and send a BYE on its legs,
+// except the one with the Call-ID hungUp.
+func (b *B2BUA) releaseState(state *CallState, ended time.Time, hungUp string) {
+	record := state.Record
+	record.Ended = ended
+	if !record.Answered.IsZero() && record.Ended.After(record.Answered) {
+		record.Duration = record.Ended.Sub(record.Answered)
+	}
+	record.StatusCode = 200
+	record.Reason = "OK"
+
+	if writer := b.GetCDRWriter(); writer != nil {
+		if err := writer.Write(record); err != nil {
+			logger.Errorf("CDR write failed: %v", err)
+		}
+	}
+	if accounting, _ := b.GetAccounting(); accounting != nil {
+		if err := accounting.Stop(record); err != nil {
+			logger.Errorf("Accounting stop of %s failed: %v", state.ID, err)
+		}
+	}
+	b.publish(&events.Event{
+		Type:        events.CallEnded,
+		CallID:      record.CallID,
+		Caller:      record.Caller,
+		Called:      record.Called,
+		Source:      record.Source,
+		Destination: record.Destination,
+		Tenant:      state.Tenant,
+		Time:        record.Ended,
+		Duration:    record.Duration,
+		StatusCode:  record.StatusCode,
+		Reason:      record.Reason,
+	})
+
+	for _, dialog := range state.Legs {
+		if dialog.CallID == hungUp {
+			continue
+		}
+		var headers []sip.Header
+		if len(hungUp) == 0 {
+			headers = append(headers, utils.NewReasonHeader("SIP", 500, "Call recovered after restart"))
+		}
+		bye, err := dialog.Bye(headers...)
+		if err != nil {
+			logger.Errorf("BYE of the recovered leg %s failed: %v", dialog.CallID, err)
+			continue
+		}
+		go func(callID string) {
+			ctx, cancel := context.WithTimeout(context.Background(), recoveryTimeout)
+			defer cancel()
+			if _, err := b.ua.RequestWithContext(ctx, bye, nil, true, 1); err != nil {
+				logger.Warnf("BYE of the recovered leg %s: %v", callID, err)
+			}
+		}(dialog.CallID)
+	}
+}
@@ -0,0 +1,148 @@
+package b2bua
+
+import (
+	"net"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+var (
+	// DefaultEmergencyNumbers .
+	DefaultEmergencyNumbers = []string{"112", "911"}
+)
+
+// ChallengePolicy default challenge policy of the B2BUA.
+type ChallengePolicy struct {
+	// TrustedNetworks requests from these networks are never challenged, e.g. a PSTN gateway.
+	TrustedNetworks []*net.IPNet
+	// EmergencyNumbers INVITEs to these numbers are never challenged, provided that EmergencyDestination is
+	// set: they are routed there.
+	EmergencyNumbers []string
+	// EmergencyDestination SIP URI the emergency calls are routed to, e.g. the gateway of the PSAP. The
+	// emergency numbers are challenged and routed as the other numbers if empty.
+	EmergencyDestination string
+	// Dialogs in-dialog requests matching a known dialog are not challenged,
+	// others are rejected with 481.
+	Dialogs DialogMatcher
+}
+
+// NewChallengePolicy .
+func NewChallengePolicy() *ChallengePolicy {
+	return &ChallengePolicy{
+		TrustedNetworks:  []*net.IPNet{},
+		EmergencyNumbers: DefaultEmergencyNumbers,
+	}
+}
+
+// AddTrustedNetwork add a CIDR, e.g. 10.0.0.0/8, to the trusted networks.
+func (p *ChallengePolicy) AddTrustedNetwork(cidr string) error {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	p.TrustedNetworks = append(p.TrustedNetworks, network)
+	return nil
+}
+
+// IsTrusted .
+func (p *ChallengePolicy) IsTrusted(source stack.RequestSource) bool {
+	host, _, err := net.SplitHostPort(source.Addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p.TrustedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEmergency check if the To user, the called party the B2BUA routes on, is an emergency number.
+func (p *ChallengePolicy) IsEmergency(req sip.Request) bool {
+	to, ok := req.To()
+	if !ok || to.Address == nil || to.Address.User() == nil {
+		return false
+	}
+	user := to.Address.User().String()
+	for _, number := range p.EmergencyNumbers {
+		if user == number {
+			return true
+		}
+	}
+	return false
+}
+
+// EmergencyTarget the EmergencyDestination of req if it is an emergency call, false if it isn't or there is
+// no EmergencyDestination.
+func (p *ChallengePolicy) EmergencyTarget(req sip.Request) (string, bool) {
+	if len(p.EmergencyDestination) == 0 || !p.IsEmergency(req) {
+		return "", false
+	}
+	return p.EmergencyDestination, true
+}
+
+// Challenge implements stack.ChallengePolicy
+func (p *ChallengePolicy) Challenge(req sip.Request, source stack.RequestSource) stack.ChallengeResult {
+	allow := stack.ChallengeResult{Decision: stack.ChallengeAllow}
+	challenge := stack.ChallengeResult{Decision: stack.ChallengeRequired}
+
+	if p.IsTrusted(source) {
+		return allow
+	}
+
+	if isInDialog(req) && req.Method() != sip.ACK {
+		if p.Dialogs != nil && p.Dialogs.MatchDialog(req) {
+			return allow
+		}
+		return stack.ChallengeResult{
+			Decision:   stack.ChallengeReject,
+			StatusCode: 481,
+			Reason:     "Call/Transaction Does Not Exist",
+		}
+	}
+
+	switch req.Method() {
+	case sip.REGISTER:
+		return challenge
+	case sip.INVITE:
+		if _, routed := p.EmergencyTarget(req); routed {
+			// Routed to the emergency destination only.
+			return allow
+		}
+		return challenge
+	case sip.CANCEL:
+		return allow
+	case sip.OPTIONS:
+		return allow
+	case sip.INFO:
+		return allow
+	case sip.BYE:
+		// BYE outside of a dialog.
+		return challenge
+	}
+	return allow
+}
+
+// SetChallengePolicy replace the challenge policy of the B2BUA.
+func (b *B2BUA) SetChallengePolicy(policy stack.ChallengePolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.challengePolicy = policy
+}
+
+// GetChallengePolicy .
+func (b *B2BUA) GetChallengePolicy() stack.ChallengePolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.challengePolicy
+}
+
+func (b *B2BUA) challenge(req sip.Request, source stack.RequestSource) stack.ChallengeResult {
+	return b.GetChallengePolicy().Challenge(req, source)
+}
@@ -0,0 +1,51 @@
+package b2bua
+
+import (
+	"testing"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+func parseRequest(t *testing.T, raw string) sip.Request {
+	msg, err := parser.ParseMessage([]byte(raw), log.NewDefaultLogrusLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.(sip.Request)
+}
+
+func newInvite(t *testing.T, requestURI string, to string) sip.Request {
+	return parseRequest(t, "INVITE "+requestURI+" SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK776asdhds\r\n"+
+		"From: <sip:alice@example.com>;tag=1928301774\r\n"+
+		"To: <"+to+">\r\n"+
+		"Call-ID: a84b4c76e66710\r\n"+
+		"CSeq: 1 INVITE\r\n"+
+		"Content-Length: 0\r\n\r\n")
+}
+
+func TestChallengeEmergency(t *testing.T) {
+	source := stack.RequestSource{Addr: "192.0.2.1:5060"}
+	routed := NewChallengePolicy()
+	routed.EmergencyDestination = "sip:psap.example.com"
+	for _, test := range []struct {
+		name     string
+		policy   *ChallengePolicy
+		uri, to  string
+		decision stack.ChallengeDecision
+	}{
+		{"routed", routed, "sip:112@example.com", "sip:112@example.com", stack.ChallengeAllow},
+		{"spoofed Request-URI", routed, "sip:911@example.com", "sip:bob@example.com", stack.ChallengeRequired},
+		{"To only", routed, "sip:bob@example.com", "sip:911@example.com", stack.ChallengeAllow},
+		{"no destination", NewChallengePolicy(), "sip:112@example.com", "sip:112@example.com", stack.ChallengeRequired},
+		{"other number", routed, "sip:bob@example.com", "sip:bob@example.com", stack.ChallengeRequired},
+	} {
+		result := test.policy.Challenge(newInvite(t, test.uri, test.to), source)
+		if result.Decision != test.decision {
+			t.Errorf("%s: decision = %v, want %v", test.name, result.Decision, test.decision)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package b2bua
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/auth"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/ghettovoice/gosip/util"
+)
+
+// ProvisioningAction what a device does on a provisioning NOTIFY.
+type ProvisioningAction string
+
+const (
+	// ProvisionCheckSync fetch the configuration, and reboot if it changed.
+	ProvisionCheckSync ProvisioningAction = "check-sync"
+	// ProvisionResync fetch the configuration without rebooting.
+	ProvisionResync ProvisioningAction = "resync"
+	// ProvisionReboot reboot, the configuration is fetched at boot.
+	ProvisionReboot ProvisioningAction = "reboot"
+	// provisionTimeout per device.
+	provisionTimeout = 5 * time.Second
+)
+
+var (
+	// ProvisioningEvents the Event header of the provisioning NOTIFY per action, by vendor, matched
+	// in lower case in the User-Agent of the registration. The "" vendor is the default.
+	ProvisioningEvents = map[string]map[ProvisioningAction]string{
+		"": {
+			ProvisionCheckSync: "check-sync",
+			ProvisionResync:    "check-sync;reboot=false",
+			ProvisionReboot:    "check-sync;reboot=true",
+		},
+		"polycom": {
+			ProvisionCheckSync: "check-sync",
+			ProvisionResync:    "check-sync;reboot=false",
+			ProvisionReboot:    "check-sync;reboot=true",
+		},
+		"yealink": {
+			ProvisionCheckSync: "check-sync",
+			ProvisionResync:    "check-sync;reboot=false",
+			ProvisionReboot:    "check-sync;reboot=true",
+		},
+		"snom": {
+			ProvisionCheckSync: "check-sync",
+			ProvisionResync:    "check-sync;reboot=false",
+			ProvisionReboot:    "check-sync;reboot=true",
+		},
+		"grandstream": {
+			ProvisionCheckSync: "check-sync",
+			ProvisionResync:    "resync",
+			ProvisionReboot:    "check-sync",
+		},
+		// Cisco SPA and the Linksys phones.
+		"cisco": {
+			ProvisionCheckSync: "resync",
+			ProvisionResync:    "resync",
+			ProvisionReboot:    "reboot",
+		},
+		"linksys": {
+			ProvisionCheckSync: "resync",
+			ProvisionResync:    "resync",
+			ProvisionReboot:    "reboot",
+		},
+	}
+)
+
+// ProvisioningResult the answer of a device to a provisioning NOTIFY.
+type ProvisioningResult struct {
+	AOR        string `json:"aor"`
+	Contact    string `json:"contact"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	Event      string `json:"event"`
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error,omitempty"`
+}
+
+// provisioningEvent the Event of action for the device with userAgent.
+func provisioningEvent(userAgent string, action ProvisioningAction) (string, error) {
+	events := ProvisioningEvents[""]
+	userAgent = strings.ToLower(userAgent)
+	for vendor, vendorEvents := range ProvisioningEvents {
+		if len(vendor) > 0 && strings.Contains(userAgent, vendor) {
+			events = vendorEvents
+			break
+		}
+	}
+	event, found := events[action]
+	if !found {
+		return "", fmt.Errorf("unknown provisioning action %q", action)
+	}
+	return event, nil
+}
+
+// Provision send the provisioning NOTIFY of action to the registered devices of aor, or of all
+// the AORs if nil, e.g. to have a phone fleet fetch its new configuration.
+func (b *B2BUA) Provision(aor sip.Uri, action ProvisioningAction) ([]ProvisioningResult, error) {
+	if _, err := provisioningEvent("", action); err != nil {
+		return nil, err
+	}
+	bindings := make(map[sip.Uri]map[string]*registry.ContactInstance)
+	if aor == nil {
+		bindings = b.registry.GetAllContacts()
+	} else if contacts, found := b.registry.GetContacts(aor); found {
+		bindings[aor] = *contacts
+	} else {
+		return nil, fmt.Errorf("%v is not registered", aor)
+	}
+
+	var (
+		mutex   sync.Mutex
+		wg      sync.WaitGroup
+		results []ProvisioningResult
+	)
+	for aor, contacts := range bindings {
+		for _, instance := range contacts {
+			wg.Add(1)
+			go func(aor sip.Uri, instance *registry.ContactInstance) {
+				defer wg.Done()
+				result := b.provision(aor, instance, action)
+				mutex.Lock()
+				results = append(results, result)
+				mutex.Unlock()
+			}(aor, instance)
+		}
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// provision send the NOTIFY of action to a registered device of aor.
+func (b *B2BUA) provision(aor sip.Uri, instance *registry.ContactInstance, action ProvisioningAction) ProvisioningResult {
+	event, _ := provisioningEvent(instance.UserAgent, action)
+	result := ProvisioningResult{
+		AOR:       aor.String(),
+		Contact:   instance.Contact.Address.String(),
+		UserAgent: instance.UserAgent,
+		Event:     event,
+	}
+
+	request, err := contactRequest(sip.NOTIFY, aor, instance, &sip.GenericHeader{HeaderName: "Event", Contents: event})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	// The phones may challenge the NOTIFY with the credentials of the line.
+	var authorizer sip.Authorizer
+	if user := aor.User(); user != nil {
+		if password, found := b.GetAccounts()[user.String()]; found {
+			authorizer = auth.NewClientAuthorizer(user.String(), password)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), provisionTimeout)
+	defer cancel()
+	response, err := b.ua.RequestWithContext(ctx, request, authorizer, true, 1)
+	if err != nil {
+		result.Error = err.Error()
+		if reqErr, ok := err.(*sip.RequestError); ok {
+			result.StatusCode = int(reqErr.Code)
+		}
+		logger.Warnf("Provisioning %s of %s failed: %v", event, result.Contact, err)
+		return result
+	}
+	result.StatusCode = int(response.StatusCode())
+	logger.Infof("Provisioning %s sent to %s: %d", event, result.Contact, result.StatusCode)
+	return result
+}
+
+// contactRequest an out of dialog request to a registered device of aor, sent to the source of its registration.
+func contactRequest(method sip.RequestMethod, aor sip.Uri, instance *registry.ContactInstance, headers ...sip.Header) (sip.Request, error) {
+	builder := sip.NewRequestBuilder()
+	builder.SetMethod(method)
+	builder.SetRecipient(instance.Contact.Address.Clone())
+	builder.SetFrom(&sip.Address{
+		Uri:    aor.Clone(),
+		Params: sip.NewParams().Add("tag", sip.String{Str: util.RandString(8)}),
+	})
+	builder.SetTo(&sip.Address{Uri: aor.Clone()})
+	callID := sip.CallID(util.RandString(32))
+	builder.SetCallID(&callID)
+	for _, header := range headers {
+		builder.AddHeader(header)
+	}
+	request, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	request.SetDestination(instance.Source)
+	request.SetTransport(instance.Transport)
+	return request, nil
+}
+
+// ProvisioningHandler send the provisioning NOTIFYs on POST, with the action query parameter,
+// check-sync by default, to the devices of the aor parameter, or of all the AORs if missing.
+// The results are returned as JSON.
+func (b *B2BUA) ProvisioningHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		action := ProvisioningAction(r.URL.Query().Get("action"))
+		if len(action) == 0 {
+			action = ProvisionCheckSync
+		}
+		var aor sip.Uri
+		if value := r.URL.Query().Get("aor"); len(value) > 0 {
+			uri, err := parser.ParseUri(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			aor = uri
+		}
+		results, err := b.Provision(aor, action)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	})
+}
@@ -0,0 +1,132 @@
+package b2bua
+
+import (
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// SetCDRWriter set the sink of the call detail records, nil to disable.
+func (b *B2BUA) SetCDRWriter(writer cdr.Writer) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.cdrWriter = writer
+}
+
+// GetCDRWriter .
+func (b *B2BUA) GetCDRWriter() cdr.Writer {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.cdrWriter
+}
+
+// setStatus record the final failure response of the call.
+func (call *B2BCall) setStatus(statusCode sip.StatusCode, reason string) {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	if call.statusCode == 0 {
+		call.statusCode = statusCode
+		call.reason = reason
+	}
+}
+
+// setCause record the cause of the termination, the first one.
+func (call *B2BCall) setCause(cause cdr.Cause) {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	if len(call.cause) == 0 {
+		call.cause = cause
+	}
+}
+
+// Record the call detail record of the call.
+func (call *B2BCall) Record() *cdr.Record {
+	timing := call.Timing()
+	call.mutex.Lock()
+	statusCode, reason, cause := call.statusCode, call.reason, call.cause
+	encryption := call.encryption
+	call.mutex.Unlock()
+
+	record := &cdr.Record{
+		Setup:              timing.Setup,
+		Ringing:            timing.Ringing,
+		EarlyMedia:         timing.EarlyMedia,
+		Answered:           timing.Answered,
+		Ended:              timing.Ended,
+		Duration:           timing.BillableDuration(),
+		EarlyMediaDuration: timing.EarlyMediaDuration(),
+		PostDialDelay:      timing.PostDialDelay(),
+		StatusCode:         int(statusCode),
+		Reason:             reason,
+		MediaSecurity:      call.mediaSecurity.String(),
+		MediaEncryption:    string(encryption),
+		OnNet:              call.onNet,
+		CorrelationID:      call.correlationID,
+		Emergency:          call.IsEmergency(),
+		Location:           call.HasLocation(),
+	}
+	if callID := call.src.CallID(); callID != nil {
+		record.CallID = callID.Value()
+	}
+	if callID := call.dest.CallID(); callID != nil {
+		record.CalleeCallID = callID.Value()
+	}
+	if req := call.src.Request(); req != nil {
+		record.Source = req.Source()
+		if from, ok := req.From(); ok && from.Address != nil {
+			record.Caller = aorOf(from.Address)
+		}
+		if to, ok := req.To(); ok && to.Address != nil {
+			record.Called = aorOf(to.Address)
+		}
+	}
+	if req := call.dest.Request(); req != nil {
+		record.Destination = req.Destination()
+	}
+
+	// The hangups are those of the answered calls, an unanswered call is abandoned or rejected unless
+	// released or transferred.
+	hungUp := len(cause) == 0 || cause == cdr.CallerHangup || cause == cdr.CalleeHangup
+	switch {
+	case !timing.Answered.IsZero():
+		record.Disposition = cdr.Answered
+		record.StatusCode = 200
+		record.Reason = "OK"
+	case statusCode == 487 || statusCode == 0:
+		record.Disposition = cdr.Cancelled
+		if hungUp {
+			cause = cdr.Abandoned
+		}
+	default:
+		record.Disposition = cdr.Failed
+		if hungUp {
+			cause = cdr.Rejected
+		}
+	}
+	record.Cause = cause
+	if len(record.Cause) == 0 {
+		record.Cause = cdr.Released
+	}
+	return record
+}
+
+// aorOf the address of record of uri, without its port and parameters, e.g. sip:alice@example.com.
+func aorOf(uri sip.Uri) string {
+	scheme := "sip:"
+	if uri.IsEncrypted() {
+		scheme = "sips:"
+	}
+	if uri.User() == nil {
+		return scheme + uri.Host()
+	}
+	return scheme + uri.User().String() + "@" + uri.Host()
+}
+
+func (b *B2BUA) writeCDR(call *B2BCall) {
+	writer := b.GetCDRWriter()
+	if writer == nil {
+		return
+	}
+	if err := writer.Write(call.Record()); err != nil {
+		logger.Errorf("CDR write failed: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package b2bua
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+func TestRecordCause(t *testing.T) {
+	req := parseRequest(t, "INVITE sip:bob@example.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK776asdhds\r\n"+
+		"From: <sip:alice@example.com:5060;transport=udp>;tag=1928301774\r\n"+
+		"To: <sips:bob@example.com>\r\n"+
+		"Call-ID: a84b4c76e66710\r\n"+
+		"CSeq: 1 INVITE\r\n"+
+		"Contact: <sip:alice@192.0.2.1:5060>\r\n"+
+		"Content-Length: 0\r\n\r\n")
+	contacts, _ := req.Contact()
+	for _, test := range []struct {
+		name        string
+		cause       cdr.Cause
+		statusCode  sip.StatusCode
+		disposition cdr.Disposition
+		want        cdr.Cause
+	}{
+		{"cancelled", cdr.CallerHangup, 487, cdr.Cancelled, cdr.Abandoned},
+		{"rejected", cdr.CalleeHangup, 486, cdr.Failed, cdr.Rejected},
+		{"released before the answer", cdr.Released, 487, cdr.Cancelled, cdr.Released},
+		{"transferred before the answer", cdr.Transferred, 0, cdr.Cancelled, cdr.Transferred},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			src := session.NewInviteSession(nil, "UAS", contacts, req, "a84b4c76e66710", nil, session.Incoming, nil)
+			call := &B2BCall{src: src, dest: src, timing: CallTiming{Setup: time.Now(), Ended: time.Now()}}
+			call.setCause(test.cause)
+			if test.statusCode != 0 {
+				call.setStatus(test.statusCode, "")
+			}
+			record := call.Record()
+			if record.Disposition != test.disposition || record.Cause != test.want {
+				t.Errorf("disposition %s, cause %s, want %s, %s", record.Disposition, record.Cause, test.disposition, test.want)
+			}
+			if record.Caller != "sip:alice@example.com" || record.Called != "sips:bob@example.com" {
+				t.Errorf("caller %s, called %s, want their AORs", record.Caller, record.Called)
+			}
+		})
+	}
+}
+
+func TestRecordEmergency(t *testing.T) {
+	req := newInvite(t, "sip:112@example.com", "sip:112@example.com")
+	src := session.NewInviteSession(nil, "UAS", &sip.ContactHeader{Address: req.Recipient()}, req, "a84b4c76e66710", nil, session.Incoming, nil)
+	call := &B2BCall{src: src, dest: src, emergency: true, location: NewStaticLocation("example.com", "<presence/>")}
+	record := call.Record()
+	if !record.Emergency || !record.Location {
+		t.Errorf("emergency %v, location %v, want both", record.Emergency, record.Location)
+	}
+}
@@ -0,0 +1,129 @@
+package b2bua
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// RegisterAuthorizer decides whether the account username may register aor besides its own, e.g. the
+// devices of a hunt group sharing an AOR, or an assistant registering on behalf of a manager.
+type RegisterAuthorizer func(username string, aor sip.Uri) bool
+
+// SetRegisterAuthorizer allow the accounts to register the AORs authorizer accepts, nil to allow their
+// own AOR only.
+func (b *B2BUA) SetRegisterAuthorizer(authorizer RegisterAuthorizer) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.registerAuthorizer = authorizer
+}
+
+// GetRegisterAuthorizer .
+func (b *B2BUA) GetRegisterAuthorizer() RegisterAuthorizer {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.registerAuthorizer
+}
+
+// authenticatedUser the account request was authenticated as, the user of its From checked by the digest
+// or the bearer token, false if it wasn't challenged, e.g. from the trusted networks.
+func (b *B2BUA) authenticatedUser(request sip.Request) (string, bool) {
+	if b.authenticator == nil {
+		return "", false
+	}
+	source := stack.RequestSource{Addr: request.Source(), Transport: request.Transport()}
+	if b.challenge(request, source).Decision != stack.ChallengeRequired {
+		return "", false
+	}
+	from, ok := request.From()
+	if !ok || from.Address.User() == nil {
+		return "", true
+	}
+	return from.Address.User().String(), true
+}
+
+// registerAllowed whether the account authenticated by request may register aor, returns its username.
+// The requests not challenged register any AOR.
+func (b *B2BUA) registerAllowed(request sip.Request, aor sip.Uri) (string, bool) {
+	username, authenticated := b.authenticatedUser(request)
+	if !authenticated {
+		return "", true
+	}
+	if len(username) == 0 {
+		return "", false
+	}
+	from, _ := request.From()
+	if aor.User() != nil && aor.User().String() == username && strings.EqualFold(aor.Host(), from.Address.Host()) {
+		return username, true
+	}
+	if authorizer := b.GetRegisterAuthorizer(); authorizer != nil && authorizer(username, aor) {
+		logger.Infof("Third-party registration of [%v] by %s", aor, username)
+		return username, true
+	}
+	return username, false
+}
+
+// ThirdPartyRule allows the accounts matching Account, e.g. a provisioning system, to register on behalf of
+// others the AORs matching AORs: user@domain patterns of path.Match, e.g. "*@gw.example.com".
+type ThirdPartyRule struct {
+	Account string   `json:"account"`
+	AORs    []string `json:"aors"`
+}
+
+// ThirdPartyACL the third-party registrations allowed, From other than To, its Allowed method being the
+// RegisterAuthorizer of the B2BUA.
+type ThirdPartyACL struct {
+	Rules []*ThirdPartyRule
+}
+
+// NewThirdPartyACL .
+func NewThirdPartyACL(rules ...*ThirdPartyRule) (*ThirdPartyACL, error) {
+	for _, rule := range rules {
+		if len(rule.Account) == 0 {
+			return nil, fmt.Errorf("third-party rule without account")
+		}
+		for _, pattern := range append([]string{rule.Account}, rule.AORs...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("bad third-party pattern %s: %v", pattern, err)
+			}
+		}
+	}
+	return &ThirdPartyACL{Rules: rules}, nil
+}
+
+// LoadThirdPartyACL a ThirdPartyACL of the JSON array of the ThirdPartyRules of file.
+func LoadThirdPartyACL(file string) (*ThirdPartyACL, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	rules := []*ThirdPartyRule{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("bad third-party ACL %s: %v", file, err)
+	}
+	return NewThirdPartyACL(rules...)
+}
+
+// Allowed whether the account username may register aor.
+func (acl *ThirdPartyACL) Allowed(username string, aor sip.Uri) bool {
+	if aor.User() == nil {
+		return false
+	}
+	target := strings.ToLower(aor.User().String() + "@" + aor.Host())
+	for _, rule := range acl.Rules {
+		if matched, _ := path.Match(rule.Account, username); !matched {
+			continue
+		}
+		for _, pattern := range rule.AORs {
+			if matched, _ := path.Match(strings.ToLower(pattern), target); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package b2bua
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// unavailableRegistry a registry whose backend is down.
+type unavailableRegistry struct {
+	registry.Registry
+}
+
+func (r unavailableRegistry) AddAor(aor sip.Uri, instance *registry.ContactInstance) error {
+	return errors.New("connection refused")
+}
+
+func TestRegisterFailure(t *testing.T) {
+	b := newTestB2BUA(t)
+	defer b.Shutdown()
+	b.SetRegistry(unavailableRegistry{b.GetRegistry()})
+	request := parseRequest(t, "REGISTER sip:example.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bKnashds7\r\n"+
+		"From: <sip:alice@example.com>;tag=456248\r\n"+
+		"To: <sip:alice@example.com>\r\n"+
+		"Call-ID: 843817637684230@998sdasdh09\r\n"+
+		"CSeq: 1826 REGISTER\r\n"+
+		"Contact: <sip:alice@192.0.2.1:5060>\r\n"+
+		"Expires: 3600\r\n"+
+		"Content-Length: 0\r\n\r\n")
+	to, _ := request.To()
+	expires := sip.Expires(3600)
+	contact, _ := request.GetHeaders("Contact")[0].(*sip.ContactHeader)
+	tx := &referTx{responses: make(chan sip.Response, 1)}
+	b.register(request, tx, to.Address, []sip.Header{contact}, expires)
+	if res := <-tx.responses; res.StatusCode() != 500 {
+		t.Errorf("%d answered, want 500", res.StatusCode())
+	}
+	if _, found := b.GetRegistry().GetContacts(to.Address); found {
+		t.Error("binding stored")
+	}
+}
@@ -0,0 +1,176 @@
+package b2bua
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/events"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// replaces the dialog a Replaces header designates, RFC 3891.
+type replaces struct {
+	callID  string
+	toTag   string
+	fromTag string
+}
+
+// referReplaces the Replaces embedded in the Refer-To URI of an attended transfer, escaped.
+func referReplaces(referTo sip.Uri) (*replaces, bool) {
+	if referTo.Headers() == nil {
+		return nil, false
+	}
+	value, found := referTo.Headers().Get("Replaces")
+	if !found || value == nil {
+		return nil, false
+	}
+	unescaped, err := url.PathUnescape(value.String())
+	if err != nil {
+		unescaped = value.String()
+	}
+	return parseReplaces(unescaped), true
+}
+
+// parseReplaces "call-id;to-tag=...;from-tag=...".
+func parseReplaces(value string) *replaces {
+	parts := strings.Split(value, ";")
+	r := &replaces{callID: strings.TrimSpace(parts[0])}
+	for _, part := range parts[1:] {
+		pair := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(pair) != 2 {
+			continue
+		}
+		switch strings.ToLower(pair[0]) {
+		case "to-tag":
+			r.toTag = pair[1]
+		case "from-tag":
+			r.fromTag = pair[1]
+		}
+	}
+	return r
+}
+
+// matches whether sess is the dialog designated, the tags seen from either end.
+func (r *replaces) matches(sess *session.Session) bool {
+	if len(r.callID) == 0 || string(*sess.CallID()) != r.callID {
+		return false
+	}
+	tags := dialogTags(sess)
+	for _, tag := range []string{r.toTag, r.fromTag} {
+		if len(tag) > 0 && !tags[tag] {
+			return false
+		}
+	}
+	return true
+}
+
+// dialogTags the From and To tags of the dialog of sess.
+func dialogTags(sess *session.Session) map[string]bool {
+	tags := make(map[string]bool)
+	add := func(params sip.Params) {
+		if params == nil {
+			return
+		}
+		if tag, found := params.Get("tag"); found && tag != nil {
+			tags[tag.String()] = true
+		}
+	}
+	if req := sess.Request(); req != nil {
+		if from, ok := req.From(); ok {
+			add(from.Params)
+		}
+		if to, ok := req.To(); ok {
+			add(to.Params)
+		}
+	}
+	if resp := sess.Response(); resp != nil {
+		if to, ok := resp.To(); ok {
+			add(to.Params)
+		}
+	}
+	return tags
+}
+
+// findReplaced the answered call, other than call, with a leg the dialog of r, and that leg.
+func (b *B2BUA) findReplaced(r *replaces, call *B2BCall) (*B2BCall, *session.Session) {
+	for _, other := range b.Calls() {
+		if other == call || !other.IsAnswered() {
+			continue
+		}
+		if r.matches(other.src) {
+			return other, other.src
+		}
+		if r.matches(other.dest) {
+			return other, other.dest
+		}
+	}
+	return nil, nil
+}
+
+// attendedTransfer bridge the transferee to the other party of the dialog the REFER replaces, both being
+// legs of the B2BUA already: the transferee and the target are re-INVITEd with the SDP of each other, and
+// the transferor's legs, the one of the REFER and the replaced one, are released.
+func (b *B2BUA) attendedTransfer(request sip.Request, tx sip.ServerTransaction, call *B2BCall, transferor, transferee *session.Session, r *replaces) {
+	respond := func(statusCode sip.StatusCode, reason string) {
+		tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, statusCode, reason, ""))
+	}
+	replaced, replacedLeg := b.findReplaced(r, call)
+	if replaced == nil {
+		logger.Infof("Call %v: no dialog %s to replace", call.ToString(), r.callID)
+		respond(481, "Call/Transaction Does Not Exist")
+		return
+	}
+	target := replaced.src
+	if replacedLeg == replaced.src {
+		target = replaced.dest
+	}
+	b.callsLock.Lock()
+	pending := false
+	for _, sess := range []*session.Session{transferor, transferee, replacedLeg, target} {
+		if _, found := b.transfers[sess]; found {
+			pending = true
+		}
+	}
+	b.callsLock.Unlock()
+	if pending {
+		respond(491, "Request Pending")
+		return
+	}
+	respond(202, "Accepted")
+	b.auditCall("transfer", transferor, "replaces "+r.callID)
+
+	b.dialogs.Remove(transferor)
+	b.dialogs.Remove(replacedLeg)
+	call.setCause(cdr.Transferred)
+	replaced.setCause(cdr.Transferred)
+	b.removeCall(transferor)
+	b.removeCall(replacedLeg)
+	bridged := &B2BCall{
+		src:           transferee,
+		dest:          target,
+		ringback:      RingbackForward,
+		mediaSecurity: replaced.mediaSecurity,
+		timing:        CallTiming{Setup: time.Now()},
+		connected:     true,
+		transport:     replaced.transport,
+		trunk:         replaced.trunk,
+		correlationID: call.correlationID,
+	}
+	b.addCall(bridged)
+	b.answered(bridged)
+	b.publishCall(events.CallTransferred, bridged)
+	logger.Infof("Call %v transferred, replacing %s", bridged.ToString(), r.callID)
+
+	t := &transfer{call: call, transferor: transferor, transferee: transferee}
+	go func() {
+		b.notifyTransferor(t, 200, "OK", true)
+		transferor.Bye()
+		replacedLeg.Bye()
+	}()
+	transfereeSdp, targetSdp := transferee.RemoteSdp(), target.RemoteSdp()
+	go b.offerLeg(bridged, transferee, targetSdp)
+	go b.offerLeg(bridged, target, transfereeSdp)
+}
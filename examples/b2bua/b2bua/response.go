@@ -0,0 +1,79 @@
+package b2bua
+
+import (
+	"fmt"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// WarningMiscellaneous 399 Miscellaneous warning, https://tools.ietf.org/html/rfc3261#section-20.43
+	WarningMiscellaneous = 399
+)
+
+// RejectOptions extra details attached to the 4xx/5xx/6xx responses sent by the B2BUA.
+type RejectOptions struct {
+	// WarningCode warn-code of the Warning header, 0 to omit the header.
+	WarningCode int
+	// WarningText explanatory text of the Warning header.
+	WarningText string
+	// RetryAfter seconds for the Retry-After header, 0 to omit the header.
+	RetryAfter uint32
+	// ContentType of the optional reason body, e.g. application/vnd.example.reason+json
+	ContentType string
+	// Body optional reason body.
+	Body string
+}
+
+// SetRejectOptions set the details used when rejecting with the given status code, nil to reset.
+func (b *B2BUA) SetRejectOptions(statusCode sip.StatusCode, options *RejectOptions) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if options == nil {
+		delete(b.rejectOptions, statusCode)
+		return
+	}
+	b.rejectOptions[statusCode] = options
+}
+
+// GetRejectOptions .
+func (b *B2BUA) GetRejectOptions(statusCode sip.StatusCode) (*RejectOptions, bool) {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	options, found := b.rejectOptions[statusCode]
+	return options, found
+}
+
+// rejectHeaders build the extra headers configured for statusCode.
+func (b *B2BUA) rejectHeaders(statusCode sip.StatusCode) ([]sip.Header, *RejectOptions) {
+	headers := []sip.Header{}
+	options, found := b.GetRejectOptions(statusCode)
+	if !found {
+		return headers, nil
+	}
+	if options.WarningCode > 0 {
+		agent := b.stack.GetNetworkInfo("udp").Host
+		headers = append(headers, utils.NewWarningHeader(options.WarningCode, agent, options.WarningText))
+	}
+	if options.RetryAfter > 0 {
+		headers = append(headers, utils.NewRetryAfterHeader(options.RetryAfter))
+	}
+	return headers, options
+}
+
+// reject send a final error response on sess with the configured details, and the reason phrase of the
+// locale of the caller.
+func (b *B2BUA) reject(sess *session.Session, statusCode sip.StatusCode, reason string) {
+	b.auditCall("reject", sess, fmt.Sprintf("%d %s", statusCode, reason))
+	if locale := b.locale(sess); locale != nil {
+		reason = locale.Reason(statusCode, reason)
+	}
+	headers, options := b.rejectHeaders(statusCode)
+	if options != nil && len(options.Body) > 0 {
+		sess.RejectWithBody(statusCode, reason, options.ContentType, options.Body, headers...)
+		return
+	}
+	sess.Reject(statusCode, reason, headers...)
+}
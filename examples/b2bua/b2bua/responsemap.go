@@ -0,0 +1,119 @@
+package b2bua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// reasonPhrases the reason phrases of RFC 3261 and of the common extensions, of the translated responses.
+var reasonPhrases = map[sip.StatusCode]string{
+	400: "Bad Request",
+	401: "Unauthorized",
+	402: "Payment Required",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	406: "Not Acceptable",
+	407: "Proxy Authentication Required",
+	408: "Request Timeout",
+	410: "Gone",
+	413: "Request Entity Too Large",
+	414: "Request-URI Too Long",
+	415: "Unsupported Media Type",
+	416: "Unsupported URI Scheme",
+	420: "Bad Extension",
+	421: "Extension Required",
+	423: "Interval Too Brief",
+	480: "Temporarily Unavailable",
+	481: "Call/Transaction Does Not Exist",
+	482: "Loop Detected",
+	483: "Too Many Hops",
+	484: "Address Incomplete",
+	485: "Ambiguous",
+	486: "Busy Here",
+	487: "Request Terminated",
+	488: "Not Acceptable Here",
+	491: "Request Pending",
+	493: "Undecipherable",
+	500: "Server Internal Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Server Time-out",
+	505: "Version Not Supported",
+	513: "Message Too Large",
+	600: "Busy Everywhere",
+	603: "Decline",
+	604: "Does Not Exist Anywhere",
+	606: "Not Acceptable",
+	607: "Unwanted",
+	608: "Rejected",
+}
+
+// ResponseMap translates the final responses of a trunk relayed to the caller, by status code, e.g. the
+// 403 of a carrier out of credit to 503 and its 604 to 404, so that the callers behave alike whatever
+// the carrier.
+type ResponseMap map[sip.StatusCode]sip.StatusCode
+
+// ParseResponseMap parse comma separated code=code translations, e.g. 403=503,604=404.
+func ParseResponseMap(value string) (ResponseMap, error) {
+	responses := ResponseMap{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		codes := strings.SplitN(entry, "=", 2)
+		if len(codes) != 2 {
+			return nil, fmt.Errorf("invalid response translation %s, expected code=code", entry)
+		}
+		from, err := parseFinalCode(codes[0])
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseFinalCode(codes[1])
+		if err != nil {
+			return nil, err
+		}
+		responses[from] = to
+	}
+	return responses, nil
+}
+
+func parseFinalCode(value string) (sip.StatusCode, error) {
+	code, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || code < 300 || code > 699 {
+		return 0, fmt.Errorf("invalid final status code %s", value)
+	}
+	return sip.StatusCode(code), nil
+}
+
+// Translate the status code and the reason relayed for statusCode and reason, the reason phrase of the
+// translated code, they're kept if not translated.
+func (m ResponseMap) Translate(statusCode sip.StatusCode, reason string) (sip.StatusCode, string) {
+	translated, found := m[statusCode]
+	if !found || translated == statusCode {
+		return statusCode, reason
+	}
+	if phrase, found := reasonPhrases[translated]; found {
+		return translated, phrase
+	}
+	return translated, reason
+}
+
+// trunkResponse the status code and the reason relayed to the caller for the final response of the
+// B-Leg of call, translated by the ResponseMap of its trunk.
+func (b *B2BUA) trunkResponse(call *B2BCall, statusCode sip.StatusCode, reason string) (sip.StatusCode, string) {
+	trunk := b.trunkNamed(call.trunk)
+	if len(call.trunk) == 0 || trunk == nil || trunk.Responses == nil {
+		return statusCode, reason
+	}
+	translated, translatedReason := trunk.Responses.Translate(statusCode, reason)
+	if translated != statusCode {
+		logger.Debugf("Response %d of trunk %s relayed as %d", statusCode, trunk.Name, translated)
+	}
+	return translated, translatedReason
+}
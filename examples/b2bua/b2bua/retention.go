@@ -0,0 +1,241 @@
+package b2bua
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// DefaultPurgeInterval between the purges of the retention policy.
+const DefaultPurgeInterval = time.Hour
+
+// RetentionPolicy how long the personal data are kept, GDPR: the older ones are purged every Interval.
+// A zero period keeps the data.
+type RetentionPolicy struct {
+	// CDRs the records of the CDR writer, when it stores them, e.g. cdr.SQLWriter.
+	CDRs time.Duration
+	// Recordings the files of RecordingsDir, written by the media server, by modification time.
+	Recordings    time.Duration
+	RecordingsDir string
+	// History the completed calls of the message history.
+	History time.Duration
+	// Registrations the bindings first registered longer ago, with their device metadata (User-Agent,
+	// source address), the device registers again on its next refresh.
+	Registrations time.Duration
+	// Interval between the purges, DefaultPurgeInterval if zero.
+	Interval time.Duration
+
+	stop chan struct{}
+}
+
+// DataCounts the items purged or erased, by store.
+type DataCounts struct {
+	CDRs          int64 `json:"cdrs"`
+	Recordings    int64 `json:"recordings"`
+	History       int64 `json:"history"`
+	Registrations int64 `json:"registrations"`
+	Settings      int64 `json:"settings"`
+}
+
+// SetRetentionPolicy purge the data older than the periods of policy, nil to keep them.
+func (b *B2BUA) SetRetentionPolicy(policy *RetentionPolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if b.retentionPolicy != nil {
+		close(b.retentionPolicy.stop)
+	}
+	b.retentionPolicy = policy
+	if policy != nil {
+		if policy.Interval <= 0 {
+			policy.Interval = DefaultPurgeInterval
+		}
+		policy.stop = make(chan struct{})
+		go b.purgeData(policy)
+	}
+}
+
+// GetRetentionPolicy .
+func (b *B2BUA) GetRetentionPolicy() *RetentionPolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.retentionPolicy
+}
+
+// purgeData purge every interval, until the policy is replaced.
+func (b *B2BUA) purgeData(policy *RetentionPolicy) {
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+	for {
+		counts, err := b.Purge(policy, time.Now())
+		if err != nil {
+			logger.Errorf("Data purge failed: %v", err)
+		} else {
+			logger.Infof("Data purged: %+v", counts)
+		}
+		select {
+		case <-policy.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge remove the data older than the periods of policy at now.
+func (b *B2BUA) Purge(policy *RetentionPolicy, now time.Time) (DataCounts, error) {
+	counts := DataCounts{}
+	var err error
+	if purger, ok := b.GetCDRWriter().(cdr.Purger); ok && policy.CDRs > 0 {
+		if counts.CDRs, err = purger.Purge(now.Add(-policy.CDRs)); err != nil {
+			return counts, err
+		}
+	}
+	if len(policy.RecordingsDir) > 0 && policy.Recordings > 0 {
+		before := now.Add(-policy.Recordings)
+		counts.Recordings, err = removeFiles(policy.RecordingsDir, func(info os.FileInfo) bool {
+			return info.ModTime().Before(before)
+		})
+		if err != nil {
+			return counts, err
+		}
+	}
+	if history := b.GetMessageHistory(); history != nil && policy.History > 0 {
+		counts.History = int64(history.Purge(now.Add(-policy.History)))
+	}
+	if policy.Registrations > 0 {
+		before := now.Add(-policy.Registrations)
+		for aor, contacts := range b.registry.GetAllContacts() {
+			for _, instance := range contacts {
+				if instance.Registered.Before(before) {
+					b.registry.RemoveContact(aor, instance)
+					counts.Registrations++
+				}
+			}
+		}
+	}
+	return counts, nil
+}
+
+// Erase remove all the data of user, the user part of its AOR, or its AOR: the CDRs of its calls, its
+// recordings, the message history of its calls, its registrations, its static location and its call
+// forward. The recordings are the files of RecordingsDir whose name contains the user between
+// separators, e.g. 20240101-100-200.wav.
+func (b *B2BUA) Erase(user string) (DataCounts, error) {
+	user = uriUser(user)
+	counts := DataCounts{}
+	var err error
+	if len(user) == 0 {
+		return counts, nil
+	}
+	if purger, ok := b.GetCDRWriter().(cdr.Purger); ok {
+		if counts.CDRs, err = purger.Erase(user); err != nil {
+			return counts, err
+		}
+	}
+	if policy := b.GetRetentionPolicy(); policy != nil && len(policy.RecordingsDir) > 0 {
+		counts.Recordings, err = removeFiles(policy.RecordingsDir, func(info os.FileInfo) bool {
+			return containsToken(info.Name(), user)
+		})
+		if err != nil {
+			return counts, err
+		}
+	}
+	if history := b.GetMessageHistory(); history != nil {
+		counts.History = int64(history.Erase(user))
+	}
+	for aor, contacts := range b.registry.GetAllContacts() {
+		if aor.User() != nil && aor.User().String() == user {
+			counts.Registrations += int64(len(contacts))
+			b.registry.RemoveAor(aor)
+		}
+	}
+	if _, found := b.GetStaticLocation(user); found {
+		b.SetStaticLocation(user, "")
+		counts.Settings++
+	}
+	if _, found := b.GetCallForward(user); found {
+		b.SetCallForward(user, "")
+		counts.Settings++
+	}
+	logger.Infof("Data of %s erased: %+v", user, counts)
+	return counts, nil
+}
+
+// ErasureHandler erase the data of the user query parameter on POST or DELETE, the counts are returned
+// as JSON.
+func (b *B2BUA) ErasureHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user := r.URL.Query().Get("user")
+		if len(uriUser(user)) == 0 {
+			http.Error(w, "missing user", http.StatusBadRequest)
+			return
+		}
+		counts, err := b.Erase(user)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(counts)
+	})
+}
+
+// uriUser the user part of a SIP URI, e.g. the From of a call, or the number of a tel URI, value itself
+// if it isn't one.
+func uriUser(value string) string {
+	value = strings.Trim(strings.TrimSpace(value), "<>")
+	if !strings.Contains(value, ":") {
+		return value
+	}
+	if strings.HasPrefix(strings.ToLower(value), "tel:") {
+		return strings.SplitN(value[4:], ";", 2)[0]
+	}
+	uri, err := parser.ParseUri(value)
+	if err != nil || uri.User() == nil {
+		return value
+	}
+	return uri.User().String()
+}
+
+// containsToken name contains token between non alphanumeric characters.
+func containsToken(name string, token string) bool {
+	for _, field := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '+'
+	}) {
+		if field == token {
+			return true
+		}
+	}
+	return false
+}
+
+// removeFiles remove the files of dir, recursively, matching match.
+func removeFiles(dir string, match func(info os.FileInfo) bool) (int64, error) {
+	var removed int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() && match(info) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,290 @@
+package b2bua
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media/rtp"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// RingbackPolicy how the B2BUA relays the B-Leg alerting to the caller.
+type RingbackPolicy int
+
+const (
+	// RingbackForward relay the B-Leg 180/183 as received.
+	RingbackForward RingbackPolicy = iota
+	// RingbackConvert relay 183 with early media as 180 without SDP, the caller plays its own ringback.
+	RingbackConvert
+	// RingbackLocal the B2BUA plays the ringback tone toward the caller until the B-Leg answers.
+	RingbackLocal
+)
+
+const (
+	tonePtime = 20 * time.Millisecond
+)
+
+func (p RingbackPolicy) String() string {
+	switch p {
+	case RingbackForward:
+		return "forward"
+	case RingbackConvert:
+		return "convert"
+	case RingbackLocal:
+		return "local"
+	}
+	return fmt.Sprintf("RingbackPolicy(%d)", int(p))
+}
+
+// SetRingbackPolicy set the ringback policy of the called numbers starting with prefix,
+// "" for the default policy.
+func (b *B2BUA) SetRingbackPolicy(prefix string, policy RingbackPolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.ringbackPolicies[prefix] = policy
+}
+
+// GetRingbackPolicy the policy of the longest matching prefix of called.
+func (b *B2BUA) GetRingbackPolicy(called string) RingbackPolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	policy, matched := RingbackForward, -1
+	for prefix, p := range b.ringbackPolicies {
+		if strings.HasPrefix(called, prefix) && len(prefix) > matched {
+			policy, matched = p, len(prefix)
+		}
+	}
+	return policy
+}
+
+// SetRingbackTone set the tone played by RingbackLocal, media.RingbackToneITU by default.
+func (b *B2BUA) SetRingbackTone(tone media.Tone) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.ringbackTone = tone
+}
+
+// GetRingbackTone .
+func (b *B2BUA) GetRingbackTone() media.Tone {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.ringbackTone
+}
+
+// SetMediaConfig set the port range, the address and the DSCP of the media sockets of the B2BUA,
+// rtp.DefaultConfig() by default.
+func (b *B2BUA) SetMediaConfig(config rtp.Config) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.mediaConfig = config
+}
+
+// GetMediaConfig .
+func (b *B2BUA) GetMediaConfig() rtp.Config {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.mediaConfig
+}
+
+// relayProvisional relay a B-Leg provisional response to the caller according to the ringback policy.
+func (b *B2BUA) relayProvisional(call *B2BCall, resp sip.Response) {
+	if call.connected {
+		// The caller was answered, it hears the media of the application until the callee answers.
+		return
+	}
+	switch call.ringback {
+	case RingbackLocal:
+		call.mutex.Lock()
+		defer call.mutex.Unlock()
+		if call.player != nil || call.ended {
+			return
+		}
+		tone := b.GetRingbackTone()
+		if locale := b.locale(call.src); locale != nil && locale.RingbackTone != nil {
+			tone = *locale.RingbackTone
+		}
+		player, answer, err := b.playTone(call.src.RemoteSdp(), tone)
+		if err == nil {
+			call.player = player
+			call.src.ProvideAnswer(answer)
+			call.src.Provisional(183, "Session Progress")
+			return
+		}
+		logger.Warnf("Local ringback failed, fallback to 180: %v", err)
+		fallthrough
+	case RingbackConvert:
+		if resp.StatusCode() == 100 {
+			return
+		}
+		call.src.ProvideAnswer("")
+		call.src.Provisional(180, "Ringing")
+	default:
+		answer, relay := call.fork.provisional(call, call.dest.RemoteSdp())
+		if !relay {
+			// Another branch answered, or relays the early media.
+			return
+		}
+		call.src.ProvideAnswer(answer)
+		call.src.Provisional(resp.StatusCode(), resp.Reason())
+	}
+}
+
+// stopRingback stop the local ringback, e.g. once the B-Leg answers, ended no ringback will be played anymore.
+func (call *B2BCall) stopRingback(ended bool) {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	call.ended = call.ended || ended
+	if call.player != nil {
+		call.player.Stop()
+		call.player = nil
+	}
+}
+
+// tonePlayer plays a tone over RTP.
+type tonePlayer struct {
+	conn  *net.UDPConn
+	tone  *media.ToneGenerator
+	codec media.Codec
+	latch *rtp.Latch
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// playTone start playing tone toward the media address of offer,
+// returns the answer describing the tone stream.
+func (b *B2BUA) playTone(offer string, tone media.Tone) (*tonePlayer, string, error) {
+	session, err := sdp.ParseString(offer)
+	if err != nil {
+		return nil, "", err
+	}
+	var audio *sdp.Media
+	for _, m := range session.Media {
+		if m.Type == "audio" && m.Port != 0 {
+			audio = m
+			break
+		}
+	}
+	if audio == nil {
+		return nil, "", fmt.Errorf("no audio stream in the offer")
+	}
+
+	codec, payload, err := media.NewCodecForSDP(offer)
+	if err != nil {
+		return nil, "", err
+	}
+
+	address := ""
+	if len(audio.Connection) > 0 {
+		address = audio.Connection[0].Address
+	} else if session.Connection != nil {
+		address = session.Connection.Address
+	}
+	raddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(address, fmt.Sprint(audio.Port)))
+	if err != nil {
+		return nil, "", err
+	}
+
+	config := b.GetMediaConfig()
+	conn, err := config.Listen()
+	if err != nil {
+		codec.Close()
+		return nil, "", err
+	}
+
+	host := b.stack.GetNetworkInfo("udp").Host
+	if ip, _ := config.BindIP(); ip != nil {
+		host = ip.String()
+	}
+	now := time.Now().UnixNano() / 1e6
+	answer := &sdp.Session{
+		Origin: &sdp.Origin{
+			Username:       "-",
+			Address:        host,
+			SessionID:      now,
+			SessionVersion: now,
+		},
+		Timing:     &sdp.Timing{Start: time.Time{}, Stop: time.Time{}},
+		Connection: &sdp.Connection{Address: host},
+		Media: []*sdp.Media{
+			{
+				Type:   "audio",
+				Port:   conn.LocalAddr().(*net.UDPAddr).Port,
+				Proto:  "RTP/AVP",
+				Mode:   sdp.SendOnly,
+				Format: []*sdp.Format{media.CodecFormat(codec, payload)},
+			},
+		},
+	}
+
+	player := &tonePlayer{
+		conn:  conn,
+		tone:  media.NewCodecToneGenerator(tone, codec, payload),
+		codec: codec,
+		latch: rtp.NewLatch(raddr, rtp.LatchConfig{}),
+		stop:  make(chan struct{}),
+	}
+	go player.read()
+	go player.play()
+	return player, answer.String(), nil
+}
+
+func (p *tonePlayer) play() {
+	defer p.conn.Close()
+	defer p.codec.Close()
+	ticker := time.NewTicker(tonePtime)
+	defer ticker.Stop()
+
+	ssrc := rand.Uint32()
+	seq := uint16(rand.Intn(0xffff))
+	timestamp := rand.Uint32()
+	samples := uint32(tonePtime.Seconds() * float64(p.tone.ClockRate()))
+	packet := make([]byte, 12)
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			packet = packet[:12]
+			packet[0] = 0x80
+			packet[1] = p.tone.Payload()
+			binary.BigEndian.PutUint16(packet[2:], seq)
+			binary.BigEndian.PutUint32(packet[4:], timestamp)
+			binary.BigEndian.PutUint32(packet[8:], ssrc)
+			packet = append(packet, p.tone.Next(tonePtime)...)
+			if _, err := p.conn.WriteToUDP(packet, p.latch.Target()); err != nil {
+				logger.Debugf("Ringback write error: %v", err)
+			}
+			seq++
+			timestamp += samples
+		}
+	}
+}
+
+// read latch the source of the packets of the caller, e.g. behind a NAT, until the connection is closed.
+func (p *tonePlayer) read() {
+	packet := utils.GetPacket()
+	defer utils.PutPacket(packet)
+	buf := *packet
+	for {
+		n, source, err := p.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		p.latch.Accept(buf[:n], source, time.Now())
+	}
+}
+
+// Stop .
+func (p *tonePlayer) Stop() {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+}
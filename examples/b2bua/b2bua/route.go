@@ -0,0 +1,280 @@
+package b2bua
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// Route the outcome of the dial plan.
+type Route struct {
+	Called sip.Uri
+	// Targets explicit destinations of the call, the registered contacts of Called if empty.
+	Targets []*registry.ContactInstance
+	// Headers added to the B-Leg INVITE.
+	Headers []sip.Header
+	// Timeout no answer timeout of the B-Legs, 0 for none.
+	Timeout time.Duration
+	// MediaSecurity the media encryption policy of the call.
+	MediaSecurity MediaSecurityPolicy
+	// Emergency the call is routed to the emergency destination: it skips the class of service, the billing
+	// and the maximum duration, and is admitted over the bandwidth budgets.
+	Emergency bool
+}
+
+// contacts the explicit destinations, false if the call is routed to the registered contacts.
+func (r *Route) contacts() (*map[string]*registry.ContactInstance, bool) {
+	if len(r.Targets) == 0 {
+		return nil, false
+	}
+	contacts := make(map[string]*registry.ContactInstance, len(r.Targets))
+	for _, instance := range r.Targets {
+		contacts[instance.Source] = instance
+	}
+	return &contacts, true
+}
+
+// noAnswer end the B-Leg of the call if it is still ringing after the route timeout.
+func (b *B2BUA) noAnswer(call *B2BCall) {
+	if call.IsAnswered() {
+		return
+	}
+	logger.Infof("Call %v not answered before the route timeout", call.ToString())
+	call.setStatus(480, "No Answer")
+	if call.src.IsInProgress() {
+		b.reject(call.src, 480, "No Answer")
+	}
+	call.dest.End()
+}
+
+// RouteAction .
+type RouteAction string
+
+const (
+	// RouteContinue route the call as usual.
+	RouteContinue RouteAction = "continue"
+	// RouteTo route the call to RouteDecision.Target.
+	RouteTo RouteAction = "route"
+	// RouteReject reject the call with RouteDecision.StatusCode.
+	RouteReject RouteAction = "reject"
+)
+
+// RouteRequest the call submitted to an external router.
+type RouteRequest struct {
+	CallID     string `json:"call_id"`
+	Caller     string `json:"caller"`
+	Called     string `json:"called"`
+	RequestURI string `json:"request_uri"`
+	Source     string `json:"source"`
+	Transport  string `json:"transport"`
+	UserAgent  string `json:"user_agent,omitempty"`
+}
+
+// RouteDecision the answer of an external router.
+type RouteDecision struct {
+	Action RouteAction `json:"action"`
+	// Target a number routed by the dial plan, or a SIP URI the call is sent to.
+	Target string `json:"target,omitempty"`
+	// StatusCode and Reason of a rejected call.
+	StatusCode int    `json:"status_code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	// Headers added to the B-Leg INVITE.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout no answer timeout in seconds, 0 for none.
+	Timeout int `json:"timeout,omitempty"`
+	// MediaSecurity best-effort, require or forbid, the policy of the called number if empty.
+	MediaSecurity string `json:"media_security,omitempty"`
+}
+
+// RouteHandler an external router, an error rejects the call with 503.
+type RouteHandler func(request *RouteRequest) (*RouteDecision, error)
+
+// SetRouteHandler set the external router consulted before the dial plan, nil to disable.
+func (b *B2BUA) SetRouteHandler(handler RouteHandler) {
+	if handler == nil {
+		b.SetRouter(nil)
+		return
+	}
+	b.SetRouter(handler)
+}
+
+// GetRouteHandler .
+func (b *B2BUA) GetRouteHandler() RouteHandler {
+	return b.GetRouter().Route
+}
+
+// SetRouter set the routing engine of the calls, nil for the RegistryRouter.
+func (b *B2BUA) SetRouter(router Router) {
+	if router == nil {
+		router = RegistryRouter{}
+	}
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.router = router
+}
+
+// GetRouter .
+func (b *B2BUA) GetRouter() Router {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.router
+}
+
+// newRouteRequest .
+func newRouteRequest(req sip.Request, caller sip.Uri, called sip.Uri) *RouteRequest {
+	request := &RouteRequest{
+		RequestURI: req.Recipient().String(),
+		Source:     req.Source(),
+		Transport:  req.Transport(),
+	}
+	if callID, ok := req.CallID(); ok {
+		request.CallID = callID.Value()
+	}
+	if caller.User() != nil {
+		request.Caller = caller.User().String()
+	}
+	if called.User() != nil {
+		request.Called = called.User().String()
+	}
+	if headers := req.GetHeaders("User-Agent"); len(headers) > 0 {
+		request.UserAgent = headers[0].Value()
+	}
+	return request
+}
+
+// apply the decision to the route, returns the status code rejecting the call, 0 to route it.
+func (d *RouteDecision) apply(route *Route) (sip.StatusCode, string) {
+	switch d.Action {
+	case RouteReject:
+		if d.StatusCode < 400 || d.StatusCode > 699 {
+			return 403, "Forbidden"
+		}
+		return sip.StatusCode(d.StatusCode), d.Reason
+	case RouteTo:
+		if len(d.Target) == 0 {
+			return 500, "Invalid Route"
+		}
+		if strings.HasPrefix(d.Target, "sip:") || strings.HasPrefix(d.Target, "sips:") {
+			uri, err := parser.ParseSipUri(d.Target)
+			if err != nil {
+				logger.Errorf("Invalid route target %s: %v", d.Target, err)
+				return 500, "Invalid Route"
+			}
+			if uri.User() != nil {
+				route.Called = divert(route.Called, uri.User().String())
+			}
+			route.Targets = []*registry.ContactInstance{uriTarget(&uri)}
+		} else {
+			route.Called = divert(route.Called, d.Target)
+		}
+	}
+	for name, value := range d.Headers {
+		route.Headers = append(route.Headers, &sip.GenericHeader{HeaderName: name, Contents: value})
+	}
+	if d.Timeout > 0 {
+		route.Timeout = time.Duration(d.Timeout) * time.Second
+	}
+	if len(d.MediaSecurity) > 0 {
+		policy, err := ParseMediaSecurityPolicy(d.MediaSecurity)
+		if err != nil {
+			logger.Errorf("Invalid route decision: %v", err)
+			return 500, "Invalid Route"
+		}
+		route.MediaSecurity = policy
+	}
+	return 0, ""
+}
+
+// uriTarget the contact of a SIP URI target.
+func uriTarget(uri *sip.SipUri) *registry.ContactInstance {
+	port := 5060
+	if uri.FIsEncrypted {
+		port = 5061
+	}
+	if uri.FPort != nil {
+		port = int(*uri.FPort)
+	}
+	transport := "udp"
+	if uri.FIsEncrypted {
+		transport = "tls"
+	}
+	if value, ok := uri.UriParams().Get("transport"); ok && value != nil {
+		transport = strings.ToLower(value.String())
+	}
+	return &registry.ContactInstance{
+		Source:    net.JoinHostPort(uri.Host(), fmt.Sprint(port)),
+		Transport: transport,
+	}
+}
+
+// HTTPRouter an external router POSTing the RouteRequest as JSON to a web service,
+// which answers with a RouteDecision.
+type HTTPRouter struct {
+	URL string
+	// Headers of the HTTP requests, e.g. Authorization.
+	Headers map[string]string
+	// FailOpen route the calls as usual when the service fails, they are rejected otherwise.
+	FailOpen bool
+	client   *http.Client
+}
+
+// NewHTTPRouter timeout of the HTTP requests, 2s if 0.
+func NewHTTPRouter(url string, timeout time.Duration) *HTTPRouter {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return &HTTPRouter{
+		URL:     url,
+		Headers: make(map[string]string),
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Route a RouteHandler.
+func (r *HTTPRouter) Route(request *RouteRequest) (*RouteDecision, error) {
+	decision, err := r.post(request)
+	if err != nil && r.FailOpen {
+		logger.Warnf("HTTP router failed, route %s as usual: %v", request.CallID, err)
+		return &RouteDecision{Action: RouteContinue}, nil
+	}
+	return decision, err
+}
+
+func (r *HTTPRouter) post(request *RouteRequest) (*RouteDecision, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range r.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("router answered %s", resp.Status)
+	}
+	decision := &RouteDecision{}
+	if err := json.NewDecoder(resp.Body).Decode(decision); err != nil {
+		return nil, err
+	}
+	if len(decision.Action) == 0 {
+		decision.Action = RouteContinue
+	}
+	return decision, nil
+}
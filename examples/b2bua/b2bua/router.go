@@ -0,0 +1,142 @@
+package b2bua
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// Router the routing engine consulted by the dial plan for each incoming call, e.g. an HTTPRouter, a
+// route script or a DialPlanRouter, an error rejects the call with 503.
+type Router interface {
+	Route(request *RouteRequest) (*RouteDecision, error)
+}
+
+// Route a Router.
+func (h RouteHandler) Route(request *RouteRequest) (*RouteDecision, error) {
+	return h(request)
+}
+
+// RegistryRouter the default Router, the calls go on to the registered contacts of the called party.
+type RegistryRouter struct{}
+
+// Route .
+func (RegistryRouter) Route(request *RouteRequest) (*RouteDecision, error) {
+	return &RouteDecision{Action: RouteContinue}, nil
+}
+
+// DialRule a rule of a DialPlanRouter, matching the called number by its Prefix, its Pattern, or both.
+type DialRule struct {
+	Prefix  string `json:"prefix,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	// Strip the digits removed from the start of the number, then Add prepended, e.g. 00 to +.
+	Strip int    `json:"strip,omitempty"`
+	Add   string `json:"add,omitempty"`
+	// Rewrite the number matched by Pattern into this template, with $1 for its first group.
+	Rewrite string `json:"rewrite,omitempty"`
+	// Trunk the SIP URI of the gateway the call is sent to, e.g. sip:gw.carrier.com;transport=tcp, the
+	// registered users if empty.
+	Trunk string `json:"trunk,omitempty"`
+	// Reject the matched calls with this status code and Reason instead.
+	Reject int    `json:"reject,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Timeout no answer timeout in seconds, 0 for none.
+	Timeout int `json:"timeout,omitempty"`
+	pattern *regexp.Regexp
+}
+
+// compile the pattern and check the trunk.
+func (r *DialRule) compile() error {
+	if len(r.Pattern) > 0 {
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("bad pattern %s: %v", r.Pattern, err)
+		}
+		r.pattern = pattern
+	}
+	if len(r.Trunk) > 0 {
+		if _, err := parser.ParseSipUri(r.Trunk); err != nil {
+			return fmt.Errorf("bad trunk %s: %v", r.Trunk, err)
+		}
+	}
+	return nil
+}
+
+// match whether the rule matches number.
+func (r *DialRule) match(number string) bool {
+	if !strings.HasPrefix(number, r.Prefix) {
+		return false
+	}
+	return r.pattern == nil || r.pattern.MatchString(number)
+}
+
+// translate number by the rule.
+func (r *DialRule) translate(number string) string {
+	if r.pattern != nil && len(r.Rewrite) > 0 {
+		number = r.pattern.ReplaceAllString(number, r.Rewrite)
+	}
+	if r.Strip > 0 {
+		if r.Strip >= len(number) {
+			number = ""
+		} else {
+			number = number[r.Strip:]
+		}
+	}
+	return r.Add + number
+}
+
+// decision the route of number by the rule.
+func (r *DialRule) decision(number string) *RouteDecision {
+	if r.Reject > 0 {
+		return &RouteDecision{Action: RouteReject, StatusCode: r.Reject, Reason: r.Reason}
+	}
+	decision := &RouteDecision{Action: RouteTo, Target: r.translate(number), Timeout: r.Timeout}
+	if len(r.Trunk) > 0 {
+		trunk, _ := parser.ParseSipUri(r.Trunk)
+		decision.Target = divert(&trunk, decision.Target).String()
+	}
+	return decision
+}
+
+// DialPlanRouter routes the calls by the first rule matching the called number: rewrites it and sends it
+// to a trunk or to the registered users, the calls matching none go on as with the RegistryRouter.
+type DialPlanRouter struct {
+	Rules []*DialRule
+}
+
+// NewDialPlanRouter .
+func NewDialPlanRouter(rules ...*DialRule) (*DialPlanRouter, error) {
+	for _, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return &DialPlanRouter{Rules: rules}, nil
+}
+
+// LoadDialPlan a DialPlanRouter of the JSON array of the DialRules of path.
+func LoadDialPlan(path string) (*DialPlanRouter, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := []*DialRule{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("bad dial plan %s: %v", path, err)
+	}
+	return NewDialPlanRouter(rules...)
+}
+
+// Route .
+func (r *DialPlanRouter) Route(request *RouteRequest) (*RouteDecision, error) {
+	for _, rule := range r.Rules {
+		if rule.match(request.Called) {
+			return rule.decision(request.Called), nil
+		}
+	}
+	return &RouteDecision{Action: RouteContinue}, nil
+}
@@ -0,0 +1,83 @@
+package b2bua
+
+import (
+	"testing"
+)
+
+func TestDialRuleTranslate(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		rule   DialRule
+		number string
+		want   string
+	}{
+		{"strip and add", DialRule{Prefix: "00", Strip: 2, Add: "+"}, "004412345", "+4412345"},
+		{"strip all", DialRule{Strip: 5}, "1234", ""},
+		{"rewrite", DialRule{Pattern: `^9(\d{4})$`, Rewrite: "+3315550$1"}, "91234", "+33155501234"},
+		{"rewrite then strip", DialRule{Pattern: `^0(\d+)$`, Rewrite: "+33$1", Strip: 1}, "0155501234", "33155501234"},
+		{"rewrite without pattern", DialRule{Rewrite: "x"}, "1234", "1234"},
+	} {
+		if err := test.rule.compile(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got := test.rule.translate(test.number); got != test.want {
+			t.Errorf("%s: translate(%s) = %q, want %q", test.name, test.number, got, test.want)
+		}
+	}
+}
+
+func TestDialRuleMatch(t *testing.T) {
+	rule := &DialRule{Prefix: "9", Pattern: `^9\d{4}$`}
+	if err := rule.compile(); err != nil {
+		t.Fatal(err)
+	}
+	for number, want := range map[string]bool{
+		"91234":  true,
+		"912345": false,
+		"81234":  false,
+		"9":      false,
+	} {
+		if got := rule.match(number); got != want {
+			t.Errorf("match(%s) = %v, want %v", number, got, want)
+		}
+	}
+}
+
+func TestDialPlanRouter(t *testing.T) {
+	router, err := NewDialPlanRouter(
+		&DialRule{Prefix: "900", Reject: 403, Reason: "Premium Numbers Barred"},
+		&DialRule{Prefix: "00", Strip: 2, Add: "+", Trunk: "sip:gw.carrier.com;transport=tcp", Timeout: 30},
+		&DialRule{Pattern: `^(\d{3})$`, Rewrite: "ext$1"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		called string
+		want   RouteDecision
+	}{
+		{"900123", RouteDecision{Action: RouteReject, StatusCode: 403, Reason: "Premium Numbers Barred"}},
+		{"004412345", RouteDecision{Action: RouteTo, Target: "sip:+4412345@gw.carrier.com;transport=tcp", Timeout: 30}},
+		{"100", RouteDecision{Action: RouteTo, Target: "ext100"}},
+		{"1000", RouteDecision{Action: RouteContinue}},
+	} {
+		decision, err := router.Route(&RouteRequest{Called: test.called})
+		if err != nil {
+			t.Fatalf("%s: %v", test.called, err)
+		}
+		if decision.Action != test.want.Action || decision.Target != test.want.Target ||
+			decision.StatusCode != test.want.StatusCode || decision.Reason != test.want.Reason ||
+			decision.Timeout != test.want.Timeout {
+			t.Errorf("%s: decision = %+v, want %+v", test.called, *decision, test.want)
+		}
+	}
+}
+
+func TestDialPlanRouterBadRule(t *testing.T) {
+	if _, err := NewDialPlanRouter(&DialRule{Pattern: "("}); err == nil {
+		t.Error("bad pattern accepted")
+	}
+	if _, err := NewDialPlanRouter(&DialRule{Trunk: "gw.carrier.com"}); err == nil {
+		t.Error("bad trunk accepted")
+	}
+}
@@ -0,0 +1,173 @@
+package b2bua
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimeWindow a daily time window, e.g. the business hours.
+type TimeWindow struct {
+	// Days of the window, every day if empty.
+	Days []time.Weekday
+	// Start and End offsets since midnight, End before Start spans midnight.
+	Start time.Duration
+	End   time.Duration
+}
+
+// NewTimeWindow a window from start to end, "09:00" and "18:00" on days.
+func NewTimeWindow(start, end string, days ...time.Weekday) (TimeWindow, error) {
+	s, err := parseClock(start)
+	if err != nil {
+		return TimeWindow{}, err
+	}
+	e, err := parseClock(end)
+	if err != nil {
+		return TimeWindow{}, err
+	}
+	return TimeWindow{Days: days, Start: s, End: e}, nil
+}
+
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains .
+func (w TimeWindow) Contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	day := t.Weekday()
+	if w.End < w.Start && offset < w.End {
+		// After midnight, the window started the day before.
+		day = (day + 6) % 7
+		offset += 24 * time.Hour
+	}
+	if len(w.Days) > 0 {
+		found := false
+		for _, d := range w.Days {
+			if d == day {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	end := w.End
+	if end < w.Start {
+		end += 24 * time.Hour
+	}
+	return offset >= w.Start && offset < end
+}
+
+// Calendar holidays, closed all day.
+type Calendar struct {
+	Name     string
+	holidays map[string]bool
+	mutex    sync.RWMutex
+}
+
+// NewCalendar .
+func NewCalendar(name string) *Calendar {
+	return &Calendar{
+		Name:     name,
+		holidays: make(map[string]bool),
+	}
+}
+
+// AddHoliday date is "2006-01-02", or "01-02" for a holiday every year.
+func (c *Calendar) AddHoliday(date string) *Calendar {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.holidays[date] = true
+	return c
+}
+
+// RemoveHoliday .
+func (c *Calendar) RemoveHoliday(date string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.holidays, date)
+}
+
+// IsHoliday .
+func (c *Calendar) IsHoliday(t time.Time) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.holidays[t.Format("2006-01-02")] || c.holidays[t.Format("01-02")]
+}
+
+// TimeRoute diverts the calls outside the open windows or on holidays to an alternate target.
+type TimeRoute struct {
+	// Open windows, always open if empty.
+	Open []TimeWindow
+	// Calendar of the holidays, optional.
+	Calendar *Calendar
+	// Location time zone of the windows and the calendar, local time if nil.
+	Location *time.Location
+	// Closed target of the calls outside the open windows, e.g. a voicemail or an answering service.
+	Closed string
+	// Holiday target of the calls on holidays, Closed if empty.
+	Holiday string
+}
+
+// Target the alternate target at t, false if the calls are routed as usual.
+func (r *TimeRoute) Target(t time.Time) (string, bool) {
+	if r.Location != nil {
+		t = t.In(r.Location)
+	}
+	if r.Calendar != nil && r.Calendar.IsHoliday(t) {
+		if len(r.Holiday) > 0 {
+			return r.Holiday, true
+		}
+		return r.Closed, len(r.Closed) > 0
+	}
+	if len(r.Open) == 0 {
+		return "", false
+	}
+	for _, w := range r.Open {
+		if w.Contains(t) {
+			return "", false
+		}
+	}
+	return r.Closed, len(r.Closed) > 0
+}
+
+// SetTimeRoute set the time based routing of the called numbers starting with prefix, an account
+// or a route prefix, nil to remove it.
+func (b *B2BUA) SetTimeRoute(prefix string, route *TimeRoute) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if route == nil {
+		delete(b.timeRoutes, prefix)
+		return
+	}
+	b.timeRoutes[prefix] = route
+}
+
+// GetTimeRoute the time route of the longest matching prefix of called.
+func (b *B2BUA) GetTimeRoute(called string) (*TimeRoute, bool) {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	var route *TimeRoute
+	matched := -1
+	for prefix, r := range b.timeRoutes {
+		if strings.HasPrefix(called, prefix) && len(prefix) > matched {
+			route, matched = r, len(prefix)
+		}
+	}
+	return route, route != nil
+}
+
+// timeTarget the alternate target of called now, false if the call is routed as usual.
+func (b *B2BUA) timeTarget(called string) (string, bool) {
+	route, found := b.GetTimeRoute(called)
+	if !found {
+		return "", false
+	}
+	return route.Target(time.Now())
+}
@@ -0,0 +1,132 @@
+package b2bua
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// ScreeningAction what happens to a screened call.
+type ScreeningAction int
+
+const (
+	// ScreenAllow ring the devices of the user.
+	ScreenAllow ScreeningAction = iota
+	// ScreenReject reject the call, 433 for anonymous callers (RFC 5079), 603 otherwise.
+	ScreenReject
+	// ScreenVoicemail route the call to the voicemail of the user.
+	ScreenVoicemail
+)
+
+func (a ScreeningAction) String() string {
+	switch a {
+	case ScreenAllow:
+		return "allow"
+	case ScreenReject:
+		return "reject"
+	case ScreenVoicemail:
+		return "voicemail"
+	}
+	return fmt.Sprintf("ScreeningAction(%d)", int(a))
+}
+
+// ScreeningRules call screening of an account, evaluated before ringing its devices.
+type ScreeningRules struct {
+	// Anonymous action for the callers hiding their identity.
+	Anonymous ScreeningAction
+	// Blocked action per calling number, a number ending with * matches a prefix.
+	Blocked map[string]ScreeningAction
+	// Voicemail number the ScreenVoicemail calls are routed to.
+	Voicemail string
+}
+
+// NewScreeningRules .
+func NewScreeningRules() *ScreeningRules {
+	return &ScreeningRules{
+		Blocked: make(map[string]ScreeningAction),
+	}
+}
+
+// Block set the action of the calls from number, e.g. "+33612345678" or "+3389*".
+func (r *ScreeningRules) Block(number string, action ScreeningAction) *ScreeningRules {
+	r.Blocked[number] = action
+	return r
+}
+
+// match the action of the calls from caller, the longest match wins.
+func (r *ScreeningRules) match(caller string, anonymous bool) ScreeningAction {
+	if anonymous {
+		return r.Anonymous
+	}
+	action, matched := ScreenAllow, -1
+	for number, a := range r.Blocked {
+		if strings.HasSuffix(number, "*") {
+			prefix := strings.TrimSuffix(number, "*")
+			if strings.HasPrefix(caller, prefix) && len(prefix) > matched {
+				action, matched = a, len(prefix)
+			}
+		} else if caller == number {
+			return a
+		}
+	}
+	return action
+}
+
+// SetScreening set the screening rules of the calls to account, nil to remove them.
+func (b *B2BUA) SetScreening(account string, rules *ScreeningRules) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if rules == nil {
+		delete(b.screening, account)
+		return
+	}
+	b.screening[account] = rules
+}
+
+// GetScreening .
+func (b *B2BUA) GetScreening(account string) (*ScreeningRules, bool) {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	rules, found := b.screening[account]
+	return rules, found
+}
+
+// IsAnonymous the caller hides its identity with an anonymous From (RFC 3323) or a Privacy header.
+func IsAnonymous(req sip.Request) bool {
+	if from, ok := req.From(); ok {
+		if strings.EqualFold(from.Address.Host(), "anonymous.invalid") {
+			return true
+		}
+		if user := from.Address.User(); user == nil || strings.EqualFold(user.String(), "anonymous") {
+			return true
+		}
+	}
+	for _, header := range req.GetHeaders("Privacy") {
+		for _, value := range strings.Split(header.Value(), ";") {
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "id", "user", "header":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// screen apply the screening rules of the called account, returns the action and the voicemail number.
+func (b *B2BUA) screen(req sip.Request, caller sip.Uri, called string) (ScreeningAction, string) {
+	rules, found := b.GetScreening(called)
+	if !found {
+		return ScreenAllow, ""
+	}
+	number := ""
+	if caller.User() != nil {
+		number = caller.User().String()
+	}
+	action := rules.match(number, IsAnonymous(req))
+	if action == ScreenVoicemail && len(rules.Voicemail) == 0 {
+		// No voicemail to divert to.
+		action = ScreenReject
+	}
+	return action, rules.Voicemail
+}
@@ -0,0 +1,142 @@
+package b2bua
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// DefaultSetupTimeout of the setup of the incoming calls, until their first B-Leg is sent.
+	DefaultSetupTimeout = 10 * time.Second
+)
+
+// setupState the stage of the setup of an incoming call, run off the stack worker.
+type setupState int
+
+const (
+	// setupRouting the dial plan and the routing services are asked for the route.
+	setupRouting setupState = iota
+	// setupAuthorizing the billing authorizes the call.
+	setupAuthorizing
+	// setupInviting the destinations are resolved and sent the first B-Leg.
+	setupInviting
+	// setupInvited a B-Leg was sent, the setup can't time out anymore.
+	setupInvited
+	// setupPushing the callee is woken up by a push notification, which has its own timeout.
+	setupPushing
+	setupDone
+	setupTimedOut
+)
+
+var setupStates = []string{"routing", "authorizing", "inviting", "invited", "pushing", "done", "timed out"}
+
+func (s setupState) String() string {
+	return setupStates[s]
+}
+
+// callSetup the state machine of the setup of an incoming call, the caller is answered 504 if its first
+// B-Leg isn't sent within the setup timeout.
+type callSetup struct {
+	mutex sync.Mutex
+	state setupState
+	timer *time.Timer
+}
+
+// SetSetupTimeout the time an incoming call may take to be routed, authorized and sent its first B-Leg,
+// DefaultSetupTimeout by default, 0 to disable.
+func (b *B2BUA) SetSetupTimeout(timeout time.Duration) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.setupTimeout = timeout
+}
+
+// GetSetupTimeout .
+func (b *B2BUA) GetSetupTimeout() time.Duration {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.setupTimeout
+}
+
+// startSetup start the setup of the call of sess, and its timeout.
+func (b *B2BUA) startSetup(sess *session.Session) *callSetup {
+	setup := &callSetup{state: setupRouting}
+	timeout := b.GetSetupTimeout()
+	if timeout <= 0 {
+		return setup
+	}
+	setup.mutex.Lock()
+	defer setup.mutex.Unlock()
+	setup.timer = time.AfterFunc(timeout, func() {
+		state, expired := setup.expire()
+		if !expired {
+			return
+		}
+		logger.Infof("Setup of call %v timed out after %v while %v", sess.CallID(), timeout, state)
+		b.reject(sess, 504, "Server Time-out")
+		b.dialogs.Remove(sess)
+	})
+	return setup
+}
+
+// enter move the setup to state, false if it timed out meanwhile.
+func (s *callSetup) enter(state setupState) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.state == setupTimedOut {
+		return false
+	}
+	if state > s.state {
+		s.state = state
+	}
+	if s.state >= setupInvited && s.timer != nil {
+		s.timer.Stop()
+	}
+	return true
+}
+
+// conclude end the setup before the caller is answered otherwise than by a B-Leg, false if it was answered
+// 504 meanwhile.
+func (s *callSetup) conclude() bool {
+	return s.enter(setupDone)
+}
+
+// finish end the setup, unless it timed out.
+func (s *callSetup) finish() {
+	s.enter(setupDone)
+}
+
+// expire time out the setup, the stage it was in and false if a B-Leg was sent meanwhile.
+func (s *callSetup) expire() (setupState, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state := s.state
+	if state >= setupInvited {
+		return state, false
+	}
+	s.state = setupTimedOut
+	return state, true
+}
+
+// rejectSetup answer the caller of sess statusCode, unless it was answered 504 meanwhile.
+func (b *B2BUA) rejectSetup(setup *callSetup, sess *session.Session, statusCode sip.StatusCode, reason string) {
+	if setup.conclude() {
+		b.reject(sess, statusCode, reason)
+	}
+}
+
+// failSetup answer the caller of sess, to which no B-Leg could be sent, 403 if the transport security policy
+// refused them, 480 otherwise, unless it was answered 504 meanwhile or cancelled.
+func (b *B2BUA) failSetup(setup *callSetup, sess *session.Session, insecure bool) {
+	if sess.IsInProgress() {
+		if insecure {
+			b.rejectSetup(setup, sess, 403, "TLS Required")
+		} else {
+			logger.Infof("No B-Leg of call %v could be sent", sess.CallID())
+			b.rejectSetup(setup, sess, 480, "Temporarily Unavailable")
+		}
+	}
+	b.dialogs.Remove(sess)
+}
@@ -0,0 +1,30 @@
+package b2bua
+
+import (
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
+)
+
+// isInsecureTransport the request was refused by the transport security policy.
+func isInsecureTransport(err error) bool {
+	_, ok := err.(*stack.InsecureTransportError)
+	return ok
+}
+
+// isSecureTransport .
+func isSecureTransport(transport string) bool {
+	return stack.IsSecureTransport(transport)
+}
+
+// secureContacts the contacts registered over TLS or WSS, the only ones a sips: call may reach, false if none.
+func secureContacts(contacts *map[string]*registry.ContactInstance) (*map[string]*registry.ContactInstance, bool) {
+	secure := make(map[string]*registry.ContactInstance)
+	for source, instance := range *contacts {
+		if stack.IsSecureTransport(instance.Transport) {
+			secure[source] = instance
+		} else {
+			logger.Debugf("Skipping contact %v registered over %s for a sips: call", instance.Contact.Address, instance.Transport)
+		}
+	}
+	return &secure, len(secure) > 0
+}
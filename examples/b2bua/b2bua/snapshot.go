@@ -0,0 +1,125 @@
+package b2bua
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// DefaultSnapshotInterval of the registry snapshots.
+	DefaultSnapshotInterval = 30 * time.Second
+)
+
+// RegistrySnapshot the periodic snapshots of the registrations and their push subscriptions to a local
+// file, restored on start by RestoreRegistry so that the devices are reachable again without waiting
+// for them to register.
+type RegistrySnapshot struct {
+	Path string
+	// Interval of the snapshots, DefaultSnapshotInterval if 0.
+	Interval time.Duration
+	stop     chan struct{}
+}
+
+// SetRegistrySnapshot snapshot the registry periodically, nil to stop.
+func (b *B2BUA) SetRegistrySnapshot(snapshot *RegistrySnapshot) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	if b.registrySnapshot != nil {
+		close(b.registrySnapshot.stop)
+	}
+	b.registrySnapshot = snapshot
+	if snapshot != nil {
+		if snapshot.Interval <= 0 {
+			snapshot.Interval = DefaultSnapshotInterval
+		}
+		snapshot.stop = make(chan struct{})
+		go b.snapshotRegistry(snapshot)
+	}
+}
+
+// GetRegistrySnapshot .
+func (b *B2BUA) GetRegistrySnapshot() *RegistrySnapshot {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.registrySnapshot
+}
+
+// snapshotRegistry every interval, until the snapshot is replaced.
+func (b *B2BUA) snapshotRegistry(snapshot *RegistrySnapshot) {
+	ticker := time.NewTicker(snapshot.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-snapshot.stop:
+			return
+		case <-ticker.C:
+			if _, err := registry.WriteSnapshot(snapshot.Path, b.GetRegistry()); err != nil {
+				logger.Errorf("Registry snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// SnapshotRegistry write a snapshot of the registry now, e.g. on shutdown, returns the bindings written.
+func (b *B2BUA) SnapshotRegistry() (int, error) {
+	snapshot := b.GetRegistrySnapshot()
+	if snapshot == nil {
+		return 0, nil
+	}
+	return registry.WriteSnapshot(snapshot.Path, b.GetRegistry())
+}
+
+// RestoreRegistry add the bindings of the last snapshot which haven't expired since, with their push
+// subscriptions and expiry timers. Call it once at startup, before the first snapshot is due.
+func (b *B2BUA) RestoreRegistry() (int, error) {
+	snapshot := b.GetRegistrySnapshot()
+	if snapshot == nil {
+		return 0, nil
+	}
+	saved, err := registry.ReadSnapshot(snapshot.Path)
+	if err != nil || saved == nil {
+		return 0, err
+	}
+	return b.restoreSnapshot(saved), nil
+}
+
+// restoreSnapshot add the bindings of saved which haven't expired, with their push subscriptions and
+// expiry timers.
+func (b *B2BUA) restoreSnapshot(saved *registry.Snapshot) int {
+	now := time.Now()
+	return saved.Restore(b.GetRegistry(), now, func(aor sip.Uri, instance *registry.ContactInstance) {
+		remaining := int64(instance.LastUpdated) + int64(instance.RegExpires) - now.Unix()
+		b.scheduleExpiry(aor, instance.Source, time.Duration(remaining)*time.Second)
+		b.rfc8599.HandleContactInstance(aor, instance)
+	})
+}
+
+// HandOverRegistry send the registrations to the process the sockets are handed off to, restored there
+// by TakeOverRegistry, e.g. those of a registry in memory.
+func (b *B2BUA) HandOverRegistry() {
+	b.stack.SetHandoffState(func() []byte {
+		data, err := json.Marshal(registry.NewSnapshot(b.GetRegistry()))
+		if err != nil {
+			logger.Errorf("Registry handover failed: %v", err)
+			return nil
+		}
+		return data
+	})
+}
+
+// TakeOverRegistry add the bindings handed over with the sockets by the previous process, returns the
+// bindings added.
+func (b *B2BUA) TakeOverRegistry() (int, error) {
+	state := b.stack.HandoffState()
+	if len(state) == 0 {
+		return 0, nil
+	}
+	saved, err := registry.ParseSnapshot(state)
+	if err != nil {
+		return 0, err
+	}
+	return b.restoreSnapshot(saved), nil
+}
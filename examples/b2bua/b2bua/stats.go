@@ -0,0 +1,143 @@
+package b2bua
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
+)
+
+const (
+	// callRateWindow seconds the CPS is averaged over.
+	callRateWindow = 60
+)
+
+// Stats snapshot of the B2BUA for the monitoring tools without Prometheus, served as JSON by
+// StatsHandler.
+type Stats struct {
+	Time   time.Time `json:"time"`
+	Uptime int64     `json:"uptime"`
+	// Calls active, AnsweredCalls of them answered.
+	Calls         int `json:"calls"`
+	AnsweredCalls int `json:"answered_calls"`
+	// CallAttempts INVITEs received since start, CPS their rate over the last minute.
+	CallAttempts uint64  `json:"call_attempts"`
+	CPS          float64 `json:"cps"`
+	PeakCPS      float64 `json:"peak_cps"`
+	// Registrations registered AORs, Contacts their bindings.
+	Registrations int `json:"registrations"`
+	Contacts      int `json:"contacts"`
+	// ExpiryTimers bindings waiting for their expiry.
+	ExpiryTimers int                `json:"expiry_timers"`
+	Messages     stack.MessageStats `json:"messages"`
+	Connections  int                `json:"connections"`
+	WorkerQueued int                `json:"worker_queued"`
+	// WorkerRejected requests answered 503 because the worker queues were full.
+	WorkerRejected uint64 `json:"worker_rejected"`
+	// ScannersBlocked requests of the SIP scanners dropped or tarpitted.
+	ScannersBlocked uint64 `json:"scanners_blocked"`
+	// DNSUnauthenticated answers of the resolver not authenticated by DNSSEC, refused or not.
+	DNSUnauthenticated uint64 `json:"dns_unauthenticated"`
+	// PostDialDelay histograms of the post-dial delays by trunk, "local" for the calls to the registered users.
+	PostDialDelay map[string]Histogram `json:"post_dial_delay,omitempty"`
+}
+
+// callRate counts the call attempts per second over the last callRateWindow seconds.
+type callRate struct {
+	mutex   sync.Mutex
+	started time.Time
+	total   uint64
+	buckets [callRateWindow]uint64
+	last    int64
+}
+
+func newCallRate() *callRate {
+	return &callRate{started: time.Now(), last: time.Now().Unix()}
+}
+
+// advance clear the buckets of the seconds elapsed since the last count, locked.
+func (r *callRate) advance(now int64) {
+	for second := r.last + 1; second <= now && second <= r.last+callRateWindow; second++ {
+		r.buckets[second%callRateWindow] = 0
+	}
+	if now > r.last {
+		r.last = now
+	}
+}
+
+func (r *callRate) count() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now().Unix()
+	r.advance(now)
+	r.buckets[now%callRateWindow]++
+	r.total++
+}
+
+// rates the total attempts, the average and the peak CPS of the window.
+func (r *callRate) rates() (uint64, float64, float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.advance(time.Now().Unix())
+	sum, peak := uint64(0), uint64(0)
+	for _, n := range r.buckets {
+		sum += n
+		if n > peak {
+			peak = n
+		}
+	}
+	window := float64(callRateWindow)
+	// Average over the uptime until the window is full.
+	if elapsed := time.Since(r.started).Seconds(); elapsed < window {
+		window = elapsed + 1
+	}
+	return r.total, float64(sum) / window, float64(peak)
+}
+
+// Stats .
+func (b *B2BUA) Stats() *Stats {
+	stats := &Stats{
+		Time:     time.Now(),
+		Uptime:   int64(time.Since(b.callRate.started).Seconds()),
+		Messages: b.stack.MessageStats(),
+	}
+	stats.CallAttempts, stats.CPS, stats.PeakCPS = b.callRate.rates()
+	for _, call := range b.Calls() {
+		stats.Calls++
+		if call.IsAnswered() {
+			stats.AnsweredCalls++
+		}
+	}
+	for _, instances := range b.GetRegistry().GetAllContacts() {
+		stats.Registrations++
+		stats.Contacts += len(instances)
+	}
+	stats.ExpiryTimers = b.ExpiryTimers()
+	if connections, ok := b.stack.ConnectionStats(); ok {
+		stats.Connections = connections.Connections
+	}
+	if workers, ok := b.stack.WorkerStats(); ok {
+		stats.WorkerQueued = workers.Queued
+		stats.WorkerRejected = workers.Rejected
+	}
+	if scanners, ok := b.stack.ScannerStats(); ok {
+		stats.ScannersBlocked = scanners.Dropped + scanners.Tarpitted
+	}
+	if resolver, ok := b.stack.ResolverStats(); ok {
+		stats.DNSUnauthenticated = resolver.Unauthenticated
+	}
+	stats.PostDialDelay = b.PostDialDelays()
+	return stats
+}
+
+// StatsHandler serve the Stats as JSON.
+func (b *B2BUA) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(b.Stats())
+	})
+}
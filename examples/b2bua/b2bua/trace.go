@@ -0,0 +1,280 @@
+package b2bua
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// TraceLogger name of the logger of the SIP trace.
+	TraceLogger = "SipTrace"
+	// traceLinger a traced Call-ID stays traced this long after its last message.
+	traceLinger = 5 * time.Minute
+)
+
+var (
+	traceLogger log.Logger
+)
+
+func init() {
+	traceLogger = utils.NewLogrusLogger(log.InfoLevel, TraceLogger, nil)
+}
+
+// TraceFilter the messages to trace, all the set criteria must match: the user of the From, To
+// or Request-URI, the Call-ID pattern, and the address of the peer, an IP or a CIDR.
+type TraceFilter struct {
+	ID     int    `json:"id"`
+	User   string `json:"user,omitempty"`
+	CallID string `json:"call_id,omitempty"`
+	Source string `json:"source,omitempty"`
+
+	callID *regexp.Regexp
+	source *net.IPNet
+}
+
+// compile check the filter and parse its patterns.
+func (f *TraceFilter) compile() error {
+	if len(f.User) == 0 && len(f.CallID) == 0 && len(f.Source) == 0 {
+		return fmt.Errorf("empty trace filter")
+	}
+	if len(f.CallID) > 0 {
+		re, err := regexp.Compile(f.CallID)
+		if err != nil {
+			return err
+		}
+		f.callID = re
+	}
+	if len(f.Source) > 0 {
+		cidr := f.Source
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() == nil {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		f.source = network
+	}
+	return nil
+}
+
+func (f *TraceFilter) match(msg sip.Message, callID string, peer net.IP) bool {
+	if f.callID != nil && !f.callID.MatchString(callID) {
+		return false
+	}
+	if f.source != nil && (peer == nil || !f.source.Contains(peer)) {
+		return false
+	}
+	if len(f.User) > 0 && !hasUser(msg, f.User) {
+		return false
+	}
+	return true
+}
+
+// hasUser user is the user of the From, the To or the Request-URI of msg.
+func hasUser(msg sip.Message, user string) bool {
+	if from, ok := msg.From(); ok && from.Address != nil && from.Address.User() != nil && from.Address.User().String() == user {
+		return true
+	}
+	if to, ok := msg.To(); ok && to.Address != nil && to.Address.User() != nil && to.Address.User().String() == user {
+		return true
+	}
+	if req, ok := msg.(sip.Request); ok && req.Recipient() != nil && req.Recipient().User() != nil {
+		return req.Recipient().User().String() == user
+	}
+	return false
+}
+
+// Tracer logs the SIP messages of the calls and registrations matching its filters, so the
+// signaling of a user can be followed without enabling the debug logs. Once a message matches,
+// all the messages of its Call-ID, and of the other leg of its call, are traced.
+type Tracer struct {
+	mutex   sync.Mutex
+	filters []*TraceFilter
+	nextID  int
+	traced  map[string]time.Time
+	swept   time.Time
+}
+
+// NewTracer .
+func NewTracer() *Tracer {
+	return &Tracer{
+		traced: make(map[string]time.Time),
+		nextID: 1,
+		swept:  time.Now(),
+	}
+}
+
+// Add start tracing the messages matching filter, returns its ID.
+func (t *Tracer) Add(filter TraceFilter) (int, error) {
+	if err := filter.compile(); err != nil {
+		return 0, err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	filter.ID = t.nextID
+	t.nextID++
+	t.filters = append(t.filters, &filter)
+	logger.Infof("SIP trace %d started: user %q, call-id %q, source %q", filter.ID, filter.User, filter.CallID, filter.Source)
+	return filter.ID, nil
+}
+
+// Remove the filter id, the calls it matched stop being traced.
+func (t *Tracer) Remove(id int) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i, filter := range t.filters {
+		if filter.ID == id {
+			t.filters = append(t.filters[:i], t.filters[i+1:]...)
+			if len(t.filters) == 0 {
+				t.traced = make(map[string]time.Time)
+			}
+			logger.Infof("SIP trace %d stopped", id)
+			return true
+		}
+	}
+	return false
+}
+
+// Filters .
+func (t *Tracer) Filters() []TraceFilter {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	filters := make([]TraceFilter, 0, len(t.filters))
+	for _, filter := range t.filters {
+		filters = append(filters, *filter)
+	}
+	return filters
+}
+
+// Trace log msg if it matches a filter or belongs to a traced Call-ID.
+func (t *Tracer) Trace(msg sip.Message, inbound bool) {
+	t.mutex.Lock()
+	if len(t.filters) == 0 {
+		t.mutex.Unlock()
+		return
+	}
+	callID := ""
+	if header, ok := msg.CallID(); ok {
+		callID = header.Value()
+	}
+	address := msg.Destination()
+	if inbound {
+		address = msg.Source()
+	}
+	now := time.Now()
+	_, traced := t.traced[callID]
+	if !traced {
+		peer := peerIP(address)
+		for _, filter := range t.filters {
+			if filter.match(msg, callID, peer) {
+				traced = true
+				break
+			}
+		}
+	}
+	if traced && len(callID) > 0 {
+		t.traced[callID] = now
+	}
+	t.sweep(now)
+	t.mutex.Unlock()
+
+	if traced {
+		direction := "sent to"
+		if inbound {
+			direction = "received from"
+		}
+		traceLogger.Infof("%s %s:\n%s", direction, address, msg.String())
+	}
+}
+
+// link trace the B-Leg of a traced A-Leg, and the other way round.
+func (t *Tracer) link(aLeg string, bLeg string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if at, found := t.traced[aLeg]; found {
+		t.traced[bLeg] = at
+	} else if at, found := t.traced[bLeg]; found {
+		t.traced[aLeg] = at
+	}
+}
+
+// sweep forget the Call-IDs idle for traceLinger, at most every minute, locked.
+func (t *Tracer) sweep(now time.Time) {
+	if now.Sub(t.swept) < time.Minute {
+		return
+	}
+	t.swept = now
+	for callID, last := range t.traced {
+		if now.Sub(last) > traceLinger {
+			delete(t.traced, callID)
+		}
+	}
+}
+
+// peerIP the IP of a host:port address, nil if it isn't an IP.
+func peerIP(address string) net.IP {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	return net.ParseIP(host)
+}
+
+// Handler toggle the traces at runtime: GET lists the filters, POST adds the TraceFilter of the
+// JSON body and returns it with its ID, DELETE with the id query parameter removes one.
+func (t *Tracer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body interface{}
+		switch r.Method {
+		case http.MethodGet:
+			body = t.Filters()
+		case http.MethodPost:
+			filter := TraceFilter{}
+			if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			id, err := t.Add(filter)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			filter.ID = id
+			body = filter
+		case http.MethodDelete:
+			id, err := strconv.Atoi(r.URL.Query().Get("id"))
+			if err != nil || !t.Remove(id) {
+				http.Error(w, "trace not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	})
+}
+
+// Tracer the SIP trace of the B2BUA, disabled until a filter is added.
+func (b *B2BUA) Tracer() *Tracer {
+	return b.tracer
+}
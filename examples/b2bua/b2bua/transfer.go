@@ -0,0 +1,403 @@
+package b2bua
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/events"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// transfer a blind transfer, RFC 3515, requested by the REFER of a leg of an answered call, the
+// transferor: the other leg, the transferee, is bridged to new B-Legs to the Refer-To target, and the
+// transferor is released once the target answers. The progress is notified to the transferor in
+// message/sipfrag NOTIFYs.
+type transfer struct {
+	call       *B2BCall
+	transferor *session.Session
+	transferee *session.Session
+	// legs the B-Legs to the contacts of the target.
+	legs []*B2BCall
+	// released the transferor hung up before the target answered.
+	released bool
+	// username the account of the transferor the target is called for, authenticated whether it was, rather
+	// than trusted.
+	username      string
+	authenticated bool
+	// reservation, billing and maxDuration of the call to the target, given to the B-Leg which answers.
+	setup       time.Time
+	reservation *Reservation
+	billing     *billingSession
+	maxDuration time.Duration
+}
+
+// handleRefer accept the REFER of a leg of an answered call and transfer the other leg to the Refer-To
+// target, or to the other party of the dialog it replaces.
+func (b *B2BUA) handleRefer(request sip.Request, tx sip.ServerTransaction) {
+	respond := func(statusCode sip.StatusCode, reason string) {
+		tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, statusCode, reason, ""))
+	}
+	call, transferor := b.findLeg(request)
+	if call == nil {
+		respond(481, "Call/Transaction Does Not Exist")
+		return
+	}
+	if !call.IsAnswered() {
+		respond(603, "Decline")
+		return
+	}
+	username, authenticated, allowed := b.transferorAccount(request, call, transferor)
+	if !allowed {
+		logger.Infof("Call %v: REFER of an unauthenticated leg refused", call.ToString())
+		respond(403, "Forbidden")
+		return
+	}
+	headers := request.GetHeaders("Refer-To")
+	if len(headers) != 1 {
+		respond(400, "Bad Refer-To")
+		return
+	}
+	_, referTo, _, err := parser.ParseAddressValue(headers[0].Value())
+	if err != nil || referTo.User() == nil {
+		respond(400, "Bad Refer-To")
+		return
+	}
+	transferee := call.src
+	if transferor == call.src {
+		transferee = call.dest
+	}
+	if replaces, found := referReplaces(referTo); found {
+		b.attendedTransfer(request, tx, call, transferor, transferee, replaces)
+		return
+	}
+	t := &transfer{call: call, transferor: transferor, transferee: transferee, username: username, authenticated: authenticated}
+	b.callsLock.Lock()
+	_, pending := b.transfers[transferor]
+	if !pending {
+		_, pending = b.transfers[transferee]
+	}
+	if !pending {
+		b.transfers[transferor] = t
+		b.transfers[transferee] = t
+	}
+	b.callsLock.Unlock()
+	if pending {
+		respond(491, "Request Pending")
+		return
+	}
+	respond(202, "Accepted")
+	logger.Infof("Call %v: transfer to [%v]", call.ToString(), referTo)
+	b.auditCall("transfer", transferor, referTo.String())
+	go b.startTransfer(t, referTo, request)
+}
+
+// findLeg the call and the leg of the in-dialog request.
+func (b *B2BUA) findLeg(request sip.Request) (*B2BCall, *session.Session) {
+	callID, ok := request.CallID()
+	if !ok {
+		return nil, nil
+	}
+	for _, call := range b.Calls() {
+		if call.src.CallID().Equals(callID) {
+			return call, call.src
+		}
+		if call.dest.CallID().Equals(callID) {
+			return call, call.dest
+		}
+	}
+	return nil, nil
+}
+
+// transferorAccount the account of the transferor leg of call the REFER request is from: the authenticated
+// caller of an incoming leg, or the callee of a B-Leg sending it from one of its registered contacts.
+// authenticated is false for the legs of the trusted networks, allowed false for the other legs.
+func (b *B2BUA) transferorAccount(request sip.Request, call *B2BCall, transferor *session.Session) (string, bool, bool) {
+	party, _ := remoteParty(transferor)
+	if party.User() == nil {
+		return "", false, false
+	}
+	if b.authenticator == nil {
+		// Nothing is authenticated.
+		return party.User().String(), false, true
+	}
+	source := stack.RequestSource{Addr: request.Source(), Transport: request.Transport()}
+	if policy, ok := b.GetChallengePolicy().(interface {
+		IsTrusted(source stack.RequestSource) bool
+	}); ok && policy.IsTrusted(source) {
+		return party.User().String(), false, true
+	}
+	if transferor == call.src {
+		username, authenticated := b.authenticatedUser(transferor.Request())
+		if !authenticated || len(username) == 0 || call.IsEmergency() {
+			return "", false, false
+		}
+		return username, true, true
+	}
+	contacts, found := b.registry.GetContacts(party)
+	address := peerIP(request.Source())
+	if !found || address == nil {
+		return "", false, false
+	}
+	for _, instance := range *contacts {
+		if ip := peerIP(instance.Source); ip != nil && ip.Equal(address) {
+			return party.User().String(), true, true
+		}
+	}
+	return "", false, false
+}
+
+// startTransfer INVITE the target, a number of the domain of the call whatever the host of referTo, from
+// the transferee. It is set up as a new call of the transferor: routed by the dial plan, authorized for its
+// account, billed and admitted.
+func (b *B2BUA) startTransfer(t *transfer, referTo sip.Uri, request sip.Request) {
+	b.notifyTransferor(t, 100, "Trying", false)
+	setup := time.Now()
+	to, _ := t.call.src.Request().To()
+	caller, displayName := remoteParty(t.transferee)
+	route := &Route{Called: b.translateCalled(request, divert(to.Address, referTo.User().String()))}
+	if code, reason := b.routeNumber(request, caller, route); code != 0 {
+		b.failTransfer(t, code, reason)
+		return
+	}
+	if authorizer := b.GetCallAuthorizer(); authorizer != nil && t.authenticated {
+		if allowed, reason := authorizer(t.username, route.Called); !allowed {
+			logger.Infof("Account %s may not transfer to [%v]: %s", t.username, route.Called, reason)
+			if len(reason) == 0 {
+				reason = "Forbidden"
+			}
+			b.failTransfer(t, 403, reason)
+			return
+		}
+	}
+	contacts, err := b.routeContacts(route)
+	if err != nil {
+		logger.Errorf("Call %v: transfer to [%v]: %v", t.call.ToString(), route.Called, err)
+		b.failTransfer(t, 404, "Not Found")
+		return
+	}
+	billing, authorized, err := b.authorize(request, t.username, route.Called.User().String())
+	if err != nil {
+		logger.Infof("Billing rejects the transfer of %v to [%v]: %v", t.call.ToString(), route.Called, err)
+		code, reason := billingRejection(err)
+		b.failTransfer(t, code, reason)
+		return
+	}
+	addrs := []string{legAddr(t.transferee)}
+	for _, instance := range *contacts {
+		addrs = append(addrs, instance.Source)
+	}
+	reservation, code := b.admit(t.transferee.RemoteSdp(), addrs...)
+	if code != 200 {
+		billing.abandon(setup)
+		b.failTransfer(t, code, admissionReason(code))
+		return
+	}
+	b.callsLock.Lock()
+	t.setup, t.reservation, t.billing = setup, reservation, billing
+	t.maxDuration = shorterDuration(b.maxCallDuration(t.username, addrs...), authorized)
+	b.callsLock.Unlock()
+	legs, err := b.bridgeLegs(t.transferee, caller, displayName, route, contacts, t.call.correlationID)
+	if err != nil {
+		logger.Errorf("Call %v: transfer to [%v]: %v", t.call.ToString(), route.Called, err)
+		b.failTransfer(t, 404, "Not Found")
+		return
+	}
+	b.callsLock.Lock()
+	t.legs = legs
+	for _, leg := range legs {
+		b.transfers[leg.dest] = t
+	}
+	b.callsLock.Unlock()
+}
+
+// legAddr the address of the party of sess.
+func legAddr(sess *session.Session) string {
+	if sess.Direction() == session.Incoming {
+		return sess.Request().Source()
+	}
+	return sess.Request().Destination()
+}
+
+// remoteParty the identity of the party of sess: the caller of an incoming leg, the callee of a B-Leg.
+func remoteParty(sess *session.Session) (sip.Uri, string) {
+	var party *sip.Address
+	if sess.Direction() == session.Incoming {
+		from, _ := sess.Request().From()
+		party = &sip.Address{DisplayName: from.DisplayName, Uri: from.Address}
+	} else {
+		to, _ := sess.Request().To()
+		party = &sip.Address{DisplayName: to.DisplayName, Uri: to.Address}
+	}
+	if party.DisplayName == nil {
+		return party.Uri, ""
+	}
+	return party.Uri, party.DisplayName.String()
+}
+
+// transferHandler handle the state changes of the sessions of the pending transfers, returns false for
+// those left to the B2BUA.
+func (b *B2BUA) transferHandler(sess *session.Session, resp *sip.Response, state session.Status) bool {
+	b.callsLock.RLock()
+	t, found := b.transfers[sess]
+	b.callsLock.RUnlock()
+	if !found {
+		return false
+	}
+	ended := state == session.Failure || state == session.Canceled || state == session.Terminated
+	switch sess {
+	case t.transferor:
+		if !ended {
+			return false
+		}
+		// The transferee stays, to be bridged to the target.
+		b.callsLock.Lock()
+		t.released = true
+		delete(b.transfers, sess)
+		b.callsLock.Unlock()
+		b.dialogs.Remove(sess)
+		t.call.setCause(cdr.Transferred)
+		b.removeCall(sess)
+		return true
+	case t.transferee:
+		if !ended {
+			return false
+		}
+		released := b.dropTransfer(t)
+		b.releaseTransfer(t)
+		for _, leg := range t.legs {
+			leg.dest.End()
+		}
+		if released {
+			b.dialogs.Remove(sess)
+			return true
+		}
+		b.notifyTransferor(t, 487, "Request Terminated", true)
+		return false
+	}
+
+	switch state {
+	case session.EarlyMedia:
+		fallthrough
+	case session.Provisional:
+		if resp != nil && *resp != nil && (*resp).StatusCode() > 100 {
+			b.notifyTransferor(t, (*resp).StatusCode(), (*resp).Reason(), false)
+		}
+	case session.Confirmed:
+		for _, leg := range t.legs {
+			if leg.dest == sess {
+				b.completeTransfer(t, leg)
+				break
+			}
+		}
+	case session.Failure, session.Canceled, session.Terminated:
+		b.dialogs.Remove(sess)
+		b.callsLock.Lock()
+		delete(b.transfers, sess)
+		remaining := 0
+		for _, leg := range t.legs {
+			if _, found := b.transfers[leg.dest]; found {
+				remaining++
+			}
+		}
+		b.callsLock.Unlock()
+		if remaining == 0 {
+			statusCode, reason := sip.StatusCode(487), "Request Terminated"
+			if resp != nil && *resp != nil {
+				statusCode, reason = (*resp).StatusCode(), (*resp).Reason()
+			}
+			b.failTransfer(t, statusCode, reason)
+		}
+	}
+	return true
+}
+
+// completeTransfer bridge the transferee to the B-Leg call which answered, end the other B-Legs and
+// release the transferor.
+func (b *B2BUA) completeTransfer(t *transfer, call *B2BCall) {
+	released := b.dropTransfer(t)
+	for _, leg := range t.legs {
+		if leg != call && leg.dest.IsInProgress() {
+			leg.dest.End()
+		}
+	}
+	if !released {
+		b.dialogs.Remove(t.transferor)
+		t.call.setCause(cdr.Transferred)
+		b.removeCall(t.transferor)
+		go func() {
+			b.notifyTransferor(t, 200, "OK", true)
+			t.transferor.Bye()
+		}()
+	}
+	b.callsLock.Lock()
+	call.reservation, call.billing, call.maxDuration = t.reservation, t.billing, t.maxDuration
+	t.reservation, t.billing = nil, nil
+	b.callsLock.Unlock()
+	call.billing.attach()
+	b.addCall(call)
+	b.answered(call)
+	b.publishCall(events.CallTransferred, call)
+	logger.Infof("Call %v transferred", call.ToString())
+	go b.connectMedia(call, call.dest.RemoteSdp())
+}
+
+// failTransfer notify the transferor of the final statusCode, the call stays as it was, the transferee
+// is hung up if the transferor is gone.
+func (b *B2BUA) failTransfer(t *transfer, statusCode sip.StatusCode, reason string) {
+	logger.Infof("Call %v: transfer failed: %d %s", t.call.ToString(), statusCode, reason)
+	b.releaseTransfer(t)
+	if b.dropTransfer(t) {
+		b.dialogs.Remove(t.transferee)
+		t.transferee.Bye()
+		return
+	}
+	b.notifyTransferor(t, statusCode, reason, true)
+}
+
+// releaseTransfer release the reservation and abandon the billing of the call to the target, which didn't
+// answer.
+func (b *B2BUA) releaseTransfer(t *transfer) {
+	b.callsLock.Lock()
+	reservation, billing := t.reservation, t.billing
+	t.reservation, t.billing = nil, nil
+	b.callsLock.Unlock()
+	if reservation != nil {
+		reservation.Release()
+	}
+	billing.abandon(t.setup)
+}
+
+// dropTransfer forget the sessions of t, returns whether the transferor was released.
+func (b *B2BUA) dropTransfer(t *transfer) bool {
+	b.callsLock.Lock()
+	defer b.callsLock.Unlock()
+	for _, leg := range t.legs {
+		delete(b.transfers, leg.dest)
+	}
+	delete(b.transfers, t.transferor)
+	delete(b.transfers, t.transferee)
+	return t.released
+}
+
+// notifyTransferor the progress of the transfer as a message/sipfrag status line, the implicit
+// subscription of the REFER terminated with the final one.
+func (b *B2BUA) notifyTransferor(t *transfer, statusCode sip.StatusCode, reason string, final bool) {
+	b.callsLock.RLock()
+	released := t.released
+	b.callsLock.RUnlock()
+	if released {
+		return
+	}
+	state := "active;expires=60"
+	if final {
+		state = "terminated;reason=noresource"
+	}
+	frag := fmt.Sprintf("SIP/2.0 %d %s\r\n", statusCode, reason)
+	if _, err := t.transferor.Notify("refer", state, frag, "message/sipfrag;version=2.0"); err != nil {
+		logger.Errorf("Call %v: NOTIFY of the transferor failed: %v", t.call.ToString(), err)
+	}
+}
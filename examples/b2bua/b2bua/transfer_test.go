@@ -0,0 +1,291 @@
+package b2bua
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// legRecorder the requests sent in the dialogs of the legs, all answered 200 OK.
+type legRecorder struct {
+	mutex    sync.Mutex
+	requests []sip.Request
+}
+
+func (r *legRecorder) send(ctx context.Context, request sip.Request, authorizer sip.Authorizer, waitForResult bool, attempt int) (sip.Response, error) {
+	r.mutex.Lock()
+	r.requests = append(r.requests, request)
+	r.mutex.Unlock()
+	return sip.NewResponseFromRequest(request.MessageID(), request, 200, "OK", ""), nil
+}
+
+// sent the requests of method sent in the dialog callID, as "NOTIFY <body>" or "BYE".
+func (r *legRecorder) sent(callID string, method sip.RequestMethod) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sent := []string{}
+	for _, request := range r.requests {
+		if id, ok := request.CallID(); !ok || string(*id) != callID || request.Method() != method {
+			continue
+		}
+		sent = append(sent, strings.TrimSpace(string(method)+" "+strings.TrimSpace(request.Body())))
+	}
+	return sent
+}
+
+func (r *legRecorder) wait(t *testing.T, callID string, method sip.RequestMethod, want ...string) {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sent := r.sent(callID, method)
+		if len(sent) >= len(want) {
+			for i := range want {
+				if sent[i] != want[i] {
+					t.Fatalf("%s sent %q, want %q", callID, sent, want)
+				}
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s sent %q, want %q", callID, sent, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newLeg a confirmed leg from the caller user to the callee user, incoming or a B-Leg.
+func (r *legRecorder) newLeg(t *testing.T, callID, caller, callee string, direction session.Direction) *session.Session {
+	req := parseRequest(t, "INVITE sip:"+callee+"@example.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK"+callID+"\r\n"+
+		"From: <sip:"+caller+"@example.com>;tag=from-"+callID+"\r\n"+
+		"To: <sip:"+callee+"@example.com>;tag=to-"+callID+"\r\n"+
+		"Call-ID: "+callID+"\r\n"+
+		"CSeq: 1 INVITE\r\n"+
+		"Contact: <sip:"+caller+"@192.0.2.1:5060>\r\n"+
+		"Content-Length: 0\r\n\r\n")
+	contacts, _ := req.Contact()
+	uaType := "UAC"
+	if direction == session.Incoming {
+		uaType = "UAS"
+	}
+	sess := session.NewInviteSession(r.send, uaType, contacts, req, sip.CallID(callID), nil, direction, nil)
+	sess.StoreResponse(sip.NewResponseFromRequest(req.MessageID(), req, 200, "OK", ""))
+	sess.SetState(session.Confirmed)
+	return sess
+}
+
+// referTx a server transaction recording the response to the REFER.
+type referTx struct {
+	sip.ServerTransaction
+	responses chan sip.Response
+}
+
+func (tx *referTx) Respond(res sip.Response) error {
+	tx.responses <- res
+	return nil
+}
+
+func newTestB2BUA(t *testing.T) *B2BUA {
+	b, err := NewB2BUAWithConfig(&B2BUAConfig{UDP: "127.0.0.1:0", Realm: "b2bua", DisableAuth: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func answeredCall(src, dest *session.Session) *B2BCall {
+	now := time.Now()
+	return &B2BCall{src: src, dest: dest, timing: CallTiming{Setup: now, Answered: now}}
+}
+
+func refer(t *testing.T, b *B2BUA, callID string, referTo ...string) sip.StatusCode {
+	raw := "REFER sip:b2bua@example.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bKrefer" + callID + "\r\n" +
+		"From: <sip:alice@example.com>;tag=from-" + callID + "\r\n" +
+		"To: <sip:bob@example.com>;tag=to-" + callID + "\r\n" +
+		"Call-ID: " + callID + "\r\n" +
+		"CSeq: 2 REFER\r\n"
+	for _, target := range referTo {
+		raw += "Refer-To: " + target + "\r\n"
+	}
+	tx := &referTx{responses: make(chan sip.Response, 1)}
+	b.handleRefer(parseRequest(t, raw+"Content-Length: 0\r\n\r\n"), tx)
+	select {
+	case res := <-tx.responses:
+		return res.StatusCode()
+	default:
+		t.Fatal("REFER not answered")
+	}
+	return 0
+}
+
+func TestReferRejected(t *testing.T) {
+	b := newTestB2BUA(t)
+	defer b.Shutdown()
+	legs := &legRecorder{}
+	call := answeredCall(legs.newLeg(t, "a-leg", "alice", "bob", session.Incoming), legs.newLeg(t, "b-leg", "alice", "bob", session.Outgoing))
+	b.addCall(call)
+	ringing := &B2BCall{
+		src:  legs.newLeg(t, "ringing-a", "alice", "dave", session.Incoming),
+		dest: legs.newLeg(t, "ringing-b", "alice", "dave", session.Outgoing),
+	}
+	b.addCall(ringing)
+
+	for _, test := range []struct {
+		name    string
+		callID  string
+		referTo []string
+		code    sip.StatusCode
+	}{
+		{"no call", "unknown", []string{"<sip:carol@example.com>"}, 481},
+		{"not answered", "ringing-a", []string{"<sip:carol@example.com>"}, 603},
+		{"no Refer-To", "a-leg", nil, 400},
+		{"two Refer-To", "a-leg", []string{"<sip:carol@example.com>", "<sip:dave@example.com>"}, 400},
+		{"no user", "a-leg", []string{"<sip:example.com>"}, 400},
+	} {
+		if code := refer(t, b, test.callID, test.referTo...); code != test.code {
+			t.Errorf("%s: REFER answered %d, want %d", test.name, code, test.code)
+		}
+	}
+
+	// A second transfer of a leg of the call is refused while the first is pending.
+	b.callsLock.Lock()
+	b.transfers[call.dest] = &transfer{call: call, transferor: call.dest, transferee: call.src}
+	b.callsLock.Unlock()
+	if code := refer(t, b, "a-leg", "<sip:carol@example.com>"); code != 491 {
+		t.Errorf("REFER during a transfer answered %d, want 491", code)
+	}
+}
+
+func TestBlindTransferFailure(t *testing.T) {
+	b := newTestB2BUA(t)
+	defer b.Shutdown()
+	legs := &legRecorder{}
+	call := answeredCall(legs.newLeg(t, "a-leg", "alice", "bob", session.Incoming), legs.newLeg(t, "b-leg", "alice", "bob", session.Outgoing))
+	b.addCall(call)
+
+	// Bob transfers Alice to Carol, who isn't registered.
+	if code := refer(t, b, "b-leg", "<sip:carol@example.com>"); code != 202 {
+		t.Fatalf("REFER answered %d, want 202", code)
+	}
+	legs.wait(t, "b-leg", sip.NOTIFY, "NOTIFY SIP/2.0 100 Trying", "NOTIFY SIP/2.0 404 Not Found")
+	b.callsLock.RLock()
+	pending := len(b.transfers)
+	b.callsLock.RUnlock()
+	if pending != 0 {
+		t.Errorf("%d sessions of the failed transfer left", pending)
+	}
+	if calls := b.Calls(); len(calls) != 1 || calls[0] != call {
+		t.Errorf("calls = %v, want the call as it was", calls)
+	}
+	if byes := legs.sent("a-leg", sip.BYE); len(byes) != 0 {
+		t.Errorf("the transferee was hung up: %q", byes)
+	}
+}
+
+func TestCompleteTransfer(t *testing.T) {
+	b := newTestB2BUA(t)
+	defer b.Shutdown()
+	legs := &legRecorder{}
+	transferee := legs.newLeg(t, "a-leg", "alice", "bob", session.Incoming)
+	transferor := legs.newLeg(t, "b-leg", "alice", "bob", session.Outgoing)
+	call := answeredCall(transferee, transferor)
+	b.addCall(call)
+	answering := &B2BCall{src: transferee, dest: legs.newLeg(t, "c-leg", "alice", "carol", session.Outgoing)}
+	tx := &cancelTx{canceled: make(chan struct{})}
+	req := newInvite(t, "sip:carol@example.com", "sip:carol@example.com")
+	ringing := &B2BCall{src: transferee, dest: session.NewInviteSession(legs.send, "UAC", nil, req, "c-leg-2", tx, session.Outgoing, nil)}
+	ringing.dest.SetState(session.Provisional)
+	t1 := &transfer{call: call, transferor: transferor, transferee: transferee, legs: []*B2BCall{answering, ringing}}
+	b.callsLock.Lock()
+	for _, sess := range []*session.Session{transferor, transferee, answering.dest, ringing.dest} {
+		b.transfers[sess] = t1
+	}
+	b.callsLock.Unlock()
+
+	b.completeTransfer(t1, answering)
+	select {
+	case <-tx.canceled:
+	case <-time.After(time.Second):
+		t.Error("the other B-Leg to the target not cancelled")
+	}
+	legs.wait(t, "b-leg", sip.NOTIFY, "NOTIFY SIP/2.0 200 OK")
+	legs.wait(t, "b-leg", sip.BYE, "BYE")
+	if calls := b.Calls(); len(calls) != 1 || calls[0] != answering {
+		t.Errorf("calls = %v, want the transferee bridged to the target", calls)
+	}
+	if b.findCall(transferor) != nil {
+		t.Error("the transferor's leg is still a call leg")
+	}
+	b.callsLock.RLock()
+	pending := len(b.transfers)
+	b.callsLock.RUnlock()
+	if pending != 0 {
+		t.Errorf("%d sessions of the completed transfer left", pending)
+	}
+}
+
+func TestAttendedTransfer(t *testing.T) {
+	b := newTestB2BUA(t)
+	defer b.Shutdown()
+	legs := &legRecorder{}
+	// Bob talks to Alice, then to Carol, and transfers Alice to Carol.
+	first := answeredCall(legs.newLeg(t, "a-leg", "alice", "bob", session.Incoming), legs.newLeg(t, "b-leg", "alice", "bob", session.Outgoing))
+	second := answeredCall(legs.newLeg(t, "b2-leg", "bob", "carol", session.Incoming), legs.newLeg(t, "c-leg", "bob", "carol", session.Outgoing))
+	b.addCall(first)
+	b.addCall(second)
+
+	if code := refer(t, b, "b-leg", "<sip:carol@example.com?Replaces=unknown%3Bto-tag%3Dx>"); code != 481 {
+		t.Errorf("REFER replacing an unknown dialog answered %d, want 481", code)
+	}
+	if code := refer(t, b, "b-leg", "<sip:carol@example.com?Replaces=b2-leg%3Bto-tag%3Dto-b2-leg%3Bfrom-tag%3Dfrom-b2-leg>"); code != 202 {
+		t.Fatalf("REFER answered %d, want 202", code)
+	}
+	legs.wait(t, "b-leg", sip.NOTIFY, "NOTIFY SIP/2.0 200 OK")
+	legs.wait(t, "b-leg", sip.BYE, "BYE")
+	legs.wait(t, "b2-leg", sip.BYE, "BYE")
+	calls := b.Calls()
+	if len(calls) != 1 || calls[0].src != first.src || calls[0].dest != second.dest {
+		t.Fatalf("calls = %v, want Alice bridged to Carol", calls)
+	}
+}
+
+func TestReferReplaces(t *testing.T) {
+	for _, test := range []struct {
+		referTo string
+		found   bool
+		want    replaces
+	}{
+		{"<sip:carol@example.com?Replaces=12345%40192.0.2.1%3Bto-tag%3D12345%3Bfrom-tag%3D5FFE-3994>", true,
+			replaces{callID: "12345@192.0.2.1", toTag: "12345", fromTag: "5FFE-3994"}},
+		{"<sip:carol@example.com?Replaces=12345%3Bearly-only>", true, replaces{callID: "12345"}},
+		{"<sip:carol@example.com>", false, replaces{}},
+		{"<sip:carol@example.com?Subject=hello>", false, replaces{}},
+	} {
+		req := parseRequest(t, "REFER sip:b2bua@example.com SIP/2.0\r\n"+
+			"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bKrefer\r\n"+
+			"From: <sip:alice@example.com>;tag=1\r\n"+
+			"To: <sip:bob@example.com>;tag=2\r\n"+
+			"Call-ID: refer\r\n"+
+			"CSeq: 2 REFER\r\n"+
+			"Refer-To: "+test.referTo+"\r\n"+
+			"Content-Length: 0\r\n\r\n")
+		_, referTo, _, err := parser.ParseAddressValue(req.GetHeaders("Refer-To")[0].Value())
+		if err != nil {
+			t.Fatalf("%s: %v", test.referTo, err)
+		}
+		r, found := referReplaces(referTo)
+		if found != test.found {
+			t.Errorf("%s: found = %v, want %v", test.referTo, found, test.found)
+			continue
+		}
+		if found && *r != test.want {
+			t.Errorf("%s: replaces = %+v, want %+v", test.referTo, *r, test.want)
+		}
+	}
+}
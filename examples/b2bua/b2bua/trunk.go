@@ -0,0 +1,169 @@
+package b2bua
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// Trunk a peer or network zone of the B2BUA, e.g. a SIP trunk to a carrier or the network of a branch office.
+type Trunk struct {
+	Name string
+	// Networks addresses of the peers of the trunk.
+	Networks []*net.IPNet
+	// Bandwidth budget in kbit/s of the calls with media anchored on the trunk, 0 for unlimited.
+	Bandwidth int
+	// MaxDuration maximum duration of the answered calls on the trunk, 0 for unlimited.
+	MaxDuration time.Duration
+	// Routes outbound proxies traversed by the calls sent to the trunk, e.g. the SBC of the carrier.
+	Routes []sip.Uri
+	// TLS overrides the certificate verification of the trunk dialed over TLS, e.g. its pins, nil to verify
+	// it as the other peers, see WithTLSVerify.
+	TLS *stack.TLSPeer
+	// PostDialDelayThreshold the post-dial delay over which the calls of the trunk publish a slow progress
+	// event, the one of SetPostDialDelayThreshold if 0.
+	PostDialDelayThreshold time.Duration
+	// Responses translates the final responses of the trunk relayed to the callers, nil to relay them as is.
+	Responses ResponseMap
+}
+
+// NewTrunk create a trunk of the given networks, as CIDRs (10.0.0.0/8) or IP addresses.
+func NewTrunk(name string, bandwidth int, networks ...string) (*Trunk, error) {
+	t := &Trunk{
+		Name:      name,
+		Networks:  []*net.IPNet{},
+		Bandwidth: bandwidth,
+	}
+	for _, network := range networks {
+		if err := t.AddNetwork(network); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// AddNetwork add a CIDR or an IP address to the trunk.
+func (t *Trunk) AddNetwork(network string) error {
+	if ip := net.ParseIP(network); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		t.Networks = append(t.Networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return nil
+	}
+	_, ipNet, err := net.ParseCIDR(network)
+	if err != nil {
+		return err
+	}
+	t.Networks = append(t.Networks, ipNet)
+	return nil
+}
+
+// SetRoutes set the chain of outbound proxies of the trunk, in order, e.g. "sip:sbc.carrier.com;lr".
+func (t *Trunk) SetRoutes(uris ...string) error {
+	routes, err := utils.ParseRoutes(uris...)
+	if err != nil {
+		return err
+	}
+	t.Routes = routes
+	return nil
+}
+
+// Contains check if addr, host or host:port, belongs to the trunk.
+func (t *Trunk) Contains(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range t.Networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dials the trunk dials host, one of its outbound proxies or of its networks.
+func (t *Trunk) Dials(host string) bool {
+	for _, route := range t.Routes {
+		if strings.EqualFold(route.Host(), host) {
+			return true
+		}
+	}
+	return t.Contains(host)
+}
+
+// AddTrunk add or replace the trunk with the same name.
+func (b *B2BUA) AddTrunk(trunk *Trunk) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	for idx, t := range b.trunks {
+		if t.Name == trunk.Name {
+			b.trunks[idx] = trunk
+			return
+		}
+	}
+	b.trunks = append(b.trunks, trunk)
+}
+
+// RemoveTrunk .
+func (b *B2BUA) RemoveTrunk(name string) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	for idx, t := range b.trunks {
+		if t.Name == name {
+			b.trunks = append(b.trunks[:idx], b.trunks[idx+1:]...)
+			return
+		}
+	}
+}
+
+// GetTrunks .
+func (b *B2BUA) GetTrunks() []*Trunk {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return append([]*Trunk{}, b.trunks...)
+}
+
+// trunkNamed the trunk of name, nil if none.
+func (b *B2BUA) trunkNamed(name string) *Trunk {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	for _, t := range b.trunks {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// FindTrunk the first trunk containing addr, nil if none.
+func (b *B2BUA) FindTrunk(addr string) *Trunk {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	for _, t := range b.trunks {
+		if t.Contains(addr) {
+			return t
+		}
+	}
+	return nil
+}
+
+// trunkTLSPeer the TLS override of the trunk dialing host, if any.
+func (b *B2BUA) trunkTLSPeer(host string) (stack.TLSPeer, bool) {
+	for _, t := range b.GetTrunks() {
+		if t.TLS != nil && t.Dials(host) {
+			return *t.TLS, true
+		}
+	}
+	return stack.TLSPeer{}, false
+}
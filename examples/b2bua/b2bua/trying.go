@@ -0,0 +1,77 @@
+package b2bua
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+)
+
+// TryingPolicy when the caller is sent 100 Trying.
+type TryingPolicy string
+
+const (
+	// TryingAfterRouting once the callee is found and the call admitted, the default: the calls refused
+	// meanwhile are answered the final response only.
+	TryingAfterRouting TryingPolicy = "routing"
+	// TryingImmediate on receipt of the INVITE, which stops its retransmissions before a slow dial plan,
+	// billing or push.
+	TryingImmediate TryingPolicy = "immediate"
+)
+
+// SetTryingPolicy .
+func (b *B2BUA) SetTryingPolicy(policy TryingPolicy) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.tryingPolicy = policy
+}
+
+// GetTryingPolicy .
+func (b *B2BUA) GetTryingPolicy() TryingPolicy {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	if len(b.tryingPolicy) == 0 {
+		return TryingAfterRouting
+	}
+	return b.tryingPolicy
+}
+
+// SetProvisionalRefresh resend the last provisional response of the callers over UDP every interval until
+// the call is answered or fails, so that a long post-dial delay doesn't expire the timers of the caller
+// and of the proxies in between (RFC 3261 13.3.1.1), 0 to disable.
+func (b *B2BUA) SetProvisionalRefresh(interval time.Duration) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	b.provisionalRefresh = interval
+}
+
+// GetProvisionalRefresh .
+func (b *B2BUA) GetProvisionalRefresh() time.Duration {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.provisionalRefresh
+}
+
+// trying send 100 Trying to the caller of sess at the point of policy, and start the refresh of its
+// provisional responses.
+func (b *B2BUA) trying(sess *session.Session, policy TryingPolicy) {
+	if b.GetTryingPolicy() != policy {
+		return
+	}
+	sess.Provisional(100, "Trying")
+	interval := b.GetProvisionalRefresh()
+	if interval <= 0 || !strings.EqualFold(sess.Request().Transport(), "udp") {
+		return
+	}
+	var refresh func()
+	refresh = func() {
+		if !sess.IsInProgress() {
+			return
+		}
+		if resp := sess.Response(); resp != nil && resp.IsProvisional() {
+			sess.Provisional(resp.StatusCode(), resp.Reason())
+		}
+		time.AfterFunc(interval, refresh)
+	}
+	time.AfterFunc(interval, refresh)
+}
@@ -0,0 +1,244 @@
+package b2bua
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// AccountOnline the first binding of an account was registered.
+	AccountOnline = "account.online"
+	// AccountOffline the last binding of an account disappeared: unregistered, expired, or its connection lost.
+	AccountOffline = "account.offline"
+	// DefaultWebhookTimeout of a callback.
+	DefaultWebhookTimeout = 5 * time.Second
+	// webhookRetries of a failed callback, the delay doubles from a second.
+	webhookRetries = 3
+	// webhookQueue callbacks waiting for their delivery, the new ones are dropped beyond.
+	webhookQueue = 1000
+)
+
+// RegistrationWebhook the HTTP callbacks of a tenant on the online state of its accounts, for the
+// provisioning and the analytics systems. The AccountEvent is POSTed as JSON, signed with Secret.
+type RegistrationWebhook struct {
+	URL string
+	// Headers of the HTTP requests, e.g. Authorization.
+	Headers map[string]string
+	// Secret of the X-Signature header, sha256= the hex HMAC-SHA256 of the body, none if empty.
+	Secret string
+	client *http.Client
+}
+
+// NewRegistrationWebhook timeout of the callbacks, DefaultWebhookTimeout if 0.
+func NewRegistrationWebhook(url string, secret string, timeout time.Duration) *RegistrationWebhook {
+	if timeout <= 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	return &RegistrationWebhook{
+		URL:     url,
+		Headers: make(map[string]string),
+		Secret:  secret,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// AccountEvent the body of a callback.
+type AccountEvent struct {
+	Event  string    `json:"event"`
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant"`
+	AOR    string    `json:"aor"`
+	User   string    `json:"user"`
+	// Reason of the change: registered, unregistered, expired, connection-lost or erased.
+	Reason string `json:"reason"`
+	// Devices the registered bindings, the last ones known when the account goes offline.
+	Devices []registry.DeviceInfo `json:"devices"`
+}
+
+func (w *RegistrationWebhook) post(event *AccountEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+	if len(w.Secret) > 0 {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// presence the accounts online, and the callbacks to deliver.
+type presence struct {
+	mutex  sync.Mutex
+	online map[string]*onlineAccount
+	queue  chan *accountDelivery
+}
+
+// onlineAccount an account with live bindings, by user@domain.
+type onlineAccount struct {
+	aor     sip.Uri
+	devices []registry.DeviceInfo
+}
+
+type accountDelivery struct {
+	webhook *RegistrationWebhook
+	event   *AccountEvent
+}
+
+// SetRegistrationWebhook call webhook when the accounts of tenant, a domain, go online or offline, "*"
+// for the tenants without their own webhook, nil to remove.
+func (b *B2BUA) SetRegistrationWebhook(tenant string, webhook *RegistrationWebhook) {
+	b.configLock.Lock()
+	defer b.configLock.Unlock()
+	tenant = strings.ToLower(tenant)
+	if webhook == nil {
+		delete(b.webhooks, tenant)
+		return
+	}
+	b.webhooks[tenant] = webhook
+	if b.presence == nil {
+		b.presence = &presence{
+			online: make(map[string]*onlineAccount),
+			queue:  make(chan *accountDelivery, webhookQueue),
+		}
+		go b.deliverWebhooks(b.presence)
+	}
+}
+
+// GetRegistrationWebhook the webhook of tenant, or the default one.
+func (b *B2BUA) GetRegistrationWebhook(tenant string) (*RegistrationWebhook, bool) {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	if webhook, found := b.webhooks[strings.ToLower(tenant)]; found {
+		return webhook, true
+	}
+	webhook, found := b.webhooks["*"]
+	return webhook, found
+}
+
+func (b *B2BUA) getPresence() *presence {
+	b.configLock.RLock()
+	defer b.configLock.RUnlock()
+	return b.presence
+}
+
+// liveDevices the bindings of aor which haven't expired.
+func (b *B2BUA) liveDevices(aor sip.Uri, now time.Time) []registry.DeviceInfo {
+	devices := []registry.DeviceInfo{}
+	contacts, found := b.registry.GetContacts(aor)
+	if !found || contacts == nil {
+		return devices
+	}
+	for _, instance := range *contacts {
+		if instance.LastUpdated > 0 && int64(instance.LastUpdated)+int64(instance.RegExpires) <= now.Unix() {
+			continue
+		}
+		devices = append(devices, instance.DeviceInfo())
+	}
+	return devices
+}
+
+// updatePresence check the online state of the account of aor after a change of its bindings, and call
+// the webhook of its tenant when it changed.
+func (b *B2BUA) updatePresence(aor sip.Uri, reason string) {
+	p := b.getPresence()
+	if p == nil || aor.User() == nil {
+		return
+	}
+	webhook, found := b.GetRegistrationWebhook(aor.Host())
+	if !found {
+		return
+	}
+	now := time.Now()
+	devices := b.liveDevices(aor, now)
+	user := aor.User().String()
+	key := user + "@" + strings.ToLower(aor.Host())
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	previous, online := p.online[key]
+	var event *AccountEvent
+	switch {
+	case len(devices) > 0 && !online:
+		event = &AccountEvent{Event: AccountOnline, Devices: devices}
+		p.online[key] = &onlineAccount{aor: aor.Clone(), devices: devices}
+	case len(devices) == 0 && online:
+		event = &AccountEvent{Event: AccountOffline, Devices: previous.devices}
+		delete(p.online, key)
+	case online:
+		previous.devices = devices
+		return
+	default:
+		return
+	}
+	event.Time = now
+	event.Tenant = aor.Host()
+	event.AOR = aor.String()
+	event.User = user
+	event.Reason = reason
+	select {
+	case p.queue <- &accountDelivery{webhook: webhook, event: event}:
+	default:
+		logger.Warnf("Webhook queue full, %s of %s dropped", event.Event, event.AOR)
+	}
+}
+
+// checkPresence update the presence of all the accounts online, e.g. after a connection was lost.
+func (b *B2BUA) checkPresence(p *presence, reason string) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	aors := make([]sip.Uri, 0, len(p.online))
+	for _, account := range p.online {
+		aors = append(aors, account.aor)
+	}
+	p.mutex.Unlock()
+	for _, aor := range aors {
+		b.updatePresence(aor, reason)
+	}
+}
+
+// deliverWebhooks post the callbacks in order, with retries.
+func (b *B2BUA) deliverWebhooks(p *presence) {
+	for delivery := range p.queue {
+		delay := time.Second
+		err := delivery.webhook.post(delivery.event)
+		for retry := 0; err != nil && retry < webhookRetries; retry++ {
+			time.Sleep(delay)
+			delay *= 2
+			err = delivery.webhook.post(delivery.event)
+		}
+		if err != nil {
+			logger.Errorf("Webhook %s of %s to %s failed: %v", delivery.event.Event, delivery.event.AOR, delivery.webhook.URL, err)
+		}
+	}
+}
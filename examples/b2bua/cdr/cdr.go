@@ -0,0 +1,123 @@
+package cdr
+
+import (
+	"strings"
+	"time"
+)
+
+// Disposition final state of a call.
+type Disposition string
+
+const (
+	// Answered the call was answered.
+	Answered Disposition = "ANSWERED"
+	// Failed the callee rejected or couldn't be reached.
+	Failed Disposition = "FAILED"
+	// Cancelled the caller hung up before the answer.
+	Cancelled Disposition = "CANCELLED"
+)
+
+// Cause why the call was terminated.
+type Cause string
+
+const (
+	// CallerHangup the caller hung up the answered call.
+	CallerHangup Cause = "caller_hangup"
+	// CalleeHangup the callee hung up the answered call.
+	CalleeHangup Cause = "callee_hangup"
+	// Released the B2BUA released the call, e.g. at its maximum duration or once a leg stopped answering
+	// the keep-alives.
+	Released Cause = "released"
+	// Transferred a party transferred the call, its legs continue in another call.
+	Transferred Cause = "transferred"
+	// Rejected the callee, or the B2BUA, rejected the call.
+	Rejected Cause = "rejected"
+	// Abandoned the caller hung up before the answer.
+	Abandoned Cause = "abandoned"
+)
+
+// Record a call detail record, written once the call is released.
+type Record struct {
+	// CallID of the caller leg, CalleeCallID of the callee leg.
+	CallID       string `json:"call_id"`
+	CalleeCallID string `json:"callee_call_id"`
+	// CorrelationID of the call, the prefix of the Call-ID of the callee leg.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Caller the AOR of the caller, its From, and Called the AOR called, its To, e.g. sip:100@example.com.
+	Caller string `json:"caller"`
+	Called string `json:"called"`
+	// Source address of the caller, Destination address of the callee.
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+
+	Setup      time.Time `json:"setup"`
+	Ringing    time.Time `json:"ringing,omitempty"`
+	EarlyMedia time.Time `json:"early_media,omitempty"`
+	Answered   time.Time `json:"answered,omitempty"`
+	Ended      time.Time `json:"ended"`
+
+	// Duration billable time, from the answer to the release.
+	Duration time.Duration `json:"duration"`
+	// EarlyMediaDuration media time before the answer.
+	EarlyMediaDuration time.Duration `json:"early_media_duration"`
+	// PostDialDelay time from the setup to the first progress of the callee, 0 if none.
+	PostDialDelay time.Duration `json:"post_dial_delay,omitempty"`
+
+	Disposition Disposition `json:"disposition"`
+	// Cause of the termination.
+	Cause Cause `json:"cause"`
+	// StatusCode and Reason of the final response of the callee, if any.
+	StatusCode int    `json:"status_code"`
+	Reason     string `json:"reason,omitempty"`
+
+	// MediaSecurity policy of the call, best-effort, require or forbid, and MediaEncryption negotiated
+	// by the answer: none, srtp or dtls-srtp.
+	MediaSecurity   string `json:"media_security,omitempty"`
+	MediaEncryption string `json:"media_encryption,omitempty"`
+	// OnNet the call between users registered on the B2BUA bypassed its media processing.
+	OnNet bool `json:"on_net,omitempty"`
+	// Emergency the call was routed to the emergency destination, Location a location (Geolocation or
+	// PIDF-LO) was conveyed to it, RFC 6442.
+	Emergency bool `json:"emergency,omitempty"`
+	Location  bool `json:"location,omitempty"`
+}
+
+// Writer a sink of the call detail records.
+type Writer interface {
+	// Write the record, writers may buffer the records until Close.
+	Write(record *Record) error
+	// Close flush the buffered records.
+	Close() error
+}
+
+// Purger a Writer storing the records, which can remove them for the retention and the erasure of the
+// personal data.
+type Purger interface {
+	// Purge remove the records of the calls ended before.
+	Purge(before time.Time) (int64, error)
+	// Erase remove the records of the calls of a user, the user part of the AOR of the caller or the
+	// called.
+	Erase(user string) (int64, error)
+}
+
+// AORUser the user part of an AOR of a record, e.g. 100 of sip:100@example.com or of tel:100, aor
+// itself if it isn't a URI.
+func AORUser(aor string) string {
+	colon := strings.Index(aor, ":")
+	if colon < 0 {
+		return aor
+	}
+	rest := aor[colon+1:]
+	if at := strings.Index(rest, "@"); at >= 0 {
+		return rest[:at]
+	}
+	if strings.EqualFold(aor[:colon], "tel") {
+		return strings.SplitN(rest, ";", 2)[0]
+	}
+	return ""
+}
+
+// isUserOf the record is a call of user, the caller or the called.
+func (record *Record) isUserOf(user string) bool {
+	return AORUser(record.Caller) == user || AORUser(record.Called) == user
+}
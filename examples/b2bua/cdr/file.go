@@ -0,0 +1,166 @@
+package cdr
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JSONWriter appends the records to a file, a JSON object by line.
+type JSONWriter struct {
+	mutex  sync.Mutex
+	file   *os.File
+	buffer *bufio.Writer
+}
+
+// NewJSONWriter append the records to path, created if needed.
+func NewJSONWriter(path string) (*JSONWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONWriter{file: file, buffer: bufio.NewWriter(file)}, nil
+}
+
+// Write the record, flushed to the file.
+func (w *JSONWriter) Write(record *Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return ErrClosed
+	}
+	w.buffer.Write(data)
+	w.buffer.WriteByte('\n')
+	return w.buffer.Flush()
+}
+
+// Close .
+func (w *JSONWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	w.buffer.Flush()
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// csvHeader the columns of the CSV files, the times in RFC 3339 and the durations in milliseconds.
+var csvHeader = []string{
+	"call_id", "callee_call_id", "correlation_id", "caller", "called", "source", "destination",
+	"setup", "ringing", "early_media", "answered", "ended",
+	"duration_ms", "early_media_ms", "post_dial_delay_ms", "disposition", "cause", "status_code", "reason",
+	"media_security", "media_encryption", "on_net", "emergency", "location",
+}
+
+// CSVWriter appends the records to a CSV file, with a header line when it's created.
+type CSVWriter struct {
+	mutex  sync.Mutex
+	file   *os.File
+	writer *csv.Writer
+}
+
+// NewCSVWriter append the records to path, created with the header line if needed.
+func NewCSVWriter(path string) (*CSVWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	w := &CSVWriter{file: file, writer: csv.NewWriter(file)}
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		w.writer.Write(csvHeader)
+		w.writer.Flush()
+	}
+	return w, nil
+}
+
+// Write the record, flushed to the file.
+func (w *CSVWriter) Write(record *Record) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return ErrClosed
+	}
+	w.writer.Write([]string{
+		record.CallID, record.CalleeCallID, record.CorrelationID, record.Caller, record.Called,
+		record.Source, record.Destination,
+		csvTime(record.Setup), csvTime(record.Ringing), csvTime(record.EarlyMedia), csvTime(record.Answered),
+		csvTime(record.Ended),
+		strconv.FormatInt(record.Duration.Milliseconds(), 10),
+		strconv.FormatInt(record.EarlyMediaDuration.Milliseconds(), 10),
+		strconv.FormatInt(record.PostDialDelay.Milliseconds(), 10),
+		string(record.Disposition), string(record.Cause), strconv.Itoa(record.StatusCode), record.Reason,
+		record.MediaSecurity, record.MediaEncryption, strconv.FormatBool(record.OnNet),
+		strconv.FormatBool(record.Emergency), strconv.FormatBool(record.Location),
+	})
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// Close .
+func (w *CSVWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	w.writer.Flush()
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// WriterFunc a Writer calling the function with each record, e.g. to hand them to the application.
+type WriterFunc func(record *Record) error
+
+// Write .
+func (f WriterFunc) Write(record *Record) error {
+	return f(record)
+}
+
+// Close .
+func (f WriterFunc) Close() error {
+	return nil
+}
+
+// MultiWriter a Writer writing the records to each of writers.
+type MultiWriter []Writer
+
+// Write the record to each writer, returns the first error.
+func (m MultiWriter) Write(record *Record) error {
+	var first error
+	for _, writer := range m {
+		if err := writer.Write(record); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close each writer, returns the first error.
+func (m MultiWriter) Close() error {
+	var first error
+	for _, writer := range m {
+		if err := writer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
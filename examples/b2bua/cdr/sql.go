@@ -0,0 +1,508 @@
+package cdr
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+)
+
+// Dialect SQL dialect of the database.
+type Dialect int
+
+const (
+	// Postgres PostgreSQL, $1 placeholders.
+	Postgres Dialect = iota
+	// MySQL MySQL/MariaDB, ? placeholders.
+	MySQL
+)
+
+const (
+	spillPrefix  = "cdr-spill-"
+	spillSuffix  = ".jsonl"
+	replaySuffix = ".replay"
+)
+
+var (
+	// ErrClosed the writer is closed.
+	ErrClosed = errors.New("cdr: writer closed")
+
+	columns = []string{
+		"call_id", "callee_call_id", "caller", "called", "source", "destination",
+		"setup", "ringing", "early_media", "answered", "ended",
+		"duration_ms", "early_media_ms", "disposition", "status_code", "reason",
+		"media_security", "media_encryption", "on_net", "post_dial_delay_ms",
+		"correlation_id", "cause", "emergency", "location",
+	}
+)
+
+// SQLOptions .
+type SQLOptions struct {
+	Dialect Dialect
+	// Table name, "cdr" by default.
+	Table string
+	// BatchSize records inserted at once, 100 by default.
+	BatchSize int
+	// FlushInterval maximum time a record waits for its batch, 1s by default.
+	FlushInterval time.Duration
+	// Retries of a failed batch before spilling it, RetryDelay doubles after each retry.
+	Retries    int
+	RetryDelay time.Duration
+	// SpillDir directory of the records which couldn't be inserted, replayed once the database is back.
+	// Empty to drop them.
+	SpillDir string
+	// QueueSize records buffered before Write spills them directly, 10000 by default.
+	QueueSize int
+}
+
+// SQLWriter writes the records to PostgreSQL/MySQL in batches, the driver is up to the application.
+type SQLWriter struct {
+	db        *sql.DB
+	options   SQLOptions
+	records   chan *Record
+	jobs      chan func()
+	closed    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	spillLock sync.Mutex
+	log       log.Logger
+}
+
+// NewSQLWriter .
+func NewSQLWriter(db *sql.DB, options SQLOptions) (*SQLWriter, error) {
+	if len(options.Table) == 0 {
+		options.Table = "cdr"
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Second
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = time.Second
+	}
+	if options.QueueSize <= 0 {
+		options.QueueSize = 10000
+	}
+	if len(options.SpillDir) > 0 {
+		if err := os.MkdirAll(options.SpillDir, 0750); err != nil {
+			return nil, err
+		}
+	}
+	w := &SQLWriter{
+		db:      db,
+		options: options,
+		records: make(chan *Record, options.QueueSize),
+		jobs:    make(chan func()),
+		closed:  make(chan struct{}),
+		done:    make(chan struct{}),
+		log:     utils.NewLogrusLogger(log.InfoLevel, "CDR", nil),
+	}
+	go w.run()
+	return w, nil
+}
+
+// CreateTableSQL the statement creating the CDR table.
+func CreateTableSQL(dialect Dialect, table string) string {
+	timestamp, text := "TIMESTAMPTZ", "TEXT"
+	if dialect == MySQL {
+		timestamp, text = "DATETIME(3)", "VARCHAR(255)"
+	}
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	call_id %[2]s NOT NULL,
+	callee_call_id %[2]s,
+	caller %[2]s,
+	called %[2]s,
+	source %[2]s,
+	destination %[2]s,
+	setup %[3]s NOT NULL,
+	ringing %[3]s NULL,
+	early_media %[3]s NULL,
+	answered %[3]s NULL,
+	ended %[3]s NOT NULL,
+	duration_ms BIGINT NOT NULL,
+	early_media_ms BIGINT NOT NULL,
+	disposition VARCHAR(16) NOT NULL,
+	status_code INTEGER NOT NULL,
+	reason %[2]s,
+	media_security VARCHAR(16),
+	media_encryption VARCHAR(16),
+	on_net BOOLEAN NOT NULL DEFAULT FALSE,
+	post_dial_delay_ms BIGINT NOT NULL DEFAULT 0,
+	correlation_id %[2]s,
+	cause VARCHAR(16),
+	emergency BOOLEAN NOT NULL DEFAULT FALSE,
+	location BOOLEAN NOT NULL DEFAULT FALSE
+)`, table, text, timestamp)
+}
+
+// Write queue the record, it's spilled to disk directly if the queue is full.
+func (w *SQLWriter) Write(record *Record) error {
+	select {
+	case <-w.closed:
+		return ErrClosed
+	default:
+	}
+	select {
+	case w.records <- record:
+		return nil
+	default:
+		w.log.Warnf("CDR queue full, spill record %s", record.CallID)
+		return w.spill([]*Record{record})
+	}
+}
+
+// Close flush the queued records.
+func (w *SQLWriter) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.closed)
+	})
+	<-w.done
+	return nil
+}
+
+func (w *SQLWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.options.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Record, 0, w.options.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			w.flush(batch)
+			batch = make([]*Record, 0, w.options.BatchSize)
+		}
+	}
+	for {
+		select {
+		case record := <-w.records:
+			batch = append(batch, record)
+			if len(batch) >= w.options.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case job := <-w.jobs:
+			// The queued records are purged too.
+			flush()
+			job()
+		case <-w.closed:
+			for {
+				select {
+				case record := <-w.records:
+					batch = append(batch, record)
+					if len(batch) >= w.options.BatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush insert the batch with retries, or spill it; the spilled records are replayed
+// after a successful insert.
+func (w *SQLWriter) flush(batch []*Record) {
+	delay := w.options.RetryDelay
+	err := w.insert(batch)
+	for retry := 0; err != nil && retry < w.options.Retries; retry++ {
+		w.log.Warnf("Insert %d CDRs failed: %v, retry in %v", len(batch), err, delay)
+		select {
+		case <-time.After(delay):
+		case <-w.closed:
+			// Don't hold the shutdown, the batch is spilled.
+			retry = w.options.Retries
+			continue
+		}
+		delay *= 2
+		err = w.insert(batch)
+	}
+	if err != nil {
+		w.log.Errorf("Insert %d CDRs failed: %v", len(batch), err)
+		if err := w.spill(batch); err != nil {
+			w.log.Errorf("Spill %d CDRs failed, records lost: %v", len(batch), err)
+		}
+		return
+	}
+	w.replay()
+}
+
+func (w *SQLWriter) insert(batch []*Record) error {
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := w.insertTx(tx, batch); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (w *SQLWriter) insertTx(tx *sql.Tx, batch []*Record) error {
+	placeholders := make([]string, 0, len(batch))
+	args := make([]interface{}, 0, len(batch)*len(columns))
+	for _, record := range batch {
+		values := make([]string, len(columns))
+		for i := range columns {
+			if w.options.Dialect == Postgres {
+				values[i] = fmt.Sprintf("$%d", len(args)+i+1)
+			} else {
+				values[i] = "?"
+			}
+		}
+		placeholders = append(placeholders, "("+strings.Join(values, ", ")+")")
+		args = append(args,
+			record.CallID, record.CalleeCallID, record.Caller, record.Called, record.Source, record.Destination,
+			record.Setup, nullTime(record.Ringing), nullTime(record.EarlyMedia), nullTime(record.Answered), record.Ended,
+			record.Duration.Milliseconds(), record.EarlyMediaDuration.Milliseconds(),
+			string(record.Disposition), record.StatusCode, record.Reason,
+			record.MediaSecurity, record.MediaEncryption, record.OnNet, record.PostDialDelay.Milliseconds(),
+			record.CorrelationID, string(record.Cause), record.Emergency, record.Location,
+		)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+		w.options.Table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	_, err := tx.Exec(query, args...)
+	return err
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// Purge remove the records of the calls ended before, stored or spilled.
+func (w *SQLWriter) Purge(before time.Time) (int64, error) {
+	return w.remove(func(record *Record) bool { return record.Ended.Before(before) },
+		"ended < "+w.placeholder(1), before)
+}
+
+// Erase remove the records of the calls of user, the user part of the AOR of the caller or the called,
+// stored or spilled.
+func (w *SQLWriter) Erase(user string) (int64, error) {
+	// sip:user@host and sips:user@host, tel:user with or without parameters, and the records of the user
+	// part only written before the AORs.
+	escaped := likeEscaper.Replace(user)
+	values := []string{"%:" + escaped + "@%", "tel:" + escaped + ";%", "tel:" + user, user}
+	conditions := []string{}
+	args := []interface{}{}
+	for _, column := range []string{"caller", "called"} {
+		for idx, value := range values {
+			operator := "= " + w.placeholder(len(args)+1)
+			if idx < 2 {
+				operator = "LIKE " + w.placeholder(len(args)+1) + " ESCAPE '!'"
+			}
+			conditions = append(conditions, column+" "+operator)
+			args = append(args, value)
+		}
+	}
+	return w.remove(func(record *Record) bool { return record.isUserOf(user) }, strings.Join(conditions, " OR "), args...)
+}
+
+// likeEscaper escape the wildcards of a LIKE pattern with '!'.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+func (w *SQLWriter) placeholder(n int) string {
+	if w.options.Dialect == Postgres {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+// remove the records matching the condition from the table and the ones matching match from the spill
+// files. It runs between the batches, so that a record being inserted or replayed isn't missed.
+func (w *SQLWriter) remove(match func(record *Record) bool, condition string, args ...interface{}) (int64, error) {
+	var removed int64
+	var err error
+	done := make(chan struct{})
+	job := func() {
+		defer close(done)
+		var result sql.Result
+		result, err = w.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", w.options.Table, condition), args...)
+		if err != nil {
+			return
+		}
+		removed, _ = result.RowsAffected()
+		var spilled int64
+		spilled, err = w.removeSpilled(match)
+		removed += spilled
+	}
+	select {
+	case w.jobs <- job:
+	case <-w.done:
+		return 0, ErrClosed
+	}
+	<-done
+	return removed, err
+}
+
+// removeSpilled rewrite the spill files without the records matching match.
+func (w *SQLWriter) removeSpilled(match func(record *Record) bool) (int64, error) {
+	if len(w.options.SpillDir) == 0 {
+		return 0, nil
+	}
+	w.spillLock.Lock()
+	defer w.spillLock.Unlock()
+	spilled, _ := filepath.Glob(filepath.Join(w.options.SpillDir, spillPrefix+"*"+spillSuffix))
+	replaying, _ := filepath.Glob(filepath.Join(w.options.SpillDir, spillPrefix+"*"+replaySuffix))
+	var removed int64
+	for _, path := range append(spilled, replaying...) {
+		records, err := w.readSpill(path)
+		if err != nil {
+			return removed, err
+		}
+		kept := records[:0]
+		for _, record := range records {
+			if !match(record) {
+				kept = append(kept, record)
+			}
+		}
+		if len(kept) == len(records) {
+			continue
+		}
+		removed += int64(len(records) - len(kept))
+		if err := writeSpill(path, kept); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// readSpill the records of a spill file, the corrupted ones are skipped.
+func (w *SQLWriter) readSpill(path string) ([]*Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records := []*Record{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		record := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			w.log.Errorf("Skip corrupted CDR in %s: %v", path, err)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// writeSpill replace the spill file at path by the records, atomically.
+func writeSpill(path string, records []*Record) error {
+	if len(records) == 0 {
+		return os.Remove(path)
+	}
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// spill append the records to the spill file of the day.
+func (w *SQLWriter) spill(records []*Record) error {
+	if len(w.options.SpillDir) == 0 {
+		return fmt.Errorf("no spill directory")
+	}
+	w.spillLock.Lock()
+	defer w.spillLock.Unlock()
+	path := filepath.Join(w.options.SpillDir, spillPrefix+time.Now().Format("20060102")+spillSuffix)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// replay insert the spilled records, the files are removed once inserted.
+func (w *SQLWriter) replay() {
+	if len(w.options.SpillDir) == 0 {
+		return
+	}
+	w.spillLock.Lock()
+	paths, _ := filepath.Glob(filepath.Join(w.options.SpillDir, spillPrefix+"*"+spillSuffix))
+	for _, path := range paths {
+		// New spills go to a new file while this one is replayed,
+		// the suffix keeps the file apart from a previous failed replay.
+		os.Rename(path, fmt.Sprintf("%s.%d%s", path, time.Now().UnixNano(), replaySuffix))
+	}
+	w.spillLock.Unlock()
+
+	replaying, _ := filepath.Glob(filepath.Join(w.options.SpillDir, spillPrefix+"*"+replaySuffix))
+	sort.Strings(replaying)
+
+	for _, path := range replaying {
+		if err := w.replayFile(path); err != nil {
+			w.log.Warnf("Replay %s failed: %v", path, err)
+			return
+		}
+		os.Remove(path)
+		w.log.Infof("Replayed %s", path)
+	}
+}
+
+// replayFile insert the records of a spill file in a single transaction,
+// so that a failed replay doesn't duplicate records.
+func (w *SQLWriter) replayFile(path string) error {
+	records, err := w.readSpill(path)
+	if err != nil {
+		return err
+	}
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(records); start += w.options.BatchSize {
+		end := start + w.options.BatchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		if err := w.insertTx(tx, records[start:end]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
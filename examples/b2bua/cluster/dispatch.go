@@ -0,0 +1,130 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// Ring spreads the calls over the instances by rendezvous hashing, adding or removing an instance
+// only moves the calls it gains or loses.
+type Ring struct {
+	mutex sync.RWMutex
+	nodes []string
+}
+
+// NewRing .
+func NewRing(nodes ...string) *Ring {
+	r := &Ring{}
+	for _, node := range nodes {
+		r.Add(node)
+	}
+	return r
+}
+
+// Add .
+func (r *Ring) Add(node string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, n := range r.nodes {
+		if n == node {
+			return
+		}
+	}
+	r.nodes = append(r.nodes, node)
+	sort.Strings(r.nodes)
+}
+
+// Remove .
+func (r *Ring) Remove(node string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i, n := range r.nodes {
+		if n == node {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// Nodes .
+func (r *Ring) Nodes() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return append([]string{}, r.nodes...)
+}
+
+// Has .
+func (r *Ring) Has(node string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, n := range r.nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// Node the instance of key, "" if the ring is empty.
+func (r *Ring) Node(key string) string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	best, bestScore := "", uint64(0)
+	for _, node := range r.nodes {
+		h := fnv.New64a()
+		h.Write([]byte(node))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := h.Sum64(); len(best) == 0 || score > bestScore {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
+
+// AffinityKey the key keeping all the messages of a call on the same instance, its Call-ID.
+func AffinityKey(msg sip.Message) string {
+	if callID, ok := msg.CallID(); ok {
+		return callID.Value()
+	}
+	return ""
+}
+
+// BranchKey the key of the transaction of the message, the branch of its top Via, e.g. to
+// dispatch the CANCEL and the ACK of a non 2xx response with their INVITE.
+func BranchKey(msg sip.Message) string {
+	if viaHop, ok := msg.ViaHop(); ok && viaHop.Params != nil {
+		if branch, ok := viaHop.Params.Get("branch"); ok && branch != nil {
+			return branch.String()
+		}
+	}
+	return ""
+}
+
+// InstanceOf the instance a message belongs to, see stack.InstanceOf.
+func InstanceOf(msg sip.Message) (string, bool) {
+	return stack.InstanceOf(msg)
+}
+
+// Dispatcher picks the instance of the messages received by a SIP load balancer: the instance
+// named by the message if it is up, else the instance of its Call-ID on the ring.
+type Dispatcher struct {
+	Ring *Ring
+}
+
+// NewDispatcher .
+func NewDispatcher(nodes ...string) *Dispatcher {
+	return &Dispatcher{Ring: NewRing(nodes...)}
+}
+
+// Dispatch the instance of msg, "" if no instance is up.
+func (d *Dispatcher) Dispatch(msg sip.Message) string {
+	if node, ok := InstanceOf(msg); ok && d.Ring.Has(node) {
+		return node
+	}
+	return d.Ring.Node(AffinityKey(msg))
+}
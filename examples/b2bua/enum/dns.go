@@ -0,0 +1,216 @@
+package enum
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	typeNAPTR = 35
+	classIN   = 1
+	// rcodeNXDomain the domain doesn't exist, a number without records.
+	rcodeNXDomain = 3
+)
+
+var errMalformed = errors.New("enum: malformed DNS answer")
+
+// naptr a NAPTR record, RFC 3403.
+type naptr struct {
+	order      uint16
+	preference uint16
+	flags      string
+	services   string
+	regexp     string
+}
+
+// exchange query the NAPTR records of name over UDP, over TCP if the answer is truncated, returns them
+// and their lowest TTL, none if name doesn't exist.
+func exchange(ctx context.Context, server string, name string) ([]naptr, time.Duration, error) {
+	// A random ID, the answers being matched by it.
+	random := make([]byte, 2)
+	if _, err := rand.Read(random); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(random)
+	query, err := packQuery(id, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	answer, err := exchangeUDP(ctx, server, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(answer) >= 4 && answer[2]&0x02 != 0 {
+		// Truncated, RFC 7766.
+		if answer, err = exchangeTCP(ctx, server, query); err != nil {
+			return nil, 0, err
+		}
+	}
+	return parseAnswer(id, answer)
+}
+
+func exchangeUDP(ctx context.Context, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Skip the stray answers of other queries.
+		if n >= 2 && binary.BigEndian.Uint16(buf) == binary.BigEndian.Uint16(query) {
+			return buf[:n], nil
+		}
+	}
+}
+
+func exchangeTCP(ctx context.Context, server string, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(conn, length); err != nil {
+		return nil, err
+	}
+	answer := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// packQuery the recursive NAPTR query of name.
+func packQuery(id uint16, name string) ([]byte, error) {
+	msg := make([]byte, 12, 12+len(name)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	msg[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	for _, label := range strings.Split(strings.Trim(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("enum: bad domain %s", name)
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	msg = append(msg, 0, 0, typeNAPTR, 0, classIN)
+	return msg, nil
+}
+
+// parseAnswer the NAPTR records of the answer msg to the query id.
+func parseAnswer(id uint16, msg []byte) ([]naptr, time.Duration, error) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg) != id {
+		return nil, 0, errMalformed
+	}
+	switch rcode := msg[3] & 0x0f; rcode {
+	case 0:
+	case rcodeNXDomain:
+		return nil, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("enum: DNS error %d", rcode)
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+	offset := 12
+	var err error
+	for i := 0; i < questions; i++ {
+		if offset, err = skipName(msg, offset); err != nil {
+			return nil, 0, err
+		}
+		offset += 4
+	}
+	records := []naptr{}
+	ttl := time.Duration(0)
+	for i := 0; i < answers; i++ {
+		if offset, err = skipName(msg, offset); err != nil {
+			return nil, 0, err
+		}
+		if offset+10 > len(msg) {
+			return nil, 0, errMalformed
+		}
+		rrType := binary.BigEndian.Uint16(msg[offset:])
+		rrTTL := time.Duration(binary.BigEndian.Uint32(msg[offset+4:])) * time.Second
+		length := int(binary.BigEndian.Uint16(msg[offset+8:]))
+		offset += 10
+		if offset+length > len(msg) {
+			return nil, 0, errMalformed
+		}
+		if rrType == typeNAPTR {
+			record, err := parseNAPTR(msg[offset : offset+length])
+			if err != nil {
+				return nil, 0, err
+			}
+			records = append(records, record)
+			if ttl == 0 || rrTTL < ttl {
+				ttl = rrTTL
+			}
+		}
+		offset += length
+	}
+	return records, ttl, nil
+}
+
+// parseNAPTR the rdata of a NAPTR record, its replacement is ignored, only the terminal records are used.
+func parseNAPTR(rdata []byte) (naptr, error) {
+	if len(rdata) < 4 {
+		return naptr{}, errMalformed
+	}
+	record := naptr{order: binary.BigEndian.Uint16(rdata), preference: binary.BigEndian.Uint16(rdata[2:])}
+	offset := 4
+	for _, field := range []*string{&record.flags, &record.services, &record.regexp} {
+		if offset >= len(rdata) || offset+1+int(rdata[offset]) > len(rdata) {
+			return naptr{}, errMalformed
+		}
+		length := int(rdata[offset])
+		*field = string(rdata[offset+1 : offset+1+length])
+		offset += 1 + length
+	}
+	return record, nil
+}
+
+// skipName the offset after the name at offset, compressed or not.
+func skipName(msg []byte, offset int) (int, error) {
+	for {
+		if offset >= len(msg) {
+			return 0, errMalformed
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			// A pointer ends the name.
+			return offset + 2, nil
+		}
+		offset += 1 + length
+	}
+}
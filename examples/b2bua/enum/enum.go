@@ -0,0 +1,227 @@
+package enum
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultZone of the public ENUM tree, RFC 6116.
+	DefaultZone = "e164.arpa"
+	// DefaultTimeout of a lookup, all the zones included.
+	DefaultTimeout = 2 * time.Second
+	// DefaultMaxTTL the answers are cached at most.
+	DefaultMaxTTL = time.Hour
+	// DefaultNegativeTTL the numbers without SIP URI are cached.
+	DefaultNegativeTTL = 5 * time.Minute
+)
+
+var (
+	// ErrNotFound the number has no SIP URI in the zones.
+	ErrNotFound = errors.New("enum: no SIP URI")
+	// e164 the numbers looked up, +4420794600.
+	e164 = regexp.MustCompile(`^\+[0-9]{1,15}$`)
+	// backReference \1 to \9 in the replacements of the NAPTR regexps.
+	backReference = regexp.MustCompile(`\\([0-9])`)
+)
+
+// entry a cached answer, uri empty for a number without SIP URI.
+type entry struct {
+	uri     string
+	expires time.Time
+}
+
+// Resolver translates E.164 numbers to SIP URIs by the NAPTR records of the E2U+sip service in the ENUM
+// zones, tried in order, RFC 6116. The answers are cached for their TTL, at most MaxTTL, the numbers
+// without SIP URI for NegativeTTL.
+type Resolver struct {
+	Zones []string
+	// Server the DNS server, host:port, the first nameserver of /etc/resolv.conf if empty.
+	Server      string
+	Timeout     time.Duration
+	MaxTTL      time.Duration
+	NegativeTTL time.Duration
+	mutex       sync.Mutex
+	cache       map[string]entry
+}
+
+// NewResolver a resolver of the zones, DefaultZone if none.
+func NewResolver(server string, zones ...string) *Resolver {
+	if len(zones) == 0 {
+		zones = []string{DefaultZone}
+	}
+	return &Resolver{
+		Zones:       zones,
+		Server:      server,
+		Timeout:     DefaultTimeout,
+		MaxTTL:      DefaultMaxTTL,
+		NegativeTTL: DefaultNegativeTTL,
+		cache:       make(map[string]entry),
+	}
+}
+
+// IsE164 whether number is an E.164 number ENUM applies to.
+func IsE164(number string) bool {
+	return e164.MatchString(number)
+}
+
+// Domain the ENUM domain of number in zone, +44207946 in e164.arpa being 6.4.9.7.0.2.4.4.e164.arpa.
+func Domain(number string, zone string) string {
+	digits := strings.TrimPrefix(number, "+")
+	labels := make([]string, 0, len(digits)+1)
+	for i := len(digits) - 1; i >= 0; i-- {
+		labels = append(labels, digits[i:i+1])
+	}
+	return strings.Join(append(labels, strings.Trim(zone, ".")), ".")
+}
+
+// Lookup the SIP URI of number, ErrNotFound if it has none.
+func (r *Resolver) Lookup(ctx context.Context, number string) (string, error) {
+	if !IsE164(number) {
+		return "", ErrNotFound
+	}
+	now := time.Now()
+	r.mutex.Lock()
+	cached, found := r.cache[number]
+	r.mutex.Unlock()
+	if found && now.Before(cached.expires) {
+		if len(cached.uri) == 0 {
+			return "", ErrNotFound
+		}
+		return cached.uri, nil
+	}
+
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	server, err := r.server()
+	if err != nil {
+		return "", err
+	}
+	for _, zone := range r.Zones {
+		records, ttl, err := exchange(ctx, server, Domain(number, zone))
+		if err != nil {
+			// A failure isn't cached, the next call tries again.
+			return "", err
+		}
+		if uri, found := sipURI(records, number); found {
+			if r.MaxTTL > 0 && ttl > r.MaxTTL {
+				ttl = r.MaxTTL
+			}
+			r.store(number, uri, now.Add(ttl))
+			return uri, nil
+		}
+	}
+	r.store(number, "", now.Add(r.NegativeTTL))
+	return "", ErrNotFound
+}
+
+func (r *Resolver) store(number string, uri string, expires time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.cache == nil {
+		r.cache = make(map[string]entry)
+	}
+	now := time.Now()
+	if len(r.cache) >= 10000 {
+		// Forget the expired answers rather than growing forever.
+		for number, cached := range r.cache {
+			if now.After(cached.expires) {
+				delete(r.cache, number)
+			}
+		}
+	}
+	r.cache[number] = entry{uri: uri, expires: expires}
+}
+
+// server the DNS server of the lookups.
+func (r *Resolver) server() (string, error) {
+	if len(r.Server) > 0 {
+		if _, _, err := net.SplitHostPort(r.Server); err != nil {
+			return net.JoinHostPort(r.Server, "53"), nil
+		}
+		return r.Server, nil
+	}
+	data, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf("enum: no DNS server: %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", fmt.Errorf("enum: no nameserver in /etc/resolv.conf")
+}
+
+// sipURI the SIP URI of the best terminal E2U+sip record, by order then preference, applied to number.
+func sipURI(records []naptr, number string) (string, bool) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].order != records[j].order {
+			return records[i].order < records[j].order
+		}
+		return records[i].preference < records[j].preference
+	})
+	for _, record := range records {
+		if !strings.EqualFold(record.flags, "u") || !isSIPService(record.services) {
+			continue
+		}
+		if uri, ok := substitute(record.regexp, number); ok && strings.HasPrefix(strings.ToLower(uri), "sip") {
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+// isSIPService whether services, e.g. E2U+sip or E2U+voice:sip, include SIP.
+func isSIPService(services string) bool {
+	services = strings.ToLower(services)
+	if !strings.HasPrefix(services, "e2u+") {
+		return false
+	}
+	for _, service := range strings.Split(strings.TrimPrefix(services, "e2u+"), "+") {
+		if service == "sip" || strings.HasSuffix(service, ":sip") {
+			return true
+		}
+	}
+	return false
+}
+
+// substitute the NAPTR regexp, !pattern!replacement!flags, applied to number.
+func substitute(expression string, number string) (string, bool) {
+	if len(expression) < 3 {
+		return "", false
+	}
+	delimiter := expression[:1]
+	parts := strings.Split(expression[1:], delimiter)
+	if len(parts) != 3 {
+		return "", false
+	}
+	pattern := parts[0]
+	if parts[2] == "i" {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", false
+	}
+	match := re.FindStringSubmatchIndex(number)
+	if match == nil {
+		return "", false
+	}
+	// The back-references are the ${1} to ${9} of Go.
+	template := backReference.ReplaceAllString(parts[1], "$${$1}")
+	return string(re.ExpandString(nil, template, number, match)), true
+}
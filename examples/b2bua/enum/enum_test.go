@@ -0,0 +1,140 @@
+package enum
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDomain(t *testing.T) {
+	if got := Domain("+44207946", "e164.arpa."); got != "6.4.9.7.0.2.4.4.e164.arpa" {
+		t.Errorf("Domain = %s", got)
+	}
+}
+
+func TestSIPURI(t *testing.T) {
+	records := []naptr{
+		{order: 100, preference: 10, flags: "u", services: "E2U+sip", regexp: "!^.*$!sip:fallback@example.com!"},
+		{order: 10, preference: 20, flags: "u", services: "E2U+voice:sip", regexp: `!^\+44(.*)$!sip:\1@uk.example.com!`},
+		{order: 10, preference: 10, flags: "u", services: "E2U+email:mailto", regexp: "!^.*$!mailto:a@example.com!"},
+		{order: 10, preference: 30, flags: "", services: "E2U+sip", regexp: "!^.*$!sip:nonterminal@example.com!"},
+	}
+	for _, test := range []struct {
+		number, uri string
+		found       bool
+	}{
+		{"+44207946", "sip:207946@uk.example.com", true},
+		{"+33123456", "sip:fallback@example.com", true},
+	} {
+		uri, found := sipURI(append([]naptr{}, records...), test.number)
+		if found != test.found || uri != test.uri {
+			t.Errorf("sipURI(%s) = %s, %v, want %s", test.number, uri, found, test.uri)
+		}
+	}
+	if _, found := sipURI(records[2:3], "+44207946"); found {
+		t.Error("mailto record used")
+	}
+}
+
+// fakeDNS answers the NAPTR queries of the domains in records over UDP, NXDOMAIN for the others.
+func fakeDNS(t *testing.T, records map[string][]naptr, ttl uint32) (net.PacketConn, *int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := new(int32)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(queries, 1)
+			query := buf[:n]
+			labels := []string{}
+			offset := 12
+			for query[offset] != 0 {
+				length := int(query[offset])
+				labels = append(labels, string(query[offset+1:offset+1+length]))
+				offset += 1 + length
+			}
+			question := query[12 : offset+5]
+			answer := append([]byte{}, query[:12]...)
+			answer[2] |= 0x80
+			binary.BigEndian.PutUint16(answer[6:], 0)
+			found, ok := records[strings.Join(labels, ".")]
+			if !ok {
+				answer[3] |= rcodeNXDomain
+			}
+			answer = append(answer, question...)
+			for _, record := range found {
+				rdata := make([]byte, 4)
+				binary.BigEndian.PutUint16(rdata, record.order)
+				binary.BigEndian.PutUint16(rdata[2:], record.preference)
+				for _, field := range []string{record.flags, record.services, record.regexp} {
+					rdata = append(append(rdata, byte(len(field))), field...)
+				}
+				// The replacement, the root.
+				rdata = append(rdata, 0)
+				rr := []byte{0xc0, 12, 0, typeNAPTR, 0, classIN, 0, 0, 0, 0, 0, 0}
+				binary.BigEndian.PutUint32(rr[6:], ttl)
+				binary.BigEndian.PutUint16(rr[10:], uint16(len(rdata)))
+				answer = append(append(answer, rr...), rdata...)
+				binary.BigEndian.PutUint16(answer[6:], binary.BigEndian.Uint16(answer[6:])+1)
+			}
+			conn.WriteTo(answer, addr)
+		}
+	}()
+	return conn, queries
+}
+
+func TestLookup(t *testing.T) {
+	conn, queries := fakeDNS(t, map[string][]naptr{
+		Domain("+44207946", "e164.example.net"): {
+			{order: 10, preference: 10, flags: "u", services: "E2U+sip", regexp: `!^\+(.*)$!sip:\1@gw.example.com!`},
+		},
+	}, 60)
+	defer conn.Close()
+	server := conn.LocalAddr().String()
+	resolver := NewResolver(server, "e164.arpa", "e164.example.net")
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		uri, err := resolver.Lookup(ctx, "+44207946")
+		if err != nil || uri != "sip:44207946@gw.example.com" {
+			t.Fatalf("Lookup = %s, %v", uri, err)
+		}
+	}
+	// Both zones tried once, the second lookup answered from the cache.
+	if n := atomic.LoadInt32(queries); n != 2 {
+		t.Errorf("%d queries, want 2", n)
+	}
+
+	if _, err := resolver.Lookup(ctx, "+33123456"); err != ErrNotFound {
+		t.Errorf("Lookup of a number without record: %v", err)
+	}
+	if _, err := resolver.Lookup(ctx, "+33123456"); err != ErrNotFound {
+		t.Errorf("cached Lookup of a number without record: %v", err)
+	}
+	if n := atomic.LoadInt32(queries); n != 4 {
+		t.Errorf("%d queries, want 4, the negative answer cached", n)
+	}
+	if _, err := resolver.Lookup(ctx, "0207946"); err != ErrNotFound {
+		t.Errorf("Lookup of a national number: %v", err)
+	}
+
+	// The answers are cached MaxTTL at most.
+	resolver = NewResolver(server, "e164.example.net")
+	resolver.MaxTTL = time.Nanosecond
+	resolver.Lookup(ctx, "+44207946")
+	time.Sleep(time.Millisecond)
+	resolver.Lookup(ctx, "+44207946")
+	if n := atomic.LoadInt32(queries); n != 6 {
+		t.Errorf("%d queries, want 6, the answer expired", n)
+	}
+}
@@ -0,0 +1,172 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+)
+
+// Type of an event.
+type Type string
+
+const (
+	// CallStarted a new call was routed.
+	CallStarted Type = "call.started"
+	// CallAnswered the callee answered.
+	CallAnswered Type = "call.answered"
+	// CallEnded the call was released, answered or not.
+	CallEnded Type = "call.ended"
+	// CallTransferred the call was transferred by a REFER to a new B-Leg.
+	CallTransferred Type = "call.transferred"
+	// Registered a contact was registered or refreshed.
+	Registered Type = "registration.registered"
+	// Unregistered a contact was removed.
+	Unregistered Type = "registration.unregistered"
+	// Expired a contact wasn't refreshed before its expiry.
+	Expired Type = "registration.expired"
+	// TrunkDegraded a trunk crossed the failure thresholds of the circuit breaker, it's skipped by the routing.
+	TrunkDegraded Type = "trunk.degraded"
+	// TrunkRestored a degraded trunk answered the probes again.
+	TrunkRestored Type = "trunk.restored"
+	// CallSlowProgress the post-dial delay of a call exceeded its threshold.
+	CallSlowProgress Type = "call.slow_progress"
+)
+
+// Event a call or registration event.
+type Event struct {
+	Type Type      `json:"type"`
+	Time time.Time `json:"time"`
+	// Tenant domain of the called party or of the registered AOR.
+	Tenant string `json:"tenant,omitempty"`
+
+	// CallID of the caller leg, CorrelationID of the call, the prefix of the Call-ID of the callee leg.
+	CallID        string `json:"call_id,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	Caller        string `json:"caller,omitempty"`
+	Called        string `json:"called,omitempty"`
+	// Source address of the caller or of the REGISTER, Destination address of the callee.
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	// StatusCode and Reason of the final response of a failed call.
+	StatusCode int    `json:"status_code,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	// Duration billable time of an ended call.
+	Duration time.Duration `json:"duration,omitempty"`
+
+	AOR     string `json:"aor,omitempty"`
+	Contact string `json:"contact,omitempty"`
+	Expires uint32 `json:"expires,omitempty"`
+
+	// Trunk name of a degraded or restored trunk, the Reason tells why, or of the trunk of a slow call.
+	Trunk string `json:"trunk,omitempty"`
+	// PostDialDelay of a slow call.
+	PostDialDelay time.Duration `json:"post_dial_delay,omitempty"`
+}
+
+// Publisher a sink of the events, e.g. a message bus.
+type Publisher interface {
+	Publish(event *Event) error
+	// Close flush the pending events.
+	Close() error
+}
+
+// Bus dispatches the events to the subscribed publishers, each publisher has its own queue
+// so that a slow message bus doesn't delay the signaling nor the other publishers.
+type Bus struct {
+	queueSize   int
+	subscribers []*subscriber
+	lock        sync.RWMutex
+	closed      bool
+}
+
+var (
+	logger log.Logger
+)
+
+func init() {
+	logger = utils.NewLogrusLogger(log.InfoLevel, "Events", nil)
+}
+
+type subscriber struct {
+	publisher Publisher
+	events    chan *Event
+	done      chan struct{}
+}
+
+// NewBus queueSize events buffered per publisher before new events are dropped, 10000 if 0.
+func NewBus(queueSize int) *Bus {
+	if queueSize <= 0 {
+		queueSize = 10000
+	}
+	return &Bus{
+		queueSize: queueSize,
+	}
+}
+
+// Subscribe add a publisher.
+func (b *Bus) Subscribe(publisher Publisher) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		return
+	}
+	s := &subscriber{
+		publisher: publisher,
+		events:    make(chan *Event, b.queueSize),
+		done:      make(chan struct{}),
+	}
+	b.subscribers = append(b.subscribers, s)
+	go b.run(s)
+}
+
+// Publish queue the event to every publisher, it's dropped for the publishers which are behind.
+func (b *Bus) Publish(event *Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if b.closed {
+		return
+	}
+	for _, s := range b.subscribers {
+		select {
+		case s.events <- event:
+		default:
+			logger.Warnf("Event queue full, drop %s %s", event.Type, event.CallID+event.AOR)
+		}
+	}
+}
+
+// Close deliver the queued events and close the publishers.
+func (b *Bus) Close() {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return
+	}
+	b.closed = true
+	subscribers := b.subscribers
+	for _, s := range subscribers {
+		close(s.events)
+	}
+	b.lock.Unlock()
+
+	for _, s := range subscribers {
+		<-s.done
+		if err := s.publisher.Close(); err != nil {
+			logger.Errorf("Close publisher failed: %v", err)
+		}
+	}
+}
+
+func (b *Bus) run(s *subscriber) {
+	defer close(s.done)
+	for event := range s.events {
+		if err := s.publisher.Publish(event); err != nil {
+			logger.Errorf("Publish %s failed: %v", event.Type, err)
+		}
+	}
+}
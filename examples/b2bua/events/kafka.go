@@ -0,0 +1,57 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaPublisher publishes the events on Kafka topics, the key selects the partition.
+// The messages are written asynchronously in batches, the failures are logged.
+type KafkaPublisher struct {
+	writer  *kafka.Writer
+	options PublisherOptions
+}
+
+// NewKafkaPublisher .
+func NewKafkaPublisher(brokers []string, options PublisherOptions) *KafkaPublisher {
+	options.setDefaults()
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 100 * time.Millisecond,
+			RequiredAcks: kafka.RequireOne,
+			Async:        true,
+			Completion: func(messages []kafka.Message, err error) {
+				if err != nil {
+					logger.Errorf("Write %d events to Kafka failed: %v", len(messages), err)
+				}
+			},
+		},
+		options: options,
+	}
+}
+
+// Publish .
+func (p *KafkaPublisher) Publish(event *Event) error {
+	data, err := p.options.Serializer.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.writer.WriteMessages(context.Background(), kafka.Message{
+		Topic: p.options.topic(event),
+		Key:   []byte(p.options.Key(event)),
+		Value: data,
+		Headers: []kafka.Header{
+			{Key: "content-type", Value: []byte(p.options.Serializer.ContentType())},
+		},
+		Time: event.Time,
+	})
+}
+
+// Close .
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,46 @@
+package events
+
+import (
+	"github.com/nats-io/nats.go"
+)
+
+// NATSPublisher publishes the events on NATS subjects, the key and the content type are
+// sent as headers when the server supports them.
+type NATSPublisher struct {
+	conn    *nats.Conn
+	options PublisherOptions
+	headers bool
+}
+
+// NewNATSPublisher the topics are the subjects.
+func NewNATSPublisher(conn *nats.Conn, options PublisherOptions) *NATSPublisher {
+	options.setDefaults()
+	return &NATSPublisher{
+		conn:    conn,
+		options: options,
+		headers: conn.HeadersSupported(),
+	}
+}
+
+// Publish .
+func (p *NATSPublisher) Publish(event *Event) error {
+	data, err := p.options.Serializer.Marshal(event)
+	if err != nil {
+		return err
+	}
+	msg := &nats.Msg{
+		Subject: p.options.topic(event),
+		Data:    data,
+	}
+	if p.headers {
+		msg.Header = nats.Header{}
+		msg.Header.Set("Content-Type", p.options.Serializer.ContentType())
+		msg.Header.Set("Key", p.options.Key(event))
+	}
+	return p.conn.PublishMsg(msg)
+}
+
+// Close flush the pending messages, the connection is left open.
+func (p *NATSPublisher) Close() error {
+	return p.conn.Flush()
+}
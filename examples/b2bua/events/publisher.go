@@ -0,0 +1,58 @@
+package events
+
+import (
+	"strings"
+)
+
+// KeyFunc the message key of an event, the events with the same key keep their order.
+type KeyFunc func(event *Event) string
+
+var (
+	// KeyByCallID the Call-ID of the call events, the AOR of the registration events.
+	KeyByCallID KeyFunc = func(event *Event) string {
+		if len(event.CallID) > 0 {
+			return event.CallID
+		}
+		return event.AOR
+	}
+	// KeyByTenant the tenant of the event.
+	KeyByTenant KeyFunc = func(event *Event) string {
+		return event.Tenant
+	}
+)
+
+// PublisherOptions common options of the message bus publishers.
+type PublisherOptions struct {
+	// Topic of the events, "sip-events" by default.
+	Topic string
+	// Topics per event type or category ("call", "registration"), override Topic.
+	Topics map[string]string
+	// Key of the messages, KeyByCallID by default.
+	Key KeyFunc
+	// Serializer JSON by default.
+	Serializer Serializer
+}
+
+func (o *PublisherOptions) setDefaults() {
+	if len(o.Topic) == 0 {
+		o.Topic = "sip-events"
+	}
+	if o.Key == nil {
+		o.Key = KeyByCallID
+	}
+	if o.Serializer == nil {
+		o.Serializer = JSON
+	}
+}
+
+// topic of the event, the most specific match.
+func (o *PublisherOptions) topic(event *Event) string {
+	if topic, found := o.Topics[string(event.Type)]; found {
+		return topic
+	}
+	category := strings.SplitN(string(event.Type), ".", 2)[0]
+	if topic, found := o.Topics[category]; found {
+		return topic
+	}
+	return o.Topic
+}
@@ -0,0 +1,72 @@
+package events
+
+import (
+	"encoding/json"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Serializer encodes the events published on a message bus.
+type Serializer interface {
+	Marshal(event *Event) ([]byte, error)
+	ContentType() string
+}
+
+var (
+	// JSON serializer, the default.
+	JSON Serializer = jsonSerializer{}
+	// Protobuf serializer, see event.proto for the schema.
+	Protobuf Serializer = protobufSerializer{}
+)
+
+type jsonSerializer struct{}
+
+func (jsonSerializer) Marshal(event *Event) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+func (jsonSerializer) ContentType() string {
+	return "application/json"
+}
+
+// protobufSerializer encodes the Event message of event.proto,
+// the fields are written by hand to avoid generated code.
+type protobufSerializer struct{}
+
+func (protobufSerializer) Marshal(event *Event) ([]byte, error) {
+	b := make([]byte, 0, 256)
+	appendString := func(num protowire.Number, s string) {
+		if len(s) > 0 {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, s)
+		}
+	}
+	appendVarint := func(num protowire.Number, v uint64) {
+		if v != 0 {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, v)
+		}
+	}
+	appendString(1, string(event.Type))
+	appendVarint(2, uint64(event.Time.UnixNano()))
+	appendString(3, event.Tenant)
+	appendString(4, event.CallID)
+	appendString(5, event.Caller)
+	appendString(6, event.Called)
+	appendString(7, event.Source)
+	appendString(8, event.Destination)
+	appendVarint(9, uint64(event.StatusCode))
+	appendString(10, event.Reason)
+	appendVarint(11, uint64(event.Duration.Milliseconds()))
+	appendString(12, event.AOR)
+	appendString(13, event.Contact)
+	appendVarint(14, uint64(event.Expires))
+	appendString(15, event.Trunk)
+	appendVarint(16, uint64(event.PostDialDelay.Milliseconds()))
+	appendString(17, event.CorrelationID)
+	return b, nil
+}
+
+func (protobufSerializer) ContentType() string {
+	return "application/x-protobuf"
+}
@@ -0,0 +1,203 @@
+package ha
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/b2bua"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+func newInstance(t *testing.T, contact string, source string) *registry.ContactInstance {
+	displayName, uri, params, err := parser.ParseAddressValue(contact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &registry.ContactInstance{
+		Contact:    &sip.ContactHeader{DisplayName: displayName, Address: uri, Params: params},
+		RegExpires: 3600,
+		Source:     source,
+		Transport:  "udp",
+	}
+}
+
+func parseAor(t *testing.T, aor string) sip.Uri {
+	uri, err := parser.ParseSipUri(aor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &uri
+}
+
+func change(t *testing.T, update *bindingChange) json.RawMessage {
+	data, err := json.Marshal(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// freeAddr a loopback address nobody listens on.
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegistryApply(t *testing.T) {
+	inner := registry.NewMemoryRegistry()
+	r := NewRegistry(inner, NewNode(Options{ID: "b"}))
+	aor := parseAor(t, "sip:100@example.com")
+	first := newInstance(t, "<sip:100@192.0.2.1:5060>", "192.0.2.1:5060")
+	second := newInstance(t, "<sip:100@192.0.2.2:5060>", "192.0.2.2:5060")
+
+	r.apply(change(t, &bindingChange{Op: bindingAdd, Binding: registry.NewBinding(aor, first)}))
+	r.apply(change(t, &bindingChange{Op: bindingUpdate, Binding: registry.NewBinding(aor, second)}))
+	contacts, found := inner.GetContacts(aor)
+	if !found || len(*contacts) != 2 {
+		t.Fatalf("contacts = %v, want the 2 replicated bindings", contacts)
+	}
+	if instance := (*contacts)["192.0.2.1:5060"]; instance == nil || instance.Contact.Address.String() != "sip:100@192.0.2.1:5060" {
+		t.Errorf("binding of 192.0.2.1:5060 = %v", instance)
+	}
+
+	r.apply(change(t, &bindingChange{Op: bindingRemove, Binding: registry.NewBinding(aor, first)}))
+	if contacts, _ := inner.GetContacts(aor); contacts == nil || len(*contacts) != 1 {
+		t.Fatalf("contacts after remove = %v, want 1 binding", contacts)
+	}
+
+	// Invalid updates are dropped.
+	r.apply(json.RawMessage(`{"op":`))
+	r.apply(change(t, &bindingChange{Op: bindingAdd}))
+	r.apply(change(t, &bindingChange{Op: bindingAdd, Binding: &registry.Binding{AOR: "not an aor"}}))
+
+	r.apply(change(t, &bindingChange{Op: bindingRemoveAor, AOR: aor.String()}))
+	if inner.AorIsRegistered(aor) {
+		t.Error("aor still registered after remove-aor")
+	}
+}
+
+func TestStateStoreApply(t *testing.T) {
+	s := NewStateStore(NewNode(Options{ID: "b"}))
+	state := &b2bua.CallState{ID: "a84b4c76e66710", Record: &cdr.Record{CallID: "a84b4c76e66710"}}
+	data, _ := json.Marshal(state)
+	s.applySave(data)
+	// A call without a record can't be adopted.
+	s.applySave(json.RawMessage(`{"id":"no-record"}`))
+	states, _ := s.Load()
+	if len(states) != 1 || states[0].ID != state.ID || states[0].Record.CallID != state.ID {
+		t.Fatalf("states = %v, want the replicated call", states)
+	}
+
+	s.applyDelete(json.RawMessage(`42`))
+	if states, _ := s.Load(); len(states) != 1 {
+		t.Fatalf("%d states after an invalid call end, want 1", len(states))
+	}
+	s.applyDelete(json.RawMessage(`"a84b4c76e66710"`))
+	if states, _ := s.Load(); len(states) != 0 {
+		t.Errorf("%d states after the call end, want 0", len(states))
+	}
+}
+
+func TestPair(t *testing.T) {
+	addrA, addrB := freeAddr(t), freeAddr(t)
+	heartbeat := 20 * time.Millisecond
+	a := NewNode(Options{ID: "a", Listen: addrA, Peer: addrB, Secret: "s3cret", Priority: 2, Heartbeat: heartbeat})
+	b := NewNode(Options{ID: "b", Listen: addrB, Peer: addrA, Secret: "s3cret", Priority: 1, Heartbeat: heartbeat})
+	innerA, innerB := registry.NewMemoryRegistry(), registry.NewMemoryRegistry()
+	registryA, _ := NewRegistry(innerA, a), NewRegistry(innerB, b)
+	aor := parseAor(t, "sip:100@example.com")
+	// Registered before the election, b gets it with the whole state.
+	if err := registryA.AddAor(aor, newInstance(t, "<sip:100@192.0.2.1:5060>", "192.0.2.1:5060")); err != nil {
+		t.Fatal(err)
+	}
+
+	activated := make(chan struct{}, 1)
+	b.OnActive(func() { activated <- struct{}{} })
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+	if err := b.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Stop()
+
+	eventually(t, "the election", func() bool { return a.IsActive() && !b.IsActive() })
+	eventually(t, "the sync", func() bool { return innerB.AorIsRegistered(aor) })
+
+	other := parseAor(t, "sip:200@example.com")
+	if err := registryA.AddAor(other, newInstance(t, "<sip:200@192.0.2.2:5060>", "192.0.2.2:5060")); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the replication", func() bool { return innerB.AorIsRegistered(other) })
+	if err := registryA.RemoveAor(other); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the replicated removal", func() bool { return !innerB.AorIsRegistered(other) })
+
+	a.Stop()
+	select {
+	case <-activated:
+	case <-time.After(2 * time.Second):
+		t.Fatal("b didn't take over")
+	}
+	if !b.IsActive() || !innerB.AorIsRegistered(aor) {
+		t.Errorf("b is %v, registered %v, want active with the replicated binding", b.Role(), innerB.AorIsRegistered(aor))
+	}
+}
+
+func TestChannelSecret(t *testing.T) {
+	addr := freeAddr(t)
+	a := NewNode(Options{ID: "a", Listen: addr, Peer: freeAddr(t), Secret: "s3cret", Heartbeat: time.Hour})
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(conn)
+	if _, err := reader.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	encoder := json.NewEncoder(conn)
+	encoder.Encode(&message{Type: helloMessage, Data: json.RawMessage(`"0badc0de"`)})
+	encoder.Encode(&message{Type: heartbeatMessage, From: "intruder", Role: Active})
+	if _, err := reader.ReadByte(); err == nil {
+		t.Error("channel with a bad signature not closed")
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if len(a.peerID) > 0 {
+		t.Errorf("heartbeat of %s accepted", a.peerID)
+	}
+}
+
+func TestNoSecret(t *testing.T) {
+	if err := NewNode(Options{ID: "a", Listen: freeAddr(t)}).Start(); err != ErrNoSecret {
+		t.Errorf("started without a secret: %v", err)
+	}
+}
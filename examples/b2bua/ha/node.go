@@ -0,0 +1,416 @@
+package ha
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+)
+
+var (
+	logger log.Logger
+)
+
+func init() {
+	logger = utils.NewLogrusLogger(log.InfoLevel, "HA", nil)
+}
+
+// Role of a node of the pair.
+type Role int
+
+const (
+	// Standby the node receives the state of the active node and takes over when it fails.
+	Standby Role = iota
+	// Active the node serves the traffic and replicates its state.
+	Active
+)
+
+func (r Role) String() string {
+	if r == Active {
+		return "active"
+	}
+	return "standby"
+}
+
+const (
+	heartbeatMessage = "heartbeat"
+	// helloMessage the first message on the replication channel, proving the secret of the pair.
+	helloMessage = "hello"
+	dialTimeout  = 2 * time.Second
+	// challengeSize random bytes sent by the node accepting the channel, signed by the peer.
+	challengeSize = 32
+)
+
+// ErrNoSecret the node has no shared secret.
+var ErrNoSecret = errors.New("ha: no shared secret")
+
+// Options .
+type Options struct {
+	// ID of the node, unique in the pair.
+	ID string
+	// Listen address of the replication channel, Peer the one of the other node.
+	Listen string
+	Peer   string
+	// Secret shared by the nodes of the pair, required: a channel is accepted from the address of Peer
+	// only, once it signed a random challenge with the secret.
+	Secret string
+	// Priority the node with the highest priority, or ID, is elected when both start.
+	Priority int
+	// Heartbeat interval, 1s by default, the peer is dead after DeadInterval, 3 heartbeats by default.
+	Heartbeat    time.Duration
+	DeadInterval time.Duration
+}
+
+// message on the replication channel.
+type message struct {
+	Type     string          `json:"type"`
+	From     string          `json:"from"`
+	Role     Role            `json:"role"`
+	Priority int             `json:"priority"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// Node a node of an active/standby pair. The nodes exchange heartbeats over a TCP replication
+// channel, the standby takes over when the heartbeats of the active node stop.
+type Node struct {
+	options  Options
+	mutex    sync.Mutex
+	role     Role
+	out      net.Conn
+	encoder  *json.Encoder
+	listener net.Listener
+	stop     chan struct{}
+
+	lastPeer     time.Time
+	peerRole     Role
+	peerPriority int
+	peerID       string
+
+	handlers  map[string]func(data json.RawMessage)
+	syncs     []func()
+	onActive  []func()
+	onStandby []func()
+}
+
+// NewNode .
+func NewNode(options Options) *Node {
+	if options.Heartbeat <= 0 {
+		options.Heartbeat = time.Second
+	}
+	if options.DeadInterval <= 0 {
+		options.DeadInterval = 3 * options.Heartbeat
+	}
+	return &Node{
+		options:  options,
+		role:     Standby,
+		handlers: make(map[string]func(data json.RawMessage)),
+	}
+}
+
+// Handle set the handler of the replicated messages of type kind, set before Start.
+func (n *Node) Handle(kind string, handler func(data json.RawMessage)) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.handlers[kind] = handler
+}
+
+// OnSync called on the active node when the peer connects, to send it the whole state.
+func (n *Node) OnSync(f func()) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.syncs = append(n.syncs, f)
+}
+
+// OnActive called when the node becomes active, e.g. to take the virtual IP.
+func (n *Node) OnActive(f func()) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.onActive = append(n.onActive, f)
+}
+
+// OnStandby called when the active node steps down, after a split brain.
+func (n *Node) OnStandby(f func()) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.onStandby = append(n.onStandby, f)
+}
+
+// Role .
+func (n *Node) Role() Role {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.role
+}
+
+// IsActive .
+func (n *Node) IsActive() bool {
+	return n.Role() == Active
+}
+
+// Start listen for the peer and start the heartbeats, the node starts as standby.
+func (n *Node) Start() error {
+	if len(n.options.Secret) == 0 {
+		return ErrNoSecret
+	}
+	listener, err := net.Listen("tcp", n.options.Listen)
+	if err != nil {
+		return err
+	}
+	n.mutex.Lock()
+	n.listener = listener
+	n.stop = make(chan struct{})
+	// Give the peer a chance to claim the active role first.
+	n.lastPeer = time.Now()
+	n.mutex.Unlock()
+
+	go n.accept(listener)
+	go n.run()
+	return nil
+}
+
+// Stop .
+func (n *Node) Stop() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.stop == nil {
+		return
+	}
+	close(n.stop)
+	n.stop = nil
+	n.listener.Close()
+	if n.out != nil {
+		n.out.Close()
+		n.out = nil
+	}
+}
+
+// Replicate send v to the peer as a message of type kind, only the active node replicates.
+// The messages are dropped while the peer is unreachable, it gets the whole state when it reconnects.
+func (n *Node) Replicate(kind string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logger.Errorf("Replication of %s failed: %v", kind, err)
+		return
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.role != Active || n.encoder == nil {
+		return
+	}
+	n.send(&message{Type: kind, Data: data})
+}
+
+// send must be called with the mutex held.
+func (n *Node) send(msg *message) {
+	msg.From = n.options.ID
+	msg.Role = n.role
+	msg.Priority = n.options.Priority
+	n.out.SetWriteDeadline(time.Now().Add(n.options.DeadInterval))
+	if err := n.encoder.Encode(msg); err != nil {
+		logger.Warnf("Replication channel to %s lost: %v", n.options.Peer, err)
+		n.out.Close()
+		n.out, n.encoder = nil, nil
+	}
+}
+
+func (n *Node) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		if !n.isPeer(conn.RemoteAddr()) {
+			logger.Warnf("Replication channel from %v refused, not the peer %s", conn.RemoteAddr(), n.options.Peer)
+			conn.Close()
+			continue
+		}
+		go n.receive(conn)
+	}
+}
+
+// isPeer addr is an address of the host of Peer.
+func (n *Node) isPeer(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	host, _, err := net.SplitHostPort(n.options.Peer)
+	if err != nil {
+		return false
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+	for _, ip := range ips {
+		if ip.Equal(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// sign the challenge with the secret of the pair.
+func (n *Node) sign(challenge string) string {
+	mac := hmac.New(sha256.New, []byte(n.options.Secret))
+	mac.Write([]byte(challenge))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n *Node) receive(conn net.Conn) {
+	defer conn.Close()
+	nonce := make([]byte, challengeSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return
+	}
+	challenge := hex.EncodeToString(nonce)
+	conn.SetWriteDeadline(time.Now().Add(n.options.DeadInterval))
+	if _, err := conn.Write([]byte(challenge + "\n")); err != nil {
+		return
+	}
+	decoder := json.NewDecoder(conn)
+	conn.SetReadDeadline(time.Now().Add(n.options.DeadInterval))
+	hello := &message{}
+	signature := ""
+	if err := decoder.Decode(hello); err != nil || hello.Type != helloMessage ||
+		json.Unmarshal(hello.Data, &signature) != nil || !hmac.Equal([]byte(signature), []byte(n.sign(challenge))) {
+		logger.Warnf("Replication channel from %v refused, bad secret", conn.RemoteAddr())
+		return
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(n.options.DeadInterval))
+		msg := &message{}
+		if err := decoder.Decode(msg); err != nil {
+			return
+		}
+		n.mutex.Lock()
+		n.lastPeer = time.Now()
+		n.peerRole, n.peerPriority, n.peerID = msg.Role, msg.Priority, msg.From
+		handler := n.handlers[msg.Type]
+		n.mutex.Unlock()
+		if msg.Type != heartbeatMessage && handler != nil {
+			handler(msg.Data)
+		}
+	}
+}
+
+func (n *Node) run() {
+	ticker := time.NewTicker(n.options.Heartbeat)
+	defer ticker.Stop()
+	for {
+		n.mutex.Lock()
+		stop := n.stop
+		n.mutex.Unlock()
+		if stop == nil {
+			return
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		n.connect()
+		n.heartbeat()
+		n.elect()
+	}
+}
+
+// connect dial the peer if the replication channel is down, the active node then sends its state.
+func (n *Node) connect() {
+	n.mutex.Lock()
+	connected := n.out != nil
+	n.mutex.Unlock()
+	if connected {
+		return
+	}
+	conn, err := net.DialTimeout("tcp", n.options.Peer, dialTimeout)
+	if err != nil {
+		return
+	}
+	// Sign the challenge of the peer, it's the only message it sends.
+	conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	challenge, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return
+	}
+	signature, _ := json.Marshal(n.sign(challenge[:len(challenge)-1]))
+	n.mutex.Lock()
+	if n.stop == nil {
+		n.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	n.out, n.encoder = conn, json.NewEncoder(conn)
+	n.send(&message{Type: helloMessage, Data: signature})
+	if n.encoder == nil {
+		n.mutex.Unlock()
+		return
+	}
+	active := n.role == Active
+	syncs := append([]func(){}, n.syncs...)
+	n.mutex.Unlock()
+	logger.Infof("Replication channel to %s up", n.options.Peer)
+	if active {
+		for _, f := range syncs {
+			f()
+		}
+	}
+}
+
+func (n *Node) heartbeat() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.encoder != nil {
+		n.send(&message{Type: heartbeatMessage})
+	}
+}
+
+// elect the standby takes over when the peer is dead, or both are standby and it wins; the
+// active node steps down when the peer is active too and wins.
+func (n *Node) elect() {
+	n.mutex.Lock()
+	alive := time.Since(n.lastPeer) < n.options.DeadInterval
+	wins := n.options.Priority > n.peerPriority ||
+		(n.options.Priority == n.peerPriority && n.options.ID > n.peerID)
+	var role Role
+	switch {
+	case n.role == Standby && (!alive || (n.peerRole == Standby && wins && len(n.peerID) > 0)):
+		role = Active
+	case n.role == Active && alive && n.peerRole == Active && !wins:
+		role = Standby
+	default:
+		n.mutex.Unlock()
+		return
+	}
+	n.role = role
+	callbacks := append([]func(){}, n.onStandby...)
+	if role == Active {
+		callbacks = append([]func(){}, n.onActive...)
+		// The channel came up while standby, send the state now.
+		if n.encoder != nil {
+			callbacks = append(callbacks, n.syncs...)
+		}
+	}
+	n.mutex.Unlock()
+
+	logger.Infof("Node %s is now %v", n.options.ID, role)
+	for _, f := range callbacks {
+		f()
+	}
+}
+
+// String .
+func (n *Node) String() string {
+	return fmt.Sprintf("%s (%v)", n.options.ID, n.Role())
+}
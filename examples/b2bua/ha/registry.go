@@ -0,0 +1,124 @@
+package ha
+
+import (
+	"encoding/json"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+const (
+	bindingMessage = "binding"
+)
+
+type bindingOp string
+
+const (
+	bindingAdd       bindingOp = "add"
+	bindingUpdate    bindingOp = "update"
+	bindingRemove    bindingOp = "remove"
+	bindingRemoveAor bindingOp = "remove-aor"
+)
+
+type bindingChange struct {
+	Op      bindingOp         `json:"op"`
+	AOR     string            `json:"aor,omitempty"`
+	Binding *registry.Binding `json:"binding,omitempty"`
+}
+
+// Registry a registry.Registry replicating the registrations to the standby node.
+type Registry struct {
+	registry.Registry
+	node *Node
+}
+
+// NewRegistry replicate the changes of inner through node.
+func NewRegistry(inner registry.Registry, node *Node) *Registry {
+	r := &Registry{Registry: inner, node: node}
+	node.Handle(bindingMessage, r.apply)
+	node.OnSync(r.sync)
+	return r
+}
+
+// AddAor .
+func (r *Registry) AddAor(aor sip.Uri, instance *registry.ContactInstance) error {
+	if err := r.Registry.AddAor(aor, instance); err != nil {
+		return err
+	}
+	r.node.Replicate(bindingMessage, &bindingChange{Op: bindingAdd, Binding: registry.NewBinding(aor, instance)})
+	return nil
+}
+
+// UpdateContact .
+func (r *Registry) UpdateContact(aor sip.Uri, instance *registry.ContactInstance) error {
+	if err := r.Registry.UpdateContact(aor, instance); err != nil {
+		return err
+	}
+	r.node.Replicate(bindingMessage, &bindingChange{Op: bindingUpdate, Binding: registry.NewBinding(aor, instance)})
+	return nil
+}
+
+// RemoveContact .
+func (r *Registry) RemoveContact(aor sip.Uri, instance *registry.ContactInstance) error {
+	if err := r.Registry.RemoveContact(aor, instance); err != nil {
+		return err
+	}
+	r.node.Replicate(bindingMessage, &bindingChange{Op: bindingRemove, Binding: registry.NewBinding(aor, instance)})
+	return nil
+}
+
+// RemoveAor .
+func (r *Registry) RemoveAor(aor sip.Uri) error {
+	if err := r.Registry.RemoveAor(aor); err != nil {
+		return err
+	}
+	r.node.Replicate(bindingMessage, &bindingChange{Op: bindingRemoveAor, AOR: aor.String()})
+	return nil
+}
+
+// Ping the inner registry backend.
+func (r *Registry) Ping() error {
+	if pinger, ok := r.Registry.(registry.Pinger); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+// apply a change replicated by the active node.
+func (r *Registry) apply(data json.RawMessage) {
+	update := &bindingChange{}
+	if err := json.Unmarshal(data, update); err != nil {
+		logger.Errorf("Invalid binding update: %v", err)
+		return
+	}
+	if update.Op == bindingRemoveAor {
+		if aor, err := parser.ParseSipUri(update.AOR); err == nil {
+			r.Registry.RemoveAor(&aor)
+		}
+		return
+	}
+	if update.Binding == nil {
+		return
+	}
+	aor, instance, err := update.Binding.Instance()
+	if err != nil {
+		logger.Errorf("Invalid replicated binding: %v", err)
+		return
+	}
+	switch update.Op {
+	case bindingAdd, bindingUpdate:
+		r.Registry.AddAor(aor, instance)
+	case bindingRemove:
+		r.Registry.RemoveContact(aor, instance)
+	}
+}
+
+// sync send all the bindings to the peer.
+func (r *Registry) sync() {
+	for aor, instances := range r.Registry.GetAllContacts() {
+		for _, instance := range instances {
+			r.node.Replicate(bindingMessage, &bindingChange{Op: bindingAdd, Binding: registry.NewBinding(aor, instance)})
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package ha
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/b2bua"
+)
+
+const (
+	callMessage    = "call"
+	callEndMessage = "call-end"
+)
+
+// StateStore a b2bua.StateStore replicating the answered calls to the standby node, which
+// adopts them when it takes over.
+type StateStore struct {
+	node   *Node
+	mutex  sync.Mutex
+	states map[string]*b2bua.CallState
+}
+
+// NewStateStore .
+func NewStateStore(node *Node) *StateStore {
+	s := &StateStore{
+		node:   node,
+		states: make(map[string]*b2bua.CallState),
+	}
+	node.Handle(callMessage, s.applySave)
+	node.Handle(callEndMessage, s.applyDelete)
+	node.OnSync(s.sync)
+	return s
+}
+
+// Save .
+func (s *StateStore) Save(state *b2bua.CallState) error {
+	s.mutex.Lock()
+	s.states[state.ID] = state
+	s.mutex.Unlock()
+	s.node.Replicate(callMessage, state)
+	return nil
+}
+
+// Delete .
+func (s *StateStore) Delete(id string) error {
+	s.mutex.Lock()
+	delete(s.states, id)
+	s.mutex.Unlock()
+	s.node.Replicate(callEndMessage, id)
+	return nil
+}
+
+// Load the calls replicated by the active node.
+func (s *StateStore) Load() ([]*b2bua.CallState, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	states := make([]*b2bua.CallState, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (s *StateStore) applySave(data json.RawMessage) {
+	state := &b2bua.CallState{}
+	if err := json.Unmarshal(data, state); err != nil || state.Record == nil {
+		logger.Errorf("Invalid replicated call: %v", err)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.states[state.ID] = state
+}
+
+func (s *StateStore) applyDelete(data json.RawMessage) {
+	id := ""
+	if err := json.Unmarshal(data, &id); err != nil {
+		logger.Errorf("Invalid replicated call end: %v", err)
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.states, id)
+}
+
+// sync send all the calls to the peer.
+func (s *StateStore) sync() {
+	states, _ := s.Load()
+	for _, state := range states {
+		s.node.Replicate(callMessage, state)
+	}
+}
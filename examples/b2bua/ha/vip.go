@@ -0,0 +1,51 @@
+package ha
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// VirtualIP the address the SIP traffic is sent to, held by the active node.
+type VirtualIP struct {
+	// Interface e.g. eth0, Address with its prefix length, e.g. 10.0.0.100/24.
+	Interface string
+	Address   string
+}
+
+// Acquire add the address to the interface and announce it with gratuitous ARPs, Linux only.
+func (v *VirtualIP) Acquire() error {
+	if err := run("ip", "addr", "add", v.Address, "dev", v.Interface); err != nil && !strings.Contains(err.Error(), "File exists") {
+		return err
+	}
+	ip, _, err := net.ParseCIDR(v.Address)
+	if err != nil {
+		return err
+	}
+	if ip.To4() != nil {
+		// The switches and the neighbours must learn the new MAC address now.
+		if err := run("arping", "-U", "-c", "3", "-I", v.Interface, ip.String()); err != nil {
+			logger.Warnf("Gratuitous ARP of %s failed: %v", ip, err)
+		}
+	}
+	logger.Infof("Virtual IP %s acquired on %s", v.Address, v.Interface)
+	return nil
+}
+
+// Release remove the address from the interface.
+func (v *VirtualIP) Release() error {
+	if err := run("ip", "addr", "del", v.Address, "dev", v.Interface); err != nil && !strings.Contains(err.Error(), "Cannot assign") {
+		return err
+	}
+	logger.Infof("Virtual IP %s released", v.Address)
+	return nil
+}
+
+func run(name string, args ...string) error {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
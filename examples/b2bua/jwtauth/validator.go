@@ -0,0 +1,170 @@
+package jwtauth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	// ErrTransport bearer tokens are not accepted on the transport of the request.
+	ErrTransport = errors.New("jwt: transport not allowed")
+)
+
+// ClaimsMapping the account of the claims of a validated token.
+type ClaimsMapping func(claims jwt.MapClaims) (string, error)
+
+// Options .
+type Options struct {
+	// Key verifying the signatures, []byte for HMAC, *rsa.PublicKey or *ecdsa.PublicKey.
+	Key interface{}
+	// Keys by key ID (kid header), e.g. during a key rotation, Key is used for the tokens without kid.
+	Keys map[string]interface{}
+	// Methods accepted signing algorithms, RS256 and ES256 by default, HS256 with a []byte Key.
+	Methods []string
+	// Issuer and Audience the tokens must have, not checked if empty.
+	Issuer   string
+	Audience string
+	// UsernameClaim claim holding the SIP account, "sub" by default, Mapping takes precedence.
+	UsernameClaim string
+	Mapping       ClaimsMapping
+	// Transports accepting bearer tokens, e.g. "WS" and "WSS" for WebRTC clients, all if empty.
+	Transports []string
+	// Leeway clock skew tolerated on exp/nbf/iat.
+	Leeway time.Duration
+}
+
+// Validator validates the bearer tokens issued by an identity provider, Verify is
+// an auth.VerifyBearerCallback.
+type Validator struct {
+	options Options
+	parser  *jwt.Parser
+}
+
+// NewValidator .
+func NewValidator(options Options) *Validator {
+	if len(options.Methods) == 0 {
+		if _, ok := options.Key.([]byte); ok {
+			options.Methods = []string{"HS256"}
+		} else {
+			options.Methods = []string{"RS256", "ES256"}
+		}
+	}
+	if len(options.UsernameClaim) == 0 {
+		options.UsernameClaim = "sub"
+	}
+	return &Validator{
+		options: options,
+		parser: &jwt.Parser{
+			ValidMethods: options.Methods,
+			// exp/nbf are checked with the leeway.
+			SkipClaimsValidation: true,
+		},
+	}
+}
+
+// Verify validate the signature and the claims of the token, returns the account and the expiry.
+func (v *Validator) Verify(tokenString string, request sip.Request) (string, time.Time, error) {
+	if !v.transportAllowed(request.Transport()) {
+		return "", time.Time{}, ErrTransport
+	}
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(tokenString, claims, v.key); err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	if _, found := claims["exp"]; !found {
+		return "", time.Time{}, fmt.Errorf("jwt: no expiry")
+	}
+	if !claims.VerifyExpiresAt(now.Add(-v.options.Leeway).Unix(), true) {
+		return "", time.Time{}, fmt.Errorf("jwt: token expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(v.options.Leeway).Unix(), false) {
+		return "", time.Time{}, fmt.Errorf("jwt: token not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now.Add(v.options.Leeway).Unix(), false) {
+		return "", time.Time{}, fmt.Errorf("jwt: token issued in the future")
+	}
+	if len(v.options.Issuer) > 0 && !claims.VerifyIssuer(v.options.Issuer, true) {
+		return "", time.Time{}, fmt.Errorf("jwt: bad issuer")
+	}
+	if len(v.options.Audience) > 0 && !verifyAudience(claims, v.options.Audience) {
+		return "", time.Time{}, fmt.Errorf("jwt: bad audience")
+	}
+
+	username := ""
+	if v.options.Mapping != nil {
+		var err error
+		if username, err = v.options.Mapping(claims); err != nil {
+			return "", time.Time{}, err
+		}
+	} else if value, ok := claims[v.options.UsernameClaim].(string); ok {
+		username = value
+	}
+	if len(username) == 0 {
+		return "", time.Time{}, fmt.Errorf("jwt: no account in the claims")
+	}
+	return username, expiry(claims), nil
+}
+
+// key select the verification key, an HMAC secret must not verify an asymmetric algorithm and vice versa.
+func (v *Validator) key(token *jwt.Token) (interface{}, error) {
+	key := v.options.Key
+	if kid, ok := token.Header["kid"].(string); ok && len(v.options.Keys) > 0 {
+		found := false
+		if key, found = v.options.Keys[kid]; !found {
+			return nil, fmt.Errorf("jwt: unknown key %s", kid)
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("jwt: no key")
+	}
+	_, hmac := token.Method.(*jwt.SigningMethodHMAC)
+	_, secret := key.([]byte)
+	if hmac != secret {
+		return nil, fmt.Errorf("jwt: key doesn't match %s", token.Method.Alg())
+	}
+	return key, nil
+}
+
+func (v *Validator) transportAllowed(transport string) bool {
+	if len(v.options.Transports) == 0 {
+		return true
+	}
+	for _, t := range v.options.Transports {
+		if strings.EqualFold(t, transport) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyAudience aud is a string or an array of strings.
+func verifyAudience(claims jwt.MapClaims, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func expiry(claims jwt.MapClaims) time.Time {
+	switch exp := claims["exp"].(type) {
+	case float64:
+		return time.Unix(int64(exp), 0)
+	case int64:
+		return time.Unix(exp, 0)
+	}
+	return time.Time{}
+}
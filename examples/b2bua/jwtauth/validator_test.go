@@ -0,0 +1,122 @@
+package jwtauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/golang-jwt/jwt"
+)
+
+func newRegister(t *testing.T, transport string) sip.Request {
+	msg, err := parser.ParseMessage([]byte("REGISTER sip:example.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/"+transport+" 192.0.2.1:5060;branch=z9hG4bK776asdhds\r\n"+
+		"From: <sip:alice@example.com>;tag=1928301774\r\n"+
+		"To: <sip:alice@example.com>\r\n"+
+		"Call-ID: a84b4c76e66710\r\n"+
+		"CSeq: 1 REGISTER\r\n"+
+		"Content-Length: 0\r\n\r\n"), log.NewDefaultLogrusLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.(sip.Request)
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secret")
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub": "alice",
+			"iss": "https://idp.example.com",
+			"aud": "sip",
+			"exp": now.Add(time.Hour).Unix(),
+			"iat": now.Unix(),
+		}
+	}
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		claims[key] = value
+		return claims
+	}
+	without := func(key string) jwt.MapClaims {
+		claims := valid()
+		delete(claims, key)
+		return claims
+	}
+	rsaOptions := Options{Key: &rsaKey.PublicKey, Issuer: "https://idp.example.com", Audience: "sip"}
+	hmacOptions := Options{Key: secret, Issuer: "https://idp.example.com", Audience: "sip"}
+
+	for _, test := range []struct {
+		name      string
+		options   Options
+		token     string
+		transport string
+		username  string
+	}{
+		{"valid RS256", rsaOptions, sign(t, jwt.SigningMethodRS256, rsaKey, valid()), "WSS", "alice"},
+		{"valid HS256", hmacOptions, sign(t, jwt.SigningMethodHS256, secret, valid()), "WSS", "alice"},
+		{"audience array", rsaOptions, sign(t, jwt.SigningMethodRS256, rsaKey, with("aud", []string{"web", "sip"})), "WSS", "alice"},
+		{"expired", rsaOptions, sign(t, jwt.SigningMethodRS256, rsaKey, with("exp", now.Add(-time.Minute).Unix())), "WSS", ""},
+		{"no expiry", rsaOptions, sign(t, jwt.SigningMethodRS256, rsaKey, without("exp")), "WSS", ""},
+		{"not valid yet", rsaOptions, sign(t, jwt.SigningMethodRS256, rsaKey, with("nbf", now.Add(time.Hour).Unix())), "WSS", ""},
+		{"wrong audience", rsaOptions, sign(t, jwt.SigningMethodRS256, rsaKey, with("aud", "web")), "WSS", ""},
+		{"wrong issuer", rsaOptions, sign(t, jwt.SigningMethodRS256, rsaKey, with("iss", "https://evil.example.com")), "WSS", ""},
+		{"wrong alg", rsaOptions, sign(t, jwt.SigningMethodRS384, rsaKey, valid()), "WSS", ""},
+		{"none alg", rsaOptions, sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid()), "WSS", ""},
+		// An HS256 token signed with the public key must not verify against it, RS256 allowed or not.
+		{"HMAC with the public key", rsaOptions, sign(t, jwt.SigningMethodHS256, publicDER, valid()), "WSS", ""},
+		{"HMAC with the public key, HS256 allowed", Options{Key: &rsaKey.PublicKey, Methods: []string{"RS256", "HS256"}},
+			sign(t, jwt.SigningMethodHS256, publicDER, valid()), "WSS", ""},
+		{"RS256 with an HMAC key", Options{Key: secret, Methods: []string{"HS256", "RS256"}},
+			sign(t, jwt.SigningMethodRS256, rsaKey, valid()), "WSS", ""},
+		{"transport not allowed", Options{Key: &rsaKey.PublicKey, Transports: []string{"WS", "WSS"}},
+			sign(t, jwt.SigningMethodRS256, rsaKey, valid()), "UDP", ""},
+		{"kid", Options{Keys: map[string]interface{}{"2": &rsaKey.PublicKey}}, func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, valid())
+			token.Header["kid"] = "2"
+			signed, err := token.SignedString(rsaKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signed
+		}(), "WSS", "alice"},
+		{"no account", rsaOptions, sign(t, jwt.SigningMethodRS256, rsaKey, without("sub")), "WSS", ""},
+	} {
+		username, expires, err := NewValidator(test.options).Verify(test.token, newRegister(t, test.transport))
+		if len(test.username) == 0 {
+			if err == nil {
+				t.Errorf("%s: accepted as %s", test.name, username)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if username != test.username || expires.Unix() != now.Add(time.Hour).Unix() {
+			t.Errorf("%s: %s expires %v, want %s", test.name, username, expires, test.username)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package ldapauth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	logger log.Logger
+
+	// ErrUserNotFound the user doesn't exist or has no SIP credential.
+	ErrUserNotFound = errors.New("ldap: user not found")
+)
+
+func init() {
+	logger = utils.NewLogrusLogger(log.InfoLevel, "LDAP", nil)
+}
+
+// Options .
+type Options struct {
+	// URL of the directory, ldap://host:389 or ldaps://host:636.
+	URL string
+	// StartTLS upgrade ldap:// connections, TLSConfig for StartTLS and ldaps://.
+	StartTLS  bool
+	TLSConfig *tls.Config
+	// BindDN and BindPassword of the service account searching the users, empty for anonymous searches.
+	BindDN       string
+	BindPassword string
+	// BaseDN of the user searches.
+	BaseDN string
+	// Filter of the user searches, %s is replaced by the escaped username, "(uid=%s)" by default,
+	// e.g. "(&(objectClass=user)(sAMAccountName=%s))" for Active Directory.
+	Filter string
+	// HA1Attribute attribute holding MD5(username:realm:password), the realm must be the one of the
+	// ServerAuthorizer. Takes precedence over PasswordAttribute.
+	HA1Attribute string
+	// PasswordAttribute attribute holding the clear text SIP password.
+	PasswordAttribute string
+	// UserDNTemplate DN of a user for Bind, %s is replaced by the username, the DN is searched if empty.
+	UserDNTemplate string
+	// PoolSize idle connections kept open, 4 by default.
+	PoolSize int
+	// Timeout of the requests, 5s by default.
+	Timeout time.Duration
+	// CacheTTL lifetime of the cached credentials, 5min by default, negative to disable the cache.
+	// Unknown users are cached for NegativeCacheTTL, 30s by default.
+	CacheTTL         time.Duration
+	NegativeCacheTTL time.Duration
+}
+
+type cacheEntry struct {
+	password string
+	ha1      string
+	err      error
+	expires  time.Time
+}
+
+// Provider LDAP/Active Directory credential backend, RequestCredential plugs into the ServerAuthorizer.
+type Provider struct {
+	options Options
+	pool    chan *ldap.Conn
+	cache   map[string]cacheEntry
+	lock    sync.Mutex
+}
+
+// NewProvider .
+func NewProvider(options Options) *Provider {
+	if len(options.Filter) == 0 {
+		options.Filter = "(uid=%s)"
+	}
+	if options.PoolSize <= 0 {
+		options.PoolSize = 4
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Second
+	}
+	if options.CacheTTL == 0 {
+		options.CacheTTL = 5 * time.Minute
+	}
+	if options.NegativeCacheTTL == 0 {
+		options.NegativeCacheTTL = 30 * time.Second
+	}
+	return &Provider{
+		options: options,
+		pool:    make(chan *ldap.Conn, options.PoolSize),
+		cache:   make(map[string]cacheEntry),
+	}
+}
+
+// RequestCredential the SIP password or HA1 of username, an auth.RequestCredentialCallback.
+func (p *Provider) RequestCredential(username string) (string, string, error) {
+	now := time.Now()
+	p.lock.Lock()
+	entry, found := p.cache[username]
+	p.lock.Unlock()
+	if found && now.Before(entry.expires) {
+		return entry.password, entry.ha1, entry.err
+	}
+
+	entry = cacheEntry{}
+	err := p.do(func(conn *ldap.Conn) error {
+		attrs := []string{}
+		if len(p.options.HA1Attribute) > 0 {
+			attrs = append(attrs, p.options.HA1Attribute)
+		}
+		if len(p.options.PasswordAttribute) > 0 {
+			attrs = append(attrs, p.options.PasswordAttribute)
+		}
+		user, err := p.search(conn, username, attrs)
+		if err != nil {
+			return err
+		}
+		if len(p.options.HA1Attribute) > 0 {
+			entry.ha1 = user.GetAttributeValue(p.options.HA1Attribute)
+		}
+		if len(entry.ha1) == 0 && len(p.options.PasswordAttribute) > 0 {
+			entry.password = user.GetAttributeValue(p.options.PasswordAttribute)
+		}
+		if len(entry.ha1) == 0 && len(entry.password) == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+	switch {
+	case err == nil:
+		entry.expires = now.Add(p.options.CacheTTL)
+	case err == ErrUserNotFound:
+		entry.err = err
+		entry.expires = now.Add(p.options.NegativeCacheTTL)
+	default:
+		// Directory failures are not cached.
+		logger.Errorf("Credential lookup of %s failed: %v", username, err)
+		return "", "", err
+	}
+	if p.options.CacheTTL > 0 {
+		p.lock.Lock()
+		p.cache[username] = entry
+		p.lock.Unlock()
+	}
+	return entry.password, entry.ha1, entry.err
+}
+
+// Bind verify a clear text password by binding as the user, e.g. for HTTP basic authentication.
+// SIP digest authentication needs RequestCredential instead.
+func (p *Provider) Bind(username, password string) (bool, error) {
+	if len(password) == 0 {
+		// An empty password is an unauthenticated bind, which always succeeds.
+		return false, nil
+	}
+	dn := ""
+	if len(p.options.UserDNTemplate) > 0 {
+		dn = fmt.Sprintf(p.options.UserDNTemplate, escapeDN(username))
+	} else {
+		err := p.do(func(conn *ldap.Conn) error {
+			// 1.1 requests no attribute, only the DN.
+			user, err := p.search(conn, username, []string{"1.1"})
+			if err == nil {
+				dn = user.DN
+			}
+			return err
+		})
+		if err == ErrUserNotFound {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	// The connection is bound to the user, it's not returned to the pool.
+	conn, err := p.dial(false)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Invalidate drop the cached credential of username, e.g. after a password change.
+func (p *Provider) Invalidate(username string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.cache, username)
+}
+
+// Close the pooled connections.
+func (p *Provider) Close() {
+	for {
+		select {
+		case conn := <-p.pool:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (p *Provider) search(conn *ldap.Conn, username string, attrs []string) (*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(
+		p.options.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(p.options.Timeout.Seconds()), false,
+		fmt.Sprintf(p.options.Filter, ldap.EscapeFilter(username)),
+		attrs, nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) ||
+			ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			// Ambiguous usernames are refused.
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrUserNotFound
+	}
+	return result.Entries[0], nil
+}
+
+// do run f on a pooled connection, retried once on a fresh connection if the pooled one was broken.
+func (p *Provider) do(f func(conn *ldap.Conn) error) error {
+	for attempt := 0; ; attempt++ {
+		conn, pooled, err := p.get()
+		if err != nil {
+			return err
+		}
+		err = f(conn)
+		if err != nil && ldap.IsErrorWithCode(err, ldap.ErrorNetwork) {
+			conn.Close()
+			if pooled && attempt == 0 {
+				continue
+			}
+			return err
+		}
+		p.put(conn)
+		return err
+	}
+}
+
+func (p *Provider) get() (*ldap.Conn, bool, error) {
+	for {
+		select {
+		case conn := <-p.pool:
+			if conn.IsClosing() {
+				conn.Close()
+				continue
+			}
+			return conn, true, nil
+		default:
+			conn, err := p.dial(true)
+			return conn, false, err
+		}
+	}
+}
+
+func (p *Provider) put(conn *ldap.Conn) {
+	if conn.IsClosing() {
+		conn.Close()
+		return
+	}
+	select {
+	case p.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// dial open a connection, bound to the service account if bind.
+func (p *Provider) dial(bind bool) (*ldap.Conn, error) {
+	options := []ldap.DialOpt{}
+	if p.options.TLSConfig != nil {
+		options = append(options, ldap.DialWithTLSConfig(p.options.TLSConfig))
+	}
+	conn, err := ldap.DialURL(p.options.URL, options...)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(p.options.Timeout)
+	if p.options.StartTLS {
+		config := p.options.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+			if u, err := url.Parse(p.options.URL); err == nil {
+				config.ServerName = u.Hostname()
+			}
+		}
+		if err := conn.StartTLS(config); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if bind && len(p.options.BindDN) > 0 {
+		if err := conn.Bind(p.options.BindDN, p.options.BindPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// escapeDN escape a RDN value (RFC 4514).
+func escapeDN(value string) string {
+	var b strings.Builder
+	for i, c := range value {
+		switch {
+		case strings.ContainsRune(",+\"\\<>;=", c),
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
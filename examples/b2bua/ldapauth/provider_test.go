@@ -0,0 +1,216 @@
+package ldapauth
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeUser an entry of the fakeDirectory.
+type fakeUser struct {
+	dn       string
+	password string
+	attrs    map[string]string
+}
+
+// fakeDirectory a minimal LDAP server answering the simple binds and the searches of an equality on uid.
+type fakeDirectory struct {
+	listener net.Listener
+	users    map[string]fakeUser
+	searches int32
+}
+
+func newFakeDirectory(t *testing.T, users map[string]fakeUser) *fakeDirectory {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDirectory{listener: listener, users: users}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeDirectory) url() string {
+	return "ldap://" + d.listener.Addr().String()
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Data.String()
+			password := op.Children[2].Data.String()
+			code := ldap.LDAPResultInvalidCredentials
+			for _, user := range d.users {
+				if user.dn == dn && user.password == password {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			conn.Write(response(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			atomic.AddInt32(&d.searches, 1)
+			if user, found := d.users[equalityValue(op.Children[6], "uid")]; found {
+				entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+				entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, user.dn, ""))
+				attributes := ber.NewSequence("")
+				for name, value := range user.attrs {
+					attribute := ber.NewSequence("")
+					attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+					values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+					values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+					attribute.AppendChild(values)
+					attributes.AppendChild(attribute)
+				}
+				entry.AppendChild(attributes)
+				envelope := ber.NewSequence("")
+				envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+				envelope.AppendChild(entry)
+				conn.Write(envelope.Bytes())
+			}
+			conn.Write(response(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// response an LDAPResult of tag.
+func response(id interface{}, tag ber.Tag, code int) *ber.Packet {
+	envelope := ber.NewSequence("")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	envelope.AppendChild(result)
+	return envelope
+}
+
+// equalityValue the value of the first equality match on attribute in filter.
+func equalityValue(filter *ber.Packet, attribute string) string {
+	if filter.ClassType == ber.ClassContext && filter.Tag == ldap.FilterEqualityMatch && len(filter.Children) == 2 {
+		if filter.Children[0].Data.String() == attribute {
+			return filter.Children[1].Data.String()
+		}
+		return ""
+	}
+	for _, child := range filter.Children {
+		if value := equalityValue(child, attribute); len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+func testUsers() map[string]fakeUser {
+	return map[string]fakeUser{
+		"alice": {dn: "uid=alice,ou=people,dc=example,dc=com", password: "secret",
+			attrs: map[string]string{"sipHA1": "939e7578ed9e3c518a452acee763bce9", "sipPassword": "sip-secret"}},
+		"bob": {dn: "uid=bob,ou=people,dc=example,dc=com", password: "bob-secret",
+			attrs: map[string]string{"sipPassword": "bob-sip"}},
+		"carol": {dn: "uid=carol,ou=people,dc=example,dc=com", password: "carol-secret"},
+	}
+}
+
+func TestRequestCredential(t *testing.T) {
+	directory := newFakeDirectory(t, testUsers())
+	defer directory.listener.Close()
+	provider := NewProvider(Options{
+		URL:               directory.url(),
+		BaseDN:            "ou=people,dc=example,dc=com",
+		HA1Attribute:      "sipHA1",
+		PasswordAttribute: "sipPassword",
+	})
+	defer provider.Close()
+
+	for _, test := range []struct {
+		username, password, ha1 string
+		err                     error
+	}{
+		{"alice", "", "939e7578ed9e3c518a452acee763bce9", nil},
+		{"bob", "bob-sip", "", nil},
+		{"carol", "", "", ErrUserNotFound},
+		{"mallory", "", "", ErrUserNotFound},
+		{"*)(uid=*", "", "", ErrUserNotFound},
+	} {
+		password, ha1, err := provider.RequestCredential(test.username)
+		if password != test.password || ha1 != test.ha1 || err != test.err {
+			t.Errorf("RequestCredential(%s) = %q, %q, %v, want %q, %q, %v", test.username, password, ha1, err,
+				test.password, test.ha1, test.err)
+		}
+	}
+
+	// The credentials and the unknown users are cached, until invalidated.
+	searches := atomic.LoadInt32(&directory.searches)
+	provider.RequestCredential("alice")
+	provider.RequestCredential("mallory")
+	if n := atomic.LoadInt32(&directory.searches); n != searches {
+		t.Errorf("%d searches of cached credentials", n-searches)
+	}
+	provider.Invalidate("alice")
+	provider.RequestCredential("alice")
+	if n := atomic.LoadInt32(&directory.searches); n != searches+1 {
+		t.Errorf("%d searches after Invalidate, want 1", n-searches)
+	}
+}
+
+func TestBind(t *testing.T) {
+	directory := newFakeDirectory(t, testUsers())
+	defer directory.listener.Close()
+	for _, options := range []Options{
+		{URL: directory.url(), BaseDN: "ou=people,dc=example,dc=com"},
+		{URL: directory.url(), UserDNTemplate: "uid=%s,ou=people,dc=example,dc=com"},
+	} {
+		provider := NewProvider(options)
+		for _, test := range []struct {
+			username, password string
+			bound              bool
+		}{
+			{"alice", "secret", true},
+			{"alice", "guess", false},
+			// An empty password would be an unauthenticated bind.
+			{"alice", "", false},
+			{"mallory", "secret", false},
+		} {
+			bound, err := provider.Bind(test.username, test.password)
+			if err != nil || bound != test.bound {
+				t.Errorf("Bind(%s, %s) with template %q = %v, %v, want %v", test.username, test.password,
+					options.UserDNTemplate, bound, err, test.bound)
+			}
+		}
+		provider.Close()
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	for value, want := range map[string]string{
+		"alice":       "alice",
+		"a,b=c":       `a\,b\=c`,
+		" #lead":      `\ #lead`,
+		"#hash":       `\#hash`,
+		"trailing ":   `trailing\ `,
+		`quote"<>;+\`: `quote\"\<\>\;\+\\`,
+	} {
+		if got := escapeDN(value); got != want {
+			t.Errorf("escapeDN(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
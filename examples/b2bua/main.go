@@ -1,18 +1,40 @@
 package main
 
 import (
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/c-bata/go-prompt"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/accounts"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/audit"
 	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/b2bua"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/enum"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/events"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/ha"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/jwtauth"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/ldapauth"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/numbering"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/radius"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/registry"
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/script"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media/rtp"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
 	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
 	"github.com/ghettovoice/gosip/log"
+	"github.com/go-redis/redis/v8"
+	"github.com/golang-jwt/jwt"
+	"github.com/nats-io/nats.go"
 )
 
 func completer(d prompt.Document) []prompt.Suggest {
@@ -20,6 +42,8 @@ func completer(d prompt.Document) []prompt.Suggest {
 		{Text: "users", Description: "Show sip accounts"},
 		{Text: "onlines", Description: "Show online sip devices"},
 		{Text: "calls", Description: "Show active calls"},
+		{Text: "cs", Description: "Show connection stats"},
+		{Text: "stats", Description: "Show call and message stats"},
 		{Text: "set debug on", Description: "Show debug msg in console"},
 		{Text: "set debug off", Description: "Turn off debug msg in console"},
 		{Text: "show loggers", Description: "Print Loggers"},
@@ -94,16 +118,37 @@ func consoleLoop(b2bua *b2bua.B2BUA) {
 				for aor, instances := range aors {
 					fmt.Printf("AOR: %v:\n", aor)
 					for _, instance := range instances {
+						device := instance.DeviceInfo()
 						fmt.Printf("\t%v, Expires: %d, Source: %v, Transport: %v\n",
-							(*instance).UserAgent,
-							(*instance).RegExpires,
-							(*instance).Source,
-							(*instance).Transport)
+							device.UserAgent,
+							device.Expires,
+							device.Source,
+							device.Transport)
+						if len(device.InstanceID) > 0 {
+							fmt.Printf("\t\tInstance: %v, Reg-ID: %v\n", device.InstanceID, device.RegID)
+						}
+						fmt.Printf("\t\tSupported: %v, Allow: %v, Registered: %v\n",
+							strings.Join(device.Supported, ","),
+							strings.Join(device.Allow, ","),
+							device.Registered.Format(time.RFC3339))
 					}
 				}
 			} else {
 				fmt.Printf("No online devices\n")
 			}
+		case "cs": /* connection stats*/
+			if stats, ok := b2bua.ConnectionStats(); ok {
+				fmt.Printf("Connections: %d, Sources: %d, Accepted: %d, Rejected: %d, Evicted: %d\n",
+					stats.Connections, stats.Sources, stats.Accepted, stats.Rejected, stats.Evicted)
+			} else {
+				fmt.Printf("Connection limits disabled\n")
+			}
+		case "stats":
+			stats := b2bua.Stats()
+			fmt.Printf("Calls: %d (%d answered), Attempts: %d, CPS: %.2f (peak %.0f), Registrations: %d (%d contacts)\n",
+				stats.Calls, stats.AnsweredCalls, stats.CallAttempts, stats.CPS, stats.PeakCPS, stats.Registrations, stats.Contacts)
+			fmt.Printf("Requests in: %v, out: %v\n", stats.Messages.RequestsIn, stats.Messages.RequestsOut)
+			fmt.Printf("Responses in: %v, out: %v\n", stats.Messages.ResponsesIn, stats.Messages.ResponsesOut)
 		case "pr": /* pn records*/
 			pnrs := b2bua.GetRFC8599().PNRecords()
 			if len(pnrs) > 0 {
@@ -122,6 +167,27 @@ func consoleLoop(b2bua *b2bua.B2BUA) {
 	}
 }
 
+// getenv the first set variable of names.
+func getenv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// splitList the non-empty items of a comma separated list.
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func main() {
 	noconsole := false
 	disableAuth := false
@@ -129,6 +195,227 @@ func main() {
 	flag.BoolVar(&h, "h", false, "this help")
 	flag.BoolVar(&noconsole, "nc", false, "no console mode")
 	flag.BoolVar(&disableAuth, "da", false, "disable auth mode")
+	listen := b2bua.DefaultB2BUAConfig()
+	flag.StringVar(&listen.EmergencyDestination, "emergency-destination", "", "route the emergency calls (112, 911), unchallenged, to this SIP URI, e.g. sip:psap.example.com")
+	flag.StringVar(&listen.UDP, "udp-listen", listen.UDP, "serve SIP over UDP on this address, empty to disable")
+	flag.StringVar(&listen.TCP, "tcp-listen", listen.TCP, "serve SIP over TCP on this address, empty to disable")
+	flag.StringVar(&listen.TLS, "tls-listen", listen.TLS, "serve SIP over TLS on this address, empty to disable")
+	flag.StringVar(&listen.WSS, "wss-listen", listen.WSS, "serve SIP over WSS on this address, empty to disable")
+	flag.StringVar(&listen.TLSCert, "tls-cert", listen.TLSCert, "PEM certificate of the TLS and WSS listeners")
+	flag.StringVar(&listen.TLSKey, "tls-key", listen.TLSKey, "PEM private key of the TLS and WSS listeners")
+	flag.StringVar(&listen.Realm, "realm", listen.Realm, "realm of the digest challenges")
+	reusePort := false
+	telURI := false
+	handoff := ""
+	flag.BoolVar(&reusePort, "reuseport", false, "listen with SO_REUSEPORT")
+	flag.BoolVar(&telURI, "tel-uri", false, "accept the tel: URIs in the Request-URI, To and From, routed as sip:<number>@<host>;user=phone")
+	flag.StringVar(&handoff, "handoff", "", "unix socket path to take over the listening sockets of a running b2bua")
+	natsURL := ""
+	kafkaBrokers := ""
+	eventsFormat := "json"
+	flag.StringVar(&natsURL, "nats", "", "publish the call and registration events to this NATS server")
+	flag.StringVar(&kafkaBrokers, "kafka", "", "publish the call and registration events to these comma separated Kafka brokers")
+	flag.StringVar(&eventsFormat, "events-format", "json", "serialization of the events, json or protobuf")
+	auditOptions := audit.Options{}
+	auditFormat := ""
+	flag.StringVar(&auditOptions.Path, "audit-log", "", "append the admin actions, auth failures, registrations and call attempts to this file")
+	flag.StringVar(&auditFormat, "audit-format", "json", "format of the audit log, json or csv")
+	flag.Int64Var(&auditOptions.MaxSize, "audit-max-size", audit.DefaultMaxSize, "rotate the audit log beyond this size in bytes")
+	flag.DurationVar(&auditOptions.Interval, "audit-rotate", 24*time.Hour, "rotate the audit log at least this often, 0 to rotate on size only")
+	flag.IntVar(&auditOptions.MaxBackups, "audit-backups", audit.DefaultMaxBackups, "rotated audit logs kept, -1 to keep them all")
+	radiusServer := ""
+	radiusAccounting := ""
+	radiusSecret := ""
+	flag.StringVar(&radiusServer, "radius", "", "authenticate the digest with this RADIUS server, host:port")
+	flag.StringVar(&radiusAccounting, "radius-acct", "", "send the call accounting to this RADIUS server, host:port")
+	flag.StringVar(&radiusSecret, "radius-secret", "", "RADIUS shared secret")
+	ldapOptions := ldapauth.Options{}
+	flag.StringVar(&ldapOptions.URL, "ldap", "", "look up the credentials in this LDAP/AD directory, ldap://host:389")
+	flag.StringVar(&ldapOptions.BaseDN, "ldap-base", "", "LDAP base DN of the users")
+	flag.StringVar(&ldapOptions.BindDN, "ldap-bind-dn", "", "LDAP service account DN")
+	flag.StringVar(&ldapOptions.BindPassword, "ldap-bind-password", "", "LDAP service account password")
+	flag.StringVar(&ldapOptions.Filter, "ldap-filter", "(uid=%s)", "LDAP user filter, %s is the username")
+	flag.StringVar(&ldapOptions.PasswordAttribute, "ldap-password-attr", "", "LDAP attribute of the SIP password")
+	flag.StringVar(&ldapOptions.HA1Attribute, "ldap-ha1-attr", "", "LDAP attribute of the SIP HA1, realm b2bua")
+	accountOptions := accounts.Options{}
+	accountHash := ""
+	flag.StringVar(&accountOptions.Path, "accounts", "", "keep the hashed accounts in this JSON file, managed on /accounts and /accounts/password of the admin API")
+	flag.StringVar(&accountHash, "accounts-hash", "argon2id", "hash of the account passwords, argon2id or bcrypt")
+	regWebhooks := ""
+	regWebhookSecret := ""
+	flag.StringVar(&regWebhooks, "reg-webhook", "", "POST the accounts going online and offline to this URL, or tenant=url, comma separated")
+	flag.StringVar(&regWebhookSecret, "reg-webhook-secret", "", "sign the registration webhooks with this HMAC secret")
+	jwtKey := ""
+	jwtOptions := jwtauth.Options{Transports: []string{"WS", "WSS"}}
+	flag.StringVar(&jwtKey, "jwt-key", "", "accept the bearer tokens signed by this PEM public key from WebRTC clients")
+	flag.StringVar(&jwtOptions.Issuer, "jwt-issuer", "", "issuer of the bearer tokens")
+	flag.StringVar(&jwtOptions.Audience, "jwt-audience", "", "audience of the bearer tokens")
+	cdrJSON := ""
+	cdrCSV := ""
+	flag.StringVar(&cdrJSON, "cdr-json", "", "append the call detail records to this file, a JSON object by line")
+	flag.StringVar(&cdrCSV, "cdr-csv", "", "append the call detail records to this CSV file")
+	retention := b2bua.RetentionPolicy{}
+	flag.DurationVar(&retention.CDRs, "retain-cdrs", 0, "purge the stored CDRs older than this, e.g. 8760h, 0 to keep them")
+	flag.DurationVar(&retention.Recordings, "retain-recordings", 0, "purge the recordings of -recordings-dir older than this, 0 to keep them")
+	flag.StringVar(&retention.RecordingsDir, "recordings-dir", "", "directory of the call recordings, purged and erased with the other data")
+	flag.DurationVar(&retention.History, "retain-history", 0, "purge the message history of the calls ended longer ago, 0 to keep it")
+	flag.DurationVar(&retention.Registrations, "retain-registrations", 0, "remove the bindings, and their device metadata, first registered longer ago, 0 to keep them")
+	stripHeaders := strings.Join(b2bua.DefaultStripHeaders, ",")
+	copyHeaders := ""
+	flag.StringVar(&stripHeaders, "strip-headers", stripHeaders, "strip these headers, X-* for a prefix, from the calls of untrusted sources, comma separated")
+	flag.StringVar(&copyHeaders, "copy-headers", "", "copy these inbound headers, X-* for a prefix, onto the B-leg, comma separated")
+	localesFile := ""
+	languages := ""
+	flag.StringVar(&localesFile, "locales", "", "JSON file of the localized reason phrases by language, e.g. {\"fr\": {\"486\": \"Occupé\"}}")
+	flag.StringVar(&languages, "language", "", "language of the callers whose Accept-Language matches no locale, or account=language and domain=language, comma separated")
+	keepaliveMethod := ""
+	keepaliveInterval := time.Duration(0)
+	keepaliveFailures := 0
+	flag.StringVar(&keepaliveMethod, "keepalive", "", "detect the dead calls with in-dialog keep-alives on both legs, options or reinvite")
+	flag.DurationVar(&keepaliveInterval, "keepalive-interval", b2bua.DefaultKeepaliveInterval, "interval between the keep-alives of a call leg")
+	flag.IntVar(&keepaliveFailures, "keepalive-failures", b2bua.DefaultKeepaliveFailures, "unanswered keep-alives before a call is torn down")
+	earlyMedia := string(b2bua.EarlyMediaFirst)
+	forking := string(b2bua.ForkingParallel)
+	ringTimeout := b2bua.DefaultRingTimeout
+	destinations := ""
+	onNetBypass := false
+	trying := string(b2bua.TryingAfterRouting)
+	provisionalRefresh := time.Duration(0)
+	pddThreshold := time.Duration(0)
+	setupTimeout := b2bua.DefaultSetupTimeout
+	trunkBreaker := false
+	trunkMinASR := b2bua.DefaultBreakerMinASR
+	trunkMaxTimeouts := b2bua.DefaultBreakerMaxTimeoutRate
+	trunkProbe := b2bua.DefaultBreakerProbeInterval
+	flag.StringVar(&earlyMedia, "early-media", earlyMedia, "branch of the forked calls relaying its early SDP: first, preferred:<transport> or none")
+	flag.StringVar(&forking, "forking", forking, "ring the contacts of an AOR all at once, parallel, or one at a time, sequential")
+	flag.DurationVar(&ringTimeout, "ring-timeout", ringTimeout, "ring time of each contact of a sequential fork before the next one")
+	flag.BoolVar(&trunkBreaker, "trunk-breaker", false, "skip the failing trunks, probed with OPTIONS until they answer, health on /trunks/health of the admin API")
+	flag.Float64Var(&trunkMinASR, "trunk-min-asr", trunkMinASR, "answer seizure ratio of the last calls of a trunk under which -trunk-breaker skips it")
+	flag.Float64Var(&trunkMaxTimeouts, "trunk-max-timeouts", trunkMaxTimeouts, "ratio of the last calls of a trunk timing out over which -trunk-breaker skips it")
+	flag.DurationVar(&trunkProbe, "trunk-probe", trunkProbe, "interval of the probes of the trunks skipped by -trunk-breaker")
+	flag.BoolVar(&onNetBypass, "on-net-bypass", false, "relay the calls between registered users end to end: no local ringback, media security filtering nor admission, CDRs flagged on-net")
+	flag.StringVar(&trying, "trying", trying, "when the callers are sent 100 Trying: immediate, on receipt of the INVITE, or routing, once the callee is found")
+	flag.DurationVar(&provisionalRefresh, "provisional-refresh", 0, "resend the last provisional response of the callers over UDP at this interval until the call is answered, e.g. 60s")
+	flag.DurationVar(&setupTimeout, "setup-timeout", setupTimeout, "answer 504 to the calls not routed, authorized and sent their first B-Leg within this, 0 to disable")
+	flag.DurationVar(&pddThreshold, "pdd-threshold", 0, "publish a call.slow_progress event when the post-dial delay of a call exceeds this, e.g. 8s")
+	flag.StringVar(&destinations, "destinations", "", "comma separated match=transport/family/source[/tel] of the B-Legs to a CIDR, IP or domain, first match, tel sending the numbers as tel: URIs, e.g. 10.0.0.0/8=tcp//10.0.0.5,carrier.com=tls/ip6/,203.0.113.7=udp///tel")
+	routeURL := ""
+	routeFailOpen := false
+	flag.StringVar(&routeURL, "route-url", "", "ask this HTTP service for the routing of the calls")
+	flag.BoolVar(&routeFailOpen, "route-fail-open", false, "route the calls as usual when the HTTP routing service fails")
+	thirdPartyACLFile := ""
+	flag.StringVar(&thirdPartyACLFile, "third-party-acl", "", "allow the accounts to register other AORs by the rules of this JSON file: account and AOR patterns")
+	classOfServiceFile := ""
+	flag.StringVar(&classOfServiceFile, "class-of-service", "", "authorize the calls of the accounts by the class of service policy of this JSON file")
+	numberingFile := ""
+	numberingCases := ""
+	flag.StringVar(&numberingFile, "numbering", "", "translate the called numbers by the tables of this JSON file before the routing: by trunk, tenant or default")
+	flag.StringVar(&numberingCases, "numbering-verify", "", "verify the -numbering tables against the cases of this JSON file and exit")
+	enumZones := ""
+	enumServer := ""
+	enumTimeout := time.Duration(0)
+	enumMaxTTL := time.Duration(0)
+	flag.StringVar(&enumZones, "enum-zones", "", "look the E.164 numbers called up in these ENUM zones before the routing, comma separated, e.g. e164.arpa")
+	flag.StringVar(&enumServer, "enum-server", "", "DNS server of the ENUM lookups, host[:port], the system's if empty")
+	flag.DurationVar(&enumTimeout, "enum-timeout", enum.DefaultTimeout, "timeout of an ENUM lookup")
+	flag.DurationVar(&enumMaxTTL, "enum-max-ttl", enum.DefaultMaxTTL, "cache the ENUM answers at most this long")
+	dialPlanFile := ""
+	flag.StringVar(&dialPlanFile, "dial-plan", "", "route the calls by the rules of this JSON file: prefix or pattern, rewrite, trunk or registered users")
+	routeScript := ""
+	stateDir := ""
+	instanceID := ""
+	redisAddr := ""
+	redisPrefix := ""
+	registryShards := 0
+	registrySnapshot := b2bua.RegistrySnapshot{}
+	advertise := ""
+	wsPath := ""
+	probeListen := ""
+	adminListen := ""
+	adminAuth := b2bua.AdminAuth{}
+	drainTimeout := time.Duration(0)
+	historyCalls := 0
+	redactLogs := ""
+	redactTrace := ""
+	redactHistory := ""
+	intercomCallers := ""
+	natPing := time.Duration(0)
+	natExpires := uint(0)
+	tlsDomains := ""
+	tlsUpgrade := false
+	tlsVerify := false
+	tlsCA := ""
+	tlsSkipVerify := ""
+	tlsPins := ""
+	dnsServer := ""
+	dnssec := ""
+	mediaSecurity := ""
+	rtpPorts := ""
+	mediaConfig := rtp.DefaultConfig()
+	sipTOS := 0
+	dialogMailbox := 0
+	userAgent := ""
+	serverHeader := ""
+	hideUserAgent := false
+	headerOrder := ""
+	blockScanners := ""
+	scannerSignatures := ""
+	haOptions := ha.Options{}
+	haVIP := ha.VirtualIP{}
+	flag.StringVar(&haOptions.ID, "ha-id", "", "run as a node of an active/standby pair, with this node ID")
+	flag.StringVar(&haOptions.Listen, "ha-listen", ":7070", "replication channel address of this node")
+	flag.StringVar(&haOptions.Peer, "ha-peer", "", "replication channel address of the other node")
+	flag.StringVar(&haOptions.Secret, "ha-secret", getenv("B2BUA_HA_SECRET"), "secret shared by the nodes of the pair, required, $B2BUA_HA_SECRET by default")
+	flag.IntVar(&haOptions.Priority, "ha-priority", 0, "the node with the highest priority is active when both start")
+	flag.StringVar(&haVIP.Address, "ha-vip", "", "virtual IP held by the active node, e.g. 10.0.0.100/24")
+	flag.StringVar(&haVIP.Interface, "ha-vip-dev", "eth0", "interface of the virtual IP")
+	flag.StringVar(&stateDir, "state-dir", "", "persist the answered calls in this directory, released cleanly after a crash")
+	flag.StringVar(&routeScript, "route-script", "", "route the calls with this Lua script, reloaded when it changes")
+	flag.StringVar(&instanceID, "instance-id", "", "name of this instance behind a SIP load balancer, added to its Via and Contact")
+	flag.StringVar(&redisAddr, "redis", "", "share the registrations with the other instances in this Redis server, host:port")
+	flag.StringVar(&redisPrefix, "redis-prefix", "b2bua:", "prefix of the Redis keys")
+	flag.StringVar(&registrySnapshot.Path, "registry-snapshot", "", "snapshot the registrations to this file, restored on start")
+	flag.DurationVar(&registrySnapshot.Interval, "registry-snapshot-interval", b2bua.DefaultSnapshotInterval, "interval of the registry snapshots")
+	flag.IntVar(&registryShards, "registry-shards", 0, "spread the registrations in memory over this many locks, for millions of subscribers")
+	// In Kubernetes, from the downward API: env: [{name: POD_IP, valueFrom: {fieldRef: {fieldPath: status.podIP}}}]
+	flag.StringVar(&advertise, "advertise", getenv("SIP_ADVERTISED_ADDRESS", "POD_IP"), "address advertised in the Via and Contact, $SIP_ADVERTISED_ADDRESS or $POD_IP by default")
+	flag.StringVar(&listen.WS, "ws-listen", "", "also serve SIP over plain WS on this address, e.g. :8080 behind an ingress terminating TLS")
+	flag.StringVar(&wsPath, "ws-path", "", "upgrade only this WS path, e.g. /sip")
+	flag.StringVar(&probeListen, "probe-listen", "", "serve /healthz, /readyz and the /drain preStop hook on this address, e.g. :8086")
+	flag.StringVar(&adminListen, "admin-listen", "", "serve the admin API of the calls, registrations and accounts on this address, e.g. 127.0.0.1:8087")
+	flag.StringVar(&adminAuth.Token, "admin-token", getenv("B2BUA_ADMIN_TOKEN"), "bearer token of the admin API, $B2BUA_ADMIN_TOKEN by default")
+	flag.StringVar(&adminAuth.Username, "admin-user", "admin", "basic auth user of the admin API, with -admin-password")
+	flag.StringVar(&adminAuth.Password, "admin-password", getenv("B2BUA_ADMIN_PASSWORD"), "basic auth password of the admin API, $B2BUA_ADMIN_PASSWORD by default")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 5*time.Minute, "longest wait of the /drain hook, and of the process handing off its sockets, for the calls to end")
+	flag.IntVar(&historyCalls, "history", 100, "keep the SIP messages of the active calls and of this many completed calls, 0 to disable")
+	flag.StringVar(&redactLogs, "redact-logs", "credentials", "mask in the logs: comma separated credentials, bodies, users, or all")
+	flag.StringVar(&redactTrace, "redact-trace", "credentials", "mask in the SIP trace: comma separated credentials, bodies, users, or all")
+	flag.StringVar(&redactHistory, "redact-history", "credentials", "mask in the message history: comma separated credentials, bodies, users, or all")
+	flag.StringVar(&intercomCallers, "intercom", "", "comma separated callers allowed to request the auto-answer of their calls, e.g. 100,90*")
+	flag.DurationVar(&natPing, "nat-ping", 0, "ping the UDP clients behind a NAT with OPTIONS at this interval, e.g. 30s, and skip the ones which don't answer")
+	flag.UintVar(&natExpires, "nat-expires", b2bua.DefaultLivenessExpires, "max expires of the registrations of the UDP clients behind a NAT, with -nat-ping")
+	flag.StringVar(&tlsDomains, "tls-domains", "", "comma separated domains the requests with a body or credentials are sent to over TLS only, * for all")
+	flag.BoolVar(&tlsUpgrade, "tls-upgrade", false, "send the requests of -tls-domains over TLS instead of UDP/TCP rather than refusing them")
+	flag.BoolVar(&tlsVerify, "tls-verify", false, "verify the certificates of the TLS peers dialed against their SIP domain, RFC 5922")
+	flag.StringVar(&tlsCA, "tls-ca", "", "PEM file of the CAs trusted by -tls-verify, the ones of the system by default")
+	flag.StringVar(&tlsSkipVerify, "tls-skip-verify", "", "comma separated hosts whose certificate -tls-verify accepts unverified, e.g. a lab")
+	flag.StringVar(&tlsPins, "tls-pins", "", "comma separated host=pin, the base64 SHA-256 of the public key of the only certificate accepted from host")
+	flag.StringVar(&dnsServer, "dns", "", "resolver of the targets: host[:port], tls://host[:port] for DNS over TLS or https://host/path for DNS over HTTPS")
+	flag.StringVar(&dnssec, "dnssec", "off", "off, prefer or require the answers of the -dns resolver authenticated by DNSSEC")
+	flag.StringVar(&mediaSecurity, "media-security", "", "comma separated media security policies, best-effort, require or forbid, of the called prefixes, e.g. require,800=best-effort")
+	flag.StringVar(&rtpPorts, "rtp-ports", fmt.Sprintf("%d-%d", rtp.DefaultPortMin, rtp.DefaultPortMax), "port range of the media sockets")
+	flag.StringVar(&mediaConfig.BindAddress, "rtp-bind", "", "bind the media sockets to this address")
+	flag.StringVar(&mediaConfig.Interface, "rtp-interface", "", "bind the media sockets to the address of this network interface")
+	flag.IntVar(&mediaConfig.DSCP, "rtp-dscp", utils.DSCPExpedited, "DSCP of the media packets, 46 (EF) by default, 0 to leave them unmarked")
+	flag.IntVar(&dialogMailbox, "dialog-mailbox", 0, "process each dialog on a goroutine of its own with a mailbox of this many messages, instead of the shared workers")
+	flag.IntVar(&sipTOS, "sip-tos", 0, "TOS byte of the signaling packets, e.g. 0x60 for CS3, 0 to leave them unmarked")
+	flag.StringVar(&userAgent, "user-agent", "", "User-Agent of the requests, Go B2BUA/1.0.0 by default")
+	flag.StringVar(&serverHeader, "server-header", "", "Server header of the responses, the User-Agent by default")
+	flag.BoolVar(&hideUserAgent, "hide-user-agent", false, "send neither User-Agent nor Server header")
+	flag.StringVar(&headerOrder, "header-order", "", "send these headers first, comma separated, or default for Via, Route, Record-Route, Max-Forwards, From, To, Call-ID, CSeq, Contact")
+	flag.StringVar(&blockScanners, "block-scanners", "", "drop or tarpit the requests of the SIP scanners, e.g. friendly-scanner")
+	flag.StringVar(&scannerSignatures, "scanner-signatures", strings.Join(stack.DefaultScannerSignatures, ","), "User-Agent substrings of the SIP scanners, comma separated")
 	flag.Usage = usage
 
 	flag.Parse()
@@ -146,7 +433,590 @@ func main() {
 		http.ListenAndServe(":6658", nil)
 	}()
 
-	b2bua := b2bua.NewB2BUA(disableAuth)
+	options := []b2bua.StackOption{}
+	if reusePort {
+		options = append(options, b2bua.WithReusePort())
+	}
+	if telURI {
+		options = append(options, b2bua.WithTelURI())
+	}
+	if len(handoff) > 0 {
+		options = append(options, b2bua.WithHandoff(handoff))
+	}
+	if len(instanceID) > 0 {
+		options = append(options, b2bua.WithInstanceID(instanceID))
+	}
+	if len(advertise) > 0 {
+		options = append(options, b2bua.WithHost(advertise))
+	}
+	if len(wsPath) > 0 {
+		options = append(options, b2bua.WithWebSocketPath(wsPath))
+	}
+	if dialogMailbox > 0 {
+		options = append(options, b2bua.WithDialogRuntime(dialogMailbox))
+	}
+	if sipTOS != 0 {
+		options = append(options, b2bua.WithSignalingTOS(sipTOS))
+	}
+	if len(userAgent) > 0 || len(serverHeader) > 0 {
+		if len(userAgent) == 0 {
+			userAgent = "Go B2BUA/1.0.0"
+		}
+		options = append(options, b2bua.WithUserAgent(userAgent, serverHeader))
+	}
+	if hideUserAgent {
+		options = append(options, b2bua.WithHiddenUserAgent())
+	}
+	switch blockScanners {
+	case "":
+	case "drop", "tarpit":
+		filter := stack.NewScannerFilter(stack.ScannerDrop)
+		if blockScanners == "tarpit" {
+			filter.Action = stack.ScannerTarpit
+		}
+		filter.UserAgents = splitList(scannerSignatures)
+		options = append(options, b2bua.WithScannerFilter(filter))
+	default:
+		fmt.Printf("Invalid -block-scanners %q, drop or tarpit\n", blockScanners)
+		os.Exit(1)
+	}
+	if headerOrder == "default" {
+		options = append(options, b2bua.WithHeaderOrder(stack.DefaultHeaderOrder...))
+	} else if len(headerOrder) > 0 {
+		options = append(options, b2bua.WithHeaderOrder(splitList(headerOrder)...))
+	}
+	if _, err := fmt.Sscanf(rtpPorts, "%d-%d", &mediaConfig.PortMin, &mediaConfig.PortMax); err != nil || mediaConfig.PortMin > mediaConfig.PortMax {
+		fmt.Printf("Invalid -rtp-ports %s, expected min-max\n", rtpPorts)
+		os.Exit(1)
+	}
+	if tlsVerify {
+		var rootCAs *x509.CertPool
+		if len(tlsCA) > 0 {
+			pem, err := ioutil.ReadFile(tlsCA)
+			if err != nil {
+				fmt.Printf("Invalid -tls-ca %s: %v\n", tlsCA, err)
+				os.Exit(1)
+			}
+			rootCAs = x509.NewCertPool()
+			if !rootCAs.AppendCertsFromPEM(pem) {
+				fmt.Printf("Invalid -tls-ca %s: no certificate\n", tlsCA)
+				os.Exit(1)
+			}
+		}
+		policy := stack.NewTLSVerifyPolicy(rootCAs)
+		for _, host := range splitList(tlsSkipVerify) {
+			policy.SetPeer(host, stack.TLSPeer{SkipVerify: true})
+		}
+		pins := map[string][]string{}
+		for _, entry := range splitList(tlsPins) {
+			if i := strings.Index(entry, "="); i > 0 {
+				pins[entry[:i]] = append(pins[entry[:i]], entry[i+1:])
+			}
+		}
+		for host, hostPins := range pins {
+			policy.SetPeer(host, stack.TLSPeer{Pins: hostPins})
+		}
+		options = append(options, b2bua.WithTLSVerify(policy))
+	}
+	if len(dnsServer) > 0 {
+		resolver := &stack.ResolverConfig{Protocol: stack.ResolverUDP, Server: dnsServer}
+		switch {
+		case strings.HasPrefix(dnsServer, "tls://"):
+			resolver.Protocol, resolver.Server = stack.ResolverTLS, strings.TrimPrefix(dnsServer, "tls://")
+		case strings.HasPrefix(dnsServer, "https://"):
+			resolver.Protocol = stack.ResolverHTTPS
+		}
+		switch dnssec {
+		case "off":
+		case "prefer":
+			resolver.DNSSEC = stack.DNSSECPrefer
+		case "require":
+			resolver.DNSSEC = stack.DNSSECRequire
+		default:
+			fmt.Printf("Invalid -dnssec %s, expected off, prefer or require\n", dnssec)
+			os.Exit(1)
+		}
+		options = append(options, b2bua.WithResolver(resolver))
+	}
+	if len(tlsDomains) > 0 {
+		options = append(options, b2bua.WithTransportSecurity(stack.NewTransportSecurityPolicy(tlsUpgrade, strings.Split(tlsDomains, ",")...)))
+	}
+	var router *b2bua.HTTPRouter
+	if len(routeURL) > 0 {
+		router = b2bua.NewHTTPRouter(routeURL, 0)
+		router.FailOpen = routeFailOpen
+	}
+	var dialPlan *b2bua.DialPlanRouter
+	if len(dialPlanFile) > 0 {
+		var err error
+		if dialPlan, err = b2bua.LoadDialPlan(dialPlanFile); err != nil {
+			fmt.Printf("Invalid dial plan: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var thirdPartyACL *b2bua.ThirdPartyACL
+	if len(thirdPartyACLFile) > 0 {
+		var err error
+		if thirdPartyACL, err = b2bua.LoadThirdPartyACL(thirdPartyACLFile); err != nil {
+			fmt.Printf("Invalid third-party ACL: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	var numberingTables *numbering.Tables
+	if len(numberingFile) > 0 {
+		var err error
+		if numberingTables, err = numbering.Load(numberingFile); err != nil {
+			fmt.Printf("Invalid numbering tables: %v\n", err)
+			os.Exit(1)
+		}
+		if len(numberingCases) > 0 {
+			cases, err := numbering.LoadCases(numberingCases)
+			if err != nil {
+				fmt.Printf("Invalid numbering cases: %v\n", err)
+				os.Exit(1)
+			}
+			mismatches := numberingTables.Verify(cases)
+			for _, mismatch := range mismatches {
+				fmt.Println(mismatch)
+			}
+			fmt.Printf("%d cases, %d mismatches\n", len(cases), len(mismatches))
+			if len(mismatches) > 0 {
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+	var enumResolver *enum.Resolver
+	if len(enumZones) > 0 {
+		enumResolver = enum.NewResolver(enumServer, strings.Split(enumZones, ",")...)
+		enumResolver.Timeout = enumTimeout
+		enumResolver.MaxTTL = enumMaxTTL
+	}
+	var classOfService *b2bua.ClassOfServicePolicy
+	if len(classOfServiceFile) > 0 {
+		var err error
+		if classOfService, err = b2bua.LoadClassOfServicePolicy(classOfServiceFile); err != nil {
+			fmt.Printf("Invalid class of service policy: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	cdrWriters := cdr.MultiWriter{}
+	if len(cdrJSON) > 0 {
+		writer, err := cdr.NewJSONWriter(cdrJSON)
+		if err != nil {
+			fmt.Printf("CDR file %s: %v\n", cdrJSON, err)
+			os.Exit(1)
+		}
+		cdrWriters = append(cdrWriters, writer)
+	}
+	if len(cdrCSV) > 0 {
+		writer, err := cdr.NewCSVWriter(cdrCSV)
+		if err != nil {
+			fmt.Printf("CDR file %s: %v\n", cdrCSV, err)
+			os.Exit(1)
+		}
+		cdrWriters = append(cdrWriters, writer)
+	}
+	var stateStore b2bua.StateStore
+	if len(stateDir) > 0 {
+		store, err := b2bua.NewFileStateStore(stateDir)
+		if err != nil {
+			fmt.Printf("Invalid state directory %s: %v\n", stateDir, err)
+			os.Exit(1)
+		}
+		stateStore = store
+	}
+	redactions := map[string]*utils.Redaction{}
+	for sink, value := range map[string]string{"logs": redactLogs, "trace": redactTrace, "history": redactHistory} {
+		redaction, err := utils.ParseRedaction(value)
+		if err != nil {
+			fmt.Printf("Invalid %s redaction: %v\n", sink, err)
+			os.Exit(1)
+		}
+		redactions[sink] = redaction
+	}
+	traceLogger := b2bua.TraceLogger
+	var intercom *b2bua.IntercomPolicy
+	if len(intercomCallers) > 0 {
+		intercom = b2bua.NewIntercomPolicy(strings.Split(intercomCallers, ",")...)
+	}
+	var keepalive *b2bua.KeepalivePolicy
+	if len(keepaliveMethod) > 0 {
+		if keepaliveMethod != string(b2bua.KeepaliveOptions) && keepaliveMethod != string(b2bua.KeepaliveReInvite) {
+			fmt.Printf("Invalid -keepalive %q, options or reinvite\n", keepaliveMethod)
+			os.Exit(1)
+		}
+		keepalive = b2bua.NewKeepalivePolicy(b2bua.KeepaliveMethod(keepaliveMethod), keepaliveInterval, keepaliveFailures)
+	}
+	earlyMediaPolicy := b2bua.EarlyMediaPolicy{Mode: b2bua.EarlyMediaMode(earlyMedia)}
+	if i := strings.Index(earlyMedia, ":"); i >= 0 {
+		earlyMediaPolicy = b2bua.EarlyMediaPolicy{Mode: b2bua.EarlyMediaMode(earlyMedia[:i]), Transport: earlyMedia[i+1:]}
+	}
+	switch earlyMediaPolicy.Mode {
+	case b2bua.EarlyMediaFirst, b2bua.EarlyMediaNone:
+	case b2bua.EarlyMediaPreferred:
+		if len(earlyMediaPolicy.Transport) == 0 {
+			fmt.Printf("Invalid -early-media %q, preferred:<transport>\n", earlyMedia)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Invalid -early-media %q, first, preferred:<transport> or none\n", earlyMedia)
+		os.Exit(1)
+	}
+	forkingPolicy := b2bua.ForkingPolicy{Mode: b2bua.ForkingMode(forking), RingTimeout: ringTimeout}
+	if forkingPolicy.Mode != b2bua.ForkingParallel && forkingPolicy.Mode != b2bua.ForkingSequential {
+		fmt.Printf("Invalid -forking %q, parallel or sequential\n", forking)
+		os.Exit(1)
+	}
+	if trying != string(b2bua.TryingImmediate) && trying != string(b2bua.TryingAfterRouting) {
+		fmt.Printf("Invalid -trying %q, immediate or routing\n", trying)
+		os.Exit(1)
+	}
+	tryingPolicy := b2bua.TryingPolicy(trying)
+	var breaker *b2bua.TrunkBreaker
+	if trunkBreaker {
+		breaker = b2bua.NewTrunkBreaker(0, trunkMinASR, trunkMaxTimeouts)
+		breaker.ProbeInterval = trunkProbe
+	}
+	destinationMatches, destinationPolicies := []string{}, []b2bua.DestinationPolicy{}
+	for _, entry := range splitList(destinations) {
+		i := strings.Index(entry, "=")
+		parts := strings.SplitN(entry[i+1:], "/", 4)
+		if i <= 0 || len(parts) < 3 || (len(parts) == 4 && parts[3] != "tel") {
+			fmt.Printf("Invalid -destinations %s, expected match=transport/family/source[/tel]\n", entry)
+			os.Exit(1)
+		}
+		policy := b2bua.DestinationPolicy{Transport: parts[0], Family: stack.IPFamily(parts[1]), TelURI: len(parts) == 4}
+		if len(parts[2]) > 0 {
+			if policy.Source = net.ParseIP(parts[2]); policy.Source == nil {
+				fmt.Printf("Invalid -destinations %s, source %s is not an IP address\n", entry, parts[2])
+				os.Exit(1)
+			}
+		}
+		destinationMatches = append(destinationMatches, entry[:i])
+		destinationPolicies = append(destinationPolicies, policy)
+	}
+	headerPolicy := &b2bua.HeaderPolicy{Strip: splitList(stripHeaders), Copy: splitList(copyHeaders)}
+	locales := map[string]*b2bua.Locale{}
+	if len(localesFile) > 0 {
+		var err error
+		if locales, err = b2bua.LoadLocales(localesFile); err != nil {
+			fmt.Printf("Invalid -locales %s: %v\n", localesFile, err)
+			os.Exit(1)
+		}
+	}
+	registrationWebhooks := map[string]*b2bua.RegistrationWebhook{}
+	for _, entry := range strings.Split(regWebhooks, ",") {
+		if len(entry) == 0 {
+			continue
+		}
+		tenant, url := "*", entry
+		if i := strings.Index(entry, "="); i >= 0 && !strings.Contains(entry[:i], "/") {
+			tenant, url = entry[:i], entry[i+1:]
+		}
+		registrationWebhooks[tenant] = b2bua.NewRegistrationWebhook(url, regWebhookSecret, 0)
+	}
+	mediaSecurityPolicies := map[string]b2bua.MediaSecurityPolicy{}
+	for _, entry := range strings.Split(mediaSecurity, ",") {
+		if len(entry) == 0 {
+			continue
+		}
+		prefix, value := "", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			prefix, value = entry[:i], entry[i+1:]
+		}
+		policy, err := b2bua.ParseMediaSecurityPolicy(value)
+		if err != nil {
+			fmt.Printf("Invalid -media-security: %v\n", err)
+			os.Exit(1)
+		}
+		mediaSecurityPolicies[prefix] = policy
+	}
+	var liveness *b2bua.LivenessPolicy
+	if natPing > 0 {
+		liveness = b2bua.NewLivenessPolicy(uint32(natExpires), natPing, 0)
+	}
+	var history *b2bua.MessageHistory
+	if historyCalls > 0 {
+		history = b2bua.NewMessageHistory(0, historyCalls)
+		history.SetRedaction(redactions["history"])
+	}
+	listen.DisableAuth = disableAuth
+	b2bua, err := b2bua.NewB2BUAWithConfig(listen, options...)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	// Stats snapshot for the legacy monitoring tools, next to pprof.
+	http.Handle("/stats", b2bua.StatsHandler())
+	for prefix := range utils.GetLoggers() {
+		redaction := redactions["logs"]
+		if prefix == traceLogger {
+			redaction = redactions["trace"]
+		}
+		utils.SetLogRedaction(prefix, redaction)
+	}
+	b2bua.SetIntercomPolicy(intercom)
+	b2bua.SetMediaConfig(mediaConfig)
+	for prefix, policy := range mediaSecurityPolicies {
+		b2bua.SetMediaSecurityPolicy(prefix, policy)
+	}
+	if liveness != nil {
+		b2bua.SetLivenessPolicy(liveness)
+	}
+	if history != nil {
+		b2bua.SetMessageHistory(history)
+	}
+	if keepalive != nil {
+		b2bua.SetKeepalivePolicy(keepalive)
+	}
+	b2bua.SetEarlyMediaPolicy(earlyMediaPolicy)
+	b2bua.SetForkingPolicy(forkingPolicy)
+	b2bua.SetOnNetBypass(onNetBypass)
+	b2bua.SetTryingPolicy(tryingPolicy)
+	b2bua.SetProvisionalRefresh(provisionalRefresh)
+	b2bua.SetPostDialDelayThreshold(pddThreshold)
+	b2bua.SetSetupTimeout(setupTimeout)
+	if breaker != nil {
+		b2bua.SetTrunkBreaker(breaker)
+	}
+	for i, match := range destinationMatches {
+		if err := b2bua.AddDestinationRule(match, destinationPolicies[i]); err != nil {
+			fmt.Printf("Invalid -destinations: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	for language, locale := range locales {
+		b2bua.SetLocale(language, locale)
+	}
+	for _, entry := range splitList(languages) {
+		account, language := "", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			account, language = entry[:i], entry[i+1:]
+		}
+		b2bua.SetLanguage(account, language)
+	}
+	if len(headerPolicy.Strip) > 0 || len(headerPolicy.Copy) > 0 {
+		b2bua.SetHeaderPolicy("", headerPolicy)
+	}
+	if retention.CDRs > 0 || retention.Recordings > 0 || retention.History > 0 || retention.Registrations > 0 || len(retention.RecordingsDir) > 0 {
+		b2bua.SetRetentionPolicy(&retention)
+	}
+	if len(auditOptions.Path) > 0 {
+		auditOptions.Format = audit.Format(auditFormat)
+		auditLog, err := audit.NewLog(auditOptions)
+		if err != nil {
+			fmt.Printf("Audit log: %v\n", err)
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+		b2bua.SetAuditLog(auditLog)
+	}
+
+	if len(redisAddr) > 0 {
+		client := redis.NewClient(&redis.Options{Addr: redisAddr})
+		defer client.Close()
+		b2bua.SetRegistry(registry.NewRedisRegistry(client, redisPrefix))
+	} else if registryShards > 0 {
+		b2bua.SetRegistry(registry.NewShardedRegistry(registryShards))
+	}
+
+	if len(natsURL) > 0 || len(kafkaBrokers) > 0 {
+		publisherOptions := events.PublisherOptions{
+			Topics: map[string]string{"call": "sip-calls", "registration": "sip-registrations"},
+		}
+		if eventsFormat == "protobuf" {
+			publisherOptions.Serializer = events.Protobuf
+		}
+		bus := events.NewBus(0)
+		if len(natsURL) > 0 {
+			conn, err := nats.Connect(natsURL)
+			if err != nil {
+				fmt.Printf("NATS connect failed: %v\n", err)
+				os.Exit(1)
+			}
+			bus.Subscribe(events.NewNATSPublisher(conn, publisherOptions))
+		}
+		if len(kafkaBrokers) > 0 {
+			bus.Subscribe(events.NewKafkaPublisher(strings.Split(kafkaBrokers, ","), publisherOptions))
+		}
+		b2bua.SetEventBus(bus)
+	}
+
+	if len(radiusServer) > 0 || len(radiusAccounting) > 0 {
+		client := radius.NewClient(radius.Options{
+			Server:           radiusServer,
+			AccountingServer: radiusAccounting,
+			Secret:           radiusSecret,
+			NASIdentifier:    "b2bua",
+		})
+		if len(radiusServer) > 0 {
+			b2bua.SetDigestVerifier(client.VerifyDigest)
+		}
+		if len(radiusAccounting) > 0 {
+			b2bua.SetAccounting(client, 0)
+		}
+	}
+
+	var accountsAdmin, accountsSelfService http.Handler
+	if len(accountOptions.Path) > 0 {
+		accountOptions.Hash = accounts.Hash(accountHash)
+		store, err := accounts.NewStore(accountOptions)
+		if err != nil {
+			fmt.Printf("Accounts: %v\n", err)
+			os.Exit(1)
+		}
+		accountsAdmin = store.AdminHandler()
+		accountsSelfService = store.SelfServiceHandler()
+		if len(ldapOptions.URL) == 0 {
+			b2bua.SetCredentialProvider(store.Credential)
+		}
+	}
+
+	for tenant, webhook := range registrationWebhooks {
+		b2bua.SetRegistrationWebhook(tenant, webhook)
+	}
+
+	if len(ldapOptions.URL) > 0 {
+		provider := ldapauth.NewProvider(ldapOptions)
+		defer provider.Close()
+		b2bua.SetCredentialProvider(provider.RequestCredential)
+	}
+
+	if len(jwtKey) > 0 {
+		pem, err := ioutil.ReadFile(jwtKey)
+		if err == nil {
+			if jwtOptions.Key, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+				jwtOptions.Key, err = jwt.ParseECPublicKeyFromPEM(pem)
+			}
+		}
+		if err != nil {
+			fmt.Printf("Invalid JWT key %s: %v\n", jwtKey, err)
+			os.Exit(1)
+		}
+		b2bua.SetBearerVerifier(jwtauth.NewValidator(jwtOptions).Verify, jwtOptions.Issuer)
+	}
+
+	if len(routeScript) > 0 {
+		engine, err := script.NewEngine(routeScript)
+		if err != nil {
+			fmt.Printf("Invalid route script %s: %v\n", routeScript, err)
+			os.Exit(1)
+		}
+		engine.Watch(2 * time.Second)
+		defer engine.Close()
+		b2bua.SetRouteHandler(engine.Route)
+	} else if router != nil {
+		b2bua.SetRouteHandler(router.Route)
+	} else if dialPlan != nil {
+		b2bua.SetRouter(dialPlan)
+	}
+	if thirdPartyACL != nil {
+		b2bua.SetRegisterAuthorizer(thirdPartyACL.Allowed)
+	}
+	if classOfService != nil {
+		b2bua.SetCallAuthorizer(classOfService.Authorize)
+	}
+	if numberingTables != nil {
+		b2bua.SetNumbering(numberingTables)
+	}
+	if enumResolver != nil {
+		b2bua.SetENUM(enumResolver)
+	}
+	if len(cdrWriters) == 1 {
+		b2bua.SetCDRWriter(cdrWriters[0])
+	} else if len(cdrWriters) > 1 {
+		b2bua.SetCDRWriter(cdrWriters)
+	}
+
+	if len(haOptions.ID) > 0 {
+		node := ha.NewNode(haOptions)
+		b2bua.SetRegistry(ha.NewRegistry(b2bua.GetRegistry(), node))
+		// The calls are replicated to the peer, not recovered from the disk.
+		stateStore = nil
+		b2bua.SetStateStore(ha.NewStateStore(node), 0)
+		node.OnActive(func() {
+			if len(haVIP.Address) > 0 {
+				if err := haVIP.Acquire(); err != nil {
+					fmt.Printf("Virtual IP: %v\n", err)
+				}
+			}
+			if n, err := b2bua.AdoptCalls(); err == nil && n > 0 {
+				fmt.Printf("Adopted %d calls of the peer\n", n)
+			}
+		})
+		node.OnStandby(func() {
+			if len(haVIP.Address) > 0 {
+				haVIP.Release()
+			}
+		})
+		if err := node.Start(); err != nil {
+			fmt.Printf("HA node: %v\n", err)
+			os.Exit(1)
+		}
+		defer node.Stop()
+	}
+
+	if stateStore != nil {
+		b2bua.SetStateStore(stateStore, 0)
+		// On a handoff the calls in the store still belong to the previous process.
+		if len(handoff) == 0 {
+			if n, err := b2bua.RecoverCalls(); err != nil {
+				fmt.Printf("Call recovery failed: %v\n", err)
+			} else if n > 0 {
+				fmt.Printf("Released %d calls of the previous process\n", n)
+			}
+		}
+	}
+
+	// The Redis registry outlives the restarts, the one in memory is handed over with the sockets.
+	if len(redisAddr) == 0 {
+		tookOver := 0
+		if len(handoff) > 0 {
+			b2bua.HandOverRegistry()
+			var err error
+			if tookOver, err = b2bua.TakeOverRegistry(); err != nil {
+				fmt.Printf("Registry takeover failed: %v\n", err)
+			} else if tookOver > 0 {
+				fmt.Printf("Took over %d bindings of the previous process\n", tookOver)
+			}
+		}
+		if len(registrySnapshot.Path) > 0 {
+			b2bua.SetRegistrySnapshot(&registrySnapshot)
+			// The snapshot is older than the bindings taken over.
+			if tookOver == 0 {
+				if n, err := b2bua.RestoreRegistry(); err != nil {
+					fmt.Printf("Registry restore failed: %v\n", err)
+				} else if n > 0 {
+					fmt.Printf("Restored %d bindings\n", n)
+				}
+			}
+		}
+	}
+
+	if len(adminListen) > 0 {
+		if err := adminAuth.CheckListen(adminListen); err != nil {
+			fmt.Printf("Admin API on %s: %v\n", adminListen, err)
+			os.Exit(1)
+		}
+		admin := http.NewServeMux()
+		admin.Handle("/", adminAuth.Handler(b2bua.AdminHandler(accountsAdmin)))
+		if accountsSelfService != nil {
+			// Authenticated by the current password of the user.
+			admin.Handle("/accounts/password", accountsSelfService)
+		}
+		go func() {
+			fmt.Printf("Start admin API on %s\n", adminListen)
+			http.ListenAndServe(adminListen, b2bua.AuditHandler(admin))
+		}()
+	}
+
+	if len(probeListen) > 0 {
+		go func() {
+			fmt.Printf("Start probes on %s\n", probeListen)
+			http.ListenAndServe(probeListen, b2bua.HealthHandler(drainTimeout))
+		}()
+	}
 
 	// Add sample accounts.
 	b2bua.AddAccount("100", "100")
@@ -154,6 +1024,17 @@ func main() {
 	b2bua.AddAccount("300", "300")
 	b2bua.AddAccount("400", "400")
 
+	// The new process owns the sockets now, drain the calls and leave.
+	b2bua.OnHandoff(func() {
+		select {
+		case <-b2bua.Drain():
+		case <-time.After(drainTimeout):
+			fmt.Printf("%d calls left after %v of drain\n", len(b2bua.Calls()), drainTimeout)
+		}
+		b2bua.Shutdown()
+		os.Exit(0)
+	})
+
 	if !noconsole {
 		consoleLoop(b2bua)
 		return
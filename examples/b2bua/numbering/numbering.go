@@ -0,0 +1,193 @@
+package numbering
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// separators the visual separators removed from the dialed numbers, e.g. "+33 (0)1 23-45.67".
+var separators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+
+// dialable the dialed numbers the tables translate, the other users, e.g. alice, are left as is.
+var dialable = regexp.MustCompile(`^\+?[0-9*#]+$`)
+
+// Rule a translation of the dialed numbers matching its Prefix, its Pattern, or both.
+type Rule struct {
+	Prefix  string `json:"prefix,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	// Rewrite the number matched by Pattern into this template, with $1 for its first group.
+	Rewrite string `json:"rewrite,omitempty"`
+	// Strip the digits removed from the start of the number, then Add prepended.
+	Strip int    `json:"strip,omitempty"`
+	Add   string `json:"add,omitempty"`
+	// Continue with the next rules once applied, the first matching rule is the last one otherwise.
+	Continue bool `json:"continue,omitempty"`
+	pattern  *regexp.Regexp
+}
+
+// compile the pattern.
+func (r *Rule) compile() error {
+	if len(r.Pattern) == 0 {
+		return nil
+	}
+	pattern, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("bad pattern %s: %v", r.Pattern, err)
+	}
+	r.pattern = pattern
+	return nil
+}
+
+// match whether the rule matches number.
+func (r *Rule) match(number string) bool {
+	if !strings.HasPrefix(number, r.Prefix) {
+		return false
+	}
+	return r.pattern == nil || r.pattern.MatchString(number)
+}
+
+// apply the rule to number.
+func (r *Rule) apply(number string) string {
+	if r.pattern != nil && len(r.Rewrite) > 0 {
+		number = r.pattern.ReplaceAllString(number, r.Rewrite)
+	}
+	if r.Strip >= len(number) {
+		number = ""
+	} else if r.Strip > 0 {
+		number = number[r.Strip:]
+	}
+	return r.Add + number
+}
+
+// E164 the normalization of the national and international numbers to E.164, +33123456789.
+type E164 struct {
+	// CountryCode prepended to the national numbers, e.g. 33.
+	CountryCode string `json:"country_code"`
+	// NationalPrefix of the national numbers, e.g. 0, InternationalPrefix of the international ones, e.g. 00.
+	NationalPrefix      string `json:"national_prefix"`
+	InternationalPrefix string `json:"international_prefix"`
+}
+
+// Normalize number to E.164, the short numbers, e.g. the extensions, are left as is.
+func (e *E164) Normalize(number string) string {
+	switch {
+	case strings.HasPrefix(number, "+"):
+		return number
+	case len(e.InternationalPrefix) > 0 && strings.HasPrefix(number, e.InternationalPrefix):
+		return "+" + number[len(e.InternationalPrefix):]
+	case len(e.NationalPrefix) > 0 && len(e.CountryCode) > 0 && strings.HasPrefix(number, e.NationalPrefix):
+		return "+" + e.CountryCode + number[len(e.NationalPrefix):]
+	}
+	return number
+}
+
+// Table the translation of the numbers dialed by a tenant or received from a trunk: the separators are
+// removed, the Rules applied, then the number normalized to E.164 if E164 is set.
+type Table struct {
+	Rules []*Rule `json:"rules"`
+	E164  *E164   `json:"e164,omitempty"`
+}
+
+// Compile the patterns of the rules.
+func (t *Table) Compile() error {
+	for _, rule := range t.Rules {
+		if err := rule.compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Translate number, unchanged if it isn't a dialable number.
+func (t *Table) Translate(number string) string {
+	if t == nil {
+		return number
+	}
+	cleaned := separators.Replace(number)
+	if !dialable.MatchString(cleaned) {
+		return number
+	}
+	number = cleaned
+	for _, rule := range t.Rules {
+		if !rule.match(number) {
+			continue
+		}
+		number = rule.apply(number)
+		if !rule.Continue {
+			break
+		}
+	}
+	if t.E164 != nil {
+		number = t.E164.Normalize(number)
+	}
+	return number
+}
+
+// Tables the translation tables by trunk and by tenant, a domain, Default for the others.
+type Tables struct {
+	Default *Table            `json:"default,omitempty"`
+	Tenants map[string]*Table `json:"tenants,omitempty"`
+	Trunks  map[string]*Table `json:"trunks,omitempty"`
+}
+
+// NewTables .
+func NewTables() *Tables {
+	return &Tables{Tenants: make(map[string]*Table), Trunks: make(map[string]*Table)}
+}
+
+// Load the Tables of the JSON object of path.
+func Load(path string) (*Tables, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tables := NewTables()
+	if err := json.Unmarshal(data, tables); err != nil {
+		return nil, fmt.Errorf("bad numbering tables %s: %v", path, err)
+	}
+	if err := tables.Compile(); err != nil {
+		return nil, fmt.Errorf("bad numbering tables %s: %v", path, err)
+	}
+	return tables, nil
+}
+
+// Compile the patterns of the tables, the tenants are case insensitive.
+func (t *Tables) Compile() error {
+	tenants := make(map[string]*Table, len(t.Tenants))
+	for tenant, table := range t.Tenants {
+		tenants[strings.ToLower(tenant)] = table
+	}
+	t.Tenants = tenants
+	if t.Default != nil {
+		if err := t.Default.Compile(); err != nil {
+			return err
+		}
+	}
+	for _, tables := range []map[string]*Table{t.Tenants, t.Trunks} {
+		for name, table := range tables {
+			if err := table.Compile(); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// For the table of the calls from trunk, else to tenant, else the default one, nil if none.
+func (t *Tables) For(tenant string, trunk string) *Table {
+	if table, found := t.Trunks[trunk]; found && len(trunk) > 0 {
+		return table
+	}
+	if table, found := t.Tenants[strings.ToLower(tenant)]; found {
+		return table
+	}
+	return t.Default
+}
+
+// Translate number dialed to tenant, or received from trunk.
+func (t *Tables) Translate(tenant string, trunk string, number string) string {
+	return t.For(tenant, trunk).Translate(number)
+}
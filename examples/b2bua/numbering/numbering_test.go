@@ -0,0 +1,32 @@
+package numbering
+
+import (
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	tables := NewTables()
+	tables.Default = &Table{
+		Rules: []*Rule{
+			{Prefix: "9", Strip: 1, Continue: true},
+			{Pattern: `^(\d{4})$`, Rewrite: "+3312345$1"},
+		},
+		E164: &E164{CountryCode: "33", NationalPrefix: "0", InternationalPrefix: "00"},
+	}
+	tables.Tenants["Example.COM"] = &Table{E164: &E164{CountryCode: "44", NationalPrefix: "0", InternationalPrefix: "00"}}
+	tables.Trunks["carrier"] = &Table{Rules: []*Rule{{Prefix: "", Add: "0"}}, E164: &E164{CountryCode: "33", NationalPrefix: "0"}}
+	if err := tables.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	mismatches := tables.Verify([]Case{
+		{Number: "01 23-45.67.89", Expected: "+33123456789"},
+		{Number: "90044207946", Expected: "+44207946"},
+		{Number: "6789", Expected: "+33123456789"},
+		{Number: "alice", Expected: "alice"},
+		{Tenant: "example.com", Number: "020 7946 0000", Expected: "+442079460000"},
+		{Trunk: "carrier", Number: "123456789", Expected: "+33123456789"},
+	})
+	for _, mismatch := range mismatches {
+		t.Error(mismatch)
+	}
+}
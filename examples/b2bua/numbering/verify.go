@@ -0,0 +1,49 @@
+package numbering
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Case an expected translation, to verify the tables before they are deployed.
+type Case struct {
+	Tenant   string `json:"tenant,omitempty"`
+	Trunk    string `json:"trunk,omitempty"`
+	Number   string `json:"number"`
+	Expected string `json:"expected"`
+}
+
+// Mismatch a case the tables translate otherwise, into Got.
+type Mismatch struct {
+	Case
+	Got string `json:"got"`
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s (tenant %q, trunk %q): got %s, expected %s", m.Number, m.Tenant, m.Trunk, m.Got, m.Expected)
+}
+
+// LoadCases the JSON array of the Cases of path.
+func LoadCases(path string) ([]Case, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cases := []Case{}
+	if err := json.Unmarshal(data, &cases); err != nil {
+		return nil, fmt.Errorf("bad numbering cases %s: %v", path, err)
+	}
+	return cases, nil
+}
+
+// Verify the translation of each case, returns the mismatches.
+func (t *Tables) Verify(cases []Case) []Mismatch {
+	mismatches := []Mismatch{}
+	for _, c := range cases {
+		if got := t.Translate(c.Tenant, c.Trunk, c.Number); got != c.Expected {
+			mismatches = append(mismatches, Mismatch{Case: c, Got: got})
+		}
+	}
+	return mismatches
+}
@@ -0,0 +1,59 @@
+package radius
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+)
+
+// Start send the Accounting-Request Start of an answered call.
+func (c *Client) Start(record *cdr.Record) error {
+	return c.account(rfc2866.AcctStatusType_Value_Start, record)
+}
+
+// Interim send an Accounting-Request Interim-Update of an answered call.
+func (c *Client) Interim(record *cdr.Record) error {
+	return c.account(rfc2866.AcctStatusType_Value_InterimUpdate, record)
+}
+
+// Stop send the Accounting-Request Stop of a released call.
+func (c *Client) Stop(record *cdr.Record) error {
+	return c.account(rfc2866.AcctStatusType_Value_Stop, record)
+}
+
+func (c *Client) account(status rfc2866.AcctStatusType, record *cdr.Record) error {
+	packet := radius.New(radius.CodeAccountingRequest, []byte(c.options.Secret))
+	rfc2866.AcctStatusType_Set(packet, status)
+	rfc2866.AcctSessionID_SetString(packet, record.CallID)
+	rfc2865.UserName_SetString(packet, record.Caller)
+	rfc2865.CallingStationID_SetString(packet, record.Caller)
+	rfc2865.CalledStationID_SetString(packet, record.Called)
+	if len(c.options.NASIdentifier) > 0 {
+		rfc2865.NASIdentifier_SetString(packet, c.options.NASIdentifier)
+	}
+	switch status {
+	case rfc2866.AcctStatusType_Value_Start:
+		rfc2869.EventTimestamp_Set(packet, record.Answered)
+	case rfc2866.AcctStatusType_Value_Stop:
+		rfc2869.EventTimestamp_Set(packet, record.Ended)
+		rfc2866.AcctSessionTime_Set(packet, rfc2866.AcctSessionTime(record.Duration/time.Second))
+		rfc2866.AcctTerminateCause_Set(packet, rfc2866.AcctTerminateCause_Value_UserRequest)
+	default:
+		rfc2869.EventTimestamp_Set(packet, time.Now())
+		rfc2866.AcctSessionTime_Set(packet, rfc2866.AcctSessionTime(record.Duration/time.Second))
+	}
+
+	response, err := c.exchange(packet, c.options.AccountingServer)
+	if err != nil {
+		return err
+	}
+	if response.Code != radius.CodeAccountingResponse {
+		return fmt.Errorf("radius: unexpected %v", response.Code)
+	}
+	return nil
+}
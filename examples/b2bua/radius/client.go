@@ -0,0 +1,81 @@
+package radius
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"errors"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+	"layeh.com/radius"
+)
+
+var (
+	logger log.Logger
+)
+
+func init() {
+	logger = utils.NewLogrusLogger(log.InfoLevel, "RADIUS", nil)
+}
+
+// Options .
+type Options struct {
+	// Server host:port of the authentication server, 1812 by convention.
+	Server string
+	// AccountingServer host:port of the accounting server, 1813 by convention, empty to disable accounting.
+	AccountingServer string
+	Secret           string
+	// NASIdentifier identity of the B2BUA in the requests.
+	NASIdentifier string
+	// Timeout of an exchange including the retransmissions, 5s by default.
+	Timeout time.Duration
+	// Retry retransmission interval, 1s by default.
+	Retry time.Duration
+}
+
+// Client RADIUS authentication and accounting client.
+type Client struct {
+	options Options
+	client  *radius.Client
+}
+
+// NewClient .
+func NewClient(options Options) *Client {
+	if options.Timeout <= 0 {
+		options.Timeout = 5 * time.Second
+	}
+	if options.Retry <= 0 {
+		options.Retry = time.Second
+	}
+	return &Client{
+		options: options,
+		client: &radius.Client{
+			Retry:           options.Retry,
+			MaxPacketErrors: 10,
+		},
+	}
+}
+
+func (c *Client) exchange(packet *radius.Packet, server string) (*radius.Packet, error) {
+	if len(server) == 0 {
+		return nil, errors.New("radius: no server")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.options.Timeout)
+	defer cancel()
+	return c.client.Exchange(ctx, packet, server)
+}
+
+// signMessage add the Message-Authenticator, required with the digest attributes (RFC 5090).
+func signMessage(packet *radius.Packet) error {
+	packet.Set(attrMessageAuthenticator, make(radius.Attribute, md5.Size))
+	encoded, err := packet.Encode()
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(md5.New, packet.Secret)
+	mac.Write(encoded)
+	packet.Set(attrMessageAuthenticator, mac.Sum(nil))
+	return nil
+}
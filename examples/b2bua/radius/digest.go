@@ -0,0 +1,85 @@
+package radius
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/auth"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+)
+
+// RFC 5090 attributes.
+const (
+	attrMessageAuthenticator radius.Type = 80
+	attrDigestResponse       radius.Type = 103
+	attrDigestRealm          radius.Type = 104
+	attrDigestNonce          radius.Type = 105
+	attrDigestMethod         radius.Type = 108
+	attrDigestURI            radius.Type = 109
+	attrDigestQop            radius.Type = 110
+	attrDigestAlgorithm      radius.Type = 111
+	attrDigestEntityBodyHash radius.Type = 112
+	attrDigestCNonce         radius.Type = 113
+	attrDigestNonceCount     radius.Type = 114
+	attrDigestUsername       radius.Type = 115
+)
+
+type stringAttr struct {
+	key   radius.Type
+	value string
+}
+
+// VerifyDigest verify a SIP digest response by the RADIUS server (RFC 5090), the password
+// never leaves the AAA infrastructure. Use it as the auth.VerifyDigestCallback of the B2BUA.
+func (c *Client) VerifyDigest(credentials auth.DigestCredentials) (bool, error) {
+	packet := radius.New(radius.CodeAccessRequest, []byte(c.options.Secret))
+	rfc2865.UserName_SetString(packet, credentials.Username)
+	if len(c.options.NASIdentifier) > 0 {
+		rfc2865.NASIdentifier_SetString(packet, c.options.NASIdentifier)
+	}
+	attrs := []stringAttr{
+		{attrDigestResponse, credentials.Response},
+		{attrDigestRealm, credentials.Realm},
+		{attrDigestNonce, credentials.Nonce},
+		{attrDigestMethod, credentials.Method},
+		{attrDigestURI, credentials.URI},
+		{attrDigestQop, credentials.Qop},
+		{attrDigestAlgorithm, credentials.Algorithm},
+		{attrDigestCNonce, credentials.CNonce},
+		{attrDigestNonceCount, credentials.NonceCount},
+		{attrDigestUsername, credentials.Username},
+	}
+	if credentials.Qop == "auth-int" {
+		sum := md5.Sum([]byte(credentials.Body))
+		attrs = append(attrs, stringAttr{attrDigestEntityBodyHash, hex.EncodeToString(sum[:])})
+	}
+	for _, attr := range attrs {
+		if len(attr.value) == 0 {
+			continue
+		}
+		value, err := radius.NewString(attr.value)
+		if err != nil {
+			return false, err
+		}
+		packet.Add(attr.key, value)
+	}
+	if err := signMessage(packet); err != nil {
+		return false, err
+	}
+
+	response, err := c.exchange(packet, c.options.Server)
+	if err != nil {
+		return false, err
+	}
+	switch response.Code {
+	case radius.CodeAccessAccept:
+		return true, nil
+	case radius.CodeAccessReject:
+		logger.Infof("Access rejected for %s", credentials.Username)
+		return false, nil
+	}
+	// Access-Challenge is not part of the SIP digest exchange.
+	logger.Warnf("Unexpected %v for %s", response.Code, credentials.Username)
+	return false, nil
+}
@@ -0,0 +1,149 @@
+package radius
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/cdr"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/auth"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+)
+
+const testSecret = "testing123"
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// serve answer the packets received on a local address with handler, returns the address.
+func serve(t *testing.T, handler radius.HandlerFunc) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &radius.PacketServer{Handler: handler, SecretSource: radius.StaticSecretSource([]byte(testSecret))}
+	go server.Serve(conn)
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+// messageAuthenticated the Message-Authenticator of packet is valid, RFC 3579 3.2.
+func messageAuthenticated(packet *radius.Packet) bool {
+	received := packet.Get(attrMessageAuthenticator)
+	if len(received) != md5.Size {
+		return false
+	}
+	clone := *packet
+	clone.Attributes = make(radius.Attributes, 0, len(packet.Attributes))
+	for _, avp := range packet.Attributes {
+		clone.Attributes = append(clone.Attributes, &radius.AVP{Type: avp.Type, Attribute: avp.Attribute})
+	}
+	clone.Set(attrMessageAuthenticator, make(radius.Attribute, md5.Size))
+	encoded, err := clone.Encode()
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(md5.New, packet.Secret)
+	mac.Write(encoded)
+	return hmac.Equal(mac.Sum(nil), received)
+}
+
+func TestVerifyDigest(t *testing.T) {
+	passwords := map[string]string{"alice": "secret"}
+	address, stop := serve(t, func(w radius.ResponseWriter, r *radius.Request) {
+		code := radius.CodeAccessReject
+		username := radius.String(r.Get(attrDigestUsername))
+		password, found := passwords[username]
+		ha1 := md5Hex(fmt.Sprintf("%s:%s:%s", username, radius.String(r.Get(attrDigestRealm)), password))
+		ha2 := md5Hex(fmt.Sprintf("%s:%s", radius.String(r.Get(attrDigestMethod)), radius.String(r.Get(attrDigestURI))))
+		response := md5Hex(fmt.Sprintf("%s:%s:%s", ha1, radius.String(r.Get(attrDigestNonce)), ha2))
+		if found && messageAuthenticated(r.Packet) && rfc2865.UserName_GetString(r.Packet) == username &&
+			radius.String(r.Get(attrDigestResponse)) == response {
+			code = radius.CodeAccessAccept
+		}
+		w.Write(r.Response(code))
+	})
+	defer stop()
+
+	credentials := func(username string, password string) auth.DigestCredentials {
+		credentials := auth.DigestCredentials{
+			Username: username,
+			Realm:    "example.com",
+			Nonce:    "dcd98b7102dd2f0e8b11d0f600bfb0c093",
+			URI:      "sip:example.com",
+			Method:   "REGISTER",
+		}
+		ha1 := md5Hex(fmt.Sprintf("%s:%s:%s", username, credentials.Realm, password))
+		ha2 := md5Hex(fmt.Sprintf("%s:%s", credentials.Method, credentials.URI))
+		credentials.Response = md5Hex(fmt.Sprintf("%s:%s:%s", ha1, credentials.Nonce, ha2))
+		return credentials
+	}
+	client := NewClient(Options{Server: address, Secret: testSecret, NASIdentifier: "b2bua", Timeout: time.Second})
+	for _, test := range []struct {
+		name        string
+		credentials auth.DigestCredentials
+		accepted    bool
+	}{
+		{"valid", credentials("alice", "secret"), true},
+		{"wrong password", credentials("alice", "guess"), false},
+		{"unknown account", credentials("mallory", "secret"), false},
+	} {
+		accepted, err := client.VerifyDigest(test.credentials)
+		if err != nil || accepted != test.accepted {
+			t.Errorf("%s: accepted %v, %v, want %v", test.name, accepted, err, test.accepted)
+		}
+	}
+
+	// A wrong shared secret gets no valid answer.
+	wrong := NewClient(Options{Server: address, Secret: "wrong", Timeout: 300 * time.Millisecond, Retry: 100 * time.Millisecond})
+	if accepted, err := wrong.VerifyDigest(credentials("alice", "secret")); accepted || err == nil {
+		t.Errorf("wrong secret: accepted %v, %v", accepted, err)
+	}
+	if _, err := NewClient(Options{}).VerifyDigest(credentials("alice", "secret")); err == nil {
+		t.Error("no server: no error")
+	}
+}
+
+func TestAccounting(t *testing.T) {
+	statuses := make(chan rfc2866.AcctStatusType, 3)
+	address, stop := serve(t, func(w radius.ResponseWriter, r *radius.Request) {
+		if r.Code != radius.CodeAccountingRequest || rfc2866.AcctSessionID_GetString(r.Packet) != "call-1" {
+			return
+		}
+		statuses <- rfc2866.AcctStatusType_Get(r.Packet)
+		w.Write(r.Response(radius.CodeAccountingResponse))
+	})
+	defer stop()
+
+	client := NewClient(Options{AccountingServer: address, Secret: testSecret, Timeout: time.Second})
+	answered := time.Now()
+	record := &cdr.Record{CallID: "call-1", Caller: "alice", Called: "bob", Answered: answered}
+	if err := client.Start(record); err != nil {
+		t.Fatal(err)
+	}
+	record.Duration = time.Minute
+	if err := client.Interim(record); err != nil {
+		t.Fatal(err)
+	}
+	record.Ended = answered.Add(2 * time.Minute)
+	record.Duration = 2 * time.Minute
+	if err := client.Stop(record); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []rfc2866.AcctStatusType{
+		rfc2866.AcctStatusType_Value_Start,
+		rfc2866.AcctStatusType_Value_InterimUpdate,
+		rfc2866.AcctStatusType_Value_Stop,
+	} {
+		if got := <-statuses; got != want {
+			t.Errorf("Acct-Status-Type %v, want %v", got, want)
+		}
+	}
+}
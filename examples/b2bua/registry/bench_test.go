@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+func newBenchRegistry(b *testing.B, count int) (*MemoryRegistry, []sip.Uri) {
+	mr := NewMemoryRegistry()
+	return mr, fillBenchRegistry(b, mr, count)
+}
+
+func fillBenchRegistry(b *testing.B, r Registry, count int) []sip.Uri {
+	aors := make([]sip.Uri, 0, count)
+	for i := 0; i < count; i++ {
+		aor, err := parser.ParseUri(fmt.Sprintf("sip:%d@example.com", 1000+i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		contact, err := parser.ParseUri(fmt.Sprintf("sip:%d@192.0.2.1:5060", 1000+i))
+		if err != nil {
+			b.Fatal(err)
+		}
+		instance := &ContactInstance{
+			Contact:   &sip.ContactHeader{Address: contact},
+			Source:    fmt.Sprintf("192.0.2.1:%d", 10000+i),
+			Transport: "udp",
+		}
+		r.AddAor(aor, instance)
+		aors = append(aors, aor)
+	}
+	return aors
+}
+
+func BenchmarkGetContacts(b *testing.B) {
+	mr, aors := newBenchRegistry(b, 10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, found := mr.GetContacts(aors[i%len(aors)]); !found {
+			b.Fatal("contacts not found")
+		}
+	}
+}
+
+func BenchmarkAddAor(b *testing.B) {
+	mr, aors := newBenchRegistry(b, 10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		aor := aors[i%len(aors)]
+		instance := &ContactInstance{
+			Source:    "198.51.100.1:5060",
+			Transport: "udp",
+		}
+		mr.AddAor(aor, instance)
+	}
+}
+
+// benchmarkMixed lookups with a REGISTER every 10 of them, from parallel goroutines.
+func benchmarkMixed(b *testing.B, r Registry) {
+	aors := fillBenchRegistry(b, r, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			aor := aors[i%len(aors)]
+			if i%10 == 0 {
+				r.UpdateContact(aor, &ContactInstance{Source: "198.51.100.1:5060", Transport: "udp"})
+			} else if _, found := r.GetContacts(aor); !found {
+				b.Error("contacts not found")
+				return
+			}
+			i += 7
+		}
+	})
+}
+
+func BenchmarkMemoryRegistryParallel(b *testing.B) {
+	benchmarkMixed(b, NewMemoryRegistry())
+}
+
+func BenchmarkShardedRegistryParallel(b *testing.B) {
+	benchmarkMixed(b, NewShardedRegistry(0))
+}
@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// Binding a serializable binding of an AOR, e.g. to replicate or store the registrations.
+type Binding struct {
+	AOR         string    `json:"aor"`
+	Contact     string    `json:"contact"`
+	Expires     uint32    `json:"expires"`
+	LastUpdated uint32    `json:"last_updated,omitempty"`
+	Source      string    `json:"source"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	Transport   string    `json:"transport"`
+	InstanceID  string    `json:"instance_id,omitempty"`
+	RegID       string    `json:"reg_id,omitempty"`
+	Supported   []string  `json:"supported,omitempty"`
+	Allow       []string  `json:"allow,omitempty"`
+	Registered  time.Time `json:"registered"`
+}
+
+// NewBinding .
+func NewBinding(aor sip.Uri, instance *ContactInstance) *Binding {
+	binding := &Binding{
+		AOR:         aor.String(),
+		Expires:     instance.RegExpires,
+		LastUpdated: instance.LastUpdated,
+		Source:      instance.Source,
+		UserAgent:   instance.UserAgent,
+		Transport:   instance.Transport,
+		InstanceID:  instance.InstanceID,
+		RegID:       instance.RegID,
+		Supported:   instance.Supported,
+		Allow:       instance.Allow,
+		Registered:  instance.Registered,
+	}
+	if instance.Contact != nil {
+		binding.Contact = instance.Contact.Value()
+	}
+	return binding
+}
+
+// Instance the AOR and the contact instance of the binding.
+func (b *Binding) Instance() (sip.Uri, *ContactInstance, error) {
+	aor, err := parser.ParseSipUri(b.AOR)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad aor %s: %v", b.AOR, err)
+	}
+	instance := &ContactInstance{
+		RegExpires:  b.Expires,
+		LastUpdated: b.LastUpdated,
+		Source:      b.Source,
+		UserAgent:   b.UserAgent,
+		Transport:   b.Transport,
+		InstanceID:  b.InstanceID,
+		RegID:       b.RegID,
+		Supported:   b.Supported,
+		Allow:       b.Allow,
+		Registered:  b.Registered,
+	}
+	if len(b.Contact) > 0 {
+		displayName, uri, params, err := parser.ParseAddressValue(b.Contact)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad contact %s: %v", b.Contact, err)
+		}
+		instance.Contact = &sip.ContactHeader{DisplayName: displayName, Address: uri, Params: params}
+	}
+	return &aor, instance, nil
+}
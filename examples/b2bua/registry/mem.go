@@ -10,57 +10,62 @@ import (
 
 // MemoryRegistry Address-of-Record registry using memory.
 type MemoryRegistry struct {
-	mutex *sync.Mutex
+	mutex *sync.RWMutex
 	aors  map[sip.Uri]map[string]*ContactInstance
+	// users index of the aors keys by user, to avoid scanning aors on each lookup.
+	users map[string]sip.Uri
 }
 
 func NewMemoryRegistry() *MemoryRegistry {
 	mr := &MemoryRegistry{
 		aors:  make(map[sip.Uri]map[string]*ContactInstance),
-		mutex: new(sync.Mutex),
+		users: make(map[string]sip.Uri),
+		mutex: new(sync.RWMutex),
 	}
 	return mr
 }
 
+// AddAor add a binding of aor, aor is cloned when the first binding is added.
 func (mr *MemoryRegistry) AddAor(aor sip.Uri, instance *ContactInstance) error {
 	mr.mutex.Lock()
 	defer mr.mutex.Unlock()
-	instances, _ := findInstances(mr.aors, aor)
+	instances, _ := mr.findInstances(aor)
 	if instances != nil {
+		if ci, ok := (*instances)[instance.Source]; ok && !ci.Registered.IsZero() {
+			instance.Registered = ci.Registered
+		}
+		removeStaleBindings(*instances, instance)
 		(*instances)[instance.Source] = instance
 		return nil
-	} else {
-		mr.aors[aor] = make(map[string]*ContactInstance)
 	}
-	mr.aors[aor][instance.Source] = instance
+	key := aor.Clone()
+	mr.aors[key] = map[string]*ContactInstance{instance.Source: instance}
+	mr.users[userKey(key)] = key
 	return nil
 }
 
 func (mr *MemoryRegistry) RemoveAor(aor sip.Uri) error {
 	mr.mutex.Lock()
 	defer mr.mutex.Unlock()
-	for key := range mr.aors {
-		if key.Equals(aor) {
-			delete(mr.aors, key)
-		}
-	}
+	mr.removeAor(aor)
 	return nil
 }
 
 func (mr *MemoryRegistry) AorIsRegistered(aor sip.Uri) bool {
-	mr.mutex.Lock()
-	defer mr.mutex.Unlock()
-	_, ok := mr.aors[aor]
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
+	_, ok := mr.users[userKey(aor)]
 	return ok
 }
 
 func (mr *MemoryRegistry) UpdateContact(aor sip.Uri, instance *ContactInstance) error {
 	mr.mutex.Lock()
 	defer mr.mutex.Unlock()
-	instances, err := findInstances(mr.aors, aor)
+	instances, err := mr.findInstances(aor)
 	if err != nil {
 		return err
 	}
+	removeStaleBindings(*instances, instance)
 	(*instances)[instance.Source] = instance
 	return nil
 }
@@ -68,15 +73,12 @@ func (mr *MemoryRegistry) UpdateContact(aor sip.Uri, instance *ContactInstance)
 func (mr *MemoryRegistry) RemoveContact(aor sip.Uri, instance *ContactInstance) error {
 	mr.mutex.Lock()
 	defer mr.mutex.Unlock()
-	instances, err := findInstances(mr.aors, aor)
+	instances, err := mr.findInstances(aor)
 	if instances != nil {
 		delete(*instances, instance.Source)
+		removeStaleBindings(*instances, instance)
 		if len(*instances) == 0 {
-			for key := range mr.aors {
-				if key.Equals(aor) {
-					delete(mr.aors, key)
-				}
-			}
+			mr.removeAor(aor)
 		}
 		return nil
 	}
@@ -88,25 +90,21 @@ func (mr *MemoryRegistry) HandleConnectionError(connError *transport.ConnectionE
 	defer mr.mutex.Unlock()
 	result := false
 	for aor, cis := range mr.aors {
-		for source := range cis {
-			if source == connError.Source {
-				delete(cis, source)
-				result = true
-				break
-			}
+		if _, ok := cis[connError.Source]; ok {
+			delete(cis, connError.Source)
+			result = true
 		}
 		if len(cis) == 0 {
-			delete(mr.aors, aor)
-			break
+			mr.removeAor(aor)
 		}
 	}
 	return result
 }
 
 func (mr *MemoryRegistry) GetContacts(aor sip.Uri) (*map[string]*ContactInstance, bool) {
-	mr.mutex.Lock()
-	defer mr.mutex.Unlock()
-	instance, err := findInstances(mr.aors, aor)
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
+	instance, err := mr.findInstances(aor)
 	if err != nil {
 		return nil, false
 	}
@@ -114,17 +112,58 @@ func (mr *MemoryRegistry) GetContacts(aor sip.Uri) (*map[string]*ContactInstance
 	return instance, true
 }
 
+// GetDevices device metadata of each binding of aor.
+func (mr *MemoryRegistry) GetDevices(aor sip.Uri) ([]DeviceInfo, bool) {
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
+	instances, err := mr.findInstances(aor)
+	if err != nil {
+		return nil, false
+	}
+	devices := make([]DeviceInfo, 0, len(*instances))
+	for _, instance := range *instances {
+		devices = append(devices, instance.DeviceInfo())
+	}
+	return devices, true
+}
+
 func (mr *MemoryRegistry) GetAllContacts() map[sip.Uri]map[string]*ContactInstance {
-	mr.mutex.Lock()
-	defer mr.mutex.Unlock()
+	mr.mutex.RLock()
+	defer mr.mutex.RUnlock()
 	return mr.aors
 }
 
-func findInstances(aors map[sip.Uri]map[string]*ContactInstance, aor sip.Uri) (*map[string]*ContactInstance, error) {
-	for key, instances := range aors {
-		if key.User() == aor.User() {
+// removeStaleBindings remove the bindings of the same device registered from another address,
+// e.g. after a NAT rebinding.
+func removeStaleBindings(instances map[string]*ContactInstance, instance *ContactInstance) {
+	for source, ci := range instances {
+		if source != instance.Source && ci.SameBinding(instance) {
+			delete(instances, source)
+		}
+	}
+}
+
+func (mr *MemoryRegistry) findInstances(aor sip.Uri) (*map[string]*ContactInstance, error) {
+	if key, ok := mr.users[userKey(aor)]; ok {
+		if instances, ok := mr.aors[key]; ok {
 			return &instances, nil
 		}
 	}
 	return nil, fmt.Errorf("Not found instances for %v", aor)
 }
+
+func (mr *MemoryRegistry) removeAor(aor sip.Uri) {
+	user := userKey(aor)
+	if key, ok := mr.users[user]; ok {
+		delete(mr.aors, key)
+		delete(mr.users, user)
+	}
+}
+
+// userKey AORs are matched by user part.
+func userKey(aor sip.Uri) string {
+	if aor.User() == nil {
+		return ""
+	}
+	return aor.User().String()
+}
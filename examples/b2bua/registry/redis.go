@@ -0,0 +1,288 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/transport"
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	logger log.Logger
+)
+
+func init() {
+	logger = utils.NewLogrusLogger(log.InfoLevel, "Registry", nil)
+}
+
+const (
+	redisTimeout = 2 * time.Second
+	// redisRetries of the transactions on the bindings of an AOR updated by another instance meanwhile.
+	redisRetries = 8
+)
+
+// redisBinding a binding and its expiry, stored in the hash of its AOR.
+type redisBinding struct {
+	Binding
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RedisRegistry Address-of-Record registry shared by the instances of a cluster in Redis.
+//
+// Each AOR is a hash of its bindings by source address, which expires with its last binding,
+// and the AORs are indexed by source to handle the connection errors.
+type RedisRegistry struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisRegistry prefix of the keys, e.g. "b2bua:".
+func NewRedisRegistry(client *redis.Client, prefix string) *RedisRegistry {
+	return &RedisRegistry{client: client, prefix: prefix}
+}
+
+func (r *RedisRegistry) aorKey(aor sip.Uri) string {
+	return r.prefix + "aor:" + userKey(aor)
+}
+
+func (r *RedisRegistry) aorsKey() string {
+	return r.prefix + "aors"
+}
+
+func (r *RedisRegistry) sourceKey(source string) string {
+	return r.prefix + "source:" + source
+}
+
+func redisContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), redisTimeout)
+}
+
+// AddAor .
+func (r *RedisRegistry) AddAor(aor sip.Uri, instance *ContactInstance) error {
+	return r.update(aor, func(ctx context.Context, instances map[string]*ContactInstance, pipe redis.Pipeliner) error {
+		if ci, ok := instances[instance.Source]; ok && !ci.Registered.IsZero() {
+			instance.Registered = ci.Registered
+		}
+		return r.store(ctx, pipe, aor, instances, instance)
+	})
+}
+
+// UpdateContact .
+func (r *RedisRegistry) UpdateContact(aor sip.Uri, instance *ContactInstance) error {
+	return r.update(aor, func(ctx context.Context, instances map[string]*ContactInstance, pipe redis.Pipeliner) error {
+		if len(instances) == 0 {
+			return fmt.Errorf("Not found instances for %v", aor)
+		}
+		return r.store(ctx, pipe, aor, instances, instance)
+	})
+}
+
+// update the bindings of aor with fn in a transaction, retried while another instance of the cluster
+// updates them at the same time: fn queues on pipe the writes computed from the bindings read.
+func (r *RedisRegistry) update(aor sip.Uri, fn func(ctx context.Context, instances map[string]*ContactInstance, pipe redis.Pipeliner) error) error {
+	ctx, cancel := redisContext()
+	defer cancel()
+	key := r.aorKey(aor)
+	for attempt := 0; attempt < redisRetries; attempt++ {
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			instances, _, expired, err := r.read(ctx, tx, aor)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if len(expired) > 0 {
+					pipe.HDel(ctx, key, expired...)
+				}
+				return fn(ctx, instances, pipe)
+			})
+			return err
+		}, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return fmt.Errorf("bindings of %v updated concurrently %d times", aor, redisRetries)
+}
+
+// store queue on pipe the write of instance and the removal of the stale bindings of the same device.
+func (r *RedisRegistry) store(ctx context.Context, pipe redis.Pipeliner, aor sip.Uri, instances map[string]*ContactInstance, instance *ContactInstance) error {
+	expiresAt := time.Now().Add(time.Duration(instance.RegExpires) * time.Second)
+	data, err := json.Marshal(&redisBinding{Binding: *NewBinding(aor, instance), ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+	key := r.aorKey(aor)
+	for source, ci := range instances {
+		if source != instance.Source && ci.SameBinding(instance) {
+			pipe.HDel(ctx, key, source)
+			pipe.SRem(ctx, r.sourceKey(source), userKey(aor))
+		}
+	}
+	pipe.HSet(ctx, key, instance.Source, data)
+	pipe.SAdd(ctx, r.aorsKey(), userKey(aor))
+	pipe.SAdd(ctx, r.sourceKey(instance.Source), userKey(aor))
+	// The hash lives as long as its longest binding.
+	ttl := time.Until(expiresAt)
+	for _, ci := range instances {
+		if d := time.Duration(ci.RegExpires) * time.Second; d > ttl {
+			ttl = d
+		}
+	}
+	if ttl > 0 {
+		pipe.Expire(ctx, key, ttl)
+	}
+	return nil
+}
+
+// RemoveAor .
+func (r *RedisRegistry) RemoveAor(aor sip.Uri) error {
+	ctx, cancel := redisContext()
+	defer cancel()
+	instances, err := r.load(ctx, aor)
+	if err != nil {
+		return err
+	}
+	pipe := r.client.TxPipeline()
+	for source := range instances {
+		pipe.SRem(ctx, r.sourceKey(source), userKey(aor))
+	}
+	pipe.Del(ctx, r.aorKey(aor))
+	pipe.SRem(ctx, r.aorsKey(), userKey(aor))
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// AorIsRegistered .
+func (r *RedisRegistry) AorIsRegistered(aor sip.Uri) bool {
+	_, found := r.GetContacts(aor)
+	return found
+}
+
+// RemoveContact .
+func (r *RedisRegistry) RemoveContact(aor sip.Uri, instance *ContactInstance) error {
+	return r.update(aor, func(ctx context.Context, instances map[string]*ContactInstance, pipe redis.Pipeliner) error {
+		if len(instances) == 0 {
+			return fmt.Errorf("Not found instances for %v", aor)
+		}
+		key := r.aorKey(aor)
+		remaining := len(instances)
+		for source, ci := range instances {
+			if source == instance.Source || ci.SameBinding(instance) {
+				pipe.HDel(ctx, key, source)
+				pipe.SRem(ctx, r.sourceKey(source), userKey(aor))
+				remaining--
+			}
+		}
+		if remaining == 0 {
+			pipe.SRem(ctx, r.aorsKey(), userKey(aor))
+		}
+		return nil
+	})
+}
+
+// GetContacts .
+func (r *RedisRegistry) GetContacts(aor sip.Uri) (*map[string]*ContactInstance, bool) {
+	ctx, cancel := redisContext()
+	defer cancel()
+	instances, err := r.load(ctx, aor)
+	if err != nil {
+		logger.Errorf("Redis registry: %v", err)
+		return nil, false
+	}
+	if len(instances) == 0 {
+		return nil, false
+	}
+	return &instances, true
+}
+
+// GetAllContacts .
+func (r *RedisRegistry) GetAllContacts() map[sip.Uri]map[string]*ContactInstance {
+	ctx, cancel := redisContext()
+	defer cancel()
+	all := make(map[sip.Uri]map[string]*ContactInstance)
+	users, err := r.client.SMembers(ctx, r.aorsKey()).Result()
+	if err != nil {
+		logger.Errorf("Redis registry: %v", err)
+		return all
+	}
+	for _, user := range users {
+		instances, registered, err := r.loadAor(ctx, &sip.SipUri{FUser: sip.String{Str: user}})
+		if err != nil || len(instances) == 0 {
+			continue
+		}
+		all[registered] = instances
+	}
+	return all
+}
+
+// HandleConnectionError remove the bindings of the closed connection.
+func (r *RedisRegistry) HandleConnectionError(connError *transport.ConnectionError) bool {
+	ctx, cancel := redisContext()
+	defer cancel()
+	sourceKey := r.sourceKey(connError.Source)
+	users, err := r.client.SMembers(ctx, sourceKey).Result()
+	if err != nil || len(users) == 0 {
+		return false
+	}
+	pipe := r.client.TxPipeline()
+	for _, user := range users {
+		pipe.HDel(ctx, r.prefix+"aor:"+user, connError.Source)
+	}
+	pipe.Del(ctx, sourceKey)
+	_, err = pipe.Exec(ctx)
+	return err == nil
+}
+
+// Ping .
+func (r *RedisRegistry) Ping() error {
+	ctx, cancel := redisContext()
+	defer cancel()
+	return r.client.Ping(ctx).Err()
+}
+
+// load the live bindings of aor by source, the expired ones are removed.
+func (r *RedisRegistry) load(ctx context.Context, aor sip.Uri) (map[string]*ContactInstance, error) {
+	instances, _, err := r.loadAor(ctx, aor)
+	return instances, err
+}
+
+// loadAor the live bindings of aor and the AOR they were registered with.
+func (r *RedisRegistry) loadAor(ctx context.Context, aor sip.Uri) (map[string]*ContactInstance, sip.Uri, error) {
+	instances, registered, expired, err := r.read(ctx, r.client, aor)
+	if err == nil && len(expired) > 0 {
+		r.client.HDel(ctx, r.aorKey(aor), expired...)
+	}
+	return instances, registered, err
+}
+
+// read the live bindings of aor, the AOR they were registered with and the sources of the expired ones.
+func (r *RedisRegistry) read(ctx context.Context, c redis.Cmdable, aor sip.Uri) (map[string]*ContactInstance, sip.Uri, []string, error) {
+	values, err := c.HGetAll(ctx, r.aorKey(aor)).Result()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	registered := aor
+	now := time.Now()
+	instances := make(map[string]*ContactInstance, len(values))
+	expired := []string{}
+	for source, value := range values {
+		binding := &redisBinding{}
+		if err := json.Unmarshal([]byte(value), binding); err != nil || now.After(binding.ExpiresAt) {
+			expired = append(expired, source)
+			continue
+		}
+		uri, instance, err := binding.Instance()
+		if err != nil {
+			logger.Errorf("Redis registry: %v", err)
+			continue
+		}
+		instances[source], registered = instance, uri
+	}
+	return instances, registered, expired, nil
+}
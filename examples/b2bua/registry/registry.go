@@ -1,6 +1,9 @@
 package registry
 
 import (
+	"strings"
+	"time"
+
 	"github.com/ghettovoice/gosip/sip"
 	"github.com/ghettovoice/gosip/transport"
 )
@@ -12,6 +15,53 @@ type ContactInstance struct {
 	Source      string
 	UserAgent   string
 	Transport   string
+	// InstanceID +sip.instance of the Contact, https://tools.ietf.org/html/rfc5626#section-4.1
+	InstanceID string
+	// RegID reg-id of the Contact, https://tools.ietf.org/html/rfc5626#section-4.2
+	RegID string
+	// Supported option tags from the Supported header of the REGISTER.
+	Supported []string
+	// Allow methods from the Allow header of the REGISTER.
+	Allow []string
+	// Registered time of the first registration of this binding.
+	Registered time.Time
+}
+
+// DeviceInfo metadata of the registered device, for admin tools.
+type DeviceInfo struct {
+	UserAgent  string    `json:"user_agent,omitempty"`
+	InstanceID string    `json:"instance_id,omitempty"`
+	RegID      string    `json:"reg_id,omitempty"`
+	Supported  []string  `json:"supported,omitempty"`
+	Allow      []string  `json:"allow,omitempty"`
+	Source     string    `json:"source"`
+	Transport  string    `json:"transport"`
+	Expires    uint32    `json:"expires"`
+	Registered time.Time `json:"registered"`
+}
+
+// DeviceInfo .
+func (c *ContactInstance) DeviceInfo() DeviceInfo {
+	return DeviceInfo{
+		UserAgent:  c.UserAgent,
+		InstanceID: c.InstanceID,
+		RegID:      c.RegID,
+		Supported:  c.Supported,
+		Allow:      c.Allow,
+		Source:     c.Source,
+		Transport:  c.Transport,
+		Expires:    c.RegExpires,
+		Registered: c.Registered,
+	}
+}
+
+// SameBinding check if both instances are the same device binding (RFC 5626 section 6),
+// i.e. the same +sip.instance and reg-id registered from any address.
+func (c *ContactInstance) SameBinding(other *ContactInstance) bool {
+	if len(c.InstanceID) == 0 || c.InstanceID != other.InstanceID {
+		return false
+	}
+	return c.RegID == other.RegID
 }
 
 func (c *ContactInstance) GetPNParams() *PNParams {
@@ -39,13 +89,39 @@ func NewContactInstanceForRequest(request sip.Request) *ContactInstance {
 		expires = *headers[0].(*sip.Expires)
 	}
 	contacts, _ := request.Contact()
-	userAgent := request.GetHeaders("User-Agent")[0].(*sip.UserAgentHeader)
+	userAgent := ""
+	if hdrs := request.GetHeaders("User-Agent"); len(hdrs) > 0 {
+		userAgent = hdrs[0].Value()
+	}
 	instance := &ContactInstance{
 		Source:     request.Source(),
 		RegExpires: uint32(expires),
 		Contact:    contacts.Clone().(*sip.ContactHeader),
-		UserAgent:  userAgent.String(),
+		UserAgent:  userAgent,
 		Transport:  request.Transport(),
+		Supported:  []string{},
+		Allow:      []string{},
+		Registered: time.Now(),
+	}
+	for _, hdr := range request.GetHeaders("Supported") {
+		if supported, ok := hdr.(*sip.SupportedHeader); ok {
+			instance.Supported = append(instance.Supported, supported.Options...)
+		}
+	}
+	for _, hdr := range request.GetHeaders("Allow") {
+		if allow, ok := hdr.(sip.AllowHeader); ok {
+			for _, method := range allow {
+				instance.Allow = append(instance.Allow, string(method))
+			}
+		}
+	}
+	if contacts.Params != nil {
+		if urn, ok := contacts.Params.Get("+sip.instance"); ok && urn != nil {
+			instance.InstanceID = strings.Trim(urn.String(), `"<>`)
+		}
+		if regID, ok := contacts.Params.Get("reg-id"); ok && regID != nil {
+			instance.RegID = regID.String()
+		}
 	}
 	return instance
 }
@@ -61,3 +137,29 @@ type Registry interface {
 	GetAllContacts() map[sip.Uri]map[string]*ContactInstance
 	HandleConnectionError(connError *transport.ConnectionError) bool
 }
+
+// DeviceLister a Registry listing the device metadata of the bindings itself, e.g. from its backend.
+type DeviceLister interface {
+	GetDevices(aor sip.Uri) ([]DeviceInfo, bool)
+}
+
+// Devices the device metadata of each binding of aor, from the contacts of r unless it's a DeviceLister.
+func Devices(r Registry, aor sip.Uri) ([]DeviceInfo, bool) {
+	if lister, ok := r.(DeviceLister); ok {
+		return lister.GetDevices(aor)
+	}
+	contacts, found := r.GetContacts(aor)
+	if !found {
+		return nil, false
+	}
+	devices := make([]DeviceInfo, 0, len(*contacts))
+	for _, instance := range *contacts {
+		devices = append(devices, instance.DeviceInfo())
+	}
+	return devices, true
+}
+
+// Pinger a Registry with a remote backend, Ping fails while the backend is unreachable.
+type Pinger interface {
+	Ping() error
+}
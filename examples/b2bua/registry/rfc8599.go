@@ -1,8 +1,9 @@
 package registry
 
 import (
-	"fmt"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/ghettovoice/gosip/sip"
@@ -12,6 +13,13 @@ const (
 	DefaultPNTimeout = 30 // s
 )
 
+var (
+	// ErrPushAborted the caller cancelled the call while the callee was woken up.
+	ErrPushAborted = errors.New("push aborted")
+	// ErrPushTimeout the callee didn't register within DefaultPNTimeout.
+	ErrPushTimeout = errors.New("push timeout")
+)
+
 type PNParams struct {
 	Provider string // PNS Provider (apns|fcm|other)
 	Param    string
@@ -39,6 +47,7 @@ type RFC8599 struct {
 	PushCallback PushCallback
 	records      map[PNParams]sip.Uri
 	pushers      map[PNParams]*Pusher
+	mutex        sync.Mutex
 }
 
 func NewRFC8599(callback PushCallback) *RFC8599 {
@@ -57,6 +66,8 @@ func (r *RFC8599) PNRecords() map[PNParams]sip.Uri {
 func (r *RFC8599) HandleContactInstance(aor sip.Uri, instance *ContactInstance) {
 	pn := instance.GetPNParams()
 	if pn != nil {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
 		disable := pn.Disabled()
 		if disable {
 			//Remove pn record.
@@ -71,7 +82,7 @@ func (r *RFC8599) HandleContactInstance(aor sip.Uri, instance *ContactInstance)
 
 		// Add pn record.
 		if _, ok := r.records[*pn]; !ok {
-			r.records[*pn] = aor
+			r.records[*pn] = aor.Clone()
 		}
 
 		//for params, aor := range r.records {
@@ -89,6 +100,8 @@ func (r *RFC8599) HandleContactInstance(aor sip.Uri, instance *ContactInstance)
 }
 
 func (r *RFC8599) TryPush(aor sip.Uri, from *sip.FromHeader) (*Pusher, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
 	for params, uri := range r.records {
 
 		if uri.User() == aor.User() {
@@ -131,12 +144,13 @@ func NewPusher() *Pusher {
 
 func (pn *Pusher) WaitContactOnline() (*ContactInstance, error) {
 	t := time.NewTicker(time.Second * time.Duration(DefaultPNTimeout))
+	defer t.Stop()
 	for {
 		select {
 		case <-pn.abort:
-			return nil, fmt.Errorf("Abort")
+			return nil, ErrPushAborted
 		case <-t.C:
-			return nil, fmt.Errorf("Timeout")
+			return nil, ErrPushTimeout
 		case instance := <-pn.CH:
 			return instance, nil
 		}
@@ -145,5 +159,27 @@ func (pn *Pusher) WaitContactOnline() (*ContactInstance, error) {
 
 //Abort caller cancelled the call
 func (pn *Pusher) Abort() {
-	pn.abort <- 1
+	select {
+	case pn.abort <- 1:
+	default:
+	}
+}
+
+//Abort the wait of pusher, and forget it, e.g. when the caller cancelled the call.
+func (r *RFC8599) Abort(pusher *Pusher) {
+	r.mutex.Lock()
+	for params, p := range r.pushers {
+		if p == pusher {
+			delete(r.pushers, params)
+		}
+	}
+	r.mutex.Unlock()
+	pusher.Abort()
+}
+
+//Pending the pushers waiting for their callee to register.
+func (r *RFC8599) Pending() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.pushers)
 }
@@ -0,0 +1,31 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+func TestPushAbort(t *testing.T) {
+	r := NewRFC8599(func(pn *PNParams, payload map[string]string) error { return nil })
+	aor, _ := parser.ParseUri("sip:100@example.com")
+	caller, _ := parser.ParseUri("sip:200@example.com")
+	r.records[PNParams{Provider: "fcm", Param: "project", PRID: "token"}] = aor
+	pusher, ok := r.TryPush(aor, &sip.FromHeader{Address: caller})
+	if !ok {
+		t.Fatal("no push sent")
+	}
+	if pending := r.Pending(); pending != 1 {
+		t.Fatalf("%d pending pushers, want 1", pending)
+	}
+	r.Abort(pusher)
+	// A second abort, e.g. once the wait is over, doesn't block.
+	r.Abort(pusher)
+	if _, err := pusher.WaitContactOnline(); err != ErrPushAborted {
+		t.Errorf("wait = %v, want %v", err, ErrPushAborted)
+	}
+	if pending := r.Pending(); pending != 0 {
+		t.Errorf("%d pending pushers after the abort, want 0", pending)
+	}
+}
@@ -0,0 +1,221 @@
+package registry
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/transport"
+)
+
+const (
+	// DefaultRegistryShards .
+	DefaultRegistryShards = 256
+)
+
+// ShardedRegistry Address-of-Record registry in memory for millions of bindings: the AORs are spread over
+// shards by the hash of their user, each with its own write lock, and the lookups take no lock: the
+// bindings of an AOR are an immutable snapshot, replaced on each change.
+type ShardedRegistry struct {
+	shards []*registryShard
+	mask   uint32
+}
+
+// registryShard the AORs of a shard by user, mutex serializes their writers.
+type registryShard struct {
+	mutex sync.Mutex
+	aors  sync.Map
+}
+
+// aorBindings the bindings of an AOR, instances holds a map[string]*ContactInstance never modified once
+// stored, so that the readers may range over it.
+type aorBindings struct {
+	aor       sip.Uri
+	instances atomic.Value
+}
+
+// NewShardedRegistry shards rounded up to a power of two, DefaultRegistryShards if 0.
+func NewShardedRegistry(shards int) *ShardedRegistry {
+	if shards <= 0 {
+		shards = DefaultRegistryShards
+	}
+	count := 1
+	for count < shards {
+		count <<= 1
+	}
+	sr := &ShardedRegistry{
+		shards: make([]*registryShard, count),
+		mask:   uint32(count - 1),
+	}
+	for i := range sr.shards {
+		sr.shards[i] = &registryShard{}
+	}
+	return sr
+}
+
+// shard of user, by its FNV-1a hash.
+func (sr *ShardedRegistry) shard(user string) *registryShard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(user); i++ {
+		hash ^= uint32(user[i])
+		hash *= 16777619
+	}
+	return sr.shards[hash&sr.mask]
+}
+
+// find the bindings of user in shard, nil if none.
+func (shard *registryShard) find(user string) *aorBindings {
+	if bindings, ok := shard.aors.Load(user); ok {
+		return bindings.(*aorBindings)
+	}
+	return nil
+}
+
+func (bindings *aorBindings) snapshot() map[string]*ContactInstance {
+	return bindings.instances.Load().(map[string]*ContactInstance)
+}
+
+// update replace the bindings with a copy changed by change, the AOR is removed once it has none.
+func (shard *registryShard) update(user string, bindings *aorBindings, change func(instances map[string]*ContactInstance)) {
+	current := bindings.snapshot()
+	instances := make(map[string]*ContactInstance, len(current)+1)
+	for source, instance := range current {
+		instances[source] = instance
+	}
+	change(instances)
+	if len(instances) == 0 {
+		shard.aors.Delete(user)
+		return
+	}
+	bindings.instances.Store(instances)
+}
+
+// AddAor add a binding of aor, aor is cloned when the first binding is added.
+func (sr *ShardedRegistry) AddAor(aor sip.Uri, instance *ContactInstance) error {
+	user := userKey(aor)
+	shard := sr.shard(user)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	if bindings := shard.find(user); bindings != nil {
+		shard.update(user, bindings, func(instances map[string]*ContactInstance) {
+			if ci, ok := instances[instance.Source]; ok && !ci.Registered.IsZero() {
+				instance.Registered = ci.Registered
+			}
+			removeStaleBindings(instances, instance)
+			instances[instance.Source] = instance
+		})
+		return nil
+	}
+	bindings := &aorBindings{aor: aor.Clone()}
+	bindings.instances.Store(map[string]*ContactInstance{instance.Source: instance})
+	shard.aors.Store(user, bindings)
+	return nil
+}
+
+// RemoveAor .
+func (sr *ShardedRegistry) RemoveAor(aor sip.Uri) error {
+	user := userKey(aor)
+	shard := sr.shard(user)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	shard.aors.Delete(user)
+	return nil
+}
+
+// AorIsRegistered .
+func (sr *ShardedRegistry) AorIsRegistered(aor sip.Uri) bool {
+	user := userKey(aor)
+	return sr.shard(user).find(user) != nil
+}
+
+// UpdateContact .
+func (sr *ShardedRegistry) UpdateContact(aor sip.Uri, instance *ContactInstance) error {
+	user := userKey(aor)
+	shard := sr.shard(user)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	bindings := shard.find(user)
+	if bindings == nil {
+		return fmt.Errorf("Not found instances for %v", aor)
+	}
+	shard.update(user, bindings, func(instances map[string]*ContactInstance) {
+		removeStaleBindings(instances, instance)
+		instances[instance.Source] = instance
+	})
+	return nil
+}
+
+// RemoveContact .
+func (sr *ShardedRegistry) RemoveContact(aor sip.Uri, instance *ContactInstance) error {
+	user := userKey(aor)
+	shard := sr.shard(user)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	bindings := shard.find(user)
+	if bindings == nil {
+		return fmt.Errorf("Not found instances for %v", aor)
+	}
+	shard.update(user, bindings, func(instances map[string]*ContactInstance) {
+		delete(instances, instance.Source)
+		removeStaleBindings(instances, instance)
+	})
+	return nil
+}
+
+// HandleConnectionError remove the bindings of the closed connection.
+func (sr *ShardedRegistry) HandleConnectionError(connError *transport.ConnectionError) bool {
+	result := false
+	for _, shard := range sr.shards {
+		shard.mutex.Lock()
+		shard.aors.Range(func(user, value interface{}) bool {
+			bindings := value.(*aorBindings)
+			if _, ok := bindings.snapshot()[connError.Source]; ok {
+				shard.update(user.(string), bindings, func(instances map[string]*ContactInstance) {
+					delete(instances, connError.Source)
+				})
+				result = true
+			}
+			return true
+		})
+		shard.mutex.Unlock()
+	}
+	return result
+}
+
+// GetContacts the bindings of aor, not to be modified.
+func (sr *ShardedRegistry) GetContacts(aor sip.Uri) (*map[string]*ContactInstance, bool) {
+	user := userKey(aor)
+	bindings := sr.shard(user).find(user)
+	if bindings == nil {
+		return nil, false
+	}
+	instances := bindings.snapshot()
+	return &instances, true
+}
+
+// GetDevices device metadata of each binding of aor.
+func (sr *ShardedRegistry) GetDevices(aor sip.Uri) ([]DeviceInfo, bool) {
+	instances, found := sr.GetContacts(aor)
+	if !found {
+		return nil, false
+	}
+	devices := make([]DeviceInfo, 0, len(*instances))
+	for _, instance := range *instances {
+		devices = append(devices, instance.DeviceInfo())
+	}
+	return devices, true
+}
+
+// GetAllContacts a snapshot of the bindings of all the AORs.
+func (sr *ShardedRegistry) GetAllContacts() map[sip.Uri]map[string]*ContactInstance {
+	aors := make(map[sip.Uri]map[string]*ContactInstance)
+	for _, shard := range sr.shards {
+		shard.aors.Range(func(_, value interface{}) bool {
+			bindings := value.(*aorBindings)
+			aors[bindings.aor] = bindings.snapshot()
+			return true
+		})
+	}
+	return aors
+}
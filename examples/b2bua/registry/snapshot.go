@@ -0,0 +1,122 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// SnapshotVersion of the snapshot format, the snapshots of a later version are refused.
+	SnapshotVersion = 1
+)
+
+// Snapshot the bindings of a registry at a time, written to disk to be restored on the next start.
+type Snapshot struct {
+	Version  int        `json:"version"`
+	Time     time.Time  `json:"time"`
+	Bindings []*Binding `json:"bindings"`
+}
+
+// NewSnapshot the bindings of r.
+func NewSnapshot(r Registry) *Snapshot {
+	snapshot := &Snapshot{Version: SnapshotVersion, Time: time.Now(), Bindings: []*Binding{}}
+	for aor, instances := range r.GetAllContacts() {
+		for _, instance := range instances {
+			snapshot.Bindings = append(snapshot.Bindings, NewBinding(aor, instance))
+		}
+	}
+	return snapshot
+}
+
+// WriteSnapshot write the snapshot of r to path atomically: to a synced temporary file renamed over
+// path, then the directory synced, so that a crash leaves either the previous snapshot or this one.
+func WriteSnapshot(path string, r Registry) (int, error) {
+	snapshot := NewSnapshot(r)
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return 0, err
+	}
+	dir := filepath.Dir(path)
+	file, err := ioutil.TempFile(dir, ".registry-")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return 0, err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		os.Remove(file.Name())
+		return 0, err
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return len(snapshot.Bindings), nil
+}
+
+// ReadSnapshot the snapshot of path, nil if there is none.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := ParseSnapshot(data)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %v", path, err)
+	}
+	return snapshot, nil
+}
+
+// ParseSnapshot the snapshot of data, as written by WriteSnapshot.
+func ParseSnapshot(data []byte) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("bad snapshot: %v", err)
+	}
+	if snapshot.Version > SnapshotVersion {
+		return nil, fmt.Errorf("snapshot of version %d, %d supported", snapshot.Version, SnapshotVersion)
+	}
+	return snapshot, nil
+}
+
+// Restore add the bindings of the snapshot to r, but the expired ones, and call restored with each.
+func (s *Snapshot) Restore(r Registry, now time.Time, restored func(aor sip.Uri, instance *ContactInstance)) int {
+	count := 0
+	for _, binding := range s.Bindings {
+		if int64(binding.LastUpdated)+int64(binding.Expires) <= now.Unix() {
+			continue
+		}
+		aor, instance, err := binding.Instance()
+		if err != nil {
+			logger.Errorf("Invalid binding in snapshot: %v", err)
+			continue
+		}
+		r.AddAor(aor, instance)
+		if restored != nil {
+			restored(aor, instance)
+		}
+		count++
+	}
+	return count
+}
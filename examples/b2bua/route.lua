@@ -0,0 +1,24 @@
+-- Sample routing script, go run examples/b2bua/main.go -route-script examples/b2bua/route.lua
+-- Edit it while the b2bua is running, it is reloaded within a few seconds.
+
+function route(call)
+  -- 9 prefix: outbound trunk.
+  if call.called:sub(1, 1) == "9" and #call.called > 1 then
+    return {
+      action = "route",
+      target = "sip:" .. call.called:sub(2) .. "@127.0.0.1:5080",
+      headers = { ["X-Trunk"] = "pstn" },
+      timeout = 60,
+    }
+  end
+  -- 0: the operator.
+  if call.called == "0" then
+    return "100"
+  end
+  if call.caller == "666" then
+    log("blocked call from", call.caller)
+    return { action = "reject", status_code = 403, reason = "Forbidden" }
+  end
+  -- Route as usual.
+  return nil
+end
@@ -0,0 +1,319 @@
+package script
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/examples/b2bua/b2bua"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+var (
+	logger log.Logger
+)
+
+func init() {
+	logger = utils.NewLogrusLogger(log.InfoLevel, "Script", nil)
+}
+
+const (
+	// routeFunction the global function of the script called for each call.
+	routeFunction = "route"
+	// maxIdleStates interpreters kept for the next calls.
+	maxIdleStates = 16
+)
+
+// Engine runs the routing of the calls in a Lua script, Route is a b2bua.RouteHandler.
+//
+// The script defines route(call), call has the fields of b2bua.RouteRequest (call_id, caller,
+// called, ...). It returns nil to route the call as usual, a number or a SIP URI to route the
+// call to, or a table with the fields of b2bua.RouteDecision, e.g.
+//
+//	function route(call)
+//	  if call.called:sub(1, 1) == "9" then
+//	    return { action = "route", target = "sip:" .. call.called:sub(2) .. "@10.0.0.1",
+//	             headers = { ["X-Trunk"] = "pstn" }, timeout = 30 }
+//	  end
+//	  if call.caller == "666" then
+//	    return { action = "reject", status_code = 403, reason = "Forbidden" }
+//	  end
+//	end
+type Engine struct {
+	path string
+	// Timeout of a route call, 100ms if 0.
+	Timeout time.Duration
+
+	mutex      sync.Mutex
+	proto      *lua.FunctionProto
+	modTime    time.Time
+	generation int
+	idle       []*state
+	stop       chan struct{}
+}
+
+type state struct {
+	L          *lua.LState
+	generation int
+}
+
+// NewEngine load the script at path.
+func NewEngine(path string) (*Engine, error) {
+	e := &Engine{path: path}
+	if err := e.Reload(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Reload compile the script again, the current one is kept if it fails.
+func (e *Engine) Reload() error {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+	proto, err := compile(e.path)
+	if err != nil {
+		return err
+	}
+	// Run it once, e.g. a missing route function must not replace a working script.
+	L := newState()
+	defer L.Close()
+	if err := load(L, proto); err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.proto = proto
+	e.modTime = info.ModTime()
+	e.generation++
+	for _, s := range e.idle {
+		s.L.Close()
+	}
+	e.idle = nil
+	logger.Infof("Script %s loaded", e.path)
+	return nil
+}
+
+// Watch reload the script when it changes, checked every interval.
+func (e *Engine) Watch(interval time.Duration) {
+	e.mutex.Lock()
+	if e.stop != nil {
+		e.mutex.Unlock()
+		return
+	}
+	e.stop = make(chan struct{})
+	stop := e.stop
+	e.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				info, err := os.Stat(e.path)
+				if err != nil {
+					continue
+				}
+				e.mutex.Lock()
+				changed := !info.ModTime().Equal(e.modTime)
+				e.mutex.Unlock()
+				if !changed {
+					continue
+				}
+				if err := e.Reload(); err != nil {
+					logger.Errorf("Reload of %s failed, keeping the previous script: %v", e.path, err)
+					// Don't retry until the next change.
+					e.mutex.Lock()
+					e.modTime = info.ModTime()
+					e.mutex.Unlock()
+				}
+			}
+		}
+	}()
+}
+
+// Close stop watching the script and release the interpreters.
+func (e *Engine) Close() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.stop != nil {
+		close(e.stop)
+		e.stop = nil
+	}
+	for _, s := range e.idle {
+		s.L.Close()
+	}
+	e.idle = nil
+}
+
+// Route call the route function of the script.
+func (e *Engine) Route(request *b2bua.RouteRequest) (*b2bua.RouteDecision, error) {
+	s, err := e.get()
+	if err != nil {
+		return nil, err
+	}
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = 100 * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	s.L.SetContext(ctx)
+	decision, err := call(s.L, request)
+	s.L.RemoveContext()
+	if err != nil {
+		// The interpreter may be left in any state.
+		s.L.Close()
+		return nil, err
+	}
+	e.put(s)
+	return decision, nil
+}
+
+func (e *Engine) get() (*state, error) {
+	e.mutex.Lock()
+	if n := len(e.idle); n > 0 {
+		s := e.idle[n-1]
+		e.idle = e.idle[:n-1]
+		e.mutex.Unlock()
+		return s, nil
+	}
+	proto, generation := e.proto, e.generation
+	e.mutex.Unlock()
+
+	L := newState()
+	if err := load(L, proto); err != nil {
+		L.Close()
+		return nil, err
+	}
+	return &state{L: L, generation: generation}, nil
+}
+
+func (e *Engine) put(s *state) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if s.generation != e.generation || len(e.idle) >= maxIdleStates {
+		s.L.Close()
+		return
+	}
+	e.idle = append(e.idle, s)
+}
+
+func compile(path string) (*lua.FunctionProto, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	chunk, err := parse.Parse(file, path)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, path)
+}
+
+// newState an interpreter without access to the file system or the processes.
+func newState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetGlobal("log", L.NewFunction(luaLog))
+	return L
+}
+
+// load run the script in L, defining its functions.
+func load(L *lua.LState, proto *lua.FunctionProto) error {
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		return err
+	}
+	if L.GetGlobal(routeFunction).Type() != lua.LTFunction {
+		return fmt.Errorf("script: no %s function", routeFunction)
+	}
+	return nil
+}
+
+func luaLog(L *lua.LState) int {
+	args := make([]string, 0, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		args = append(args, L.Get(i).String())
+	}
+	logger.Info(strings.Join(args, " "))
+	return 0
+}
+
+func call(L *lua.LState, request *b2bua.RouteRequest) (*b2bua.RouteDecision, error) {
+	t := L.NewTable()
+	t.RawSetString("call_id", lua.LString(request.CallID))
+	t.RawSetString("caller", lua.LString(request.Caller))
+	t.RawSetString("called", lua.LString(request.Called))
+	t.RawSetString("request_uri", lua.LString(request.RequestURI))
+	t.RawSetString("source", lua.LString(request.Source))
+	t.RawSetString("transport", lua.LString(request.Transport))
+	t.RawSetString("user_agent", lua.LString(request.UserAgent))
+
+	if err := L.CallByParam(lua.P{Fn: L.GetGlobal(routeFunction), NRet: 1, Protect: true}, t); err != nil {
+		return nil, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return decision(ret)
+}
+
+// decision convert the value returned by the script.
+func decision(value lua.LValue) (*b2bua.RouteDecision, error) {
+	switch v := value.(type) {
+	case *lua.LNilType:
+		return &b2bua.RouteDecision{Action: b2bua.RouteContinue}, nil
+	case lua.LString, lua.LNumber:
+		return &b2bua.RouteDecision{Action: b2bua.RouteTo, Target: v.String()}, nil
+	case *lua.LTable:
+		d := &b2bua.RouteDecision{
+			Action: b2bua.RouteAction(lua.LVAsString(v.RawGetString("action"))),
+			Target: lua.LVAsString(v.RawGetString("target")),
+			Reason: lua.LVAsString(v.RawGetString("reason")),
+		}
+		d.StatusCode = int(lua.LVAsNumber(v.RawGetString("status_code")))
+		d.Timeout = int(lua.LVAsNumber(v.RawGetString("timeout")))
+		if headers, ok := v.RawGetString("headers").(*lua.LTable); ok {
+			d.Headers = make(map[string]string)
+			headers.ForEach(func(name, value lua.LValue) {
+				d.Headers[name.String()] = value.String()
+			})
+		}
+		if len(d.Action) == 0 {
+			if len(d.Target) > 0 {
+				d.Action = b2bua.RouteTo
+			} else {
+				d.Action = b2bua.RouteContinue
+			}
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("script: %s returned a %s", routeFunction, value.Type())
+}
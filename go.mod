@@ -7,13 +7,22 @@ require (
 	firebase.google.com/go v3.13.0+incompatible
 	github.com/c-bata/go-prompt v0.2.6
 	github.com/ghettovoice/gosip v0.0.0-20211014110559-f0c4b77a298b
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.3.0
+	github.com/go-redis/redis/v8 v8.11.0
+	github.com/gobwas/ws v1.1.0-rc.1
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/google/uuid v1.3.0
-	github.com/kr/pretty v0.2.0 // indirect
 	github.com/mgutz/ansi v0.0.0-20200706080929-d51e80ef957d // indirect
+	github.com/nats-io/nats.go v1.11.0
 	github.com/pixelbender/go-sdp v1.1.0
+	github.com/segmentio/kafka-go v0.4.17
 	github.com/sirupsen/logrus v1.8.1
 	github.com/tevino/abool v1.2.0
 	github.com/x-cray/logrus-prefixed-formatter v0.5.2
+	github.com/yuin/gopher-lua v0.0.0-20210529063254-f4c35e4016d9
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 	google.golang.org/api v0.43.0
+	google.golang.org/protobuf v1.25.0
+	layeh.com/radius v0.0.0-20200615152116-663b41c3bf86
 )
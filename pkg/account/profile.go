@@ -2,7 +2,9 @@ package account
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media/ice"
 	"github.com/cloudwebrtc/go-sip-ua/pkg/stack"
 	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
 	"github.com/ghettovoice/gosip/log"
@@ -29,14 +31,84 @@ type AuthInfo struct {
 
 // Profile .
 type Profile struct {
-	URI           sip.Uri
-	DisplayName   string
-	AuthInfo      *AuthInfo
-	Expires       uint32
-	InstanceID    string
+	URI         sip.Uri
+	DisplayName string
+	AuthInfo    *AuthInfo
+	Expires     uint32
+	InstanceID  string
+	// Routes pre-loaded route set, the outbound proxies the requests outside of a dialog traverse, in order.
 	Routes        []sip.Uri
 	ContactURI    sip.Uri
 	ContactParams map[string]string
+	// Supported, Allow and Accept override the advertisement of the stack when set.
+	Supported []string
+	Allow     []sip.RequestMethod
+	Accept    []string
+	// TURN server relaying the media of the calls of the profile, nil for none.
+	TURN *ice.TURNServer
+	// UserAgent of the requests of the profile, the one of the stack if empty, HideUserAgent to send none.
+	UserAgent     string
+	HideUserAgent bool
+}
+
+// SetOutboundProxies set the Routes of the profile, e.g. "sip:sbc.example.com;lr", see utils.ParseRoutes.
+func (p *Profile) SetOutboundProxies(uris ...string) error {
+	routes, err := utils.ParseRoutes(uris...)
+	if err != nil {
+		return err
+	}
+	p.Routes = routes
+	return nil
+}
+
+// SetTURNServer relay the media through a TURN server, address host:port, with the long-term credentials.
+func (p *Profile) SetTURNServer(address string, username string, password string) {
+	p.TURN = &ice.TURNServer{Address: address, Username: username, Password: password}
+}
+
+// NewTURNClient an allocation on the TURN server of the profile, e.g. for the RTP stream of a call.
+func (p *Profile) NewTURNClient() (*ice.TURNClient, error) {
+	if p.TURN == nil {
+		return nil, fmt.Errorf("no TURN server in the profile of %v", p.URI)
+	}
+	client, err := ice.NewTURNClient(p.TURN)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := client.Allocate(0); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// CapabilityHeaders Supported/Allow/Accept headers of the profile, if configured.
+func (p *Profile) CapabilityHeaders() []sip.Header {
+	headers := []sip.Header{}
+	if p.Supported != nil {
+		headers = append(headers, &sip.SupportedHeader{Options: p.Supported})
+	}
+	if p.Allow != nil {
+		headers = append(headers, sip.AllowHeader(p.Allow))
+	}
+	if p.Accept != nil {
+		accept := sip.Accept(strings.Join(p.Accept, ", "))
+		headers = append(headers, &accept)
+	}
+	return headers
+}
+
+// UserAgentHeader the User-Agent of the requests of the profile, nil for the one of the stack. Empty when
+// hidden, the stack removes it.
+func (p *Profile) UserAgentHeader() *sip.UserAgentHeader {
+	if !p.HideUserAgent && len(p.UserAgent) == 0 {
+		return nil
+	}
+	userAgent := sip.UserAgentHeader(p.UserAgent)
+	if p.HideUserAgent {
+		userAgent = ""
+	}
+	return &userAgent
 }
 
 // Contact .
@@ -113,3 +185,15 @@ type RegisterState struct {
 	Response   sip.Response
 	UserData   interface{}
 }
+
+// PublishState result of a PUBLISH, ETag the entity-tag of the published state, empty once removed.
+type PublishState struct {
+	Account    *Profile
+	Event      string
+	StatusCode sip.StatusCode
+	Reason     string
+	ETag       string
+	Expiration uint32
+	Response   sip.Response
+	UserData   interface{}
+}
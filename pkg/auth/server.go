@@ -30,11 +30,43 @@ type AuthSession struct {
 
 type RequestCredentialCallback func(username string) (password string, ha1 string, err error)
 
+// DigestCredentials the digest authorization of a request, for external verification.
+type DigestCredentials struct {
+	Username   string
+	Realm      string
+	Nonce      string
+	URI        string
+	Method     string
+	Qop        string
+	NonceCount string
+	CNonce     string
+	Algorithm  string
+	Response   string
+	// Body of the request, for qop auth-int.
+	Body string
+}
+
+// VerifyBearerCallback validates a bearer token (RFC 8898), returns the account of the token and
+// its expiry, zero if it doesn't expire.
+type VerifyBearerCallback func(token string, request sip.Request) (username string, expires time.Time, err error)
+
+// VerifyDigestCallback verifies the credentials instead of the local digest computation,
+// e.g. by a RADIUS or Diameter server, an error is answered with 503.
+type VerifyDigestCallback func(credentials DigestCredentials) (bool, error)
+
+// FailureCallback notified of the credentials rejected, e.g. for an audit log: the username they were for,
+// and the reason.
+type FailureCallback func(request sip.Request, username string, reason string)
+
 // ServerAuthorizer Proxy-Authorization | WWW-Authenticate
 type ServerAuthorizer struct {
 	// a map[call id]authSession pair
 	sessions          map[string]AuthSession
 	requestCredential RequestCredentialCallback
+	verifyDigest      VerifyDigestCallback
+	verifyBearer      VerifyBearerCallback
+	onFailure         FailureCallback
+	authzServer       string
 	useAuthInt        bool
 	realm             string
 	log               log.Logger
@@ -65,6 +97,38 @@ func NewServerAuthorizer(callback RequestCredentialCallback, realm string, authI
 	return auth
 }
 
+// SetDigestVerifier delegate the verification of the digest responses, nil to verify them locally.
+func (auth *ServerAuthorizer) SetDigestVerifier(callback VerifyDigestCallback) {
+	auth.mx.Lock()
+	defer auth.mx.Unlock()
+	auth.verifyDigest = callback
+}
+
+// SetBearerVerifier accept bearer tokens (RFC 8898) validated by callback, in addition to digest,
+// authzServer is advertised in the Bearer challenge, nil to disable.
+func (auth *ServerAuthorizer) SetBearerVerifier(callback VerifyBearerCallback, authzServer string) {
+	auth.mx.Lock()
+	defer auth.mx.Unlock()
+	auth.verifyBearer = callback
+	auth.authzServer = authzServer
+}
+
+// OnFailure notify callback of the rejected credentials, nil to disable.
+func (auth *ServerAuthorizer) OnFailure(callback FailureCallback) {
+	auth.mx.Lock()
+	defer auth.mx.Unlock()
+	auth.onFailure = callback
+}
+
+func (auth *ServerAuthorizer) failed(request sip.Request, username string, reason string) {
+	auth.mx.RLock()
+	onFailure := auth.onFailure
+	auth.mx.RUnlock()
+	if onFailure != nil {
+		onFailure(request, username, reason)
+	}
+}
+
 // ServerAuthorizer handles Authenticate requests.
 func (auth *ServerAuthorizer) Authenticate(request sip.Request, tx sip.ServerTransaction) (string, bool) {
 	logger := auth.log
@@ -88,6 +152,9 @@ func (auth *ServerAuthorizer) Authenticate(request sip.Request, tx sip.ServerTra
 	}
 
 	authenticateHeader := hdrs[0].(*sip.GenericHeader)
+	if token, ok := BearerToken(request); ok {
+		return auth.checkBearer(request, tx, token, from)
+	}
 	authArgs := parseAuthHeader(authenticateHeader.Contents)
 	return auth.checkAuthorization(request, tx, authArgs, from)
 }
@@ -119,6 +186,12 @@ func (auth *ServerAuthorizer) requestAuthentication(request sip.Request, tx sip.
 		HeaderName: "WWW-Authenticate",
 		Contents:   "Digest " + digest.ToString(','),
 	})
+	auth.mx.RLock()
+	bearer, authzServer := auth.verifyBearer != nil, auth.authzServer
+	auth.mx.RUnlock()
+	if bearer {
+		response.AppendHeader(auth.bearerChallenge(authzServer, ""))
+	}
 
 	from.Params.Add("tag", sip.String{Str: generateNonce(8)})
 	auth.mx.Lock()
@@ -163,8 +236,17 @@ func (auth *ServerAuthorizer) checkAuthorization(request sip.Request, tx sip.Ser
 	}
 
 	username := from.Address.User().String()
+
+	auth.mx.RLock()
+	verifyDigest := auth.verifyDigest
+	auth.mx.RUnlock()
+	if verifyDigest != nil {
+		return auth.checkExternal(request, tx, verifyDigest, authArgs, username, session.nonce)
+	}
+
 	password, ha1, err := auth.requestCredential(username)
 	if err != nil {
+		auth.failed(request, username, "user not found")
 		sendResponse(request, tx, 404, "User not found")
 		return "", false
 	}
@@ -206,6 +288,7 @@ func (auth *ServerAuthorizer) checkAuthorization(request sip.Request, tx sip.Ser
 	}
 
 	if result != response.String() {
+		auth.failed(request, username, "bad digest")
 		sendResponse(request, tx, 403, "Forbidden (Bad auth)")
 		return "", false
 	}
@@ -213,6 +296,99 @@ func (auth *ServerAuthorizer) checkAuthorization(request sip.Request, tx sip.Ser
 	return username, true
 }
 
+// BearerToken the token of a Bearer Authorization header, if any.
+func BearerToken(request sip.Request) (string, bool) {
+	hdrs := request.GetHeaders("Authorization")
+	if len(hdrs) == 0 {
+		return "", false
+	}
+	contents := strings.TrimSpace(hdrs[0].Value())
+	if len(contents) < 7 || !strings.EqualFold(contents[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(contents[7:]), true
+}
+
+func (auth *ServerAuthorizer) bearerChallenge(authzServer string, err string) sip.Header {
+	contents := "Bearer realm=\"" + auth.realm + "\""
+	if len(authzServer) > 0 {
+		contents += ", authz_server=\"" + authzServer + "\""
+	}
+	if len(err) > 0 {
+		contents += ", error=\"" + err + "\""
+	}
+	return &sip.GenericHeader{
+		HeaderName: "WWW-Authenticate",
+		Contents:   contents,
+	}
+}
+
+// checkBearer validate the bearer token, the account of the token must be the user of the From header.
+func (auth *ServerAuthorizer) checkBearer(request sip.Request, tx sip.ServerTransaction, token string,
+	from *sip.FromHeader) (string, bool) {
+	auth.mx.RLock()
+	verifyBearer, authzServer := auth.verifyBearer, auth.authzServer
+	auth.mx.RUnlock()
+	if verifyBearer == nil {
+		auth.requestAuthentication(request, tx, from)
+		return "", false
+	}
+
+	username, _, err := verifyBearer(token, request)
+	if err != nil {
+		auth.log.Infof("Bearer token rejected: %v", err)
+		auth.failed(request, "", "invalid token: "+err.Error())
+		response := sip.NewResponseFromRequest(request.MessageID(), request, 401, "Unauthorized", "")
+		response.AppendHeader(auth.bearerChallenge(authzServer, "invalid_token"))
+		tx.Respond(response)
+		return "", false
+	}
+	if from.Address.User() == nil || username != from.Address.User().String() {
+		auth.failed(request, username, "token account mismatch")
+		sendResponse(request, tx, 403, "Forbidden (Token account mismatch)")
+		return "", false
+	}
+	return username, true
+}
+
+// checkExternal verify the digest response by the callback.
+func (auth *ServerAuthorizer) checkExternal(request sip.Request, tx sip.ServerTransaction,
+	verifyDigest VerifyDigestCallback, authArgs sip.Params, username string, nonce string) (string, bool) {
+	arg := func(name string) string {
+		if value, ok := authArgs.Get(name); ok && value != nil {
+			return value.String()
+		}
+		return ""
+	}
+	credentials := DigestCredentials{
+		Username:   username,
+		Realm:      arg("realm"),
+		Nonce:      nonce,
+		URI:        arg("uri"),
+		Method:     string(request.Method()),
+		Qop:        arg("qop"),
+		NonceCount: arg("nc"),
+		CNonce:     arg("cnonce"),
+		Algorithm:  arg("algorithm"),
+		Response:   arg("response"),
+	}
+	if credentials.Qop == "auth-int" {
+		credentials.Body = request.Body()
+	}
+	ok, err := verifyDigest(credentials)
+	if err != nil {
+		auth.log.Errorf("Digest verification of %s failed: %v", username, err)
+		sendResponse(request, tx, 503, "Service Unavailable")
+		return "", false
+	}
+	if !ok {
+		auth.failed(request, username, "bad digest")
+		sendResponse(request, tx, 403, "Forbidden (Bad auth)")
+		return "", false
+	}
+	return username, true
+}
+
 // parseAuthHeader .
 func parseAuthHeader(value string) sip.Params {
 	authArgs := sip.NewParams()
@@ -0,0 +1,143 @@
+package media
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// Codec an audio codec instance, encoding and decoding the linear samples of one stream. The codecs with
+// a state, e.g. Opus or G.729, are not shared between streams.
+type Codec interface {
+	// Name encoding name of the rtpmap, e.g. PCMU or opus.
+	Name() string
+	// PayloadType the static payload type, or the preferred dynamic one.
+	PayloadType() uint8
+	// ClockRate of the RTP timestamps, and Channels of the rtpmap.
+	ClockRate() int
+	Channels() int
+	// SampleRate of the linear samples, e.g. 16000 for G.722 whose clock rate is 8000.
+	SampleRate() int
+	// Fmtp parameters of the a=fmtp line, empty if none.
+	Fmtp() string
+	// Encode the payload of a frame of samples, Decode the samples of a payload.
+	Encode(samples []int16) ([]byte, error)
+	Decode(payload []byte) ([]int16, error)
+	// Close release the resources of the codec.
+	Close() error
+}
+
+// CodecFactory creates the instances of a codec.
+type CodecFactory func() (Codec, error)
+
+var (
+	codecsLock sync.RWMutex
+	// codecs the registered factories, by lower case encoding name.
+	codecs = map[string]CodecFactory{}
+)
+
+func init() {
+	RegisterCodec("PCMU", func() (Codec, error) { return &g711{payload: PayloadPCMU}, nil })
+	RegisterCodec("PCMA", func() (Codec, error) { return &g711{payload: PayloadPCMA}, nil })
+}
+
+// RegisterCodec make a codec available by its encoding name, e.g. from the init of a plug-in package.
+func RegisterCodec(name string, factory CodecFactory) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[strings.ToLower(name)] = factory
+}
+
+// Codecs the encoding names of the registered codecs.
+func Codecs() []string {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewCodec an instance of the codec of an encoding name, case insensitive.
+func NewCodec(name string) (Codec, error) {
+	codecsLock.RLock()
+	factory, found := codecs[strings.ToLower(name)]
+	codecsLock.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("codec %s not available", name)
+	}
+	return factory()
+}
+
+// NewCodecForSDP an instance of the first registered codec of the audio stream of a session description,
+// e.g. the negotiated one in an answer, and its payload type in the description.
+func NewCodecForSDP(desc string) (Codec, uint8, error) {
+	session, err := sdp.ParseString(desc)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, media := range session.Media {
+		if media.Type != "audio" || media.Port == 0 {
+			continue
+		}
+		for _, format := range media.Format {
+			name := format.Name
+			if len(name) == 0 {
+				name = staticPayloads[format.Payload]
+			}
+			if codec, err := NewCodec(name); err == nil {
+				return codec, format.Payload, nil
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("no available codec in the session description")
+}
+
+// CodecFormat the rtpmap and fmtp of a codec, e.g. for an answer.
+func CodecFormat(codec Codec, payload uint8) *sdp.Format {
+	format := &sdp.Format{Payload: payload, Name: codec.Name(), ClockRate: codec.ClockRate()}
+	if codec.Channels() > 1 {
+		format.Channels = codec.Channels()
+	}
+	if fmtp := codec.Fmtp(); len(fmtp) > 0 {
+		format.Params = []string{fmtp}
+	}
+	return format
+}
+
+// g711 PCMU and PCMA.
+type g711 struct {
+	payload uint8
+}
+
+func (c *g711) Name() string {
+	if c.payload == PayloadPCMA {
+		return "PCMA"
+	}
+	return "PCMU"
+}
+
+func (c *g711) PayloadType() uint8 { return c.payload }
+
+func (c *g711) ClockRate() int { return toneSampleRate }
+
+func (c *g711) Channels() int { return 1 }
+
+func (c *g711) SampleRate() int { return toneSampleRate }
+
+func (c *g711) Fmtp() string { return "" }
+
+func (c *g711) Encode(samples []int16) ([]byte, error) {
+	return EncodeG711(c.payload, samples), nil
+}
+
+func (c *g711) Decode(payload []byte) ([]int16, error) {
+	return DecodeG711(c.payload, payload), nil
+}
+
+func (c *g711) Close() error { return nil }
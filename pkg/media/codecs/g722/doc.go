@@ -0,0 +1,5 @@
+// Package g722 the G.722 codec plug-in, backed by spandsp with cgo. Build with -tags g722 and import it
+// for its side effect, the codec is then available as media.NewCodec("G722"):
+//
+//	import _ "github.com/cloudwebrtc/go-sip-ua/pkg/media/codecs/g722"
+package g722
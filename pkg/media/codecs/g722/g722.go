@@ -0,0 +1,92 @@
+// +build cgo,g722
+
+package g722
+
+/*
+#cgo LDFLAGS: -lspandsp
+#include <stdint.h>
+#include <spandsp.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+)
+
+const (
+	// PayloadType static payload type of G.722.
+	PayloadType = 9
+	// bitRate of the G.722 mode 1.
+	bitRate = 64000
+)
+
+func init() {
+	media.RegisterCodec("G722", newCodec)
+}
+
+// codec G.722 at 64 kbit/s.
+type codec struct {
+	encoder *C.g722_encode_state_t
+	decoder *C.g722_decode_state_t
+}
+
+func newCodec() (media.Codec, error) {
+	encoder := C.g722_encode_init(nil, bitRate, 0)
+	if encoder == nil {
+		return nil, fmt.Errorf("g722 encoder init failed")
+	}
+	decoder := C.g722_decode_init(nil, bitRate, 0)
+	if decoder == nil {
+		C.g722_encode_free(encoder)
+		return nil, fmt.Errorf("g722 decoder init failed")
+	}
+	return &codec{encoder: encoder, decoder: decoder}, nil
+}
+
+func (c *codec) Name() string { return "G722" }
+
+func (c *codec) PayloadType() uint8 { return PayloadType }
+
+// ClockRate 8000 for historical reasons, RFC 3551, the samples are at 16000.
+func (c *codec) ClockRate() int { return 8000 }
+
+func (c *codec) Channels() int { return 1 }
+
+func (c *codec) SampleRate() int { return 16000 }
+
+func (c *codec) Fmtp() string { return "" }
+
+func (c *codec) Encode(samples []int16) ([]byte, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("empty frame")
+	}
+	payload := make([]byte, len(samples)/2+1)
+	n := C.g722_encode(c.encoder, (*C.uint8_t)(unsafe.Pointer(&payload[0])),
+		(*C.int16_t)(unsafe.Pointer(&samples[0])), C.int(len(samples)))
+	return payload[:n], nil
+}
+
+func (c *codec) Decode(payload []byte) ([]int16, error) {
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("empty payload")
+	}
+	samples := make([]int16, 2*len(payload))
+	n := C.g722_decode(c.decoder, (*C.int16_t)(unsafe.Pointer(&samples[0])),
+		(*C.uint8_t)(unsafe.Pointer(&payload[0])), C.int(len(payload)))
+	return samples[:n], nil
+}
+
+func (c *codec) Close() error {
+	if c.encoder != nil {
+		C.g722_encode_free(c.encoder)
+		c.encoder = nil
+	}
+	if c.decoder != nil {
+		C.g722_decode_free(c.decoder)
+		c.decoder = nil
+	}
+	return nil
+}
@@ -0,0 +1,5 @@
+// Package g729 the G.729 codec plug-in, backed by bcg729 with cgo. Build with -tags g729 and import it
+// for its side effect, the codec is then available as media.NewCodec("G729"):
+//
+//	import _ "github.com/cloudwebrtc/go-sip-ua/pkg/media/codecs/g729"
+package g729
@@ -0,0 +1,101 @@
+// +build cgo,g729
+
+package g729
+
+/*
+#cgo LDFLAGS: -lbcg729
+#include <stdint.h>
+#include <bcg729/encoder.h>
+#include <bcg729/decoder.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+)
+
+const (
+	// PayloadType static payload type of G.729.
+	PayloadType = 18
+	// frameSamples of a 10 ms frame, encoded in frameBytes.
+	frameSamples = 80
+	frameBytes   = 10
+)
+
+func init() {
+	media.RegisterCodec("G729", newCodec)
+}
+
+// codec G.729 Annex A without the Annex B silence suppression.
+type codec struct {
+	encoder *C.bcg729EncoderChannelContextStruct
+	decoder *C.bcg729DecoderChannelContextStruct
+}
+
+func newCodec() (media.Codec, error) {
+	encoder := C.initBcg729EncoderChannel(0)
+	if encoder == nil {
+		return nil, fmt.Errorf("g729 encoder init failed")
+	}
+	decoder := C.initBcg729DecoderChannel()
+	if decoder == nil {
+		C.closeBcg729EncoderChannel(encoder)
+		return nil, fmt.Errorf("g729 decoder init failed")
+	}
+	return &codec{encoder: encoder, decoder: decoder}, nil
+}
+
+func (c *codec) Name() string { return "G729" }
+
+func (c *codec) PayloadType() uint8 { return PayloadType }
+
+func (c *codec) ClockRate() int { return 8000 }
+
+func (c *codec) Channels() int { return 1 }
+
+func (c *codec) SampleRate() int { return 8000 }
+
+func (c *codec) Fmtp() string { return "annexb=no" }
+
+// Encode a multiple of 10 ms of samples.
+func (c *codec) Encode(samples []int16) ([]byte, error) {
+	if len(samples) == 0 || len(samples)%frameSamples != 0 {
+		return nil, fmt.Errorf("frame of %d samples, not a multiple of %d", len(samples), frameSamples)
+	}
+	payload := make([]byte, 0, len(samples)/frameSamples*frameBytes)
+	frame := make([]byte, frameBytes)
+	var length C.uint8_t
+	for i := 0; i < len(samples); i += frameSamples {
+		C.bcg729Encoder(c.encoder, (*C.int16_t)(unsafe.Pointer(&samples[i])), (*C.uint8_t)(unsafe.Pointer(&frame[0])), &length)
+		payload = append(payload, frame[:length]...)
+	}
+	return payload, nil
+}
+
+// Decode a payload of 10 bytes frames.
+func (c *codec) Decode(payload []byte) ([]int16, error) {
+	if len(payload) == 0 || len(payload)%frameBytes != 0 {
+		return nil, fmt.Errorf("payload of %d bytes, not a multiple of %d", len(payload), frameBytes)
+	}
+	samples := make([]int16, len(payload)/frameBytes*frameSamples)
+	for i := 0; i < len(payload)/frameBytes; i++ {
+		C.bcg729Decoder(c.decoder, (*C.uint8_t)(unsafe.Pointer(&payload[i*frameBytes])), frameBytes, 0, 0, 0,
+			(*C.int16_t)(unsafe.Pointer(&samples[i*frameSamples])))
+	}
+	return samples, nil
+}
+
+func (c *codec) Close() error {
+	if c.encoder != nil {
+		C.closeBcg729EncoderChannel(c.encoder)
+		c.encoder = nil
+	}
+	if c.decoder != nil {
+		C.closeBcg729DecoderChannel(c.decoder)
+		c.decoder = nil
+	}
+	return nil
+}
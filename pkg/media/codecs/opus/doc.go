@@ -0,0 +1,5 @@
+// Package opus the Opus codec plug-in, backed by libopus with cgo. Build with -tags opus and import it
+// for its side effect, the codec is then available as media.NewCodec("opus"):
+//
+//	import _ "github.com/cloudwebrtc/go-sip-ua/pkg/media/codecs/opus"
+package opus
@@ -0,0 +1,104 @@
+// +build cgo,opus
+
+package opus
+
+/*
+#cgo pkg-config: opus
+#include <opus.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+)
+
+const (
+	// PayloadType preferred dynamic payload type.
+	PayloadType = 111
+	// sampleRate of the samples and clock rate of the RTP timestamps.
+	sampleRate = 48000
+	// maxPayload bytes of an encoded frame.
+	maxPayload = 1500
+	// maxFrame samples of a decoded frame, 120 ms.
+	maxFrame = sampleRate * 120 / 1000
+)
+
+func init() {
+	media.RegisterCodec("opus", newCodec)
+}
+
+// codec mono Opus for VoIP.
+type codec struct {
+	encoder *C.OpusEncoder
+	decoder *C.OpusDecoder
+}
+
+func newCodec() (media.Codec, error) {
+	var err C.int
+	encoder := C.opus_encoder_create(sampleRate, 1, C.OPUS_APPLICATION_VOIP, &err)
+	if err != C.OPUS_OK {
+		return nil, fmt.Errorf("opus encoder: %s", C.GoString(C.opus_strerror(err)))
+	}
+	decoder := C.opus_decoder_create(sampleRate, 1, &err)
+	if err != C.OPUS_OK {
+		C.opus_encoder_destroy(encoder)
+		return nil, fmt.Errorf("opus decoder: %s", C.GoString(C.opus_strerror(err)))
+	}
+	return &codec{encoder: encoder, decoder: decoder}, nil
+}
+
+func (c *codec) Name() string { return "opus" }
+
+func (c *codec) PayloadType() uint8 { return PayloadType }
+
+func (c *codec) ClockRate() int { return sampleRate }
+
+// Channels the rtpmap of Opus is always opus/48000/2, RFC 7587.
+func (c *codec) Channels() int { return 2 }
+
+func (c *codec) SampleRate() int { return sampleRate }
+
+func (c *codec) Fmtp() string { return "minptime=10;useinbandfec=1" }
+
+// Encode a frame of 2.5, 5, 10, 20, 40 or 60 ms.
+func (c *codec) Encode(samples []int16) ([]byte, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("empty frame")
+	}
+	payload := make([]byte, maxPayload)
+	n := C.opus_encode(c.encoder, (*C.opus_int16)(unsafe.Pointer(&samples[0])), C.int(len(samples)),
+		(*C.uchar)(unsafe.Pointer(&payload[0])), C.opus_int32(len(payload)))
+	if n < 0 {
+		return nil, fmt.Errorf("opus encode: %s", C.GoString(C.opus_strerror(n)))
+	}
+	return payload[:n], nil
+}
+
+func (c *codec) Decode(payload []byte) ([]int16, error) {
+	samples := make([]int16, maxFrame)
+	var data *C.uchar
+	if len(payload) > 0 {
+		data = (*C.uchar)(unsafe.Pointer(&payload[0]))
+	}
+	n := C.opus_decode(c.decoder, data, C.opus_int32(len(payload)),
+		(*C.opus_int16)(unsafe.Pointer(&samples[0])), C.int(len(samples)), 0)
+	if n < 0 {
+		return nil, fmt.Errorf("opus decode: %s", C.GoString(C.opus_strerror(n)))
+	}
+	return samples[:n], nil
+}
+
+func (c *codec) Close() error {
+	if c.encoder != nil {
+		C.opus_encoder_destroy(c.encoder)
+		c.encoder = nil
+	}
+	if c.decoder != nil {
+		C.opus_decoder_destroy(c.decoder)
+		c.decoder = nil
+	}
+	return nil
+}
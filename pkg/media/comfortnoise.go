@@ -0,0 +1,224 @@
+package media
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+const (
+	// PayloadCN static payload type of the 8 kHz comfort noise, RFC 3389.
+	PayloadCN = 13
+	// DefaultComfortNoiseInterval a CN packet is resent during a silence at most this often.
+	DefaultComfortNoiseInterval = 200 * time.Millisecond
+	// overloadLevel 0 dBov of the 16 bits linear samples.
+	overloadLevel = 32767
+	// silentLevel the lowest noise level, -127 dBov.
+	silentLevel = 127
+	// noiseLevelStep dB of change of the noise level resent without waiting for the interval.
+	noiseLevelStep = 3
+)
+
+// ComfortNoisePayload the payload type of the comfort noise of the audio stream of a session description,
+// at the clock rate of its first codec.
+func ComfortNoisePayload(desc string) (uint8, bool) {
+	session, err := sdp.ParseString(desc)
+	if err != nil {
+		return 0, false
+	}
+	for _, media := range session.Media {
+		if media.Type != "audio" || media.Port == 0 {
+			continue
+		}
+		clockRate := 0
+		for _, format := range media.Format {
+			name := strings.ToLower(format.Name)
+			rate := format.ClockRate
+			if len(name) == 0 {
+				name, rate = staticPayloads[format.Payload], toneSampleRate
+			}
+			if name != "cn" && !signalingCodecs[name] && clockRate == 0 {
+				clockRate = rate
+			}
+		}
+		for _, format := range media.Format {
+			if format.Payload == PayloadCN && len(format.Name) == 0 && clockRate == toneSampleRate {
+				return PayloadCN, true
+			}
+			if strings.EqualFold(format.Name, "cn") && format.ClockRate == clockRate {
+				return format.Payload, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// AddComfortNoise a session description offering the 8 kHz comfort noise in its audio stream, e.g. for an
+// offer with silence suppression. Unchanged if the stream already has it.
+func AddComfortNoise(desc string) string {
+	eol := "\r\n"
+	if !strings.Contains(desc, eol) {
+		eol = "\n"
+	}
+	lines := strings.Split(strings.TrimRight(desc, "\r\n"), eol)
+	out := make([]string, 0, len(lines)+1)
+	audio, done := false, false
+	endAudio := func() {
+		if audio && !done {
+			out = append(out, "a=rtpmap:13 CN/8000")
+			done = true
+		}
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "m=") {
+			endAudio()
+			audio = false
+			fields := strings.Fields(line)
+			if !done && strings.HasPrefix(line, "m=audio ") && len(fields) > 3 && fields[1] != "0" {
+				audio = true
+				for _, payload := range fields[3:] {
+					if payload == "13" {
+						return desc
+					}
+				}
+				line += " 13"
+			}
+		}
+		out = append(out, line)
+	}
+	endAudio()
+	return strings.Join(out, eol) + eol
+}
+
+// NoiseLevel the level of a frame of samples in -dBov, as carried by a CN payload.
+func NoiseLevel(samples []int16) byte {
+	if len(samples) == 0 {
+		return silentLevel
+	}
+	energy := 0.0
+	for _, sample := range samples {
+		energy += float64(sample) * float64(sample)
+	}
+	rms := math.Sqrt(energy / float64(len(samples)))
+	if rms < 1 {
+		return silentLevel
+	}
+	level := -20 * math.Log10(rms/overloadLevel)
+	if level < 0 {
+		return 0
+	}
+	if level > silentLevel {
+		return silentLevel
+	}
+	return byte(level)
+}
+
+// comfortNoisePayload the CN payload of a noise level, without spectral information.
+func comfortNoisePayload(level byte) []byte {
+	return []byte{level & 0x7f}
+}
+
+// ComfortNoiseGenerator generates the noise of the silences of a received stream from its CN packets,
+// instead of playing dead silence once the sender stops sending. The spectral information is ignored,
+// the noise is white.
+type ComfortNoiseGenerator struct {
+	level byte
+	rand  *rand.Rand
+}
+
+// NewComfortNoiseGenerator .
+func NewComfortNoiseGenerator() *ComfortNoiseGenerator {
+	return &ComfortNoiseGenerator{
+		level: silentLevel,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Update the noise level from the payload of a received CN packet.
+func (g *ComfortNoiseGenerator) Update(payload []byte) {
+	if len(payload) > 0 {
+		g.level = payload[0] & 0x7f
+	}
+}
+
+// Level the current noise level in -dBov.
+func (g *ComfortNoiseGenerator) Level() byte {
+	return g.level
+}
+
+// Generate the next samples of noise.
+func (g *ComfortNoiseGenerator) Generate(samples int) []int16 {
+	frame := make([]int16, samples)
+	if g.level >= silentLevel {
+		return frame
+	}
+	// Uniform noise of the RMS of the level.
+	peak := math.Sqrt(3) * overloadLevel * math.Pow(10, -float64(g.level)/20)
+	if peak > overloadLevel {
+		peak = overloadLevel
+	}
+	for i := range frame {
+		frame[i] = int16((2*g.rand.Float64() - 1) * peak)
+	}
+	return frame
+}
+
+// SilenceSuppressor encodes the frames of a stream sent with silence suppression: the frames are sent while
+// the VAD detects voice, and CN packets during the silences, at their start, when the noise level changes,
+// and every interval.
+type SilenceSuppressor struct {
+	codec     Codec
+	payload   uint8
+	cnPayload uint8
+	vad       *VAD
+	// Interval between the CN packets of a silence.
+	Interval time.Duration
+	silent   bool
+	sinceCN  time.Duration
+	level    byte
+}
+
+// NewSilenceSuppressor payload and cnPayload are the payload types negotiated for the codec and the CN.
+func NewSilenceSuppressor(codec Codec, payload uint8, cnPayload uint8, vad *VAD) *SilenceSuppressor {
+	return &SilenceSuppressor{
+		codec:     codec,
+		payload:   payload,
+		cnPayload: cnPayload,
+		vad:       vad,
+		Interval:  DefaultComfortNoiseInterval,
+	}
+}
+
+// Next the packet to send for the next frame of samples, its marker set on the first frame of a talkspurt,
+// nil when nothing is sent. The sequence number, timestamp and SSRC are left to the caller.
+func (s *SilenceSuppressor) Next(samples []int16) (*RTPPacket, error) {
+	duration := time.Duration(len(samples)) * time.Second / time.Duration(s.codec.SampleRate())
+	if s.vad.Process(samples) {
+		payload, err := s.codec.Encode(samples)
+		if err != nil {
+			return nil, err
+		}
+		marker := s.silent
+		s.silent = false
+		return &RTPPacket{Marker: marker, PayloadType: s.payload, Payload: payload}, nil
+	}
+	level := NoiseLevel(samples)
+	s.sinceCN += duration
+	if s.silent && s.sinceCN < s.Interval && absDiff(level, s.level) < noiseLevelStep {
+		return nil, nil
+	}
+	s.silent = true
+	s.sinceCN = 0
+	s.level = level
+	return &RTPPacket{PayloadType: s.cnPayload, Payload: comfortNoisePayload(level)}, nil
+}
+
+func absDiff(a byte, b byte) byte {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,162 @@
+package ice
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	// ufragLength and pwdLength of the local credentials, RFC 8839 at least 4 and 22.
+	ufragLength = 8
+	pwdLength   = 24
+	// hostPreference type preference of the host candidates, RFC 8445 5.1.2.2.
+	hostPreference = 126
+	// credentialChars ice-char, RFC 8839.
+	credentialChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789+/"
+)
+
+// LiteAgent an ICE-lite agent (RFC 8445 2.5) on an anchored media endpoint: it only has host candidates,
+// answers the connectivity checks of the full agent of the peer, e.g. a WebRTC client, and sends the media to
+// the address the peer nominates, without performing checks of its own. It is always the controlled agent.
+type LiteAgent struct {
+	mutex       sync.Mutex
+	ufrag       string
+	pwd         string
+	remoteUfrag string
+	remotePwd   string
+	selected    *net.UDPAddr
+	onSelected  func(addr *net.UDPAddr)
+}
+
+// NewLiteAgent an agent with random local credentials.
+func NewLiteAgent() *LiteAgent {
+	return &LiteAgent{
+		ufrag: randomCredential(ufragLength),
+		pwd:   randomCredential(pwdLength),
+	}
+}
+
+func randomCredential(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	for i := range buf {
+		buf[i] = credentialChars[int(buf[i])%len(credentialChars)]
+	}
+	return string(buf)
+}
+
+// LocalCredentials the ice-ufrag and ice-pwd of the agent.
+func (a *LiteAgent) LocalCredentials() (string, string) {
+	return a.ufrag, a.pwd
+}
+
+// SetRemoteCredentials the ice-ufrag and ice-pwd of the peer, from its session description.
+func (a *LiteAgent) SetRemoteCredentials(ufrag string, pwd string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if ufrag != a.remoteUfrag || pwd != a.remotePwd {
+		// ICE restart, the peer nominates again.
+		a.selected = nil
+	}
+	a.remoteUfrag, a.remotePwd = ufrag, pwd
+}
+
+// OnSelected set the handler called when the peer nominates a new address.
+func (a *LiteAgent) OnSelected(handler func(addr *net.UDPAddr)) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.onSelected = handler
+}
+
+// Selected the address nominated by the peer, nil until then.
+func (a *LiteAgent) Selected() *net.UDPAddr {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.selected
+}
+
+// Candidate the a=candidate value of the host candidate addr of the component, 1 for RTP and 2 for RTCP.
+func Candidate(addr *net.UDPAddr, component int) string {
+	foundation := crc32.ChecksumIEEE([]byte("host" + addr.IP.String()))
+	priority := hostPreference<<24 | 65535<<8 | (256 - component)
+	return fmt.Sprintf("%d %d udp %d %s %d typ host", foundation, component, priority, addr.IP.String(), addr.Port)
+}
+
+// Attributes the media level attributes of the agent, its credentials and the candidates of the RTP addrs.
+// The session level a=ice-lite is up to the caller.
+func (a *LiteAgent) Attributes(addrs ...*net.UDPAddr) []string {
+	attributes := []string{"ice-ufrag:" + a.ufrag, "ice-pwd:" + a.pwd}
+	for _, addr := range addrs {
+		attributes = append(attributes, "candidate:"+Candidate(addr, 1))
+	}
+	return attributes
+}
+
+// Handle a packet received from raddr on the endpoint: the connectivity checks are answered with the
+// response to send back to raddr, and true. False for the other packets, e.g. RTP, which are processed as usual.
+func (a *LiteAgent) Handle(buf []byte, raddr *net.UDPAddr) ([]byte, bool) {
+	if !IsSTUN(buf) {
+		return nil, false
+	}
+	request, err := parseSTUN(buf)
+	if err != nil || request.typ != stunBindingRequest {
+		// Malformed, or a response or indication a lite agent never expects.
+		return nil, true
+	}
+
+	a.mutex.Lock()
+	response := &stunMessage{transaction: request.transaction}
+	username, found := request.get(attrUsername)
+	// The USERNAME of the checks is the local ufrag, a colon and the remote one.
+	valid := found && (bytes.HasPrefix(username.value, []byte(a.ufrag+":")) &&
+		(len(a.remoteUfrag) == 0 || string(username.value) == a.ufrag+":"+a.remoteUfrag))
+	if !valid || !request.checkIntegrity([]byte(a.pwd)) {
+		a.mutex.Unlock()
+		response.typ = stunBindingError
+		response.add(attrErrorCode, errorCode(401, "Unauthorized"))
+		return response.encode(nil), true
+	}
+	if _, controlled := request.get(attrIceControlled); controlled {
+		// Both agents controlled, the peer is expected to switch to controlling, RFC 8445 7.3.1.1.
+		a.mutex.Unlock()
+		response.typ = stunBindingError
+		response.add(attrErrorCode, errorCode(487, "Role Conflict"))
+		return response.encode([]byte(a.pwd)), true
+	}
+
+	var onSelected func(addr *net.UDPAddr)
+	if _, nominated := request.get(attrUseCandidate); nominated {
+		if a.selected == nil || a.selected.String() != raddr.String() {
+			a.selected = raddr
+			onSelected = a.onSelected
+		}
+	}
+	a.mutex.Unlock()
+	if onSelected != nil {
+		onSelected(raddr)
+	}
+
+	response.typ = stunBindingSuccess
+	response.add(attrXorMappedAddress, response.xorAddress(raddr))
+	return response.encode([]byte(a.pwd)), true
+}
+
+// RemoteCredentials the ice-ufrag and ice-pwd of the first stream of a session description, or of the session.
+func RemoteCredentials(desc string) (string, string, bool) {
+	ufrag, pwd := "", ""
+	for _, line := range strings.Split(desc, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "a=ice-ufrag:") && len(ufrag) == 0:
+			ufrag = strings.TrimPrefix(line, "a=ice-ufrag:")
+		case strings.HasPrefix(line, "a=ice-pwd:") && len(pwd) == 0:
+			pwd = strings.TrimPrefix(line, "a=ice-pwd:")
+		}
+	}
+	return ufrag, pwd, len(ufrag) > 0 && len(pwd) > 0
+}
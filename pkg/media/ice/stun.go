@@ -0,0 +1,200 @@
+package ice
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net"
+)
+
+const (
+	stunHeaderSize  = 20
+	stunMagicCookie = 0x2112A442
+	// stunFingerprintXor RFC 5389 15.5.
+	stunFingerprintXor = 0x5354554e
+
+	stunBindingRequest = 0x0001
+	stunBindingSuccess = 0x0101
+	stunBindingError   = 0x0111
+
+	attrUsername           = 0x0006
+	attrMessageIntegrity   = 0x0008
+	attrErrorCode          = 0x0009
+	attrChannelNumber      = 0x000C
+	attrLifetime           = 0x000D
+	attrXorPeerAddress     = 0x0012
+	attrData               = 0x0013
+	attrRealm              = 0x0014
+	attrNonce              = 0x0015
+	attrXorRelayedAddress  = 0x0016
+	attrRequestedTransport = 0x0019
+	attrXorMappedAddress   = 0x0020
+	attrUseCandidate       = 0x0025
+	attrFingerprint        = 0x8028
+	attrIceControlled      = 0x8029
+)
+
+var (
+	errNotSTUN = errors.New("not a STUN message")
+)
+
+// IsSTUN the packet is a STUN message, e.g. a connectivity check received on an RTP port, RFC 7983.
+func IsSTUN(buf []byte) bool {
+	return len(buf) >= stunHeaderSize && buf[0] < 2 && binary.BigEndian.Uint32(buf[4:]) == stunMagicCookie
+}
+
+type stunAttribute struct {
+	typ   uint16
+	value []byte
+	// offset of the attribute in the message.
+	offset int
+}
+
+type stunMessage struct {
+	typ         uint16
+	transaction [12]byte
+	attributes  []stunAttribute
+	raw         []byte
+}
+
+func parseSTUN(buf []byte) (*stunMessage, error) {
+	if !IsSTUN(buf) || int(binary.BigEndian.Uint16(buf[2:]))+stunHeaderSize != len(buf) {
+		return nil, errNotSTUN
+	}
+	m := &stunMessage{typ: binary.BigEndian.Uint16(buf), raw: buf}
+	copy(m.transaction[:], buf[8:stunHeaderSize])
+	for offset := stunHeaderSize; offset < len(buf); {
+		if offset+4 > len(buf) {
+			return nil, errNotSTUN
+		}
+		typ := binary.BigEndian.Uint16(buf[offset:])
+		length := int(binary.BigEndian.Uint16(buf[offset+2:]))
+		if offset+4+length > len(buf) {
+			return nil, errNotSTUN
+		}
+		m.attributes = append(m.attributes, stunAttribute{typ: typ, value: buf[offset+4 : offset+4+length], offset: offset})
+		offset += 4 + (length+3)&^3
+	}
+	return m, nil
+}
+
+func (m *stunMessage) get(typ uint16) (stunAttribute, bool) {
+	for _, attr := range m.attributes {
+		if attr.typ == typ {
+			return attr, true
+		}
+	}
+	return stunAttribute{}, false
+}
+
+// checkIntegrity the MESSAGE-INTEGRITY of the message is the HMAC of key.
+func (m *stunMessage) checkIntegrity(key []byte) bool {
+	attr, found := m.get(attrMessageIntegrity)
+	if !found || len(attr.value) != sha1.Size {
+		return false
+	}
+	// The length covers the message up to the MESSAGE-INTEGRITY included.
+	buf := append([]byte{}, m.raw[:attr.offset]...)
+	binary.BigEndian.PutUint16(buf[2:], uint16(attr.offset+4+sha1.Size-stunHeaderSize))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(buf)
+	return hmac.Equal(mac.Sum(nil), attr.value)
+}
+
+// add an attribute, padded to 4 bytes.
+func (m *stunMessage) add(typ uint16, value []byte) {
+	m.attributes = append(m.attributes, stunAttribute{typ: typ, value: value})
+}
+
+// encode the message, with a MESSAGE-INTEGRITY if key isn't nil, and a FINGERPRINT.
+func (m *stunMessage) encode(key []byte) []byte {
+	buf := make([]byte, stunHeaderSize, 512)
+	binary.BigEndian.PutUint16(buf, m.typ)
+	binary.BigEndian.PutUint32(buf[4:], stunMagicCookie)
+	copy(buf[8:], m.transaction[:])
+	write := func(typ uint16, value []byte) {
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint16(header, typ)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(value)))
+		buf = append(buf, header...)
+		buf = append(buf, value...)
+		for len(buf)%4 != 0 {
+			buf = append(buf, 0)
+		}
+	}
+	for _, attr := range m.attributes {
+		write(attr.typ, attr.value)
+	}
+	if key != nil {
+		binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)+4+sha1.Size-stunHeaderSize))
+		mac := hmac.New(sha1.New, key)
+		mac.Write(buf)
+		write(attrMessageIntegrity, mac.Sum(nil))
+	}
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(buf)+8-stunHeaderSize))
+	fingerprint := make([]byte, 4)
+	binary.BigEndian.PutUint32(fingerprint, crc32.ChecksumIEEE(buf)^stunFingerprintXor)
+	write(attrFingerprint, fingerprint)
+	return buf
+}
+
+// xorAddress the value of a XOR-MAPPED-ADDRESS.
+func (m *stunMessage) xorAddress(addr *net.UDPAddr) []byte {
+	ip := addr.IP.To4()
+	family := byte(0x01)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 0x02
+	}
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:], uint16(addr.Port)^uint16(stunMagicCookie>>16))
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint32(key, stunMagicCookie)
+	copy(key[4:], m.transaction[:])
+	for i := range ip {
+		value[4+i] = ip[i] ^ key[i]
+	}
+	return value
+}
+
+// parseXorAddress the address of a XOR-MAPPED-ADDRESS, XOR-PEER-ADDRESS or XOR-RELAYED-ADDRESS.
+func (m *stunMessage) parseXorAddress(typ uint16) (*net.UDPAddr, bool) {
+	attr, found := m.get(typ)
+	if !found || len(attr.value) < 8 {
+		return nil, false
+	}
+	size := net.IPv4len
+	if attr.value[1] == 0x02 {
+		size = net.IPv6len
+	}
+	if len(attr.value) < 4+size {
+		return nil, false
+	}
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint32(key, stunMagicCookie)
+	copy(key[4:], m.transaction[:])
+	ip := make(net.IP, size)
+	for i := range ip {
+		ip[i] = attr.value[4+i] ^ key[i]
+	}
+	port := binary.BigEndian.Uint16(attr.value[2:]) ^ uint16(stunMagicCookie>>16)
+	return &net.UDPAddr{IP: ip, Port: int(port)}, true
+}
+
+// errorCode the value of an ERROR-CODE.
+func errorCode(code int, reason string) []byte {
+	value := []byte{0, 0, byte(code / 100), byte(code % 100)}
+	return append(value, reason...)
+}
+
+// code the status code of an error response, 0 if none.
+func (m *stunMessage) code() int {
+	attr, found := m.get(attrErrorCode)
+	if !found || len(attr.value) < 4 {
+		return 0
+	}
+	return int(attr.value[2]&0x07)*100 + int(attr.value[3])
+}
@@ -0,0 +1,527 @@
+package ice
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+)
+
+const (
+	turnAllocate         = 0x0003
+	turnRefresh          = 0x0004
+	turnSendIndication   = 0x0016
+	turnDataIndication   = 0x0017
+	turnCreatePermission = 0x0008
+	turnChannelBind      = 0x0009
+
+	// DefaultTURNLifetime requested lifetime of the allocations.
+	DefaultTURNLifetime = 10 * time.Minute
+	// turnPermissionRefresh permissions last 5 minutes, channel bindings 10, RFC 8656.
+	turnPermissionRefresh = 4 * time.Minute
+	turnChannelRefresh    = 9 * time.Minute
+	// turnMaintenance period of the refresh of the allocation, permissions and channels.
+	turnMaintenance = 30 * time.Second
+	// turnRTO initial retransmission timeout of the requests, doubled after each of the turnRetries.
+	turnRTO     = 500 * time.Millisecond
+	turnRetries = 4
+	// turnChannelMin first channel number.
+	turnChannelMin = 0x4000
+	// protocolUDP REQUESTED-TRANSPORT of an UDP relay.
+	protocolUDP = 17
+)
+
+var (
+	// ErrTURNClosed the client is closed.
+	ErrTURNClosed = errors.New("turn: client closed")
+	// ErrTURNTimeout the TURN server didn't answer.
+	ErrTURNTimeout = errors.New("turn: request timeout")
+)
+
+// TURNServer a TURN server and the long-term credentials of the user.
+type TURNServer struct {
+	// Address host:port of the server, UDP.
+	Address  string
+	Username string
+	Password string
+}
+
+// TURNError an error response of the TURN server.
+type TURNError struct {
+	Code   int
+	Reason string
+}
+
+func (e *TURNError) Error() string {
+	return fmt.Sprintf("turn: %d %s", e.Code, e.Reason)
+}
+
+// turnPacket a packet received from a peer through the relay.
+type turnPacket struct {
+	data []byte
+	peer *net.UDPAddr
+	// pooled the packet buffer of data, returned to the pool once read.
+	pooled *[]byte
+}
+
+// turnPermission the permission and channel of a peer.
+type turnPermission struct {
+	channel   uint16
+	permitted time.Time
+	bound     time.Time
+}
+
+// TURNClient a UDP allocation on a TURN server (RFC 8656), relaying the media of the UA through restrictive
+// NATs: the packets are sent to the peers and received from them through the relayed address, over a
+// channel once bound, in Send and Data indications before. The allocation, the permissions and the channels
+// are refreshed until Close.
+type TURNClient struct {
+	server  *TURNServer
+	conn    *net.UDPConn
+	mutex   sync.Mutex
+	realm   string
+	nonce   string
+	key     []byte
+	relayed *net.UDPAddr
+	mapped  *net.UDPAddr
+	// expires of the allocation.
+	expires     time.Time
+	peers       map[string]*turnPermission
+	channels    map[uint16]*net.UDPAddr
+	nextChannel uint16
+	pending     map[[12]byte]chan *stunMessage
+	packets     chan turnPacket
+	closed      chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewTURNClient a client of server, Allocate requests the relayed address.
+func NewTURNClient(server *TURNServer) (*TURNClient, error) {
+	raddr, err := net.ResolveUDPAddr("udp", server.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+	c := &TURNClient{
+		server:      server,
+		conn:        conn,
+		peers:       make(map[string]*turnPermission),
+		channels:    make(map[uint16]*net.UDPAddr),
+		nextChannel: turnChannelMin,
+		pending:     make(map[[12]byte]chan *stunMessage),
+		packets:     make(chan turnPacket, 256),
+		closed:      make(chan struct{}),
+	}
+	go c.read()
+	return c, nil
+}
+
+// Allocate the relayed address, for lifetime, DefaultTURNLifetime if 0.
+func (c *TURNClient) Allocate(lifetime time.Duration) (*net.UDPAddr, error) {
+	if lifetime <= 0 {
+		lifetime = DefaultTURNLifetime
+	}
+	request := &stunMessage{typ: turnAllocate}
+	request.add(attrRequestedTransport, []byte{protocolUDP, 0, 0, 0})
+	request.add(attrLifetime, seconds(lifetime))
+	response, err := c.request(request)
+	if err != nil {
+		return nil, err
+	}
+	relayed, found := response.parseXorAddress(attrXorRelayedAddress)
+	if !found {
+		return nil, fmt.Errorf("turn: allocation without a relayed address")
+	}
+	mapped, _ := response.parseXorAddress(attrXorMappedAddress)
+	c.mutex.Lock()
+	c.relayed, c.mapped = relayed, mapped
+	c.expires = time.Now().Add(responseLifetime(response, lifetime))
+	c.mutex.Unlock()
+	go c.maintain()
+	return relayed, nil
+}
+
+// RelayedAddr the relayed address of the allocation, nil before.
+func (c *TURNClient) RelayedAddr() *net.UDPAddr {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.relayed
+}
+
+// MappedAddr the server reflexive address of the client, as seen by the server.
+func (c *TURNClient) MappedAddr() *net.UDPAddr {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.mapped
+}
+
+// Candidate the a=candidate value of the relayed candidate of the component, 1 for RTP and 2 for RTCP.
+func (c *TURNClient) Candidate(component int) string {
+	relayed, mapped := c.RelayedAddr(), c.MappedAddr()
+	if relayed == nil {
+		return ""
+	}
+	foundation := crc32.ChecksumIEEE([]byte("relay" + relayed.IP.String()))
+	priority := 65535<<8 | (256 - component)
+	candidate := fmt.Sprintf("%d %d udp %d %s %d typ relay", foundation, component, priority, relayed.IP.String(), relayed.Port)
+	if mapped != nil {
+		candidate += fmt.Sprintf(" raddr %s rport %d", mapped.IP.String(), mapped.Port)
+	}
+	return candidate
+}
+
+// CreatePermission allow the packets from the peers, WriteTo does it for the peers it sends to.
+func (c *TURNClient) CreatePermission(peers ...*net.UDPAddr) error {
+	request := &stunMessage{typ: turnCreatePermission}
+	c.newTransaction(request)
+	for _, peer := range peers {
+		request.add(attrXorPeerAddress, request.xorAddress(peer))
+	}
+	if _, err := c.request(request); err != nil {
+		return err
+	}
+	now := time.Now()
+	c.mutex.Lock()
+	for _, peer := range peers {
+		c.permission(peer).permitted = now
+	}
+	c.mutex.Unlock()
+	return nil
+}
+
+// BindChannel bind a channel to the peer, its packets are then relayed with 4 bytes of overhead instead of 36.
+func (c *TURNClient) BindChannel(peer *net.UDPAddr) error {
+	c.mutex.Lock()
+	permission := c.permission(peer)
+	if permission.channel == 0 {
+		if c.nextChannel > 0x4FFF {
+			c.mutex.Unlock()
+			return fmt.Errorf("turn: no channel left")
+		}
+		permission.channel = c.nextChannel
+		c.nextChannel++
+	}
+	channel := permission.channel
+	c.mutex.Unlock()
+
+	request := &stunMessage{typ: turnChannelBind}
+	c.newTransaction(request)
+	number := make([]byte, 4)
+	binary.BigEndian.PutUint16(number, channel)
+	request.add(attrChannelNumber, number)
+	request.add(attrXorPeerAddress, request.xorAddress(peer))
+	if _, err := c.request(request); err != nil {
+		return err
+	}
+	now := time.Now()
+	c.mutex.Lock()
+	permission.permitted, permission.bound = now, now
+	c.channels[channel] = peer
+	c.mutex.Unlock()
+	return nil
+}
+
+// permission of the peer, created if needed, locked.
+func (c *TURNClient) permission(peer *net.UDPAddr) *turnPermission {
+	permission, found := c.peers[peer.String()]
+	if !found {
+		permission = &turnPermission{}
+		c.peers[peer.String()] = permission
+	}
+	return permission
+}
+
+// channel the channel bound to the peer, 0 if none.
+func (c *TURNClient) channel(peer *net.UDPAddr) uint16 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if permission, found := c.peers[peer.String()]; found && !permission.bound.IsZero() {
+		return permission.channel
+	}
+	return 0
+}
+
+// WriteTo send a packet to the peer through the relay, over its channel, bound on the first packet.
+func (c *TURNClient) WriteTo(data []byte, peer *net.UDPAddr) (int, error) {
+	channel := c.channel(peer)
+	if channel == 0 {
+		if err := c.BindChannel(peer); err != nil {
+			return 0, err
+		}
+		channel = c.channel(peer)
+	}
+	// ChannelData, RFC 8656 12.4, padded to 4 bytes.
+	size := 4 + (len(data)+3)&^3
+	var buf []byte
+	if size <= utils.PacketSize {
+		packet := utils.GetPacket()
+		defer utils.PutPacket(packet)
+		buf = (*packet)[:size]
+		for i := 4 + len(data); i < size; i++ {
+			buf[i] = 0
+		}
+	} else {
+		buf = make([]byte, size)
+	}
+	binary.BigEndian.PutUint16(buf, channel)
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(data)))
+	copy(buf[4:], data)
+	if _, err := c.conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// ReadFrom the next packet received from a peer through the relay.
+func (c *TURNClient) ReadFrom(buf []byte) (int, *net.UDPAddr, error) {
+	select {
+	case packet := <-c.packets:
+		n := copy(buf, packet.data)
+		if packet.pooled != nil {
+			utils.PutPacket(packet.pooled)
+		}
+		return n, packet.peer, nil
+	case <-c.closed:
+		return 0, nil, ErrTURNClosed
+	}
+}
+
+// Close release the allocation.
+func (c *TURNClient) Close() error {
+	if c.RelayedAddr() != nil {
+		request := &stunMessage{typ: turnRefresh}
+		request.add(attrLifetime, seconds(0))
+		c.request(request)
+	}
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.conn.Close()
+}
+
+// read the responses, indications and channel data from the server.
+func (c *TURNClient) read() {
+	packet := utils.GetPacket()
+	defer utils.PutPacket(packet)
+	buf := *packet
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			c.closeOnce.Do(func() { close(c.closed) })
+			return
+		}
+		if n >= 4 && buf[0]&0xC0 == 0x40 {
+			channel := binary.BigEndian.Uint16(buf)
+			length := int(binary.BigEndian.Uint16(buf[2:]))
+			c.mutex.Lock()
+			peer, found := c.channels[channel]
+			c.mutex.Unlock()
+			if found && 4+length <= n {
+				pooled := utils.GetPacket()
+				data := (*pooled)[:copy(*pooled, buf[4:4+length])]
+				c.deliver(turnPacket{data: data, peer: peer, pooled: pooled})
+			}
+			continue
+		}
+		message, err := parseSTUN(append([]byte{}, buf[:n]...))
+		if err != nil {
+			continue
+		}
+		if message.typ == turnDataIndication {
+			peer, found := message.parseXorAddress(attrXorPeerAddress)
+			if data, ok := message.get(attrData); found && ok {
+				c.deliver(turnPacket{data: data.value, peer: peer})
+			}
+			continue
+		}
+		c.mutex.Lock()
+		pending, found := c.pending[message.transaction]
+		c.mutex.Unlock()
+		if found {
+			select {
+			case pending <- message:
+			default:
+			}
+		}
+	}
+}
+
+// deliver a packet to ReadFrom, dropped if the reader is late.
+func (c *TURNClient) deliver(packet turnPacket) {
+	select {
+	case c.packets <- packet:
+	default:
+		if packet.pooled != nil {
+			utils.PutPacket(packet.pooled)
+		}
+	}
+}
+
+// newTransaction a random transaction ID.
+func (c *TURNClient) newTransaction(request *stunMessage) {
+	rand.Read(request.transaction[:])
+}
+
+// request send a request authenticated with the long-term credentials, the nonce is renewed on a 401
+// or a 438 Stale Nonce. The error responses are returned as TURNError.
+func (c *TURNClient) request(request *stunMessage) (*stunMessage, error) {
+	attributes := request.attributes
+	for attempt := 0; ; attempt++ {
+		c.mutex.Lock()
+		realm, nonce, key := c.realm, c.nonce, c.key
+		c.mutex.Unlock()
+		if request.transaction == ([12]byte{}) || attempt > 0 {
+			// The XOR addresses are relative to the transaction ID.
+			old := request.transaction
+			c.newTransaction(request)
+			request.attributes = rexor(attributes, old, request)
+		}
+		message := &stunMessage{typ: request.typ, transaction: request.transaction, attributes: request.attributes}
+		if key != nil {
+			message.add(attrUsername, []byte(c.server.Username))
+			message.add(attrRealm, []byte(realm))
+			message.add(attrNonce, []byte(nonce))
+		}
+		response, err := c.transact(message, key)
+		if err != nil {
+			return nil, err
+		}
+		if response.typ == request.typ|0x0100 {
+			return response, nil
+		}
+		code := response.code()
+		if (code == 401 || code == 438) && attempt == 0 {
+			realmAttr, _ := response.get(attrRealm)
+			nonceAttr, _ := response.get(attrNonce)
+			c.mutex.Lock()
+			if len(realmAttr.value) > 0 {
+				c.realm = string(realmAttr.value)
+			}
+			c.nonce = string(nonceAttr.value)
+			sum := md5.Sum([]byte(c.server.Username + ":" + c.realm + ":" + c.server.Password))
+			c.key = sum[:]
+			c.mutex.Unlock()
+			continue
+		}
+		reason := ""
+		if attr, found := response.get(attrErrorCode); found && len(attr.value) > 4 {
+			reason = string(attr.value[4:])
+		}
+		return nil, &TURNError{Code: code, Reason: reason}
+	}
+}
+
+// rexor the attributes of a request with the XOR addresses encoded for a new transaction ID.
+func rexor(attributes []stunAttribute, old [12]byte, request *stunMessage) []stunAttribute {
+	if old == ([12]byte{}) {
+		return attributes
+	}
+	previous := &stunMessage{transaction: old}
+	out := make([]stunAttribute, len(attributes))
+	for i, attr := range attributes {
+		out[i] = attr
+		if attr.typ == attrXorPeerAddress {
+			previous.attributes = []stunAttribute{attr}
+			if peer, found := previous.parseXorAddress(attrXorPeerAddress); found {
+				out[i].value = request.xorAddress(peer)
+			}
+		}
+	}
+	return out
+}
+
+// transact send a message and wait for its response, with retransmissions.
+func (c *TURNClient) transact(message *stunMessage, key []byte) (*stunMessage, error) {
+	response := make(chan *stunMessage, 1)
+	c.mutex.Lock()
+	c.pending[message.transaction] = response
+	c.mutex.Unlock()
+	defer func() {
+		c.mutex.Lock()
+		delete(c.pending, message.transaction)
+		c.mutex.Unlock()
+	}()
+	raw := message.encode(key)
+	rto := turnRTO
+	for i := 0; i < turnRetries; i++ {
+		if _, err := c.conn.Write(raw); err != nil {
+			return nil, err
+		}
+		select {
+		case msg := <-response:
+			return msg, nil
+		case <-time.After(rto):
+			rto *= 2
+		case <-c.closed:
+			return nil, ErrTURNClosed
+		}
+	}
+	return nil, ErrTURNTimeout
+}
+
+// maintain refresh the allocation, the permissions and the channels until Close.
+func (c *TURNClient) maintain() {
+	ticker := time.NewTicker(turnMaintenance)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case now := <-ticker.C:
+			c.mutex.Lock()
+			refresh := c.expires.Sub(now) < 2*turnMaintenance
+			permissions, channels := []*net.UDPAddr{}, []*net.UDPAddr{}
+			for _, peer := range c.channels {
+				if now.Sub(c.peers[peer.String()].bound) > turnChannelRefresh {
+					channels = append(channels, peer)
+				}
+			}
+			for address, permission := range c.peers {
+				if permission.channel == 0 && now.Sub(permission.permitted) > turnPermissionRefresh {
+					if peer, err := net.ResolveUDPAddr("udp", address); err == nil {
+						permissions = append(permissions, peer)
+					}
+				}
+			}
+			c.mutex.Unlock()
+			if refresh {
+				request := &stunMessage{typ: turnRefresh}
+				request.add(attrLifetime, seconds(DefaultTURNLifetime))
+				if response, err := c.request(request); err == nil {
+					c.mutex.Lock()
+					c.expires = now.Add(responseLifetime(response, DefaultTURNLifetime))
+					c.mutex.Unlock()
+				}
+			}
+			if len(permissions) > 0 {
+				c.CreatePermission(permissions...)
+			}
+			for _, peer := range channels {
+				c.BindChannel(peer)
+			}
+		}
+	}
+}
+
+// seconds the value of a LIFETIME.
+func seconds(d time.Duration) []byte {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, uint32(d/time.Second))
+	return value
+}
+
+// responseLifetime the LIFETIME granted by the server, requested if none.
+func responseLifetime(response *stunMessage, requested time.Duration) time.Duration {
+	if attr, found := response.get(attrLifetime); found && len(attr.value) == 4 {
+		return time.Duration(binary.BigEndian.Uint32(attr.value)) * time.Second
+	}
+	return requested
+}
@@ -0,0 +1,242 @@
+package media
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJitterMinDelay lowest playout delay of the jitter buffers.
+	DefaultJitterMinDelay = 20 * time.Millisecond
+	// DefaultJitterMaxDelay highest playout delay of the jitter buffers.
+	DefaultJitterMaxDelay = 300 * time.Millisecond
+	// jitterFactor playout delay in multiples of the interarrival jitter.
+	jitterFactor = 3
+	// jitterMaxPackets packets buffered at most, the oldest are dropped beyond.
+	jitterMaxPackets = 256
+)
+
+// RTPPacket a parsed RTP packet, RFC 3550.
+type RTPPacket struct {
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	Payload        []byte
+}
+
+// ParseRTP parse an RTP packet, its payload refers to buf.
+func ParseRTP(buf []byte) (*RTPPacket, error) {
+	if len(buf) < 12 || buf[0]>>6 != 2 {
+		return nil, fmt.Errorf("not an RTP packet")
+	}
+	offset := 12 + 4*int(buf[0]&0x0f)
+	if buf[0]&0x10 != 0 {
+		if len(buf) < offset+4 {
+			return nil, fmt.Errorf("truncated RTP header extension")
+		}
+		offset += 4 + 4*int(binary.BigEndian.Uint16(buf[offset+2:]))
+	}
+	end := len(buf)
+	if buf[0]&0x20 != 0 && end > 0 {
+		end -= int(buf[end-1])
+	}
+	if offset > end {
+		return nil, fmt.Errorf("truncated RTP packet")
+	}
+	return &RTPPacket{
+		Marker:         buf[1]&0x80 != 0,
+		PayloadType:    buf[1] & 0x7f,
+		SequenceNumber: binary.BigEndian.Uint16(buf[2:]),
+		Timestamp:      binary.BigEndian.Uint32(buf[4:]),
+		SSRC:           binary.BigEndian.Uint32(buf[8:]),
+		Payload:        buf[offset:end],
+	}, nil
+}
+
+// JitterBufferConfig .
+type JitterBufferConfig struct {
+	// ClockRate of the RTP timestamps, 8000 for G.711.
+	ClockRate int
+	// MinDelay and MaxDelay band of the playout delay, which follows the interarrival jitter.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+}
+
+// JitterBufferStats counters and estimations of a jitter buffer.
+type JitterBufferStats struct {
+	Received   uint64 `json:"received"`
+	Played     uint64 `json:"played"`
+	Lost       uint64 `json:"lost"`
+	Late       uint64 `json:"late"`
+	Duplicates uint64 `json:"duplicates"`
+	Dropped    uint64 `json:"dropped"`
+	// Jitter interarrival jitter, RFC 3550.
+	Jitter time.Duration `json:"jitter"`
+	// Delay current playout delay.
+	Delay time.Duration `json:"delay"`
+}
+
+// JitterBuffer an adaptive jitter buffer of received RTP packets, e.g. before a playback, a recording,
+// a mixer or a transcoder. Packets are pushed as they arrive, and popped every packetization time in
+// sequence, once their playout time has come. The playout delay follows the interarrival jitter, it is
+// adapted at the start of the talkspurts, and after an underrun, so that the speech isn't cut.
+type JitterBuffer struct {
+	mutex   sync.Mutex
+	config  JitterBufferConfig
+	packets []*RTPPacket
+	started bool
+	nextSeq uint16
+	// base playout time of the timestamp baseTS.
+	base   time.Time
+	baseTS uint32
+	// transit and jitter in timestamp units.
+	transit int64
+	jitter  float64
+	// step timestamp units between two packets in sequence.
+	step  uint32
+	delay time.Duration
+	stats JitterBufferStats
+}
+
+// NewJitterBuffer .
+func NewJitterBuffer(config JitterBufferConfig) *JitterBuffer {
+	if config.ClockRate <= 0 {
+		config.ClockRate = toneSampleRate
+	}
+	if config.MinDelay <= 0 {
+		config.MinDelay = DefaultJitterMinDelay
+	}
+	if config.MaxDelay < config.MinDelay {
+		config.MaxDelay = DefaultJitterMaxDelay
+		if config.MaxDelay < config.MinDelay {
+			config.MaxDelay = config.MinDelay
+		}
+	}
+	return &JitterBuffer{
+		config: config,
+		delay:  config.MinDelay,
+	}
+}
+
+// duration of ts timestamp units.
+func (j *JitterBuffer) duration(ts int64) time.Duration {
+	return time.Duration(ts) * time.Second / time.Duration(j.config.ClockRate)
+}
+
+// playout the playout time of a packet.
+func (j *JitterBuffer) playout(packet *RTPPacket) time.Time {
+	return j.base.Add(j.duration(int64(int32(packet.Timestamp - j.baseTS))))
+}
+
+// Push a packet received at arrival.
+func (j *JitterBuffer) Push(packet *RTPPacket, arrival time.Time) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.stats.Received++
+
+	// Interarrival jitter, RFC 3550 A.8.
+	transit := int64(arrival.UnixNano())*int64(j.config.ClockRate)/int64(time.Second) - int64(packet.Timestamp)
+	if j.started {
+		d := transit - j.transit
+		if d < 0 {
+			d = -d
+		}
+		j.jitter += (float64(d) - j.jitter) / 16
+	}
+	j.transit = transit
+
+	if !j.started {
+		j.started = true
+		j.nextSeq = packet.SequenceNumber
+		j.rebase(packet, arrival)
+	} else if int16(packet.SequenceNumber-j.nextSeq) < 0 {
+		j.stats.Late++
+		return
+	} else if len(j.packets) == 0 && (packet.Marker || packet.SequenceNumber != j.nextSeq || arrival.After(j.playout(packet))) {
+		// A talkspurt starts, or the buffer ran dry: the playout delay is adapted.
+		j.nextSeq = packet.SequenceNumber
+		j.rebase(packet, arrival)
+	}
+
+	idx := sort.Search(len(j.packets), func(i int) bool {
+		return int16(j.packets[i].SequenceNumber-packet.SequenceNumber) >= 0
+	})
+	if idx < len(j.packets) && j.packets[idx].SequenceNumber == packet.SequenceNumber {
+		j.stats.Duplicates++
+		return
+	}
+	j.packets = append(j.packets, nil)
+	copy(j.packets[idx+1:], j.packets[idx:])
+	j.packets[idx] = packet
+	if idx > 0 && j.packets[idx-1].SequenceNumber == packet.SequenceNumber-1 {
+		if step := packet.Timestamp - j.packets[idx-1].Timestamp; int32(step) > 0 {
+			j.step = step
+		}
+	}
+	if len(j.packets) > jitterMaxPackets {
+		j.stats.Dropped++
+		j.packets = j.packets[1:]
+		j.nextSeq = j.packets[0].SequenceNumber
+	}
+}
+
+// rebase play packet after the target delay.
+func (j *JitterBuffer) rebase(packet *RTPPacket, arrival time.Time) {
+	delay := jitterFactor * j.duration(int64(j.jitter))
+	if delay < j.config.MinDelay {
+		delay = j.config.MinDelay
+	}
+	if delay > j.config.MaxDelay {
+		delay = j.config.MaxDelay
+	}
+	j.delay = delay
+	j.base = arrival.Add(delay)
+	j.baseTS = packet.Timestamp
+}
+
+// Pop the next packet in sequence if its playout time has come at now. Lost is true when the next packet
+// is missing while a later one is due, the caller conceals one frame. Nil and false when nothing is due.
+func (j *JitterBuffer) Pop(now time.Time) (packet *RTPPacket, lost bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if len(j.packets) == 0 {
+		return nil, false
+	}
+	head := j.packets[0]
+	// The missing packets before the head are due a step each before it.
+	gap := int64(head.SequenceNumber - j.nextSeq)
+	if now.Before(j.playout(head).Add(-j.duration(gap * int64(j.step)))) {
+		return nil, false
+	}
+	if gap > 0 {
+		j.stats.Lost++
+		j.nextSeq++
+		return nil, true
+	}
+	j.packets = j.packets[1:]
+	j.nextSeq++
+	j.stats.Played++
+	return head, false
+}
+
+// Len the packets buffered.
+func (j *JitterBuffer) Len() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return len(j.packets)
+}
+
+// Stats .
+func (j *JitterBuffer) Stats() JitterBufferStats {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	stats := j.stats
+	stats.Jitter = j.duration(int64(j.jitter))
+	stats.Delay = j.delay
+	return stats
+}
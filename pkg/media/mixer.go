@@ -0,0 +1,123 @@
+package media
+
+import (
+	"sync"
+)
+
+const (
+	// mixerQueue frames queued per participant, the oldest are dropped beyond.
+	mixerQueue = 5
+)
+
+// Mixer mixes the 8 kHz audio of the participants of a conference, each one hears the others (mix-minus).
+// The participants write their frames as they arrive, and Mix is called every packetization time.
+type Mixer struct {
+	mutex  sync.Mutex
+	queues map[string][][]int16
+}
+
+// NewMixer .
+func NewMixer(ids ...string) *Mixer {
+	m := &Mixer{queues: make(map[string][][]int16)}
+	for _, id := range ids {
+		m.queues[id] = nil
+	}
+	return m
+}
+
+// Add a participant.
+func (m *Mixer) Add(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, found := m.queues[id]; !found {
+		m.queues[id] = nil
+	}
+}
+
+// Remove a participant.
+func (m *Mixer) Remove(id string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.queues, id)
+}
+
+// Write the next frame of a participant, ignored for the unknown ones.
+func (m *Mixer) Write(id string, frame []int16) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	queue, found := m.queues[id]
+	if !found {
+		return
+	}
+	if len(queue) >= mixerQueue {
+		queue = queue[1:]
+	}
+	m.queues[id] = append(queue, frame)
+}
+
+// Mix the next frame of samples for each participant, the sum of the frames of the others,
+// silence for the participants without a frame.
+func (m *Mixer) Mix(samples int) map[string][]int16 {
+	m.mutex.Lock()
+	inputs := make(map[string][]int16, len(m.queues))
+	for id, queue := range m.queues {
+		if len(queue) > 0 {
+			inputs[id] = queue[0]
+			m.queues[id] = queue[1:]
+		} else {
+			inputs[id] = nil
+		}
+	}
+	m.mutex.Unlock()
+
+	sum := make([]int, samples)
+	for _, frame := range inputs {
+		for i := 0; i < samples && i < len(frame); i++ {
+			sum[i] += int(frame[i])
+		}
+	}
+	outputs := make(map[string][]int16, len(inputs))
+	for id, frame := range inputs {
+		output := make([]int16, samples)
+		for i := range output {
+			value := sum[i]
+			if i < len(frame) {
+				value -= int(frame[i])
+			}
+			if value > 32767 {
+				value = 32767
+			} else if value < -32768 {
+				value = -32768
+			}
+			output[i] = int16(value)
+		}
+		outputs[id] = output
+	}
+	return outputs
+}
+
+// DecodeG711 the linear samples of a PCMU or PCMA frame.
+func DecodeG711(payload uint8, frame []byte) []int16 {
+	samples := make([]int16, len(frame))
+	for i, b := range frame {
+		if payload == PayloadPCMA {
+			samples[i] = ALawToLinear(b)
+		} else {
+			samples[i] = ULawToLinear(b)
+		}
+	}
+	return samples
+}
+
+// EncodeG711 the PCMU or PCMA frame of linear samples.
+func EncodeG711(payload uint8, samples []int16) []byte {
+	frame := make([]byte, len(samples))
+	for i, sample := range samples {
+		if payload == PayloadPCMA {
+			frame[i] = LinearToALaw(sample)
+		} else {
+			frame[i] = LinearToULaw(sample)
+		}
+	}
+	return frame
+}
@@ -0,0 +1,87 @@
+package rtp
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+)
+
+// Config of the media sockets.
+type Config struct {
+	// PortMin and PortMax range of the RTP ports.
+	PortMin int
+	PortMax int
+	// BindAddress local address of the sockets, or Interface the network interface whose first address
+	// is bound. All the addresses if both are empty.
+	BindAddress string
+	Interface   string
+	// DSCP of the RTP packets, utils.DSCPExpedited (EF) for the voice, 0 to leave them unmarked.
+	DSCP int
+}
+
+// DefaultConfig the default range, all the addresses, and EF.
+func DefaultConfig() Config {
+	return Config{
+		PortMin: DefaultPortMin,
+		PortMax: DefaultPortMax,
+		DSCP:    utils.DSCPExpedited,
+	}
+}
+
+// BindIP the address the sockets are bound to, nil for all.
+func (c *Config) BindIP() (net.IP, error) {
+	if len(c.BindAddress) > 0 {
+		ip := net.ParseIP(c.BindAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid media bind address %s", c.BindAddress)
+		}
+		return ip, nil
+	}
+	if len(c.Interface) == 0 {
+		return nil, nil
+	}
+	iface, err := net.InterfaceByName(c.Interface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var found net.IP
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && (ipnet.IP.IsGlobalUnicast() || ipnet.IP.IsLoopback()) {
+			// IPv4 first.
+			if ipnet.IP.To4() != nil {
+				return ipnet.IP, nil
+			}
+			if found == nil {
+				found = ipnet.IP
+			}
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no address on the interface %s", c.Interface)
+	}
+	return found, nil
+}
+
+// Listen a UDP socket on a free port of the range, marked with the DSCP.
+func (c *Config) Listen() (*net.UDPConn, error) {
+	ip, err := c.BindIP()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := utils.ListenUDPInPortRange(c.PortMin, c.PortMax, &net.UDPAddr{IP: ip})
+	if err != nil {
+		return nil, err
+	}
+	if c.DSCP != 0 {
+		if err := utils.SetTOS(conn, "udp", utils.DSCPToTOS(c.DSCP)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
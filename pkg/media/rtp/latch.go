@@ -0,0 +1,173 @@
+package rtp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRelatchSilence the latched source must be silent this long before another one is latched.
+	DefaultRelatchSilence = 2 * time.Second
+	// DefaultMaxRelatches relatches allowed per minute.
+	DefaultMaxRelatches = 3
+)
+
+var privateNets = func() []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "169.254.0.0/16", "fc00::/7", "fe80::/10"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		nets = append(nets, ipnet)
+	}
+	return nets
+}()
+
+// IsPrivateIP the address is private (RFC 1918, RFC 6598 shared, link-local or unique local), unreachable
+// from the public side of a NAT.
+func IsPrivateIP(ip net.IP) bool {
+	for _, ipnet := range privateNets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// LatchConfig the security limits of a Latch.
+type LatchConfig struct {
+	// Always latch, even when the address of the SDP is public.
+	Always bool
+	// Silence the latched source must be silent this long before another source is latched, e.g. after a
+	// NAT rebinding. Zero is DefaultRelatchSilence.
+	Silence time.Duration
+	// MaxRelatches per minute, beyond them the packets of new sources are dropped. Zero is DefaultMaxRelatches,
+	// negative never relatches.
+	MaxRelatches int
+	// SameIP relatch only to a new port of the latched IP.
+	SameIP bool
+	// SameSSRC relatch only to a source sending the SSRC of the latched one.
+	SameSSRC bool
+}
+
+// Latch symmetric RTP (comedia, RFC 4961): when the far end is behind a NAT, the address of its SDP is
+// private, the media are sent back to the source of its first RTP packets instead. Once latched, the
+// packets of other sources are dropped, a new source is only latched within the limits of the config,
+// so that a third party can't hijack the stream.
+type Latch struct {
+	mutex    sync.Mutex
+	config   LatchConfig
+	signaled *net.UDPAddr
+	enabled  bool
+	latched  *net.UDPAddr
+	ssrc     uint32
+	last     time.Time
+	// relatches the times of the relatches of the last minute.
+	relatches []time.Time
+	dropped   uint64
+}
+
+// NewLatch a latch of the stream signaled at the address of the SDP, enabled when it is private or
+// unspecified, or with config.Always.
+func NewLatch(signaled *net.UDPAddr, config LatchConfig) *Latch {
+	if config.Silence <= 0 {
+		config.Silence = DefaultRelatchSilence
+	}
+	if config.MaxRelatches == 0 {
+		config.MaxRelatches = DefaultMaxRelatches
+	}
+	enabled := config.Always || signaled == nil || signaled.IP == nil || signaled.IP.IsUnspecified() || IsPrivateIP(signaled.IP)
+	return &Latch{
+		config:   config,
+		signaled: signaled,
+		enabled:  enabled,
+	}
+}
+
+// Enabled the latch learns the source of the packets.
+func (l *Latch) Enabled() bool {
+	return l.enabled
+}
+
+// Accept a packet received from source at now, false when it must be dropped. The first RTP packet
+// latches its source.
+func (l *Latch) Accept(pkt []byte, source *net.UDPAddr, now time.Time) bool {
+	if !l.enabled || source == nil {
+		return true
+	}
+	// Only RTP/RTCP packets latch, version 2.
+	if len(pkt) < 12 || pkt[0]>>6 != 2 {
+		return false
+	}
+	ssrc := binary.BigEndian.Uint32(pkt[8:])
+	if pkt[1] >= 192 && pkt[1] <= 223 {
+		// RTCP, the SSRC of the sender follows the length.
+		ssrc = binary.BigEndian.Uint32(pkt[4:])
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	switch {
+	case l.latched == nil:
+	case sameAddr(l.latched, source):
+		l.last = now
+		return true
+	case !l.relatch(source, ssrc, now):
+		l.dropped++
+		return false
+	default:
+		l.relatches = append(l.relatches, now)
+	}
+	l.latched = &net.UDPAddr{IP: append(net.IP{}, source.IP...), Port: source.Port, Zone: source.Zone}
+	l.ssrc = ssrc
+	l.last = now
+	return true
+}
+
+// relatch a new source may replace the latched one.
+func (l *Latch) relatch(source *net.UDPAddr, ssrc uint32, now time.Time) bool {
+	if l.config.MaxRelatches < 0 || now.Sub(l.last) < l.config.Silence {
+		return false
+	}
+	if l.config.SameIP && !l.latched.IP.Equal(source.IP) {
+		return false
+	}
+	if l.config.SameSSRC && ssrc != l.ssrc {
+		return false
+	}
+	recent := l.relatches[:0]
+	for _, at := range l.relatches {
+		if now.Sub(at) < time.Minute {
+			recent = append(recent, at)
+		}
+	}
+	l.relatches = recent
+	return len(l.relatches) < l.config.MaxRelatches
+}
+
+// Target the address to send the media to: the latched source, the address of the SDP until then.
+func (l *Latch) Target() *net.UDPAddr {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.latched != nil {
+		return l.latched
+	}
+	return l.signaled
+}
+
+// Latched the latched source, nil until the first packet.
+func (l *Latch) Latched() *net.UDPAddr {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.latched
+}
+
+// Dropped the packets of the sources refused.
+func (l *Latch) Dropped() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.dropped
+}
+
+func sameAddr(a *net.UDPAddr, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
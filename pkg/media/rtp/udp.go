@@ -2,7 +2,9 @@ package rtp
 
 import (
 	"net"
+	"time"
 
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media/ice"
 	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
 	"github.com/ghettovoice/gosip/log"
 )
@@ -18,16 +20,22 @@ type RtpUDPStream struct {
 	onPacket func(pkt []byte, raddr net.Addr)
 	laddr    *net.UDPAddr
 	raddr    *net.UDPAddr
+	ice      *ice.LiteAgent
+	turn     *ice.TURNClient
+	latch    *Latch
 	logger   log.Logger
 }
 
 func NewRtpUDPStream(bind string, portMin, portMax int, callback func(pkt []byte, raddr net.Addr)) *RtpUDPStream {
+	return NewRtpUDPStreamWithConfig(Config{PortMin: portMin, PortMax: portMax, BindAddress: bind}, callback)
+}
+
+// NewRtpUDPStreamWithConfig a stream on the port range and the address of config, its packets marked with the DSCP.
+func NewRtpUDPStreamWithConfig(config Config, callback func(pkt []byte, raddr net.Addr)) *RtpUDPStream {
 
 	logger := utils.NewLogrusLogger(log.InfoLevel, "Media", nil)
 
-	lAddr := &net.UDPAddr{IP: net.ParseIP(bind), Port: 0}
-	var err error
-	conn, err := utils.ListenUDPInPortRange(portMin, portMax, lAddr)
+	conn, err := config.Listen()
 	if err != nil {
 		logger.Errorf("ListenUDP: err => %v", err)
 		return nil
@@ -37,7 +45,7 @@ func NewRtpUDPStream(bind string, portMin, portMax int, callback func(pkt []byte
 		conn:     conn,
 		stop:     false,
 		onPacket: callback,
-		laddr:    lAddr,
+		laddr:    conn.LocalAddr().(*net.UDPAddr),
 		logger:   logger,
 	}
 }
@@ -54,14 +62,61 @@ func (r *RtpUDPStream) LocalAddr() *net.UDPAddr {
 	return r.laddr
 }
 
+// SetICE answer the connectivity checks of the peer with an ICE-lite agent, the packets are then sent to the
+// address it nominates.
+func (r *RtpUDPStream) SetICE(agent *ice.LiteAgent) {
+	r.ice = agent
+}
+
+// SetTURN send and receive the packets through the relayed address of a TURN allocation, e.g. from
+// profile.NewTURNClient, instead of the local port.
+func (r *RtpUDPStream) SetTURN(client *ice.TURNClient) {
+	r.turn = client
+	go r.readTURN()
+}
+
+// SetLatch learn the address of the far end from the source of its packets, symmetric RTP: the packets of
+// the other sources are dropped, and the media are sent back to the latched one.
+func (r *RtpUDPStream) SetLatch(latch *Latch) {
+	r.latch = latch
+}
+
+func (r *RtpUDPStream) readTURN() {
+	packet := utils.GetPacket()
+	defer utils.PutPacket(packet)
+	buf := *packet
+	for {
+		n, raddr, err := r.turn.ReadFrom(buf)
+		if err != nil || r.stop {
+			return
+		}
+		r.onPacket(buf[0:n], raddr)
+	}
+}
+
 func (r *RtpUDPStream) Close() {
 	r.stop = true
 	r.conn.Close()
+	if r.turn != nil {
+		r.turn.Close()
+	}
 }
 
 func (r *RtpUDPStream) Send(pkt []byte, raddr *net.UDPAddr) (int, error) {
+	if r.ice != nil {
+		if selected := r.ice.Selected(); selected != nil {
+			raddr = selected
+		}
+	} else if r.latch != nil && r.latch.Enabled() {
+		if target := r.latch.Target(); target != nil {
+			raddr = target
+		}
+	}
 	r.Log().Debugf("Send to %v, length %d", raddr.String(), len(pkt))
 	r.raddr = raddr
+	if r.turn != nil {
+		return r.turn.WriteTo(pkt, raddr)
+	}
 	return r.conn.WriteToUDP(pkt, raddr)
 }
 
@@ -69,7 +124,9 @@ func (r *RtpUDPStream) Read() {
 
 	r.Log().Infof("Read")
 
-	buf := make([]byte, 1500)
+	packet := utils.GetPacket()
+	defer utils.PutPacket(packet)
+	buf := *packet
 	for {
 		if r.stop {
 			r.Log().Infof("Terminate: stop rtp conn now!")
@@ -83,6 +140,20 @@ func (r *RtpUDPStream) Read() {
 
 		r.Log().Tracef("Read rtp from: %v, length: %d", raddr.String(), n)
 
+		if r.ice != nil {
+			if response, handled := r.ice.Handle(buf[0:n], raddr.(*net.UDPAddr)); handled {
+				if response != nil {
+					r.conn.WriteTo(response, raddr)
+				}
+				continue
+			}
+		}
+
+		if r.latch != nil && !r.latch.Accept(buf[0:n], raddr.(*net.UDPAddr), time.Now()) {
+			r.Log().Debugf("Dropped packet from %v, not the latched source", raddr)
+			continue
+		}
+
 		if !r.stop {
 			r.onPacket(buf[0:n], raddr)
 		}
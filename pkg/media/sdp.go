@@ -1 +1,264 @@
 package media
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+const (
+	// DefaultPtime packetization time in ms when the SDP has no a=ptime.
+	DefaultPtime = 20
+	// rtpOverhead IPv4 + UDP + RTP header bytes per packet.
+	rtpOverhead = 20 + 8 + 12
+	// DefaultAudioBandwidth kbit/s of the unknown audio codecs.
+	DefaultAudioBandwidth = 64
+	// DefaultVideoBandwidth kbit/s of the video streams without a b= line.
+	DefaultVideoBandwidth = 512
+)
+
+var (
+	// codecBitrates payload bitrate of the audio codecs in kbit/s, by lower case encoding name.
+	codecBitrates = map[string]int{
+		"pcmu":   64,
+		"pcma":   64,
+		"g722":   64,
+		"g729":   8,
+		"g723":   6,
+		"gsm":    13,
+		"ilbc":   15,
+		"opus":   40,
+		"speex":  24,
+		"amr":    12,
+		"amr-wb": 24,
+	}
+	// staticPayloads encoding names of the static RTP payload types, RFC 3551.
+	staticPayloads = map[uint8]string{
+		0:  "pcmu",
+		3:  "gsm",
+		4:  "g723",
+		8:  "pcma",
+		9:  "g722",
+		13: "cn",
+		18: "g729",
+	}
+	// signalingCodecs carry no media of their own.
+	signalingCodecs = map[string]bool{
+		"telephone-event": true,
+		"cn":              true,
+		"red":             true,
+		"ulpfec":          true,
+		"rtx":             true,
+	}
+)
+
+// CodecBandwidth bandwidth on the wire in kbit/s of an audio codec at the given ptime,
+// including the IP/UDP/RTP overhead.
+func CodecBandwidth(name string, ptime int) int {
+	if ptime <= 0 {
+		ptime = DefaultPtime
+	}
+	bitrate, found := codecBitrates[strings.ToLower(name)]
+	if !found {
+		bitrate = DefaultAudioBandwidth
+	}
+	return bitrate + rtpOverhead*8/ptime
+}
+
+// SessionBandwidth estimates the bandwidth in kbit/s of a session description:
+// preferred is the bandwidth of the first codec of each stream, i.e. the negotiated
+// one in an answer, lowest the bandwidth of the cheapest codecs.
+// b=AS/b=TIAS lines take precedence over the codec estimation.
+func SessionBandwidth(desc string) (preferred int, lowest int, err error) {
+	session, err := sdp.ParseString(desc)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, media := range session.Media {
+		if media.Port == 0 || media.Mode == sdp.Inactive {
+			continue
+		}
+		if bandwidth, found := mediaBandwidth(media); found {
+			preferred += bandwidth
+			lowest += bandwidth
+			continue
+		}
+		switch media.Type {
+		case "audio":
+			p, l := audioBandwidth(media)
+			preferred += p
+			lowest += l
+		case "video":
+			preferred += DefaultVideoBandwidth
+			lowest += DefaultVideoBandwidth
+		}
+	}
+	return preferred, lowest, nil
+}
+
+// MediaAddresses the host:port of the active streams of a session description.
+func MediaAddresses(desc string) ([]string, error) {
+	session, err := sdp.ParseString(desc)
+	if err != nil {
+		return nil, err
+	}
+	addresses := []string{}
+	for _, media := range session.Media {
+		if media.Port == 0 {
+			continue
+		}
+		connection := session.Connection
+		if len(media.Connection) > 0 {
+			connection = media.Connection[0]
+		}
+		if connection == nil {
+			continue
+		}
+		addresses = append(addresses, net.JoinHostPort(connection.Address, strconv.Itoa(media.Port)))
+	}
+	return addresses, nil
+}
+
+// lineEnd the end of line of a session description, CRLF as in RFC 4566 or LF.
+func lineEnd(desc string) string {
+	if strings.Contains(desc, "\r\n") {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// eachLine call fn with the lines of desc, ended by eol, without copying them.
+func eachLine(desc string, eol string, fn func(line string)) {
+	desc = strings.TrimRight(desc, "\r\n")
+	for len(desc) > 0 {
+		line := desc
+		if end := strings.Index(desc, eol); end >= 0 {
+			line, desc = desc[:end], desc[end+len(eol):]
+		} else {
+			desc = ""
+		}
+		fn(line)
+	}
+}
+
+// SetDirection a new version of a session description with the direction attribute of its streams
+// set to sendrecv, sendonly, recvonly or inactive, e.g. sendonly to put a call on hold.
+func SetDirection(desc string, direction string) string {
+	eol := lineEnd(desc)
+	out := utils.GetBuffer()
+	defer utils.PutBuffer(out)
+	inMedia, found := false, false
+	endMedia := func() {
+		if inMedia && !found {
+			out.WriteString("a=" + direction + eol)
+		}
+	}
+	eachLine(desc, eol, func(line string) {
+		switch {
+		case strings.HasPrefix(line, "o="):
+			// The version of the origin increases with each change of the session.
+			if fields := strings.Fields(line); len(fields) == 6 {
+				if version, err := strconv.ParseUint(fields[2], 10, 64); err == nil {
+					fields[2] = strconv.FormatUint(version+1, 10)
+					line = strings.Join(fields, " ")
+				}
+			}
+		case strings.HasPrefix(line, "m="):
+			endMedia()
+			inMedia, found = true, false
+		case line == "a=sendrecv" || line == "a=sendonly" || line == "a=recvonly" || line == "a=inactive":
+			if !inMedia {
+				// Session level, the streams carry their own.
+				return
+			}
+			line, found = "a="+direction, true
+		}
+		out.WriteString(line)
+		out.WriteString(eol)
+	})
+	endMedia()
+	return out.String()
+}
+
+// Direction the direction attribute of the first stream of a session description, sendrecv if none.
+func Direction(desc string) string {
+	direction, inMedia, done := "sendrecv", false, false
+	eachLine(desc, "\n", func(line string) {
+		line = strings.TrimSpace(line)
+		switch {
+		case done:
+		case strings.HasPrefix(line, "m="):
+			done, inMedia = inMedia, true
+		case inMedia && (line == "a=sendrecv" || line == "a=sendonly" || line == "a=recvonly" || line == "a=inactive"):
+			direction, done = line[2:], true
+		}
+	})
+	return direction
+}
+
+// G711Payload the first G.711 payload type, PayloadPCMU or PayloadPCMA, of the audio stream of a session description.
+func G711Payload(desc string) (uint8, bool) {
+	session, err := sdp.ParseString(desc)
+	if err != nil {
+		return 0, false
+	}
+	for _, media := range session.Media {
+		if media.Type != "audio" || media.Port == 0 {
+			continue
+		}
+		for _, format := range media.Format {
+			name := strings.ToLower(format.Name)
+			if len(name) == 0 {
+				name = staticPayloads[format.Payload]
+			}
+			switch name {
+			case "pcmu":
+				return PayloadPCMU, true
+			case "pcma":
+				return PayloadPCMA, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func mediaBandwidth(media *sdp.Media) (int, bool) {
+	for _, b := range media.Bandwidth {
+		switch strings.ToUpper(b.Type) {
+		case "AS":
+			return b.Value, true
+		case "TIAS":
+			return b.Value / 1000, true
+		}
+	}
+	return 0, false
+}
+
+func audioBandwidth(media *sdp.Media) (preferred int, lowest int) {
+	ptime := DefaultPtime
+	if value := media.Attributes.Get("ptime"); len(value) > 0 {
+		if p, err := strconv.Atoi(value); err == nil {
+			ptime = p
+		}
+	}
+	for _, format := range media.Format {
+		name := format.Name
+		if len(name) == 0 {
+			name = staticPayloads[format.Payload]
+		}
+		if signalingCodecs[strings.ToLower(name)] {
+			continue
+		}
+		bandwidth := CodecBandwidth(name, ptime)
+		if preferred == 0 {
+			preferred = bandwidth
+		}
+		if lowest == 0 || bandwidth < lowest {
+			lowest = bandwidth
+		}
+	}
+	return preferred, lowest
+}
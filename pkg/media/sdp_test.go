@@ -0,0 +1,49 @@
+package media
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetDirection(t *testing.T) {
+	held := SetDirection(benchOffer, "sendonly")
+	if !strings.Contains(held, "o=alice 2890844526 2890844527 IN IP4 192.0.2.1\r\n") {
+		t.Errorf("origin version not increased:\n%s", held)
+	}
+	if strings.Contains(held, "a=sendrecv") || Direction(held) != "sendonly" {
+		t.Errorf("direction %s, want sendonly:\n%s", Direction(held), held)
+	}
+	if !strings.HasSuffix(held, "a=sendonly\r\n") {
+		t.Errorf("lines not ended by CRLF:\n%q", held)
+	}
+	// Without a direction attribute, one is added to each stream.
+	lf := "v=0\no=- 1 1 IN IP4 192.0.2.1\ns=-\nt=0 0\nm=audio 49170 RTP/AVP 0\nm=video 51372 RTP/AVP 31\n"
+	want := "v=0\no=- 1 2 IN IP4 192.0.2.1\ns=-\nt=0 0\nm=audio 49170 RTP/AVP 0\na=inactive\nm=video 51372 RTP/AVP 31\na=inactive\n"
+	if got := SetDirection(lf, "inactive"); got != want {
+		t.Errorf("SetDirection = %q, want %q", got, want)
+	}
+}
+
+func TestDirection(t *testing.T) {
+	for _, test := range []struct {
+		desc, want string
+	}{
+		{"v=0\r\na=recvonly\r\nm=audio 49170 RTP/AVP 0\r\n", "sendrecv"},
+		{"v=0\r\nm=audio 49170 RTP/AVP 0\r\na=recvonly\r\n", "recvonly"},
+		{"v=0\nm=audio 49170 RTP/AVP 0\nm=video 51372 RTP/AVP 31\na=inactive\n", "sendrecv"},
+	} {
+		if got := Direction(test.desc); got != test.want {
+			t.Errorf("Direction(%q) = %s, want %s", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestStripEncryption(t *testing.T) {
+	clear := StripEncryption(benchOffer)
+	if strings.Contains(clear, "a=crypto:") || strings.Count(clear, "\r\n") != strings.Count(benchOffer, "\r\n")-1 {
+		t.Errorf("StripEncryption = %q", clear)
+	}
+	if StripEncryption(clear) != clear {
+		t.Error("offer in the clear changed")
+	}
+}
@@ -0,0 +1,78 @@
+package media
+
+import (
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/pixelbender/go-sdp/sdp"
+)
+
+// Encryption of the media streams of a session.
+type Encryption string
+
+const (
+	// EncryptionNone RTP in the clear.
+	EncryptionNone Encryption = "none"
+	// EncryptionSRTP SRTP keyed in the SDP, a=crypto (SDES, RFC 4568).
+	EncryptionSRTP Encryption = "srtp"
+	// EncryptionDTLS SRTP keyed by DTLS, a=fingerprint (RFC 5763).
+	EncryptionDTLS Encryption = "dtls-srtp"
+)
+
+// MediaEncryption the encryption of the active streams of a session description, e.g. the negotiated one of
+// an answer, EncryptionNone as soon as one of them is in the clear.
+func MediaEncryption(desc string) (Encryption, error) {
+	session, err := sdp.ParseString(desc)
+	if err != nil {
+		return EncryptionNone, err
+	}
+	encryption := EncryptionNone
+	for _, media := range session.Media {
+		if media.Port == 0 {
+			continue
+		}
+		proto := strings.ToUpper(media.Proto)
+		switch {
+		case strings.Contains(proto, "TLS") && (media.Attributes.Has("fingerprint") || session.Attributes.Has("fingerprint")):
+			encryption = EncryptionDTLS
+		case media.Attributes.Has("crypto"):
+			encryption = EncryptionSRTP
+		default:
+			return EncryptionNone, nil
+		}
+	}
+	return encryption, nil
+}
+
+// RequiresEncryption the streams of an offer use a secure profile, e.g. RTP/SAVP, they can't be answered in
+// the clear. The best effort SRTP offers, RTP/AVP with a=crypto, can.
+func RequiresEncryption(desc string) bool {
+	session, err := sdp.ParseString(desc)
+	if err != nil {
+		return false
+	}
+	for _, media := range session.Media {
+		if media.Port != 0 && strings.Contains(strings.ToUpper(media.Proto), "SAVP") {
+			return true
+		}
+	}
+	return false
+}
+
+// StripEncryption a session description without the a=crypto lines of its streams, e.g. a best effort SRTP
+// offer renegotiated in the clear.
+func StripEncryption(desc string) string {
+	if !strings.Contains(desc, "a=crypto:") {
+		return desc
+	}
+	eol := lineEnd(desc)
+	buf := utils.GetBuffer()
+	defer utils.PutBuffer(buf)
+	eachLine(desc, eol, func(line string) {
+		if !strings.HasPrefix(line, "a=crypto:") {
+			buf.WriteString(line)
+			buf.WriteString(eol)
+		}
+	})
+	return buf.String()
+}
@@ -0,0 +1,160 @@
+package media
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// PayloadPCMU .
+	PayloadPCMU = 0
+	// PayloadPCMA .
+	PayloadPCMA = 8
+	// toneSampleRate sample rate of the G.711 tones.
+	toneSampleRate = 8000
+	// toneAmplitude peak of the 16 bits linear samples, about -10 dBm0.
+	toneAmplitude = 5000
+)
+
+// Tone a cadenced tone, e.g. a ringback tone.
+type Tone struct {
+	// Frequencies in Hz mixed together.
+	Frequencies []float64
+	// On and Off durations of the cadence, Off 0 for a continuous tone.
+	On  time.Duration
+	Off time.Duration
+}
+
+var (
+	// RingbackToneITU 425 Hz, 1s on 4s off, ITU-T E.180.
+	RingbackToneITU = Tone{Frequencies: []float64{425}, On: time.Second, Off: 4 * time.Second}
+	// RingbackToneUS 440+480 Hz, 2s on 4s off.
+	RingbackToneUS = Tone{Frequencies: []float64{440, 480}, On: 2 * time.Second, Off: 4 * time.Second}
+)
+
+// ToneGenerator generates the encoded frames of a tone.
+type ToneGenerator struct {
+	tone    Tone
+	codec   Codec
+	payload uint8
+	sample  int
+}
+
+// NewToneGenerator payload is PayloadPCMU or PayloadPCMA.
+func NewToneGenerator(tone Tone, payload uint8) *ToneGenerator {
+	return NewCodecToneGenerator(tone, &g711{payload: payload}, payload)
+}
+
+// NewCodecToneGenerator a generator of the frames of codec, sent with the payload type negotiated for it.
+func NewCodecToneGenerator(tone Tone, codec Codec, payload uint8) *ToneGenerator {
+	return &ToneGenerator{
+		tone:    tone,
+		codec:   codec,
+		payload: payload,
+	}
+}
+
+// Payload .
+func (g *ToneGenerator) Payload() uint8 {
+	return g.payload
+}
+
+// ClockRate of the RTP timestamps of the frames.
+func (g *ToneGenerator) ClockRate() int {
+	return g.codec.ClockRate()
+}
+
+// Next the encoded frame of the next duration.
+func (g *ToneGenerator) Next(duration time.Duration) []byte {
+	rate := float64(g.codec.SampleRate())
+	samples := make([]int16, int(duration.Seconds()*rate))
+	period := int((g.tone.On + g.tone.Off).Seconds() * rate)
+	on := int(g.tone.On.Seconds() * rate)
+	for i := range samples {
+		value := 0.0
+		if g.tone.Off == 0 || g.sample%period < on {
+			t := float64(g.sample) / rate
+			for _, f := range g.tone.Frequencies {
+				value += math.Sin(2 * math.Pi * f * t)
+			}
+			value = value * toneAmplitude / float64(len(g.tone.Frequencies))
+		}
+		samples[i] = int16(value)
+		g.sample++
+	}
+	frame, err := g.codec.Encode(samples)
+	if err != nil {
+		return nil
+	}
+	return frame
+}
+
+// LinearToULaw G.711 mu-law encoding of a 16 bits linear sample.
+func LinearToULaw(sample int16) byte {
+	const bias = 0x84
+	const clip = 32635
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > clip {
+		s = clip
+	}
+	s += bias
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> uint(exponent+3)) & 0x0f
+	return byte(^(sign | exponent<<4 | mantissa))
+}
+
+// LinearToALaw G.711 A-law encoding of a 16 bits linear sample.
+func LinearToALaw(sample int16) byte {
+	s := int(sample) >> 3
+	sign := 0x80
+	if s < 0 {
+		s = -s - 1
+		sign = 0
+	}
+	var encoded int
+	switch {
+	case s < 32:
+		encoded = s >> 1
+	default:
+		exponent := 1
+		for v := s >> 5; v > 1 && exponent < 7; v >>= 1 {
+			exponent++
+		}
+		encoded = exponent<<4 | (s>>uint(exponent))&0x0f
+	}
+	return byte((sign | encoded) ^ 0x55)
+}
+
+// ULawToLinear G.711 mu-law decoding to a 16 bits linear sample.
+func ULawToLinear(u byte) int16 {
+	u = ^u
+	exponent := uint(u>>4) & 0x07
+	sample := ((int(u&0x0f) << 3) + 0x84) << exponent
+	sample -= 0x84
+	if u&0x80 != 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
+
+// ALawToLinear G.711 A-law decoding to a 16 bits linear sample.
+func ALawToLinear(a byte) int16 {
+	a ^= 0x55
+	exponent := uint(a>>4) & 0x07
+	sample := int(a&0x0f)<<4 + 8
+	if exponent > 0 {
+		sample = (int(a&0x0f)<<4 + 0x108) << (exponent - 1)
+	}
+	if a&0x80 == 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
@@ -0,0 +1,133 @@
+package media
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultVADThreshold dB above the noise floor detected as voice.
+	DefaultVADThreshold = 9
+	// DefaultVADHangover the voice is held active after the last voiced frame, so the ends of words aren't cut.
+	DefaultVADHangover = 200 * time.Millisecond
+	// vadMinLevel dBov below which a frame is never voice.
+	vadMinLevel = -55
+	// vadFloorAttack and vadFloorRelease adaptation rates of the noise floor, fast down and slow up.
+	vadFloorAttack  = 0.5
+	vadFloorRelease = 0.01
+)
+
+// VADConfig .
+type VADConfig struct {
+	// SampleRate of the samples, 8000 by default.
+	SampleRate int
+	// Threshold dB above the noise floor.
+	Threshold float64
+	// Hangover after the last voiced frame.
+	Hangover time.Duration
+}
+
+// VADStats the talk time analytics of a stream.
+type VADStats struct {
+	Talkspurts  uint64        `json:"talkspurts"`
+	TalkTime    time.Duration `json:"talk_time"`
+	SilenceTime time.Duration `json:"silence_time"`
+	// NoiseFloor current estimation in dBov.
+	NoiseFloor float64 `json:"noise_floor"`
+}
+
+// VAD an energy based voice activity detector, comparing each frame to an adaptive estimation of the
+// background noise, e.g. to drive the silence suppression or to measure the talk time of the parties.
+type VAD struct {
+	mutex    sync.Mutex
+	config   VADConfig
+	floor    float64
+	started  bool
+	active   bool
+	hangover time.Duration
+	stats    VADStats
+}
+
+// NewVAD .
+func NewVAD(config VADConfig) *VAD {
+	if config.SampleRate <= 0 {
+		config.SampleRate = toneSampleRate
+	}
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultVADThreshold
+	}
+	if config.Hangover < 0 {
+		config.Hangover = 0
+	} else if config.Hangover == 0 {
+		config.Hangover = DefaultVADHangover
+	}
+	return &VAD{config: config}
+}
+
+// frameLevel the level of a frame of samples in dBov.
+func frameLevel(samples []int16) float64 {
+	energy := 0.0
+	for _, sample := range samples {
+		energy += float64(sample) * float64(sample)
+	}
+	if len(samples) == 0 || energy == 0 {
+		return -silentLevel
+	}
+	return 10 * math.Log10(energy/float64(len(samples))/(overloadLevel*overloadLevel))
+}
+
+// Process the next frame of samples, true while the voice is active.
+func (v *VAD) Process(samples []int16) bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	duration := time.Duration(len(samples)) * time.Second / time.Duration(v.config.SampleRate)
+	level := frameLevel(samples)
+	if !v.started {
+		v.started = true
+		v.floor = math.Min(level, vadMinLevel)
+	}
+
+	voiced := level > vadMinLevel && level > v.floor+v.config.Threshold
+	if level < v.floor {
+		v.floor += (level - v.floor) * vadFloorAttack
+	} else if !voiced {
+		v.floor += (level - v.floor) * vadFloorRelease
+	} else {
+		// A long talkspurt slowly raises the floor, e.g. after the noise increased.
+		v.floor += (level - v.floor) * vadFloorRelease / 10
+	}
+
+	if voiced {
+		if !v.active {
+			v.stats.Talkspurts++
+		}
+		v.active = true
+		v.hangover = v.config.Hangover
+	} else if v.active {
+		v.hangover -= duration
+		v.active = v.hangover > 0
+	}
+	if v.active {
+		v.stats.TalkTime += duration
+	} else {
+		v.stats.SilenceTime += duration
+	}
+	return v.active
+}
+
+// Active the voice was active in the last frame.
+func (v *VAD) Active() bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.active
+}
+
+// Stats .
+func (v *VAD) Stats() VADStats {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	stats := v.stats
+	stats.NoiseFloor = v.floor
+	return stats
+}
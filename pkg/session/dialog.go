@@ -0,0 +1,148 @@
+package session
+
+import (
+	"fmt"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// Dialog the state of an established dialog, enough to end it without the session, e.g. after a restart.
+type Dialog struct {
+	CallID string `json:"call_id"`
+	UAType string `json:"ua_type"`
+	// Local and Remote addresses with their tags.
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	// Contact the local target, RouteSet the Route of the in-dialog requests.
+	Contact  string   `json:"contact"`
+	RouteSet []string `json:"route_set,omitempty"`
+	// CSeq the last local CSeq of the dialog, the BYE uses the next one.
+	CSeq uint32 `json:"cseq"`
+	// Recipient (Request-URI, the Contact of the peer), Destination and Source of the in-dialog requests.
+	Recipient   string `json:"recipient"`
+	Destination string `json:"destination,omitempty"`
+	Source      string `json:"source,omitempty"`
+}
+
+// Dialog the state of the dialog of the session, with the route set and the remote target of its
+// in-dialog requests, see makeRequest.
+func (s *Session) Dialog() Dialog {
+	d := Dialog{
+		CallID:  s.callID.Value(),
+		UAType:  s.uaType,
+		Local:   s.localURI.String(),
+		Remote:  s.remoteURI.String(),
+		Contact: s.contact.Address.String(),
+	}
+	if cseq, ok := s.request.CSeq(); ok {
+		d.CSeq = cseq.SeqNo
+	}
+	s.lock.Lock()
+	if d.CSeq < s.localCSeq {
+		d.CSeq = s.localCSeq
+	}
+	s.lock.Unlock()
+	switch s.uaType {
+	case "UAC":
+		d.Recipient = s.request.Recipient().String()
+		if s.response != nil {
+			if contact, ok := s.response.Contact(); ok {
+				d.Recipient = contact.Address.String()
+			}
+			// The Record-Route of the response reversed.
+			for _, uri := range utils.RecordRoutes(s.response) {
+				d.RouteSet = append([]string{uri.String()}, d.RouteSet...)
+			}
+		}
+		if len(d.RouteSet) == 0 {
+			// The pre-loaded Route of the INVITE when the proxies didn't record the route.
+			for _, header := range s.request.GetHeaders("Route") {
+				for _, address := range header.(*sip.RouteHeader).Addresses {
+					d.RouteSet = append(d.RouteSet, address.String())
+				}
+			}
+		}
+	case "UAS":
+		d.Recipient = s.remoteURI.Uri.String()
+		if contact, ok := s.request.Contact(); ok {
+			d.Recipient = contact.Address.String()
+		}
+		d.Destination = s.request.Source()
+		for _, uri := range utils.RecordRoutes(s.request) {
+			d.RouteSet = append(d.RouteSet, uri.String())
+		}
+		if s.response != nil && len(s.response.Destination()) > 0 {
+			d.Destination = s.response.Destination()
+			d.Source = s.response.Source()
+		}
+	}
+	return d
+}
+
+// Bye a BYE ending the dialog, sent with the stack.
+func (d Dialog) Bye(headers ...sip.Header) (sip.Request, error) {
+	local, err := parseAddress(d.Local)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := parseAddress(d.Remote)
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := parser.ParseSipUri(d.Recipient)
+	if err != nil {
+		return nil, err
+	}
+	contact, err := parser.ParseSipUri(d.Contact)
+	if err != nil {
+		return nil, err
+	}
+
+	callID := sip.CallID(d.CallID)
+	maxForwards := sip.MaxForwards(70)
+	request := sip.NewRequest("", sip.BYE, &recipient, "SIP/2.0", []sip.Header{
+		local.AsFromHeader(),
+		remote.AsToHeader(),
+		&callID,
+		&sip.CSeq{SeqNo: d.CSeq + 1, MethodName: sip.BYE},
+		&sip.ContactHeader{Address: &contact},
+		&maxForwards,
+	}, "", nil)
+	routes := []sip.Uri{}
+	for _, value := range d.RouteSet {
+		uri, err := parser.ParseSipUri(trimBrackets(value))
+		if err != nil {
+			return nil, fmt.Errorf("bad route %s: %v", value, err)
+		}
+		routes = append(routes, &uri)
+	}
+	utils.SetRouteSet(request, routes)
+	for _, header := range headers {
+		request.AppendHeader(header)
+	}
+	if len(d.Destination) > 0 {
+		request.SetDestination(d.Destination)
+	}
+	if len(d.Source) > 0 {
+		request.SetSource(d.Source)
+	}
+	return request, nil
+}
+
+func parseAddress(value string) (*sip.Address, error) {
+	displayName, uri, params, err := parser.ParseAddressValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return &sip.Address{DisplayName: displayName, Uri: uri, Params: params}, nil
+}
+
+// trimBrackets the URI of a name-addr, <sip:proxy;lr>.
+func trimBrackets(value string) string {
+	if n := len(value); n > 1 && value[0] == '<' && value[n-1] == '>' {
+		return value[1 : n-1]
+	}
+	return value
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+func parseMessage(t *testing.T, raw string) sip.Message {
+	msg, err := parser.ParseMessage([]byte(raw), log.NewDefaultLogrusLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestDialogOfUAC(t *testing.T) {
+	invite := parseMessage(t, "INVITE sip:bob@example.com SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK776asdhds\r\n"+
+		"Route: <sip:outbound.example.com;lr>\r\n"+
+		"From: <sip:alice@example.com>;tag=1928301774\r\n"+
+		"To: <sip:bob@example.com>\r\n"+
+		"Call-ID: a84b4c76e66710\r\n"+
+		"CSeq: 1 INVITE\r\n"+
+		"Contact: <sip:alice@192.0.2.1:5060>\r\n"+
+		"Content-Length: 0\r\n\r\n").(sip.Request)
+	contact, _ := invite.Contact()
+	sess := NewInviteSession(nil, "UAC", contact, invite, "a84b4c76e66710", nil, Outgoing, nil)
+	sess.StoreResponse(parseMessage(t, "SIP/2.0 200 OK\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK776asdhds\r\n"+
+		"Record-Route: <sip:p2.example.com;lr>, <sip:p1.example.com;lr>\r\n"+
+		"From: <sip:alice@example.com>;tag=1928301774\r\n"+
+		"To: <sip:bob@example.com>;tag=a6c85cf\r\n"+
+		"Call-ID: a84b4c76e66710\r\n"+
+		"CSeq: 1 INVITE\r\n"+
+		"Contact: <sip:bob@192.0.2.4:5060>\r\n"+
+		"Content-Length: 0\r\n\r\n").(sip.Response))
+	// A re-INVITE sent in the dialog.
+	sess.makeRequest("UAC", sip.INVITE, "", invite, sess.response)
+
+	d := sess.Dialog()
+	if d.Recipient != "sip:bob@192.0.2.4:5060" {
+		t.Errorf("recipient = %s, want the Contact of the callee", d.Recipient)
+	}
+	if len(d.RouteSet) != 2 || d.RouteSet[0] != "sip:p1.example.com;lr" || d.RouteSet[1] != "sip:p2.example.com;lr" {
+		t.Errorf("route set = %v, want the Record-Route reversed without the pre-loaded Route", d.RouteSet)
+	}
+	bye, err := d.Bye()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cseq, _ := bye.CSeq(); cseq.SeqNo != 3 {
+		t.Errorf("BYE CSeq = %d, want 3, after the re-INVITE", cseq.SeqNo)
+	}
+	if bye.Recipient().String() != d.Recipient {
+		t.Errorf("BYE sent to %s, want %s", bye.Recipient(), d.Recipient)
+	}
+}
+
+func TestDialogOfUAS(t *testing.T) {
+	invite := parseMessage(t, "INVITE sip:bob@192.0.2.4 SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK776asdhds\r\n"+
+		"Record-Route: <sip:p1.example.com;lr>, <sip:p2.example.com;lr>\r\n"+
+		"From: <sip:alice@example.com>;tag=1928301774\r\n"+
+		"To: <sip:bob@example.com>\r\n"+
+		"Call-ID: a84b4c76e66710\r\n"+
+		"CSeq: 7 INVITE\r\n"+
+		"Contact: <sip:alice@192.0.2.1:5060>\r\n"+
+		"Content-Length: 0\r\n\r\n").(sip.Request)
+	contact := &sip.ContactHeader{Address: &sip.SipUri{FUser: sip.String{Str: "bob"}, FHost: "192.0.2.4"}}
+	sess := NewInviteSession(nil, "UAS", contact, invite, "a84b4c76e66710", nil, Incoming, nil)
+
+	d := sess.Dialog()
+	if d.Recipient != "sip:alice@192.0.2.1:5060" {
+		t.Errorf("recipient = %s, want the Contact of the caller", d.Recipient)
+	}
+	if len(d.RouteSet) != 2 || d.RouteSet[0] != "sip:p1.example.com;lr" {
+		t.Errorf("route set = %v, want the Record-Route in order", d.RouteSet)
+	}
+	if d.CSeq != 7 {
+		t.Errorf("CSeq = %d, want 7", d.CSeq)
+	}
+}
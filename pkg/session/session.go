@@ -29,6 +29,8 @@ type Session struct {
 	localURI       sip.Address
 	remoteURI      sip.Address
 	remoteTarget   sip.Uri
+	remoteAddr     string
+	localCSeq      uint32
 	logger         log.Logger
 }
 
@@ -61,6 +63,7 @@ func NewInviteSession(reqcb RequestCallback, uaType string,
 		s.localURI = sip.Address{Uri: to.Address, Params: to.Params}
 		s.remoteURI = sip.Address{Uri: from.Address, Params: from.Params}
 		s.remoteTarget = contact.Address
+		s.remoteAddr = req.Source()
 		s.offer = req.Body()
 	} else if uaType == "UAC" {
 		s.localURI = sip.Address{Uri: from.Address, Params: from.Params}
@@ -142,6 +145,8 @@ func (s *Session) IsEstablished() bool {
 		fallthrough
 	case WaitingForACK:
 		fallthrough
+	case ReInviteReceived:
+		fallthrough
 	case Confirmed:
 		return true
 	default:
@@ -230,6 +235,18 @@ func (s *Session) Info(content string, contentType string) {
 	s.sendRequest(req)
 }
 
+//Notify send an in-dialog NOTIFY of event with subscriptionState, e.g. the message/sipfrag progress of a
+//REFER to the transferor.
+func (s *Session) Notify(event string, subscriptionState string, content string, contentType string) (sip.Response, error) {
+	req := s.makeRequest(s.uaType, sip.NOTIFY, sip.MessageID(s.callID), s.request, s.response)
+	req.AppendHeader(&sip.GenericHeader{HeaderName: "Event", Contents: event})
+	req.AppendHeader(&sip.GenericHeader{HeaderName: "Subscription-State", Contents: subscriptionState})
+	req.SetBody(content, true)
+	hdr := sip.ContentType(contentType)
+	req.AppendHeader(&hdr)
+	return s.sendRequest(req)
+}
+
 //ReInvite send re-INVITE
 func (s *Session) ReInvite() {
 	method := sip.INVITE
@@ -240,9 +257,38 @@ func (s *Session) ReInvite() {
 	s.sendRequest(req)
 }
 
-//Bye send Bye request.
-func (s *Session) Bye() (sip.Response, error) {
+//Refresh send a re-INVITE with the unchanged local SDP, e.g. to check the dialog is alive.
+func (s *Session) Refresh() (sip.Response, error) {
+	req := s.makeRequest(s.uaType, sip.INVITE, sip.MessageID(s.callID), s.request, s.response)
+	req.SetBody(s.LocalSdp(), true)
+	hdr := sip.ContentType("application/sdp")
+	req.AppendHeader(&hdr)
+	return s.sendRequest(req)
+}
+
+//Offer send a re-INVITE offering sdp, which becomes the local SDP of the session, e.g. the media of
+//another leg once it is known.
+func (s *Session) Offer(sdp string) (sip.Response, error) {
+	if s.uaType == "UAC" {
+		s.offer = sdp
+	} else {
+		s.answer = sdp
+	}
+	return s.Refresh()
+}
+
+//Options send an in-dialog OPTIONS, e.g. to check the dialog is alive.
+func (s *Session) Options() (sip.Response, error) {
+	req := s.makeRequest(s.uaType, sip.OPTIONS, sip.MessageID(s.callID), s.request, s.response)
+	return s.sendRequest(req)
+}
+
+//Bye send Bye request, with extra headers, e.g. Reason.
+func (s *Session) Bye(headers ...sip.Header) (sip.Response, error) {
 	req := s.makeRequest(s.uaType, sip.BYE, sip.MessageID(s.callID), s.request, s.response)
+	for _, header := range headers {
+		req.AppendHeader(header)
+	}
 	return s.sendRequest(req)
 }
 
@@ -252,17 +298,30 @@ func (s *Session) sendRequest(req sip.Request) (sip.Response, error) {
 }
 
 // Reject Reject incoming call or for re-INVITE or UPDATE,
-func (s *Session) Reject(statusCode sip.StatusCode, reason string) {
+func (s *Session) Reject(statusCode sip.StatusCode, reason string, headers ...sip.Header) {
+	s.RejectWithBody(statusCode, reason, "", "", headers...)
+}
+
+// RejectWithBody Reject with extra headers and an optional body, e.g. Warning, Retry-After.
+func (s *Session) RejectWithBody(statusCode sip.StatusCode, reason string, contentType string, body string, headers ...sip.Header) {
 	tx := (s.transaction.(sip.ServerTransaction))
 	request := s.request
 	s.Log().Debugf("Reject: Request => %s, body => %s", request.Short(), request.Body())
 	response := sip.NewResponseFromRequest(request.MessageID(), request, statusCode, reason, "")
 	response.AppendHeader(s.contact)
+	for _, header := range headers {
+		response.AppendHeader(header)
+	}
+	if len(body) > 0 {
+		hdr := sip.ContentType(contentType)
+		response.AppendHeader(&hdr)
+		response.SetBody(body, true)
+	}
 	tx.Respond(response)
 }
 
-//End end session
-func (s *Session) End() error {
+//End end session, headers, e.g. a Reason, are added to the CANCEL, the rejection or the BYE.
+func (s *Session) End(headers ...sip.Header) error {
 
 	if s.status == Terminated {
 		err := fmt.Errorf("invalid status: %v", s.status)
@@ -278,11 +337,15 @@ func (s *Session) End() error {
 		fallthrough
 	case EarlyMedia:
 		s.Log().Info("Canceling session.")
-		switch s.transaction.(type) {
+		switch tx := s.transaction.(type) {
+		case interface {
+			CancelWithHeaders(headers ...sip.Header) error
+		}:
+			tx.CancelWithHeaders(headers...)
 		case sip.ClientTransaction:
-			s.transaction.(sip.ClientTransaction).Cancel()
+			tx.Cancel()
 		case sip.ServerTransaction:
-			s.transaction.(sip.ServerTransaction).Done()
+			tx.Done()
 		}
 
 	// - UAS -
@@ -292,13 +355,15 @@ func (s *Session) End() error {
 		fallthrough
 	case Answered:
 		s.Log().Info("Rejecting session")
-		s.Reject(603, "Decline")
+		s.Reject(603, "Decline", headers...)
 
 	case WaitingForACK:
 		fallthrough
+	case ReInviteReceived:
+		fallthrough
 	case Confirmed:
 		s.Log().Info("Terminating session.")
-		s.Bye()
+		s.Bye(headers...)
 	}
 
 	return nil
@@ -332,9 +397,43 @@ func (s *Session) Accept(statusCode sip.StatusCode) {
 	s.SetState(WaitingForACK)
 }
 
-// Redirect send a 3xx
-func (s *Session) Redirect(target string, code sip.StatusCode) {
+// AcceptReInvite answer a re-INVITE with the local sdp, which becomes the local description of the session.
+func (s *Session) AcceptReInvite(sdp string) {
+	tx := (s.transaction.(sip.ServerTransaction))
+	request := s.request
+	response := sip.NewResponseFromRequest(request.MessageID(), request, 200, "OK", "")
+	contentType := sip.ContentType("application/sdp")
+	response.AppendHeader(&contentType)
+	response.AppendHeader(s.contact)
+	response.SetBody(sdp, true)
 
+	if s.uaType == "UAC" {
+		s.offer = sdp
+		if len(request.Body()) > 0 {
+			s.answer = request.Body()
+		}
+	} else {
+		s.answer = sdp
+		if len(request.Body()) > 0 {
+			s.offer = request.Body()
+		}
+	}
+	tx.Respond(response)
+}
+
+// Redirect send a 3xx, 302 if code isn't one, the caller calls target, a SIP URI, instead.
+func (s *Session) Redirect(target string, code sip.StatusCode) {
+	if code < 300 || code > 399 {
+		code = 302
+	}
+	reason := "Moved Temporarily"
+	if code == 301 {
+		reason = "Moved Permanently"
+	}
+	tx := (s.transaction.(sip.ServerTransaction))
+	response := sip.NewResponseFromRequest(s.request.MessageID(), s.request, code, reason, "")
+	response.AppendHeader(&sip.GenericHeader{HeaderName: "Contact", Contents: "<" + target + ">"})
+	tx.Respond(response)
 }
 
 // Provisional send a provisional code 100|180|183
@@ -380,29 +479,39 @@ func (s *Session) makeRequest(uaType string, method sip.RequestMethod, msgID sip
 	to := s.remoteURI.Clone().AsToHeader()
 	newRequest.AppendHeader(to)
 	newRequest.SetRecipient(s.request.Recipient())
-	sip.CopyHeaders("Via", inviteRequest, newRequest)
 	newRequest.AppendHeader(s.contact)
 
 	if uaType == "UAC" {
-		for _, header := range s.response.Headers() {
-			if header.Name() == "Record-Route" {
-				h := header.(*sip.RecordRouteHeader)
-				rh := &sip.RouteHeader{
-					Addresses: h.Addresses,
-				}
-				newRequest.AppendHeader(rh)
-			}
+		// The route set is the Record-Route of the response reversed, the pre-loaded Route of the INVITE
+		// when the proxies didn't record the route.
+		routes := []sip.Uri{}
+		for _, uri := range utils.RecordRoutes(s.response) {
+			routes = append([]sip.Uri{uri}, routes...)
 		}
-		if len(inviteRequest.GetHeaders("Route")) > 0 {
+		if len(routes) > 0 {
+			utils.SetRouteSet(newRequest, routes)
+		} else if len(inviteRequest.GetHeaders("Route")) > 0 {
 			sip.CopyHeaders("Route", inviteRequest, newRequest)
 		}
-	} else if uaType == "UAS" {
-		if len(inviteResponse.GetHeaders("Route")) > 0 {
-			sip.CopyHeaders("Route", inviteResponse, newRequest)
+		// The User-Agent of the profile, none when the INVITE was sent without.
+		if len(inviteRequest.GetHeaders("User-Agent")) > 0 {
+			sip.CopyHeaders("User-Agent", inviteRequest, newRequest)
+		} else {
+			userAgent := sip.UserAgentHeader("")
+			newRequest.AppendHeader(&userAgent)
 		}
-		newRequest.SetDestination(inviteResponse.Destination())
-		newRequest.SetSource(inviteResponse.Source())
+	} else if uaType == "UAS" {
 		newRequest.SetRecipient(to.Address)
+		// The route set is the Record-Route of the INVITE, in order.
+		if routes := utils.RecordRoutes(inviteRequest); len(routes) > 0 {
+			utils.SetRouteSet(newRequest, routes)
+		} else if len(s.remoteAddr) > 0 {
+			// The remote party sent the INVITE, the last response may be one to a request of the UAS.
+			newRequest.SetDestination(s.remoteAddr)
+		} else {
+			newRequest.SetDestination(inviteResponse.Destination())
+			newRequest.SetSource(inviteResponse.Source())
+		}
 	}
 
 	maxForwardsHeader := sip.MaxForwards(70)
@@ -410,8 +519,15 @@ func (s *Session) makeRequest(uaType string, method sip.RequestMethod, msgID sip
 	sip.CopyHeaders("Call-ID", inviteRequest, newRequest)
 	sip.CopyHeaders("CSeq", inviteRequest, newRequest)
 
+	// Each request of the dialog is a new transaction, the stack adds its Via with a new branch.
 	cseq, _ := newRequest.CSeq()
-	cseq.SeqNo++
+	s.lock.Lock()
+	if s.localCSeq < cseq.SeqNo {
+		s.localCSeq = cseq.SeqNo
+	}
+	s.localCSeq++
+	cseq.SeqNo = s.localCSeq
+	s.lock.Unlock()
 	cseq.MethodName = method
 
 	return newRequest
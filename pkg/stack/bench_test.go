@@ -0,0 +1,120 @@
+package stack
+
+import (
+	"testing"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/ghettovoice/gosip/transaction"
+)
+
+var benchInvite = []byte("INVITE sip:bob@example.com SIP/2.0\r\n" +
+	"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK776asdhds;rport\r\n" +
+	"Max-Forwards: 70\r\n" +
+	"To: Bob <sip:bob@example.com>\r\n" +
+	"From: Alice <sip:alice@example.com>;tag=1928301774\r\n" +
+	"Call-ID: a84b4c76e66710@192.0.2.1\r\n" +
+	"CSeq: 314159 INVITE\r\n" +
+	"Contact: <sip:alice@192.0.2.1:5060>\r\n" +
+	"Supported: replaces, outbound\r\n" +
+	"Content-Type: application/sdp\r\n" +
+	"Content-Length: 129\r\n" +
+	"\r\n" +
+	"v=0\r\n" +
+	"o=alice 2890844526 2890844526 IN IP4 192.0.2.1\r\n" +
+	"s=-\r\n" +
+	"c=IN IP4 192.0.2.1\r\n" +
+	"t=0 0\r\n" +
+	"m=audio 49170 RTP/AVP 0 8\r\n" +
+	"a=sendrecv\r\n")
+
+func benchRequest(b *testing.B) sip.Request {
+	msg, err := parser.ParseMessage(benchInvite, fuzzLogger)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return msg.(sip.Request)
+}
+
+func BenchmarkParseMessage(b *testing.B) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(benchInvite)))
+	for i := 0; i < b.N; i++ {
+		if _, err := parser.ParseMessage(benchInvite, fuzzLogger); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSerializeMessage(b *testing.B) {
+	req := benchRequest(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = req.String()
+	}
+}
+
+func BenchmarkValidateMessage(b *testing.B) {
+	req := benchRequest(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ValidateMessage(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkServerTxKey(b *testing.B) {
+	req := benchRequest(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := transaction.MakeServerTxKey(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnsupported(b *testing.B) {
+	s := newTestStack()
+	req := benchRequest(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Unsupported(req)
+	}
+}
+
+func BenchmarkAppendAutoHeaders(b *testing.B) {
+	s := newTestStack()
+	req := benchRequest(b)
+	res := sip.NewResponseFromRequest("", req, 200, "OK", "")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.appendAutoHeaders(res)
+	}
+}
+
+func BenchmarkWriteMessage(b *testing.B) {
+	req := benchRequest(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf := utils.GetBuffer()
+		writeMessage(buf, req)
+		utils.PutBuffer(buf)
+	}
+}
+
+func BenchmarkWriteMessageString(b *testing.B) {
+	req := benchRequest(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = []byte(req.String())
+	}
+}
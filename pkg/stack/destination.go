@@ -0,0 +1,110 @@
+package stack
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/transport"
+)
+
+// IPFamily of the addresses a destination is dialed at.
+type IPFamily string
+
+const (
+	// AnyFamily the first address resolved.
+	AnyFamily IPFamily = ""
+	IPv4      IPFamily = "ip4"
+	IPv6      IPFamily = "ip6"
+)
+
+// Matches ip belongs to the family.
+func (f IPFamily) Matches(ip net.IP) bool {
+	switch f {
+	case IPv4:
+		return ip.To4() != nil
+	case IPv6:
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// DestinationRoute how the stack reaches a destination, e.g. from a multi-homed host.
+type DestinationRoute struct {
+	// Family the addresses a domain is dialed at, the first one resolved if none of this family.
+	Family IPFamily
+	// Source the local address the requests are sent from and advertised in their Via, the stack host if nil.
+	// Over UDP, the stack must listen on this address, not on the wildcard one.
+	Source net.IP
+	// TelURI send the Request-URI, To and From of telephone numbers in their tel: form, RFC 3966, e.g. to
+	// a trunk expecting them.
+	TelURI bool
+}
+
+// destinationRouter applies the DestinationFunc of the config to the requests sent.
+type destinationRouter struct {
+	routes   func(host string) (DestinationRoute, bool)
+	resolver *net.Resolver
+}
+
+// route the target and the source address of msg, the ones of the transport layer if not routed.
+func (r *destinationRouter) route(network string, target *transport.Target, msg sip.Message) (*transport.Target, net.IP) {
+	req, ok := msg.(sip.Request)
+	if r == nil || !ok {
+		return target, nil
+	}
+	host := dialedHost(target, msg)
+	route, found := r.routes(host)
+	if !found && host != target.Host {
+		route, found = r.routes(target.Host)
+	}
+	if !found {
+		return target, nil
+	}
+	if ip := net.ParseIP(target.Host); route.Family != AnyFamily && net.ParseIP(host) == nil && (ip == nil || !route.Family.Matches(ip)) {
+		if addr := r.resolve(network, host, route.Family); addr != nil {
+			target = &transport.Target{Host: addr.String(), Port: target.Port}
+		}
+	}
+	if route.Source != nil {
+		if viaHop, ok := req.ViaHop(); ok {
+			viaHop.Host = route.Source.String()
+		}
+	}
+	return target, route.Source
+}
+
+// telURI the URIs of telephone numbers of msg are sent in their tel: form.
+func (r *destinationRouter) telURI(msg sip.Message) bool {
+	if r == nil {
+		return false
+	}
+	host := msg.Destination()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	route, found := r.routes(host)
+	return found && route.TelURI
+}
+
+// resolve an address of family of the SIP domain host, of its first SRV target if any, nil if none.
+func (r *destinationRouter) resolve(network string, host string, family IPFamily) net.IP {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultResolverTimeout)
+	defer cancel()
+	name := host
+	// The SRV lookup of the transport layer.
+	if _, srvs, err := r.resolver.LookupSRV(ctx, "sip", network, host); err == nil && len(srvs) > 0 {
+		name = strings.TrimSuffix(srvs[0].Target, ".")
+	}
+	addrs, err := r.resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if family.Matches(addr.IP) {
+			return addr.IP
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package stack
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultDialogMailboxSize .
+	DefaultDialogMailboxSize = 32
+)
+
+// jobQueue processes the jobs of a key in order, a WorkerPool or a DialogRuntime.
+type jobQueue interface {
+	Submit(key string, job func()) bool
+	Stats() WorkerStats
+	Stop()
+}
+
+// dialogActor runs the jobs of a dialog one at a time.
+type dialogActor struct {
+	mailbox chan func()
+}
+
+// DialogRuntime runs the messages of each dialog, by Call-ID, on a goroutine of its own: the messages of a
+// dialog are processed in order, the dialogs in parallel across the cores. A dialog's goroutine exits once
+// its mailbox is empty, and the messages overflowing its bounded mailbox are refused, which pushes back
+// on the peers flooding a dialog without delaying the others.
+type DialogRuntime struct {
+	mutex       sync.Mutex
+	actors      map[string]*dialogActor
+	mailboxSize int
+	stopped     bool
+	processed   uint64
+	rejected    uint64
+	wg          *sync.WaitGroup
+}
+
+// NewDialogRuntime mailboxSize messages queued per dialog, DefaultDialogMailboxSize if 0.
+func NewDialogRuntime(mailboxSize int) *DialogRuntime {
+	if mailboxSize <= 0 {
+		mailboxSize = DefaultDialogMailboxSize
+	}
+	return &DialogRuntime{
+		actors:      make(map[string]*dialogActor),
+		mailboxSize: mailboxSize,
+		wg:          new(sync.WaitGroup),
+	}
+}
+
+// submit queue job in the mailbox of key, starting its actor if idle.
+func (r *DialogRuntime) submit(key string, job func()) (queued bool, stopped bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stopped {
+		return false, true
+	}
+	actor, found := r.actors[key]
+	if !found {
+		actor = &dialogActor{mailbox: make(chan func(), r.mailboxSize)}
+		r.actors[key] = actor
+		r.wg.Add(1)
+		go r.run(key, actor)
+	}
+	select {
+	case actor.mailbox <- job:
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+func (r *DialogRuntime) run(key string, actor *dialogActor) {
+	defer r.wg.Done()
+	for {
+		r.mutex.Lock()
+		select {
+		case job := <-actor.mailbox:
+			r.mutex.Unlock()
+			job()
+			atomic.AddUint64(&r.processed, 1)
+		default:
+			// Retire under the lock, a new message starts a new actor.
+			delete(r.actors, key)
+			r.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// Submit queue job on the actor of key, returns false if its mailbox is full.
+func (r *DialogRuntime) Submit(key string, job func()) bool {
+	queued, _ := r.submit(key, job)
+	if !queued {
+		atomic.AddUint64(&r.rejected, 1)
+	}
+	return queued
+}
+
+// Stats Workers the dialogs with messages in process, Queued the messages waiting in their mailboxes.
+func (r *DialogRuntime) Stats() WorkerStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	queued := 0
+	for _, actor := range r.actors {
+		queued += len(actor.mailbox)
+	}
+	return WorkerStats{
+		Workers:   len(r.actors),
+		QueueSize: r.mailboxSize,
+		Queued:    queued,
+		Processed: atomic.LoadUint64(&r.processed),
+		Rejected:  atomic.LoadUint64(&r.rejected),
+	}
+}
+
+// Stop refuse the new messages and wait for the queued ones.
+func (r *DialogRuntime) Stop() {
+	r.mutex.Lock()
+	r.stopped = true
+	r.mutex.Unlock()
+	r.wg.Wait()
+}
+
+// Serialize run job in order with the messages of the dialog of callID, on its actor when the dialog
+// runtime is enabled, inline otherwise. It never waits for the actor, which may be the caller itself: when
+// the mailbox of the dialog is full, job is dropped and false returned, like the requests overflowing it.
+func (s *SipStack) Serialize(callID string, job func()) bool {
+	if runtime, ok := s.workers.(*DialogRuntime); ok {
+		if runtime.Submit(callID, job) {
+			return true
+		}
+		s.Log().Warnf("mailbox of the dialog %s full, job dropped", callID)
+		return false
+	}
+	job()
+	return true
+}
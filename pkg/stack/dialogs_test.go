@@ -0,0 +1,123 @@
+package stack
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+)
+
+func TestDialogRuntimeOrder(t *testing.T) {
+	runtime := NewDialogRuntime(0)
+	defer runtime.Stop()
+	mutex := sync.Mutex{}
+	order := map[string][]int{}
+	done := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		for _, key := range []string{"a", "b"} {
+			key, i := key, i
+			done.Add(1)
+			if !runtime.Submit(key, func() {
+				defer done.Done()
+				mutex.Lock()
+				order[key] = append(order[key], i)
+				mutex.Unlock()
+			}) {
+				t.Fatal("Submit refused before Stop")
+			}
+		}
+	}
+	done.Wait()
+	for key, jobs := range order {
+		for i, job := range jobs {
+			if job != i {
+				t.Fatalf("jobs of %s run out of order: %v", key, jobs)
+			}
+		}
+	}
+}
+
+func TestDialogRuntimeRetirement(t *testing.T) {
+	runtime := NewDialogRuntime(0)
+	defer runtime.Stop()
+	done := make(chan struct{})
+	runtime.Submit("a", func() { close(done) })
+	<-done
+	deadline := time.Now().Add(time.Second)
+	for runtime.Stats().Workers > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("idle actor not retired")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// A new message starts a new actor.
+	done = make(chan struct{})
+	if !runtime.Submit("a", func() { close(done) }) {
+		t.Fatal("Submit refused after retirement")
+	}
+	<-done
+	if stats := runtime.Stats(); stats.Processed != 2 {
+		t.Errorf("Processed = %d, want 2", stats.Processed)
+	}
+}
+
+func TestDialogRuntimeBackpressure(t *testing.T) {
+	runtime := NewDialogRuntime(2)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	runtime.Submit("a", func() {
+		close(started)
+		<-release
+	})
+	<-started
+	// The actor is busy, its mailbox takes 2 messages.
+	for i := 0; i < 2; i++ {
+		if !runtime.Submit("a", func() {}) {
+			t.Fatalf("message %d refused", i)
+		}
+	}
+	if runtime.Submit("a", func() {}) {
+		t.Error("message over the mailbox size queued")
+	}
+	// The other dialogs aren't delayed.
+	other := make(chan struct{})
+	if !runtime.Submit("b", func() { close(other) }) {
+		t.Fatal("message of another dialog refused")
+	}
+	<-other
+	close(release)
+	runtime.Stop()
+	stats := runtime.Stats()
+	if stats.Rejected != 1 || stats.Processed != 4 {
+		t.Errorf("Rejected = %d, Processed = %d, want 1, 4", stats.Rejected, stats.Processed)
+	}
+	if runtime.Submit("a", func() {}) {
+		t.Error("Submit queued after Stop")
+	}
+}
+
+func TestSerializeOnFullMailbox(t *testing.T) {
+	runtime := NewDialogRuntime(1)
+	defer runtime.Stop()
+	s := &SipStack{workers: runtime, log: utils.NewLogrusLogger(log.FatalLevel, "Dialogs", nil)}
+	done := make(chan struct{})
+	runtime.Submit("a", func() {
+		// From the actor, with its mailbox full: dropped rather than run out of order or waiting for itself.
+		runtime.Submit("a", func() {})
+		ran := false
+		if s.Serialize("a", func() { ran = true }) {
+			t.Error("job queued over the mailbox size")
+		}
+		if ran {
+			t.Error("job run out of order")
+		}
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Serialize blocked on its own actor")
+	}
+}
@@ -0,0 +1,173 @@
+package stack
+
+import (
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// Option tags, https://www.iana.org/assignments/sip-parameters/sip-parameters.xhtml#sip-parameters-4
+const (
+	OptionTag100rel   = "100rel"   // https://tools.ietf.org/html/rfc3262
+	OptionTagTimer    = "timer"    // https://tools.ietf.org/html/rfc4028
+	OptionTagGruu     = "gruu"     // https://tools.ietf.org/html/rfc5627
+	OptionTagPath     = "path"     // https://tools.ietf.org/html/rfc3327
+	OptionTagReplaces = "replaces" // https://tools.ietf.org/html/rfc3891
+	OptionTagOutbound = "outbound" // https://tools.ietf.org/html/rfc5626
+)
+
+var (
+	// DefaultAccept .
+	DefaultAccept = []string{"application/sdp"}
+)
+
+// OptionTagHandler satisfies an option tag required by req, returns false if
+// the request can not be processed with the extension.
+type OptionTagHandler func(req sip.Request) bool
+
+// Features SIP extensions enabled in the stack, used to derive the Supported option tags.
+type Features struct {
+	Reliable100rel bool
+	SessionTimer   bool
+	Gruu           bool
+	Path           bool
+	Replaces       bool
+	Outbound       bool
+}
+
+// OptionTags .
+func (f Features) OptionTags() []string {
+	tags := []string{}
+	if f.Reliable100rel {
+		tags = append(tags, OptionTag100rel)
+	}
+	if f.SessionTimer {
+		tags = append(tags, OptionTagTimer)
+	}
+	if f.Gruu {
+		tags = append(tags, OptionTagGruu)
+	}
+	if f.Path {
+		tags = append(tags, OptionTagPath)
+	}
+	if f.Replaces {
+		tags = append(tags, OptionTagReplaces)
+	}
+	if f.Outbound {
+		tags = append(tags, OptionTagOutbound)
+	}
+	return tags
+}
+
+// mergeOptionTags merge the option tags, without duplicates, keeping the order.
+func mergeOptionTags(lists ...[]string) []string {
+	tags := []string{}
+	added := map[string]bool{}
+	for _, list := range lists {
+		for _, tag := range list {
+			tag = strings.TrimSpace(tag)
+			if len(tag) == 0 || added[tag] {
+				continue
+			}
+			added[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// OnOptionTag register a handler satisfying an additional option tag,
+// the tag is advertised in the Supported header.
+func (s *SipStack) OnOptionTag(tag string, handler OptionTagHandler) {
+	s.hmu.Lock()
+	defer s.hmu.Unlock()
+	s.optionTagHandlers[tag] = handler
+	s.extensions = mergeOptionTags(s.extensions, []string{tag})
+}
+
+// Supported option tags advertised in the Supported header.
+func (s *SipStack) Supported() []string {
+	s.hmu.RLock()
+	defer s.hmu.RUnlock()
+	return s.extensions
+}
+
+// IsSupported .
+func (s *SipStack) IsSupported(tag string) bool {
+	for _, ext := range s.Supported() {
+		if ext == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// satisfies check if the stack can process req with the extension tag.
+func (s *SipStack) satisfies(req sip.Request, tag string) bool {
+	s.hmu.RLock()
+	handler, ok := s.optionTagHandlers[tag]
+	s.hmu.RUnlock()
+	if ok {
+		return handler(req)
+	}
+	return s.IsSupported(tag)
+}
+
+// Accept content types advertised in the Accept header.
+func (s *SipStack) Accept() []string {
+	if s.config.Accept != nil {
+		return s.config.Accept
+	}
+	return DefaultAccept
+}
+
+// Unsupported option tags of the Require and Proxy-Require headers of req not satisfied by the stack.
+func (s *SipStack) Unsupported(req sip.Request) []string {
+	var tags []string
+	for _, hdr := range req.GetHeaders("Require") {
+		if require, ok := hdr.(*sip.RequireHeader); ok {
+			tags = append(tags, require.Options...)
+		}
+	}
+	for _, hdr := range req.GetHeaders("Proxy-Require") {
+		switch proxyRequire := hdr.(type) {
+		case *sip.ProxyRequireHeader:
+			tags = append(tags, proxyRequire.Options...)
+		default:
+			tags = append(tags, strings.Split(hdr.Value(), ",")...)
+		}
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	unsupported := []string{}
+	for _, tag := range mergeOptionTags(tags) {
+		if !s.satisfies(req, tag) {
+			unsupported = append(unsupported, tag)
+		}
+	}
+	return unsupported
+}
+
+// rejectUnsupported respond 420 Bad Extension if req requires unsupported extensions,
+// https://tools.ietf.org/html/rfc3261#section-8.2.2.3 and https://tools.ietf.org/html/rfc3261#section-16.3
+func (s *SipStack) rejectUnsupported(req sip.Request, tx sip.ServerTransaction) bool {
+	if req.IsAck() || req.IsCancel() {
+		return false
+	}
+	unsupported := s.Unsupported(req)
+	if len(unsupported) == 0 {
+		return false
+	}
+	s.Log().Infof("SIP request %v requires unsupported extensions: %v", req.Method(), unsupported)
+	res := sip.NewResponseFromRequest("", req, 420, "Bad Extension", "")
+	res.AppendHeader(&sip.UnsupportedHeader{Options: unsupported})
+	if tx != nil {
+		tx.Respond(res)
+	} else if _, err := s.Respond(res); err != nil {
+		s.Log().Errorf("respond '420 Bad Extension' failed: %s", err)
+	}
+	return true
+}
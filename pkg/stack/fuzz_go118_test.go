@@ -0,0 +1,25 @@
+//go:build go1.18
+// +build go1.18
+
+package stack
+
+import (
+	"testing"
+)
+
+func FuzzParseMessage(f *testing.F) {
+	for _, data := range loadTorture(f) {
+		f.Add(data)
+	}
+	f.Add([]byte("OPTIONS sip:a@b SIP/2.0\r\nVia: SIP/2.0/UDP h;branch=z9hG4bK1\r\nCSeq: 1 OPTIONS\r\nCall-ID: c\r\nFrom: <sip:a@b>;tag=1\r\nTo: <sip:a@b>\r\nContent-Length: 0\r\n\r\n"))
+	f.Add([]byte("SIP/2.0 200 OK\r\nVia: SIP/2.0/UDP h;branch=z9hG4bK1\r\nCSeq: 1 INVITE\r\nCall-ID: c\r\nFrom: <sip:a@b>;tag=1\r\nTo: <sip:a@b>;tag=2\r\nContent-Length: 0\r\n\r\n"))
+
+	s := newTestStack()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := parseMessage(data)
+		if err != nil || msg == nil {
+			return
+		}
+		exerciseMessage(t, s, msg)
+	})
+}
@@ -0,0 +1,211 @@
+package stack
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+	"github.com/ghettovoice/gosip/transaction"
+)
+
+var (
+	fuzzLogger = utils.NewLogrusLogger(log.FatalLevel, "Fuzz", nil)
+)
+
+// loopbackTransport feeds messages to the transaction layer and discards the sent ones.
+type loopbackTransport struct {
+	msgs chan sip.Message
+}
+
+func (tp *loopbackTransport) Messages() <-chan sip.Message {
+	return tp.msgs
+}
+
+func (tp *loopbackTransport) Send(msg sip.Message) error {
+	_ = msg.String()
+	return nil
+}
+
+func (tp *loopbackTransport) IsReliable(network string) bool {
+	return strings.ToUpper(network) != "UDP"
+}
+
+func (tp *loopbackTransport) IsStreamed(network string) bool {
+	return strings.ToUpper(network) != "UDP"
+}
+
+func newTestStack() *SipStack {
+	s := &SipStack{
+		config:            &SipStackConfig{Features: Features{Replaces: true, Outbound: true}},
+		hwg:               new(sync.WaitGroup),
+		hmu:               new(sync.RWMutex),
+		requestHandlers:   make(map[sip.RequestMethod]RequestHandler),
+		optionTagHandlers: make(map[string]OptionTagHandler),
+		invites:           make(map[transaction.TxKey]sip.Request),
+		invitesLock:       new(sync.RWMutex),
+		log:               fuzzLogger,
+	}
+	s.extensions = mergeOptionTags(s.config.Features.OptionTags())
+	return s
+}
+
+// loadTorture load the RFC 4475 torture messages of testdata/torture, lines end with CRLF on the wire.
+func loadTorture(tb testing.TB) map[string][]byte {
+	files, err := filepath.Glob(filepath.Join("testdata", "torture", "*.sip"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	messages := make(map[string][]byte)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		text := strings.Replace(string(data), "\r\n", "\n", -1)
+		messages[filepath.Base(file)] = []byte(strings.Replace(text, "\n", "\r\n", -1))
+	}
+	return messages
+}
+
+// parseMessage parse data as a datagram, with a deadline since the parser may wait for more data. The parser
+// is stopped whatever the outcome, its goroutine doesn't outlive the call.
+func parseMessage(data []byte) (sip.Message, error) {
+	output := make(chan sip.Message)
+	errs := make(chan error)
+	prs := parser.NewParser(output, errs, false, fuzzLogger)
+	defer func() {
+		stopped := make(chan struct{})
+		go func() {
+			prs.Stop()
+			close(stopped)
+		}()
+		for {
+			select {
+			case <-output:
+			case <-errs:
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	if _, err := prs.Write(data); err != nil {
+		return nil, err
+	}
+	select {
+	case msg := <-output:
+		return msg, nil
+	case err := <-errs:
+		return nil, err
+	case <-time.After(time.Second):
+		return nil, nil
+	}
+}
+
+// exerciseMessage run msg through the validation, extension and transaction code of the stack.
+func exerciseMessage(t *testing.T, s *SipStack, msg sip.Message) error {
+	_ = msg.String()
+	if err := ValidateMessage(msg); err != nil {
+		return err
+	}
+
+	switch m := msg.(type) {
+	case sip.Request:
+		s.Unsupported(m)
+		if _, err := transaction.MakeServerTxKey(m); err != nil {
+			t.Logf("server tx key: %v", err)
+		}
+		clone := m.Clone().(sip.Request)
+		s.appendAutoHeaders(clone)
+		_ = clone.String()
+	case sip.Response:
+		if _, err := transaction.MakeClientTxKey(m); err != nil {
+			t.Logf("client tx key: %v", err)
+		}
+	}
+
+	tp := &loopbackTransport{msgs: make(chan sip.Message, 1)}
+	txl := transaction.NewLayer(tp, fuzzLogger)
+	defer txl.Cancel()
+	tp.msgs <- msg
+
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case tx := <-txl.Requests():
+			res := sip.NewResponseFromRequest("", tx.Origin(), 200, "OK", "")
+			if err := tx.Respond(res); err != nil {
+				t.Logf("respond: %v", err)
+			}
+			return nil
+		case <-txl.Acks():
+			return nil
+		case <-txl.Responses():
+			return nil
+		case <-txl.Errors():
+			return nil
+		case <-timeout:
+			return nil
+		}
+	}
+}
+
+func TestTortureMessages(t *testing.T) {
+	malformed := map[string]bool{
+		"insuf.sip":      true,
+		"ltgtruri.sip":   true,
+		"mismatch01.sip": true,
+		"nocontact.sip":  true,
+		"novia.sip":      true,
+		"scalar02.sip":   true,
+	}
+	// The well-formed messages the gosip parser can't handle.
+	parserLimits := map[string]string{
+		"unkscm.sip": "only the sip and sips URIs are parsed",
+		"wsinv.sip":  "the From header folded over several lines is dropped",
+	}
+	s := newTestStack()
+	for name, data := range loadTorture(t) {
+		if limit, found := parserLimits[name]; found {
+			t.Logf("%s: skipped, %s", name, limit)
+			continue
+		}
+		msg, err := parseMessage(data)
+		if err != nil || msg == nil {
+			if !malformed[name] {
+				t.Errorf("%s: parse failed: %v", name, err)
+			}
+			continue
+		}
+		err = exerciseMessage(t, s, msg)
+		if malformed[name] && err == nil {
+			t.Errorf("%s: malformed message accepted", name)
+		}
+		if !malformed[name] && err != nil {
+			t.Errorf("%s: well-formed message rejected: %v", name, err)
+		}
+	}
+}
+
+func TestTortureUnsupported(t *testing.T) {
+	s := newTestStack()
+	s.OnOptionTag("norDoAnyProxiesSupportThis", func(req sip.Request) bool {
+		return true
+	})
+	msg, err := parseMessage(loadTorture(t)["bext01.sip"])
+	if err != nil || msg == nil {
+		t.Fatalf("parse bext01.sip failed: %v", err)
+	}
+	got := strings.Join(s.Unsupported(msg.(sip.Request)), ",")
+	want := "nothingSupportsThis,nothingSupportsThisEither,noProxiesSupportThis"
+	if got != want {
+		t.Errorf("Unsupported = %s; want %s", got, want)
+	}
+}
@@ -0,0 +1,219 @@
+package stack
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+const (
+	// relayQueueSize datagrams relayed to a UDP socket handed off and not read yet, the others are dropped.
+	relayQueueSize = 256
+)
+
+// InstanceOf the instance a message belongs to, from the node parameter set by the instances with an
+// InstanceID: the Request-URI or the top Route of the in-dialog requests, sent to the Contact of the
+// instance, or the top Via of the responses.
+func InstanceOf(msg sip.Message) (string, bool) {
+	switch m := msg.(type) {
+	case sip.Request:
+		if node, ok := uriInstance(m.Recipient()); ok {
+			return node, true
+		}
+		for _, header := range m.GetHeaders("Route") {
+			if route, ok := header.(*sip.RouteHeader); ok && len(route.Addresses) > 0 {
+				return uriInstance(route.Addresses[0])
+			}
+		}
+	case sip.Response:
+		if viaHop, ok := m.ViaHop(); ok && viaHop.Params != nil {
+			if node, ok := viaHop.Params.Get(InstanceParam); ok && node != nil {
+				return node.String(), true
+			}
+		}
+	}
+	return "", false
+}
+
+func uriInstance(uri sip.Uri) (string, bool) {
+	if uri == nil || uri.UriParams() == nil {
+		return "", false
+	}
+	if node, ok := uri.UriParams().Get(InstanceParam); ok && node != nil {
+		return node.String(), true
+	}
+	return "", false
+}
+
+// datagramConn the UDP sockets of the protocol, a *net.UDPConn or its tel: rewriting.
+type datagramConn interface {
+	net.Conn
+	ReadFrom(b []byte) (int, net.Addr, error)
+	WriteTo(b []byte, addr net.Addr) (int, error)
+}
+
+// relayedPacket a datagram received by the process which took over the socket.
+type relayedPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+// handoffRelay the UDP sockets shared with the process they are handed off to. Both processes hold the
+// sockets, only the new one reads them: the previous one stops once they are handed off, and reads the
+// datagrams of its calls relayed by the new one, those naming its instance, see InstanceOf.
+type handoffRelay struct {
+	log log.Logger
+
+	mutex sync.Mutex
+	// conns the UDP sockets, by socketKey.
+	conns     map[string]*handoffConn
+	handedOff bool
+	// previous the instance of the process the sockets were taken over from, its datagrams are relayed
+	// by send until it exits.
+	previous string
+	send     func(key string, addr net.Addr, data []byte) error
+	// stop the relay of the datagrams to the process, once handed off.
+	stop func()
+}
+
+func newHandoffRelay(logger log.Logger) *handoffRelay {
+	return &handoffRelay{log: logger, conns: make(map[string]*handoffConn)}
+}
+
+// wrap the UDP socket of key.
+func (r *handoffRelay) wrap(key string, conn datagramConn) *handoffConn {
+	c := &handoffConn{datagramConn: conn, key: key, relay: r, relayed: make(chan relayedPacket, relayQueueSize), done: make(chan struct{})}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.conns[key] = c
+	return c
+}
+
+// isHandedOff .
+func (r *handoffRelay) isHandedOff() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.handedOff
+}
+
+// handOff stop reading the sockets, stop relays the datagrams to them no more.
+func (r *handoffRelay) handOff(stop func()) {
+	r.mutex.Lock()
+	r.handedOff, r.stop = true, stop
+	conns := make([]*handoffConn, 0, len(r.conns))
+	for _, conn := range r.conns {
+		conns = append(conns, conn)
+	}
+	r.mutex.Unlock()
+	for _, conn := range conns {
+		// Wakes up the pending read, the deadline is the one of this process only.
+		conn.datagramConn.SetReadDeadline(time.Now())
+	}
+}
+
+// takeOver relay to the previous instance its datagrams, with send.
+func (r *handoffRelay) takeOver(previous string, send func(key string, addr net.Addr, data []byte) error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.previous, r.send = previous, send
+}
+
+// deliver a datagram relayed to the socket key.
+func (r *handoffRelay) deliver(key string, addr net.Addr, data []byte) {
+	r.mutex.Lock()
+	conn, found := r.conns[key]
+	r.mutex.Unlock()
+	if !found {
+		r.log.Warnf("handoff: datagram relayed to unknown socket %s", key)
+		return
+	}
+	select {
+	case conn.relayed <- relayedPacket{data: data, addr: addr}:
+	default:
+		r.log.Warnf("handoff: relay queue of %s full, datagram dropped", key)
+	}
+}
+
+// steer relay the datagram read on the socket key if it belongs to the previous instance, returns false
+// when the process handles it.
+func (r *handoffRelay) steer(key string, addr net.Addr, data []byte) bool {
+	r.mutex.Lock()
+	previous, send := r.previous, r.send
+	r.mutex.Unlock()
+	if len(previous) == 0 {
+		return false
+	}
+	msg, err := parser.ParseMessage(data, r.log)
+	if err != nil {
+		return false
+	}
+	if instance, ok := InstanceOf(msg); !ok || instance != previous {
+		return false
+	}
+	if err := send(key, addr, data); err != nil {
+		// The previous process has exited.
+		r.log.Infof("handoff: instance %s gone, its datagrams are handled here: %v", previous, err)
+		r.mutex.Lock()
+		r.previous, r.send = "", nil
+		r.mutex.Unlock()
+		return false
+	}
+	return true
+}
+
+// close stop relaying.
+func (r *handoffRelay) close() {
+	r.mutex.Lock()
+	stop := r.stop
+	r.stop = nil
+	r.mutex.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+// handoffConn a UDP socket which can be handed off, see handoffRelay.
+type handoffConn struct {
+	datagramConn
+	key     string
+	relay   *handoffRelay
+	relayed chan relayedPacket
+	once    sync.Once
+	done    chan struct{}
+}
+
+func (c *handoffConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		if c.relay.isHandedOff() {
+			select {
+			case packet := <-c.relayed:
+				return copy(b, packet.data), packet.addr, nil
+			case <-c.done:
+				// Fails on the closed socket.
+				return c.datagramConn.ReadFrom(b)
+			}
+		}
+		n, addr, err := c.datagramConn.ReadFrom(b)
+		if err != nil {
+			if c.relay.isHandedOff() {
+				continue
+			}
+			return n, addr, err
+		}
+		if c.relay.steer(c.key, addr, b[:n]) {
+			continue
+		}
+		return n, addr, nil
+	}
+}
+
+func (c *handoffConn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+	})
+	return c.datagramConn.Close()
+}
@@ -0,0 +1,20 @@
+// +build !linux,!darwin
+
+package stack
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func (s *SipStack) takeOver(path string) error {
+	return fmt.Errorf("socket handoff is not supported on %s", runtime.GOOS)
+}
+
+func (s *SipStack) serveHandoff(path string) error {
+	return fmt.Errorf("socket handoff is not supported on %s", runtime.GOOS)
+}
+
+func (s *SipStack) listenRelay(path string) (func(), error) {
+	return nil, fmt.Errorf("socket handoff is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,252 @@
+// +build linux darwin
+
+package stack
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	handoffRequest = "HANDOFF"
+	// handoffAck the sockets have been received, the state is due.
+	handoffAck = "OK"
+	// handoffMaxSockets sockets sent in one handoff message.
+	handoffMaxSockets = 64
+	// handoffTimeout of the exchange with the other process.
+	handoffTimeout = 30 * time.Second
+)
+
+// takeOver receive the listening sockets of the process serving the handoff socket at path, with its
+// instance, whose datagrams are relayed to it, and its state.
+func (s *SipStack) takeOver(path string) error {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	unixConn := conn.(*net.UnixConn)
+	unixConn.SetDeadline(time.Now().Add(handoffTimeout))
+
+	if _, err := unixConn.Write([]byte(handoffRequest + "\n")); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(handoffMaxSockets*4))
+	n, oobn, _, _, err := unixConn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return err
+	}
+	messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return err
+	}
+	fds := []int{}
+	for _, message := range messages {
+		rights, err := syscall.ParseUnixRights(&message)
+		if err != nil {
+			return err
+		}
+		fds = append(fds, rights...)
+	}
+
+	// The instance and the relay socket of the previous process, then the names of the sockets.
+	lines := strings.Split(strings.TrimRight(string(buf[:n]), "\n"), "\n")
+	if len(lines) < 2 || len(lines)-2 != len(fds) {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+		return fmt.Errorf("handoff: %d sockets for %d lines", len(fds), len(lines))
+	}
+	previous, relayPath, keys := lines[0], lines[1], lines[2:]
+
+	s.sockets.mutex.Lock()
+	for idx, key := range keys {
+		s.sockets.inherited[key] = os.NewFile(uintptr(fds[idx]), key)
+	}
+	s.sockets.mutex.Unlock()
+	s.Log().Infof("handoff: received %d sockets from %s", len(keys), path)
+
+	if _, err := unixConn.Write([]byte(handoffAck + "\n")); err != nil {
+		return err
+	}
+	state, err := ioutil.ReadAll(unixConn)
+	if err != nil {
+		s.Log().Warnf("handoff: state of the previous process not received: %v", err)
+	}
+	s.hmu.Lock()
+	s.handoffState = state
+	s.hmu.Unlock()
+
+	if len(previous) > 0 && len(relayPath) > 0 {
+		relay, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: relayPath, Net: "unixgram"})
+		if err != nil {
+			s.Log().Warnf("handoff: relay to instance %s failed: %v", previous, err)
+			return nil
+		}
+		s.sockets.relay.takeOver(previous, func(key string, addr net.Addr, data []byte) error {
+			_, err := relay.Write(encodeRelayed(key, addr, data))
+			if err != nil {
+				relay.Close()
+			}
+			return err
+		})
+	}
+	return nil
+}
+
+// encodeRelayed a datagram relayed to the previous process: the socket and the source it was read
+// from, then the datagram.
+func encodeRelayed(key string, addr net.Addr, data []byte) []byte {
+	packet := make([]byte, 0, len(key)+len(addr.String())+2+len(data))
+	packet = append(packet, key...)
+	packet = append(packet, '\n')
+	packet = append(packet, addr.String()...)
+	packet = append(packet, '\n')
+	return append(packet, data...)
+}
+
+func decodeRelayed(packet []byte) (string, net.Addr, []byte, error) {
+	parts := bytes.SplitN(packet, []byte("\n"), 3)
+	if len(parts) != 3 {
+		return "", nil, nil, fmt.Errorf("invalid relayed datagram")
+	}
+	addr, err := net.ResolveUDPAddr("udp", string(parts[1]))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return string(parts[0]), addr, parts[2], nil
+}
+
+// serveHandoff serve the handoff socket at path, the listening sockets are sent
+// to the next process requesting them, then the OnHandoff handler is called.
+func (s *SipStack) serveHandoff(path string) error {
+	os.Remove(path)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			conn, err := listener.AcceptUnix()
+			if err != nil {
+				if s.running.IsSet() {
+					s.Log().Errorf("handoff: accept failed: %v", err)
+				}
+				return
+			}
+			if s.handoff(conn) {
+				// The next process serves the handoff socket from now on.
+				listener.SetUnlinkOnClose(false)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// listenRelay receive the datagrams relayed by the next process on a socket of its own, path.
+func (s *SipStack) listenRelay(path string) (func(), error) {
+	os.Remove(path)
+	relay, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		buf := make([]byte, 65535+1024)
+		for {
+			n, err := relay.Read(buf)
+			if err != nil {
+				return
+			}
+			key, addr, data, err := decodeRelayed(buf[:n])
+			if err != nil {
+				s.Log().Warnf("handoff: %v", err)
+				continue
+			}
+			s.sockets.relay.deliver(key, addr, append([]byte(nil), data...))
+		}
+	}()
+	return func() {
+		relay.Close()
+		os.Remove(path)
+	}, nil
+}
+
+func (s *SipStack) handoff(conn *net.UnixConn) bool {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+	reader := bufio.NewReader(conn)
+
+	request, err := reader.ReadString('\n')
+	if err != nil || strings.TrimSpace(request) != handoffRequest {
+		s.Log().Warnf("handoff: invalid request %q", request)
+		return false
+	}
+
+	keys, files, err := s.sockets.files()
+	if err != nil {
+		s.Log().Errorf("handoff: %v", err)
+		return false
+	}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	if len(files) > handoffMaxSockets {
+		s.Log().Errorf("handoff: too many sockets %d", len(files))
+		return false
+	}
+
+	relayPath := fmt.Sprintf("%s.%d.relay", s.config.HandoffPath, os.Getpid())
+	stopRelay, err := s.listenRelay(relayPath)
+	if err != nil {
+		s.Log().Errorf("handoff: relay socket %s: %v", relayPath, err)
+		return false
+	}
+
+	fds := make([]int, 0, len(files))
+	for _, file := range files {
+		fds = append(fds, int(file.Fd()))
+	}
+	header := append([]string{s.config.InstanceID, relayPath}, keys...)
+	if _, _, err := conn.WriteMsgUnix([]byte(strings.Join(header, "\n")), syscall.UnixRights(fds...), nil); err != nil {
+		s.Log().Errorf("handoff: send sockets failed: %v", err)
+		stopRelay()
+		return false
+	}
+	if ack, err := reader.ReadString('\n'); err != nil || strings.TrimSpace(ack) != handoffAck {
+		s.Log().Errorf("handoff: sockets not acknowledged: %q %v", ack, err)
+		stopRelay()
+		return false
+	}
+
+	// The next process reads the sockets from now on.
+	s.sockets.relay.handOff(stopRelay)
+	s.sockets.closeListeners()
+	s.Log().Infof("handoff: sent %d sockets, the datagrams of instance %s are relayed to %s", len(files), s.config.InstanceID, relayPath)
+
+	s.hmu.RLock()
+	handler, state := s.handoffHandler, s.handoffStateFunc
+	s.hmu.RUnlock()
+	if state != nil {
+		if _, err := conn.Write(state()); err != nil {
+			s.Log().Errorf("handoff: send state failed: %v", err)
+		}
+	}
+	if handler != nil {
+		go handler()
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package stack
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// listenPort the port of the UDP socket of s.
+func listenPort(t *testing.T, s *SipStack) int {
+	s.sockets.mutex.Lock()
+	defer s.sockets.mutex.Unlock()
+	for _, socket := range s.sockets.opened {
+		if conn, ok := socket.(*net.UDPConn); ok {
+			return conn.LocalAddr().(*net.UDPAddr).Port
+		}
+	}
+	t.Fatal("no UDP socket")
+	return 0
+}
+
+func receiver(s *SipStack) chan string {
+	received := make(chan string, 4)
+	s.OnRequest(sip.MESSAGE, func(req sip.Request, tx sip.ServerTransaction) {
+		received <- req.Recipient().User().String()
+		tx.Respond(sip.NewResponseFromRequest("", req, 200, "OK", ""))
+	})
+	return received
+}
+
+func TestHandoffRelay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "b2bua.sock")
+
+	old := NewSipStack(&SipStackConfig{Host: "127.0.0.1", HandoffPath: path, InstanceID: "old"})
+	defer old.Shutdown()
+	if err := old.Listen("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	old.SetHandoffState(func() []byte { return []byte("bindings") })
+	handedOff := make(chan struct{})
+	old.OnHandoff(func() { close(handedOff) })
+	oldReceived := receiver(old)
+	port := listenPort(t, old)
+
+	next := NewSipStack(&SipStackConfig{Host: "127.0.0.1", HandoffPath: path, InstanceID: "next"})
+	defer next.Shutdown()
+	if err := next.Listen("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handedOff:
+	case <-time.After(2 * time.Second):
+		t.Fatal("sockets not handed off")
+	}
+	if state := string(next.HandoffState()); state != "bindings" {
+		t.Errorf("state = %q, want the state of the previous process", state)
+	}
+	if got := listenPort(t, next); got != port {
+		t.Fatalf("next listens on %d, want the port taken over %d", got, port)
+	}
+	nextReceived := receiver(next)
+
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	send := func(user string, uri string) {
+		msg := "MESSAGE " + uri + " SIP/2.0\r\n" +
+			"Via: SIP/2.0/UDP " + peer.LocalAddr().String() + ";branch=z9hG4bK" + user + "\r\n" +
+			"Max-Forwards: 70\r\n" +
+			"From: <sip:alice@example.com>;tag=1928301774\r\n" +
+			"To: <sip:" + user + "@example.com>\r\n" +
+			"Call-ID: " + user + "@example.com\r\n" +
+			"CSeq: 1 MESSAGE\r\n" +
+			"Content-Length: 0\r\n\r\n"
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+		if _, err := peer.WriteTo([]byte(msg), addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(received chan string, user string, who string) {
+		select {
+		case got := <-received:
+			if got != user {
+				t.Errorf("%s received the MESSAGE to %s, want the one to %s", who, got, user)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("%s didn't receive the MESSAGE to %s", who, user)
+		}
+	}
+
+	// The requests of the dialogs of the previous process name its instance.
+	send("dialog", "sip:dialog@127.0.0.1;node=old")
+	expect(oldReceived, "dialog", "the previous process")
+	send("new", "sip:new@127.0.0.1")
+	expect(nextReceived, "new", "the new process")
+	select {
+	case got := <-oldReceived:
+		t.Errorf("the previous process read the MESSAGE to %s from the socket handed off", got)
+	default:
+	}
+}
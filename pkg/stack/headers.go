@@ -0,0 +1,89 @@
+package stack
+
+import (
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// builderUserAgent the placeholder User-Agent of the requests built by sip.RequestBuilder.
+const builderUserAgent = "GoSIP"
+
+var (
+	// DefaultHeaderOrder the routing headers first, then the dialog ones, the order of most SIP
+	// implementations, for the gateways which parse the headers in a fixed order.
+	DefaultHeaderOrder = []string{"Via", "Route", "Record-Route", "Max-Forwards", "From", "To", "Call-ID", "CSeq", "Contact"}
+)
+
+// UserAgent the User-Agent of the requests.
+func (s *SipStack) UserAgent() string {
+	if len(s.config.UserAgent) > 0 {
+		return s.config.UserAgent
+	}
+	return DefaultUserAgent
+}
+
+// Server the Server header of the responses.
+func (s *SipStack) Server() string {
+	if len(s.config.Server) > 0 {
+		return s.config.Server
+	}
+	return s.UserAgent()
+}
+
+// appendAgentHeader add the User-Agent of a request, or the Server of a response, unless hidden: by the
+// stack, or by an empty header, e.g. the one of a profile hiding it.
+func (s *SipStack) appendAgentHeader(msg sip.Message) {
+	if s.config.HideUserAgent {
+		msg.RemoveHeader("User-Agent")
+		msg.RemoveHeader("Server")
+		return
+	}
+	var header sip.Header
+	if _, ok := msg.(sip.Response); ok {
+		header = &sip.GenericHeader{HeaderName: "Server", Contents: s.Server()}
+	} else {
+		userAgent := sip.UserAgentHeader(s.UserAgent())
+		header = &userAgent
+	}
+	hdrs := msg.GetHeaders(header.Name())
+	switch {
+	case len(hdrs) == 0:
+		msg.AppendHeader(header)
+	case len(hdrs[0].Value()) == 0:
+		msg.RemoveHeader(header.Name())
+	case hdrs[0].Value() == builderUserAgent:
+		msg.ReplaceHeaders(header.Name(), []sip.Header{header})
+	}
+}
+
+// orderHeaders sort the headers of msg in the HeaderOrder of the config, the others follow in the
+// order they were added, Content-Length last.
+func (s *SipStack) orderHeaders(msg sip.Message) {
+	if len(s.config.HeaderOrder) == 0 {
+		return
+	}
+	headers := msg.Headers()
+	ordered := make([]sip.Header, 0, len(headers))
+	placed := map[string]bool{"content-length": true}
+	for _, name := range s.config.HeaderOrder {
+		name = strings.ToLower(name)
+		if placed[name] {
+			continue
+		}
+		placed[name] = true
+		ordered = append(ordered, msg.GetHeaders(name)...)
+	}
+	for _, header := range headers {
+		if !placed[strings.ToLower(header.Name())] {
+			ordered = append(ordered, header)
+		}
+	}
+	ordered = append(ordered, msg.GetHeaders("Content-Length")...)
+	for _, header := range headers {
+		msg.RemoveHeader(header.Name())
+	}
+	for _, header := range ordered {
+		msg.AppendHeader(header)
+	}
+}
@@ -0,0 +1,233 @@
+package stack
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+)
+
+// ConnectionLimits limits of the inbound TCP/TLS/WS/WSS connections, 0 for unlimited.
+type ConnectionLimits struct {
+	// MaxConnections concurrent inbound connections.
+	MaxConnections int
+	// MaxPerSource concurrent inbound connections per source IP.
+	MaxPerSource int
+	// IdleTimeout closes the inbound connections without traffic for this long.
+	IdleTimeout time.Duration
+}
+
+func (l ConnectionLimits) enabled() bool {
+	return l.MaxConnections > 0 || l.MaxPerSource > 0 || l.IdleTimeout > 0
+}
+
+// ConnectionStats metrics of the inbound stream connections.
+type ConnectionStats struct {
+	// Connections open.
+	Connections int
+	// Sources distinct source IPs with open connections.
+	Sources int
+	// Accepted connections since start.
+	Accepted uint64
+	// Rejected connections over the limits.
+	Rejected uint64
+	// Evicted idle connections.
+	Evicted uint64
+}
+
+// connLimiter tracks the inbound connections of all the stream listeners.
+type connLimiter struct {
+	limits   ConnectionLimits
+	mutex    sync.Mutex
+	conns    map[*limitedConn]struct{}
+	sources  map[string]int
+	accepted uint64
+	rejected uint64
+	evicted  uint64
+	done     chan struct{}
+	once     sync.Once
+	log      log.Logger
+}
+
+func newConnLimiter(limits ConnectionLimits, logger log.Logger) *connLimiter {
+	l := &connLimiter{
+		limits:  limits,
+		conns:   make(map[*limitedConn]struct{}),
+		sources: make(map[string]int),
+		done:    make(chan struct{}),
+		log:     logger,
+	}
+	if limits.IdleTimeout > 0 {
+		go l.evictIdle()
+	}
+	return l
+}
+
+func sourceIP(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// admit tracks conn, or returns nil if it's over the limits.
+func (l *connLimiter) admit(conn net.Conn) *limitedConn {
+	source := sourceIP(conn.RemoteAddr())
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.limits.MaxConnections > 0 && len(l.conns) >= l.limits.MaxConnections {
+		atomic.AddUint64(&l.rejected, 1)
+		l.log.Warnf("reject connection from %s: %d connections", conn.RemoteAddr(), len(l.conns))
+		return nil
+	}
+	if l.limits.MaxPerSource > 0 && l.sources[source] >= l.limits.MaxPerSource {
+		atomic.AddUint64(&l.rejected, 1)
+		l.log.Warnf("reject connection from %s: %d connections from %s", conn.RemoteAddr(), l.sources[source], source)
+		return nil
+	}
+
+	lc := &limitedConn{
+		Conn:    conn,
+		source:  source,
+		limiter: l,
+	}
+	lc.touch()
+	l.conns[lc] = struct{}{}
+	l.sources[source]++
+	atomic.AddUint64(&l.accepted, 1)
+	return lc
+}
+
+func (l *connLimiter) release(lc *limitedConn) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.conns[lc]; !ok {
+		return
+	}
+	delete(l.conns, lc)
+	if l.sources[lc.source]--; l.sources[lc.source] <= 0 {
+		delete(l.sources, lc.source)
+	}
+}
+
+func (l *connLimiter) evictIdle() {
+	interval := l.limits.IdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case now := <-ticker.C:
+			idle := []*limitedConn{}
+			l.mutex.Lock()
+			for lc := range l.conns {
+				if now.Sub(lc.lastActive()) >= l.limits.IdleTimeout {
+					idle = append(idle, lc)
+				}
+			}
+			l.mutex.Unlock()
+			// Closing the connection makes the connection pool drop it.
+			for _, lc := range idle {
+				l.log.Infof("evict idle connection from %s", lc.RemoteAddr())
+				atomic.AddUint64(&l.evicted, 1)
+				lc.Close()
+			}
+		}
+	}
+}
+
+func (l *connLimiter) stats() ConnectionStats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return ConnectionStats{
+		Connections: len(l.conns),
+		Sources:     len(l.sources),
+		Accepted:    atomic.LoadUint64(&l.accepted),
+		Rejected:    atomic.LoadUint64(&l.rejected),
+		Evicted:     atomic.LoadUint64(&l.evicted),
+	}
+}
+
+func (l *connLimiter) stop() {
+	l.once.Do(func() {
+		close(l.done)
+	})
+}
+
+// limitedConn an inbound connection tracked by the limiter.
+type limitedConn struct {
+	net.Conn
+	source  string
+	active  int64
+	limiter *connLimiter
+	once    sync.Once
+	err     error
+}
+
+func (c *limitedConn) touch() {
+	atomic.StoreInt64(&c.active, time.Now().UnixNano())
+}
+
+func (c *limitedConn) lastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.active))
+}
+
+func (c *limitedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+// Close closes the connection once, the connection pool closes the evicted connections again.
+func (c *limitedConn) Close() error {
+	c.once.Do(func() {
+		c.limiter.release(c)
+		c.err = c.Conn.Close()
+	})
+	return c.err
+}
+
+// limitedListener closes the accepted connections over the limits.
+type limitedListener struct {
+	net.Listener
+	limiter *connLimiter
+}
+
+func (l *limitedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if lc := l.limiter.admit(conn); lc != nil {
+			return lc, nil
+		}
+		conn.Close()
+	}
+}
+
+// ConnectionStats inbound connection metrics, ok is false when the connection limits are disabled.
+func (s *SipStack) ConnectionStats() (ConnectionStats, bool) {
+	if s.limiter == nil {
+		return ConnectionStats{}, false
+	}
+	return s.limiter.stats(), true
+}
@@ -0,0 +1,614 @@
+package stack
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/transport"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+const (
+	// streamConnTTL idle time-to-live of the stream connections, as in gosip.
+	streamConnTTL = time.Hour
+	// wsSubProtocol websocket sub-protocol of SIP, RFC 7118.
+	wsSubProtocol = "sip"
+	// wsHandshakeTimeout time given to the accepted WS/WSS connections to upgrade.
+	wsHandshakeTimeout = 10 * time.Second
+)
+
+var (
+	// protocolsOnce installs the dispatchProtocol factory in gosip, once for all the stacks.
+	protocolsOnce sync.Once
+	// defaultProtocolFactory the factory dispatchProtocol replaced, gosip one unless set before.
+	defaultProtocolFactory transport.ProtocolFactory
+	protocolsLock          sync.RWMutex
+	// stackProtocols the stacks with their own protocols, by the pointer of their transport layer.
+	stackProtocols = make(map[string]*SipStack)
+)
+
+// useProtocols the transport layer of s creates the stack protocols, the other layers of the
+// process keep the protocols they had. Called before the layer listens.
+func (s *SipStack) useProtocols() {
+	protocolsOnce.Do(func() {
+		defaultProtocolFactory = transport.GetProtocolFactory()
+		transport.SetProtocolFactory(dispatchProtocol)
+	})
+	protocolsLock.Lock()
+	stackProtocols[fmt.Sprintf("%p", s.tp)] = s
+	protocolsLock.Unlock()
+}
+
+// releaseProtocols forget the transport layer of s, once shut down.
+func (s *SipStack) releaseProtocols() {
+	protocolsLock.Lock()
+	delete(stackProtocols, fmt.Sprintf("%p", s.tp))
+	protocolsLock.Unlock()
+}
+
+// dispatchProtocol the gosip protocol factory is global: create the protocols of the stack whose
+// transport layer listens, identified by the logger of the layer, else the default ones.
+func dispatchProtocol(
+	network string,
+	output chan<- sip.Message,
+	errs chan<- error,
+	cancel <-chan struct{},
+	msgMapper sip.MessageMapper,
+	logger log.Logger,
+) (transport.Protocol, error) {
+	layerPtr, _ := logger.Fields()["transport_layer_ptr"].(string)
+	protocolsLock.RLock()
+	s, found := stackProtocols[layerPtr]
+	protocolsLock.RUnlock()
+	if !found {
+		return defaultProtocolFactory(network, output, errs, cancel, msgMapper, logger)
+	}
+	return s.protocolFactory(network, output, errs, cancel, msgMapper, logger)
+}
+
+// protocolFactory creates the stack protocols, which take their sockets from the stack
+// (SO_REUSEPORT, sockets handed off by a previous process) and apply the connection limits.
+func (s *SipStack) protocolFactory(
+	network string,
+	output chan<- sip.Message,
+	errs chan<- error,
+	cancel <-chan struct{},
+	msgMapper sip.MessageMapper,
+	logger log.Logger,
+) (transport.Protocol, error) {
+	// The tel: URIs received are rewritten in their SIP form at the stack host.
+	telHost := ""
+	if s.config.AcceptTelURI {
+		telHost = s.host
+	}
+	switch strings.ToLower(network) {
+	case "udp":
+		return newUDPProtocol(s.sockets, s.destinations, telHost, output, errs, cancel, msgMapper, logger), nil
+	case "tcp", "tls", "ws", "wss":
+		return newStreamProtocol(strings.ToLower(network), s.sockets, s.limiter, s.config.WebSocketPath, s.config.TLSVerify, s.destinations, telHost, output, errs, cancel, msgMapper, logger), nil
+	}
+	return defaultProtocolFactory(network, output, errs, cancel, msgMapper, logger)
+}
+
+// udpProtocol UDP protocol listening on the stack sockets.
+type udpProtocol struct {
+	network      string
+	sockets      *sockets
+	destinations *destinationRouter
+	telHost      string
+	connections  transport.ConnectionPool
+	log          log.Logger
+}
+
+func newUDPProtocol(
+	sockets *sockets,
+	destinations *destinationRouter,
+	telHost string,
+	output chan<- sip.Message,
+	errs chan<- error,
+	cancel <-chan struct{},
+	msgMapper sip.MessageMapper,
+	logger log.Logger,
+) transport.Protocol {
+	p := &udpProtocol{
+		network:      "udp",
+		sockets:      sockets,
+		destinations: destinations,
+		telHost:      telHost,
+	}
+	p.log = logger.WithPrefix("stack.Protocol").WithFields(log.Fields{
+		"protocol_ptr": fmt.Sprintf("%p", p),
+	})
+	p.connections = transport.NewConnectionPool(output, errs, cancel, msgMapper, p.log)
+	return p
+}
+
+func (p *udpProtocol) Done() <-chan struct{} {
+	return p.connections.Done()
+}
+
+func (p *udpProtocol) Network() string {
+	return strings.ToUpper(p.network)
+}
+
+func (p *udpProtocol) Reliable() bool {
+	return false
+}
+
+func (p *udpProtocol) Streamed() bool {
+	return false
+}
+
+func (p *udpProtocol) String() string {
+	return fmt.Sprintf("stack.Protocol<%s>", p.network)
+}
+
+func (p *udpProtocol) Listen(target *transport.Target, options ...transport.ListenOption) error {
+	target = transport.FillTargetHostAndPort(p.Network(), target)
+	packetConn, err := p.sockets.listenPacket(p.network, target.Addr())
+	if err != nil {
+		return &transport.ProtocolError{
+			Err:      err,
+			Op:       fmt.Sprintf("listen on %s %s address", p.Network(), target.Addr()),
+			ProtoPtr: fmt.Sprintf("%p", p),
+		}
+	}
+	udpConn, ok := packetConn.(*net.UDPConn)
+	if !ok {
+		packetConn.Close()
+		return fmt.Errorf("%s socket on %s is not UDP", p.Network(), target.Addr())
+	}
+	laddr := udpConn.LocalAddr().(*net.UDPAddr)
+
+	p.log.Debugf("begin listening on %s %s", p.Network(), laddr)
+
+	key := transport.ConnectionKey(fmt.Sprintf("%s:%s", p.network, laddr))
+	var baseConn net.Conn = udpConn
+	if len(p.telHost) > 0 {
+		baseConn = &telPacketConn{UDPConn: udpConn, host: p.telHost}
+	}
+	if p.sockets.relay != nil {
+		baseConn = p.sockets.relay.wrap(socketKey(p.network, target.Addr()), baseConn.(datagramConn))
+	}
+	conn := transport.NewConnection(baseConn, key, p.network, p.log)
+	if err := p.connections.Put(conn, 0); err != nil {
+		return &transport.ProtocolError{
+			Err:      err,
+			Op:       fmt.Sprintf("put %s connection to the pool", conn.Key()),
+			ProtoPtr: fmt.Sprintf("%p", p),
+		}
+	}
+	return nil
+}
+
+func (p *udpProtocol) Send(target *transport.Target, msg sip.Message) error {
+	target = transport.FillTargetHostAndPort(p.Network(), target)
+	if target.Host == "" {
+		return fmt.Errorf("send SIP message to %s %s: empty remote target host", p.Network(), target.Addr())
+	}
+	target, source := p.destinations.route(p.network, target, msg)
+	raddr, err := net.ResolveUDPAddr(p.network, target.Addr())
+	if err != nil {
+		return err
+	}
+	_, port, err := net.SplitHostPort(msg.Source())
+	if err != nil {
+		return err
+	}
+
+	// The socket bound to the source address, else any socket on the port.
+	var found transport.Connection
+	for _, conn := range p.connections.All() {
+		laddr, ok := conn.LocalAddr().(*net.UDPAddr)
+		if !ok || strconv.Itoa(laddr.Port) != port {
+			continue
+		}
+		if source == nil || laddr.IP.Equal(source) {
+			found = conn
+			break
+		}
+		if found == nil {
+			found = conn
+		}
+	}
+	if found == nil {
+		return fmt.Errorf("%s connection on port %s not found", p.Network(), port)
+	}
+	buf := utils.GetBuffer()
+	defer utils.PutBuffer(buf)
+	writeMessage(buf, msg)
+	_, err = found.WriteTo(buf.Bytes(), raddr)
+	return err
+}
+
+// streamListener a listener of the stream protocols, with the network the connections are served on.
+type streamListener struct {
+	net.Listener
+	network string
+	telHost string
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || len(l.telHost) == 0 {
+		return conn, err
+	}
+	return newTelConn(conn, l.telHost), nil
+}
+
+func (l *streamListener) Network() string {
+	return strings.ToUpper(l.network)
+}
+
+// wsClientConn websocket framing of the dialed WS/WSS connections.
+type wsClientConn struct {
+	net.Conn
+}
+
+func (c *wsClientConn) Read(b []byte) (int, error) {
+	msg, op, err := wsutil.ReadServerData(c.Conn)
+	if err != nil {
+		var closed wsutil.ClosedError
+		if errors.As(err, &closed) {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	if op == ws.OpClose {
+		return 0, io.EOF
+	}
+	return copy(b, msg), nil
+}
+
+func (c *wsClientConn) Write(b []byte) (int, error) {
+	if err := wsutil.WriteClientMessage(c.Conn, ws.OpText, b); err != nil {
+		var closed wsutil.ClosedError
+		if errors.As(err, &closed) {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// wsServerConn websocket framing of the accepted WS/WSS connections.
+type wsServerConn struct {
+	net.Conn
+}
+
+func (c *wsServerConn) Read(b []byte) (int, error) {
+	msg, op, err := wsutil.ReadClientData(c.Conn)
+	if err != nil {
+		var closed wsutil.ClosedError
+		if errors.As(err, &closed) {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	if op == ws.OpClose {
+		return 0, io.EOF
+	}
+	return copy(b, msg), nil
+}
+
+func (c *wsServerConn) Write(b []byte) (int, error) {
+	if err := wsutil.WriteServerMessage(c.Conn, ws.OpText, b); err != nil {
+		var closed wsutil.ClosedError
+		if errors.As(err, &closed) {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// wsListener upgrades the accepted connections to websocket. Without a path, the connections
+// failing the upgrade are served as plain TCP like gosip does, with a path they are closed.
+type wsListener struct {
+	net.Listener
+	network  string
+	path     string
+	telHost  string
+	upgrader ws.Upgrader
+	log      log.Logger
+}
+
+func newWsListener(listener net.Listener, network string, path string, telHost string, logger log.Logger) *wsListener {
+	l := &wsListener{
+		Listener: listener,
+		network:  network,
+		path:     path,
+		telHost:  telHost,
+		log:      logger,
+	}
+	l.upgrader.Protocol = func(val []byte) bool {
+		return string(val) == wsSubProtocol
+	}
+	if len(path) > 0 {
+		l.upgrader.OnRequest = func(uri []byte) error {
+			if p := strings.SplitN(string(uri), "?", 2)[0]; p != path {
+				return ws.RejectConnectionError(ws.RejectionStatus(404))
+			}
+			return nil
+		}
+	}
+	return l
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, fmt.Errorf("accept new connection: %w", err)
+		}
+		conn.SetDeadline(time.Now().Add(wsHandshakeTimeout))
+		_, err = l.upgrader.Upgrade(conn)
+		conn.SetDeadline(time.Time{})
+		if err == nil {
+			return l.wrap(&wsServerConn{Conn: conn}), nil
+		}
+		if len(l.path) == 0 {
+			l.log.Warnf("fallback to simple TCP connection due to WS upgrade error: %s", err)
+			return l.wrap(conn), nil
+		}
+		l.log.Debugf("reject %s connection from %s: %s", l.Network(), conn.RemoteAddr(), err)
+		conn.Close()
+	}
+}
+
+// wrap conn rewriting its tel: URIs, if accepted.
+func (l *wsListener) wrap(conn net.Conn) net.Conn {
+	if len(l.telHost) == 0 {
+		return conn
+	}
+	return newTelConn(conn, l.telHost)
+}
+
+func (l *wsListener) Network() string {
+	return strings.ToUpper(l.network)
+}
+
+// streamProtocol TCP, TLS, WS and WSS protocols listening on the stack sockets.
+type streamProtocol struct {
+	network      string
+	sockets      *sockets
+	limiter      *connLimiter
+	wsPath       string
+	tlsVerify    *TLSVerifyPolicy
+	destinations *destinationRouter
+	telHost      string
+	listeners    transport.ListenerPool
+	connections  transport.ConnectionPool
+	conns        chan transport.Connection
+	log          log.Logger
+}
+
+func newStreamProtocol(
+	network string,
+	sockets *sockets,
+	limiter *connLimiter,
+	wsPath string,
+	tlsVerify *TLSVerifyPolicy,
+	destinations *destinationRouter,
+	telHost string,
+	output chan<- sip.Message,
+	errs chan<- error,
+	cancel <-chan struct{},
+	msgMapper sip.MessageMapper,
+	logger log.Logger,
+) transport.Protocol {
+	p := &streamProtocol{
+		network:      network,
+		sockets:      sockets,
+		limiter:      limiter,
+		wsPath:       wsPath,
+		tlsVerify:    tlsVerify,
+		destinations: destinations,
+		telHost:      telHost,
+		conns:        make(chan transport.Connection),
+	}
+	p.log = logger.WithPrefix("stack.Protocol").WithFields(log.Fields{
+		"protocol_ptr": fmt.Sprintf("%p", p),
+	})
+	p.listeners = transport.NewListenerPool(p.conns, errs, cancel, p.log)
+	p.connections = transport.NewConnectionPool(output, errs, cancel, msgMapper, p.log)
+	go p.pipePools()
+	return p
+}
+
+func (p *streamProtocol) Done() <-chan struct{} {
+	return p.connections.Done()
+}
+
+func (p *streamProtocol) Network() string {
+	return strings.ToUpper(p.network)
+}
+
+func (p *streamProtocol) Reliable() bool {
+	return true
+}
+
+func (p *streamProtocol) Streamed() bool {
+	return true
+}
+
+func (p *streamProtocol) String() string {
+	return fmt.Sprintf("stack.Protocol<%s>", p.network)
+}
+
+// pipePools serve the accepted connections.
+func (p *streamProtocol) pipePools() {
+	defer close(p.conns)
+	for {
+		select {
+		case <-p.listeners.Done():
+			return
+		case conn := <-p.conns:
+			if err := p.connections.Put(conn, streamConnTTL); err != nil {
+				p.log.Errorf("put %s connection to the pool failed: %s", conn.Key(), err)
+				conn.Close()
+			}
+		}
+	}
+}
+
+func (p *streamProtocol) Listen(target *transport.Target, options ...transport.ListenOption) error {
+	target = transport.FillTargetHostAndPort(p.Network(), target)
+	listener, err := p.sockets.listen("tcp", target.Addr())
+	if err != nil {
+		return &transport.ProtocolError{
+			Err:      err,
+			Op:       fmt.Sprintf("listen on %s %s address", p.Network(), target.Addr()),
+			ProtoPtr: fmt.Sprintf("%p", p),
+		}
+	}
+
+	if p.limiter != nil {
+		listener = &limitedListener{Listener: listener, limiter: p.limiter}
+	}
+
+	if (p.network == "tls" || p.network == "wss") && len(options) > 0 {
+		opts := transport.ListenOptions{}
+		for _, opt := range options {
+			opt.ApplyListen(&opts)
+		}
+		cert, err := tls.LoadX509KeyPair(opts.TLSConfig.Cert, opts.TLSConfig.Key)
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("load TLS certficate %s: %w", opts.TLSConfig.Cert, err)
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+
+	p.log.Debugf("begin listening on %s %s", p.Network(), target.Addr())
+
+	var poolListener net.Listener = &streamListener{Listener: listener, network: p.network, telHost: p.telHost}
+	if p.network == "ws" || p.network == "wss" {
+		poolListener = newWsListener(listener, p.network, p.wsPath, p.telHost, p.log)
+	}
+	key := transport.ListenerKey(fmt.Sprintf("%s:0.0.0.0:%d", p.network, target.Port))
+	if err := p.listeners.Put(key, poolListener); err != nil {
+		return &transport.ProtocolError{
+			Err:      err,
+			Op:       fmt.Sprintf("put %s listener to the pool", key),
+			ProtoPtr: fmt.Sprintf("%p", p),
+		}
+	}
+	return nil
+}
+
+// tlsConfig the config dialing host over TLS or WSS: the one of the TLSVerifyPolicy, else the historical
+// ones, verifying the host dialed over TLS, nothing over WSS.
+func (p *streamProtocol) tlsConfig(host string) *tls.Config {
+	if p.tlsVerify != nil {
+		return p.tlsVerify.tlsConfig(host)
+	}
+	if p.network == "wss" {
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+	return &tls.Config{}
+}
+
+// dial raddr from the source address, if any.
+func (p *streamProtocol) dial(raddr *net.TCPAddr, host string, source net.IP) (net.Conn, error) {
+	netDialer := p.sockets.dialer()
+	if source != nil {
+		netDialer.LocalAddr = &net.TCPAddr{IP: source}
+	}
+	switch p.network {
+	case "tls":
+		return tls.DialWithDialer(netDialer, "tcp", raddr.String(), p.tlsConfig(host))
+	case "ws", "wss":
+		dialer := ws.Dialer{
+			Protocols: []string{wsSubProtocol},
+			Timeout:   time.Minute,
+			NetDial:   netDialer.DialContext,
+		}
+		if p.network == "wss" {
+			dialer.TLSConfig = p.tlsConfig(host)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		conn, _, _, err := dialer.Dial(ctx, fmt.Sprintf("%s://%s", p.network, raddr))
+		if err != nil {
+			return nil, err
+		}
+		return &wsClientConn{Conn: conn}, nil
+	}
+	return netDialer.Dial("tcp", raddr.String())
+}
+
+// dialedHost the host msg is sent to, before its SRV resolution, the SIP domain of a request.
+func dialedHost(target *transport.Target, msg sip.Message) string {
+	if req, ok := msg.(sip.Request); ok && len(req.Destination()) > 0 {
+		if host, _, err := net.SplitHostPort(req.Destination()); err == nil {
+			return host
+		}
+		return req.Destination()
+	}
+	return target.Host
+}
+
+func (p *streamProtocol) Send(target *transport.Target, msg sip.Message) error {
+	target = transport.FillTargetHostAndPort(p.Network(), target)
+	if target.Host == "" {
+		return fmt.Errorf("send SIP message to %s %s: empty remote target host", p.Network(), target.Addr())
+	}
+	host := dialedHost(target, msg)
+	target, source := p.destinations.route(p.network, target, msg)
+	raddr, err := net.ResolveTCPAddr("tcp", target.Addr())
+	if err != nil {
+		return err
+	}
+
+	key := transport.ConnectionKey(p.network + ":" + raddr.String())
+	conn, err := p.connections.Get(key)
+	if err != nil {
+		baseConn, err := p.dial(raddr, host, source)
+		if err != nil {
+			var certErr *CertificateError
+			if errors.As(err, &certErr) {
+				p.log.Warnf("%s %s not dialed: %s", p.Network(), raddr, certErr)
+			}
+			return fmt.Errorf("dial to %s %s: %w", p.Network(), raddr, err)
+		}
+		if len(p.telHost) > 0 {
+			baseConn = newTelConn(baseConn, p.telHost)
+		}
+		conn = transport.NewConnection(baseConn, key, p.network, p.log)
+		if err := p.connections.Put(conn, streamConnTTL); err != nil {
+			return fmt.Errorf("put %s connection to the pool: %w", conn.Key(), err)
+		}
+	}
+
+	buf := utils.GetBuffer()
+	defer utils.PutBuffer(buf)
+	writeMessage(buf, msg)
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
+
+// writeMessage serialize msg in buf as msg.String(), without its intermediate strings.
+func writeMessage(buf *bytes.Buffer, msg sip.Message) {
+	buf.WriteString(msg.StartLine())
+	buf.WriteString("\r\n")
+	for _, header := range msg.Headers() {
+		buf.WriteString(header.String())
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.WriteString(msg.Body())
+}
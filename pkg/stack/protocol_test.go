@@ -0,0 +1,53 @@
+package stack
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+func TestProtocolsPerStack(t *testing.T) {
+	custom := NewSipStack(&SipStackConfig{Host: "127.0.0.1", ReusePort: true})
+	defer custom.Shutdown()
+	plain := NewSipStack(&SipStackConfig{Host: "127.0.0.1"})
+	defer plain.Shutdown()
+
+	if err := custom.Listen("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Listen("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	protocolsLock.RLock()
+	_, customFound := stackProtocols[fmt.Sprintf("%p", custom.tp)]
+	_, plainFound := stackProtocols[fmt.Sprintf("%p", plain.tp)]
+	protocolsLock.RUnlock()
+	if !customFound || plainFound {
+		t.Fatalf("stack protocols: custom %v, plain %v", customFound, plainFound)
+	}
+
+	cancel := make(chan struct{})
+	defer close(cancel)
+	for _, test := range []struct {
+		name   string
+		stack  *SipStack
+		custom bool
+	}{
+		{"custom", custom, true},
+		{"plain", plain, false},
+	} {
+		logger := utils.NewLogrusLogger(log.FatalLevel, "transport.Layer", nil).WithFields(log.Fields{
+			"transport_layer_ptr": fmt.Sprintf("%p", test.stack.tp),
+		})
+		protocol, err := dispatchProtocol("udp", make(chan sip.Message), make(chan error), cancel, nil, logger)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := protocol.(*udpProtocol); ok != test.custom {
+			t.Errorf("%s: %s, stack protocol %v", test.name, protocol, test.custom)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package stack
+
+import (
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// RecordRouteURI the URI of the stack on a transport, as inserted in the Record-Route, loose routing.
+func (s *SipStack) RecordRouteURI(protocol string) sip.Uri {
+	target := s.GetNetworkInfo(protocol)
+	params := sip.NewParams()
+	if !strings.EqualFold(protocol, "udp") {
+		params.Add("transport", sip.String{Str: strings.ToLower(protocol)})
+	}
+	params.Add("lr", nil)
+	if len(s.config.InstanceID) > 0 {
+		params.Add(InstanceParam, sip.String{Str: s.config.InstanceID})
+	}
+	return &sip.SipUri{
+		FHost:      target.Host,
+		FPort:      target.Port,
+		FUriParams: params,
+	}
+}
+
+// AddRecordRoute insert the stack in the Record-Route of a request it forwards, received on the inbound
+// transport and sent on the outbound one, so that the requests of the dialog keep flowing through it.
+// When the transport changes, e.g. UDP to WSS, two entries are inserted (double Record-Route, RFC 5658),
+// each side of the dialog reaches the stack on its own transport.
+func (s *SipStack) AddRecordRoute(request sip.Request, inbound string, outbound string) {
+	uris := []sip.Uri{s.RecordRouteURI(outbound)}
+	if !strings.EqualFold(inbound, outbound) {
+		uris = append(uris, s.RecordRouteURI(inbound))
+	}
+	request.PrependHeader(&sip.RecordRouteHeader{Addresses: uris})
+}
+
+// IsLocalURI the URI is an address of the stack, e.g. one it inserted in a Record-Route.
+func (s *SipStack) IsLocalURI(uri sip.Uri) bool {
+	if !strings.EqualFold(uri.Host(), s.host) && (s.ip == nil || uri.Host() != s.ip.String()) {
+		return false
+	}
+	transport := "udp"
+	if uri.UriParams() != nil {
+		if value, ok := uri.UriParams().Get("transport"); ok && value != nil {
+			transport = value.String()
+		}
+	}
+	port := sip.DefaultPort(transport)
+	if uri.Port() != nil {
+		port = *uri.Port()
+	}
+	for _, listenPort := range s.listenPorts {
+		if listenPort != nil && *listenPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+// ProcessRoute the route information of a request received by the stack acting as a proxy, RFC 3261 16.4:
+// the Request-URI set by a strict router is replaced by the last Route, and the top Route entries of the
+// stack, two of them after a double Record-Route, are removed. The request is then forwarded to the next
+// Route, or to its Request-URI.
+func (s *SipStack) ProcessRoute(request sip.Request) {
+	routes := []sip.Uri{}
+	for _, header := range request.GetHeaders("Route") {
+		if route, ok := header.(*sip.RouteHeader); ok {
+			routes = append(routes, route.Addresses...)
+		}
+	}
+	strict := s.IsLocalURI(request.Recipient()) && len(routes) > 0
+	if strict {
+		request.SetRecipient(routes[len(routes)-1])
+		routes = routes[:len(routes)-1]
+	}
+	removed := 0
+	for removed < 2 && removed < len(routes) && s.IsLocalURI(routes[removed]) {
+		removed++
+	}
+	if removed == 0 && !strict {
+		return
+	}
+	request.RemoveHeader("Route")
+	if routes = routes[removed:]; len(routes) > 0 {
+		request.PrependHeader(&sip.RouteHeader{Addresses: routes})
+	}
+}
@@ -0,0 +1,294 @@
+package stack
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghettovoice/gosip/log"
+)
+
+// ResolverProtocol transport of the DNS queries of the stack.
+type ResolverProtocol string
+
+const (
+	// ResolverUDP plain DNS, port 53 by default.
+	ResolverUDP ResolverProtocol = "udp"
+	// ResolverTLS DNS over TLS, RFC 7858, port 853 by default.
+	ResolverTLS ResolverProtocol = "tls"
+	// ResolverHTTPS DNS over HTTPS, RFC 8484, Server is the URL of the service, e.g. https://dns.google/dns-query
+	ResolverHTTPS ResolverProtocol = "https"
+	// DefaultResolverTimeout of a DNS exchange.
+	DefaultResolverTimeout = 5 * time.Second
+)
+
+// DNSSECMode how the stack relies on the DNSSEC validation of its resolver.
+type DNSSECMode int
+
+const (
+	// DNSSECOff the answers are used as they are.
+	DNSSECOff DNSSECMode = iota
+	// DNSSECPrefer the validation is requested, the unauthenticated answers are counted and logged but used,
+	// e.g. for the unsigned zones.
+	DNSSECPrefer
+	// DNSSECRequire the unauthenticated answers are refused, the resolution fails.
+	DNSSECRequire
+)
+
+// ResolverConfig the resolver of the targets of the requests, for the deployments where it must be
+// tamper-resistant. DNSSEC is validated by the resolver, a validating one reached over TLS or HTTPS,
+// the stack checks the AD flag of its answers, RFC 6840.
+type ResolverConfig struct {
+	Protocol ResolverProtocol
+	// Server host:port of the resolver, the URL of the DNS over HTTPS service.
+	Server string
+	// ServerName verified in the certificate of the resolver over TLS or HTTPS, the host of Server if empty.
+	ServerName string
+	// RootCAs trusted by the resolver over TLS or HTTPS, the ones of the system if nil.
+	RootCAs *x509.CertPool
+	DNSSEC  DNSSECMode
+	// Timeout of an exchange, DefaultResolverTimeout if 0.
+	Timeout time.Duration
+}
+
+// ResolverStats the answers of the resolver since start.
+type ResolverStats struct {
+	// Unauthenticated answers without the AD flag, with DNSSEC enabled, Refused the ones failed by DNSSECRequire.
+	Unauthenticated uint64 `json:"unauthenticated"`
+	Refused         uint64 `json:"refused"`
+}
+
+// secureResolver the resolver of a ResolverConfig.
+type secureResolver struct {
+	config          ResolverConfig
+	tlsConfig       *tls.Config
+	client          *http.Client
+	unauthenticated uint64
+	refused         uint64
+	log             log.Logger
+}
+
+func newSecureResolver(config ResolverConfig, logger log.Logger) (*secureResolver, error) {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultResolverTimeout
+	}
+	r := &secureResolver{config: config, log: logger}
+	switch config.Protocol {
+	case ResolverUDP, "":
+		r.config.Server = withDefaultPort(config.Server, "53")
+	case ResolverTLS:
+		r.config.Server = withDefaultPort(config.Server, "853")
+		host, _, _ := net.SplitHostPort(r.config.Server)
+		r.tlsConfig = &tls.Config{ServerName: serverName(config.ServerName, host), RootCAs: config.RootCAs}
+	case ResolverHTTPS:
+		u, err := url.Parse(config.Server)
+		if err != nil || u.Scheme != "https" {
+			return nil, fmt.Errorf("DNS over HTTPS: invalid URL %s", config.Server)
+		}
+		r.tlsConfig = &tls.Config{ServerName: serverName(config.ServerName, u.Hostname()), RootCAs: config.RootCAs}
+		r.client = &http.Client{
+			Timeout:   config.Timeout,
+			Transport: &http.Transport{TLSClientConfig: r.tlsConfig, ForceAttemptHTTP2: true},
+		}
+	default:
+		return nil, fmt.Errorf("unknown resolver protocol %s", config.Protocol)
+	}
+	return r, nil
+}
+
+func withDefaultPort(addr string, port string) string {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return net.JoinHostPort(addr, port)
+	}
+	return addr
+}
+
+func serverName(name string, host string) string {
+	if len(name) > 0 {
+		return name
+	}
+	return host
+}
+
+// dial the connection of an exchange, whatever network the Go resolver asks for.
+func (r *secureResolver) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: r.config.Timeout}
+	switch r.config.Protocol {
+	case ResolverTLS:
+		conn, err := tls.DialWithDialer(&dialer, "tcp", r.config.Server, r.tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		return &dnsStreamConn{Conn: conn, resolver: r}, nil
+	case ResolverHTTPS:
+		return &dohConn{ctx: ctx, resolver: r}, nil
+	}
+	conn, err := dialer.DialContext(ctx, "udp", r.config.Server)
+	if err != nil {
+		return nil, err
+	}
+	if r.config.DNSSEC == DNSSECOff {
+		return conn, nil
+	}
+	return &dnsPacketConn{UDPConn: conn.(*net.UDPConn), resolver: r}, nil
+}
+
+// query the query msg to send, with the AD flag requesting the DNSSEC validation.
+func (r *secureResolver) query(msg []byte) []byte {
+	query := append([]byte{}, msg...)
+	if r.config.DNSSEC != DNSSECOff && len(query) >= 4 {
+		query[3] |= 0x20
+	}
+	return query
+}
+
+// answer check the AD flag of the answer msg, turned into a SERVFAIL when DNSSECRequire refuses it.
+func (r *secureResolver) answer(msg []byte) {
+	if r.config.DNSSEC == DNSSECOff || len(msg) < 4 || msg[3]&0x20 != 0 {
+		return
+	}
+	atomic.AddUint64(&r.unauthenticated, 1)
+	if r.config.DNSSEC == DNSSECRequire {
+		atomic.AddUint64(&r.refused, 1)
+		r.log.Warnf("DNS answer %d refused, not authenticated by DNSSEC", binary.BigEndian.Uint16(msg))
+		msg[3] = msg[3]&^0x0f | 2
+		return
+	}
+	r.log.Debugf("DNS answer %d not authenticated by DNSSEC", binary.BigEndian.Uint16(msg))
+}
+
+// ResolverStats the answers of the resolver, ok is false without a Resolver in the config.
+func (s *SipStack) ResolverStats() (ResolverStats, bool) {
+	if s.resolver == nil {
+		return ResolverStats{}, false
+	}
+	return ResolverStats{
+		Unauthenticated: atomic.LoadUint64(&s.resolver.unauthenticated),
+		Refused:         atomic.LoadUint64(&s.resolver.refused),
+	}, true
+}
+
+// dnsPacketConn plain DNS over UDP, checking DNSSEC, a net.PacketConn so that the Go resolver sends a
+// message per datagram.
+type dnsPacketConn struct {
+	*net.UDPConn
+	resolver *secureResolver
+}
+
+func (c *dnsPacketConn) Write(b []byte) (int, error) {
+	if _, err := c.UDPConn.Write(c.resolver.query(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *dnsPacketConn) Read(b []byte) (int, error) {
+	n, err := c.UDPConn.Read(b)
+	if err == nil {
+		c.resolver.answer(b[:n])
+	}
+	return n, err
+}
+
+// dnsStreamConn DNS over TLS, the messages prefixed with their length.
+type dnsStreamConn struct {
+	net.Conn
+	resolver *secureResolver
+	pending  bytes.Buffer
+}
+
+func (c *dnsStreamConn) Write(b []byte) (int, error) {
+	if len(b) < 2 {
+		return c.Conn.Write(b)
+	}
+	query := append(append([]byte{}, b[:2]...), c.resolver.query(b[2:])...)
+	if _, err := c.Conn.Write(query); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *dnsStreamConn) Read(b []byte) (int, error) {
+	if c.pending.Len() == 0 {
+		prefix := make([]byte, 2)
+		if _, err := io.ReadFull(c.Conn, prefix); err != nil {
+			return 0, err
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(prefix))
+		if _, err := io.ReadFull(c.Conn, msg); err != nil {
+			return 0, err
+		}
+		c.resolver.answer(msg)
+		c.pending.Write(prefix)
+		c.pending.Write(msg)
+	}
+	return c.pending.Read(b)
+}
+
+// dohConn DNS over HTTPS, a stream connection to the Go resolver, each query POSTed.
+type dohConn struct {
+	ctx      context.Context
+	resolver *secureResolver
+	pending  bytes.Buffer
+	deadline time.Time
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	if len(b) < 2 || int(binary.BigEndian.Uint16(b)) != len(b)-2 {
+		return 0, fmt.Errorf("DNS over HTTPS: partial query")
+	}
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.resolver.config.Server, bytes.NewReader(c.resolver.query(b[2:])))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := c.resolver.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("DNS over HTTPS: %s", resp.Status)
+	}
+	msg, err := ioutil.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return 0, err
+	}
+	c.resolver.answer(msg)
+	prefix := make([]byte, 2)
+	binary.BigEndian.PutUint16(prefix, uint16(len(msg)))
+	c.pending.Write(prefix)
+	c.pending.Write(msg)
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.pending.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.pending.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *dohConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { c.deadline = t; return nil }
@@ -0,0 +1,198 @@
+package stack
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// DefaultTarpitDelay before a tarpitted request is answered.
+	DefaultTarpitDelay = 30 * time.Second
+	// DefaultMaxTarpitted requests held at once, the next ones are dropped.
+	DefaultMaxTarpitted = 256
+)
+
+var (
+	// DefaultScannerSignatures the User-Agents of the well-known SIP scanners.
+	DefaultScannerSignatures = []string{"friendly-scanner", "sipvicious", "sipcli", "sip-scan", "sipscan", "sundayddr",
+		"iwar", "sivus", "smap", "pplsip", "vaxsipuseragent", "gulp"}
+	// DefaultScannerFrom the From display names of the well-known SIP scanners, narrower than the
+	// signatures not to match the users.
+	DefaultScannerFrom = []string{"friendly-scanner", "sipvicious"}
+)
+
+// ScannerAction what the stack does with the requests of a scanner.
+type ScannerAction int
+
+const (
+	// ScannerDrop drop the requests silently, the scanner can't tell there is a SIP server.
+	ScannerDrop ScannerAction = iota
+	// ScannerTarpit answer the requests statelessly after the TarpitDelay, slowing down the scanner.
+	ScannerTarpit
+)
+
+// ScannerFilter blocks the requests of the SIP scanners before they reach the transaction layer, so
+// that they don't consume transactions, workers or authentication challenges.
+type ScannerFilter struct {
+	// UserAgents case-insensitive substrings of the User-Agent of the scanners.
+	UserAgents []string
+	// From case-insensitive substrings of the From of the scanners, display name or URI.
+	From   []string
+	Action ScannerAction
+	// TarpitDelay before the tarpitted requests are answered with TarpitStatus and TarpitReason, at most
+	// MaxTarpitted at once.
+	TarpitDelay  time.Duration
+	TarpitStatus sip.StatusCode
+	TarpitReason string
+	MaxTarpitted int
+}
+
+// NewScannerFilter match the DefaultScannerSignatures in the User-Agent and DefaultScannerFrom in the From.
+func NewScannerFilter(action ScannerAction) *ScannerFilter {
+	return &ScannerFilter{
+		UserAgents:   DefaultScannerSignatures,
+		From:         DefaultScannerFrom,
+		Action:       action,
+		TarpitDelay:  DefaultTarpitDelay,
+		TarpitStatus: 403,
+		TarpitReason: "Forbidden",
+		MaxTarpitted: DefaultMaxTarpitted,
+	}
+}
+
+// Match the signature req matches, "" if it doesn't come from a scanner.
+func (f *ScannerFilter) Match(req sip.Request) string {
+	if hdrs := req.GetHeaders("User-Agent"); len(hdrs) > 0 {
+		if signature := matchSignature(f.UserAgents, hdrs[0].Value()); len(signature) > 0 {
+			return signature
+		}
+	}
+	if from, ok := req.From(); ok && len(f.From) > 0 {
+		if signature := matchSignature(f.From, from.Value()); len(signature) > 0 {
+			return signature
+		}
+	}
+	return ""
+}
+
+func matchSignature(signatures []string, value string) string {
+	value = strings.ToLower(value)
+	for _, signature := range signatures {
+		if len(signature) > 0 && strings.Contains(value, strings.ToLower(signature)) {
+			return signature
+		}
+	}
+	return ""
+}
+
+// ScannerStats the requests of the scanners blocked since start.
+type ScannerStats struct {
+	Dropped   uint64 `json:"dropped"`
+	Tarpitted uint64 `json:"tarpitted"`
+	// Signatures blocked requests by matched signature.
+	Signatures map[string]uint64 `json:"signatures"`
+}
+
+// scannerBlocker applies the ScannerFilter of the stack.
+type scannerBlocker struct {
+	filter   *ScannerFilter
+	tarpits  chan struct{}
+	mutex    sync.Mutex
+	stats    ScannerStats
+	shutdown <-chan struct{}
+}
+
+func newScannerBlocker(filter *ScannerFilter, shutdown <-chan struct{}) *scannerBlocker {
+	max := filter.MaxTarpitted
+	if max <= 0 {
+		max = DefaultMaxTarpitted
+	}
+	return &scannerBlocker{
+		filter:   filter,
+		tarpits:  make(chan struct{}, max),
+		stats:    ScannerStats{Signatures: make(map[string]uint64)},
+		shutdown: shutdown,
+	}
+}
+
+// count a blocked request of signature.
+func (b *scannerBlocker) count(signature string, tarpitted bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if tarpitted {
+		b.stats.Tarpitted++
+	} else {
+		b.stats.Dropped++
+	}
+	b.stats.Signatures[signature]++
+}
+
+func (b *scannerBlocker) snapshot() ScannerStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return ScannerStats{
+		Dropped:    b.stats.Dropped,
+		Tarpitted:  b.stats.Tarpitted,
+		Signatures: copyCounts(b.stats.Signatures),
+	}
+}
+
+// blockScanner drop or tarpit msg if it's a request of a scanner, true if blocked.
+func (s *SipStack) blockScanner(msg sip.Message) bool {
+	req, ok := msg.(sip.Request)
+	if s.scanners == nil || !ok {
+		return false
+	}
+	signature := s.scanners.filter.Match(req)
+	if len(signature) == 0 {
+		return false
+	}
+	if s.scanners.filter.Action != ScannerTarpit || req.IsAck() {
+		s.scanners.count(signature, false)
+		s.Log().Debugf("drop request of scanner %s from %s", signature, req.Source())
+		return true
+	}
+	select {
+	case s.scanners.tarpits <- struct{}{}:
+	default:
+		// Too many held, the scanner floods.
+		s.scanners.count(signature, false)
+		return true
+	}
+	s.scanners.count(signature, true)
+	s.Log().Debugf("tarpit request of scanner %s from %s", signature, req.Source())
+	go func() {
+		defer func() { <-s.scanners.tarpits }()
+		delay := s.scanners.filter.TarpitDelay
+		if delay <= 0 {
+			delay = DefaultTarpitDelay
+		}
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-s.scanners.shutdown:
+			return
+		}
+		status, reason := s.scanners.filter.TarpitStatus, s.scanners.filter.TarpitReason
+		if status == 0 {
+			status, reason = 403, "Forbidden"
+		}
+		res := sip.NewResponseFromRequest("", req, status, reason, "")
+		if err := s.Send(res); err != nil {
+			s.Log().Debugf("respond to scanner %s failed: %s", signature, err)
+		}
+	}()
+	return true
+}
+
+// ScannerStats the blocked requests of the scanners, ok is false when the ScannerFilter is disabled.
+func (s *SipStack) ScannerStats() (ScannerStats, bool) {
+	if s.scanners == nil {
+		return ScannerStats{}, false
+	}
+	return s.scanners.snapshot(), true
+}
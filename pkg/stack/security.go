@@ -0,0 +1,109 @@
+package stack
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// TransportSecurityPolicy the destinations the requests carrying sensitive data, a body (SDP keys, location,
+// messages) or credentials, must be sent to over TLS. The requests to a sips: To are too.
+type TransportSecurityPolicy struct {
+	// Domains requiring TLS, "example.com" matches the domain and its subdomains, "*" every destination.
+	// Matched against the host of the Request-URI and of the To.
+	Domains []string
+	// Upgrade send the requests over TLS instead of UDP/TCP when the stack listens on TLS, rather than refusing them.
+	Upgrade bool
+}
+
+// NewTransportSecurityPolicy .
+func NewTransportSecurityPolicy(upgrade bool, domains ...string) *TransportSecurityPolicy {
+	return &TransportSecurityPolicy{Domains: domains, Upgrade: upgrade}
+}
+
+// InsecureTransportError a request refused by the TransportSecurityPolicy.
+type InsecureTransportError struct {
+	Method    sip.RequestMethod
+	Target    string
+	Transport string
+}
+
+func (e *InsecureTransportError) Error() string {
+	return fmt.Sprintf("%s to %s refused over %s, TLS is required", e.Method, e.Target, e.Transport)
+}
+
+// Requires the request must be sent over TLS.
+func (p *TransportSecurityPolicy) Requires(request sip.Request) bool {
+	if request.Recipient().IsEncrypted() {
+		return true
+	}
+	hosts := []string{request.Recipient().Host()}
+	if to, ok := request.To(); ok {
+		if to.Address.IsEncrypted() {
+			return true
+		}
+		hosts = append(hosts, to.Address.Host())
+	}
+	if len(request.Body()) == 0 && len(request.GetHeaders("Authorization")) == 0 && len(request.GetHeaders("Proxy-Authorization")) == 0 {
+		return false
+	}
+	for _, domain := range p.Domains {
+		for _, host := range hosts {
+			host = strings.ToLower(host)
+			domain = strings.ToLower(domain)
+			if domain == "*" || host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsSecureTransport the transport is TLS or WSS.
+func IsSecureTransport(transport string) bool {
+	transport = strings.ToUpper(transport)
+	return transport == "TLS" || transport == "WSS"
+}
+
+// secureTransport send the requests to a sips: Request-URI, and the ones the TransportSecurityPolicy requires,
+// over TLS: upgrade them when possible, an InsecureTransportError otherwise.
+func (s *SipStack) secureTransport(request sip.Request) error {
+	policy := s.config.TransportSecurity
+	sips := request.Recipient().IsEncrypted()
+	if !sips && (policy == nil || !policy.Requires(request)) {
+		return nil
+	}
+	transport := strings.ToUpper(request.Transport())
+	if IsSecureTransport(transport) {
+		return nil
+	}
+	tlsPort, listening := s.listenPorts["TLS"]
+	upgrade := sips || policy.Upgrade
+	if !upgrade || !listening || (transport != "UDP" && transport != "TCP") {
+		return &InsecureTransportError{Method: request.Method(), Target: request.Recipient().String(), Transport: transport}
+	}
+
+	// The default port of the destination becomes the TLS one.
+	if host, port, err := net.SplitHostPort(request.Destination()); err == nil {
+		if port == strconv.Itoa(int(sip.DefaultPort(transport))) {
+			port = strconv.Itoa(int(sip.DefaultPort("TLS")))
+		}
+		request.SetDestination(net.JoinHostPort(host, port))
+	}
+	request.SetTransport("TLS")
+	// The requests of the dialog come back over TLS too.
+	if contact, ok := request.Contact(); ok {
+		if uri, ok := contact.Address.(*sip.SipUri); ok && s.IsLocalURI(uri) {
+			uri.FPort = tlsPort
+			if uri.FUriParams == nil {
+				uri.FUriParams = sip.NewParams()
+			}
+			uri.FUriParams.Add("transport", sip.String{Str: "tls"})
+		}
+	}
+	s.Log().Debugf("%s to %s upgraded from %s to TLS", request.Method(), request.Recipient(), transport)
+	return nil
+}
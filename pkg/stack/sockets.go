@@ -0,0 +1,169 @@
+package stack
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/log"
+)
+
+// sockets listening sockets of the stack, created with SO_REUSEPORT or taken over
+// from a previous process, see SipStackConfig.ReusePort and SipStackConfig.HandoffPath.
+type sockets struct {
+	reusePort bool
+	mutex     sync.Mutex
+	// inherited sockets received from the previous process, by socketKey.
+	inherited map[string]*os.File
+	// opened sockets, net.PacketConn or net.Listener, by socketKey.
+	opened map[string]interface{}
+	log    log.Logger
+	// tos of the signaling packets, 0 to leave them unmarked.
+	tos int
+	// relay of the UDP sockets handed off, nil without handoff.
+	relay *handoffRelay
+}
+
+func newSockets(reusePort bool, tos int, logger log.Logger) *sockets {
+	return &sockets{
+		reusePort: reusePort,
+		tos:       tos,
+		inherited: make(map[string]*os.File),
+		opened:    make(map[string]interface{}),
+		log:       logger,
+	}
+}
+
+func socketKey(network string, addr string) string {
+	return strings.ToLower(network) + "|" + addr
+}
+
+func (s *sockets) listenConfig() *net.ListenConfig {
+	lc := &net.ListenConfig{}
+	if s.reusePort || s.tos != 0 {
+		lc.Control = s.control
+	}
+	return lc
+}
+
+// dialer of the outbound connections, marked with the TOS.
+func (s *sockets) dialer() *net.Dialer {
+	dialer := &net.Dialer{}
+	if s.tos != 0 {
+		dialer.Control = utils.TOSControl(s.tos)
+	}
+	return dialer
+}
+
+func (s *sockets) control(network, address string, conn syscall.RawConn) error {
+	if s.reusePort {
+		if err := reusePortControl(network, address, conn); err != nil {
+			return err
+		}
+	}
+	if s.tos != 0 {
+		return utils.TOSControl(s.tos)(network, address, conn)
+	}
+	return nil
+}
+
+// takeInherited .
+func (s *sockets) takeInherited(key string) *os.File {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file, ok := s.inherited[key]
+	if ok {
+		delete(s.inherited, key)
+	}
+	return file
+}
+
+func (s *sockets) add(key string, socket interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.opened[key] = socket
+}
+
+func (s *sockets) listenPacket(network string, addr string) (net.PacketConn, error) {
+	key := socketKey(network, addr)
+	if file := s.takeInherited(key); file != nil {
+		conn, err := net.FilePacketConn(file)
+		file.Close()
+		if err == nil {
+			s.log.Infof("take over %s socket %s", network, addr)
+			s.add(key, conn)
+			return conn, nil
+		}
+		s.log.Warnf("inherited %s socket %s unusable: %v", network, addr, err)
+	}
+	conn, err := s.listenConfig().ListenPacket(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s.add(key, conn)
+	return conn, nil
+}
+
+func (s *sockets) listen(network string, addr string) (net.Listener, error) {
+	key := socketKey(network, addr)
+	if file := s.takeInherited(key); file != nil {
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err == nil {
+			s.log.Infof("take over %s socket %s", network, addr)
+			s.add(key, listener)
+			return listener, nil
+		}
+		s.log.Warnf("inherited %s socket %s unusable: %v", network, addr, err)
+	}
+	listener, err := s.listenConfig().Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s.add(key, listener)
+	return listener, nil
+}
+
+// closeListeners close the stream listeners of the process, handed off: the next process accepts the
+// connections, those accepted here are served until they close.
+func (s *sockets) closeListeners() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key, socket := range s.opened {
+		if listener, ok := socket.(net.Listener); ok {
+			listener.Close()
+			delete(s.opened, key)
+		}
+	}
+}
+
+// files duplicates of the opened sockets, for a handoff.
+func (s *sockets) files() ([]string, []*os.File, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := []string{}
+	files := []*os.File{}
+	for key, socket := range s.opened {
+		filer, ok := socket.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			continue
+		}
+		file, err := filer.File()
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, nil, fmt.Errorf("socket %s: %w", key, err)
+		}
+		keys = append(keys, key)
+		files = append(files, file)
+	}
+	return keys, files, nil
+}
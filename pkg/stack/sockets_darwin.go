@@ -0,0 +1,5 @@
+package stack
+
+import "syscall"
+
+const soReusePort = syscall.SO_REUSEPORT
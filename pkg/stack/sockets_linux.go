@@ -0,0 +1,4 @@
+package stack
+
+// soReusePort SO_REUSEPORT, not exported by syscall on linux.
+const soReusePort = 0xf
@@ -0,0 +1,21 @@
+// +build linux darwin
+
+package stack
+
+import (
+	"syscall"
+)
+
+func reusePortControl(network, address string, conn syscall.RawConn) error {
+	var serr error
+	err := conn.Control(func(fd uintptr) {
+		if serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); serr != nil {
+			return
+		}
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudwebrtc/go-sip-ua/pkg/auth"
@@ -24,32 +26,158 @@ import (
 const (
 	// DefaultUserAgent .
 	DefaultUserAgent = "Go SipStack/1.0.0"
+	// InstanceParam Via and Contact URI parameter identifying the instance of a cluster.
+	InstanceParam = "node"
 )
 
 // RequestHandler is a callback that will be called on the incoming request
 // of the certain method
 // tx argument can be nil for 2xx ACK request
+// With the workers or the dialog runtime, it runs on the worker of the Call-ID and must not
+// block: the backend lookups go to SipStack.Offload, the other waits on a goroutine of their own.
 type RequestHandler func(req sip.Request, tx sip.ServerTransaction)
 
 // RequiresChallengeHandler will check if each request requires 401/407 authentication.
 type RequiresChallengeHandler func(req sip.Request) bool
 
+// ChallengeDecision .
+type ChallengeDecision int
+
+const (
+	// ChallengeAllow the request is passed to the handler without authentication.
+	ChallengeAllow ChallengeDecision = iota
+	// ChallengeRequired the request must be authenticated with 401/407.
+	ChallengeRequired
+	// ChallengeReject the request is rejected with ChallengeResult.StatusCode.
+	ChallengeReject
+)
+
+// RequestSource network information of an incoming request.
+type RequestSource struct {
+	// Addr ip:port the request was received from.
+	Addr      string
+	Transport string
+}
+
+// ChallengeResult .
+type ChallengeResult struct {
+	Decision   ChallengeDecision
+	StatusCode sip.StatusCode
+	Reason     string
+}
+
+// ChallengePolicy decides whether each incoming request is challenged, allowed or rejected.
+type ChallengePolicy interface {
+	Challenge(req sip.Request, source RequestSource) ChallengeResult
+}
+
+// ChallengePolicyFunc allows the use of ordinary functions as ChallengePolicy.
+type ChallengePolicyFunc func(req sip.Request, source RequestSource) ChallengeResult
+
+// Challenge calls f(req, source).
+func (f ChallengePolicyFunc) Challenge(req sip.Request, source RequestSource) ChallengeResult {
+	return f(req, source)
+}
+
 // ServerAuthManager .
 type ServerAuthManager struct {
-	Authenticator     *auth.ServerAuthorizer
+	Authenticator *auth.ServerAuthorizer
+	// Policy takes precedence over RequiresChallenge when set.
+	Policy ChallengePolicy
+	// Deprecated: use Policy.
 	RequiresChallenge RequiresChallengeHandler
 }
 
+func (m *ServerAuthManager) challenge(req sip.Request) ChallengeResult {
+	if m.Policy != nil {
+		return m.Policy.Challenge(req, RequestSource{
+			Addr:      req.Source(),
+			Transport: req.Transport(),
+		})
+	}
+	if m.RequiresChallenge != nil && !m.RequiresChallenge(req) {
+		return ChallengeResult{Decision: ChallengeAllow}
+	}
+	return ChallengeResult{Decision: ChallengeRequired}
+}
+
 // SipStackConfig describes available options
 type SipStackConfig struct {
 	// Public IP address or domain name, if empty auto resolved IP will be used.
 	Host string
 	// Dns is an address of the public DNS server to use in SRV lookup.
-	Dns               string
-	Extensions        []string
+	Dns string
+	// Extensions option tags advertised in the Supported header, in addition to the enabled Features.
+	Extensions []string
+	// Features enabled SIP extensions.
+	Features Features
+	// Allow methods advertised in the Allow header, derived from the request handlers if nil.
+	Allow []sip.RequestMethod
+	// Accept content types advertised in the Accept header, DefaultAccept if nil.
+	Accept []string
+	// AllowEvents event packages advertised in the Allow-Events header of the OPTIONS responses.
+	AllowEvents []string
+	// Workers number of workers processing the inbound requests, requests of the same
+	// Call-ID are processed in order. 0 spawns a goroutine per request. The authentication
+	// of the requests runs off the workers, the request handlers on them must not block.
+	Workers int
+	// WorkerQueueSize requests queued per worker before answering 503, DefaultWorkerQueueSize if 0.
+	WorkerQueueSize int
+	// BackendWorkers number of workers authenticating the requests and running the jobs of Offload,
+	// which may wait for a backend (registry, LDAP, RADIUS), DefaultBackendWorkers if 0. Each queues
+	// WorkerQueueSize requests before answering 503.
+	BackendWorkers int
+	// DialogMailboxSize processes the inbound requests and the responses of the outgoing INVITEs of each
+	// Call-ID on a goroutine of its own instead of the workers, with a mailbox of this many messages
+	// before answering 503, 0 to disable.
+	DialogMailboxSize int
+	// ReusePort listen UDP/TCP/TLS/WS/WSS with SO_REUSEPORT, so that several processes can share the ports.
+	ReusePort bool
+	// HandoffPath unix socket path of the socket handoff, a new process takes over the listening
+	// sockets of the process serving it, then serves it for the next one. Empty to disable. The
+	// previous process stops reading the sockets, the new one relays to it the datagrams of its
+	// dialogs and transactions, by their InstanceID, generated if empty.
+	HandoffPath string
+	// ConnectionLimits limits of the inbound stream connections, disabled if zero.
+	ConnectionLimits ConnectionLimits
+	// InstanceID identifies this instance in a cluster, added as the node parameter of the Via of
+	// the requests and of the Contact of the dialogs, so that a load balancer can route the responses
+	// and the in-dialog requests back to it. Empty to disable.
+	InstanceID string
+	// WebSocketPath the only HTTP path upgraded to WS/WSS, e.g. "/sip" behind an ingress routing
+	// by path, the other requests are answered 404. Empty to upgrade any path.
+	WebSocketPath string
+	// TransportSecurity the destinations of the requests which must be sent over TLS, nil to disable.
+	TransportSecurity *TransportSecurityPolicy
+	// SignalingTOS TOS byte of the signaling packets, e.g. utils.DSCPToTOS(utils.DSCPSignaling), 0 to leave
+	// them unmarked.
+	SignalingTOS      int
 	MsgMapper         sip.MessageMapper
 	ServerAuthManager ServerAuthManager
-	UserAgent         string
+	// UserAgent of the requests, DefaultUserAgent if empty. A request keeps its own User-Agent, an empty
+	// one is removed, e.g. the one of a profile hiding it.
+	UserAgent string
+	// Server header of the responses, UserAgent if empty.
+	Server string
+	// HideUserAgent send neither User-Agent nor Server header, not to disclose the software and its version.
+	HideUserAgent bool
+	// HeaderOrder the headers of the messages sent first, in this order, e.g. DefaultHeaderOrder, the others
+	// follow in the order they were added. Empty to keep the order they were added in.
+	HeaderOrder []string
+	// ScannerFilter drops or tarpits the requests of the SIP scanners, e.g. NewScannerFilter(ScannerDrop),
+	// nil to disable.
+	ScannerFilter *ScannerFilter
+	// TLSVerify verifies the certificates of the TLS and WSS peers dialed, against the SIP domain dialed,
+	// RFC 5922. Nil to verify the TLS ones against the host dialed only, and not the WSS ones.
+	TLSVerify *TLSVerifyPolicy
+	// AcceptTelURI accept the tel: URIs of the messages received, rewritten in their SIP form at the stack
+	// host, sip:+12125550100@host;user=phone, RFC 3261 19.1.6. See DestinationRoute.TelURI to send them.
+	AcceptTelURI bool
+	// Resolver resolves the targets over TLS or HTTPS and checks DNSSEC, instead of Dns.
+	Resolver *ResolverConfig
+	// DestinationFunc the route of the requests sent to host, the SIP domain or the address dialed: the
+	// IP family it's dialed at and the local address they're sent from. Nil to route them all alike.
+	DestinationFunc func(host string) (DestinationRoute, bool)
 }
 
 // SipStack a golang SIP Stack
@@ -66,6 +194,19 @@ type SipStack struct {
 	requestHandlers       map[sip.RequestMethod]RequestHandler
 	handleConnectionError func(err *transport.ConnectionError)
 	extensions            []string
+	optionTagHandlers     map[string]OptionTagHandler
+	workers               jobQueue
+	backend               *WorkerPool
+	sockets               *sockets
+	limiter               *connLimiter
+	scanners              *scannerBlocker
+	resolver              *secureResolver
+	destinations          *destinationRouter
+	counters              *messageCounters
+	messageHandler        MessageHandler
+	handoffHandler        func()
+	handoffStateFunc      func() []byte
+	handoffState          []byte
 	invites               map[transaction.TxKey]sip.Request
 	invitesLock           *sync.RWMutex
 	authenticator         *ServerAuthManager
@@ -99,7 +240,14 @@ func NewSipStack(config *SipStackConfig) *SipStack {
 	}
 
 	var dnsResolver *net.Resolver
-	if config.Dns != "" {
+	var resolver *secureResolver
+	if config.Resolver != nil {
+		var err error
+		if resolver, err = newSecureResolver(*config.Resolver, logger); err != nil {
+			logger.Panicf("resolver failed: %s", err)
+		}
+		dnsResolver = &net.Resolver{PreferGo: true, Dial: resolver.dial}
+	} else if config.Dns != "" {
 		dnsResolver = &net.Resolver{
 			PreferGo: true,
 			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
@@ -111,10 +259,7 @@ func NewSipStack(config *SipStackConfig) *SipStack {
 		dnsResolver = net.DefaultResolver
 	}
 
-	var extensions []string
-	if config.Extensions != nil {
-		extensions = config.Extensions
-	}
+	extensions := mergeOptionTags(config.Features.OptionTags(), config.Extensions)
 
 	s := &SipStack{
 		config:          config,
@@ -127,23 +272,70 @@ func NewSipStack(config *SipStackConfig) *SipStack {
 		extensions:      extensions,
 		invites:         make(map[transaction.TxKey]sip.Request),
 		invitesLock:     new(sync.RWMutex),
+		resolver:        resolver,
+
+		optionTagHandlers: make(map[string]OptionTagHandler),
 	}
 
 	if config.ServerAuthManager.Authenticator != nil {
 		s.authenticator = &config.ServerAuthManager
 	}
 
+	backendWorkers := config.BackendWorkers
+	if backendWorkers <= 0 {
+		backendWorkers = DefaultBackendWorkers
+	}
+	s.backend = NewWorkerPool(backendWorkers, config.WorkerQueueSize)
+	if config.DialogMailboxSize > 0 {
+		s.workers = NewDialogRuntime(config.DialogMailboxSize)
+	} else if config.Workers > 0 {
+		s.workers = NewWorkerPool(config.Workers, config.WorkerQueueSize)
+	}
+
 	s.log = logger
+	s.counters = newMessageCounters()
+	s.sockets = newSockets(config.ReusePort, config.SignalingTOS, logger)
+	if config.ConnectionLimits.enabled() {
+		s.limiter = newConnLimiter(config.ConnectionLimits, logger)
+	}
+	if config.DestinationFunc != nil {
+		s.destinations = &destinationRouter{routes: config.DestinationFunc, resolver: dnsResolver}
+	}
+	if len(config.HandoffPath) > 0 {
+		// The datagrams of each process are told apart by its instance.
+		if len(config.InstanceID) == 0 {
+			config.InstanceID = util.RandString(8)
+		}
+		s.sockets.relay = newHandoffRelay(logger)
+		if err := s.takeOver(config.HandoffPath); err != nil {
+			logger.Infof("handoff: no sockets taken over from %s: %v", config.HandoffPath, err)
+		}
+	}
 	s.tp = transport.NewLayer(ip, dnsResolver, config.MsgMapper, utils.NewLogrusLogger(log.InfoLevel, "transport.Layer", nil))
+	// The stack protocols only for the options controlling the sockets, gosip ones otherwise.
+	if config.ReusePort || len(config.HandoffPath) > 0 || s.limiter != nil || len(config.WebSocketPath) > 0 || config.SignalingTOS != 0 || config.TLSVerify != nil || s.destinations != nil || config.AcceptTelURI {
+		s.useProtocols()
+	}
+	if config.ScannerFilter != nil {
+		s.scanners = newScannerBlocker(config.ScannerFilter, s.tp.Done())
+	}
 	sipTp := &sipTransport{
-		tpl: s.tp,
-		s:   s,
+		tpl:  s.tp,
+		s:    s,
+		msgs: make(chan sip.Message),
 	}
+	go s.filterMessages(s.tp.Messages(), sipTp.msgs)
 	s.tx = transaction.NewLayer(sipTp, utils.NewLogrusLogger(log.InfoLevel, "transaction.Layer", nil))
 
 	s.running.Set()
 	go s.serve()
 
+	if len(config.HandoffPath) > 0 {
+		if err := s.serveHandoff(config.HandoffPath); err != nil {
+			logger.Errorf("handoff: serve %s failed: %v", config.HandoffPath, err)
+		}
+	}
+
 	return s
 }
 
@@ -187,14 +379,13 @@ func (s *SipStack) serve() {
 			if !ok {
 				return
 			}
-			s.hwg.Add(1)
-			go s.handleRequest(tx.Origin(), tx)
+			atomic.AddUint64(&s.counters.serverTransactions, 1)
+			s.dispatch(tx.Origin(), tx)
 		case ack, ok := <-s.tx.Acks():
 			if !ok {
 				return
 			}
-			s.hwg.Add(1)
-			go s.handleRequest(ack, nil)
+			s.dispatch(ack, nil)
 		case response, ok := <-s.tx.Responses():
 			if !ok {
 				return
@@ -249,6 +440,11 @@ func (s *SipStack) handleRequest(req sip.Request, tx sip.ServerTransaction) {
 	handler, ok := s.requestHandlers[req.Method()]
 	s.hmu.RUnlock()
 
+	if !ok && req.Method() == sip.OPTIONS {
+		// Capability query, answered with the Allow/Accept/Supported headers.
+		handler, ok = s.RespondOptions, true
+	}
+
 	if !ok {
 		logger.Warnf("SIP request %v handler not found", req.Method())
 
@@ -275,20 +471,91 @@ func (s *SipStack) handleRequest(req sip.Request, tx sip.ServerTransaction) {
 		return
 	}
 
+	if s.rejectUnsupported(req, tx) {
+		return
+	}
+
 	if s.authenticator != nil {
 		authenticator := s.authenticator.Authenticator
-		requiresChallenge := s.authenticator.RequiresChallenge
-		if requiresChallenge(req) {
-			go func() {
+		result := s.authenticator.challenge(req)
+		switch result.Decision {
+		case ChallengeRequired:
+			// The authenticators may query a backend (registry, LDAP, RADIUS), the worker waits for none.
+			s.Offload(req, tx, func() {
 				if _, ok := authenticator.Authenticate(req, tx); ok {
-					handler(req, tx)
+					s.resume(req, tx, handler)
 				}
-			}()
+			})
+			return
+		case ChallengeReject:
+			logger.Infof("SIP request %v rejected by challenge policy: %d %s", req.Method(), result.StatusCode, result.Reason)
+			if tx != nil {
+				tx.Respond(sip.NewResponseFromRequest("", req, result.StatusCode, result.Reason, ""))
+			}
 			return
 		}
 	}
 
-	go handler(req, tx)
+	s.run(req, func() { handler(req, tx) })
+}
+
+// run job on the current worker or actor, or on a new goroutine when both are disabled.
+func (s *SipStack) run(req sip.Request, job func()) {
+	if s.workers == nil {
+		go job()
+		return
+	}
+	start := time.Now()
+	job()
+	if elapsed := time.Since(start); elapsed > slowHandlerTime {
+		s.Log().Warnf("SIP request %v handler blocked its worker for %v", req.Method(), elapsed)
+	}
+}
+
+// Offload run job, which may wait for a backend, for req on the backend worker of its Call-ID, off the
+// request workers, or answer 503 if its queue is full.
+func (s *SipStack) Offload(req sip.Request, tx sip.ServerTransaction, job func()) {
+	key := ""
+	if callID, ok := req.CallID(); ok {
+		key = callID.Value()
+	}
+	s.hwg.Add(1)
+	if s.backend.Submit(key, func() {
+		defer s.hwg.Done()
+		job()
+	}) {
+		return
+	}
+	s.hwg.Done()
+	s.Log().Warnf("backend queue full, SIP request %v rejected", req.Method())
+	if tx != nil {
+		tx.Respond(sip.NewResponseFromRequest("", req, 503, "Service Unavailable", ""))
+	}
+}
+
+// resume process the authenticated req on the worker or the actor of its Call-ID, back in order
+// with the requests received since, or answer 503 if its queue is full.
+func (s *SipStack) resume(req sip.Request, tx sip.ServerTransaction, handler RequestHandler) {
+	if s.workers == nil {
+		handler(req, tx)
+		return
+	}
+	key := ""
+	if callID, ok := req.CallID(); ok {
+		key = callID.Value()
+	}
+	s.hwg.Add(1)
+	if s.workers.Submit(key, func() {
+		defer s.hwg.Done()
+		s.run(req, func() { handler(req, tx) })
+	}) {
+		return
+	}
+	s.hwg.Done()
+	s.Log().Warnf("worker queue full, authenticated SIP request %v rejected", req.Method())
+	if tx != nil {
+		tx.Respond(sip.NewResponseFromRequest("", req, 503, "Service Unavailable", ""))
+	}
 }
 
 //Request Send SIP message
@@ -296,7 +563,21 @@ func (s *SipStack) Request(req sip.Request) (sip.ClientTransaction, error) {
 	if !s.running.IsSet() {
 		return nil, fmt.Errorf("can not send through stopped server")
 	}
-	return s.tx.Request(s.prepareRequest(req))
+	if err := s.secureTransport(req); err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&s.counters.clientTransactions, 1)
+	req = s.prepareRequest(req)
+	if !req.IsInvite() {
+		return s.tx.Request(req)
+	}
+	cancel := &cancelHeaders{}
+	req.WithFields(log.Fields{cancelHeadersField: cancel})
+	tx, err := s.tx.Request(req)
+	if err != nil {
+		return nil, err
+	}
+	return &clientTransaction{ClientTransaction: tx, cancel: cancel}, nil
 }
 
 func (s *SipStack) GetNetworkInfo(protocol string) *transport.Target {
@@ -366,7 +647,8 @@ func (s *SipStack) CancelRequest(request sip.Request, response sip.Response) {
 }
 
 func (s *SipStack) prepareRequest(req sip.Request) sip.Request {
-	if viaHop, ok := req.ViaHop(); ok {
+	viaHop, ok := req.ViaHop()
+	if ok {
 		if viaHop.Params == nil {
 			viaHop.Params = sip.NewParams()
 		}
@@ -385,12 +667,29 @@ func (s *SipStack) prepareRequest(req sip.Request) sip.Request {
 			viaHop,
 		}, "Route")
 	}
+	if len(s.config.InstanceID) > 0 && !viaHop.Params.Has(InstanceParam) {
+		viaHop.Params.Add(InstanceParam, sip.String{Str: s.config.InstanceID})
+	}
+	if req.IsCancel() {
+		// The CANCEL built by the client transaction has the fields of the INVITE.
+		if cancel, ok := req.Fields()[cancelHeadersField].(*cancelHeaders); ok {
+			for _, header := range cancel.get() {
+				req.AppendHeader(header)
+			}
+		}
+	}
 
 	s.appendAutoHeaders(req)
+	s.orderHeaders(req)
 
 	return req
 }
 
+// InstanceID .
+func (s *SipStack) InstanceID() string {
+	return s.config.InstanceID
+}
+
 // Respond .
 func (s *SipStack) Respond(res sip.Response) (sip.ServerTransaction, error) {
 	if !s.running.IsSet() {
@@ -427,16 +726,40 @@ func (s *SipStack) Send(msg sip.Message) error {
 
 	switch m := msg.(type) {
 	case sip.Request:
+		if err := s.secureTransport(m); err != nil {
+			return err
+		}
 		msg = s.prepareRequest(m)
 	case sip.Response:
 		msg = s.prepareResponse(m)
 	}
+	if s.destinations.telURI(msg) {
+		return s.sendTel(msg)
+	}
 
+	s.observe(msg, false)
 	return s.tp.Send(msg)
 }
 
+// sendTel send msg with the URIs of its telephone numbers in their tel: form. The sent-by the transport
+// layer sets in the Via of the copy sent is kept by msg, for its retransmissions and CANCEL.
+func (s *SipStack) sendTel(msg sip.Message) error {
+	tel := telMessage(msg)
+	s.observe(tel, false)
+	err := s.tp.Send(tel)
+	if req, ok := msg.(sip.Request); ok && tel != msg {
+		if viaHop, ok := req.ViaHop(); ok {
+			if sent, ok := tel.ViaHop(); ok {
+				viaHop.Transport, viaHop.Host, viaHop.Port = sent.Transport, sent.Host, sent.Port
+			}
+		}
+	}
+	return err
+}
+
 func (s *SipStack) prepareResponse(res sip.Response) sip.Response {
 	s.appendAutoHeaders(res)
+	s.orderHeaders(res)
 	return res
 }
 
@@ -452,8 +775,19 @@ func (s *SipStack) Shutdown() {
 	// stop transport layer
 	s.tp.Cancel()
 	<-s.tp.Done()
+	s.releaseProtocols()
 	// wait for handlers
 	s.hwg.Wait()
+	if s.workers != nil {
+		s.workers.Stop()
+	}
+	s.backend.Stop()
+	if s.limiter != nil {
+		s.limiter.stop()
+	}
+	if s.sockets.relay != nil {
+		s.sockets.relay.close()
+	}
 }
 
 // OnRequest registers new request callback
@@ -465,21 +799,53 @@ func (s *SipStack) OnRequest(method sip.RequestMethod, handler RequestHandler) e
 	return nil
 }
 
+// OnHandoff set the handler called when the listening sockets have been handed off
+// to a new process, the application should drain its calls then shutdown.
+func (s *SipStack) OnHandoff(handler func()) {
+	s.hmu.Lock()
+	s.handoffHandler = handler
+	s.hmu.Unlock()
+}
+
+// SetHandoffState set the state sent with the sockets to the next process, e.g. the registrations of
+// the application, read by the next process with HandoffState.
+func (s *SipStack) SetHandoffState(state func() []byte) {
+	s.hmu.Lock()
+	s.handoffStateFunc = state
+	s.hmu.Unlock()
+}
+
+// HandoffState the state of the process the sockets were taken over from, nil if none.
+func (s *SipStack) HandoffState() []byte {
+	s.hmu.RLock()
+	defer s.hmu.RUnlock()
+	return s.handoffState
+}
+
+// OnMessage set the handler called with every SIP message received or sent, before the
+// validation of the received ones, it must not block.
+func (s *SipStack) OnMessage(handler MessageHandler) {
+	s.hmu.Lock()
+	s.messageHandler = handler
+	s.hmu.Unlock()
+}
+
 func (s *SipStack) OnConnectionError(handler func(err *transport.ConnectionError)) {
 	s.hmu.Lock()
 	s.handleConnectionError = handler
 	s.hmu.Unlock()
 }
 
-func (s *SipStack) appendAutoHeaders(msg sip.Message) {
-	autoAppendMethods := map[sip.RequestMethod]bool{
-		sip.INVITE:   true,
-		sip.REGISTER: true,
-		sip.OPTIONS:  true,
-		sip.REFER:    true,
-		sip.NOTIFY:   true,
-	}
+// autoAppendMethods requests and final responses of these methods advertise Allow/Supported.
+var autoAppendMethods = map[sip.RequestMethod]bool{
+	sip.INVITE:   true,
+	sip.REGISTER: true,
+	sip.OPTIONS:  true,
+	sip.REFER:    true,
+	sip.NOTIFY:   true,
+}
 
+func (s *SipStack) appendAutoHeaders(msg sip.Message) {
 	var msgMethod sip.RequestMethod
 	switch m := msg.(type) {
 	case sip.Request:
@@ -504,66 +870,92 @@ func (s *SipStack) appendAutoHeaders(msg sip.Message) {
 			hdrs = msg.GetHeaders("Supported")
 			if len(hdrs) == 0 {
 				msg.AppendHeader(&sip.SupportedHeader{
-					Options: s.extensions,
+					Options: s.Supported(),
 				})
 			}
-		}
-	}
 
-	if hdrs := msg.GetHeaders("User-Agent"); len(hdrs) == 0 {
-		userAgent := DefaultUserAgent
-		if len(s.config.UserAgent) > 0 {
-			userAgent = s.config.UserAgent
+			if msgMethod == sip.INVITE || msgMethod == sip.OPTIONS {
+				if hdrs = msg.GetHeaders("Accept"); len(hdrs) == 0 {
+					accept := sip.Accept(strings.Join(s.Accept(), ", "))
+					msg.AppendHeader(&accept)
+				}
+			}
+
+			if _, ok := msg.(sip.Response); ok && msgMethod == sip.OPTIONS && len(s.config.AllowEvents) > 0 {
+				if hdrs = msg.GetHeaders("Allow-Events"); len(hdrs) == 0 {
+					msg.AppendHeader(&sip.GenericHeader{
+						HeaderName: "Allow-Events",
+						Contents:   strings.Join(s.config.AllowEvents, ", "),
+					})
+				}
+			}
 		}
-		userAgentHeader := sip.UserAgentHeader(userAgent)
-		msg.AppendHeader(&userAgentHeader)
-	} else if len(s.config.UserAgent) > 0 {
-		msg.RemoveHeader("User-Agent")
-		userAgentHeader := sip.UserAgentHeader(s.config.UserAgent)
-		msg.AppendHeader(&userAgentHeader)
 	}
 
+	s.appendAgentHeader(msg)
+
 	if hdrs := msg.GetHeaders("Content-Length"); len(hdrs) == 0 {
 		msg.SetBody(msg.Body(), true)
 	}
 }
 
+// allowOrder order of the well-known methods in the Allow header.
+var allowOrder = []sip.RequestMethod{
+	sip.INVITE, sip.ACK, sip.BYE, sip.CANCEL, sip.OPTIONS, sip.REGISTER, sip.UPDATE,
+	sip.INFO, sip.PRACK, sip.REFER, sip.SUBSCRIBE, sip.NOTIFY, sip.MESSAGE, sip.PUBLISH,
+}
+
+// getAllowedMethods the methods actually handled: ACK and OPTIONS, answered by the stack, and the
+// methods with a request handler.
 func (s *SipStack) getAllowedMethods() []sip.RequestMethod {
-	methods := []sip.RequestMethod{
-		sip.INVITE,
-		sip.ACK,
-		sip.BYE,
-		sip.CANCEL,
-		sip.INFO,
-		sip.OPTIONS,
-	}
-	added := map[sip.RequestMethod]bool{
-		sip.INVITE:  true,
+	if s.config.Allow != nil {
+		return s.config.Allow
+	}
+	handled := map[sip.RequestMethod]bool{
 		sip.ACK:     true,
-		sip.BYE:     true,
-		sip.CANCEL:  true,
-		sip.INFO:    true,
 		sip.OPTIONS: true,
 	}
-
 	s.hmu.RLock()
 	for method := range s.requestHandlers {
-		if _, ok := added[method]; !ok {
-			methods = append(methods, method)
-		}
+		handled[method] = true
 	}
 	s.hmu.RUnlock()
 
+	methods := []sip.RequestMethod{}
+	for _, method := range allowOrder {
+		if handled[method] {
+			methods = append(methods, method)
+			delete(handled, method)
+		}
+	}
+	others := []string{}
+	for method := range handled {
+		others = append(others, string(method))
+	}
+	sort.Strings(others)
+	for _, method := range others {
+		methods = append(methods, sip.RequestMethod(method))
+	}
 	return methods
 }
 
+// RespondOptions answer an OPTIONS with 200 and the capabilities of the stack, for the applications
+// handling OPTIONS themselves.
+func (s *SipStack) RespondOptions(req sip.Request, tx sip.ServerTransaction) {
+	res := sip.NewResponseFromRequest("", req, 200, "OK", "")
+	if _, err := s.Respond(res); err != nil {
+		s.Log().Errorf("respond '200 OK' to OPTIONS failed: %s", err)
+	}
+}
+
 type sipTransport struct {
-	tpl transport.Layer
-	s   *SipStack
+	tpl  transport.Layer
+	s    *SipStack
+	msgs chan sip.Message
 }
 
 func (tp *sipTransport) Messages() <-chan sip.Message {
-	return tp.tpl.Messages()
+	return tp.msgs
 }
 
 func (tp *sipTransport) Send(msg sip.Message) error {
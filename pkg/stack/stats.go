@@ -0,0 +1,102 @@
+package stack
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// MessageStats SIP message and transaction counters since start.
+type MessageStats struct {
+	// RequestsIn/RequestsOut by method, ResponsesIn/ResponsesOut by status code.
+	RequestsIn   map[string]uint64 `json:"requests_in"`
+	RequestsOut  map[string]uint64 `json:"requests_out"`
+	ResponsesIn  map[string]uint64 `json:"responses_in"`
+	ResponsesOut map[string]uint64 `json:"responses_out"`
+	// ServerTransactions/ClientTransactions transactions created.
+	ServerTransactions uint64 `json:"server_transactions"`
+	ClientTransactions uint64 `json:"client_transactions"`
+}
+
+// messageCounters the counters of MessageStats, the transactions are counted atomically.
+type messageCounters struct {
+	mutex              sync.Mutex
+	requestsIn         map[string]uint64
+	requestsOut        map[string]uint64
+	responsesIn        map[string]uint64
+	responsesOut       map[string]uint64
+	serverTransactions uint64
+	clientTransactions uint64
+}
+
+func newMessageCounters() *messageCounters {
+	return &messageCounters{
+		requestsIn:   make(map[string]uint64),
+		requestsOut:  make(map[string]uint64),
+		responsesIn:  make(map[string]uint64),
+		responsesOut: make(map[string]uint64),
+	}
+}
+
+// count msg in the inbound or outbound tallies.
+func (c *messageCounters) count(msg sip.Message, inbound bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch m := msg.(type) {
+	case sip.Request:
+		if inbound {
+			c.requestsIn[string(m.Method())]++
+		} else {
+			c.requestsOut[string(m.Method())]++
+		}
+	case sip.Response:
+		code := strconv.Itoa(int(m.StatusCode()))
+		if inbound {
+			c.responsesIn[code]++
+		} else {
+			c.responsesOut[code]++
+		}
+	}
+}
+
+func (c *messageCounters) snapshot() MessageStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return MessageStats{
+		RequestsIn:         copyCounts(c.requestsIn),
+		RequestsOut:        copyCounts(c.requestsOut),
+		ResponsesIn:        copyCounts(c.responsesIn),
+		ResponsesOut:       copyCounts(c.responsesOut),
+		ServerTransactions: atomic.LoadUint64(&c.serverTransactions),
+		ClientTransactions: atomic.LoadUint64(&c.clientTransactions),
+	}
+}
+
+func copyCounts(counts map[string]uint64) map[string]uint64 {
+	ret := make(map[string]uint64, len(counts))
+	for key, count := range counts {
+		ret[key] = count
+	}
+	return ret
+}
+
+// MessageHandler is a callback called with the SIP messages received (inbound) and sent.
+type MessageHandler func(msg sip.Message, inbound bool)
+
+// observe count msg and pass it to the message handler.
+func (s *SipStack) observe(msg sip.Message, inbound bool) {
+	s.counters.count(msg, inbound)
+	s.hmu.RLock()
+	handler := s.messageHandler
+	s.hmu.RUnlock()
+	if handler != nil {
+		handler(msg, inbound)
+	}
+}
+
+// MessageStats .
+func (s *SipStack) MessageStats() MessageStats {
+	return s.counters.snapshot()
+}
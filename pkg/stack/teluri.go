@@ -0,0 +1,390 @@
+package stack
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+var (
+	// telephoneNumber the number of a tel: URI, or the user of its SIP form, with its parameters, RFC 3966.
+	telephoneNumber = regexp.MustCompile(`^(\+?[0-9*#().\-]*[0-9][0-9*#().\-]*)((?:;[^;]+)*)$`)
+	// visualSeparators of the telephone numbers, meaningless, RFC 3966 5.1.1.
+	visualSeparators = strings.NewReplacer("-", "", ".", "", "(", "", ")", "")
+	// telBuffers the read buffers of the telConns, large enough for a WS message read at once.
+	telBuffers = sync.Pool{New: func() interface{} { return make([]byte, 65535) }}
+)
+
+// TelToSIP the SIP form at host of the tel: URI uri, RFC 3261 19.1.6: tel:+1-212-555-0100;phone-context=x
+// becomes sip:+12125550100;phone-context=x@host;user=phone, the visual separators removed. False if uri
+// isn't a tel: URI.
+func TelToSIP(uri string, host string) (string, bool) {
+	if len(uri) < 5 || !strings.EqualFold(uri[:4], "tel:") {
+		return "", false
+	}
+	match := telephoneNumber.FindStringSubmatch(uri[4:])
+	if match == nil {
+		return "", false
+	}
+	return "sip:" + visualSeparators.Replace(match[1]) + match[2] + "@" + host + ";user=phone", true
+}
+
+// TelURI a tel: URI, RFC 3966, the form a telephone number is sent in to the destinations routed with
+// DestinationRoute.TelURI. It keeps the host and the port of the SIP URI it replaces, the ones the request
+// is routed to, which are not part of the URI.
+type TelURI struct {
+	// Number the global (+ prefixed) or local number, without visual separators.
+	Number string
+	// Params the parameters of the number, e.g. phone-context, nil if none.
+	Params sip.Params
+	host   string
+	port   *sip.Port
+}
+
+// SIPToTel the tel: form of the SIP URI uri of a telephone number: sip:+1-212-555-0100@host;user=phone becomes
+// tel:+12125550100, the local numbers get the phone-context of their host. The URI parameters and headers
+// are dropped. False if the user of uri isn't a telephone number.
+func SIPToTel(uri sip.Uri) (*TelURI, bool) {
+	sipURI, ok := uri.(*sip.SipUri)
+	if !ok || sipURI.FUser == nil {
+		return nil, false
+	}
+	match := telephoneNumber.FindStringSubmatch(sipURI.FUser.String())
+	if match == nil {
+		return nil, false
+	}
+	tel := &TelURI{Number: visualSeparators.Replace(match[1]), host: sipURI.FHost, port: sipURI.FPort}
+	for _, param := range strings.Split(match[2], ";")[1:] {
+		if tel.Params == nil {
+			tel.Params = sip.NewParams()
+		}
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			tel.Params.Add(strings.ToLower(kv[0]), sip.String{Str: kv[1]})
+		} else {
+			tel.Params.Add(strings.ToLower(kv[0]), nil)
+		}
+	}
+	if !strings.HasPrefix(tel.Number, "+") && (tel.Params == nil || !tel.Params.Has("phone-context")) {
+		if tel.Params == nil {
+			tel.Params = sip.NewParams()
+		}
+		tel.Params.Add("phone-context", sip.String{Str: strings.Trim(sipURI.FHost, "[]")})
+	}
+	return tel, true
+}
+
+func (uri *TelURI) String() string {
+	if uri.Params == nil || uri.Params.Length() == 0 {
+		return "tel:" + uri.Number
+	}
+	return "tel:" + uri.Number + ";" + uri.Params.ToString(';')
+}
+
+// Equals the tel: URIs of the same number and parameters, RFC 3966 4.
+func (uri *TelURI) Equals(other interface{}) bool {
+	otherURI, ok := other.(*TelURI)
+	if !ok || otherURI.Number != uri.Number {
+		return false
+	}
+	if uri.Params == nil || otherURI.Params == nil {
+		return (uri.Params == nil || uri.Params.Length() == 0) == (otherURI.Params == nil || otherURI.Params.Length() == 0)
+	}
+	return uri.Params.Equals(otherURI.Params)
+}
+
+func (uri *TelURI) Clone() sip.Uri {
+	clone := *uri
+	if uri.Params != nil {
+		clone.Params = uri.Params.Clone()
+	}
+	if uri.port != nil {
+		port := *uri.port
+		clone.port = &port
+	}
+	return &clone
+}
+
+func (uri *TelURI) IsEncrypted() bool { return false }
+
+func (uri *TelURI) SetEncrypted(flag bool) {}
+
+// User the number.
+func (uri *TelURI) User() sip.MaybeString { return sip.String{Str: uri.Number} }
+
+func (uri *TelURI) SetUser(user sip.MaybeString) {
+	if user != nil {
+		uri.Number = user.String()
+	}
+}
+
+func (uri *TelURI) Password() sip.MaybeString { return nil }
+
+func (uri *TelURI) SetPassword(pass sip.MaybeString) {}
+
+// Host the host the number is routed to.
+func (uri *TelURI) Host() string { return uri.host }
+
+func (uri *TelURI) SetHost(host string) { uri.host = host }
+
+func (uri *TelURI) Port() *sip.Port { return uri.port }
+
+func (uri *TelURI) SetPort(port *sip.Port) { uri.port = port }
+
+func (uri *TelURI) UriParams() sip.Params { return uri.Params }
+
+func (uri *TelURI) SetUriParams(params sip.Params) { uri.Params = params }
+
+func (uri *TelURI) Headers() sip.Params { return nil }
+
+func (uri *TelURI) SetHeaders(params sip.Params) {}
+
+func (uri *TelURI) IsWildcard() bool { return false }
+
+// telMessage a copy of msg with the SIP URIs of telephone numbers of its Request-URI, To and From in their
+// tel: form, msg if none. The copy keeps the destination of msg.
+func telMessage(msg sip.Message) sip.Message {
+	req, isRequest := msg.(sip.Request)
+	var recipient *TelURI
+	toTel := false
+	if isRequest {
+		recipient, toTel = SIPToTel(req.Recipient())
+	}
+	to, _ := msg.To()
+	from, _ := msg.From()
+	if !toTel && (to == nil || !isTelephoneNumber(to.Address)) && (from == nil || !isTelephoneNumber(from.Address)) {
+		return msg
+	}
+	var tel sip.Message
+	if isRequest {
+		telReq := sip.CopyRequest(req)
+		if toTel {
+			telReq.SetRecipient(recipient)
+		}
+		tel = telReq
+	} else {
+		tel = sip.CopyResponse(msg.(sip.Response))
+	}
+	if to, ok := tel.To(); ok {
+		if address, ok := SIPToTel(to.Address); ok {
+			to.Address = address
+		}
+	}
+	if from, ok := tel.From(); ok {
+		if address, ok := SIPToTel(from.Address); ok {
+			from.Address = address
+		}
+	}
+	return tel
+}
+
+// isTelephoneNumber the user of the SIP URI uri is a telephone number.
+func isTelephoneNumber(uri sip.Uri) bool {
+	_, ok := SIPToTel(uri)
+	return ok
+}
+
+// telToSIPLine rewrite the tel: URI of line, the request line or a To or From header, in its SIP form at
+// host, the tel: form of the telephone numbers sent, the other lines as is. The parameters of a URI outside
+// angle brackets belong to the header, but in the request line.
+func telToSIPLine(line []byte, host string, start bool) []byte {
+	lower := bytes.ToLower(line)
+	if !bytes.Contains(lower, []byte("tel:")) {
+		return line
+	}
+	if start {
+		parts := bytes.SplitN(line, []byte(" "), 3)
+		if len(parts) != 3 || !bytes.HasPrefix(parts[2], []byte("SIP/")) {
+			// A status line.
+			return line
+		}
+		uri, ok := TelToSIP(string(parts[1]), host)
+		if !ok {
+			return line
+		}
+		return []byte(string(parts[0]) + " " + uri + " " + string(parts[2]))
+	}
+	colon := bytes.IndexByte(line, ':')
+	if colon < 0 {
+		return line
+	}
+	switch strings.ToLower(strings.TrimSpace(string(line[:colon]))) {
+	case "to", "t", "from", "f":
+	default:
+		return line
+	}
+	j := bytes.Index(lower[colon+1:], []byte("tel:"))
+	if j < 0 {
+		return line
+	}
+	j += colon + 1
+	if !bytes.ContainsAny(line[j-1:j], " \t<:") {
+		// e.g. a display name "hotel:"
+		return line
+	}
+	// An addr-spec, its parameters belong to the header, its SIP form is put between angle brackets.
+	bare := line[j-1] != '<'
+	stop := " \t\r\n>,?"
+	if bare {
+		stop += ";"
+	}
+	end := j + 4
+	for end < len(line) && !bytes.ContainsAny(line[end:end+1], stop) {
+		end++
+	}
+	uri, ok := TelToSIP(string(line[j:end]), host)
+	if !ok {
+		return line
+	}
+	if bare {
+		uri = "<" + uri + ">"
+	}
+	return []byte(string(line[:j]) + uri + string(line[end:]))
+}
+
+// telToSIPMessage rewrite the tel: URIs of the request line, the To and the From of the message data in their
+// SIP form at host, the body is left as is.
+func telToSIPMessage(data []byte, host string) []byte {
+	if !bytes.Contains(bytes.ToLower(data), []byte("tel:")) {
+		return data
+	}
+	var out bytes.Buffer
+	start := true
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			i = len(data) - 1
+		}
+		line := data[:i+1]
+		data = data[i+1:]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			if start {
+				// A keep-alive before the message.
+				out.Write(line)
+				continue
+			}
+			// The body.
+			out.Write(line)
+			out.Write(data)
+			break
+		}
+		out.Write(telToSIPLine(line, host, start))
+		start = false
+	}
+	return out.Bytes()
+}
+
+// telPacketConn rewrites the tel: URIs of the datagrams read in their SIP form at host. The gosip parser only
+// parses SIP URIs, the messages with a tel: URI are rewritten before it reads them.
+type telPacketConn struct {
+	*net.UDPConn
+	host string
+}
+
+func (c *telPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.UDPConn.ReadFrom(b)
+	if err != nil || n == 0 {
+		return n, addr, err
+	}
+	data := telToSIPMessage(b[:n], c.host)
+	if len(data) > len(b) {
+		// Not truncated: left to the parser, which rejects it.
+		return n, addr, nil
+	}
+	return copy(b, data), addr, nil
+}
+
+// telConn rewrites the tel: URIs of the messages read from a stream connection in their SIP form at host,
+// framing them by their Content-Length.
+type telConn struct {
+	net.Conn
+	host string
+	// raw read and not framed yet, out framed and not returned yet.
+	raw []byte
+	out []byte
+	// headers within the headers of a message, length its Content-Length, body the bytes of the current
+	// body left to pass as is.
+	headers bool
+	length  int
+	body    int
+}
+
+func newTelConn(conn net.Conn, host string) *telConn {
+	return &telConn{Conn: conn, host: host}
+}
+
+func (c *telConn) Read(b []byte) (int, error) {
+	for len(c.out) == 0 {
+		buf := telBuffers.Get().([]byte)
+		n, err := c.Conn.Read(buf)
+		c.raw = append(c.raw, buf[:n]...)
+		telBuffers.Put(buf)
+		c.frame()
+		if err != nil && len(c.out) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(b, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// frame move the complete lines of the headers, rewritten, and the body read to out.
+func (c *telConn) frame() {
+	for len(c.raw) > 0 {
+		if c.body > 0 {
+			n := c.body
+			if n > len(c.raw) {
+				n = len(c.raw)
+			}
+			c.out = append(c.out, c.raw[:n]...)
+			c.raw = c.raw[n:]
+			c.body -= n
+			continue
+		}
+		i := bytes.IndexByte(c.raw, '\n')
+		if i < 0 {
+			if len(c.raw) > 65535 {
+				// Not SIP, left to the parser.
+				c.out = append(c.out, c.raw...)
+				c.raw = nil
+			}
+			return
+		}
+		line := c.raw[:i+1]
+		c.raw = c.raw[i+1:]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			// The end of the headers, else a keep-alive between the messages.
+			if c.headers {
+				c.headers, c.body, c.length = false, c.length, 0
+			}
+			c.out = append(c.out, line...)
+			continue
+		}
+		start := !c.headers
+		c.headers = true
+		if !start {
+			c.contentLength(line)
+		}
+		c.out = append(c.out, telToSIPLine(line, c.host, start)...)
+	}
+}
+
+// contentLength record the Content-Length of the header line.
+func (c *telConn) contentLength(line []byte) {
+	colon := bytes.IndexByte(line, ':')
+	if colon < 0 {
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(string(line[:colon]))) {
+	case "content-length", "l":
+		if length, err := strconv.Atoi(strings.TrimSpace(string(line[colon+1:]))); err == nil && length > 0 {
+			c.length = length
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package stack
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+func TestSIPToTel(t *testing.T) {
+	for _, test := range []struct {
+		uri, tel string
+		ok       bool
+	}{
+		{"sip:+1-212-555-0100@trunk.example.com;user=phone", "tel:+12125550100", true},
+		{"sip:5550100@trunk.example.com", "tel:5550100;phone-context=trunk.example.com", true},
+		{"sip:5550100;phone-context=+1212@trunk.example.com", "tel:5550100;phone-context=+1212", true},
+		{"sip:alice@example.com", "", false},
+	} {
+		uri, err := parser.ParseSipUri(test.uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tel, ok := SIPToTel(&uri)
+		if ok != test.ok || (ok && tel.String() != test.tel) {
+			t.Errorf("SIPToTel(%s) = %v, %v, want %s, %v", test.uri, tel, ok, test.tel, test.ok)
+		}
+		if ok && tel.Host() != "trunk.example.com" {
+			t.Errorf("SIPToTel(%s) host = %s", test.uri, tel.Host())
+		}
+	}
+}
+
+func TestTelMessage(t *testing.T) {
+	msg, err := parser.ParseMessage([]byte("INVITE sip:+12125550100@trunk.example.com;user=phone SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 192.0.2.1:5060;branch=z9hG4bK776asdhds\r\n"+
+		"From: <sip:+12125550199@example.com;user=phone>;tag=1928301774\r\n"+
+		"To: <sip:+12125550100@trunk.example.com;user=phone>\r\n"+
+		"Contact: <sip:+12125550199@192.0.2.1:5060>\r\n"+
+		"Call-ID: a84b4c76e66710\r\n"+
+		"CSeq: 1 INVITE\r\n"+
+		"Content-Length: 0\r\n\r\n"), fuzzLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := msg.(sip.Request)
+	req.SetDestination("trunk.example.com:5060")
+	tel := telMessage(req).(sip.Request)
+	if tel == req {
+		t.Fatal("request not copied")
+	}
+	to, _ := tel.To()
+	from, _ := tel.From()
+	contact, _ := tel.Contact()
+	for name, got := range map[string]string{
+		"Request-URI": tel.Recipient().String(),
+		"To":          to.Address.String(),
+		"From":        from.Address.String(),
+		"Contact":     contact.Address.String(),
+		"destination": tel.Destination(),
+	} {
+		want := map[string]string{
+			"Request-URI": "tel:+12125550100",
+			"To":          "tel:+12125550100",
+			"From":        "tel:+12125550199",
+			"Contact":     "sip:+12125550199@192.0.2.1:5060",
+			"destination": "trunk.example.com:5060",
+		}[name]
+		if got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+	if req.Recipient().String() != "sip:+12125550100@trunk.example.com;user=phone" {
+		t.Errorf("request changed: %s", req.Recipient())
+	}
+
+	// The tel: URIs sent are parsed back in their SIP form.
+	back, err := parser.ParseMessage(telToSIPMessage([]byte(tel.String()), "b2bua.example.com"), fuzzLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backTo, _ := back.(sip.Request).To()
+	if got := back.(sip.Request).Recipient().String(); got != "sip:+12125550100@b2bua.example.com;user=phone" {
+		t.Errorf("Request-URI parsed back = %s", got)
+	}
+	if got := backTo.Address.String(); got != "sip:+12125550100@b2bua.example.com;user=phone" {
+		t.Errorf("To parsed back = %s", got)
+	}
+}
+
+func TestTelToSIPLine(t *testing.T) {
+	for _, test := range []struct {
+		line, want string
+		start      bool
+	}{
+		{"INVITE tel:+1-212-555-0100 SIP/2.0", "INVITE sip:+12125550100@host;user=phone SIP/2.0", true},
+		{"To: tel:+12125550100;tag=a", "To: <sip:+12125550100@host;user=phone>;tag=a", false},
+		{"f: \"Bob\" <tel:5550100;phone-context=+1212>;tag=b", "f: \"Bob\" <sip:5550100;phone-context=+1212@host;user=phone>;tag=b", false},
+		{"P-Asserted-Identity: <tel:+12125550100>", "P-Asserted-Identity: <tel:+12125550100>", false},
+		{"SIP/2.0 200 tel:", "SIP/2.0 200 tel:", true},
+	} {
+		if got := string(telToSIPLine([]byte(test.line), "host", test.start)); got != test.want {
+			t.Errorf("telToSIPLine(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package stack
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// TLSPeer overrides the certificate verification of a TLS peer.
+type TLSPeer struct {
+	// SkipVerify accept any certificate, e.g. the self-signed one of a lab.
+	SkipVerify bool
+	// Pins base64 SHA-256 of the SubjectPublicKeyInfo of the accepted certificates, see SPKIPin. A pinned
+	// certificate is accepted without verifying its chain nor its identity.
+	Pins []string
+	// Identity the SIP domain the certificate must identify, the host dialed if empty, e.g. the domain of a
+	// trunk dialed by address.
+	Identity string
+}
+
+// TLSVerifyPolicy verifies the certificates of the TLS and WSS peers the stack dials: the chain against
+// RootCAs, and the identity against the SIP domain dialed, RFC 5922.
+type TLSVerifyPolicy struct {
+	// RootCAs the trusted CAs, the ones of the system if nil.
+	RootCAs *x509.CertPool
+	// PeerFunc the override of host, the domain or the address dialed, if any, before the peers set.
+	PeerFunc func(host string) (TLSPeer, bool)
+	mutex    sync.RWMutex
+	peers    map[string]TLSPeer
+}
+
+// NewTLSVerifyPolicy .
+func NewTLSVerifyPolicy(rootCAs *x509.CertPool) *TLSVerifyPolicy {
+	return &TLSVerifyPolicy{RootCAs: rootCAs, peers: make(map[string]TLSPeer)}
+}
+
+// SetPeer set the override of host, a domain or an address.
+func (p *TLSVerifyPolicy) SetPeer(host string, peer TLSPeer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.peers == nil {
+		p.peers = make(map[string]TLSPeer)
+	}
+	p.peers[strings.ToLower(host)] = peer
+}
+
+// RemovePeer .
+func (p *TLSVerifyPolicy) RemovePeer(host string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.peers, strings.ToLower(host))
+}
+
+// Peer the override of host, the zero TLSPeer if none.
+func (p *TLSVerifyPolicy) Peer(host string) TLSPeer {
+	if p.PeerFunc != nil {
+		if peer, found := p.PeerFunc(host); found {
+			return peer
+		}
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.peers[strings.ToLower(host)]
+}
+
+// CertificateError a certificate of a TLS peer rejected by the TLSVerifyPolicy.
+type CertificateError struct {
+	// Host dialed, Identity expected.
+	Host     string
+	Identity string
+	// Identities the SIP domains the certificate identifies.
+	Identities []string
+	Reason     string
+}
+
+func (e *CertificateError) Error() string {
+	if len(e.Identities) == 0 {
+		return fmt.Sprintf("TLS certificate of %s rejected: %s", e.Host, e.Reason)
+	}
+	return fmt.Sprintf("TLS certificate of %s rejected: %s, it identifies %s, not %s", e.Host, e.Reason,
+		strings.Join(e.Identities, ", "), e.Identity)
+}
+
+// SPKIPin the pin of cert, the base64 SHA-256 of its SubjectPublicKeyInfo.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// CertificateIdentities the SIP domains cert identifies, RFC 5922 section 7.1: the domains of its sip:
+// subjectAltName URIs, else its DNS names, else its Common Name.
+func CertificateIdentities(cert *x509.Certificate) []string {
+	identities := []string{}
+	for _, uri := range cert.URIs {
+		if !strings.EqualFold(uri.Scheme, "sip") {
+			continue
+		}
+		// sip:example.com, a URI with a user part identifies no domain.
+		domain := uri.Opaque
+		if len(domain) == 0 {
+			domain = uri.Host
+		}
+		if len(domain) > 0 && !strings.Contains(domain, "@") {
+			identities = append(identities, strings.ToLower(domain))
+		}
+	}
+	if len(identities) > 0 {
+		return identities
+	}
+	for _, name := range cert.DNSNames {
+		identities = append(identities, strings.ToLower(name))
+	}
+	for _, ip := range cert.IPAddresses {
+		identities = append(identities, ip.String())
+	}
+	if len(identities) == 0 && len(cert.Subject.CommonName) > 0 {
+		identities = append(identities, strings.ToLower(cert.Subject.CommonName))
+	}
+	return identities
+}
+
+// tlsConfig the config dialing host.
+func (p *TLSVerifyPolicy) tlsConfig(host string) *tls.Config {
+	config := &tls.Config{InsecureSkipVerify: true}
+	if net.ParseIP(host) == nil {
+		config.ServerName = host
+	}
+	peer := p.Peer(host)
+	if peer.SkipVerify {
+		return config
+	}
+	// The chain and the identity are verified here, the one of crypto/tls would match the host against
+	// the DNS names only.
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return p.verify(host, peer, rawCerts)
+	}
+	return config
+}
+
+// verify the certificate chain of host.
+func (p *TLSVerifyPolicy) verify(host string, peer TLSPeer, rawCerts [][]byte) error {
+	identity := strings.ToLower(peer.Identity)
+	if len(identity) == 0 {
+		identity = strings.ToLower(host)
+	}
+	if len(rawCerts) == 0 {
+		return &CertificateError{Host: host, Identity: identity, Reason: "no certificate"}
+	}
+	certs := []*x509.Certificate{}
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return &CertificateError{Host: host, Identity: identity, Reason: err.Error()}
+		}
+		certs = append(certs, cert)
+	}
+	leaf := certs[0]
+	if len(peer.Pins) > 0 {
+		pin := SPKIPin(leaf)
+		for _, accepted := range peer.Pins {
+			if accepted == pin {
+				return nil
+			}
+		}
+		return &CertificateError{Host: host, Identity: identity, Reason: "pin " + pin + " not accepted"}
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	options := x509.VerifyOptions{
+		Roots:         p.RootCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if _, err := leaf.Verify(options); err != nil {
+		return &CertificateError{Host: host, Identity: identity, Reason: err.Error()}
+	}
+	identities := CertificateIdentities(leaf)
+	for _, name := range identities {
+		// No wildcard, RFC 5922 section 7.2.
+		if name == identity {
+			return nil
+		}
+	}
+	return &CertificateError{Host: host, Identity: identity, Identities: identities, Reason: "identity mismatch"}
+}
+
+// TLSVerifyPolicy .
+func (s *SipStack) TLSVerifyPolicy() *TLSVerifyPolicy {
+	return s.config.TLSVerify
+}
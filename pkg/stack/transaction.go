@@ -0,0 +1,51 @@
+package stack
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// cancelHeadersField the field of the INVITEs sent holding the headers of their CANCEL, which the client
+// transaction builds from the INVITE.
+const cancelHeadersField = "cancel_headers"
+
+// cancelHeaders the headers added to the CANCEL of an INVITE.
+type cancelHeaders struct {
+	mutex   sync.Mutex
+	headers []sip.Header
+}
+
+func (c *cancelHeaders) set(headers []sip.Header) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.headers = headers
+}
+
+func (c *cancelHeaders) get() []sip.Header {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.headers
+}
+
+// String the headers, logged with the fields of the INVITE.
+func (c *cancelHeaders) String() string {
+	headers := []string{}
+	for _, header := range c.get() {
+		headers = append(headers, header.String())
+	}
+	return strings.Join(headers, ", ")
+}
+
+// clientTransaction the client transaction of an INVITE sent by the stack.
+type clientTransaction struct {
+	sip.ClientTransaction
+	cancel *cancelHeaders
+}
+
+// CancelWithHeaders cancel the INVITE with headers, e.g. a Reason, RFC 3326, added to its CANCEL.
+func (tx *clientTransaction) CancelWithHeaders(headers ...sip.Header) error {
+	tx.cancel.set(headers)
+	return tx.ClientTransaction.Cancel()
+}
@@ -0,0 +1,83 @@
+package stack
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+func readRequest(t *testing.T, conn net.PacketConn) (sip.Request, net.Addr) {
+	buf := make([]byte, 65535)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := parseMessage(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.(sip.Request), addr
+}
+
+func TestCancelWithHeaders(t *testing.T) {
+	s := NewSipStack(&SipStackConfig{Host: "127.0.0.1"})
+	defer s.Shutdown()
+	if err := s.Listen("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	msg, err := parser.ParseMessage([]byte("INVITE sip:bob@"+peer.LocalAddr().String()+" SIP/2.0\r\n"+
+		"Via: SIP/2.0/UDP 127.0.0.1\r\n"+
+		"From: <sip:alice@example.com>;tag=1928301774\r\n"+
+		"To: <sip:bob@example.com>\r\n"+
+		"Call-ID: a84b4c76e66710\r\n"+
+		"CSeq: 1 INVITE\r\n"+
+		"Contact: <sip:alice@127.0.0.1>\r\n"+
+		"Content-Length: 0\r\n\r\n"), fuzzLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := s.Request(msg.(sip.Request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	invite, addr := readRequest(t, peer)
+	ringing := sip.NewResponseFromRequest("", invite, 180, "Ringing", "")
+	if _, err := peer.WriteTo([]byte(ringing.String()), addr); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-tx.Responses():
+	case <-time.After(2 * time.Second):
+		t.Fatal("180 Ringing not received")
+	}
+
+	canceler, ok := tx.(interface {
+		CancelWithHeaders(headers ...sip.Header) error
+	})
+	if !ok {
+		t.Fatalf("%T can't cancel with headers", tx)
+	}
+	reason := utils.NewReasonHeader("SIP", 200, "Call completed elsewhere")
+	if err := canceler.CancelWithHeaders(reason); err != nil {
+		t.Fatal(err)
+	}
+	cancel, _ := readRequest(t, peer)
+	if !cancel.IsCancel() {
+		t.Fatalf("%s sent, want the CANCEL", cancel.Method())
+	}
+	reasons := cancel.GetHeaders("Reason")
+	if len(reasons) != 1 || reasons[0].Value() != reason.Value() {
+		t.Errorf("Reason = %v, want %v", reasons, reason)
+	}
+}
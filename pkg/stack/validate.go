@@ -0,0 +1,107 @@
+package stack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// MalformedRequestError a request missing mandatory headers or with inconsistent headers.
+type MalformedRequestError struct {
+	Reason string
+	// Respondable a 400 response can be built from the request.
+	Respondable bool
+}
+
+func (e *MalformedRequestError) Error() string {
+	return fmt.Sprintf("malformed SIP message: %s", e.Reason)
+}
+
+// ValidateMessage check the mandatory headers of msg, https://tools.ietf.org/html/rfc3261#section-8.1.1
+func ValidateMessage(msg sip.Message) error {
+	viaHop, ok := msg.ViaHop()
+	if !ok {
+		return &MalformedRequestError{Reason: "Missing Via header"}
+	}
+	if hasEmptyParam(viaHop.Params, "branch") {
+		return &MalformedRequestError{Reason: "Empty Via branch"}
+	}
+	cseq, ok := msg.CSeq()
+	if !ok {
+		return &MalformedRequestError{Reason: "Missing CSeq header"}
+	}
+	if _, ok := msg.CallID(); !ok {
+		return &MalformedRequestError{Reason: "Missing Call-ID header"}
+	}
+	from, ok := msg.From()
+	if !ok || from.Address == nil {
+		return &MalformedRequestError{Reason: "Missing From header"}
+	}
+	if hasEmptyParam(from.Params, "tag") {
+		return &MalformedRequestError{Reason: "Empty From tag"}
+	}
+	to, ok := msg.To()
+	if !ok || to.Address == nil {
+		return &MalformedRequestError{Reason: "Missing To header"}
+	}
+	if hasEmptyParam(to.Params, "tag") {
+		return &MalformedRequestError{Reason: "Empty To tag"}
+	}
+
+	req, ok := msg.(sip.Request)
+	if !ok {
+		return nil
+	}
+	if req.Recipient() == nil {
+		return &MalformedRequestError{Reason: "Missing Request-URI"}
+	}
+	// The parser upper-cases the method of the Request-Line only.
+	if !strings.EqualFold(string(cseq.MethodName), string(req.Method())) {
+		return &MalformedRequestError{Reason: "CSeq method does not match Request-Line", Respondable: true}
+	}
+	if req.IsInvite() {
+		if contact, ok := req.Contact(); !ok || contact.Address == nil {
+			return &MalformedRequestError{Reason: "Missing Contact header", Respondable: true}
+		}
+	}
+	return nil
+}
+
+// hasEmptyParam the param is present without a value, e.g. ;branch
+func hasEmptyParam(params sip.Params, name string) bool {
+	if params == nil {
+		return false
+	}
+	value, ok := params.Get(name)
+	return ok && (value == nil || len(value.String()) == 0)
+}
+
+// filterMessages drop the malformed messages and the requests of the scanners before they reach the
+// transaction layer, malformed requests are answered with a stateless 400 when possible.
+func (s *SipStack) filterMessages(in <-chan sip.Message, out chan<- sip.Message) {
+	defer close(out)
+	for msg := range in {
+		s.observe(msg, true)
+		if s.blockScanner(msg) {
+			continue
+		}
+		if err := ValidateMessage(msg); err != nil {
+			s.Log().Warnf("drop SIP message from %s: %s", msg.Source(), err)
+			if malformed, ok := err.(*MalformedRequestError); ok && malformed.Respondable {
+				if req, ok := msg.(sip.Request); ok && !req.IsAck() {
+					res := sip.NewResponseFromRequest("", req, 400, malformed.Reason, "")
+					if err := s.Send(res); err != nil {
+						s.Log().Errorf("respond '400 %s' failed: %s", malformed.Reason, err)
+					}
+				}
+			}
+			continue
+		}
+		select {
+		case out <- msg:
+		case <-s.tp.Done():
+			return
+		}
+	}
+}
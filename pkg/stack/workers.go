@@ -0,0 +1,138 @@
+package stack
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// DefaultWorkerQueueSize .
+	DefaultWorkerQueueSize = 256
+	// DefaultBackendWorkers .
+	DefaultBackendWorkers = 32
+	// slowHandlerTime a request handler blocking its worker longer is logged.
+	slowHandlerTime = 100 * time.Millisecond
+)
+
+// WorkerStats queue metrics of the worker pool, or of the dialog runtime.
+type WorkerStats struct {
+	// Workers of the pool, or dialogs with messages in process.
+	Workers   int
+	QueueSize int
+	// Queued requests waiting in the queues.
+	Queued int
+	// Processed requests since start.
+	Processed uint64
+	// Rejected requests because the queue was full.
+	Rejected uint64
+}
+
+// WorkerPool a bounded pool of workers, jobs with the same key are processed
+// in order by the same worker.
+type WorkerPool struct {
+	queues    []chan func()
+	queueSize int
+	processed uint64
+	rejected  uint64
+	wg        *sync.WaitGroup
+}
+
+// NewWorkerPool .
+func NewWorkerPool(workers int, queueSize int) *WorkerPool {
+	if queueSize <= 0 {
+		queueSize = DefaultWorkerQueueSize
+	}
+	p := &WorkerPool{
+		queues:    make([]chan func(), workers),
+		queueSize: queueSize,
+		wg:        new(sync.WaitGroup),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), queueSize)
+		p.wg.Add(1)
+		go p.work(p.queues[i])
+	}
+	return p
+}
+
+func (p *WorkerPool) work(queue chan func()) {
+	defer p.wg.Done()
+	for job := range queue {
+		job()
+		atomic.AddUint64(&p.processed, 1)
+	}
+}
+
+// Submit queue job on the worker of key, returns false if the queue is full.
+func (p *WorkerPool) Submit(key string, job func()) bool {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	queue := p.queues[hash.Sum32()%uint32(len(p.queues))]
+	select {
+	case queue <- job:
+		return true
+	default:
+		atomic.AddUint64(&p.rejected, 1)
+		return false
+	}
+}
+
+// Stats .
+func (p *WorkerPool) Stats() WorkerStats {
+	queued := 0
+	for _, queue := range p.queues {
+		queued += len(queue)
+	}
+	return WorkerStats{
+		Workers:   len(p.queues),
+		QueueSize: p.queueSize,
+		Queued:    queued,
+		Processed: atomic.LoadUint64(&p.processed),
+		Rejected:  atomic.LoadUint64(&p.rejected),
+	}
+}
+
+// Stop wait for the queued jobs and stop the workers.
+func (p *WorkerPool) Stop() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+// WorkerStats queue metrics, ok is false when the worker pool and the dialog runtime are disabled.
+func (s *SipStack) WorkerStats() (WorkerStats, bool) {
+	if s.workers == nil {
+		return WorkerStats{}, false
+	}
+	return s.workers.Stats(), true
+}
+
+// dispatch process req on the worker or the actor of its Call-ID, or on a new goroutine
+// when both are disabled.
+func (s *SipStack) dispatch(req sip.Request, tx sip.ServerTransaction) {
+	s.hwg.Add(1)
+	if s.workers == nil {
+		go s.handleRequest(req, tx)
+		return
+	}
+
+	key := ""
+	if callID, ok := req.CallID(); ok {
+		key = callID.Value()
+	}
+	if s.workers.Submit(key, func() { s.handleRequest(req, tx) }) {
+		return
+	}
+
+	s.hwg.Done()
+	s.Log().Warnf("worker queue full, SIP request %v rejected", req.Method())
+	if tx != nil && !req.IsAck() {
+		res := sip.NewResponseFromRequest("", req, 503, "Service Unavailable", "")
+		tx.Respond(res)
+	}
+}
@@ -0,0 +1,52 @@
+package stack
+
+import (
+	"testing"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// respondTx a server transaction recording its responses.
+type respondTx struct {
+	sip.ServerTransaction
+	responses chan sip.Response
+}
+
+func (tx *respondTx) Respond(res sip.Response) error {
+	tx.responses <- res
+	return nil
+}
+
+func TestOffloadFull(t *testing.T) {
+	s := NewSipStack(&SipStackConfig{Host: "127.0.0.1", BackendWorkers: 1, WorkerQueueSize: 1})
+	defer s.Shutdown()
+	msg, err := parseMessage([]byte("OPTIONS sip:bob@example.com SIP/2.0\r\n" +
+		"Via: SIP/2.0/UDP 127.0.0.1;branch=z9hG4bK776asdhds\r\n" +
+		"From: <sip:alice@example.com>;tag=1928301774\r\n" +
+		"To: <sip:bob@example.com>\r\n" +
+		"Call-ID: a84b4c76e66710\r\n" +
+		"CSeq: 1 OPTIONS\r\n" +
+		"Content-Length: 0\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := msg.(sip.Request)
+	tx := &respondTx{responses: make(chan sip.Response, 1)}
+
+	// One job runs and blocks the backend worker, one is queued.
+	started, release := make(chan struct{}), make(chan struct{})
+	s.Offload(req, tx, func() {
+		close(started)
+		<-release
+	})
+	<-started
+	ran := make(chan struct{})
+	s.Offload(req, tx, func() { close(ran) })
+	s.Offload(req, tx, func() { t.Error("job run beyond the queue") })
+	res := <-tx.responses
+	if res.StatusCode() != 503 {
+		t.Errorf("%d answered beyond the queue, want 503", res.StatusCode())
+	}
+	close(release)
+	<-ran
+}
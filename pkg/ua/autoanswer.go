@@ -0,0 +1,84 @@
+package ua
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghettovoice/gosip/sip"
+)
+
+// AutoAnswer the request of a caller to have its call answered without user action, e.g. an
+// intercom or a paging system, from the Answer-Mode and Priv-Answer-Mode headers (RFC 5373),
+// the answer-after parameter of the Call-Info or the alert-autoanswer Alert-Info of the phones.
+type AutoAnswer struct {
+	// Required the call must be rejected with 403 rather than answered manually.
+	Required bool
+	// Private Priv-Answer-Mode, which should override the do not disturb settings of the callee.
+	Private bool
+	// Delay before answering.
+	Delay time.Duration
+}
+
+// ParseAutoAnswer the auto-answer request of an INVITE, nil if none or Answer-Mode: Manual.
+func ParseAutoAnswer(request sip.Request) *AutoAnswer {
+	var autoAnswer *AutoAnswer
+	for _, name := range []string{"Answer-Mode", "Priv-Answer-Mode"} {
+		for _, header := range request.GetHeaders(name) {
+			params := strings.Split(header.Value(), ";")
+			if !strings.EqualFold(strings.TrimSpace(params[0]), "Auto") {
+				continue
+			}
+			if autoAnswer == nil {
+				autoAnswer = &AutoAnswer{}
+			}
+			autoAnswer.Private = autoAnswer.Private || name == "Priv-Answer-Mode"
+			for _, param := range params[1:] {
+				if strings.EqualFold(strings.TrimSpace(param), "require") {
+					autoAnswer.Required = true
+				}
+			}
+		}
+	}
+	for _, header := range request.GetHeaders("Call-Info") {
+		for _, param := range strings.Split(header.Value(), ";")[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || !strings.EqualFold(kv[0], "answer-after") {
+				continue
+			}
+			if seconds, err := strconv.Atoi(strings.Trim(kv[1], `"`)); err == nil && seconds >= 0 {
+				if autoAnswer == nil {
+					autoAnswer = &AutoAnswer{}
+				}
+				autoAnswer.Delay = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	for _, header := range request.GetHeaders("Alert-Info") {
+		if autoAnswer == nil && strings.Contains(strings.ToLower(header.Value()), "info=alert-autoanswer") {
+			autoAnswer = &AutoAnswer{}
+		}
+	}
+	return autoAnswer
+}
+
+// Headers the headers requesting the auto-answer of an INVITE, Answer-Mode or Priv-Answer-Mode,
+// and the Call-Info answer-after of the intercoms, about uri, e.g. the URI of the caller.
+func (a *AutoAnswer) Headers(uri sip.Uri) []sip.Header {
+	name, mode := "Answer-Mode", "Auto"
+	if a.Private {
+		name = "Priv-Answer-Mode"
+	}
+	if a.Required {
+		mode += ";require"
+	}
+	return []sip.Header{
+		&sip.GenericHeader{HeaderName: name, Contents: mode},
+		&sip.GenericHeader{HeaderName: "Call-Info", Contents: fmt.Sprintf("<%s>;answer-after=%d", uri, int(a.Delay/time.Second))},
+	}
+}
+
+func (a *AutoAnswer) String() string {
+	return fmt.Sprintf("auto-answer after %v, required: %v, private: %v", a.Delay, a.Required, a.Private)
+}
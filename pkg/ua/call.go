@@ -0,0 +1,341 @@
+package ua
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/account"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// DTMFDuration duration in ms of the digits sent by SendDTMF.
+	DTMFDuration = 160
+)
+
+// CallStateHandler called on each state change of a call, the last one is Failure, Canceled or Terminated.
+type CallStateHandler func(call *Call, state session.Status)
+
+// IncomingCallHandler called with the new incoming calls, to Ring, Answer or Decline them.
+type IncomingCallHandler func(call *Call)
+
+// Call an INVITE session of the UA. The state handlers are called in order, the end state exactly
+// once, and never after it; the methods return an error instead of acting on a call in the wrong state.
+type Call struct {
+	ua       *UserAgent
+	session  *session.Session
+	mutex    sync.Mutex
+	state    session.Status
+	held     bool
+	ended    bool
+	done     chan struct{}
+	handlers []CallStateHandler
+	auto     *AutoAnswer
+	// UserData of the application.
+	UserData interface{}
+}
+
+func newCall(ua *UserAgent, is *session.Session) *Call {
+	call := &Call{
+		ua:      ua,
+		session: is,
+		state:   is.Status(),
+		done:    make(chan struct{}),
+	}
+	if is.Direction() == session.Incoming {
+		call.auto = ParseAutoAnswer(is.Request())
+	}
+	return call
+}
+
+// Session the underlying INVITE session.
+func (c *Call) Session() *session.Session {
+	return c.session
+}
+
+// Direction .
+func (c *Call) Direction() session.Direction {
+	return c.session.Direction()
+}
+
+// CallID .
+func (c *Call) CallID() string {
+	return c.session.CallID().Value()
+}
+
+// LocalURI .
+func (c *Call) LocalURI() sip.Address {
+	return c.session.LocalURI()
+}
+
+// RemoteURI .
+func (c *Call) RemoteURI() sip.Address {
+	return c.session.RemoteURI()
+}
+
+// LocalSDP .
+func (c *Call) LocalSDP() string {
+	return c.session.LocalSdp()
+}
+
+// RemoteSDP .
+func (c *Call) RemoteSDP() string {
+	return c.session.RemoteSdp()
+}
+
+// AutoAnswer the auto-answer requested by the caller of an incoming call, nil if none. It is only a
+// hint, the application decides whether to Answer after its Delay.
+func (c *Call) AutoAnswer() *AutoAnswer {
+	return c.auto
+}
+
+// State the last state of the call.
+func (c *Call) State() session.Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state
+}
+
+// Held the call is on hold by Hold.
+func (c *Call) Held() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.held
+}
+
+// Done closed once the call has ended.
+func (c *Call) Done() <-chan struct{} {
+	return c.done
+}
+
+// OnStateChange add a state handler, called at once with the current state so that no end is missed.
+func (c *Call) OnStateChange(handler CallStateHandler) {
+	c.mutex.Lock()
+	c.handlers = append(c.handlers, handler)
+	state := c.state
+	c.mutex.Unlock()
+	handler(c, state)
+}
+
+// setState notify the handlers of state, ignored once the call has ended.
+func (c *Call) setState(state session.Status) {
+	c.mutex.Lock()
+	if c.ended {
+		c.mutex.Unlock()
+		return
+	}
+	c.state = state
+	switch state {
+	case session.Failure, session.Canceled, session.Terminated:
+		c.ended = true
+		close(c.done)
+	}
+	handlers := append([]CallStateHandler{}, c.handlers...)
+	c.mutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(c, state)
+	}
+}
+
+// checkIncoming the call is incoming and not answered yet.
+func (c *Call) checkIncoming() error {
+	if c.Direction() != session.Incoming {
+		return fmt.Errorf("not an incoming call")
+	}
+	switch state := c.State(); state {
+	case session.InviteReceived, session.WaitingForAnswer:
+		return nil
+	default:
+		return fmt.Errorf("invalid status: %v", state)
+	}
+}
+
+// Ring send a 180 Ringing.
+func (c *Call) Ring() error {
+	if err := c.checkIncoming(); err != nil {
+		return err
+	}
+	c.session.Provisional(180, "Ringing")
+	return nil
+}
+
+// Answer accept the incoming call with the sdp answer.
+func (c *Call) Answer(sdp string) error {
+	if err := c.checkIncoming(); err != nil {
+		return err
+	}
+	if len(sdp) == 0 {
+		return fmt.Errorf("empty sdp answer")
+	}
+	c.session.ProvideAnswer(sdp)
+	c.session.Accept(200)
+	c.setState(session.Answered)
+	return nil
+}
+
+// Decline reject the incoming call with a final status code, e.g. 486 or 603.
+func (c *Call) Decline(code sip.StatusCode) error {
+	if code < 300 || code > 699 {
+		return fmt.Errorf("invalid status code: %d", code)
+	}
+	if err := c.checkIncoming(); err != nil {
+		return err
+	}
+	c.session.Reject(code, session.ReasonPhrase[uint16(code)])
+	c.ua.endSession(c.session, session.Failure)
+	return nil
+}
+
+// Hangup cancel, decline or end the call depending on its state, nothing once ended.
+func (c *Call) Hangup() error {
+	c.mutex.Lock()
+	ended := c.ended
+	c.mutex.Unlock()
+	if ended {
+		return nil
+	}
+	if c.checkIncoming() == nil {
+		return c.Decline(603)
+	}
+	return c.session.End()
+}
+
+// Hold put the established call on hold, with a re-INVITE offering sendonly streams.
+func (c *Call) Hold() error {
+	return c.setHold(true)
+}
+
+// Unhold resume the call put on hold by Hold.
+func (c *Call) Unhold() error {
+	return c.setHold(false)
+}
+
+func (c *Call) setHold(hold bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ended || !c.session.IsEstablished() {
+		return fmt.Errorf("invalid status: %v", c.state)
+	}
+	if c.held == hold {
+		return nil
+	}
+	direction := "sendrecv"
+	if hold {
+		direction = "sendonly"
+	}
+	c.session.ProvideOffer(media.SetDirection(c.session.LocalSdp(), direction))
+	c.session.ReInvite()
+	c.held = hold
+	return nil
+}
+
+// answerReInvite accept a re-INVITE with the local description, its direction mirroring the offer.
+func (c *Call) answerReInvite() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	offer := c.session.Request().Body()
+	if len(offer) == 0 {
+		// A re-INVITE without offer, e.g. a session refresh.
+		c.session.AcceptReInvite(c.session.LocalSdp())
+		return
+	}
+	direction := "sendrecv"
+	switch media.Direction(offer) {
+	case "sendonly":
+		direction = "recvonly"
+	case "recvonly":
+		direction = "sendonly"
+	case "inactive":
+		direction = "inactive"
+	default:
+		if c.held {
+			direction = "sendonly"
+		}
+	}
+	c.session.AcceptReInvite(media.SetDirection(c.session.LocalSdp(), direction))
+}
+
+// SendDTMF send digits, 0-9, *, # and A-D, with SIP INFO application/dtmf-relay.
+func (c *Call) SendDTMF(digits string) error {
+	c.mutex.Lock()
+	established := !c.ended && c.session.IsEstablished()
+	c.mutex.Unlock()
+	if !established {
+		return fmt.Errorf("invalid status: %v", c.State())
+	}
+	for _, digit := range strings.ToUpper(digits) {
+		if !strings.ContainsRune("0123456789*#ABCD", digit) {
+			return fmt.Errorf("invalid DTMF digit: %q", digit)
+		}
+	}
+	for _, digit := range strings.ToUpper(digits) {
+		c.session.Info(fmt.Sprintf("Signal=%c\r\nDuration=%d\r\n", digit, DTMFDuration), "application/dtmf-relay")
+	}
+	return nil
+}
+
+// updateCall forward the state of an INVITE session to its call, created for the new sessions.
+func (ua *UserAgent) updateCall(is *session.Session, state session.Status) {
+	v, found := ua.calls.Load(is)
+	if !found {
+		if state != session.InviteSent && state != session.InviteReceived {
+			return
+		}
+		v, _ = ua.calls.LoadOrStore(is, newCall(ua, is))
+	}
+	call := v.(*Call)
+	call.setState(state)
+	switch state {
+	case session.InviteReceived:
+		if ua.IncomingCallHandler != nil {
+			ua.IncomingCallHandler(call)
+		}
+	case session.ReInviteReceived:
+		call.answerReInvite()
+	case session.Failure, session.Canceled, session.Terminated:
+		ua.calls.Delete(is)
+	}
+}
+
+// endSession end a session on the UA side, e.g. once declined.
+func (ua *UserAgent) endSession(is *session.Session, state session.Status) {
+	if callID := is.CallID(); callID != nil {
+		ua.iss.Delete(NewSessionKey(*callID, utils.GetBranchID(is.Request())))
+	}
+	is.SetState(state)
+	ua.handleInviteState(is, nil, nil, state, nil)
+}
+
+// Call place a call from profile to target through recipient with the sdp offer, handler is
+// added with OnStateChange.
+func (ua *UserAgent) Call(ctx context.Context, profile *account.Profile, target sip.Uri, recipient sip.SipUri, sdp string, handler CallStateHandler) (*Call, error) {
+	return ua.CallWithHeaders(ctx, profile, target, recipient, sdp, nil, handler)
+}
+
+// Intercom place a call asking the callee to answer it automatically, see AutoAnswer.
+func (ua *UserAgent) Intercom(ctx context.Context, profile *account.Profile, target sip.Uri, recipient sip.SipUri, sdp string, autoAnswer AutoAnswer, handler CallStateHandler) (*Call, error) {
+	return ua.CallWithHeaders(ctx, profile, target, recipient, sdp, autoAnswer.Headers(profile.URI), handler)
+}
+
+// CallWithHeaders place a call with extra headers in the INVITE.
+func (ua *UserAgent) CallWithHeaders(ctx context.Context, profile *account.Profile, target sip.Uri, recipient sip.SipUri, sdp string, headers []sip.Header, handler CallStateHandler) (*Call, error) {
+	is, err := ua.InviteWithHeaders(ctx, profile, target, recipient, &sdp, "", headers)
+	if err != nil {
+		return nil, err
+	}
+	v, found := ua.calls.Load(is)
+	if !found {
+		return nil, fmt.Errorf("call ended: %v", is.Status())
+	}
+	call := v.(*Call)
+	if handler != nil {
+		call.OnStateChange(handler)
+	}
+	return call, nil
+}
@@ -0,0 +1,133 @@
+package ua
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/account"
+	"github.com/ghettovoice/gosip/sip"
+)
+
+const (
+	// MessageSummaryEvent the event package of the message waiting indications, RFC 3842.
+	MessageSummaryEvent = "message-summary"
+	// MessageSummaryContentType .
+	MessageSummaryContentType = "application/simple-message-summary"
+)
+
+// MessageCounts the messages of a class, e.g. Voice-Message: 2/8 (0/2).
+type MessageCounts struct {
+	New       int
+	Old       int
+	UrgentNew int
+	UrgentOld int
+}
+
+// MessageSummary a simple-message-summary body.
+type MessageSummary struct {
+	MessagesWaiting bool
+	// Account the Message-Account, the mailbox, may be empty.
+	Account string
+	// Voice the Voice-Message counts, also in Counts.
+	Voice MessageCounts
+	// Counts by message class in lower case: voice-message, fax-message, text-message...
+	Counts map[string]MessageCounts
+}
+
+// MWIHandler called with the message summary of each NOTIFY of a MWI subscription.
+type MWIHandler func(sub *Subscription, summary *MessageSummary)
+
+// ParseMessageSummary parse a simple-message-summary body.
+func ParseMessageSummary(body string) (*MessageSummary, error) {
+	summary := &MessageSummary{Counts: make(map[string]MessageCounts)}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			// The optional extension headers follow.
+			if len(summary.Counts) > 0 {
+				break
+			}
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name, value := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+		switch name {
+		case "messages-waiting":
+			summary.MessagesWaiting = strings.EqualFold(value, "yes")
+		case "message-account":
+			summary.Account = value
+		default:
+			if !strings.HasSuffix(name, "-message") {
+				continue
+			}
+			counts, err := parseMessageCounts(value)
+			if err != nil {
+				return nil, err
+			}
+			summary.Counts[name] = counts
+			if name == "voice-message" {
+				summary.Voice = counts
+			}
+		}
+	}
+	return summary, scanner.Err()
+}
+
+// parseMessageCounts parse new/old (urgent-new/urgent-old), the urgent counts are optional.
+func parseMessageCounts(value string) (MessageCounts, error) {
+	counts := MessageCounts{}
+	urgent := ""
+	if i := strings.Index(value, "("); i >= 0 {
+		urgent = strings.Trim(value[i:], "() ")
+		value = value[:i]
+	}
+	var err error
+	if counts.New, counts.Old, err = parseNewOld(value); err != nil {
+		return counts, err
+	}
+	if len(urgent) > 0 {
+		if counts.UrgentNew, counts.UrgentOld, err = parseNewOld(urgent); err != nil {
+			return counts, err
+		}
+	}
+	return counts, nil
+}
+
+func parseNewOld(value string) (int, int, error) {
+	parts := strings.SplitN(strings.TrimSpace(value), "/", 2)
+	newCount, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	oldCount := 0
+	if len(parts) == 2 {
+		if oldCount, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil {
+			return 0, 0, err
+		}
+	}
+	return newCount, oldCount, nil
+}
+
+// SubscribeMWI subscribe to the message waiting indications of the mailbox of profile for expires
+// seconds, handler is called with the message summary of each NOTIFY.
+func (ua *UserAgent) SubscribeMWI(profile *account.Profile, expires uint32, handler MWIHandler) (*Subscription, error) {
+	return ua.Subscribe(profile, profile.URI, MessageSummaryEvent, MessageSummaryContentType, expires,
+		func(sub *Subscription, notify sip.Request) {
+			if len(notify.Body()) == 0 {
+				return
+			}
+			summary, err := ParseMessageSummary(notify.Body())
+			if err != nil {
+				ua.Log().Errorf("Invalid message summary => %v", err)
+				return
+			}
+			if handler != nil {
+				handler(sub, summary)
+			}
+		})
+}
@@ -0,0 +1,264 @@
+package ua
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/account"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/auth"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/util"
+)
+
+var (
+	// PublishContentTypes content type of the bodies of the event packages, RFC 3903.
+	PublishContentTypes = map[string]string{
+		"presence":        "application/pidf+xml",
+		"dialog":          "application/dialog-info+xml",
+		"message-summary": "application/simple-message-summary",
+	}
+)
+
+// Publication the state of an event package published to an Event State Compositor (RFC 3903),
+// refreshed with SIP-If-Match before it expires.
+type Publication struct {
+	ua          *UserAgent
+	profile     *account.Profile
+	event       string
+	contentType string
+	authorizer  *auth.ClientAuthorizer
+	callID      sip.CallID
+	cseq        uint
+	etag        string
+	body        string
+	expires     uint32
+	timer       *time.Timer
+	mutex       sync.Mutex
+	ctx         context.Context
+	cancel      context.CancelFunc
+	data        interface{}
+}
+
+// NewPublication publish the event state of profile, with the PublishContentTypes of event if
+// contentType is empty.
+func NewPublication(ua *UserAgent, profile *account.Profile, event string, contentType string, data interface{}) *Publication {
+	if len(contentType) == 0 {
+		contentType = PublishContentTypes[event]
+	}
+	p := &Publication{
+		ua:          ua,
+		profile:     profile,
+		event:       event,
+		contentType: contentType,
+		callID:      sip.CallID(util.RandString(32)),
+		data:        data,
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	return p
+}
+
+// Publish publish the initial state, or modify the published state, for expires seconds.
+func (p *Publication) Publish(body string, expires uint32) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.body = body
+	return p.send(&body, expires)
+}
+
+// Refresh extend the published state without sending it again.
+func (p *Publication) Refresh() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.etag) == 0 {
+		return fmt.Errorf("nothing published")
+	}
+	return p.send(nil, p.expires)
+}
+
+// Unpublish remove the published state.
+func (p *Publication) Unpublish() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stopTimer()
+	if len(p.etag) == 0 {
+		return nil
+	}
+	return p.send(nil, 0)
+}
+
+// Stop the refreshes, the published state expires on the server.
+func (p *Publication) Stop() {
+	p.mutex.Lock()
+	p.stopTimer()
+	p.mutex.Unlock()
+	p.cancel()
+}
+
+// ETag the entity-tag of the published state, empty if nothing is published.
+func (p *Publication) ETag() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.etag
+}
+
+func (p *Publication) stopTimer() {
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+}
+
+// send a PUBLISH with body, nil for a refresh or a removal, locked.
+func (p *Publication) send(body *string, expires uint32) error {
+	ua := p.ua
+	response, err := p.request(body, expires)
+	if err != nil {
+		if reqErr, ok := err.(*sip.RequestError); ok && reqErr.Response != nil {
+			switch reqErr.Code {
+			case 412:
+				// The server lost the state, publish it again from scratch.
+				if len(p.etag) > 0 && expires > 0 {
+					p.etag = ""
+					response, err = p.request(&p.body, expires)
+				}
+			case 423:
+				if minExpires := headerUint(reqErr.Response, "Min-Expires"); minExpires > expires {
+					response, err = p.request(body, minExpires)
+				}
+			}
+		}
+	}
+
+	state := account.PublishState{
+		Account:  p.profile,
+		Event:    p.event,
+		UserData: p.data,
+	}
+	if err != nil {
+		ua.Log().Errorf("Request [%s] failed, err => %v", sip.PUBLISH, err)
+		state.StatusCode, state.Reason = 500, err.Error()
+		if reqErr, ok := err.(*sip.RequestError); ok {
+			state.StatusCode, state.Reason = sip.StatusCode(reqErr.Code), reqErr.Reason
+			state.Response = reqErr.Response
+		}
+		state.ETag = p.etag
+	} else {
+		state.StatusCode, state.Reason, state.Response = response.StatusCode(), response.Reason(), response
+		p.expires = expires
+		if headers := response.GetHeaders("Expires"); len(headers) > 0 {
+			p.expires = uint32(*headers[0].(*sip.Expires))
+		}
+		if headers := response.GetHeaders("SIP-ETag"); len(headers) > 0 {
+			p.etag = headers[0].Value()
+		}
+		if expires == 0 {
+			p.etag, p.expires = "", 0
+		}
+		state.ETag, state.Expiration = p.etag, p.expires
+		p.schedule()
+	}
+
+	if ua.PublishStateHandler != nil {
+		ua.PublishStateHandler(state)
+	}
+	return err
+}
+
+// schedule the refresh before the published state expires, locked.
+func (p *Publication) schedule() {
+	p.stopTimer()
+	if p.expires == 0 || len(p.etag) == 0 {
+		return
+	}
+	refresh := time.Duration(p.expires) * time.Second / 2
+	if p.expires > 20 {
+		refresh = time.Duration(p.expires-10) * time.Second
+	}
+	p.timer = time.AfterFunc(refresh, func() {
+		select {
+		case <-p.ctx.Done():
+			return
+		default:
+		}
+		p.Refresh()
+	})
+}
+
+// request send a PUBLISH, locked.
+func (p *Publication) request(body *string, expires uint32) (sip.Response, error) {
+	profile := p.profile
+	p.cseq++
+
+	builder := sip.NewRequestBuilder()
+	builder.SetMethod(sip.PUBLISH)
+	from := &sip.Address{
+		Uri:    profile.URI,
+		Params: sip.NewParams().Add("tag", sip.String{Str: util.RandString(8)}),
+	}
+	if len(profile.DisplayName) > 0 {
+		from.DisplayName = sip.String{Str: profile.DisplayName}
+	}
+	builder.SetFrom(from)
+	builder.SetTo(&sip.Address{Uri: profile.URI})
+	builder.SetRecipient(profile.URI.Clone())
+	builder.SetCallID(&p.callID)
+	builder.SetSeqNo(p.cseq)
+	event := sip.GenericHeader{HeaderName: "Event", Contents: p.event}
+	builder.AddHeader(&event)
+	expiresHeader := sip.Expires(expires)
+	builder.SetExpires(&expiresHeader)
+	if len(p.etag) > 0 {
+		builder.AddHeader(&sip.GenericHeader{HeaderName: "SIP-If-Match", Contents: p.etag})
+	}
+	if body != nil {
+		contentType := sip.ContentType(p.contentType)
+		builder.SetContentType(&contentType)
+		builder.SetBody(*body)
+	}
+	if userAgent := profile.UserAgentHeader(); userAgent != nil {
+		builder.SetUserAgent(userAgent)
+	}
+	request, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	utils.SetRouteSet(request, profile.Routes)
+
+	if profile.AuthInfo != nil && p.authorizer == nil {
+		p.authorizer = auth.NewClientAuthorizer(profile.AuthInfo.AuthUser, profile.AuthInfo.Password)
+	}
+	var authorizer sip.Authorizer
+	if p.authorizer != nil {
+		authorizer = p.authorizer
+	}
+	response, err := p.ua.RequestWithContext(p.ctx, request, authorizer, true, 1)
+	// The authorization retries increase the CSeq.
+	if cseq, ok := request.CSeq(); ok && uint(cseq.SeqNo) > p.cseq {
+		p.cseq = uint(cseq.SeqNo)
+	}
+	return response, err
+}
+
+// headerUint the value of the first header name of msg, 0 if missing.
+func headerUint(msg sip.Message, name string) uint32 {
+	if headers := msg.GetHeaders(name); len(headers) > 0 {
+		if value, err := strconv.ParseUint(headers[0].Value(), 10, 32); err == nil {
+			return uint32(value)
+		}
+	}
+	return 0
+}
+
+// Publish publish body of event for profile, e.g. a presence PIDF document, for expires seconds,
+// refreshed until Unpublish or Stop.
+func (ua *UserAgent) Publish(profile *account.Profile, event string, body string, expires uint32) (*Publication, error) {
+	publication := NewPublication(ua, profile, event, "", nil)
+	if err := publication.Publish(body, expires); err != nil {
+		return nil, err
+	}
+	return publication, nil
+}
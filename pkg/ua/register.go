@@ -53,13 +53,16 @@ func (r *Register) SendRegister(expires uint32) error {
 	contact := profile.Contact()
 
 	if r.request == nil || expires == 0 {
-		request, err := ua.buildRequest(sip.REGISTER, from, to, contact, recipient, profile.Routes, nil)
+		request, err := ua.buildRequest(sip.REGISTER, from, to, contact, recipient, profile.Routes, profile.UserAgentHeader(), nil)
 		if err != nil {
 			ua.Log().Errorf("Register: err = %v", err)
 			return err
 		}
 		expiresHeader := sip.Expires(expires)
 		(*request).AppendHeader(&expiresHeader)
+		for _, header := range profile.CapabilityHeaders() {
+			(*request).AppendHeader(header)
+		}
 		r.request = request
 	} else {
 		cseq, _ := (*r.request).CSeq()
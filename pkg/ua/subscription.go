@@ -0,0 +1,358 @@
+package ua
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/account"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/auth"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/utils"
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/util"
+)
+
+// SubscriptionState state of a subscription, from the Subscription-State of its NOTIFYs (RFC 6665).
+type SubscriptionState string
+
+const (
+	SubscriptionPending    SubscriptionState = "pending"
+	SubscriptionActive     SubscriptionState = "active"
+	SubscriptionTerminated SubscriptionState = "terminated"
+)
+
+// NotifyHandler called with the NOTIFYs of a subscription, once answered.
+type NotifyHandler func(sub *Subscription, notify sip.Request)
+
+// Subscription a subscription to an event package of a resource, refreshed before it expires
+// and renewed when the notifier terminates it with a deactivated or timeout reason.
+type Subscription struct {
+	ua         *UserAgent
+	profile    *account.Profile
+	target     sip.Uri
+	event      string
+	accept     string
+	handler    NotifyHandler
+	authorizer *auth.ClientAuthorizer
+	// dialog
+	callID       sip.CallID
+	cseq         uint
+	fromTag      string
+	toTag        string
+	remoteTarget sip.Uri
+	routes       []sip.Uri
+
+	state       SubscriptionState
+	expires     uint32
+	unsubscribe bool
+	timer       *time.Timer
+	mutex       sync.Mutex
+	ctx         context.Context
+	cancel      context.CancelFunc
+}
+
+// NewSubscription subscribe profile to event of target, the NOTIFYs are passed to handler.
+// accept the content type of the notifications, e.g. application/simple-message-summary.
+func NewSubscription(ua *UserAgent, profile *account.Profile, target sip.Uri, event string, accept string, handler NotifyHandler) *Subscription {
+	s := &Subscription{
+		ua:      ua,
+		profile: profile,
+		target:  target,
+		event:   event,
+		accept:  accept,
+		handler: handler,
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Event .
+func (s *Subscription) Event() string {
+	return s.event
+}
+
+// State .
+func (s *Subscription) State() SubscriptionState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.state
+}
+
+// Subscribe create the subscription for expires seconds.
+func (s *Subscription) Subscribe(expires uint32) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.unsubscribe = false
+	s.newDialog()
+	return s.send(expires)
+}
+
+// Unsubscribe terminate the subscription, the notifier sends a last NOTIFY.
+func (s *Subscription) Unsubscribe() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stopTimer()
+	s.unsubscribe = true
+	if s.state == SubscriptionTerminated || len(s.toTag) == 0 {
+		return nil
+	}
+	return s.send(0)
+}
+
+// Stop the refreshes and forget the subscription, it expires on the notifier.
+func (s *Subscription) Stop() {
+	s.mutex.Lock()
+	s.stopTimer()
+	s.state = SubscriptionTerminated
+	callID := s.callID
+	s.mutex.Unlock()
+	s.ua.subscriptions.Delete(callID)
+	s.cancel()
+}
+
+// newDialog start a new dialog, locked.
+func (s *Subscription) newDialog() {
+	if len(s.callID) > 0 {
+		s.ua.subscriptions.Delete(s.callID)
+	}
+	s.callID = sip.CallID(util.RandString(32))
+	s.fromTag = util.RandString(8)
+	s.toTag = ""
+	s.remoteTarget = nil
+	s.routes = nil
+	s.state = SubscriptionPending
+	s.ua.subscriptions.Store(s.callID, s)
+}
+
+func (s *Subscription) stopTimer() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
+
+// send a SUBSCRIBE, initial or in the dialog, locked.
+func (s *Subscription) send(expires uint32) error {
+	request, err := s.buildRequest(expires)
+	if err != nil {
+		return err
+	}
+	if s.profile.AuthInfo != nil && s.authorizer == nil {
+		s.authorizer = auth.NewClientAuthorizer(s.profile.AuthInfo.AuthUser, s.profile.AuthInfo.Password)
+	}
+	var authorizer sip.Authorizer
+	if s.authorizer != nil {
+		authorizer = s.authorizer
+	}
+	response, err := s.ua.RequestWithContext(s.ctx, request, authorizer, true, 1)
+	if cseq, ok := request.CSeq(); ok && uint(cseq.SeqNo) > s.cseq {
+		s.cseq = uint(cseq.SeqNo)
+	}
+	if err != nil {
+		s.ua.Log().Errorf("Request [%s] %s failed, err => %v", sip.SUBSCRIBE, s.event, err)
+		if reqErr, ok := err.(*sip.RequestError); ok && reqErr.Code == 481 && expires > 0 {
+			// The notifier lost the subscription.
+			s.newDialog()
+			return s.send(expires)
+		}
+		if reqErr, ok := err.(*sip.RequestError); ok && reqErr.Code == 423 && reqErr.Response != nil {
+			if minExpires := headerUint(reqErr.Response, "Min-Expires"); minExpires > expires {
+				return s.send(minExpires)
+			}
+		}
+		if len(s.toTag) == 0 {
+			s.state = SubscriptionTerminated
+			s.ua.subscriptions.Delete(s.callID)
+		}
+		return err
+	}
+
+	if len(s.toTag) == 0 {
+		if to, ok := response.To(); ok && to.Params != nil {
+			if tag, ok := to.Params.Get("tag"); ok && tag != nil {
+				s.toTag = tag.String()
+			}
+		}
+		if contact, ok := response.Contact(); ok {
+			s.remoteTarget = contact.Address
+		}
+		// The route set of the UAC is the Record-Route of the response, reversed.
+		s.routes = nil
+		for _, uri := range utils.RecordRoutes(response) {
+			s.routes = append([]sip.Uri{uri}, s.routes...)
+		}
+	}
+	s.expires = expires
+	if headers := response.GetHeaders("Expires"); len(headers) > 0 {
+		s.expires = uint32(*headers[0].(*sip.Expires))
+	}
+	s.schedule()
+	return nil
+}
+
+func (s *Subscription) buildRequest(expires uint32) (sip.Request, error) {
+	profile := s.profile
+	s.cseq++
+
+	builder := sip.NewRequestBuilder()
+	builder.SetMethod(sip.SUBSCRIBE)
+	builder.SetFrom(&sip.Address{
+		Uri:    profile.URI,
+		Params: sip.NewParams().Add("tag", sip.String{Str: s.fromTag}),
+	})
+	to := &sip.Address{Uri: s.target}
+	if len(s.toTag) > 0 {
+		to.Params = sip.NewParams().Add("tag", sip.String{Str: s.toTag})
+	}
+	builder.SetTo(to)
+	builder.SetContact(profile.Contact())
+	builder.SetCallID(&s.callID)
+	builder.SetSeqNo(s.cseq)
+	routes := profile.Routes
+	if s.remoteTarget != nil {
+		builder.SetRecipient(s.remoteTarget.Clone())
+		routes = s.routes
+	} else {
+		builder.SetRecipient(s.target.Clone())
+	}
+	builder.AddHeader(&sip.GenericHeader{HeaderName: "Event", Contents: s.event})
+	if len(s.accept) > 0 {
+		accept := sip.Accept(s.accept)
+		builder.SetAccept(&accept)
+	}
+	expiresHeader := sip.Expires(expires)
+	builder.SetExpires(&expiresHeader)
+	if userAgent := profile.UserAgentHeader(); userAgent != nil {
+		builder.SetUserAgent(userAgent)
+	}
+	request, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	utils.SetRouteSet(request, routes)
+	return request, nil
+}
+
+// schedule the refresh before the subscription expires, locked.
+func (s *Subscription) schedule() {
+	s.stopTimer()
+	if s.expires == 0 || s.unsubscribe {
+		return
+	}
+	refresh := time.Duration(s.expires) * time.Second / 2
+	if s.expires > 20 {
+		refresh = time.Duration(s.expires-10) * time.Second
+	}
+	s.timer = time.AfterFunc(refresh, s.refresh)
+}
+
+func (s *Subscription) refresh() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.ctx.Done():
+		return
+	default:
+	}
+	if s.state == SubscriptionTerminated {
+		return
+	}
+	expires := s.expires
+	if len(s.toTag) == 0 {
+		// Never established, start over.
+		s.newDialog()
+	}
+	s.send(expires)
+}
+
+// notify update the subscription from a NOTIFY of its dialog.
+func (s *Subscription) notify(request sip.Request) {
+	s.mutex.Lock()
+	if len(s.toTag) == 0 {
+		// The NOTIFY can overtake the 2xx of the SUBSCRIBE.
+		if from, ok := request.From(); ok && from.Params != nil {
+			if tag, ok := from.Params.Get("tag"); ok && tag != nil {
+				s.toTag = tag.String()
+			}
+		}
+		if contact, ok := request.Contact(); ok {
+			s.remoteTarget = contact.Address
+		}
+	}
+	state, params := parseSubscriptionState(request)
+	renew := false
+	switch state {
+	case SubscriptionActive, SubscriptionPending:
+		s.state = state
+		if value, ok := params["expires"]; ok {
+			if expires, err := strconv.ParseUint(value, 10, 32); err == nil && uint32(expires) < s.expires {
+				s.expires = uint32(expires)
+				s.schedule()
+			}
+		}
+	case SubscriptionTerminated:
+		s.state = SubscriptionTerminated
+		s.stopTimer()
+		reason := params["reason"]
+		renew = !s.unsubscribe && (reason == "" || reason == "deactivated" || reason == "timeout")
+	}
+	expires := s.expires
+	s.mutex.Unlock()
+
+	if s.handler != nil {
+		s.handler(s, request)
+	}
+	if renew {
+		s.ua.Log().Infof("Subscription %s terminated by the notifier, subscribing again", s.event)
+		go s.Subscribe(expires)
+	} else if state == SubscriptionTerminated {
+		s.ua.subscriptions.Delete(s.callID)
+	}
+}
+
+// parseSubscriptionState the state and the parameters of the Subscription-State header.
+func parseSubscriptionState(request sip.Request) (SubscriptionState, map[string]string) {
+	params := map[string]string{}
+	headers := request.GetHeaders("Subscription-State")
+	if len(headers) == 0 {
+		return SubscriptionActive, params
+	}
+	parts := strings.Split(headers[0].Value(), ";")
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		} else {
+			params[strings.ToLower(kv[0])] = ""
+		}
+	}
+	return SubscriptionState(strings.ToLower(strings.TrimSpace(parts[0]))), params
+}
+
+func (ua *UserAgent) handleNotify(request sip.Request, tx sip.ServerTransaction) {
+	ua.Log().Debugf("handleNotify: Request => %s, body => %s", request.Short(), request.Body())
+	var sub *Subscription
+	if callID, ok := request.CallID(); ok {
+		if v, found := ua.subscriptions.Load(*callID); found {
+			sub = v.(*Subscription)
+		}
+	}
+	if sub == nil {
+		tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 481, "Subscription Does Not Exist", ""))
+		return
+	}
+	tx.Respond(sip.NewResponseFromRequest(request.MessageID(), request, 200, "OK", ""))
+	sub.notify(request)
+}
+
+// Subscribe subscribe profile to event of target for expires seconds, refreshed until
+// Unsubscribe or Stop.
+func (ua *UserAgent) Subscribe(profile *account.Profile, target sip.Uri, event string, accept string, expires uint32, handler NotifyHandler) (*Subscription, error) {
+	sub := NewSubscription(ua, profile, target, event, accept, handler)
+	if err := sub.Subscribe(expires); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
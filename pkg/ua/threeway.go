@@ -0,0 +1,141 @@
+package ua
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cloudwebrtc/go-sip-ua/pkg/media"
+	"github.com/cloudwebrtc/go-sip-ua/pkg/session"
+)
+
+const (
+	// threeWayLocal id of the local user in the mixer.
+	threeWayLocal = "local"
+	// threeWaySampleRate of the mixed samples.
+	threeWaySampleRate = 8000
+)
+
+// ThreeWay a three-way call mixed by the UA, without a conference bridge: the local user and the remote
+// parties of two established calls hear each other. The application writes the audio of the local user and
+// the payloads received on the calls, and sends the frames of Mix every packetization time.
+// When one call ends, the other goes on as a two-party call.
+type ThreeWay struct {
+	mutex  sync.Mutex
+	calls  []*Call
+	codecs map[*Call]media.Codec
+	mixer  *media.Mixer
+}
+
+// ThreeWay join two established calls, e.g. a call and the consultation call of a transfer, the held
+// ones are resumed. Both must have negotiated an available 8 kHz codec, e.g. PCMU, PCMA or G.729.
+func (ua *UserAgent) ThreeWay(first *Call, second *Call) (*ThreeWay, error) {
+	if first == second {
+		return nil, fmt.Errorf("a three-way call needs two calls")
+	}
+	tw := &ThreeWay{
+		calls:  []*Call{first, second},
+		codecs: make(map[*Call]media.Codec),
+		mixer:  media.NewMixer(threeWayLocal),
+	}
+	for _, call := range tw.calls {
+		if !call.Session().IsEstablished() {
+			tw.close()
+			return nil, fmt.Errorf("call %s not established: %v", call.CallID(), call.State())
+		}
+		codec, _, err := media.NewCodecForSDP(call.RemoteSDP())
+		if err != nil {
+			tw.close()
+			return nil, fmt.Errorf("call %s: %v", call.CallID(), err)
+		}
+		tw.codecs[call] = codec
+		if codec.SampleRate() != threeWaySampleRate {
+			tw.close()
+			return nil, fmt.Errorf("call %s: %s can't be mixed, not an 8 kHz codec", call.CallID(), codec.Name())
+		}
+	}
+	for _, call := range tw.calls {
+		if call.Held() {
+			if err := call.Unhold(); err != nil {
+				tw.close()
+				return nil, err
+			}
+		}
+		tw.mixer.Add(call.CallID())
+		call.OnStateChange(func(call *Call, state session.Status) {
+			switch state {
+			case session.Failure, session.Canceled, session.Terminated:
+				tw.remove(call)
+			}
+		})
+	}
+	return tw, nil
+}
+
+// remove an ended call from the mix.
+func (tw *ThreeWay) remove(call *Call) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	tw.mixer.Remove(call.CallID())
+	if codec, found := tw.codecs[call]; found {
+		codec.Close()
+		delete(tw.codecs, call)
+	}
+}
+
+// close release the codecs.
+func (tw *ThreeWay) close() {
+	for _, call := range tw.calls {
+		tw.remove(call)
+	}
+}
+
+// Calls the two calls of the three-way call.
+func (tw *ThreeWay) Calls() []*Call {
+	return append([]*Call{}, tw.calls...)
+}
+
+// WriteLocal the next frame of 8 kHz linear samples of the local user, e.g. from the microphone.
+func (tw *ThreeWay) WriteLocal(samples []int16) {
+	tw.mixer.Write(threeWayLocal, samples)
+}
+
+// WriteRTP the payload of the next RTP packet received on call.
+func (tw *ThreeWay) WriteRTP(call *Call, payload []byte) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	codec, found := tw.codecs[call]
+	if !found {
+		return
+	}
+	if samples, err := codec.Decode(payload); err == nil {
+		tw.mixer.Write(call.CallID(), samples)
+	}
+}
+
+// Mix the next frames of samples, e.g. 160 for 20 ms: the linear samples for the local user, e.g. for the
+// speaker, and the payload to send on each call still up.
+func (tw *ThreeWay) Mix(samples int) ([]int16, map[*Call][]byte) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	frames := tw.mixer.Mix(samples)
+	remote := make(map[*Call][]byte, len(tw.calls))
+	for call, codec := range tw.codecs {
+		if frame, found := frames[call.CallID()]; found {
+			if payload, err := codec.Encode(frame); err == nil {
+				remote[call] = payload
+			}
+		}
+	}
+	return frames[threeWayLocal], remote
+}
+
+// Hangup end both calls.
+func (tw *ThreeWay) Hangup() error {
+	var err error
+	for _, call := range tw.calls {
+		if e := call.Hangup(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/cloudwebrtc/go-sip-ua/pkg/account"
@@ -44,12 +45,23 @@ type InviteSessionHandler func(s *session.Session, req *sip.Request, resp *sip.R
 //RegisterHandler .
 type RegisterHandler func(regState account.RegisterState)
 
+//PublishHandler .
+type PublishHandler func(state account.PublishState)
+
+//UnknownByeHandler called with the BYEs matching no session, once answered.
+type UnknownByeHandler func(req sip.Request)
+
 //UserAgent .
 type UserAgent struct {
 	InviteStateHandler   InviteSessionHandler
 	RegisterStateHandler RegisterHandler
+	PublishStateHandler  PublishHandler
+	UnknownByeHandler    UnknownByeHandler
+	IncomingCallHandler  IncomingCallHandler
 	config               *UserAgentConfig
 	iss                  sync.Map /*Invite Session*/
+	subscriptions        sync.Map /*Subscription by Call-ID*/
+	calls                sync.Map /*Call by Invite Session*/
 	log                  log.Logger
 }
 
@@ -68,6 +80,7 @@ func NewUserAgent(config *UserAgentConfig) *UserAgent {
 	stack.OnRequest(sip.BYE, ua.handleBye)
 	stack.OnRequest(sip.CANCEL, ua.handleCancel)
 	stack.OnRequest(sip.UPDATE, ua.handleUpdate)
+	stack.OnRequest(sip.NOTIFY, ua.handleNotify)
 	return ua
 }
 
@@ -93,6 +106,7 @@ func (ua *UserAgent) handleInviteState(is *session.Session, request *sip.Request
 	if ua.InviteStateHandler != nil {
 		ua.InviteStateHandler(is, request, response, state)
 	}
+	ua.updateCall(is, state)
 }
 
 func (ua *UserAgent) buildRequest(
@@ -102,6 +116,7 @@ func (ua *UserAgent) buildRequest(
 	contact *sip.Address,
 	recipient sip.SipUri,
 	routes []sip.Uri,
+	userAgent *sip.UserAgentHeader,
 	callID *sip.CallID) (*sip.Request, error) {
 
 	builder := sip.NewRequestBuilder()
@@ -112,19 +127,20 @@ func (ua *UserAgent) buildRequest(
 	builder.SetContact(contact)
 	builder.SetRecipient(recipient.Clone())
 
-	if len(routes) > 0 {
-		builder.SetRoutes(routes)
-	}
-
 	if callID != nil {
 		builder.SetCallID(callID)
 	}
 
+	if userAgent != nil {
+		builder.SetUserAgent(userAgent)
+	}
+
 	req, err := builder.Build()
 	if err != nil {
 		ua.Log().Errorf("err => %v", err)
 		return nil, err
 	}
+	utils.SetRouteSet(req, routes)
 
 	//ua.Log().Infof("buildRequest %s => \n%v", method, req)
 	return &req, nil
@@ -145,6 +161,12 @@ func (ua *UserAgent) Invite(profile *account.Profile, target sip.Uri, recipient
 }
 
 func (ua *UserAgent) InviteWithContext(ctx context.Context, profile *account.Profile, target sip.Uri, recipient sip.SipUri, body *string) (*session.Session, error) {
+	return ua.InviteWithHeaders(ctx, profile, target, recipient, body, "", nil)
+}
+
+// InviteWithHeaders send an INVITE with extra headers, contentType defaults to application/sdp. A Call-ID
+// among headers replaces the random one, e.g. to correlate the legs of a B2BUA.
+func (ua *UserAgent) InviteWithHeaders(ctx context.Context, profile *account.Profile, target sip.Uri, recipient sip.SipUri, body *string, contentType string, headers []sip.Header) (*session.Session, error) {
 
 	from := &sip.Address{
 		DisplayName: sip.String{Str: profile.DisplayName},
@@ -153,21 +175,46 @@ func (ua *UserAgent) InviteWithContext(ctx context.Context, profile *account.Pro
 	}
 
 	contact := profile.Contact()
+	if recipient.IsEncrypted() {
+		// The Contact of a sips: dialog is a sips: URI, RFC 5630.
+		contact.Uri = ua.secureContact(contact.Uri)
+	}
 
 	to := &sip.Address{
 		Uri: target,
 	}
 
-	request, err := ua.buildRequest(sip.INVITE, from, to, contact, recipient, profile.Routes, nil)
+	var callID *sip.CallID
+	extra := make([]sip.Header, 0, len(headers))
+	for _, header := range headers {
+		if id, ok := header.(*sip.CallID); ok {
+			callID = id
+			continue
+		}
+		extra = append(extra, header)
+	}
+
+	request, err := ua.buildRequest(sip.INVITE, from, to, contact, recipient, profile.Routes, profile.UserAgentHeader(), callID)
 	if err != nil {
 		ua.Log().Errorf("INVITE: err = %v", err)
 		return nil, err
 	}
 
+	for _, header := range profile.CapabilityHeaders() {
+		(*request).AppendHeader(header)
+	}
+
+	for _, header := range extra {
+		(*request).AppendHeader(header)
+	}
+
 	if body != nil {
 		(*request).SetBody(*body, true)
-		contentType := sip.ContentType("application/sdp")
-		(*request).AppendHeader(&contentType)
+		if len(contentType) == 0 {
+			contentType = "application/sdp"
+		}
+		ct := sip.ContentType(contentType)
+		(*request).AppendHeader(&ct)
 	}
 
 	var authorizer *auth.ClientAuthorizer = nil
@@ -240,6 +287,8 @@ func (ua *UserAgent) handleBye(request sip.Request, tx sip.ServerTransaction) {
 			ua.iss.Delete(NewSessionKey(*callID, branchID))
 			var transaction sip.Transaction = tx.(sip.Transaction)
 			ua.handleInviteState(is, &request, &response, session.Terminated, &transaction)
+		} else if ua.UnknownByeHandler != nil {
+			ua.UnknownByeHandler(request)
 		}
 	}
 }
@@ -304,6 +353,9 @@ func (ua *UserAgent) handleInvite(request sip.Request, tx sip.ServerTransaction)
 			} else {
 				contactHdr, _ := request.Contact()
 				contactAddr := ua.updateContact2UAAddr(request.Transport(), contactHdr.Address)
+				if request.Recipient().IsEncrypted() {
+					contactAddr = ua.secureContact(contactAddr).(sip.ContactUri)
+				}
 				contactHdr.Address = contactAddr
 
 				is := session.NewInviteSession(ua.RequestWithContext, "UAS", contactHdr, request, *callID, transaction, session.Incoming, ua.Log())
@@ -322,11 +374,17 @@ func (ua *UserAgent) handleInvite(request sip.Request, tx sip.ServerTransaction)
 			response := sip.NewResponseFromRequest(cancel.MessageID(), cancel, 200, "OK", "")
 			if callID, ok := response.CallID(); ok {
 				branchID := utils.GetBranchID(cancel)
-				if v, found := ua.iss.Load(NewSessionKey(*callID, branchID)); found {
-					ua.iss.Delete(NewSessionKey(*callID, branchID))
-					is := v.(*session.Session)
-					is.SetState(session.Canceled)
-					ua.handleInviteState(is, &request, &response, session.Canceled, nil)
+				// In order with the other messages of the dialog.
+				if !ua.config.SipStack.Serialize(callID.Value(), func() {
+					if v, found := ua.iss.Load(NewSessionKey(*callID, branchID)); found {
+						ua.iss.Delete(NewSessionKey(*callID, branchID))
+						is := v.(*session.Session)
+						is.SetState(session.Canceled)
+						ua.handleInviteState(is, &request, &response, session.Canceled, nil)
+					}
+				}) {
+					// Dropped with the mailbox of the dialog full, the peer may send the CANCEL again.
+					response = sip.NewResponseFromRequest(cancel.MessageID(), cancel, 503, "Service Unavailable", "")
 				}
 			}
 
@@ -363,6 +421,9 @@ func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request
 			if _, found := ua.iss.Load(NewSessionKey(*callID, branchID)); !found {
 				contactHdr, _ := request.Contact()
 				contactAddr := ua.updateContact2UAAddr(request.Transport(), contactHdr.Address)
+				if request.Recipient().IsEncrypted() {
+					contactAddr = ua.secureContact(contactAddr).(sip.ContactUri)
+				}
 				contactHdr.Address = contactAddr
 				is := session.NewInviteSession(ua.RequestWithContext, "UAC", contactHdr, request, *callID, cts, session.Outgoing, ua.Log())
 				ua.iss.Store(NewSessionKey(*callID, branchID), is)
@@ -486,6 +547,16 @@ func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request
 		}
 	}()
 
+	// dispatch run the session callbacks of the responses in order with the other messages of the dialog,
+	// unless the caller waits for the result, which may block the dialog.
+	dispatch := func(callID *sip.CallID, job func()) {
+		if waitForResult {
+			job()
+			return
+		}
+		s.Serialize(callID.Value(), job)
+	}
+
 	waitForResponse := func(cts *sip.Transaction) (sip.Response, error) {
 		for {
 			select {
@@ -493,16 +564,19 @@ func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request
 				callID, ok := provisional.CallID()
 				if ok {
 					branchID := utils.GetBranchID(provisional)
-					if v, found := ua.iss.Load(NewSessionKey(*callID, branchID)); found {
-						is := v.(*session.Session)
-						is.StoreResponse(provisional)
-						// handle Ringing or Processing with sdp
-						ua.handleInviteState(is, &request, &provisional, session.Provisional, cts)
-						if len(provisional.Body()) > 0 {
-							is.SetState(session.EarlyMedia)
-							ua.handleInviteState(is, &request, &provisional, session.EarlyMedia, cts)
+					dispatch(callID, func() {
+						// The provisional responses to a re-INVITE leave the session established.
+						if v, found := ua.iss.Load(NewSessionKey(*callID, branchID)); found && !v.(*session.Session).IsEstablished() {
+							is := v.(*session.Session)
+							is.StoreResponse(provisional)
+							// handle Ringing or Processing with sdp
+							ua.handleInviteState(is, &request, &provisional, session.Provisional, cts)
+							if len(provisional.Body()) > 0 {
+								is.SetState(session.EarlyMedia)
+								ua.handleInviteState(is, &request, &provisional, session.EarlyMedia, cts)
+							}
 						}
-					}
+					})
 				}
 			case err := <-errs:
 				//TODO: error type switch transaction.TxTimeoutError
@@ -516,30 +590,35 @@ func (ua *UserAgent) RequestWithContext(ctx context.Context, request sip.Request
 				callID, ok := request.CallID()
 				if ok {
 					branchID := utils.GetBranchID(request)
-					if v, found := ua.iss.Load(NewSessionKey(*callID, branchID)); found {
-						is := v.(*session.Session)
-						ua.iss.Delete(NewSessionKey(*callID, branchID))
-						is.SetState(session.Failure)
-						ua.handleInviteState(is, &request, &response, session.Failure, nil)
-					}
+					dispatch(callID, func() {
+						// A failed request of an established dialog, e.g. a re-INVITE or an INFO, but a BYE, leaves it established.
+						if v, found := ua.iss.Load(NewSessionKey(*callID, branchID)); found && (!v.(*session.Session).IsEstablished() || request.Method() == sip.BYE) {
+							is := v.(*session.Session)
+							ua.iss.Delete(NewSessionKey(*callID, branchID))
+							is.SetState(session.Failure)
+							ua.handleInviteState(is, &request, &response, session.Failure, nil)
+						}
+					})
 				}
 				return nil, err
 			case response := <-responses:
 				callID, ok := response.CallID()
 				if ok {
 					branchID := utils.GetBranchID(response)
-					if v, found := ua.iss.Load(NewSessionKey(*callID, branchID)); found {
-						if request.IsInvite() {
-							is := v.(*session.Session)
-							is.SetState(session.Confirmed)
-							ua.handleInviteState(is, &request, &response, session.Confirmed, nil)
-						} else if request.Method() == sip.BYE {
-							is := v.(*session.Session)
-							ua.iss.Delete(NewSessionKey(*callID, branchID))
-							is.SetState(session.Terminated)
-							ua.handleInviteState(is, &request, &response, session.Terminated, nil)
+					dispatch(callID, func() {
+						if v, found := ua.iss.Load(NewSessionKey(*callID, branchID)); found {
+							if request.IsInvite() {
+								is := v.(*session.Session)
+								is.SetState(session.Confirmed)
+								ua.handleInviteState(is, &request, &response, session.Confirmed, nil)
+							} else if request.Method() == sip.BYE {
+								is := v.(*session.Session)
+								ua.iss.Delete(NewSessionKey(*callID, branchID))
+								is.SetState(session.Terminated)
+								ua.handleInviteState(is, &request, &response, session.Terminated, nil)
+							}
 						}
-					}
+					})
 				}
 				return response, nil
 			}
@@ -557,10 +636,38 @@ func (ua *UserAgent) Shutdown() {
 	ua.config.SipStack.Shutdown()
 }
 
+// secureContact the sips: URI of the stack on TLS for a Contact URI.
+func (ua *UserAgent) secureContact(uri sip.Uri) sip.Uri {
+	stackAddr := ua.config.SipStack.GetNetworkInfo("tls")
+	ret := uri.Clone()
+	ret.SetEncrypted(true)
+	ret.SetHost(stackAddr.Host)
+	ret.SetPort(stackAddr.Port)
+	if params := ret.UriParams(); params != nil {
+		if transport, ok := params.Get("transport"); ok && transport != nil {
+			switch strings.ToLower(transport.String()) {
+			case "udp", "tcp", "tls":
+				ret.SetUriParams(params.Clone().Remove("transport"))
+			default:
+				// ws and wss, RFC 7118.
+				ret.SetPort(ua.config.SipStack.GetNetworkInfo(transport.String()).Port)
+			}
+		}
+	}
+	return ret
+}
+
 func (ua *UserAgent) updateContact2UAAddr(transport string, from sip.ContactUri) sip.ContactUri {
 	stackAddr := ua.config.SipStack.GetNetworkInfo(transport)
 	ret := from.Clone()
 	ret.SetHost(stackAddr.Host)
 	ret.SetPort(stackAddr.Port)
+	if instance := ua.config.SipStack.InstanceID(); len(instance) > 0 {
+		if params := ret.UriParams(); params != nil {
+			ret.SetUriParams(params.Clone().Add(stack.InstanceParam, sip.String{Str: instance}))
+		} else {
+			ret.SetUriParams(sip.NewParams().Add(stack.InstanceParam, sip.String{Str: instance}))
+		}
+	}
 	return ret
 }
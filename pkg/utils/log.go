@@ -9,8 +9,10 @@ import (
 )
 
 type MyLogger struct {
-	Logger *log.LogrusLogger
-	level  log.Level
+	Logger    *log.LogrusLogger
+	level     log.Level
+	logrus    *logrus.Logger
+	formatter logrus.Formatter
 }
 
 func (ml *MyLogger) Level() string {
@@ -56,8 +58,10 @@ func NewLogrusLogger(level log.Level, prefix string, fields log.Fields) log.Logg
 	l.SetReportCaller(true)
 	logger := log.NewLogrusLogger(l, "main", fields)
 	loggers[prefix] = &MyLogger{
-		Logger: logger,
-		level:  level,
+		Logger:    logger,
+		level:     level,
+		logrus:    l,
+		formatter: l.Formatter,
 	}
 	logger.SetLevel(level)
 	return logger.WithPrefix(prefix)
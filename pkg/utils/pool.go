@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"bytes"
+	"sync"
+)
+
+const (
+	// PacketSize of the pooled packet buffers, the Ethernet MTU.
+	PacketSize = 1500
+	// maxPooledBuffer the buffers grown over it, e.g. by a large MESSAGE, aren't pooled back.
+	maxPooledBuffer = 64 * 1024
+)
+
+var (
+	bufferPool = sync.Pool{
+		New: func() interface{} { return new(bytes.Buffer) },
+	}
+	packetPool = sync.Pool{
+		New: func() interface{} {
+			packet := make([]byte, PacketSize)
+			return &packet
+		},
+	}
+)
+
+// GetBuffer an empty buffer of the pool, e.g. to serialize a message or a session description, to be
+// returned with PutBuffer once its bytes are no more referred to.
+func GetBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// PutBuffer .
+func PutBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// GetPacket a PacketSize buffer of the pool, e.g. of the read loop of a media socket, to be returned with
+// PutPacket once its bytes are no more referred to.
+func GetPacket() *[]byte {
+	return packetPool.Get().(*[]byte)
+}
+
+// PutPacket .
+func PutPacket(packet *[]byte) {
+	if cap(*packet) < PacketSize {
+		return
+	}
+	*packet = (*packet)[:PacketSize]
+	packetPool.Put(packet)
+}
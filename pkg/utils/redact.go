@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	credentialsPattern = regexp.MustCompile(`(?im)^((?:Proxy-)?Authorization|(?:Proxy-)?Authentication-Info)[ \t]*:[ \t]*(\S+)[^\r\n]*`)
+	sipUserPattern     = regexp.MustCompile(`(?i)\b(sips?:)[^@;>\s"]+@`)
+	telPattern         = regexp.MustCompile(`(?i)\b(tel:)[^;>\s"]+`)
+	displayNamePattern = regexp.MustCompile(`"[^"\r\n]*"(\s*<)`)
+	sipStartPattern    = regexp.MustCompile(`(?m)(^[A-Z]+ \S+ SIP/2\.0\r?$|^SIP/2\.0 \d{3})`)
+)
+
+// Redaction the sensitive data masked in the logs and traces.
+type Redaction struct {
+	// Credentials the Authorization headers, but their scheme.
+	Credentials bool
+	// Bodies the bodies of the SIP messages, e.g. SDP, PIDF-LO.
+	Bodies bool
+	// Users the user parts of the SIP URIs, the tel URIs and the display names, for GDPR.
+	Users bool
+}
+
+// ParseRedaction a comma separated list of credentials, bodies, users, or all, nil if empty.
+func ParseRedaction(value string) (*Redaction, error) {
+	if len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+	r := &Redaction{}
+	for _, item := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(item)) {
+		case "credentials":
+			r.Credentials = true
+		case "bodies":
+			r.Bodies = true
+		case "users":
+			r.Users = true
+		case "all":
+			r.Credentials, r.Bodies, r.Users = true, true, true
+		default:
+			return nil, fmt.Errorf("unknown redaction %q", item)
+		}
+	}
+	return r, nil
+}
+
+// Redact mask the sensitive data of text, a SIP message or a log line.
+func (r *Redaction) Redact(text string) string {
+	if r == nil {
+		return text
+	}
+	if r.Bodies {
+		text = redactBody(text)
+	}
+	if r.Credentials {
+		text = credentialsPattern.ReplaceAllString(text, "$1: $2 [redacted]")
+	}
+	if r.Users {
+		text = sipUserPattern.ReplaceAllString(text, "$1***@")
+		text = telPattern.ReplaceAllString(text, "$1***")
+		text = displayNamePattern.ReplaceAllString(text, `"***"$1`)
+	}
+	return text
+}
+
+// redactBody replace the body of a SIP message in text by its length.
+func redactBody(text string) string {
+	start := sipStartPattern.FindStringIndex(text)
+	if start == nil {
+		return text
+	}
+	separator := "\r\n\r\n"
+	end := strings.Index(text[start[0]:], separator)
+	if end < 0 {
+		separator = "\n\n"
+		if end = strings.Index(text[start[0]:], separator); end < 0 {
+			return text
+		}
+	}
+	bodyStart := start[0] + end + len(separator)
+	if body := strings.TrimRight(text[bodyStart:], "\r\n"); len(body) > 0 {
+		return text[:bodyStart] + fmt.Sprintf("[%d bytes redacted]", len(body))
+	}
+	return text
+}
+
+// redactFormatter masks the messages and the string fields of the log entries.
+type redactFormatter struct {
+	logrus.Formatter
+	redaction *Redaction
+}
+
+func (f *redactFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	redacted := *entry
+	redacted.Message = f.redaction.Redact(entry.Message)
+	redacted.Data = make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		if s, ok := value.(string); ok {
+			value = f.redaction.Redact(s)
+		}
+		redacted.Data[key] = value
+	}
+	return f.Formatter.Format(&redacted)
+}
+
+// SetLogRedaction mask the sensitive data in the logs of the logger prefix, nil to disable.
+func SetLogRedaction(prefix string, redaction *Redaction) error {
+	logger, found := loggers[prefix]
+	if !found {
+		return fmt.Errorf("logger [%v] not found", prefix)
+	}
+	if redaction == nil {
+		logger.logrus.SetFormatter(logger.formatter)
+	} else {
+		logger.logrus.SetFormatter(&redactFormatter{Formatter: logger.formatter, redaction: redaction})
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/ghettovoice/gosip/sip"
+	"github.com/ghettovoice/gosip/sip/parser"
+)
+
+// ParseRoutes parse a chain of outbound proxies, in the order the requests traverse them, e.g.
+// "sip:sbc.example.com:5060". The proxies are loose routers, the lr parameter is added when missing.
+func ParseRoutes(uris ...string) ([]sip.Uri, error) {
+	routes := make([]sip.Uri, 0, len(uris))
+	for _, value := range uris {
+		uri, err := parser.ParseUri(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %q: %w", value, err)
+		}
+		if !IsLooseRouter(uri) {
+			uri.UriParams().Add("lr", nil)
+		}
+		routes = append(routes, uri)
+	}
+	return routes, nil
+}
+
+// IsLooseRouter the route has the lr parameter, https://tools.ietf.org/html/rfc3261#section-19.1.1
+func IsLooseRouter(route sip.Uri) bool {
+	return route.UriParams() != nil && route.UriParams().Has("lr")
+}
+
+// RecordRoutes the addresses of the Record-Route headers of msg, in order.
+func RecordRoutes(msg sip.Message) []sip.Uri {
+	routes := []sip.Uri{}
+	for _, header := range msg.GetHeaders("Record-Route") {
+		if recordRoute, ok := header.(*sip.RecordRouteHeader); ok {
+			for _, uri := range recordRoute.Addresses {
+				routes = append(routes, uri.Clone())
+			}
+		}
+	}
+	return routes
+}
+
+// SetRouteSet replace the Route headers of request with routes, https://tools.ietf.org/html/rfc3261#section-12.2.1.1
+// With a loose router first, the Request-URI is kept and the request is sent to the first route. With a strict
+// router first, it becomes the Request-URI, followed in the Route by the other routes and the remote target.
+func SetRouteSet(request sip.Request, routes []sip.Uri) {
+	request.RemoveHeader("Route")
+	if len(routes) == 0 {
+		return
+	}
+	addresses := make([]sip.Uri, 0, len(routes)+1)
+	if IsLooseRouter(routes[0]) {
+		for _, route := range routes {
+			addresses = append(addresses, route.Clone())
+		}
+	} else {
+		for _, route := range routes[1:] {
+			addresses = append(addresses, route.Clone())
+		}
+		addresses = append(addresses, request.Recipient().Clone())
+		request.SetRecipient(routes[0].Clone())
+		// The next hop is the strict router, not the first Route.
+		if uri, ok := routes[0].(*sip.SipUri); ok {
+			port := sip.DefaultPort(request.Transport())
+			if uri.FPort != nil {
+				port = *uri.FPort
+			}
+			request.SetDestination(fmt.Sprintf("%v:%v", uri.FHost, port))
+		}
+	}
+	request.PrependHeader(&sip.RouteHeader{Addresses: addresses})
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"syscall"
+)
+
+const (
+	// DSCPExpedited EF, the DSCP of the voice media, RFC 3246.
+	DSCPExpedited = 46
+	// DSCPSignaling CS3, the DSCP of the signaling, RFC 4594.
+	DSCPSignaling = 24
+)
+
+// DSCPToTOS the TOS byte of a DSCP, the ECN bits left unset.
+func DSCPToTOS(dscp int) int {
+	return dscp << 2
+}
+
+// SetTOS mark the packets of a socket with the TOS byte, e.g. DSCPToTOS(DSCPExpedited) for the RTP.
+func SetTOS(conn syscall.Conn, network string, tos int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return TOSControl(tos)(network, "", raw)
+}
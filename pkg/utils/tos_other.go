@@ -0,0 +1,16 @@
+// +build !linux,!darwin
+
+package utils
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// TOSControl .
+func TOSControl(tos int) func(network string, address string, conn syscall.RawConn) error {
+	return func(network string, address string, conn syscall.RawConn) error {
+		return fmt.Errorf("TOS marking is not supported on %s", runtime.GOOS)
+	}
+}
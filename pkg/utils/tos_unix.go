@@ -0,0 +1,36 @@
+// +build linux darwin
+
+package utils
+
+import (
+	"strings"
+	"syscall"
+)
+
+// TOSControl a net.ListenConfig or net.Dialer Control marking the packets of the sockets with the TOS byte,
+// the traffic class of the IPv6 ones.
+func TOSControl(tos int) func(network string, address string, conn syscall.RawConn) error {
+	return func(network string, address string, conn syscall.RawConn) error {
+		var serr error
+		err := conn.Control(func(fd uintptr) {
+			// A dual stack socket carries both, one of them is enough.
+			var errs []error
+			if !strings.HasSuffix(network, "6") {
+				errs = append(errs, syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos))
+			}
+			if !strings.HasSuffix(network, "4") {
+				errs = append(errs, syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos))
+			}
+			for _, e := range errs {
+				if e == nil {
+					return
+				}
+				serr = e
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+}
@@ -62,6 +62,35 @@ func AddParamsToContact(contact *sip.ContactHeader, expires *sip.Expires) {
 	}
 }
 
+// NewWarningHeader build a Warning header, https://tools.ietf.org/html/rfc3261#section-20.43
+func NewWarningHeader(code int, agent string, text string) sip.Header {
+	return &sip.GenericHeader{
+		HeaderName: "Warning",
+		Contents:   fmt.Sprintf(`%03d %s "%s"`, code, agent, strings.ReplaceAll(text, `"`, `\"`)),
+	}
+}
+
+// NewRetryAfterHeader build a Retry-After header, https://tools.ietf.org/html/rfc3261#section-20.33
+func NewRetryAfterHeader(seconds uint32) sip.Header {
+	return &sip.GenericHeader{
+		HeaderName: "Retry-After",
+		Contents:   fmt.Sprintf("%d", seconds),
+	}
+}
+
+// NewReasonHeader build a Reason header, https://tools.ietf.org/html/rfc3326
+// e.g. NewReasonHeader("Q.850", 16, "Normal call clearing")
+func NewReasonHeader(protocol string, cause int, text string) sip.Header {
+	contents := fmt.Sprintf("%s ;cause=%d", protocol, cause)
+	if len(text) > 0 {
+		contents += fmt.Sprintf(` ;text="%s"`, strings.ReplaceAll(text, `"`, `\"`))
+	}
+	return &sip.GenericHeader{
+		HeaderName: "Reason",
+		Contents:   contents,
+	}
+}
+
 func ListenUDPInPortRange(portMin, portMax int, laddr *net.UDPAddr) (*net.UDPConn, error) {
 	if (laddr.Port != 0) || ((portMin == 0) && (portMax == 0)) {
 		return net.ListenUDP("udp", laddr)
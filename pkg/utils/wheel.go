@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// wheelBits slots of a level, 64.
+	wheelBits  = 6
+	wheelSlots = 1 << wheelBits
+	wheelMask  = wheelSlots - 1
+	// wheelLevels 64^4 ticks, 194 days with a tick of a second, the longer timers wait on the last level.
+	wheelLevels = 4
+)
+
+// wheelTimer .
+type wheelTimer struct {
+	key     string
+	expires uint64
+	f       func()
+	slot    map[*wheelTimer]struct{}
+}
+
+// TimingWheel hierarchical timing wheel of the expirations by key, e.g. of the registrations and the
+// subscriptions: hundreds of thousands of timers cost a goroutine and a ticker instead of a runtime timer
+// each. The timers fire on the tick after their expiry, in the goroutine of the wheel, their functions
+// must not block.
+type TimingWheel struct {
+	mutex   sync.Mutex
+	tick    time.Duration
+	started time.Time
+	current uint64
+	levels  [wheelLevels][wheelSlots]map[*wheelTimer]struct{}
+	timers  map[string]*wheelTimer
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// NewTimingWheel tick the resolution of the timers, a second if 0.
+func NewTimingWheel(tick time.Duration) *TimingWheel {
+	if tick <= 0 {
+		tick = time.Second
+	}
+	w := &TimingWheel{
+		tick:    tick,
+		started: time.Now(),
+		timers:  make(map[string]*wheelTimer),
+		stop:    make(chan struct{}),
+	}
+	for level := range w.levels {
+		for slot := range w.levels[level] {
+			w.levels[level][slot] = make(map[*wheelTimer]struct{})
+		}
+	}
+	go w.run()
+	return w
+}
+
+// Schedule call f in after, replacing the timer of key.
+func (w *TimingWheel) Schedule(key string, after time.Duration, f func()) {
+	ticks := uint64((after + w.tick - 1) / w.tick)
+	if after <= 0 || ticks == 0 {
+		ticks = 1
+	}
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.remove(key)
+	timer := &wheelTimer{key: key, expires: w.current + ticks, f: f}
+	w.timers[key] = timer
+	w.add(timer)
+}
+
+// Cancel the timer of key, returns false if none.
+func (w *TimingWheel) Cancel(key string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.remove(key)
+}
+
+// Len timers scheduled.
+func (w *TimingWheel) Len() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.timers)
+}
+
+// Stop the wheel, the scheduled timers never fire.
+func (w *TimingWheel) Stop() {
+	w.once.Do(func() { close(w.stop) })
+}
+
+// add timer to the slot of its expiry on the lowest level covering it, locked.
+func (w *TimingWheel) add(timer *wheelTimer) {
+	delta := timer.expires - w.current
+	level := 0
+	for level < wheelLevels-1 && delta >= uint64(1)<<(wheelBits*(level+1)) {
+		level++
+	}
+	expires := timer.expires
+	if level == wheelLevels-1 && delta >= uint64(1)<<(wheelBits*wheelLevels) {
+		// Beyond the wheel, cascaded down again when its slot comes around.
+		expires = w.current + uint64(1)<<(wheelBits*wheelLevels) - 1
+	}
+	timer.slot = w.levels[level][(expires>>(wheelBits*level))&wheelMask]
+	timer.slot[timer] = struct{}{}
+}
+
+// remove the timer of key, locked.
+func (w *TimingWheel) remove(key string) bool {
+	timer, found := w.timers[key]
+	if !found {
+		return false
+	}
+	delete(timer.slot, timer)
+	delete(w.timers, key)
+	return true
+}
+
+func (w *TimingWheel) run() {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			// Catch up on the ticks missed while the functions ran.
+			for target := uint64(now.Sub(w.started) / w.tick); ; {
+				expired, more := w.advance(target)
+				for _, timer := range expired {
+					timer.f()
+				}
+				if !more {
+					break
+				}
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// advance the wheel by a tick up to target, returns the timers expired and whether target is still ahead.
+func (w *TimingWheel) advance(target uint64) ([]*wheelTimer, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.current >= target {
+		return nil, false
+	}
+	w.current++
+	// Cascade the slots of the upper levels coming around, from the highest.
+	for level := wheelLevels - 1; level > 0; level-- {
+		if w.current&(uint64(1)<<(wheelBits*level)-1) != 0 {
+			continue
+		}
+		slot := (w.current >> (wheelBits * level)) & wheelMask
+		timers := w.levels[level][slot]
+		w.levels[level][slot] = make(map[*wheelTimer]struct{})
+		for timer := range timers {
+			w.add(timer)
+		}
+	}
+	slot := w.levels[0][w.current&wheelMask]
+	expired := make([]*wheelTimer, 0, len(slot))
+	for timer := range slot {
+		delete(slot, timer)
+		delete(w.timers, timer.key)
+		expired = append(expired, timer)
+	}
+	return expired, w.current < target
+}